@@ -0,0 +1,175 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"io"
+
+	"google.golang.org/api/iterator"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+)
+
+// An ArrowRecordBatch is a batch of rows in the Apache Arrow columnar format.
+type ArrowRecordBatch struct {
+	// Data is the record batch, serialized as an encapsulated Arrow IPC
+	// message. It is decoded with the schema returned by
+	// ArrowIterator.SerializedArrowSchema.
+	Data []byte
+
+	// NumRows is the number of rows in the batch.
+	NumRows int64
+}
+
+// An ArrowIterator returns the results of a read as Arrow record batches.
+//
+// BigQuery columns are mapped to Arrow fields as follows:
+//
+//   STRING      utf8
+//   BOOL        bool
+//   INTEGER     int64
+//   FLOAT       float64
+//   BYTES       binary
+//   TIMESTAMP   timestamp[us, tz=UTC]
+//   DATE        date32
+//   TIME        time64[us]
+//   DATETIME    timestamp[us]
+//   NUMERIC     decimal128(38, 9)
+//   BIGNUMERIC  decimal256(76, 38)
+//   GEOGRAPHY   utf8, with the extension name "google:sqlType:geography"
+//   JSON        utf8, with the extension name "google:sqlType:json"
+//   INTERVAL    utf8, in the format of IntervalValue.String
+//
+// A RECORD column is a struct, and a repeated column is a list of its
+// elements.
+type ArrowIterator struct {
+	rows    *RowIterator   // the rows to encode, if not read in Arrow form
+	storage *storageReader // the Arrow read session, if any
+
+	schema           Schema
+	serializedSchema []byte
+}
+
+// ArrowIterator returns an iterator over the remaining results of it as Arrow
+// record batches. When the client has a Storage Read API client (see
+// Client.EnableStorageReadClient) and it has not started reading, the batches
+// are read in Arrow form through the Storage Read API. Otherwise, pages of
+// rows are read as usual and encoded by the client.
+//
+// Once ArrowIterator has been called, Next must not be called on it.
+func (it *RowIterator) ArrowIterator() (*ArrowIterator, error) {
+	if s := it.storage; s != nil && !s.started && it.pageInfo.Token == "" && it.StartIndex == 0 {
+		s.started = true
+		ok, err := s.start(it.ctx, it.src, it.Schema, storagepb.DataFormat_ARROW)
+		if err != nil {
+			return nil, err
+		}
+		s.useFallback = !ok
+		if ok {
+			it.Schema = s.schema
+			it.TotalRows = s.totalRows
+			return &ArrowIterator{
+				storage:          s,
+				schema:           s.schema,
+				serializedSchema: s.arrowSchema,
+			}, nil
+		}
+	}
+	// Fetch the first page, to learn the schema.
+	if err := it.nextFunc(); err != nil && err != iterator.Done {
+		return nil, err
+	}
+	return &ArrowIterator{
+		rows:             it,
+		schema:           it.Schema,
+		serializedSchema: arrowSchemaMessage(it.Schema),
+	}, nil
+}
+
+// Next returns the next record batch. Its second return value is
+// iterator.Done if there are no more results. Once Next returns Done, all
+// subsequent calls will return Done.
+func (ai *ArrowIterator) Next() (*ArrowRecordBatch, error) {
+	if ai.storage != nil {
+		b, ok := <-ai.storage.batches
+		if !ok {
+			if err := ai.storage.err; err != nil {
+				return nil, err
+			}
+			return nil, iterator.Done
+		}
+		return b, nil
+	}
+	if err := ai.rows.nextFunc(); err != nil {
+		return nil, err
+	}
+	rows := ai.rows.rows
+	ai.rows.rows = nil
+	data, err := arrowRecordBatchMessage(ai.schema, rows)
+	if err != nil {
+		return nil, err
+	}
+	return &ArrowRecordBatch{Data: data, NumRows: int64(len(rows))}, nil
+}
+
+// Schema returns the BigQuery schema of the results.
+func (ai *ArrowIterator) Schema() Schema {
+	return ai.schema
+}
+
+// SerializedArrowSchema returns the Arrow schema of the results, serialized
+// as an encapsulated Arrow IPC message.
+func (ai *ArrowIterator) SerializedArrowSchema() []byte {
+	return ai.serializedSchema
+}
+
+// NewArrowIteratorReader returns a reader of an Arrow IPC stream made of the
+// schema and record batches of it. The stream can be read by the IPC stream
+// reader of an Arrow implementation; in Go, ipc.NewReader in the
+// github.com/apache/arrow/go module returns an array.RecordReader for it.
+func NewArrowIteratorReader(it *ArrowIterator) io.Reader {
+	return &arrowIteratorReader{it: it}
+}
+
+type arrowIteratorReader struct {
+	it      *ArrowIterator
+	buf     []byte
+	started bool
+	done    bool
+}
+
+func (r *arrowIteratorReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		switch {
+		case r.done:
+			return 0, io.EOF
+		case !r.started:
+			r.started = true
+			r.buf = r.it.SerializedArrowSchema()
+		default:
+			b, err := r.it.Next()
+			if err == iterator.Done {
+				r.done = true
+				r.buf = arrowEOS
+			} else if err != nil {
+				return 0, err
+			} else {
+				r.buf = b.Data
+			}
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
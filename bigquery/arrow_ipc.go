@@ -0,0 +1,602 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+// This file encodes schemas and rows as Arrow IPC messages, as described in
+// https://arrow.apache.org/docs/format/Columnar.html. The message metadata
+// are FlatBuffers defined by Message.fbs and Schema.fbs in the Arrow
+// repository.
+
+// fbBuilder writes a FlatBuffer front to back. Since offsets must point
+// forward, objects are written after the tables that refer to them.
+type fbBuilder struct {
+	buf []byte
+}
+
+// fbTable is a FlatBuffer table to be written by an fbBuilder. Its fields are
+// indexed by field ID; a field with neither a value nor a ref is absent.
+type fbTable struct {
+	fields []fbField
+}
+
+type fbField struct {
+	value []byte               // an inline scalar
+	ref   func(*fbBuilder) int // writes a referenced object and returns its position
+}
+
+func newFBTable(numFields int) *fbTable {
+	return &fbTable{fields: make([]fbField, numFields)}
+}
+
+func (t *fbTable) setInt16(id int, v int16) {
+	t.fields[id].value = appendUint16(nil, uint16(v))
+}
+
+func (t *fbTable) setInt32(id int, v int32) {
+	t.fields[id].value = appendUint32(nil, uint32(v))
+}
+
+func (t *fbTable) setInt64(id int, v int64) {
+	t.fields[id].value = appendUint64(nil, uint64(v))
+}
+
+func (t *fbTable) setUint8(id int, v uint8) {
+	t.fields[id].value = []byte{v}
+}
+
+func (t *fbTable) setBool(id int, v bool) {
+	if v {
+		t.setUint8(id, 1)
+	} else {
+		t.setUint8(id, 0)
+	}
+}
+
+func (t *fbTable) setTable(id int, sub *fbTable) {
+	t.fields[id].ref = func(b *fbBuilder) int { return b.table(sub) }
+}
+
+func (t *fbTable) setString(id int, s string) {
+	t.fields[id].ref = func(b *fbBuilder) int { return b.string(s) }
+}
+
+func (t *fbTable) setTables(id int, subs []*fbTable) {
+	t.fields[id].ref = func(b *fbBuilder) int { return b.tableVector(subs) }
+}
+
+// setInt64Pairs sets a vector of structs made of two longs, such as the
+// FieldNode and Buffer structs of a RecordBatch.
+func (t *fbTable) setInt64Pairs(id int, pairs [][2]int64) {
+	t.fields[id].ref = func(b *fbBuilder) int { return b.int64PairVector(pairs) }
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) putUint32(pos int, v uint32) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], v)
+}
+
+// patch sets the offset at pos to refer to target.
+func (b *fbBuilder) patch(pos, target int) {
+	b.putUint32(pos, uint32(target-pos))
+}
+
+// finish writes the root table, and returns the buffer padded to a multiple
+// of 8 bytes.
+func (b *fbBuilder) finish(root *fbTable) []byte {
+	b.buf = make([]byte, 4)
+	b.patch(0, b.table(root))
+	b.pad(8)
+	return b.buf
+}
+
+func (b *fbBuilder) table(t *fbTable) int {
+	// Lay out the fields after the table's vtable offset, largest first so
+	// that each is aligned.
+	offsets := make([]uint16, len(t.fields))
+	size := 4
+	for _, width := range []int{8, 4, 2, 1} {
+		for i, f := range t.fields {
+			w := len(f.value)
+			if f.ref != nil {
+				w = 4
+			}
+			if w != width {
+				continue
+			}
+			for size%width != 0 {
+				size++
+			}
+			offsets[i] = uint16(size)
+			size += width
+		}
+	}
+	b.pad(2)
+	vtable := len(b.buf)
+	b.buf = appendUint16(b.buf, uint16(4+2*len(offsets)))
+	b.buf = appendUint16(b.buf, uint16(size))
+	for _, off := range offsets {
+		b.buf = appendUint16(b.buf, off)
+	}
+	b.pad(8)
+	start := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	b.putUint32(start, uint32(int32(start-vtable)))
+	for i, f := range t.fields {
+		copy(b.buf[start+int(offsets[i]):], f.value)
+	}
+	for i, f := range t.fields {
+		if f.ref != nil {
+			pos := start + int(offsets[i])
+			b.patch(pos, f.ref(b))
+		}
+	}
+	return start
+}
+
+func (b *fbBuilder) string(s string) int {
+	b.pad(4)
+	start := len(b.buf)
+	b.buf = appendUint32(b.buf, uint32(len(s)))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return start
+}
+
+func (b *fbBuilder) tableVector(ts []*fbTable) int {
+	b.pad(4)
+	start := len(b.buf)
+	b.buf = appendUint32(b.buf, uint32(len(ts)))
+	b.buf = append(b.buf, make([]byte, 4*len(ts))...)
+	for i, t := range ts {
+		pos := start + 4 + 4*i
+		b.patch(pos, b.table(t))
+	}
+	return start
+}
+
+func (b *fbBuilder) int64PairVector(pairs [][2]int64) int {
+	// The elements must be 8-byte aligned.
+	for len(b.buf)%8 != 4 {
+		b.buf = append(b.buf, 0)
+	}
+	start := len(b.buf)
+	b.buf = appendUint32(b.buf, uint32(len(pairs)))
+	for _, p := range pairs {
+		b.buf = appendUint64(b.buf, uint64(p[0]))
+		b.buf = appendUint64(b.buf, uint64(p[1]))
+	}
+	return start
+}
+
+// Values of the Arrow MessageHeader and Type unions, and other enums.
+const (
+	arrowHeaderSchema      = 1
+	arrowHeaderRecordBatch = 3
+
+	arrowTypeInt           = 2
+	arrowTypeFloatingPoint = 3
+	arrowTypeBinary        = 4
+	arrowTypeUtf8          = 5
+	arrowTypeBool          = 6
+	arrowTypeDecimal       = 7
+	arrowTypeDate          = 8
+	arrowTypeTime          = 9
+	arrowTypeTimestamp     = 10
+	arrowTypeList          = 12
+	arrowTypeStruct        = 13
+
+	arrowMetadataV5       = 4
+	arrowPrecisionDouble  = 2
+	arrowDateUnitDay      = 0
+	arrowTimeUnitMicrosec = 2
+)
+
+// Arrow extension type names of BigQuery types that are passed as strings.
+const (
+	arrowGeographyExtension = "google:sqlType:geography"
+	arrowJSONExtension      = "google:sqlType:json"
+)
+
+// arrowEOS marks the end of an Arrow IPC stream.
+var arrowEOS = []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}
+
+// arrowMessage returns an encapsulated Arrow IPC message.
+func arrowMessage(headerType uint8, header *fbTable, body []byte) []byte {
+	m := newFBTable(5)
+	m.setInt16(0, arrowMetadataV5)
+	m.setUint8(1, headerType)
+	m.setTable(2, header)
+	m.setInt64(3, int64(len(body)))
+	meta := (&fbBuilder{}).finish(m)
+	msg := []byte{0xff, 0xff, 0xff, 0xff}
+	msg = appendUint32(msg, uint32(len(meta)))
+	msg = append(msg, meta...)
+	return append(msg, body...)
+}
+
+// arrowSchemaMessage returns the Arrow IPC message for a schema.
+func arrowSchemaMessage(schema Schema) []byte {
+	s := newFBTable(4)
+	s.setInt16(0, 0) // little-endian
+	s.setTables(1, arrowFields(schema))
+	return arrowMessage(arrowHeaderSchema, s, nil)
+}
+
+func arrowFields(schema Schema) []*fbTable {
+	fields := make([]*fbTable, 0, len(schema))
+	for _, fs := range schema {
+		fields = append(fields, arrowField(fs))
+	}
+	return fields
+}
+
+// arrowField returns the Arrow field for a column. A repeated column is a
+// list of its elements.
+func arrowField(fs *FieldSchema) *fbTable {
+	f := newFBTable(7)
+	f.setString(0, fs.Name)
+	if fs.Repeated {
+		elem := *fs
+		elem.Name = "item"
+		elem.Repeated = false
+		elem.Required = true
+		f.setBool(1, false)
+		f.setUint8(2, arrowTypeList)
+		f.setTable(3, newFBTable(0))
+		f.setTables(5, []*fbTable{arrowField(&elem)})
+		return f
+	}
+	f.setBool(1, !fs.Required)
+	typ := newFBTable(3)
+	var children []*fbTable
+	switch fs.Type {
+	case IntegerFieldType:
+		typ.setInt32(0, 64)
+		typ.setBool(1, true)
+		f.setUint8(2, arrowTypeInt)
+	case FloatFieldType:
+		typ.setInt16(0, arrowPrecisionDouble)
+		f.setUint8(2, arrowTypeFloatingPoint)
+	case BooleanFieldType:
+		f.setUint8(2, arrowTypeBool)
+	case StringFieldType:
+		f.setUint8(2, arrowTypeUtf8)
+	case GeographyFieldType, JSONFieldType:
+		ext := arrowGeographyExtension
+		if fs.Type == JSONFieldType {
+			ext = arrowJSONExtension
+		}
+		f.setUint8(2, arrowTypeUtf8)
+		kv := newFBTable(2)
+		kv.setString(0, "ARROW:extension:name")
+		kv.setString(1, ext)
+		f.setTables(6, []*fbTable{kv})
+	case BytesFieldType:
+		f.setUint8(2, arrowTypeBinary)
+	case NumericFieldType:
+		typ.setInt32(0, 38)
+		typ.setInt32(1, 9)
+		typ.setInt32(2, 128)
+		f.setUint8(2, arrowTypeDecimal)
+	case BigNumericFieldType:
+		typ.setInt32(0, 76)
+		typ.setInt32(1, 38)
+		typ.setInt32(2, 256)
+		f.setUint8(2, arrowTypeDecimal)
+	case DateFieldType:
+		typ.setInt16(0, arrowDateUnitDay)
+		f.setUint8(2, arrowTypeDate)
+	case TimeFieldType:
+		typ.setInt16(0, arrowTimeUnitMicrosec)
+		typ.setInt32(1, 64)
+		f.setUint8(2, arrowTypeTime)
+	case TimestampFieldType:
+		typ.setInt16(0, arrowTimeUnitMicrosec)
+		typ.setString(1, "UTC")
+		f.setUint8(2, arrowTypeTimestamp)
+	case DateTimeFieldType:
+		// A timestamp without a time zone.
+		typ.setInt16(0, arrowTimeUnitMicrosec)
+		f.setUint8(2, arrowTypeTimestamp)
+	case RecordFieldType:
+		f.setUint8(2, arrowTypeStruct)
+		children = arrowFields(fs.Schema)
+	default:
+		// Unknown types are passed as strings.
+		f.setUint8(2, arrowTypeUtf8)
+	}
+	f.setTable(3, typ)
+	// Readers require the children, even if there are none.
+	f.setTables(5, children)
+	return f
+}
+
+// arrowBody accumulates the field nodes and buffers of a record batch.
+type arrowBody struct {
+	nodes   [][2]int64
+	buffers [][2]int64
+	data    []byte
+}
+
+func (b *arrowBody) node(length, nulls int) {
+	b.nodes = append(b.nodes, [2]int64{int64(length), int64(nulls)})
+}
+
+func (b *arrowBody) buffer(p []byte) {
+	b.buffers = append(b.buffers, [2]int64{int64(len(b.data)), int64(len(p))})
+	b.data = append(b.data, p...)
+	for len(b.data)%8 != 0 {
+		b.data = append(b.data, 0)
+	}
+}
+
+// bitmap appends a buffer with a bit set for each true value.
+func (b *arrowBody) bitmap(bits []bool) {
+	p := make([]byte, (len(bits)+7)/8)
+	for i, set := range bits {
+		if set {
+			p[i/8] |= 1 << uint(i%8)
+		}
+	}
+	b.buffer(p)
+}
+
+// arrowRecordBatchMessage returns the Arrow IPC message for rows with the
+// given schema.
+func arrowRecordBatchMessage(schema Schema, rows [][]Value) ([]byte, error) {
+	body := &arrowBody{}
+	for i, fs := range schema {
+		col := make([]Value, len(rows))
+		for j, row := range rows {
+			if i >= len(row) {
+				return nil, fmt.Errorf("bigquery: row %d has %d values, want %d", j, len(row), len(schema))
+			}
+			col[j] = row[i]
+		}
+		if err := body.column(fs, col); err != nil {
+			return nil, err
+		}
+	}
+	rb := newFBTable(4)
+	rb.setInt64(0, int64(len(rows)))
+	rb.setInt64Pairs(1, body.nodes)
+	rb.setInt64Pairs(2, body.buffers)
+	return arrowMessage(arrowHeaderRecordBatch, rb, body.data), nil
+}
+
+// column appends the nodes and buffers of a column.
+func (b *arrowBody) column(fs *FieldSchema, vals []Value) error {
+	if fs.Repeated {
+		b.node(len(vals), 0)
+		b.buffer(nil)
+		var elems []Value
+		offsets := []int32{0}
+		for _, v := range vals {
+			if v != nil {
+				list, ok := v.([]Value)
+				if !ok {
+					return fmt.Errorf("bigquery: got %T for repeated field %s", v, fs.Name)
+				}
+				elems = append(elems, list...)
+			}
+			offsets = append(offsets, int32(len(elems)))
+		}
+		b.int32s(offsets)
+		elem := *fs
+		elem.Repeated = false
+		return b.column(&elem, elems)
+	}
+
+	valid := make([]bool, len(vals))
+	nulls := 0
+	for i, v := range vals {
+		valid[i] = v != nil
+		if v == nil {
+			nulls++
+		}
+	}
+	b.node(len(vals), nulls)
+	if nulls > 0 {
+		b.bitmap(valid)
+	} else {
+		b.buffer(nil)
+	}
+
+	switch fs.Type {
+	case RecordFieldType:
+		for i, sub := range fs.Schema {
+			col := make([]Value, len(vals))
+			for j, v := range vals {
+				if v == nil {
+					continue
+				}
+				rec, ok := v.([]Value)
+				if !ok || i >= len(rec) {
+					return fmt.Errorf("bigquery: got %v for record field %s", v, fs.Name)
+				}
+				col[j] = rec[i]
+			}
+			if err := b.column(sub, col); err != nil {
+				return err
+			}
+		}
+		return nil
+	case BooleanFieldType:
+		bits := make([]bool, len(vals))
+		for i, v := range vals {
+			if v == nil {
+				continue
+			}
+			x, ok := v.(bool)
+			if !ok {
+				return arrowTypeError(fs, v)
+			}
+			bits[i] = x
+		}
+		b.bitmap(bits)
+		return nil
+	case StringFieldType, GeographyFieldType, BytesFieldType, JSONFieldType, IntervalFieldType:
+		offsets := []int32{0}
+		var data []byte
+		for _, v := range vals {
+			switch x := v.(type) {
+			case nil:
+			case string:
+				data = append(data, x...)
+			case []byte:
+				data = append(data, x...)
+			case json.RawMessage:
+				data = append(data, x...)
+			case *IntervalValue:
+				if x != nil {
+					data = append(data, x.String()...)
+				}
+			default:
+				return arrowTypeError(fs, v)
+			}
+			offsets = append(offsets, int32(len(data)))
+		}
+		b.int32s(offsets)
+		b.buffer(data)
+		return nil
+	case NumericFieldType, BigNumericFieldType:
+		width, scale := 16, 9
+		if fs.Type == BigNumericFieldType {
+			width, scale = 32, 38
+		}
+		data := make([]byte, 0, width*len(vals))
+		for _, v := range vals {
+			var r *big.Rat
+			if v != nil {
+				var ok bool
+				if r, ok = v.(*big.Rat); !ok {
+					return arrowTypeError(fs, v)
+				}
+			}
+			d, err := arrowDecimal(r, scale, width)
+			if err != nil {
+				return fmt.Errorf("bigquery: field %s: %v", fs.Name, err)
+			}
+			data = append(data, d...)
+		}
+		b.buffer(data)
+		return nil
+	case DateFieldType:
+		data := make([]byte, 0, 4*len(vals))
+		for _, v := range vals {
+			var days int32
+			if v != nil {
+				d, ok := v.(civil.Date)
+				if !ok {
+					return arrowTypeError(fs, v)
+				}
+				days = int32(d.In(time.UTC).Unix() / (24 * 60 * 60))
+			}
+			data = appendUint32(data, uint32(days))
+		}
+		b.buffer(data)
+		return nil
+	default:
+		// The remaining types have 64-bit values.
+		data := make([]byte, 0, 8*len(vals))
+		for _, v := range vals {
+			var n uint64
+			switch x := v.(type) {
+			case nil:
+			case int64:
+				n = uint64(x)
+			case float64:
+				n = math.Float64bits(x)
+			case time.Time:
+				n = uint64(x.Unix()*1e6 + int64(x.Nanosecond()/1e3))
+			case civil.DateTime:
+				t := x.In(time.UTC)
+				n = uint64(t.Unix()*1e6 + int64(t.Nanosecond()/1e3))
+			case civil.Time:
+				n = uint64((int64(x.Hour)*60*60+int64(x.Minute)*60+int64(x.Second))*1e6 + int64(x.Nanosecond/1e3))
+			default:
+				return arrowTypeError(fs, v)
+			}
+			data = appendUint64(data, n)
+		}
+		b.buffer(data)
+		return nil
+	}
+}
+
+func (b *arrowBody) int32s(vals []int32) {
+	p := make([]byte, 0, 4*len(vals))
+	for _, v := range vals {
+		p = appendUint32(p, uint32(v))
+	}
+	b.buffer(p)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
+
+func arrowTypeError(fs *FieldSchema, v Value) error {
+	return fmt.Errorf("bigquery: got %T for field %s of type %s", v, fs.Name, fs.Type)
+}
+
+// arrowDecimal returns r with the given scale as a little-endian two's
+// complement integer of width bytes. A nil r is encoded as zero.
+func arrowDecimal(r *big.Rat, scale, width int) ([]byte, error) {
+	out := make([]byte, width)
+	if r == nil {
+		return out, nil
+	}
+	n := new(big.Int).Mul(r.Num(), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+	n, m := n.QuoRem(n, r.Denom(), new(big.Int))
+	if m.Sign() != 0 {
+		return nil, fmt.Errorf("%s has more than %d digits after the decimal point", r.FloatString(scale+1), scale)
+	}
+	if n.BitLen() >= 8*width {
+		return nil, fmt.Errorf("%s is out of range", r.FloatString(scale))
+	}
+	if n.Sign() < 0 {
+		n.Add(n, new(big.Int).Lsh(big.NewInt(1), uint(8*width)))
+	}
+	be := n.Bytes()
+	for i, c := range be {
+		out[len(be)-1-i] = c
+	}
+	return out, nil
+}
@@ -0,0 +1,401 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/internal/testutil"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+)
+
+// fbTableReader reads a table of a FlatBuffer, independently of fbBuilder.
+type fbTableReader struct {
+	buf []byte
+	pos int
+}
+
+func fbRoot(buf []byte) fbTableReader {
+	return fbTableReader{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+// field returns the position of a field, or zero if it is absent.
+func (t fbTableReader) field(id int) int {
+	vt := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if 4+2*id >= int(binary.LittleEndian.Uint16(t.buf[vt:])) {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(t.buf[vt+4+2*id:]))
+	if off == 0 {
+		return 0
+	}
+	return t.pos + off
+}
+
+func (t fbTableReader) uint8(id int) uint8 {
+	if p := t.field(id); p != 0 {
+		return t.buf[p]
+	}
+	return 0
+}
+
+func (t fbTableReader) int16(id int) int16 {
+	if p := t.field(id); p != 0 {
+		return int16(binary.LittleEndian.Uint16(t.buf[p:]))
+	}
+	return 0
+}
+
+func (t fbTableReader) int32(id int) int32 {
+	if p := t.field(id); p != 0 {
+		return int32(binary.LittleEndian.Uint32(t.buf[p:]))
+	}
+	return 0
+}
+
+func (t fbTableReader) int64(id int) int64 {
+	if p := t.field(id); p != 0 {
+		return int64(binary.LittleEndian.Uint64(t.buf[p:]))
+	}
+	return 0
+}
+
+func (t fbTableReader) deref(id int) int {
+	p := t.field(id)
+	if p == 0 {
+		return 0
+	}
+	return p + int(binary.LittleEndian.Uint32(t.buf[p:]))
+}
+
+func (t fbTableReader) table(id int) fbTableReader {
+	return fbTableReader{t.buf, t.deref(id)}
+}
+
+func (t fbTableReader) string(id int) string {
+	p := t.deref(id)
+	if p == 0 {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint32(t.buf[p:]))
+	return string(t.buf[p+4 : p+4+n])
+}
+
+// tables returns the elements of a vector of tables, or nil if the vector is
+// absent.
+func (t fbTableReader) tables(id int) []fbTableReader {
+	p := t.deref(id)
+	if p == 0 {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(t.buf[p:]))
+	ts := []fbTableReader{}
+	for i := 0; i < n; i++ {
+		e := p + 4 + 4*i
+		ts = append(ts, fbTableReader{t.buf, e + int(binary.LittleEndian.Uint32(t.buf[e:]))})
+	}
+	return ts
+}
+
+func (t fbTableReader) int64Pairs(id int) [][2]int64 {
+	p := t.deref(id)
+	n := int(binary.LittleEndian.Uint32(t.buf[p:]))
+	if (p+4)%8 != 0 {
+		panic("unaligned struct vector")
+	}
+	var pairs [][2]int64
+	for i := 0; i < n; i++ {
+		e := p + 4 + 16*i
+		pairs = append(pairs, [2]int64{int64(binary.LittleEndian.Uint64(t.buf[e:])), int64(binary.LittleEndian.Uint64(t.buf[e+8:]))})
+	}
+	return pairs
+}
+
+// readArrowMessage splits an encapsulated message into its Message table and
+// body, and returns the rest of the input.
+func readArrowMessage(t *testing.T, data []byte) (fbTableReader, []byte, []byte) {
+	t.Helper()
+	if len(data) < 8 || binary.LittleEndian.Uint32(data) != 0xffffffff {
+		t.Fatalf("message does not start with a continuation marker: % x", data[:8])
+	}
+	n := int(binary.LittleEndian.Uint32(data[4:]))
+	if n%8 != 0 {
+		t.Fatalf("metadata size %d is not a multiple of 8", n)
+	}
+	m := fbRoot(data[8 : 8+n])
+	if v := m.int16(0); v != arrowMetadataV5 {
+		t.Fatalf("got metadata version %d", v)
+	}
+	body := int(m.int64(3))
+	return m, data[8+n : 8+n+body], data[8+n+body:]
+}
+
+var testArrowSchema = Schema{
+	{Name: "s", Type: StringFieldType},
+	{Name: "i", Type: IntegerFieldType, Required: true},
+	{Name: "n", Type: NumericFieldType},
+	{Name: "bn", Type: BigNumericFieldType},
+	{Name: "ts", Type: TimestampFieldType},
+	{Name: "dt", Type: DateTimeFieldType},
+	{Name: "g", Type: GeographyFieldType},
+	{Name: "r", Type: IntegerFieldType, Repeated: true},
+	{Name: "rec", Type: RecordFieldType, Schema: Schema{
+		{Name: "b", Type: BooleanFieldType},
+		{Name: "d", Type: DateFieldType},
+		{Name: "t", Type: TimeFieldType},
+	}},
+}
+
+func TestArrowSchemaMessage(t *testing.T) {
+	m, body, rest := readArrowMessage(t, arrowSchemaMessage(testArrowSchema))
+	if len(body) != 0 || len(rest) != 0 {
+		t.Fatalf("got %d body bytes and %d trailing bytes", len(body), len(rest))
+	}
+	if m.uint8(1) != arrowHeaderSchema {
+		t.Fatalf("got header type %d", m.uint8(1))
+	}
+	fields := m.table(2).tables(1)
+	type field struct {
+		name     string
+		nullable bool
+		typ      uint8
+		params   []int64
+		children []string
+		metadata string
+	}
+	var got []field
+	for _, f := range fields {
+		typ := f.table(3)
+		var params []int64
+		switch f.uint8(2) {
+		case arrowTypeInt:
+			params = []int64{int64(typ.int32(0)), int64(typ.uint8(1))}
+		case arrowTypeDecimal:
+			params = []int64{int64(typ.int32(0)), int64(typ.int32(1)), int64(typ.int32(2))}
+		case arrowTypeTimestamp:
+			params = []int64{int64(typ.int16(0)), int64(len(typ.string(1)))}
+		}
+		var children []string
+		for _, c := range f.tables(5) {
+			children = append(children, c.string(0))
+		}
+		var metadata string
+		for _, kv := range f.tables(6) {
+			metadata += kv.string(0) + "=" + kv.string(1)
+		}
+		got = append(got, field{f.string(0), f.uint8(1) == 1, f.uint8(2), params, children, metadata})
+	}
+	want := []field{
+		{name: "s", nullable: true, typ: arrowTypeUtf8},
+		{name: "i", typ: arrowTypeInt, params: []int64{64, 1}},
+		{name: "n", nullable: true, typ: arrowTypeDecimal, params: []int64{38, 9, 128}},
+		{name: "bn", nullable: true, typ: arrowTypeDecimal, params: []int64{76, 38, 256}},
+		{name: "ts", nullable: true, typ: arrowTypeTimestamp, params: []int64{arrowTimeUnitMicrosec, 3}},
+		{name: "dt", nullable: true, typ: arrowTypeTimestamp, params: []int64{arrowTimeUnitMicrosec, 0}},
+		{name: "g", nullable: true, typ: arrowTypeUtf8, metadata: "ARROW:extension:name=" + arrowGeographyExtension},
+		{name: "r", typ: arrowTypeList, children: []string{"item"}},
+		{name: "rec", nullable: true, typ: arrowTypeStruct, children: []string{"b", "d", "t"}},
+	}
+	if diff := testutil.Diff(got, want, cmp.AllowUnexported(field{})); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+	// Every field has a children vector, even if it is empty.
+	if fields[0].tables(5) == nil {
+		t.Error("missing children vector")
+	}
+}
+
+func TestArrowRecordBatchMessage(t *testing.T) {
+	ts := time.Date(2021, 9, 1, 12, 0, 0, 1000, time.UTC)
+	rows := [][]Value{
+		{"ab", int64(1), big.NewRat(-3, 2), big.NewRat(1, 4), ts, civil.DateTimeOf(ts), "POINT(1 2)",
+			[]Value{int64(7), int64(8)}, []Value{true, civil.Date{Year: 1970, Month: 1, Day: 2}, civil.Time{Second: 1}}},
+		{nil, int64(2), nil, nil, nil, nil, nil, []Value(nil), nil},
+	}
+	data, err := arrowRecordBatchMessage(testArrowSchema, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, body, rest := readArrowMessage(t, data)
+	if len(rest) != 0 {
+		t.Fatalf("got %d trailing bytes", len(rest))
+	}
+	if m.uint8(1) != arrowHeaderRecordBatch {
+		t.Fatalf("got header type %d", m.uint8(1))
+	}
+	rb := m.table(2)
+	if n := rb.int64(0); n != 2 {
+		t.Errorf("got length %d, want 2", n)
+	}
+	wantNodes := [][2]int64{
+		{2, 1}, {2, 0}, {2, 1}, {2, 1}, {2, 1}, {2, 1}, {2, 1},
+		{2, 0}, {2, 0}, // the list and its elements
+		{2, 1}, {2, 1}, {2, 1}, {2, 1}, // the record and its fields
+	}
+	if diff := testutil.Diff(rb.int64Pairs(1), wantNodes); diff != "" {
+		t.Errorf("nodes: got=-, want=+:\n%s", diff)
+	}
+	buffers := rb.int64Pairs(2)
+	buf := func(i int) []byte {
+		b := buffers[i]
+		if b[0]%8 != 0 {
+			t.Errorf("buffer %d is not aligned", i)
+		}
+		return body[b[0] : b[0]+b[1]]
+	}
+	le := func(vals ...int64) []byte {
+		var b []byte
+		for _, v := range vals {
+			b = appendUint64(b, uint64(v))
+		}
+		return b
+	}
+	// 0.25 with a scale of 38, as a 256-bit little-endian integer.
+	bn := make([]byte, 32)
+	be := new(big.Int).Mul(big.NewInt(25), new(big.Int).Exp(big.NewInt(10), big.NewInt(36), nil)).Bytes()
+	for i, b := range be {
+		bn[len(be)-1-i] = b
+	}
+	for _, test := range []struct {
+		desc string
+		i    int
+		want []byte
+	}{
+		{"s validity", 0, []byte{1}},
+		{"s offsets", 1, []byte{0, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0}},
+		{"s data", 2, []byte("ab")},
+		{"i validity", 3, nil},
+		{"i values", 4, le(1, 2)},
+		{"n values", 6, append(le(-1500000000, -1), make([]byte, 16)...)},
+		{"bn values", 8, append(bn, make([]byte, 32)...)},
+		{"ts values", 10, le(ts.Unix()*1e6+1, 0)},
+		{"dt values", 12, le(ts.Unix()*1e6+1, 0)},
+		{"r validity", 16, nil},
+		{"r offsets", 17, []byte{0, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0}},
+		{"r values", 19, le(7, 8)},
+		{"rec validity", 20, []byte{1}},
+		{"b values", 22, []byte{1}},
+		{"d values", 24, []byte{1, 0, 0, 0, 0, 0, 0, 0}},
+		{"t values", 26, le(1e6, 0)},
+	} {
+		if got := buf(test.i); !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got % x, want % x", test.desc, got, test.want)
+		}
+	}
+	if len(buffers) != 27 {
+		t.Errorf("got %d buffers, want 27", len(buffers))
+	}
+
+	if _, err := arrowRecordBatchMessage(Schema{{Name: "i", Type: IntegerFieldType}}, [][]Value{{"x"}}); err == nil {
+		t.Error("got nil error for a mistyped value")
+	}
+	if _, err := arrowRecordBatchMessage(Schema{{Name: "n", Type: NumericFieldType}}, [][]Value{{big.NewRat(1, 3)}}); err == nil {
+		t.Error("got nil error for an inexact NUMERIC value")
+	}
+}
+
+func TestArrowJSONAndInterval(t *testing.T) {
+	schema := Schema{
+		{Name: "j", Type: JSONFieldType},
+		{Name: "iv", Type: IntervalFieldType},
+	}
+	m, _, _ := readArrowMessage(t, arrowSchemaMessage(schema))
+	fields := m.table(2).tables(1)
+	if got := fields[0].tables(6)[0].string(1); got != arrowJSONExtension {
+		t.Errorf("got JSON extension %q, want %q", got, arrowJSONExtension)
+	}
+	for i, f := range fields {
+		if f.uint8(2) != arrowTypeUtf8 {
+			t.Errorf("field %d: got type %d, want utf8", i, f.uint8(2))
+		}
+	}
+
+	rows := [][]Value{
+		{json.RawMessage(`{"a":1}`), &IntervalValue{Years: 1, Months: 2, Days: 3}},
+		{nil, nil},
+	}
+	data, err := arrowRecordBatchMessage(schema, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, body, _ := readArrowMessage(t, data)
+	buffers := m.table(2).int64Pairs(2)
+	for _, test := range []struct {
+		i    int
+		want string
+	}{
+		{2, `{"a":1}`},
+		{5, "1-2 3 0:0:0"},
+	} {
+		b := buffers[test.i]
+		if got := string(body[b[0] : b[0]+b[1]]); got != test.want {
+			t.Errorf("buffer %d: got %q, want %q", test.i, got, test.want)
+		}
+	}
+}
+
+func TestArrowIteratorRows(t *testing.T) {
+	schema := Schema{{Name: "s", Type: StringFieldType}}
+	stub := &pageFetcherReadStub{
+		values:     [][][]Value{{{"a"}, {"b"}}, {{"c"}}},
+		pageTokens: map[string]string{"": "t1"},
+	}
+	pf := func(ctx context.Context, src *rowSource, _ Schema, startIndex uint64, pageSize int64, pageToken string) (*fetchPageResult, error) {
+		res, err := stub.fetchPage(ctx, src, nil, startIndex, pageSize, pageToken)
+		if res != nil {
+			res.schema = schema
+		}
+		return res, err
+	}
+	it := (&Table{c: &Client{}}).read(context.Background(), pf)
+	ai, err := it.ArrowIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := testutil.Diff(ai.Schema(), schema); diff != "" {
+		t.Errorf("schema: got=-, want=+:\n%s", diff)
+	}
+	got, err := ioutil.ReadAll(NewArrowIteratorReader(ai))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numRows []int64
+	m, _, rest := readArrowMessage(t, got)
+	if m.uint8(1) != arrowHeaderSchema {
+		t.Errorf("stream starts with header type %d", m.uint8(1))
+	}
+	for len(rest) > len(arrowEOS) {
+		m, _, rest = readArrowMessage(t, rest)
+		numRows = append(numRows, m.table(2).int64(0))
+	}
+	if !bytes.Equal(rest, arrowEOS) {
+		t.Errorf("stream ends with % x", rest)
+	}
+	if diff := testutil.Diff(numRows, []int64{2, 1}); diff != "" {
+		t.Errorf("batch sizes: got=-, want=+:\n%s", diff)
+	}
+	if _, err := ai.Next(); err != iterator.Done {
+		t.Errorf("got %v after the last batch, want iterator.Done", err)
+	}
+}
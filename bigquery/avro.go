@@ -0,0 +1,281 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+// avroType is a node of an Avro schema, as written by the BigQuery Storage
+// Read API. Only the parts of the specification that the service uses are
+// supported.
+type avroType struct {
+	typ      string      // a primitive type name, "record", "array" or "union"
+	scale    int         // the scale of a decimal
+	fields   []*avroType // the field types of a record
+	items    *avroType   // the element type of an array
+	branches []*avroType // the branches of a union
+}
+
+// parseAvroSchema parses an Avro schema in JSON form.
+func parseAvroSchema(s string) (*avroType, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, fmt.Errorf("bigquery: invalid Avro schema: %v", err)
+	}
+	return avroTypeOf(v)
+}
+
+func avroTypeOf(v interface{}) (*avroType, error) {
+	switch v := v.(type) {
+	case string:
+		return &avroType{typ: v}, nil
+	case []interface{}:
+		t := &avroType{typ: "union"}
+		for _, b := range v {
+			bt, err := avroTypeOf(b)
+			if err != nil {
+				return nil, err
+			}
+			t.branches = append(t.branches, bt)
+		}
+		return t, nil
+	case map[string]interface{}:
+		name, ok := v["type"].(string)
+		if !ok {
+			// A record field, or a type wrapped in an object.
+			return avroTypeOf(v["type"])
+		}
+		t := &avroType{typ: name}
+		var err error
+		switch t.typ {
+		case "record":
+			fields, _ := v["fields"].([]interface{})
+			for _, f := range fields {
+				ft, err := avroTypeOf(f)
+				if err != nil {
+					return nil, err
+				}
+				t.fields = append(t.fields, ft)
+			}
+		case "array":
+			if t.items, err = avroTypeOf(v["items"]); err != nil {
+				return nil, err
+			}
+		case "bytes":
+			if scale, ok := v["scale"].(float64); ok {
+				t.scale = int(scale)
+			}
+		}
+		return t, nil
+	default:
+		return nil, fmt.Errorf("bigquery: invalid Avro schema element %v", v)
+	}
+}
+
+var errAvroShort = errors.New("bigquery: truncated Avro data")
+
+// avroDecoder decodes rows in the Avro binary encoding.
+type avroDecoder struct {
+	buf []byte
+}
+
+// rows decodes all the rows in the buffer, each a record of type t with the
+// given schema.
+func (d *avroDecoder) rows(t *avroType, schema Schema) ([][]Value, error) {
+	var rows [][]Value
+	for len(d.buf) > 0 {
+		row, err := d.record(t, schema)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (d *avroDecoder) record(t *avroType, schema Schema) ([]Value, error) {
+	if t.typ != "record" {
+		return nil, fmt.Errorf("bigquery: got Avro type %q for a record", t.typ)
+	}
+	if len(t.fields) != len(schema) {
+		return nil, errors.New("schema length does not match record length")
+	}
+	var values []Value
+	for i, ft := range t.fields {
+		fs := schema[i]
+		v, err := d.value(ft, fs.Type, fs.Schema)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (d *avroDecoder) value(t *avroType, typ FieldType, schema Schema) (Value, error) {
+	switch t.typ {
+	case "null":
+		return nil, nil
+	case "union":
+		i, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(t.branches)) {
+			return nil, fmt.Errorf("bigquery: invalid Avro union branch %d", i)
+		}
+		return d.value(t.branches[i], typ, schema)
+	case "array":
+		var values []Value
+		for {
+			n, err := d.long()
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				return values, nil
+			}
+			if n < 0 {
+				// A negative count is followed by the size of the block.
+				n = -n
+				if _, err := d.long(); err != nil {
+					return nil, err
+				}
+			}
+			for ; n > 0; n-- {
+				v, err := d.value(t.items, typ, schema)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, v)
+			}
+		}
+	case "record":
+		v, err := d.record(t, schema)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	case "boolean":
+		if len(d.buf) < 1 {
+			return nil, errAvroShort
+		}
+		b := d.buf[0] != 0
+		d.buf = d.buf[1:]
+		return b, nil
+	case "int", "long":
+		n, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		return convertAvroLong(n, typ)
+	case "float":
+		if len(d.buf) < 4 {
+			return nil, errAvroShort
+		}
+		f := math.Float32frombits(binary.LittleEndian.Uint32(d.buf))
+		d.buf = d.buf[4:]
+		return float64(f), nil
+	case "double":
+		if len(d.buf) < 8 {
+			return nil, errAvroShort
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+		d.buf = d.buf[8:]
+		return f, nil
+	case "bytes":
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if typ == NumericFieldType || typ == BigNumericFieldType {
+			return avroDecimal(b, t.scale), nil
+		}
+		return append([]byte{}, b...), nil
+	case "string":
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if typ == BytesFieldType {
+			return nil, errors.New("bigquery: got an Avro string for a BYTES value")
+		}
+		return convertBasicType(string(b), typ)
+	default:
+		return nil, fmt.Errorf("bigquery: unsupported Avro type %q", t.typ)
+	}
+}
+
+// long decodes a zig-zag encoded variable-length integer.
+func (d *avroDecoder) long() (int64, error) {
+	n, k := binary.Varint(d.buf)
+	if k <= 0 {
+		return 0, errAvroShort
+	}
+	d.buf = d.buf[k:]
+	return n, nil
+}
+
+func (d *avroDecoder) bytes() ([]byte, error) {
+	n, err := d.long()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > int64(len(d.buf)) {
+		return nil, errAvroShort
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+// convertAvroLong converts an Avro int or long to a value of type typ.
+func convertAvroLong(n int64, typ FieldType) (Value, error) {
+	switch typ {
+	case IntegerFieldType:
+		return n, nil
+	case TimestampFieldType:
+		// Microseconds since the epoch.
+		return time.Unix(n/1e6, n%1e6*1e3).UTC(), nil
+	case DateFieldType:
+		// Days since the epoch.
+		return civil.DateOf(time.Unix(n*24*60*60, 0).UTC()), nil
+	case TimeFieldType:
+		// Microseconds since midnight.
+		return civil.TimeOf(time.Unix(n/1e6, n%1e6*1e3).UTC()), nil
+	default:
+		return nil, fmt.Errorf("bigquery: got an Avro integer for a %s value", typ)
+	}
+}
+
+// avroDecimal converts an Avro decimal, which is a big-endian two's complement
+// integer, to a rational number.
+func avroDecimal(b []byte, scale int) *big.Rat {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	d := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	return new(big.Rat).SetFrac(n, d)
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/internal/testutil"
+)
+
+// avroEncoder writes values in the Avro binary encoding.
+type avroEncoder struct {
+	bytes.Buffer
+}
+
+func (e *avroEncoder) long(n int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func (e *avroEncoder) bytes(b []byte) {
+	e.long(int64(len(b)))
+	e.Write(b)
+}
+
+func (e *avroEncoder) double(f float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	e.Write(b[:])
+}
+
+const testAvroSchema = `{"type": "record", "name": "__root__", "fields": [
+	{"name": "s", "type": ["null", "string"]},
+	{"name": "i", "type": "long"},
+	{"name": "f", "type": ["null", "double"]},
+	{"name": "b", "type": "boolean"},
+	{"name": "by", "type": "bytes"},
+	{"name": "ts", "type": ["null", {"type": "long", "logicalType": "timestamp-micros"}]},
+	{"name": "d", "type": {"type": "int", "logicalType": "date"}},
+	{"name": "t", "type": {"type": "long", "logicalType": "time-micros"}},
+	{"name": "dt", "type": {"type": "string", "sqlType": "DATETIME"}},
+	{"name": "n", "type": {"type": "bytes", "logicalType": "decimal", "precision": 38, "scale": 9}},
+	{"name": "g", "type": {"type": "string", "sqlType": "GEOGRAPHY"}},
+	{"name": "r", "type": {"type": "array", "items": "long"}},
+	{"name": "rec", "type": ["null", {"type": "record", "name": "rec", "fields": [{"name": "x", "type": "long"}]}]}
+]}`
+
+var testAvroBQSchema = Schema{
+	{Name: "s", Type: StringFieldType},
+	{Name: "i", Type: IntegerFieldType, Required: true},
+	{Name: "f", Type: FloatFieldType},
+	{Name: "b", Type: BooleanFieldType, Required: true},
+	{Name: "by", Type: BytesFieldType, Required: true},
+	{Name: "ts", Type: TimestampFieldType},
+	{Name: "d", Type: DateFieldType, Required: true},
+	{Name: "t", Type: TimeFieldType, Required: true},
+	{Name: "dt", Type: DateTimeFieldType, Required: true},
+	{Name: "n", Type: NumericFieldType, Required: true},
+	{Name: "g", Type: GeographyFieldType, Required: true},
+	{Name: "r", Type: IntegerFieldType, Repeated: true},
+	{Name: "rec", Type: RecordFieldType, Schema: Schema{{Name: "x", Type: IntegerFieldType, Required: true}}},
+}
+
+func TestAvroDecoder(t *testing.T) {
+	at, err := parseAvroSchema(testAvroSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2021, 9, 1, 12, 34, 56, 789012000, time.UTC)
+	var e avroEncoder
+
+	// A row without nulls.
+	e.long(1)
+	e.bytes([]byte("hello"))
+	e.long(-42)
+	e.long(1)
+	e.double(3.5)
+	e.WriteByte(1)
+	e.bytes([]byte{0, 1, 2})
+	e.long(1)
+	e.long(ts.UnixNano() / 1e3)
+	e.long(ts.Unix() / (24 * 60 * 60))
+	e.long((12*60*60+34*60+56)*1e6 + 789012)
+	e.bytes([]byte("2021-09-01T12:34:56.789012"))
+	e.bytes([]byte{0x1C, 0xBE, 0x8D, 0x10, 0x00}) // 123.456
+	e.bytes([]byte("POINT(1 2)"))
+	e.long(2)
+	e.long(7)
+	e.long(8)
+	e.long(0)
+	e.long(1)
+	e.long(9)
+
+	// A row with nulls, a negative decimal, a timestamp before the epoch and
+	// an array whose block has a byte count.
+	e.long(0)
+	e.long(0)
+	e.long(0)
+	e.WriteByte(0)
+	e.bytes(nil)
+	e.long(1)
+	e.long(-1500000)
+	e.long(0)
+	e.long(0)
+	e.bytes([]byte("1970-01-01T00:00:00"))
+	e.bytes([]byte{0xA6, 0x97, 0xD1, 0x00}) // -1.5
+	e.bytes(nil)
+	e.long(-1)
+	e.long(1)
+	e.long(-3)
+	e.long(0)
+	e.long(0)
+
+	d := &avroDecoder{buf: e.Bytes()}
+	got, err := d.rows(at, testAvroBQSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]Value{
+		{"hello", int64(-42), 3.5, true, []byte{0, 1, 2}, ts, civil.DateOf(ts), civil.TimeOf(ts),
+			civil.DateTimeOf(ts), big.NewRat(123456, 1000), "POINT(1 2)", []Value{int64(7), int64(8)}, []Value{int64(9)}},
+		{nil, int64(0), nil, false, []byte{}, time.Unix(-1, -500000000).UTC(), civil.Date{Year: 1970, Month: 1, Day: 1}, civil.Time{},
+			civil.DateTime{Date: civil.Date{Year: 1970, Month: 1, Day: 1}}, big.NewRat(-3, 2), "", []Value{int64(-3)}, nil},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestAvroDecoderErrors(t *testing.T) {
+	at, err := parseAvroSchema(`{"type": "record", "fields": [{"name": "s", "type": ["null", "string"]}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		desc   string
+		data   []byte
+		schema Schema
+	}{
+		{"truncated string", []byte{2, 10, 'a'}, Schema{{Name: "s", Type: StringFieldType}}},
+		{"bad union branch", []byte{4}, Schema{{Name: "s", Type: StringFieldType}}},
+		{"schema mismatch", []byte{0}, Schema{{Name: "s"}, {Name: "t"}}},
+		{"type mismatch", []byte{2, 2, 'a'}, Schema{{Name: "s", Type: BytesFieldType}}},
+	} {
+		d := &avroDecoder{buf: test.data}
+		if _, err := d.rows(at, test.schema); err == nil {
+			t.Errorf("%s: got nil error, want one", test.desc)
+		}
+	}
+	if _, err := parseAvroSchema(`{"type": 1}`); err == nil {
+		t.Error("got nil error for an invalid schema")
+	}
+}
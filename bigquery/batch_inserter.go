@@ -0,0 +1,332 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	gax "github.com/googleapis/gax-go/v2"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/support/bundler"
+)
+
+const (
+	// MaxInsertRequestRows is the maximum number of rows in an insertAll
+	// request.
+	MaxInsertRequestRows = 50000
+
+	// MaxInsertRequestBytes is the maximum size of an insertAll request.
+	MaxInsertRequestBytes = 10 << 20
+
+	// insertRequestOverhead is the space reserved in a request for everything
+	// but its rows.
+	insertRequestOverhead = 1 << 10
+)
+
+// BatchSettings control the batching of rows by a BatchInserter.
+type BatchSettings struct {
+	// Send a non-empty batch after this delay has passed.
+	DelayThreshold time.Duration
+
+	// Send a batch when it has this many rows. The maximum is
+	// MaxInsertRequestRows.
+	CountThreshold int
+
+	// Send a batch when the size of its rows, encoded as JSON, reaches this
+	// many bytes. Batches never exceed MaxInsertRequestBytes.
+	ByteThreshold int
+
+	// The maximum number of insertAll requests in flight at once.
+	NumGoroutines int
+
+	// The maximum number of bytes of rows to buffer. Once it is reached, Put
+	// blocks until earlier rows have been sent.
+	BufferedByteLimit int
+
+	// The maximum time to spend sending a batch, including retries.
+	Timeout time.Duration
+}
+
+// DefaultBatchSettings holds the default values for a BatchInserter's
+// BatchSettings.
+var DefaultBatchSettings = BatchSettings{
+	DelayThreshold:    100 * time.Millisecond,
+	CountThreshold:    500,
+	ByteThreshold:     5 << 20,
+	NumGoroutines:     10,
+	BufferedByteLimit: 10 * MaxInsertRequestBytes,
+	Timeout:           60 * time.Second,
+}
+
+var errBatchInserterStopped = errors.New("bigquery: BatchInserter has been stopped")
+
+// A BatchInserter does streaming inserts into a BigQuery table in the
+// background. It buffers the rows passed to Put and sends them in batches,
+// with several insertAll requests in flight at once.
+//
+// The methods of BatchInserter are safe for use by multiple goroutines.
+type BatchInserter struct {
+	u       *Inserter
+	onError func(rows []ValueSaver, err error)
+
+	// Settings for batching rows. All changes must be made before the first
+	// call to Put. The default is DefaultBatchSettings.
+	BatchSettings BatchSettings
+
+	mu      sync.RWMutex
+	stopped bool
+	bundler *bundler.Bundler
+}
+
+// batchRow is a row buffered by a BatchInserter.
+type batchRow struct {
+	saver ValueSaver
+	row   *bq.TableDataInsertAllRequestRows
+}
+
+// Batch returns a BatchInserter that inserts rows into the Inserter's table
+// with the Inserter's options.
+//
+// Rows that cannot be inserted are reported by calling onError, which may be
+// nil. rows holds the rows that failed. If err is a PutMultiError, the
+// RowIndex of each of its RowInsertionErrors is an index into rows; otherwise
+// err applies to all of rows. onError may be called concurrently, and more
+// than once for the rows of a batch.
+//
+// Call Stop to send any buffered rows and release the resources of the
+// BatchInserter.
+func (u *Inserter) Batch(onError func(rows []ValueSaver, err error)) *BatchInserter {
+	return &BatchInserter{
+		u:             u,
+		onError:       onError,
+		BatchSettings: DefaultBatchSettings,
+	}
+}
+
+// Put adds one or more rows to the batch. src is interpreted as it is by
+// Inserter.Put. Each row is saved, and given an insert ID if it has none, when
+// it is added, so that the rows of a batch that is retried can be
+// deduplicated.
+//
+// Put returns once the rows are buffered; it blocks if the buffer is full.
+// It returns an error if the rows cannot be saved, if a row is too large to
+// send, or if ctx is done while Put is blocked. Rows before the one with the
+// error have been added.
+func (b *BatchInserter) Put(ctx context.Context, src interface{}) error {
+	savers, err := valueSavers(src)
+	if err != nil {
+		return err
+	}
+	b.initBundler()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.stopped {
+		return errBatchInserterStopped
+	}
+	for _, saver := range savers {
+		row, err := saveInsertRow(saver)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		// Allow for the comma separating rows.
+		size := len(data) + 1
+		if err := b.bundler.AddWait(ctx, &batchRow{saver: saver, row: row}, size); err != nil {
+			if err == bundler.ErrOversizedItem {
+				return fmt.Errorf("bigquery: row of %d bytes exceeds the maximum request size", size)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush blocks until all rows added by earlier calls to Put have been sent.
+func (b *BatchInserter) Flush() {
+	b.mu.RLock()
+	bu := b.bundler
+	b.mu.RUnlock()
+	if bu != nil {
+		bu.Flush()
+	}
+}
+
+// Stop sends all buffered rows and waits for them to be inserted or to fail.
+// Calls to Put after Stop return an error.
+func (b *BatchInserter) Stop() {
+	b.mu.Lock()
+	b.stopped = true
+	bu := b.bundler
+	b.mu.Unlock()
+	if bu != nil {
+		bu.Flush()
+	}
+}
+
+func (b *BatchInserter) initBundler() {
+	b.mu.RLock()
+	noop := b.stopped || b.bundler != nil
+	b.mu.RUnlock()
+	if noop {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Must re-check, since we released the lock.
+	if b.stopped || b.bundler != nil {
+		return
+	}
+
+	s := b.BatchSettings
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultBatchSettings.Timeout
+	}
+	bu := bundler.NewBundler(&batchRow{}, func(items interface{}) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		b.insertBatch(ctx, items.([]*batchRow))
+	})
+	bu.DelayThreshold = s.DelayThreshold
+	if bu.DelayThreshold == 0 {
+		bu.DelayThreshold = DefaultBatchSettings.DelayThreshold
+	}
+	bu.BundleCountThreshold = s.CountThreshold
+	if bu.BundleCountThreshold <= 0 {
+		bu.BundleCountThreshold = DefaultBatchSettings.CountThreshold
+	}
+	if bu.BundleCountThreshold > MaxInsertRequestRows {
+		bu.BundleCountThreshold = MaxInsertRequestRows
+	}
+	bu.BundleByteLimit = MaxInsertRequestBytes - insertRequestOverhead
+	bu.BundleByteThreshold = s.ByteThreshold
+	if bu.BundleByteThreshold <= 0 {
+		bu.BundleByteThreshold = DefaultBatchSettings.ByteThreshold
+	}
+	if bu.BundleByteThreshold > bu.BundleByteLimit {
+		bu.BundleByteThreshold = bu.BundleByteLimit
+	}
+	bu.HandlerLimit = s.NumGoroutines
+	if bu.HandlerLimit <= 0 {
+		bu.HandlerLimit = DefaultBatchSettings.NumGoroutines
+	}
+	bu.BufferedByteLimit = s.BufferedByteLimit
+	if bu.BufferedByteLimit <= 0 {
+		bu.BufferedByteLimit = DefaultBatchSettings.BufferedByteLimit
+	}
+	b.bundler = bu
+}
+
+// insertBatch sends a batch of rows. Rows that fail with retryable errors are
+// sent again, with backoff, until they succeed or ctx is done. Rows that
+// cannot be inserted are reported to onError.
+func (b *BatchInserter) insertBatch(ctx context.Context, rows []*batchRow) {
+	// These parameters match those of runWithRetry.
+	backoff := gax.Backoff{
+		Initial:    1 * time.Second,
+		Max:        32 * time.Second,
+		Multiplier: 2,
+	}
+	var failed []ValueSaver
+	var errs PutMultiError
+	fail := func(r *batchRow, rie RowInsertionError) {
+		rie.RowIndex = len(failed)
+		failed = append(failed, r.saver)
+		errs = append(errs, rie)
+	}
+	for len(rows) > 0 {
+		req := b.u.emptyInsertRequest()
+		for _, r := range rows {
+			req.Rows = append(req.Rows, r.row)
+		}
+		res, err := b.u.insertAll(ctx, req)
+		if err == nil {
+			err = handleInsertErrors(res.InsertErrors, req.Rows)
+		}
+		if err == nil {
+			break
+		}
+		pme, ok := err.(PutMultiError)
+		if !ok {
+			b.reportError(savers(rows), err)
+			break
+		}
+		var retry []*batchRow
+		var retryErrs []RowInsertionError
+		for _, rie := range pme {
+			r := rows[rie.RowIndex]
+			if retryableRowError(rie) {
+				retry = append(retry, r)
+				retryErrs = append(retryErrs, rie)
+			} else {
+				fail(r, rie)
+			}
+		}
+		rows = retry
+		if len(rows) > 0 && gax.Sleep(ctx, backoff.Pause()) != nil {
+			// Out of time; report the rows with their last errors.
+			for i, r := range rows {
+				fail(r, retryErrs[i])
+			}
+			break
+		}
+	}
+	if len(errs) > 0 {
+		b.reportError(failed, errs)
+	}
+}
+
+func (b *BatchInserter) reportError(rows []ValueSaver, err error) {
+	if b.onError != nil {
+		b.onError(rows, err)
+	}
+}
+
+func savers(rows []*batchRow) []ValueSaver {
+	vs := make([]ValueSaver, len(rows))
+	for i, r := range rows {
+		vs[i] = r.saver
+	}
+	return vs
+}
+
+// retryableRowError reports whether a row failed only for reasons that may
+// succeed on retry: a backend error, or because the request was stopped by an
+// invalid row elsewhere in it.
+func retryableRowError(rie RowInsertionError) bool {
+	if len(rie.Errors) == 0 {
+		return false
+	}
+	for _, err := range rie.Errors {
+		e, ok := err.(*Error)
+		if !ok {
+			return false
+		}
+		switch e.Reason {
+		case "backendError", "internalError", "timeout", "stopped":
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/internal/testutil"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+)
+
+// fakeInsertAll serves insertAll requests. Rows whose "name" starts with
+// "bad" fail with an invalid error, and rows named "flaky" fail with a
+// backend error the first time they are sent. The rows of a request are not
+// inserted if any of them is invalid.
+type fakeInsertAll struct {
+	mu       sync.Mutex
+	requests []int    // number of rows in each request
+	inserted []string // names of inserted rows
+	seen     map[string]bool
+}
+
+func (f *fakeInsertAll) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req bq.TableDataInsertAllRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, len(req.Rows))
+	var res bq.TableDataInsertAllResponse
+	invalid := false
+	for i, row := range req.Rows {
+		name, _ := row.Json["name"].(string)
+		var reason string
+		switch {
+		case strings.HasPrefix(name, "bad"):
+			reason = "invalid"
+			invalid = true
+		case name == "flaky" && !f.seen[row.InsertId]:
+			reason = "backendError"
+			f.seen[row.InsertId] = true
+		}
+		if reason != "" {
+			res.InsertErrors = append(res.InsertErrors, &bq.TableDataInsertAllResponseInsertErrors{
+				Index:  int64(i),
+				Errors: []*bq.ErrorProto{{Reason: reason}},
+			})
+		}
+	}
+	if invalid {
+		// Report the valid rows as stopped, as the service does.
+		failed := map[int64]bool{}
+		for _, e := range res.InsertErrors {
+			failed[e.Index] = true
+		}
+		for i := range req.Rows {
+			if !failed[int64(i)] {
+				res.InsertErrors = append(res.InsertErrors, &bq.TableDataInsertAllResponseInsertErrors{
+					Index:  int64(i),
+					Errors: []*bq.ErrorProto{{Reason: "stopped"}},
+				})
+			}
+		}
+	} else {
+		failed := map[int64]bool{}
+		for _, e := range res.InsertErrors {
+			failed[e.Index] = true
+		}
+		for i, row := range req.Rows {
+			if !failed[int64(i)] {
+				f.inserted = append(f.inserted, row.Json["name"].(string))
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(&res)
+}
+
+func newBatchTestTable(t *testing.T) (*Table, *fakeInsertAll, func()) {
+	fake := &fakeInsertAll{seen: map[string]bool{}}
+	ts := httptest.NewServer(fake)
+	c, err := NewClient(context.Background(), "p", option.WithEndpoint(ts.URL+"/bigquery/v2/"), option.WithoutAuthentication())
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return c.Dataset("d").Table("t"), fake, func() {
+		c.Close()
+		ts.Close()
+	}
+}
+
+func nameRows(names ...string) []*ValuesSaver {
+	schema := Schema{{Name: "name", Type: StringFieldType}}
+	var rows []*ValuesSaver
+	for _, n := range names {
+		rows = append(rows, &ValuesSaver{Schema: schema, Row: []Value{n}})
+	}
+	return rows
+}
+
+func TestBatchInserter(t *testing.T) {
+	table, fake, cleanup := newBatchTestTable(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	var failed []string
+	b := table.Inserter().Batch(func(rows []ValueSaver, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		pme, ok := err.(PutMultiError)
+		if !ok {
+			t.Errorf("got error %v, want PutMultiError", err)
+			return
+		}
+		for _, rie := range pme {
+			row, _, _ := rows[rie.RowIndex].Save()
+			failed = append(failed, row["name"].(string))
+			if len(rie.Errors) != 1 || rie.Errors[0].(*Error).Reason != "invalid" {
+				t.Errorf("got errors %v, want one invalid error", rie.Errors)
+			}
+		}
+	})
+	b.BatchSettings.CountThreshold = 3
+	b.BatchSettings.NumGoroutines = 1
+
+	ctx := context.Background()
+	if err := b.Put(ctx, nameRows("a", "b", "c", "d", "flaky", "bad1", "e")); err != nil {
+		t.Fatal(err)
+	}
+	b.Stop()
+	if err := b.Put(ctx, nameRows("f")); err != errBatchInserterStopped {
+		t.Errorf("Put after Stop: got %v, want errBatchInserterStopped", err)
+	}
+
+	sort.Strings(fake.inserted)
+	if diff := testutil.Diff(fake.inserted, []string{"a", "b", "c", "d", "e", "flaky"}); diff != "" {
+		t.Errorf("inserted: got=-, want=+:\n%s", diff)
+	}
+	if diff := testutil.Diff(failed, []string{"bad1"}); diff != "" {
+		t.Errorf("failed: got=-, want=+:\n%s", diff)
+	}
+	// Batches of 3 rows; the flaky row, and the row stopped by the invalid
+	// one, are retried together.
+	if diff := testutil.Diff(fake.requests, []int{3, 3, 2, 1}); diff != "" {
+		t.Errorf("request sizes: got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestBatchInserterByteThreshold(t *testing.T) {
+	table, fake, cleanup := newBatchTestTable(t)
+	defer cleanup()
+
+	b := table.Inserter().Batch(nil)
+	b.BatchSettings.ByteThreshold = 200
+	b.BatchSettings.NumGoroutines = 1
+	name := strings.Repeat("x", 50)
+	ctx := context.Background()
+	for i := 0; i < 6; i++ {
+		if err := b.Put(ctx, nameRows(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Flush()
+	// The first two rows reach the threshold and are sent at once, so there
+	// are at least two requests.
+	if len(fake.inserted) != 6 || len(fake.requests) < 2 {
+		t.Errorf("inserted %d rows in %d requests, want 6 rows in at least 2", len(fake.inserted), len(fake.requests))
+	}
+
+	// A row that cannot fit in a request is rejected.
+	if err := b.Put(ctx, nameRows(strings.Repeat("x", MaxInsertRequestBytes))); err == nil {
+		t.Error("putting an oversized row: got nil, want error")
+	}
+	b.Stop()
+}
+
+func TestRetryableRowError(t *testing.T) {
+	for _, test := range []struct {
+		errs MultiError
+		want bool
+	}{
+		{nil, false},
+		{MultiError{&Error{Reason: "backendError"}}, true},
+		{MultiError{&Error{Reason: "stopped"}}, true},
+		{MultiError{&Error{Reason: "backendError"}, &Error{Reason: "invalid"}}, false},
+		{MultiError{&Error{Reason: "invalid"}}, false},
+	} {
+		if got := retryableRowError(RowInsertionError{Errors: test.errs}); got != test.want {
+			t.Errorf("%v: got %t, want %t", test.errs, got, test.want)
+		}
+	}
+}
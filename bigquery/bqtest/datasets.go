@@ -0,0 +1,249 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// dataset is a dataset and its tables. The metadata of resources held by the
+// server is never modified in place: updates replace it, so that a response
+// can be encoded after s.mu is released.
+type dataset struct {
+	meta   *bq.Dataset
+	tables map[string]*table
+}
+
+// validIDChars matches dataset and table IDs, which must also be at most
+// maxIDLength characters long. The limit can't be expressed as a repeat count,
+// which regexp caps at 1000.
+var validIDChars = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+const maxIDLength = 1024
+
+func validID(id string) bool {
+	return len(id) <= maxIDLength && validIDChars.MatchString(id)
+}
+
+func datasetKey(projectID, datasetID string) string {
+	return projectID + ":" + datasetID
+}
+
+// handleDatasets dispatches a request for datasets and their tables. segs is
+// the request path after "/projects/{projectId}/datasets".
+func (s *Server) handleDatasets(r *http.Request, projectID string, segs []string) (interface{}, error) {
+	switch len(segs) {
+	case 0:
+		switch r.Method {
+		case http.MethodGet:
+			return s.listDatasets(r, projectID)
+		case http.MethodPost:
+			return s.insertDataset(r, projectID)
+		}
+		return nil, errMethod(r)
+	case 1:
+		switch r.Method {
+		case http.MethodGet:
+			return s.getDataset(projectID, segs[0])
+		case http.MethodPatch, http.MethodPut:
+			return s.patchDataset(r, projectID, segs[0])
+		case http.MethodDelete:
+			return nil, s.deleteDataset(r, projectID, segs[0])
+		}
+		return nil, errMethod(r)
+	}
+	if segs[1] != "tables" {
+		return nil, errNotFound(r)
+	}
+	return s.handleTables(r, projectID, segs[0], segs[2:])
+}
+
+func errDatasetNotFound(projectID, datasetID string) error {
+	return errorf(http.StatusNotFound, "Not found: Dataset %s:%s", projectID, datasetID)
+}
+
+// lookupDataset returns the named dataset. s.mu must be held.
+func (s *Server) lookupDataset(projectID, datasetID string) (*dataset, error) {
+	ds := s.datasets[datasetKey(projectID, datasetID)]
+	if ds == nil {
+		return nil, errDatasetNotFound(projectID, datasetID)
+	}
+	return ds, nil
+}
+
+func (s *Server) insertDataset(r *http.Request, projectID string) (interface{}, error) {
+	var meta bq.Dataset
+	if err := decodeBody(r, &meta); err != nil {
+		return nil, err
+	}
+	if meta.DatasetReference == nil || !validID(meta.DatasetReference.DatasetId) {
+		return nil, errorf(http.StatusBadRequest, "Invalid dataset ID.")
+	}
+	datasetID := meta.DatasetReference.DatasetId
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := datasetKey(projectID, datasetID)
+	if s.datasets[key] != nil {
+		return nil, errorf(http.StatusConflict, "Already Exists: Dataset %s", key)
+	}
+	now := s.now()
+	meta.Kind = "bigquery#dataset"
+	meta.Id = key
+	meta.DatasetReference = &bq.DatasetReference{ProjectId: projectID, DatasetId: datasetID}
+	if meta.Location == "" {
+		meta.Location = defaultLocation
+	}
+	meta.CreationTime = now
+	meta.LastModifiedTime = now
+	meta.Etag = s.newEtag()
+	s.datasets[key] = &dataset{meta: &meta, tables: map[string]*table{}}
+	return &meta, nil
+}
+
+func (s *Server) getDataset(projectID, datasetID string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, err := s.lookupDataset(projectID, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	return ds.meta, nil
+}
+
+func (s *Server) patchDataset(r *http.Request, projectID, datasetID string) (interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, err := s.lookupDataset(projectID, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEtag(r, ds.meta.Etag); err != nil {
+		return nil, err
+	}
+	var meta bq.Dataset
+	if r.Method == http.MethodPut {
+		err = mergePatch(&bq.Dataset{}, body, &meta)
+	} else {
+		err = mergePatch(ds.meta, body, &meta)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Output-only and immutable fields keep their values.
+	meta.Kind = ds.meta.Kind
+	meta.Id = ds.meta.Id
+	meta.DatasetReference = ds.meta.DatasetReference
+	meta.Location = ds.meta.Location
+	meta.CreationTime = ds.meta.CreationTime
+	meta.LastModifiedTime = s.now()
+	meta.Etag = s.newEtag()
+	ds.meta = &meta
+	return &meta, nil
+}
+
+func (s *Server) deleteDataset(r *http.Request, projectID, datasetID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, err := s.lookupDataset(projectID, datasetID)
+	if err != nil {
+		return err
+	}
+	if len(ds.tables) > 0 && !boolParam(r.URL.Query(), "deleteContents") {
+		e := errorf(http.StatusBadRequest, "Dataset %s is still in use", ds.meta.Id)
+		e.reason = "resourceInUse"
+		return e
+	}
+	delete(s.datasets, datasetKey(projectID, datasetID))
+	return nil
+}
+
+func (s *Server) listDatasets(r *http.Request, projectID string) (interface{}, error) {
+	params := r.URL.Query()
+	match, err := labelFilter(params.Get("filter"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []*bq.DatasetListDatasets
+	for _, ds := range s.datasets {
+		if ds.meta.DatasetReference.ProjectId != projectID || !match(ds.meta.Labels) {
+			continue
+		}
+		items = append(items, &bq.DatasetListDatasets{
+			Kind:             ds.meta.Kind,
+			Id:               ds.meta.Id,
+			DatasetReference: ds.meta.DatasetReference,
+			FriendlyName:     ds.meta.FriendlyName,
+			Labels:           ds.meta.Labels,
+			Location:         ds.meta.Location,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DatasetReference.DatasetId < items[j].DatasetReference.DatasetId
+	})
+	start, end, next, err := pageBounds(params, len(items))
+	if err != nil {
+		return nil, err
+	}
+	return &bq.DatasetList{
+		Kind:          "bigquery#datasetList",
+		Datasets:      items[start:end],
+		NextPageToken: next,
+	}, nil
+}
+
+// labelFilter parses a dataset list filter of space-separated terms of the
+// form "labels.KEY" or "labels.KEY:VALUE", all of which must match.
+func labelFilter(filter string) (func(map[string]string) bool, error) {
+	type term struct {
+		key, value string
+		hasValue   bool
+	}
+	var terms []term
+	for _, f := range strings.Fields(filter) {
+		if !strings.HasPrefix(f, "labels.") {
+			return nil, errorf(http.StatusBadRequest, "Invalid filter %q", filter)
+		}
+		kv := strings.SplitN(strings.TrimPrefix(f, "labels."), ":", 2)
+		t := term{key: kv[0]}
+		if len(kv) == 2 {
+			t.value, t.hasValue = kv[1], true
+		}
+		terms = append(terms, t)
+	}
+	return func(labels map[string]string) bool {
+		for _, t := range terms {
+			v, ok := labels[t.key]
+			if !ok || (t.hasValue && v != t.value) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
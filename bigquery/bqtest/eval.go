@@ -0,0 +1,1619 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// This file runs SELECT statements. Expressions are compiled, against the
+// columns of the FROM clause, into closures that know their result type, so
+// that the schema of a result is known even if it has no rows.
+
+// maxViewDepth limits the nesting of views, which guards against cycles.
+const maxViewDepth = 16
+
+// queryRunner runs a query. s.mu must be held while it runs.
+type queryRunner struct {
+	s              *Server
+	projectID      string               // the project of the job
+	defaultDataset *bq.DatasetReference // may be nil
+	params         *queryParams         // may be nil
+	now            time.Time
+	depth          int // nesting of views
+}
+
+// queryResult is the result of a query.
+type queryResult struct {
+	schema []*bq.TableFieldSchema
+	rows   []row
+}
+
+// run parses and runs a query.
+func (q *queryRunner) run(sql string) (*queryResult, error) {
+	stmt, err := parseQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	return q.execSelect(stmt)
+}
+
+// scope describes the columns of the rows produced by a FROM clause.
+type scope struct {
+	cols []*scopeCol
+}
+
+type scopeCol struct {
+	table string // the alias of the FROM item
+	field *bq.TableFieldSchema
+}
+
+// env is the environment in which a compiled expression is evaluated.
+type env struct {
+	row row
+	// group holds the rows of the current group, for aggregate functions.
+	group []row
+}
+
+// compiled is a compiled expression.
+type compiled struct {
+	// typ is the type of the expression. Its Type is canonical or typeNull for
+	// an untyped NULL literal, and its Mode is REPEATED for arrays.
+	typ  *bq.TableFieldSchema
+	eval func(*env) (interface{}, error)
+	// constant is set for literals and query parameters.
+	constant bool
+}
+
+// typeNull is the type of the NULL literal, which coerces to any type.
+const typeNull = "NULL"
+
+func scalarType(t string) *bq.TableFieldSchema {
+	return &bq.TableFieldSchema{Type: t}
+}
+
+func arrayType(elem *bq.TableFieldSchema) *bq.TableFieldSchema {
+	f := *elem
+	f.Mode = modeRepeated
+	return &f
+}
+
+func elemType(array *bq.TableFieldSchema) *bq.TableFieldSchema {
+	f := *array
+	f.Mode = ""
+	return &f
+}
+
+// sqlTypeName returns the GoogleSQL name of a type, for error messages.
+func sqlTypeName(f *bq.TableFieldSchema) string {
+	if isRepeated(f) {
+		return "ARRAY<" + sqlTypeName(elemType(f)) + ">"
+	}
+	switch f.Type {
+	case "INTEGER", typeNull:
+		return "INT64"
+	case "FLOAT":
+		return "FLOAT64"
+	case "BOOLEAN":
+		return "BOOL"
+	case "RECORD":
+		var fields []string
+		for _, sf := range f.Fields {
+			fields = append(fields, sf.Name+" "+sqlTypeName(canonicalField(sf)))
+		}
+		return "STRUCT<" + strings.Join(fields, ", ") + ">"
+	}
+	return f.Type
+}
+
+// canonicalField returns f with its type in canonical form.
+func canonicalField(f *bq.TableFieldSchema) *bq.TableFieldSchema {
+	c := *f
+	c.Type = canonicalType(f.Type)
+	return &c
+}
+
+func typeNames(args []*compiled) string {
+	var names []string
+	for _, a := range args {
+		names = append(names, sqlTypeName(a.typ))
+	}
+	return strings.Join(names, ", ")
+}
+
+// execSelect runs a SELECT statement.
+func (q *queryRunner) execSelect(stmt *selectStmt) (*queryResult, error) {
+	sc, rows, err := q.from(stmt.from)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.where != nil {
+		if hasAggregate(stmt.where) {
+			return nil, invalidQuery("Aggregate function not allowed in WHERE clause")
+		}
+		where, err := q.newCompiler(sc, "WHERE clause").compileBool(stmt.where)
+		if err != nil {
+			return nil, err
+		}
+		var kept []row
+		for _, r := range rows {
+			v, err := where.eval(&env{row: r, group: []row{r}})
+			if err != nil {
+				return nil, err
+			}
+			if v == true {
+				kept = append(kept, r)
+			}
+		}
+		rows = kept
+	}
+
+	outs, err := expandSelectList(stmt, sc)
+	if err != nil {
+		return nil, err
+	}
+	grouped := len(stmt.groupBy) > 0 || stmt.having != nil
+	for _, o := range outs {
+		grouped = grouped || hasAggregate(o.ast)
+	}
+	for _, o := range stmt.orderBy {
+		grouped = grouped || hasAggregate(o.expr)
+	}
+
+	// Resolve GROUP BY expressions, which may name select list items by alias
+	// or position. Columns that are not grouped may only be referenced in
+	// aggregate functions; this is only checked if all grouping keys are
+	// columns or select list items.
+	var keys []*compiled
+	keyItems := map[int]bool{}
+	groupCols := map[int]bool{}
+	checkGrouped := grouped
+	for _, g := range stmt.groupBy {
+		if hasAggregate(g) {
+			return nil, invalidQuery("Aggregate function not allowed in GROUP BY clause")
+		}
+		item := -1
+		if i, ok := selectListIndex(g, outs, true); ok {
+			if i < 0 {
+				return nil, invalidQuery("GROUP BY is out of SELECT column number range")
+			}
+			item = i
+			keyItems[i] = true
+			g = outs[i].ast
+		}
+		c := q.newCompiler(sc, "GROUP BY clause")
+		key, err := c.compile(g)
+		if err != nil {
+			return nil, err
+		}
+		if isRepeated(key.typ) || key.typ.Type == "RECORD" || key.typ.Type == "GEOGRAPHY" {
+			return nil, invalidQuery("Grouping by expressions of type %s is not allowed", sqlTypeName(key.typ))
+		}
+		if col, ok := c.topLevelColumn(g); ok {
+			groupCols[col] = true
+		} else if item < 0 {
+			checkGrouped = false
+		}
+		keys = append(keys, key)
+	}
+
+	// Compile the select list.
+	sel := make([]*compiled, len(outs))
+	for i, o := range outs {
+		c := q.newCompiler(sc, "SELECT list")
+		c.aggs = grouped
+		if checkGrouped && !keyItems[i] {
+			c.groupCols = groupCols
+		}
+		if sel[i], err = c.compile(o.ast); err != nil {
+			return nil, err
+		}
+	}
+	var having *compiled
+	if stmt.having != nil {
+		c := q.newCompiler(sc, "HAVING clause")
+		c.aggs = true
+		c.aliases = outs
+		if checkGrouped {
+			c.groupCols = groupCols
+		}
+		if having, err = c.compileBool(stmt.having); err != nil {
+			return nil, err
+		}
+	}
+
+	// ORDER BY expressions may name select list items, or be expressions
+	// over the input.
+	type orderKey struct {
+		out  int // index of the select list item, or -1
+		c    *compiled
+		desc bool
+		nf   bool // NULLs first
+	}
+	var order []orderKey
+	for _, o := range stmt.orderBy {
+		k := orderKey{out: -1, desc: o.desc, nf: !o.desc}
+		if o.nullsFirst != nil {
+			k.nf = *o.nullsFirst
+		}
+		if i, ok := selectListIndex(o.expr, outs, false); ok {
+			if i < 0 {
+				return nil, invalidQuery("ORDER BY is out of SELECT column number range")
+			}
+			k.out = i
+			if !isOrderable(sel[i].typ) {
+				return nil, invalidQuery("ORDER BY does not support expressions of type %s", sqlTypeName(sel[i].typ))
+			}
+		} else {
+			c := q.newCompiler(sc, "ORDER BY clause")
+			c.aggs = grouped
+			if checkGrouped {
+				c.groupCols = groupCols
+			}
+			if k.c, err = c.compile(o.expr); err != nil {
+				return nil, err
+			}
+			if !isOrderable(k.c.typ) {
+				return nil, invalidQuery("ORDER BY does not support expressions of type %s", sqlTypeName(k.c.typ))
+			}
+		}
+		order = append(order, k)
+	}
+
+	// Form groups, or treat each row as its own group.
+	var envs []*env
+	if grouped {
+		index := map[string]*env{}
+		for _, r := range rows {
+			e := &env{row: r}
+			var kb strings.Builder
+			for _, k := range keys {
+				v, err := k.eval(e)
+				if err != nil {
+					return nil, err
+				}
+				kb.WriteString(valueKey(v))
+				kb.WriteString("|")
+			}
+			g := index[kb.String()]
+			if g == nil {
+				g = e
+				index[kb.String()] = g
+				envs = append(envs, g)
+			}
+			g.group = append(g.group, r)
+		}
+		if len(stmt.groupBy) == 0 && len(envs) == 0 {
+			// Aggregating no rows produces a single row.
+			empty := make(row, len(sc.cols))
+			for i, c := range sc.cols {
+				empty[i] = nullValue(c.field)
+			}
+			envs = []*env{{row: empty}}
+		}
+	} else {
+		for _, r := range rows {
+			envs = append(envs, &env{row: r, group: []row{r}})
+		}
+	}
+
+	type outRow struct {
+		vals row
+		keys []interface{}
+	}
+	var out []outRow
+	seen := map[string]bool{}
+	for _, e := range envs {
+		if having != nil {
+			v, err := having.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			if v != true {
+				continue
+			}
+		}
+		or := outRow{vals: make(row, len(sel))}
+		for i, c := range sel {
+			if or.vals[i], err = c.eval(e); err != nil {
+				return nil, err
+			}
+		}
+		if stmt.distinct {
+			k := valueKey(or.vals)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		for _, k := range order {
+			var v interface{}
+			if k.out >= 0 {
+				v = or.vals[k.out]
+			} else if v, err = k.c.eval(e); err != nil {
+				return nil, err
+			}
+			or.keys = append(or.keys, v)
+		}
+		out = append(out, or)
+	}
+	if len(order) > 0 {
+		sort.SliceStable(out, func(i, j int) bool {
+			for n, k := range order {
+				a, b := out[i].keys[n], out[j].keys[n]
+				switch {
+				case a == nil && b == nil:
+					continue
+				case a == nil:
+					if k.nf {
+						return true
+					}
+					return false
+				case b == nil:
+					return !k.nf
+				}
+				c := compareValues(a, b)
+				if k.desc {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+
+	offset, limit := 0, len(out)
+	if stmt.limit != nil {
+		if limit, err = q.evalCount(stmt.limit, "LIMIT"); err != nil {
+			return nil, err
+		}
+	}
+	if stmt.offset != nil {
+		if offset, err = q.evalCount(stmt.offset, "OFFSET"); err != nil {
+			return nil, err
+		}
+	}
+	res := &queryResult{schema: resultSchema(outs, sel)}
+	for i := offset; i < len(out) && i-offset < limit; i++ {
+		res.rows = append(res.rows, out[i].vals)
+	}
+	return res, nil
+}
+
+// evalCount evaluates the argument of LIMIT or OFFSET, which must be a
+// non-negative integer literal or parameter.
+func (q *queryRunner) evalCount(e expr, clause string) (int, error) {
+	switch e.(type) {
+	case *literal, *paramRef:
+	default:
+		return 0, invalidQuery("%s expects an integer literal or parameter", clause)
+	}
+	c, err := q.newCompiler(&scope{}, clause).compile(e)
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.eval(&env{})
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok || n < 0 {
+		return 0, invalidQuery("%s expects a non-negative integer literal or parameter", clause)
+	}
+	if n > math.MaxInt32 {
+		n = math.MaxInt32
+	}
+	return int(n), nil
+}
+
+func isOrderable(f *bq.TableFieldSchema) bool {
+	return !isRepeated(f) && f.Type != "RECORD" && f.Type != "GEOGRAPHY"
+}
+
+// outCol is an item of the select list, after * has been expanded.
+type outCol struct {
+	name  string // explicit alias or implicit name, or "" if anonymous
+	alias bool   // whether name is an explicit alias
+	ast   expr
+}
+
+func expandSelectList(stmt *selectStmt, sc *scope) ([]*outCol, error) {
+	var outs []*outCol
+	for _, item := range stmt.items {
+		if !item.star {
+			o := &outCol{ast: item.expr, name: item.alias, alias: item.alias != ""}
+			if o.name == "" {
+				o.name = implicitName(item.expr)
+			}
+			outs = append(outs, o)
+			continue
+		}
+		if stmt.from == nil {
+			return nil, invalidQuery("SELECT * must have a FROM clause")
+		}
+		var qual string
+		if len(item.qualifier) > 0 {
+			qual = strings.Join(item.qualifier, ".")
+			found := false
+			for _, c := range sc.cols {
+				found = found || strings.EqualFold(c.table, qual)
+			}
+			if !found {
+				return nil, invalidQuery("Unrecognized name: %s", qual)
+			}
+		}
+		for _, c := range sc.cols {
+			if qual != "" && !strings.EqualFold(c.table, qual) {
+				continue
+			}
+			if containsFold(item.except, c.field.Name) {
+				continue
+			}
+			outs = append(outs, &outCol{
+				name: c.field.Name,
+				ast:  &columnRef{parts: []string{c.table, c.field.Name}},
+			})
+		}
+		for _, name := range item.except {
+			if fieldIndex(scopeFields(sc), name) < 0 {
+				return nil, invalidQuery("Column %s in SELECT * EXCEPT list does not exist", name)
+			}
+		}
+	}
+	return outs, nil
+}
+
+func scopeFields(sc *scope) []*bq.TableFieldSchema {
+	fields := make([]*bq.TableFieldSchema, len(sc.cols))
+	for i, c := range sc.cols {
+		fields[i] = c.field
+	}
+	return fields
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// implicitName returns the implicit name of a select list item without an
+// alias: the last part of a column or field reference.
+func implicitName(e expr) string {
+	switch e := e.(type) {
+	case *columnRef:
+		return e.parts[len(e.parts)-1]
+	case *callExpr:
+		if e.name == "." {
+			return e.args[1].(*literal).val.(string)
+		}
+	}
+	return ""
+}
+
+// selectListIndex reports whether e names an item of the select list by
+// position or by name, as allowed in GROUP BY and ORDER BY, and returns its
+// index, or -1 if the position is out of range. If aliasOnly is set, only
+// explicit aliases are matched.
+func selectListIndex(e expr, outs []*outCol, aliasOnly bool) (int, bool) {
+	switch e := e.(type) {
+	case *literal:
+		if n, ok := e.val.(int64); ok {
+			if n < 1 || n > int64(len(outs)) {
+				return -1, true
+			}
+			return int(n - 1), true
+		}
+	case *columnRef:
+		if len(e.parts) != 1 {
+			return 0, false
+		}
+		for i, o := range outs {
+			if (o.alias || !aliasOnly) && o.name != "" && strings.EqualFold(o.name, e.parts[0]) {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// resultSchema returns the schema of the result of a select list. Anonymous
+// columns are named f0_, f1_ and so on, and duplicate names get a numeric
+// suffix.
+func resultSchema(outs []*outCol, sel []*compiled) []*bq.TableFieldSchema {
+	fields := make([]*bq.TableFieldSchema, len(outs))
+	anon := 0
+	used := map[string]bool{}
+	for i, o := range outs {
+		f := *sel[i].typ
+		if f.Type == typeNull {
+			f.Type = "INTEGER"
+		}
+		// Query results never have REQUIRED fields.
+		f = nullableField(f)
+		name := o.name
+		if name == "" {
+			name = fmt.Sprintf("f%d_", anon)
+			anon++
+		}
+		for n := 1; used[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s_%d", o.name, n)
+		}
+		used[strings.ToLower(name)] = true
+		f.Name = name
+		fields[i] = &f
+	}
+	return fields
+}
+
+// nullableField returns a copy of f in which REQUIRED fields, including nested
+// ones, are NULLABLE.
+func nullableField(f bq.TableFieldSchema) bq.TableFieldSchema {
+	if f.Mode != modeRepeated {
+		f.Mode = modeNullable
+	}
+	if len(f.Fields) > 0 {
+		fields := make([]*bq.TableFieldSchema, len(f.Fields))
+		for i, sub := range f.Fields {
+			nf := nullableField(*sub)
+			fields[i] = &nf
+		}
+		f.Fields = fields
+	}
+	return f
+}
+
+// from returns the columns and rows of a FROM clause.
+func (q *queryRunner) from(item *fromItem) (*scope, []row, error) {
+	if item == nil {
+		return &scope{}, []row{{}}, nil
+	}
+	var fields []*bq.TableFieldSchema
+	var rows []row
+	alias := item.alias
+	if item.subquery != nil {
+		res, err := q.execSelect(item.subquery)
+		if err != nil {
+			return nil, nil, err
+		}
+		fields, rows = res.schema, res.rows
+	} else {
+		ref, err := q.tableRef(item.path)
+		if err != nil {
+			return nil, nil, err
+		}
+		t, err := q.s.lookupTable(ref.ProjectId, ref.DatasetId, ref.TableId)
+		if err != nil {
+			return nil, nil, errorf(http.StatusNotFound, "Not found: Table %s:%s.%s was not found in location %s", ref.ProjectId, ref.DatasetId, ref.TableId, defaultLocation)
+		}
+		if t.meta.Type == typeView {
+			if q.depth >= maxViewDepth {
+				return nil, nil, invalidQuery("Views are nested too deeply")
+			}
+			vq := &queryRunner{
+				s:              q.s,
+				projectID:      ref.ProjectId,
+				defaultDataset: &bq.DatasetReference{ProjectId: ref.ProjectId, DatasetId: ref.DatasetId},
+				now:            q.now,
+				depth:          q.depth + 1,
+			}
+			res, err := vq.run(t.meta.View.Query)
+			if err != nil {
+				return nil, nil, err
+			}
+			fields, rows = res.schema, res.rows
+		} else {
+			fields, rows = t.schemaFields(), t.rows
+		}
+		if alias == "" {
+			alias = ref.TableId
+		}
+	}
+	sc := &scope{}
+	for _, f := range fields {
+		sc.cols = append(sc.cols, &scopeCol{table: alias, field: canonicalField(f)})
+	}
+	return sc, rows, nil
+}
+
+// tableRef resolves a table name in a query.
+func (q *queryRunner) tableRef(path []string) (*bq.TableReference, error) {
+	switch len(path) {
+	case 1:
+		if q.defaultDataset == nil || q.defaultDataset.DatasetId == "" {
+			return nil, invalidQuery("Table %q must be qualified with a dataset (e.g. dataset.table).", path[0])
+		}
+		projectID := q.defaultDataset.ProjectId
+		if projectID == "" {
+			projectID = q.projectID
+		}
+		return &bq.TableReference{ProjectId: projectID, DatasetId: q.defaultDataset.DatasetId, TableId: path[0]}, nil
+	case 2:
+		return &bq.TableReference{ProjectId: q.projectID, DatasetId: path[0], TableId: path[1]}, nil
+	case 3:
+		return &bq.TableReference{ProjectId: path[0], DatasetId: path[1], TableId: path[2]}, nil
+	}
+	return nil, invalidQuery("Invalid table name: %s", strings.Join(path, "."))
+}
+
+// compiler compiles expressions over the columns of a scope.
+type compiler struct {
+	q      *queryRunner
+	sc     *scope
+	clause string // for error messages
+	// aggs is whether aggregate functions are allowed.
+	aggs bool
+	// inAgg is set while compiling the arguments of an aggregate function.
+	inAgg bool
+	// groupCols, if not nil, holds the indexes of the grouping columns, the
+	// only columns that may be referenced outside aggregate functions.
+	groupCols map[int]bool
+	// aliases, if set, are select list items that may be referenced by
+	// alias when no column has the name, as in a HAVING clause.
+	aliases []*outCol
+}
+
+func (q *queryRunner) newCompiler(sc *scope, clause string) *compiler {
+	return &compiler{q: q, sc: sc, clause: clause}
+}
+
+// compileBool compiles an expression that must be a BOOL.
+func (c *compiler) compileBool(e expr) (*compiled, error) {
+	x, err := c.compile(e)
+	if err != nil {
+		return nil, err
+	}
+	if x.typ.Type != "BOOLEAN" && x.typ.Type != typeNull || isRepeated(x.typ) {
+		return nil, invalidQuery("%s expects a boolean expression, not %s", c.clause, sqlTypeName(x.typ))
+	}
+	return x, nil
+}
+
+func constant(typ *bq.TableFieldSchema, v interface{}) *compiled {
+	return &compiled{typ: typ, eval: func(*env) (interface{}, error) { return v, nil }, constant: true}
+}
+
+func (c *compiler) compile(e expr) (*compiled, error) {
+	switch e := e.(type) {
+	case *literal:
+		if e.typ == "" {
+			return constant(scalarType(typeNull), nil), nil
+		}
+		return constant(scalarType(e.typ), e.val), nil
+	case *columnRef:
+		return c.compileColumnRef(e)
+	case *paramRef:
+		return c.compileParam(e)
+	case *unaryExpr:
+		return c.compileUnary(e)
+	case *binaryExpr:
+		return c.compileBinary(e)
+	case *isExpr:
+		return c.compileIs(e)
+	case *inExpr:
+		return c.compileIn(e)
+	case *betweenExpr:
+		return c.compileBetween(e)
+	case *likeExpr:
+		return c.compileLike(e)
+	case *callExpr:
+		return c.compileCall(e)
+	case *castExpr:
+		return c.compileCast(e)
+	case *caseExpr:
+		return c.compileCase(e)
+	case *arrayExpr:
+		return c.compileArray(e)
+	case *extractExpr:
+		return c.compileExtract(e)
+	}
+	return nil, fmt.Errorf("bqtest: unknown expression %T", e)
+}
+
+// compileAll compiles a list of expressions.
+func (c *compiler) compileAll(es []expr) ([]*compiled, error) {
+	cs := make([]*compiled, len(es))
+	for i, e := range es {
+		var err error
+		if cs[i], err = c.compile(e); err != nil {
+			return nil, err
+		}
+	}
+	return cs, nil
+}
+
+// resolveColumn finds the column named by the first parts of ref, returning
+// its index and the number of parts used.
+func (c *compiler) resolveColumn(ref *columnRef) (int, int, error) {
+	name := strings.Join(ref.parts, ".")
+	if len(ref.parts) > 1 {
+		// A qualified name: table.column.
+		for i, col := range c.sc.cols {
+			if strings.EqualFold(col.table, ref.parts[0]) && strings.EqualFold(col.field.Name, ref.parts[1]) {
+				return i, 2, nil
+			}
+		}
+	}
+	for i, col := range c.sc.cols {
+		if strings.EqualFold(col.field.Name, ref.parts[0]) {
+			return i, 1, nil
+		}
+	}
+	if len(ref.parts) > 1 {
+		for _, col := range c.sc.cols {
+			if strings.EqualFold(col.table, ref.parts[0]) {
+				return 0, 0, invalidQuery("Name %s not found inside %s", ref.parts[1], ref.parts[0])
+			}
+		}
+	}
+	return 0, 0, invalidQuery("Unrecognized name: %s", name)
+}
+
+// topLevelColumn reports whether e is a reference to a whole column, and
+// returns its index.
+func (c *compiler) topLevelColumn(e expr) (int, bool) {
+	ref, ok := e.(*columnRef)
+	if !ok {
+		return 0, false
+	}
+	i, n, err := c.resolveColumn(ref)
+	return i, err == nil && n == len(ref.parts)
+}
+
+func (c *compiler) compileColumnRef(ref *columnRef) (*compiled, error) {
+	idx, n, err := c.resolveColumn(ref)
+	if err != nil {
+		if len(ref.parts) == 1 {
+			for _, o := range c.aliases {
+				if o.alias && strings.EqualFold(o.name, ref.parts[0]) {
+					return c.compile(o.ast)
+				}
+			}
+		}
+		return nil, err
+	}
+	if c.groupCols != nil && !c.inAgg && !c.groupCols[idx] {
+		return nil, invalidQuery("%s expression references column %s which is neither grouped nor aggregated", c.clause, c.sc.cols[idx].field.Name)
+	}
+	x := &compiled{
+		typ:  c.sc.cols[idx].field,
+		eval: func(e *env) (interface{}, error) { return e.row[idx], nil },
+	}
+	for _, name := range ref.parts[n:] {
+		if x, err = fieldAccess(x, name); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+// fieldAccess compiles access to the named field of a STRUCT.
+func fieldAccess(x *compiled, name string) (*compiled, error) {
+	if x.typ.Type != "RECORD" || isRepeated(x.typ) {
+		return nil, invalidQuery("Cannot access field %s on a value with type %s", name, sqlTypeName(x.typ))
+	}
+	i := fieldIndex(x.typ.Fields, name)
+	if i < 0 {
+		return nil, invalidQuery("Field name %s does not exist in %s", name, sqlTypeName(x.typ))
+	}
+	return &compiled{
+		typ: canonicalField(x.typ.Fields[i]),
+		eval: func(e *env) (interface{}, error) {
+			v, err := x.eval(e)
+			if v == nil || err != nil {
+				return nil, err
+			}
+			return v.([]interface{})[i], nil
+		},
+	}, nil
+}
+
+func (c *compiler) compileParam(p *paramRef) (*compiled, error) {
+	params := c.q.params
+	if params == nil {
+		params = &queryParams{}
+	}
+	var pv *paramValue
+	if p.position < 0 {
+		if params.positional != nil {
+			return nil, invalidQuery("Named parameters cannot be used with positional parameters")
+		}
+		pv = params.named[strings.ToLower(p.name)]
+		if pv == nil {
+			return nil, invalidQuery("Query parameter '%s' not found", p.name)
+		}
+	} else {
+		if params.named != nil {
+			return nil, invalidQuery("Positional parameters cannot be used with named parameters")
+		}
+		if p.position >= len(params.positional) {
+			return nil, invalidQuery("Number of positional query parameters is less than the number of positional placeholders in the query")
+		}
+		pv = params.positional[p.position]
+	}
+	return constant(pv.typ, pv.val), nil
+}
+
+func (c *compiler) compileUnary(u *unaryExpr) (*compiled, error) {
+	x, err := c.compile(u.x)
+	if err != nil {
+		return nil, err
+	}
+	t := x.typ.Type
+	switch {
+	case u.op == "NOT" && (t == "BOOLEAN" || t == typeNull) && !isRepeated(x.typ):
+		return &compiled{typ: scalarType("BOOLEAN"), eval: func(e *env) (interface{}, error) {
+			v, err := x.eval(e)
+			if v == nil || err != nil {
+				return nil, err
+			}
+			return !v.(bool), nil
+		}}, nil
+	case u.op == "+" && isNumericType(t) && !isRepeated(x.typ):
+		return x, nil
+	case u.op == "-" && isNumericType(t) && !isRepeated(x.typ):
+		return &compiled{typ: x.typ, eval: func(e *env) (interface{}, error) {
+			v, err := x.eval(e)
+			if v == nil || err != nil {
+				return nil, err
+			}
+			switch v := v.(type) {
+			case int64:
+				if v == math.MinInt64 {
+					return nil, evalError("int64 overflow: -%d", v)
+				}
+				return -v, nil
+			case float64:
+				return -v, nil
+			}
+			return new(big.Rat).Neg(v.(*big.Rat)), nil
+		}}, nil
+	case u.op == "~" && t == "INTEGER" && !isRepeated(x.typ):
+		return &compiled{typ: x.typ, eval: func(e *env) (interface{}, error) {
+			v, err := x.eval(e)
+			if v == nil || err != nil {
+				return nil, err
+			}
+			return ^v.(int64), nil
+		}}, nil
+	}
+	return nil, invalidQuery("No matching signature for operator %s for argument types: %s", u.op, sqlTypeName(x.typ))
+}
+
+// evalError returns an error that occurs while running a query.
+func evalError(format string, args ...interface{}) error {
+	return invalidQuery(format, args...)
+}
+
+func (c *compiler) compileBinary(b *binaryExpr) (*compiled, error) {
+	l, err := c.compile(b.l)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.compile(b.r)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "AND", "OR":
+		for _, x := range []*compiled{l, r} {
+			if (x.typ.Type != "BOOLEAN" && x.typ.Type != typeNull) || isRepeated(x.typ) {
+				return nil, invalidQuery("No matching signature for operator %s for argument types: %s", b.op, typeNames([]*compiled{l, r}))
+			}
+		}
+		and := b.op == "AND"
+		return &compiled{typ: scalarType("BOOLEAN"), eval: func(e *env) (interface{}, error) {
+			lv, err := l.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			// Short-circuit when the result is known.
+			if lv == !and {
+				return lv, nil
+			}
+			rv, err := r.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case rv == !and:
+				return rv, nil
+			case lv == nil || rv == nil:
+				return nil, nil
+			}
+			return and, nil
+		}}, nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		return c.compileComparison(b.op, l, r)
+	case "+", "-", "*", "/":
+		return compileArithmetic(b.op, l, r)
+	case "||":
+		return compileConcat([]*compiled{l, r}, "||")
+	case "&", "|", "^", "<<", ">>":
+		if l.typ.Type != "INTEGER" && l.typ.Type != typeNull || r.typ.Type != "INTEGER" && r.typ.Type != typeNull || isRepeated(l.typ) || isRepeated(r.typ) {
+			return nil, invalidQuery("No matching signature for operator %s for argument types: %s", b.op, typeNames([]*compiled{l, r}))
+		}
+		op := b.op
+		return &compiled{typ: scalarType("INTEGER"), eval: func(e *env) (interface{}, error) {
+			vs, err := evalArgs(e, l, r)
+			if vs == nil || err != nil {
+				return nil, err
+			}
+			x, y := vs[0].(int64), vs[1].(int64)
+			switch op {
+			case "&":
+				return x & y, nil
+			case "|":
+				return x | y, nil
+			case "^":
+				return x ^ y, nil
+			case "<<":
+				if y < 0 {
+					return nil, evalError("Bit shift by a negative amount")
+				}
+				if y >= 64 {
+					return int64(0), nil
+				}
+				return x << uint(y), nil
+			}
+			if y < 0 {
+				return nil, evalError("Bit shift by a negative amount")
+			}
+			if y >= 64 {
+				return int64(0), nil
+			}
+			return int64(uint64(x) >> uint(y)), nil
+		}}, nil
+	}
+	return nil, fmt.Errorf("bqtest: unknown operator %s", b.op)
+}
+
+// evalArgs evaluates the arguments of an operator or function that returns
+// NULL if any argument is NULL. It returns nil in that case.
+func evalArgs(e *env, args ...*compiled) ([]interface{}, error) {
+	vs := make([]interface{}, len(args))
+	for i, a := range args {
+		v, err := a.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, nil
+		}
+		vs[i] = v
+	}
+	return vs, nil
+}
+
+// coerceLiteral converts x, a constant STRING compared with a date or time
+// value, to the type of that value, as the service does for literals.
+func coerceLiteral(x, to *compiled) (*compiled, error) {
+	switch to.typ.Type {
+	case "DATE", "TIME", "DATETIME", "TIMESTAMP":
+	default:
+		return x, nil
+	}
+	if x.typ.Type != "STRING" || isRepeated(to.typ) {
+		return x, nil
+	}
+	v, err := x.eval(&env{})
+	if err != nil {
+		return x, nil
+	}
+	if v == nil {
+		return constant(to.typ, nil), nil
+	}
+	cv, err := parseString(to.typ.Type, v.(string))
+	if err != nil {
+		return nil, invalidQuery("Could not cast literal %q to type %s", v, sqlTypeName(to.typ))
+	}
+	return constant(to.typ, cv), nil
+}
+
+// comparable reports whether values of two types can be compared.
+func comparable(a, b *bq.TableFieldSchema) bool {
+	if a.Type == typeNull || b.Type == typeNull {
+		return true
+	}
+	if isRepeated(a) || isRepeated(b) {
+		return false
+	}
+	if isNumericType(a.Type) && isNumericType(b.Type) {
+		return true
+	}
+	return a.Type == b.Type && a.Type != "RECORD" && a.Type != "GEOGRAPHY"
+}
+
+func (c *compiler) compileComparison(op string, l, r *compiled) (*compiled, error) {
+	l, r, err := coercePair(l, r)
+	if err != nil {
+		return nil, err
+	}
+	if !comparable(l.typ, r.typ) {
+		return nil, invalidQuery("No matching signature for operator %s for argument types: %s", op, typeNames([]*compiled{l, r}))
+	}
+	return &compiled{typ: scalarType("BOOLEAN"), eval: func(e *env) (interface{}, error) {
+		vs, err := evalArgs(e, l, r)
+		if vs == nil || err != nil {
+			return nil, err
+		}
+		return compareOp(op, vs[0], vs[1]), nil
+	}}, nil
+}
+
+// coercePair coerces a constant STRING compared with a date or time value to
+// the type of that value.
+func coercePair(l, r *compiled) (*compiled, *compiled, error) {
+	var err error
+	if l.typ.Type == "STRING" && l.constant {
+		if l, err = coerceLiteral(l, r); err != nil {
+			return nil, nil, err
+		}
+	}
+	if r.typ.Type == "STRING" && r.constant {
+		if r, err = coerceLiteral(r, l); err != nil {
+			return nil, nil, err
+		}
+	}
+	return l, r, nil
+}
+
+func compareOp(op string, a, b interface{}) bool {
+	if fa, ok := a.(float64); ok && math.IsNaN(fa) {
+		return op == "!="
+	}
+	if fb, ok := b.(float64); ok && math.IsNaN(fb) {
+		return op == "!="
+	}
+	c := compareValues(a, b)
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// numericResultType returns the type of an arithmetic operation on values of
+// types a and b.
+func numericResultType(a, b string) string {
+	switch {
+	case a == typeNull && b == typeNull:
+		return "INTEGER"
+	case a == typeNull:
+		return b
+	case b == typeNull:
+		return a
+	case a == "FLOAT" || b == "FLOAT":
+		return "FLOAT"
+	case a == "BIGNUMERIC" || b == "BIGNUMERIC":
+		return "BIGNUMERIC"
+	case a == "NUMERIC" || b == "NUMERIC":
+		return "NUMERIC"
+	}
+	return "INTEGER"
+}
+
+func compileArithmetic(op string, l, r *compiled) (*compiled, error) {
+	for _, x := range []*compiled{l, r} {
+		if (!isNumericType(x.typ.Type) && x.typ.Type != typeNull) || isRepeated(x.typ) {
+			return nil, invalidQuery("No matching signature for operator %s for argument types: %s", op, typeNames([]*compiled{l, r}))
+		}
+	}
+	typ := numericResultType(l.typ.Type, r.typ.Type)
+	if op == "/" && typ == "INTEGER" {
+		typ = "FLOAT"
+	}
+	return &compiled{typ: scalarType(typ), eval: func(e *env) (interface{}, error) {
+		vs, err := evalArgs(e, l, r)
+		if vs == nil || err != nil {
+			return nil, err
+		}
+		return arith(op, typ, vs[0], vs[1])
+	}}, nil
+}
+
+// arith applies an arithmetic operator to two numbers, with a result of type
+// typ.
+func arith(op, typ string, a, b interface{}) (interface{}, error) {
+	switch typ {
+	case "INTEGER":
+		x, y := a.(int64), b.(int64)
+		var z int64
+		switch op {
+		case "+":
+			z = x + y
+			if (z > x) != (y > 0) {
+				return nil, evalError("int64 overflow: %d + %d", x, y)
+			}
+		case "-":
+			z = x - y
+			if (z < x) != (y > 0) {
+				return nil, evalError("int64 overflow: %d - %d", x, y)
+			}
+		case "*":
+			z = x * y
+			if x != 0 && (z/x != y || (x == -1 && y == math.MinInt64)) {
+				return nil, evalError("int64 overflow: %d * %d", x, y)
+			}
+		}
+		return z, nil
+	case "FLOAT":
+		x, y := toFloat(a), toFloat(b)
+		switch op {
+		case "+":
+			return x + y, nil
+		case "-":
+			return x - y, nil
+		case "*":
+			return x * y, nil
+		}
+		if y == 0 {
+			return nil, evalError("division by zero: %s / %s", formatFloat(x), formatFloat(y))
+		}
+		return x / y, nil
+	}
+	x, y := toRat(a), toRat(b)
+	z := new(big.Rat)
+	switch op {
+	case "+":
+		z.Add(x, y)
+	case "-":
+		z.Sub(x, y)
+	case "*":
+		z.Mul(x, y)
+	case "/":
+		if y.Sign() == 0 {
+			return nil, evalError("division by zero: %s / %s", formatNumeric(typ, x), formatNumeric(typ, y))
+		}
+		z.Quo(x, y)
+	}
+	res, err := roundNumeric(typ, z)
+	if err != nil {
+		return nil, evalError("%v", err)
+	}
+	return res, nil
+}
+
+// compileConcat compiles || or CONCAT, which join STRING or BYTES values.
+func compileConcat(args []*compiled, name string) (*compiled, error) {
+	typ := ""
+	for _, a := range args {
+		t := a.typ.Type
+		if isRepeated(a.typ) || (t != "STRING" && t != "BYTES" && t != typeNull) || (typ != "" && t != typeNull && t != typ) {
+			return nil, invalidQuery("No matching signature for %s for argument types: %s", name, typeNames(args))
+		}
+		if t != typeNull {
+			typ = t
+		}
+	}
+	if typ == "" {
+		typ = "STRING"
+	}
+	return &compiled{typ: scalarType(typ), eval: func(e *env) (interface{}, error) {
+		vs, err := evalArgs(e, args...)
+		if vs == nil || err != nil {
+			return nil, err
+		}
+		if typ == "BYTES" {
+			var b []byte
+			for _, v := range vs {
+				b = append(b, v.([]byte)...)
+			}
+			return b, nil
+		}
+		var sb strings.Builder
+		for _, v := range vs {
+			sb.WriteString(v.(string))
+		}
+		return sb.String(), nil
+	}}, nil
+}
+
+func (c *compiler) compileIs(is *isExpr) (*compiled, error) {
+	x, err := c.compile(is.x)
+	if err != nil {
+		return nil, err
+	}
+	if is.val != nil && (x.typ.Type != "BOOLEAN" && x.typ.Type != typeNull || isRepeated(x.typ)) {
+		return nil, invalidQuery("No matching signature for operator IS %v for argument types: %s", strings.ToUpper(fmt.Sprint(is.val)), sqlTypeName(x.typ))
+	}
+	return &compiled{typ: scalarType("BOOLEAN"), eval: func(e *env) (interface{}, error) {
+		v, err := x.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		return (v == is.val) != is.not, nil
+	}}, nil
+}
+
+func (c *compiler) compileIn(in *inExpr) (*compiled, error) {
+	x, err := c.compile(in.x)
+	if err != nil {
+		return nil, err
+	}
+	var elems func(*env) ([]interface{}, error)
+	if in.unnest != nil {
+		arr, err := c.compile(in.unnest)
+		if err != nil {
+			return nil, err
+		}
+		if !isRepeated(arr.typ) && arr.typ.Type != typeNull {
+			return nil, invalidQuery("Values referenced in UNNEST must be arrays. UNNEST contains expression of type %s", sqlTypeName(arr.typ))
+		}
+		if !comparable(x.typ, elemType(arr.typ)) {
+			return nil, invalidQuery("No matching signature for operator IN UNNEST for argument types: %s, %s", sqlTypeName(x.typ), sqlTypeName(arr.typ))
+		}
+		elems = func(e *env) ([]interface{}, error) {
+			v, err := arr.eval(e)
+			if v == nil || err != nil {
+				return nil, err
+			}
+			return v.([]interface{}), nil
+		}
+	} else {
+		list, err := c.compileAll(in.list)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list {
+			if _, list[i], err = coercePair(x, list[i]); err != nil {
+				return nil, err
+			}
+			if !comparable(x.typ, list[i].typ) {
+				return nil, invalidQuery("No matching signature for operator IN for argument types: %s", typeNames(append([]*compiled{x}, list...)))
+			}
+		}
+		elems = func(e *env) ([]interface{}, error) {
+			vs := make([]interface{}, len(list))
+			for i, l := range list {
+				var err error
+				if vs[i], err = l.eval(e); err != nil {
+					return nil, err
+				}
+			}
+			return vs, nil
+		}
+	}
+	return &compiled{typ: scalarType("BOOLEAN"), eval: func(e *env) (interface{}, error) {
+		v, err := x.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		vs, err := elems(e)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			if len(vs) == 0 {
+				return in.not, nil
+			}
+			return nil, nil
+		}
+		sawNull := false
+		for _, ev := range vs {
+			if ev == nil {
+				sawNull = true
+				continue
+			}
+			if compareOp("=", v, ev) {
+				return !in.not, nil
+			}
+		}
+		if sawNull {
+			return nil, nil
+		}
+		return in.not, nil
+	}}, nil
+}
+
+func (c *compiler) compileBetween(b *betweenExpr) (*compiled, error) {
+	args, err := c.compileAll([]expr{b.x, b.lo, b.hi})
+	if err != nil {
+		return nil, err
+	}
+	x := args[0]
+	for i := 1; i < 3; i++ {
+		if _, args[i], err = coercePair(x, args[i]); err != nil {
+			return nil, err
+		}
+		if !comparable(x.typ, args[i].typ) {
+			return nil, invalidQuery("No matching signature for operator BETWEEN for argument types: %s", typeNames(args))
+		}
+	}
+	return &compiled{typ: scalarType("BOOLEAN"), eval: func(e *env) (interface{}, error) {
+		vs, err := evalArgs(e, args...)
+		if vs == nil || err != nil {
+			return nil, err
+		}
+		in := compareOp(">=", vs[0], vs[1]) && compareOp("<=", vs[0], vs[2])
+		return in != b.not, nil
+	}}, nil
+}
+
+func (c *compiler) compileLike(l *likeExpr) (*compiled, error) {
+	args, err := c.compileAll([]expr{l.x, l.pattern})
+	if err != nil {
+		return nil, err
+	}
+	typ := ""
+	for _, a := range args {
+		t := a.typ.Type
+		if isRepeated(a.typ) || (t != "STRING" && t != "BYTES" && t != typeNull) || (typ != "" && t != typeNull && t != typ) {
+			return nil, invalidQuery("No matching signature for operator LIKE for argument types: %s", typeNames(args))
+		}
+		if t != typeNull {
+			typ = t
+		}
+	}
+	cache := map[string]*regexp.Regexp{}
+	return &compiled{typ: scalarType("BOOLEAN"), eval: func(e *env) (interface{}, error) {
+		vs, err := evalArgs(e, args...)
+		if vs == nil || err != nil {
+			return nil, err
+		}
+		s, pat := toText(vs[0]), toText(vs[1])
+		re := cache[pat]
+		if re == nil {
+			if re, err = likeRegexp(pat); err != nil {
+				return nil, err
+			}
+			cache[pat] = re
+		}
+		return re.MatchString(s) != l.not, nil
+	}}, nil
+}
+
+// toText returns a STRING or BYTES value as a string.
+func toText(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v.(string)
+}
+
+// likeRegexp converts a LIKE pattern to a regular expression. % matches any
+// sequence of characters, _ matches a single character and \ escapes the
+// following character.
+func likeRegexp(pat string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	for i := 0; i < len(pat); i++ {
+		switch c := pat[i]; c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		case '\\':
+			if i+1 == len(pat) {
+				return nil, evalError("LIKE pattern ends with a backslash")
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(pat[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(pat[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// supertype returns the common type of the given types, to which all of
+// them can be coerced, as for the results of CASE and COALESCE.
+func supertype(name string, args []*compiled) (*bq.TableFieldSchema, error) {
+	var typ *bq.TableFieldSchema
+	for _, a := range args {
+		switch {
+		case a.typ.Type == typeNull:
+			continue
+		case typ == nil:
+			typ = a.typ
+		case isNumericType(typ.Type) && isNumericType(a.typ.Type) && !isRepeated(typ) && !isRepeated(a.typ):
+			typ = scalarType(numericResultType(typ.Type, a.typ.Type))
+		case sqlTypeName(typ) != sqlTypeName(a.typ):
+			return nil, invalidQuery("No matching signature for %s for argument types: %s", name, typeNames(args))
+		}
+	}
+	if typ == nil {
+		return scalarType(typeNull), nil
+	}
+	return typ, nil
+}
+
+// coerce converts a value to a supertype of its type.
+func coerce(v interface{}, typ *bq.TableFieldSchema) interface{} {
+	if v == nil || isRepeated(typ) {
+		return v
+	}
+	switch typ.Type {
+	case "FLOAT":
+		return toFloat(v)
+	case "NUMERIC", "BIGNUMERIC":
+		if i, ok := v.(int64); ok {
+			return new(big.Rat).SetInt64(i)
+		}
+	}
+	return v
+}
+
+func (c *compiler) compileCase(ce *caseExpr) (*compiled, error) {
+	var operand *compiled
+	var err error
+	if ce.operand != nil {
+		if operand, err = c.compile(ce.operand); err != nil {
+			return nil, err
+		}
+	}
+	var conds, results []*compiled
+	for _, w := range ce.whens {
+		var cond *compiled
+		if operand != nil {
+			x, err := c.compile(w.cond)
+			if err != nil {
+				return nil, err
+			}
+			if cond, err = c.compileComparison("=", operand, x); err != nil {
+				return nil, err
+			}
+		} else if cond, err = c.compileBool(w.cond); err != nil {
+			return nil, err
+		}
+		res, err := c.compile(w.result)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+		results = append(results, res)
+	}
+	if ce.els != nil {
+		els, err := c.compile(ce.els)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, els)
+	}
+	typ, err := supertype("CASE", results)
+	if err != nil {
+		return nil, err
+	}
+	return &compiled{typ: typ, eval: func(e *env) (interface{}, error) {
+		for i, cond := range conds {
+			v, err := cond.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			if v == true {
+				v, err := results[i].eval(e)
+				return coerce(v, typ), err
+			}
+		}
+		if len(results) > len(conds) {
+			v, err := results[len(conds)].eval(e)
+			return coerce(v, typ), err
+		}
+		return nil, nil
+	}}, nil
+}
+
+func (c *compiler) compileArray(a *arrayExpr) (*compiled, error) {
+	elems, err := c.compileAll(a.elems)
+	if err != nil {
+		return nil, err
+	}
+	for _, el := range elems {
+		if isRepeated(el.typ) {
+			return nil, invalidQuery("Cannot construct array with element type %s because nested arrays are not supported", sqlTypeName(el.typ))
+		}
+	}
+	typ, err := supertype("ARRAY", elems)
+	if err != nil {
+		return nil, err
+	}
+	if typ.Type == typeNull {
+		typ = scalarType("INTEGER")
+	}
+	return &compiled{typ: arrayType(typ), eval: func(e *env) (interface{}, error) {
+		vs := make([]interface{}, len(elems))
+		for i, el := range elems {
+			v, err := el.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			vs[i] = coerce(v, typ)
+		}
+		return vs, nil
+	}}, nil
+}
+
+// hasAggregate reports whether e contains a call to an aggregate function.
+func hasAggregate(e expr) bool {
+	found := false
+	walkExpr(e, func(e expr) {
+		if call, ok := e.(*callExpr); ok && aggregateFuncs[call.name] != nil {
+			found = true
+		}
+	})
+	return found
+}
+
+// walkExpr calls f for e and each of its subexpressions.
+func walkExpr(e expr, f func(expr)) {
+	if e == nil {
+		return
+	}
+	f(e)
+	switch e := e.(type) {
+	case *unaryExpr:
+		walkExpr(e.x, f)
+	case *binaryExpr:
+		walkExpr(e.l, f)
+		walkExpr(e.r, f)
+	case *isExpr:
+		walkExpr(e.x, f)
+	case *inExpr:
+		walkExpr(e.x, f)
+		walkExpr(e.unnest, f)
+		for _, x := range e.list {
+			walkExpr(x, f)
+		}
+	case *betweenExpr:
+		walkExpr(e.x, f)
+		walkExpr(e.lo, f)
+		walkExpr(e.hi, f)
+	case *likeExpr:
+		walkExpr(e.x, f)
+		walkExpr(e.pattern, f)
+	case *callExpr:
+		for _, x := range e.args {
+			walkExpr(x, f)
+		}
+	case *castExpr:
+		walkExpr(e.x, f)
+	case *caseExpr:
+		walkExpr(e.operand, f)
+		for _, w := range e.whens {
+			walkExpr(w.cond, f)
+			walkExpr(w.result, f)
+		}
+		walkExpr(e.els, f)
+	case *arrayExpr:
+		for _, x := range e.elems {
+			walkExpr(x, f)
+		}
+	case *extractExpr:
+		walkExpr(e.x, f)
+	}
+}
@@ -0,0 +1,452 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bqtest provides a fake BigQuery REST API server for testing. It
+// implements a simplified form of the service that keeps all datasets, tables,
+// rows and jobs in memory, suitable for unit tests of code that uses
+// cloud.google.com/go/bigquery. It may behave differently from the actual
+// service in ways in which the service is unspecified, and it does not
+// implement IAM, partitioning, clustering, encryption, models, routines or the
+// Storage APIs.
+//
+// The server supports dataset and table create, get, update, list and delete;
+// streaming inserts (Inserter.Put) and table reads (Table.Read); and jobs.
+// Jobs run synchronously and are complete as soon as they are created:
+//
+//   - Query jobs run GoogleSQL SELECT statements over a subset of the
+//     language: a single table, view or subquery in FROM; WHERE; GROUP BY
+//     and HAVING with the common aggregate functions; ORDER BY; LIMIT and
+//     OFFSET; DISTINCT; named and positional query parameters; CAST, CASE,
+//     LIKE, IN, BETWEEN and a set of simple scalar functions. Joins, UNNEST
+//     in FROM, window functions, DDL, DML and scripting are not supported.
+//   - Load jobs read CSV or newline-delimited JSON, either uploaded with the
+//     request (a ReaderSource) or from a Cloud Storage URI.
+//   - Extract jobs write CSV or newline-delimited JSON to Cloud Storage URIs.
+//   - Copy jobs copy tables within the server.
+//
+// The server does not talk to Cloud Storage. Instead it keeps its own
+// in-memory set of objects, keyed by gs:// URI, that load jobs read from and
+// extract jobs write to. Use PutObject and Object to populate and inspect it.
+//
+// This package is EXPERIMENTAL and is subject to change without notice.
+//
+// See the example for usage.
+package bqtest
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+// defaultLocation is the location of datasets and jobs that do not specify
+// one.
+const defaultLocation = "US"
+
+// Server is a fake BigQuery server.
+type Server struct {
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	datasets    map[string]*dataset // keyed by "project:dataset"
+	jobs        map[string]*job     // keyed by "project:job"
+	jobOrder    []*job
+	uploads     map[string]*upload
+	objects     map[string][]byte
+	lastEtag    int64
+	nextID      int
+	timeNowFunc func() time.Time
+}
+
+// NewServer creates a new fake server running in the current process. It
+// listens on a local address chosen by the system.
+func NewServer() *Server {
+	s := &Server{
+		datasets:    map[string]*dataset{},
+		jobs:        map[string]*job{},
+		uploads:     map[string]*upload{},
+		objects:     map[string][]byte{},
+		timeNowFunc: time.Now,
+	}
+	s.srv = httptest.NewServer(s)
+	return s
+}
+
+// URL returns the base URL of the server, e.g. "http://127.0.0.1:1234".
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Endpoint returns the REST API endpoint of the server. It can be passed to
+// bigquery.NewClient with option.WithEndpoint.
+func (s *Server) Endpoint() string {
+	return s.srv.URL + "/bigquery/v2/"
+}
+
+// ClientOptions returns the options needed for bigquery.NewClient to connect
+// to the server.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.Endpoint()),
+		option.WithoutAuthentication(),
+	}
+}
+
+// SetTimeNowFunc registers f as a function to be used instead of time.Now
+// for this server. It determines resource timestamps and the value of
+// CURRENT_TIMESTAMP and related functions in queries.
+func (s *Server) SetTimeNowFunc(f func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeNowFunc = f
+}
+
+// PutObject stores data as the contents of the Cloud Storage object with the
+// given URI, of the form "gs://bucket/name", for load jobs to read.
+func (s *Server) PutObject(uri string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[uri] = append([]byte(nil), data...)
+}
+
+// Object returns the contents of the Cloud Storage object with the given
+// URI, as written by an extract job or PutObject, and whether it exists.
+func (s *Server) Object(uri string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[uri]
+	return append([]byte(nil), data...), ok
+}
+
+// Close shuts down the server and blocks until all outstanding requests on
+// the server have completed.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// ServeHTTP implements http.Handler. Requests are routed by path to the REST
+// API (/bigquery/v2/...) or the upload API for load jobs
+// (/upload/bigquery/v2/...).
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segs, err := splitPath(r.URL.EscapedPath())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	switch {
+	case hasPrefix(segs, "upload", "bigquery", "v2"):
+		s.handleUpload(w, r, segs[3:])
+	case hasPrefix(segs, "bigquery", "v2"):
+		resp, err := s.handleJSON(r, segs[2:])
+		writeResponse(w, resp, err)
+	default:
+		writeError(w, errNotFound(r))
+	}
+}
+
+// handleJSON dispatches a REST API request. segs is the request path after
+// "/bigquery/v2".
+func (s *Server) handleJSON(r *http.Request, segs []string) (interface{}, error) {
+	if len(segs) < 3 || segs[0] != "projects" {
+		return nil, errNotFound(r)
+	}
+	projectID := segs[1]
+	switch segs[2] {
+	case "datasets":
+		return s.handleDatasets(r, projectID, segs[3:])
+	case "jobs":
+		return s.handleJobs(r, projectID, segs[3:])
+	case "queries":
+		return s.handleQueries(r, projectID, segs[3:])
+	}
+	return nil, errNotFound(r)
+}
+
+// now returns the current time in milliseconds since the epoch, the format
+// of resource timestamps. s.mu must be held.
+func (s *Server) now() int64 {
+	return s.timeNowFunc().UnixNano() / 1e6
+}
+
+// newEtag returns a new resource ETag. s.mu must be held.
+func (s *Server) newEtag() string {
+	s.lastEtag++
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(s.lastEtag))
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// newID returns a new identifier for a job or upload. s.mu must be held.
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s_%d", prefix, s.nextID)
+}
+
+// checkEtag enforces an If-Match request header against the current ETag of
+// a resource.
+func checkEtag(r *http.Request, etag string) error {
+	m := r.Header.Get("If-Match")
+	if m == "" || m == "*" || m == etag {
+		return nil
+	}
+	return errorf(http.StatusPreconditionFailed, "Precondition check failed.")
+}
+
+// httpError is an error with an HTTP status code, reported to clients in the
+// REST API error format.
+type httpError struct {
+	code   int
+	reason string
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("bqtest: %d %s", e.code, e.msg)
+}
+
+func errorf(code int, format string, args ...interface{}) *httpError {
+	return &httpError{code: code, reason: errorReason(code), msg: fmt.Sprintf(format, args...)}
+}
+
+// invalidQuery returns the error reported for a query that cannot be parsed
+// or run.
+func invalidQuery(format string, args ...interface{}) *httpError {
+	e := errorf(http.StatusBadRequest, format, args...)
+	e.reason = "invalidQuery"
+	return e
+}
+
+func errNotFound(r *http.Request) error {
+	return errorf(http.StatusNotFound, "Not Found: %s", r.URL.Path)
+}
+
+func errMethod(r *http.Request) error {
+	return errorf(http.StatusMethodNotAllowed, "method %s not supported for %s", r.Method, r.URL.Path)
+}
+
+func writeResponse(w http.ResponseWriter, resp interface{}, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, resp interface{}) {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, errorf(http.StatusInternalServerError, "marshaling response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	herr, ok := err.(*httpError)
+	if !ok {
+		herr = errorf(http.StatusInternalServerError, "%v", err)
+	}
+	type errorItem struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason,omitempty"`
+	}
+	var body struct {
+		Error struct {
+			Code    int         `json:"code"`
+			Message string      `json:"message"`
+			Errors  []errorItem `json:"errors"`
+		} `json:"error"`
+	}
+	body.Error.Code = herr.code
+	body.Error.Message = herr.msg
+	body.Error.Errors = []errorItem{{Message: herr.msg, Reason: herr.reason}}
+	b, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(herr.code)
+	w.Write(b)
+}
+
+func errorReason(code int) string {
+	switch code {
+	case http.StatusBadRequest:
+		return "invalid"
+	case http.StatusNotFound:
+		return "notFound"
+	case http.StatusConflict:
+		return "duplicate"
+	case http.StatusPreconditionFailed:
+		return "conditionNotMet"
+	}
+	return ""
+}
+
+// splitPath splits an escaped URL path into unescaped segments.
+func splitPath(p string) ([]string, error) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil, nil
+	}
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		u, err := url.PathUnescape(seg)
+		if err != nil {
+			return nil, errorf(http.StatusBadRequest, "invalid path: %v", err)
+		}
+		segs[i] = u
+	}
+	return segs, nil
+}
+
+func hasPrefix(segs []string, prefix ...string) bool {
+	if len(segs) < len(prefix) {
+		return false
+	}
+	for i, p := range prefix {
+		if segs[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeBody decodes the JSON request body into v. Numbers are decoded as
+// json.Number so that INT64 values keep their precision.
+func decodeBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// int64Param returns the value of the named int64 query parameter, and
+// whether it was present.
+func int64Param(params url.Values, name string) (int64, bool, error) {
+	v := params.Get(name)
+	if v == "" {
+		return 0, false, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false, errorf(http.StatusBadRequest, "invalid value %q for parameter %s", v, name)
+	}
+	return n, true, nil
+}
+
+// boolParam returns the value of the named boolean query parameter.
+func boolParam(params url.Values, name string) bool {
+	b, _ := strconv.ParseBool(params.Get(name))
+	return b
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to the JSON encoding of
+// target and decodes the result into out. This matches the semantics of the
+// REST API's patch methods: fields missing from the patch are unchanged, null
+// fields are cleared and nested objects such as labels are merged.
+func mergePatch(target interface{}, patch []byte, out interface{}) error {
+	tb, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(tb, &doc); err != nil {
+		return err
+	}
+	p := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	mergeMaps(doc, p)
+	mb, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(mb, out); err != nil {
+		return errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		if sm, ok := v.(map[string]interface{}); ok {
+			dm, ok := dst[k].(map[string]interface{})
+			if !ok {
+				dm = map[string]interface{}{}
+				dst[k] = dm
+			}
+			mergeMaps(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// maxPageSize is the largest number of resources or rows returned in a
+// single page.
+const maxPageSize = 1000
+
+// pageBounds returns the slice bounds of the page of n results starting at
+// the startIndex or pageToken parameter, and the token of the next page. An
+// explicit maxResults of zero returns an empty page.
+func pageBounds(params url.Values, n int) (start, end int, nextToken string, err error) {
+	if tok := params.Get("pageToken"); tok != "" {
+		start, err = strconv.Atoi(tok)
+		if err != nil || start < 0 || start > n {
+			return 0, 0, "", errorf(http.StatusBadRequest, "invalid page token %q", tok)
+		}
+	} else if si, ok, err := int64Param(params, "startIndex"); err != nil {
+		return 0, 0, "", err
+	} else if ok {
+		if si < 0 {
+			return 0, 0, "", errorf(http.StatusBadRequest, "invalid startIndex %d", si)
+		}
+		start = int(si)
+		if start > n {
+			start = n
+		}
+	}
+	pageSize := maxPageSize
+	if ms, ok, err := int64Param(params, "maxResults"); err != nil {
+		return 0, 0, "", err
+	} else if ok && ms >= 0 && ms < int64(pageSize) {
+		pageSize = int(ms)
+	}
+	end = start + pageSize
+	if end >= n {
+		return start, n, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
@@ -0,0 +1,621 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"cloud.google.com/go/internal/testutil"
+	"golang.org/x/xerrors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	testProject = "proj"
+	testDataset = "ds"
+)
+
+func newTestClient(ctx context.Context, t *testing.T) (*bigquery.Client, *Server, func()) {
+	t.Helper()
+	srv := NewServer()
+	client, err := bigquery.NewClient(ctx, testProject, srv.ClientOptions()...)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return client, srv, func() {
+		client.Close()
+		srv.Close()
+	}
+}
+
+func errCode(err error) int {
+	var e *googleapi.Error
+	if xerrors.As(err, &e) {
+		return e.Code
+	}
+	return 0
+}
+
+func readRows(t *testing.T, it *bigquery.RowIterator) [][]bigquery.Value {
+	t.Helper()
+	var rows [][]bigquery.Value
+	for {
+		var r []bigquery.Value
+		err := it.Next(&r)
+		if err == iterator.Done {
+			return rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, r)
+	}
+}
+
+var peopleSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "age", Type: bigquery.IntegerFieldType},
+	{Name: "city", Type: bigquery.StringFieldType},
+	{Name: "score", Type: bigquery.FloatFieldType},
+}
+
+type person struct {
+	Name  string
+	Age   bigquery.NullInt64
+	City  bigquery.NullString
+	Score float64
+}
+
+// newPeopleTable creates a dataset and a table of people for queries.
+func newPeopleTable(ctx context.Context, t *testing.T, client *bigquery.Client) *bigquery.Table {
+	t.Helper()
+	ds := client.Dataset(testDataset)
+	if err := ds.Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	table := ds.Table("people")
+	if err := table.Create(ctx, &bigquery.TableMetadata{Schema: peopleSchema}); err != nil {
+		t.Fatal(err)
+	}
+	people := []*person{
+		{Name: "alice", Age: bigquery.NullInt64{Int64: 30, Valid: true}, City: bigquery.NullString{StringVal: "paris", Valid: true}, Score: 1.5},
+		{Name: "bob", Age: bigquery.NullInt64{Int64: 25, Valid: true}, City: bigquery.NullString{StringVal: "london", Valid: true}, Score: 2},
+		{Name: "carol", Age: bigquery.NullInt64{Int64: 35, Valid: true}, City: bigquery.NullString{StringVal: "paris", Valid: true}, Score: 3.5},
+		{Name: "dave", City: bigquery.NullString{StringVal: "rome", Valid: true}, Score: 4},
+		{Name: "eve", Age: bigquery.NullInt64{Int64: 25, Valid: true}, Score: 5},
+	}
+	if err := table.Inserter().Put(ctx, people); err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestDatasets(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+
+	ds := client.Dataset("ds1")
+	if err := ds.Create(ctx, &bigquery.DatasetMetadata{Description: "d", Labels: map[string]string{"a": "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Create(ctx, nil); errCode(err) != http.StatusConflict {
+		t.Errorf("creating existing dataset: got %v, want 409", err)
+	}
+	if err := client.Dataset("ds2").Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	md, err := ds.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Description != "d" || md.Labels["a"] != "1" || md.Location != "US" || md.ETag == "" {
+		t.Errorf("got metadata %+v", md)
+	}
+
+	var dmu bigquery.DatasetMetadataToUpdate
+	dmu.Name = "friendly"
+	dmu.SetLabel("b", "2")
+	dmu.DeleteLabel("a")
+	md2, err := ds.Update(ctx, dmu, md.ETag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md2.Name != "friendly" || md2.Description != "d" || md2.ETag == md.ETag {
+		t.Errorf("got updated metadata %+v", md2)
+	}
+	if diff := testutil.Diff(md2.Labels, map[string]string{"b": "2"}); diff != "" {
+		t.Errorf("labels: got=-, want=+:\n%s", diff)
+	}
+	if _, err := ds.Update(ctx, dmu, md.ETag); errCode(err) != http.StatusPreconditionFailed {
+		t.Errorf("update with stale etag: got %v, want 412", err)
+	}
+
+	var ids []string
+	it := client.Datasets(ctx)
+	it.Filter = "labels.b:2"
+	for {
+		d, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, d.DatasetID)
+	}
+	if diff := testutil.Diff(ids, []string{"ds1"}); diff != "" {
+		t.Errorf("datasets: got=-, want=+:\n%s", diff)
+	}
+
+	if err := ds.Table("t").Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Delete(ctx); errCode(err) != http.StatusBadRequest {
+		t.Errorf("deleting non-empty dataset: got %v, want 400", err)
+	}
+	if err := ds.DeleteWithContents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Metadata(ctx); errCode(err) != http.StatusNotFound {
+		t.Errorf("getting deleted dataset: got %v, want 404", err)
+	}
+}
+
+func TestTables(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+
+	ds := client.Dataset(testDataset)
+	if err := ds.Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	schema := bigquery.Schema{
+		{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+		{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+		{Name: "loc", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "lat", Type: bigquery.FloatFieldType},
+			{Name: "lng", Type: bigquery.FloatFieldType},
+		}},
+		{Name: "when", Type: bigquery.TimestampFieldType},
+		{Name: "day", Type: bigquery.DateFieldType},
+		{Name: "amount", Type: bigquery.NumericFieldType},
+		{Name: "data", Type: bigquery.BytesFieldType},
+	}
+	table := ds.Table("t")
+	if err := table.Create(ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Create(ctx, &bigquery.TableMetadata{Schema: schema}); errCode(err) != http.StatusConflict {
+		t.Errorf("creating existing table: got %v, want 409", err)
+	}
+
+	when := time.Date(2021, 3, 4, 5, 6, 7, 8000, time.UTC)
+	day := civil.Date{Year: 2021, Month: 3, Day: 4}
+	rows := []*bigquery.ValuesSaver{
+		{Schema: schema, InsertID: "1", Row: []bigquery.Value{int64(1), []bigquery.Value{"a", "b"}, []bigquery.Value{1.5, 2.5}, when, day, big.NewRat(3, 2), []byte("xyz")}},
+		{Schema: schema, InsertID: "2", Row: []bigquery.Value{int64(2), []bigquery.Value{}, nil, nil, nil, nil, nil}},
+		// A retry of the first row, which is ignored.
+		{Schema: schema, InsertID: "1", Row: []bigquery.Value{int64(1), []bigquery.Value{"a", "b"}, []bigquery.Value{1.5, 2.5}, when, day, big.NewRat(3, 2), []byte("xyz")}},
+	}
+	if err := table.Inserter().Put(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+	got := readRows(t, table.Read(ctx))
+	want := [][]bigquery.Value{
+		{int64(1), []bigquery.Value{"a", "b"}, []bigquery.Value{1.5, 2.5}, when, day, big.NewRat(3, 2), []byte("xyz")},
+		{int64(2), []bigquery.Value(nil), nil, nil, nil, nil, nil},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("rows: got=-, want=+:\n%s", diff)
+	}
+
+	// A row with a bad value is rejected, along with the rest of the request.
+	err := table.Inserter().Put(ctx, []*bigquery.ValuesSaver{
+		{Schema: schema, Row: []bigquery.Value{int64(3), nil, nil, nil, nil, nil, nil}},
+		{Schema: schema, Row: []bigquery.Value{nil, nil, nil, nil, nil, nil, nil}},
+	})
+	var pme bigquery.PutMultiError
+	if !xerrors.As(err, &pme) || len(pme) != 2 || pme[1].RowIndex != 1 {
+		t.Errorf("inserting bad row: got %v, want PutMultiError for both rows", err)
+	}
+
+	md, err := table.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.NumRows != 2 || md.Type != bigquery.RegularTable || md.FullID != "proj:ds.t" {
+		t.Errorf("got metadata %+v", md)
+	}
+
+	// Fields can be added, but not removed.
+	newSchema := append(md.Schema, &bigquery.FieldSchema{Name: "extra", Type: bigquery.StringFieldType})
+	md2, err := table.Update(ctx, bigquery.TableMetadataToUpdate{Schema: newSchema}, md.ETag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(md2.Schema) != len(schema)+1 {
+		t.Errorf("got schema %v after adding a field", md2.Schema)
+	}
+	if _, err := table.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema[:1]}, ""); errCode(err) != http.StatusBadRequest {
+		t.Errorf("removing fields: got %v, want 400", err)
+	}
+	got = readRows(t, table.Read(ctx))
+	if len(got) != 2 || len(got[1]) != len(schema)+1 || got[1][len(schema)] != nil {
+		t.Errorf("rows after adding a field: %v", got)
+	}
+
+	var ids []string
+	it := ds.Tables(ctx)
+	for {
+		tbl, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, tbl.TableID)
+	}
+	if diff := testutil.Diff(ids, []string{"t"}); diff != "" {
+		t.Errorf("tables: got=-, want=+:\n%s", diff)
+	}
+	if err := table.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Metadata(ctx); errCode(err) != http.StatusNotFound {
+		t.Errorf("getting deleted table: got %v, want 404", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	newPeopleTable(ctx, t, client)
+
+	for _, test := range []struct {
+		sql    string
+		params []bigquery.QueryParameter
+		want   [][]bigquery.Value
+	}{
+		{
+			sql:  "SELECT name, age FROM ds.people WHERE city = 'paris' ORDER BY age DESC",
+			want: [][]bigquery.Value{{"carol", int64(35)}, {"alice", int64(30)}},
+		},
+		{
+			sql:  "SELECT name FROM `proj.ds.people` WHERE age IS NULL OR city IS NULL ORDER BY 1",
+			want: [][]bigquery.Value{{"dave"}, {"eve"}},
+		},
+		{
+			sql:    "SELECT name FROM ds.people WHERE age > @min AND name LIKE @pat ORDER BY name LIMIT 2",
+			params: []bigquery.QueryParameter{{Name: "min", Value: 20}, {Name: "pat", Value: "%a%"}},
+			want:   [][]bigquery.Value{{"alice"}, {"carol"}},
+		},
+		{
+			sql:    "SELECT COUNT(*) FROM ds.people WHERE age IN UNNEST(?)",
+			params: []bigquery.QueryParameter{{Value: []int64{25, 35}}},
+			want:   [][]bigquery.Value{{int64(3)}},
+		},
+		{
+			sql: `SELECT city, COUNT(*) AS n, SUM(score) AS total, MAX(age) AS oldest
+			      FROM ds.people GROUP BY city HAVING n > 0 ORDER BY city NULLS LAST`,
+			want: [][]bigquery.Value{
+				{"london", int64(1), 2.0, int64(25)},
+				{"paris", int64(2), 5.0, int64(35)},
+				{"rome", int64(1), 4.0, nil},
+				{nil, int64(1), 5.0, int64(25)},
+			},
+		},
+		{
+			sql:  "SELECT COUNT(DISTINCT age), AVG(age), STRING_AGG(name, '|') FROM ds.people WHERE score < 4",
+			want: [][]bigquery.Value{{int64(3), 30.0, "alice|bob|carol"}},
+		},
+		{
+			sql:  "SELECT DISTINCT age FROM ds.people WHERE age IS NOT NULL ORDER BY age LIMIT 10 OFFSET 1",
+			want: [][]bigquery.Value{{int64(30)}, {int64(35)}},
+		},
+		{
+			sql: `SELECT UPPER(name) || '!' AS shout, LENGTH(name), CASE WHEN age >= 30 THEN 'old' ELSE 'young' END,
+			      IFNULL(city, '?'), CAST(score AS INT64), SUBSTR(name, 2, 2)
+			      FROM ds.people WHERE name BETWEEN 'bob' AND 'dave' ORDER BY name`,
+			want: [][]bigquery.Value{
+				{"BOB!", int64(3), "young", "london", int64(2), "ob"},
+				{"CAROL!", int64(5), "old", "paris", int64(4), "ar"},
+				{"DAVE!", int64(4), "young", "rome", int64(4), "av"},
+			},
+		},
+		{
+			sql:  "SELECT * EXCEPT (age, score) FROM (SELECT * FROM ds.people WHERE name = 'bob')",
+			want: [][]bigquery.Value{{"bob", "london"}},
+		},
+		{
+			sql: `SELECT 1 + 2 * 3, 7 / 2, MOD(7, 3), ROUND(2.5), ABS(-4), COALESCE(NULL, 'x'),
+			      DATE '2021-01-31' > DATE '2021-01-01', EXTRACT(YEAR FROM DATE '2021-05-06'),
+			      ARRAY_LENGTH([1, 2, 3]), TIMESTAMP_SECONDS(0), CAST('1.25' AS NUMERIC)`,
+			want: [][]bigquery.Value{{int64(7), 3.5, int64(1), 3.0, int64(4), "x", true, int64(2021),
+				int64(3), time.Unix(0, 0).UTC(), big.NewRat(5, 4)}},
+		},
+	} {
+		q := client.Query(test.sql)
+		q.Parameters = test.params
+		it, err := q.Read(ctx)
+		if err != nil {
+			t.Errorf("%s: %v", test.sql, err)
+			continue
+		}
+		got := readRows(t, it)
+		if diff := testutil.Diff(got, test.want); diff != "" {
+			t.Errorf("%s: got=-, want=+:\n%s", test.sql, diff)
+		}
+	}
+}
+
+func TestQuerySchema(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	newPeopleTable(ctx, t, client)
+
+	// The schema of an empty result is still known.
+	it, err := client.Query("SELECT name, age * 2 AS double, COUNT(*) OVER_COUNT FROM ds.people WHERE FALSE GROUP BY 1, 2").Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := readRows(t, it); len(rows) != 0 {
+		t.Errorf("got %d rows, want 0", len(rows))
+	}
+	want := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "double", Type: bigquery.IntegerFieldType},
+		{Name: "OVER_COUNT", Type: bigquery.IntegerFieldType},
+	}
+	if diff := testutil.Diff(it.Schema, want); diff != "" {
+		t.Errorf("schema: got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	newPeopleTable(ctx, t, client)
+
+	for _, test := range []struct {
+		sql      string
+		wantCode int
+		wantMsg  string
+	}{
+		{"SELEC 1", http.StatusBadRequest, "Syntax error"},
+		{"SELECT nope FROM ds.people", http.StatusBadRequest, "Unrecognized name: nope"},
+		{"SELECT 1 FROM ds.missing", http.StatusNotFound, "Not found: Table proj:ds.missing"},
+		{"SELECT name, COUNT(*) FROM ds.people", http.StatusBadRequest, "neither grouped nor aggregated"},
+		{"SELECT 1 / 0", http.StatusBadRequest, "division by zero"},
+		{"SELECT @p", http.StatusBadRequest, "Query parameter 'p' not found"},
+		{"SELECT name FROM ds.people JOIN ds.people USING (name)", http.StatusBadRequest, "not supported"},
+	} {
+		_, err := client.Query(test.sql).Read(ctx)
+		if errCode(err) != test.wantCode || !strings.Contains(err.Error(), test.wantMsg) {
+			t.Errorf("%s: got %v, want %d error containing %q", test.sql, err, test.wantCode, test.wantMsg)
+		}
+	}
+}
+
+func TestQueryJobs(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	people := newPeopleTable(ctx, t, client)
+	ds := client.Dataset(testDataset)
+
+	// A query with a destination table runs as a job.
+	q := client.Query("SELECT name, score FROM people WHERE score > 3")
+	q.DefaultDatasetID = testDataset
+	q.Dst = ds.Table("high")
+	job, err := q.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if qs, ok := status.Statistics.Details.(*bigquery.QueryStatistics); !ok || qs.StatementType != "SELECT" {
+		t.Errorf("got statistics %+v", status.Statistics.Details)
+	}
+	got := readRows(t, ds.Table("high").Read(ctx))
+	want := [][]bigquery.Value{{"carol", 3.5}, {"dave", 4.0}, {"eve", 5.0}}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("destination rows: got=-, want=+:\n%s", diff)
+	}
+	it, err := job.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it.PageInfo().MaxSize = 2
+	if diff := testutil.Diff(readRows(t, it), want); diff != "" {
+		t.Errorf("job rows: got=-, want=+:\n%s", diff)
+	}
+	// The default write disposition fails if the table has data.
+	job, err = q.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := job.Wait(ctx); err == nil {
+		t.Error("writing to a non-empty table: got nil, want error")
+	}
+
+	// Views are run when they are queried.
+	view := ds.Table("young")
+	if err := view.Create(ctx, &bigquery.TableMetadata{ViewQuery: "SELECT name FROM ds.people WHERE age < 30"}); err != nil {
+		t.Fatal(err)
+	}
+	md, err := view.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Type != bigquery.ViewTable || len(md.Schema) != 1 {
+		t.Errorf("got view metadata %+v", md)
+	}
+	it, err = client.Query("SELECT COUNT(*) FROM ds.young").Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := testutil.Diff(readRows(t, it), [][]bigquery.Value{{int64(2)}}); diff != "" {
+		t.Errorf("view rows: got=-, want=+:\n%s", diff)
+	}
+
+	// Copy jobs.
+	copier := ds.Table("copy").CopierFrom(people)
+	job, err = copier.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, err := job.Wait(ctx); err != nil || status.Err() != nil {
+		t.Fatalf("copy: %v, %v", err, status.Err())
+	}
+	if got := readRows(t, ds.Table("copy").Read(ctx)); len(got) != 5 {
+		t.Errorf("copied %d rows, want 5", len(got))
+	}
+
+	var n int
+	jobs := client.Jobs(ctx)
+	for {
+		_, err := jobs.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n < 3 {
+		t.Errorf("listed %d jobs, want at least 3", n)
+	}
+}
+
+func TestLoadAndExtract(t *testing.T) {
+	ctx := context.Background()
+	client, srv, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	ds := client.Dataset(testDataset)
+	if err := ds.Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	table := ds.Table("loaded")
+
+	// Load CSV data uploaded with the job.
+	src := bigquery.NewReaderSource(strings.NewReader("name,age\nann,1\nben,\n"))
+	src.SkipLeadingRows = 1
+	src.Schema = bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "age", Type: bigquery.IntegerFieldType},
+	}
+	runJob := func(job *bigquery.Job, err error) *bigquery.JobStatus {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := status.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+	status := runJob(table.LoaderFrom(src).Run(ctx))
+	if ls, ok := status.Statistics.Details.(*bigquery.LoadStatistics); !ok || ls.OutputRows != 2 {
+		t.Errorf("got load statistics %+v", status.Statistics.Details)
+	}
+
+	// Append newline-delimited JSON from Cloud Storage, matched by a
+	// wildcard.
+	srv.PutObject("gs://bucket/part-1.json", []byte(`{"name": "cat", "age": 3}`+"\n"))
+	srv.PutObject("gs://bucket/part-2.json", []byte(`{"name": "dan"}`+"\n"))
+	gcs := bigquery.NewGCSReference("gs://bucket/part-*.json")
+	gcs.SourceFormat = bigquery.JSON
+	runJob(table.LoaderFrom(gcs).Run(ctx))
+
+	got := readRows(t, table.Read(ctx))
+	want := [][]bigquery.Value{{"ann", int64(1)}, {"ben", nil}, {"cat", int64(3)}, {"dan", nil}}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("loaded rows: got=-, want=+:\n%s", diff)
+	}
+
+	// A load job that fails reports its error in its status.
+	bad := bigquery.NewReaderSource(strings.NewReader("eve,notanumber\n"))
+	job, err := table.LoaderFrom(bad).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Err(); err == nil || !strings.Contains(err.Error(), "Invalid INTEGER value") {
+		t.Errorf("loading bad data: got %v", err)
+	}
+
+	// Extract to CSV and JSON.
+	dst := bigquery.NewGCSReference("gs://bucket/out-*.csv")
+	runJob(table.ExtractorTo(dst).Run(ctx))
+	data, ok := srv.Object("gs://bucket/out-000000000000.csv")
+	if !ok {
+		t.Fatal("extracted object not found")
+	}
+	if got, want := string(data), "name,age\nann,1\nben,\ncat,3\ndan,\n"; got != want {
+		t.Errorf("extracted CSV: got %q, want %q", got, want)
+	}
+	dst = bigquery.NewGCSReference("gs://bucket/out.json")
+	dst.DestinationFormat = bigquery.JSON
+	runJob(table.ExtractorTo(dst).Run(ctx))
+	data, _ = srv.Object("gs://bucket/out.json")
+	if got, want := string(data), `{"age":"1","name":"ann"}`+"\n"+`{"name":"ben"}`+"\n"+`{"age":"3","name":"cat"}`+"\n"+`{"name":"dan"}`+"\n"; got != want {
+		t.Errorf("extracted JSON: got %q, want %q", got, want)
+	}
+}
+
+func TestTimeNowFunc(t *testing.T) {
+	ctx := context.Background()
+	client, srv, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	now := time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)
+	srv.SetTimeNowFunc(func() time.Time { return now })
+
+	it, err := client.Query("SELECT CURRENT_TIMESTAMP(), CURRENT_DATE(), DATE_DIFF(CURRENT_DATE(), DATE '2020-01-01', DAY)").Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]bigquery.Value{{now, civil.Date{Year: 2020, Month: 2, Day: 29}, int64(59)}}
+	if diff := testutil.Diff(readRows(t, it), want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+}
@@ -0,0 +1,1868 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/civil"
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// scalarFunc compiles a call to a scalar function with the given compiled
+// arguments.
+type scalarFunc func(c *compiler, name string, args []*compiled) (*compiled, error)
+
+// scalarFuncs holds the supported scalar functions, by upper-case name.
+var scalarFuncs map[string]scalarFunc
+
+// datePartFuncs are the functions whose last argument is a date part, such
+// as DAY, written as an identifier.
+var datePartFuncs = map[string]bool{
+	"DATE_DIFF": true, "DATE_TRUNC": true, "TIMESTAMP_DIFF": true, "TIMESTAMP_TRUNC": true,
+	"DATETIME_DIFF": true, "DATETIME_TRUNC": true,
+}
+
+func init() {
+	scalarFuncs = map[string]scalarFunc{
+		// Strings.
+		"CONCAT": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if len(args) == 0 {
+				return nil, noSignature(name, args)
+			}
+			return compileConcat(args, "function CONCAT")
+		},
+		"LENGTH":           textFunc(intType, func(s string, b []byte) interface{} { return textLength(s, b) }),
+		"CHAR_LENGTH":      stringFunc(intType, func(s string) interface{} { return int64(utf8.RuneCountInString(s)) }),
+		"CHARACTER_LENGTH": stringFunc(intType, func(s string) interface{} { return int64(utf8.RuneCountInString(s)) }),
+		"BYTE_LENGTH":      textFunc(intType, func(s string, b []byte) interface{} { return int64(len(s) + len(b)) }),
+		"UPPER": textFunc(sameType, func(s string, b []byte) interface{} {
+			if b != nil {
+				return bytes.ToUpper(b)
+			}
+			return strings.ToUpper(s)
+		}),
+		"LOWER": textFunc(sameType, func(s string, b []byte) interface{} {
+			if b != nil {
+				return bytes.ToLower(b)
+			}
+			return strings.ToLower(s)
+		}),
+		"REVERSE": textFunc(sameType, func(s string, b []byte) interface{} {
+			if b != nil {
+				r := make([]byte, len(b))
+				for i := range b {
+					r[i] = b[len(b)-1-i]
+				}
+				return r
+			}
+			rs := []rune(s)
+			for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+				rs[i], rs[j] = rs[j], rs[i]
+			}
+			return string(rs)
+		}),
+		"SUBSTR":    substr,
+		"SUBSTRING": substr,
+		"LEFT": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			return textIntFunc(name, args, func(rs []rune, n int64) []rune {
+				if n > int64(len(rs)) {
+					n = int64(len(rs))
+				}
+				return rs[:n]
+			})
+		},
+		"RIGHT": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			return textIntFunc(name, args, func(rs []rune, n int64) []rune {
+				if n > int64(len(rs)) {
+					n = int64(len(rs))
+				}
+				return rs[int64(len(rs))-n:]
+			})
+		},
+		"REPEAT": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			return textIntFunc(name, args, func(rs []rune, n int64) []rune {
+				var out []rune
+				for i := int64(0); i < n; i++ {
+					out = append(out, rs...)
+				}
+				return out
+			})
+		},
+		"TRIM":  trimFunc(strings.Trim),
+		"LTRIM": trimFunc(strings.TrimLeft),
+		"RTRIM": trimFunc(strings.TrimRight),
+		"LPAD":  padFunc(true),
+		"RPAD":  padFunc(false),
+		"REPLACE": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			typ, err := textArgs(name, args, 3, 3)
+			if err != nil {
+				return nil, err
+			}
+			return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+				s, from, to := toText(vs[0]), toText(vs[1]), toText(vs[2])
+				if from != "" {
+					s = strings.Replace(s, from, to, -1)
+				}
+				return fromText(typ, s), nil
+			}), nil
+		},
+		"STARTS_WITH": textPredicate(strings.HasPrefix),
+		"ENDS_WITH":   textPredicate(strings.HasSuffix),
+		"STRPOS": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if _, err := textArgs(name, args, 2, 2); err != nil {
+				return nil, err
+			}
+			return nullSafe(intType, args, func(vs []interface{}) (interface{}, error) {
+				s, sub := toText(vs[0]), toText(vs[1])
+				i := strings.Index(s, sub)
+				if i < 0 {
+					return int64(0), nil
+				}
+				if _, ok := vs[0].([]byte); ok {
+					return int64(i + 1), nil
+				}
+				return int64(utf8.RuneCountInString(s[:i]) + 1), nil
+			}), nil
+		},
+		"SPLIT": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			typ, err := textArgs(name, args, 1, 2)
+			if err != nil {
+				return nil, err
+			}
+			return nullSafe(arrayType(typ), args, func(vs []interface{}) (interface{}, error) {
+				s, sep := toText(vs[0]), ","
+				if len(vs) > 1 {
+					sep = toText(vs[1])
+				}
+				var parts []string
+				switch {
+				case s == "":
+					parts = nil
+				case sep == "" && typ.Type == "STRING":
+					for _, r := range s {
+						parts = append(parts, string(r))
+					}
+				case sep == "":
+					for i := range s {
+						parts = append(parts, s[i:i+1])
+					}
+				default:
+					parts = strings.Split(s, sep)
+				}
+				out := make([]interface{}, len(parts))
+				for i, p := range parts {
+					out[i] = fromText(typ, p)
+				}
+				return out, nil
+			}), nil
+		},
+		"REGEXP_CONTAINS": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if _, err := textArgs(name, args, 2, 2); err != nil {
+				return nil, err
+			}
+			res := newRegexpCache()
+			return nullSafe(boolType, args, func(vs []interface{}) (interface{}, error) {
+				re, err := res.get(toText(vs[1]))
+				if err != nil {
+					return nil, err
+				}
+				return re.MatchString(toText(vs[0])), nil
+			}), nil
+		},
+		"REGEXP_EXTRACT": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			typ, err := textArgs(name, args, 2, 2)
+			if err != nil {
+				return nil, err
+			}
+			res := newRegexpCache()
+			return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+				re, err := res.get(toText(vs[1]))
+				if err != nil {
+					return nil, err
+				}
+				if re.NumSubexp() > 1 {
+					return nil, evalError("Regular expressions passed into extraction functions must not have more than 1 capturing group")
+				}
+				m := re.FindStringSubmatch(toText(vs[0]))
+				if m == nil {
+					return nil, nil
+				}
+				return fromText(typ, m[len(m)-1]), nil
+			}), nil
+		},
+		"REGEXP_REPLACE": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			typ, err := textArgs(name, args, 3, 3)
+			if err != nil {
+				return nil, err
+			}
+			res := newRegexpCache()
+			backref := regexp.MustCompile(`\\([0-9])`)
+			return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+				re, err := res.get(toText(vs[1]))
+				if err != nil {
+					return nil, err
+				}
+				repl := strings.Replace(toText(vs[2]), "$", "$$", -1)
+				repl = backref.ReplaceAllString(repl, "$${$1}")
+				return fromText(typ, re.ReplaceAllString(toText(vs[0]), repl)), nil
+			}), nil
+		},
+
+		// Numbers.
+		"ABS": numberFunc(sameType, func(v interface{}) (interface{}, error) {
+			switch v := v.(type) {
+			case int64:
+				if v == math.MinInt64 {
+					return nil, evalError("int64 overflow: ABS(%d)", v)
+				}
+				if v < 0 {
+					return -v, nil
+				}
+				return v, nil
+			case float64:
+				return math.Abs(v), nil
+			}
+			return new(big.Rat).Abs(v.(*big.Rat)), nil
+		}),
+		"SIGN": numberFunc(sameType, func(v interface{}) (interface{}, error) {
+			switch v := v.(type) {
+			case int64:
+				switch {
+				case v < 0:
+					return int64(-1), nil
+				case v > 0:
+					return int64(1), nil
+				}
+				return int64(0), nil
+			case float64:
+				switch {
+				case v < 0:
+					return -1.0, nil
+				case v > 0:
+					return 1.0, nil
+				}
+				return v, nil
+			}
+			return new(big.Rat).SetInt64(int64(v.(*big.Rat).Sign())), nil
+		}),
+		"ROUND": roundFunc(roundHalfAway),
+		"TRUNC": roundFunc(math.Trunc),
+		"FLOOR": numberFunc(floatResultType, func(v interface{}) (interface{}, error) {
+			return roundValue(v, 0, math.Floor)
+		}),
+		"CEIL": numberFunc(floatResultType, func(v interface{}) (interface{}, error) {
+			return roundValue(v, 0, math.Ceil)
+		}),
+		"CEILING": numberFunc(floatResultType, func(v interface{}) (interface{}, error) {
+			return roundValue(v, 0, math.Ceil)
+		}),
+		"SQRT": floatFunc(1, func(xs []float64) (float64, error) {
+			if xs[0] < 0 {
+				return 0, evalError("Argument to SQRT cannot be negative: %s", formatFloat(xs[0]))
+			}
+			return math.Sqrt(xs[0]), nil
+		}),
+		"POW":   floatFunc(2, func(xs []float64) (float64, error) { return math.Pow(xs[0], xs[1]), nil }),
+		"POWER": floatFunc(2, func(xs []float64) (float64, error) { return math.Pow(xs[0], xs[1]), nil }),
+		"EXP":   floatFunc(1, func(xs []float64) (float64, error) { return math.Exp(xs[0]), nil }),
+		"LN": floatFunc(1, func(xs []float64) (float64, error) {
+			if xs[0] <= 0 {
+				return 0, evalError("Argument to LN must be positive")
+			}
+			return math.Log(xs[0]), nil
+		}),
+		"LOG10": floatFunc(1, func(xs []float64) (float64, error) {
+			if xs[0] <= 0 {
+				return 0, evalError("Argument to LOG10 must be positive")
+			}
+			return math.Log10(xs[0]), nil
+		}),
+		"LOG": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if len(args) == 1 {
+				return scalarFuncs["LN"](c, name, args)
+			}
+			return floatFunc(2, func(xs []float64) (float64, error) {
+				if xs[0] <= 0 || xs[1] <= 0 || xs[1] == 1 {
+					return 0, evalError("Invalid arguments to LOG")
+				}
+				return math.Log(xs[0]) / math.Log(xs[1]), nil
+			})(c, name, args)
+		},
+		"MOD": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if err := checkArgs(name, args, 2, 2, isNumberType); err != nil {
+				return nil, err
+			}
+			typ := scalarType(numericResultType(args[0].typ.Type, args[1].typ.Type))
+			if typ.Type == "FLOAT" {
+				return nil, noSignature(name, args)
+			}
+			return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+				if typ.Type == "INTEGER" {
+					x, y := vs[0].(int64), vs[1].(int64)
+					if y == 0 {
+						return nil, evalError("division by zero: MOD(%d, %d)", x, y)
+					}
+					if y == -1 {
+						return int64(0), nil
+					}
+					return x % y, nil
+				}
+				x, y := toRat(vs[0]), toRat(vs[1])
+				if y.Sign() == 0 {
+					return nil, evalError("division by zero: MOD")
+				}
+				q := new(big.Rat).Quo(x, y)
+				qi := new(big.Int).Quo(q.Num(), q.Denom())
+				return new(big.Rat).Sub(x, new(big.Rat).Mul(y, new(big.Rat).SetInt(qi))), nil
+			}), nil
+		},
+		"DIV": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if err := checkArgs(name, args, 2, 2, isIntType); err != nil {
+				return nil, err
+			}
+			return nullSafe(intType, args, func(vs []interface{}) (interface{}, error) {
+				x, y := vs[0].(int64), vs[1].(int64)
+				if y == 0 {
+					return nil, evalError("division by zero: DIV(%d, %d)", x, y)
+				}
+				if x == math.MinInt64 && y == -1 {
+					return nil, evalError("int64 overflow: DIV(%d, %d)", x, y)
+				}
+				return x / y, nil
+			}), nil
+		},
+		"SAFE_DIVIDE": divideFunc(true),
+		"IEEE_DIVIDE": divideFunc(false),
+		"GREATEST":    extremeFunc(1),
+		"LEAST":       extremeFunc(-1),
+
+		// Conditional expressions.
+		"COALESCE": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if len(args) == 0 {
+				return nil, noSignature(name, args)
+			}
+			return coalesce(name, args)
+		},
+		"IFNULL": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if len(args) != 2 {
+				return nil, noSignature(name, args)
+			}
+			return coalesce(name, args)
+		},
+		"NULLIF": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if len(args) != 2 || !comparable(args[0].typ, args[1].typ) {
+				return nil, noSignature(name, args)
+			}
+			return &compiled{typ: args[0].typ, eval: func(e *env) (interface{}, error) {
+				vs, err := evalArgs(e, args...)
+				if err != nil {
+					return nil, err
+				}
+				if vs != nil && compareOp("=", vs[0], vs[1]) {
+					return nil, nil
+				}
+				return args[0].eval(e)
+			}}, nil
+		},
+		"IF": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if len(args) != 3 || (args[0].typ.Type != "BOOLEAN" && args[0].typ.Type != typeNull) {
+				return nil, noSignature(name, args)
+			}
+			typ, err := supertype(name, args[1:])
+			if err != nil {
+				return nil, err
+			}
+			return &compiled{typ: typ, eval: func(e *env) (interface{}, error) {
+				cond, err := args[0].eval(e)
+				if err != nil {
+					return nil, err
+				}
+				res := args[2]
+				if cond == true {
+					res = args[1]
+				}
+				v, err := res.eval(e)
+				return coerce(v, typ), err
+			}}, nil
+		},
+
+		// Dates and times.
+		"CURRENT_TIMESTAMP": nowFunc("TIMESTAMP"),
+		"CURRENT_DATE":      nowFunc("DATE"),
+		"CURRENT_DATETIME":  nowFunc("DATETIME"),
+		"CURRENT_TIME":      nowFunc("TIME"),
+		"DATE":              dateFunc,
+		"DATETIME":          datetimeFunc,
+		"TIMESTAMP": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if len(args) != 1 {
+				return nil, noSignature(name, args)
+			}
+			switch args[0].typ.Type {
+			case "STRING", "DATE", "DATETIME", "TIMESTAMP", typeNull:
+				return castTo(args[0], "TIMESTAMP", false)
+			}
+			return nil, noSignature(name, args)
+		},
+		"UNIX_SECONDS": timestampToInt(1e6),
+		"UNIX_MILLIS":  timestampToInt(1e3),
+		"UNIX_MICROS":  timestampToInt(1),
+		"UNIX_DATE": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if err := checkArgs(name, args, 1, 1, isType("DATE")); err != nil {
+				return nil, err
+			}
+			return nullSafe(intType, args, func(vs []interface{}) (interface{}, error) {
+				return int64(vs[0].(civil.Date).DaysSince(civil.Date{Year: 1970, Month: 1, Day: 1})), nil
+			}), nil
+		},
+		"TIMESTAMP_SECONDS": intToTimestamp(1e6),
+		"TIMESTAMP_MILLIS":  intToTimestamp(1e3),
+		"TIMESTAMP_MICROS":  intToTimestamp(1),
+		"DATE_DIFF":         diffFunc("DATE"),
+		"DATETIME_DIFF":     diffFunc("DATETIME"),
+		"TIMESTAMP_DIFF":    diffFunc("TIMESTAMP"),
+		"DATE_TRUNC":        truncFunc("DATE"),
+		"DATETIME_TRUNC":    truncFunc("DATETIME"),
+		"TIMESTAMP_TRUNC":   truncFunc("TIMESTAMP"),
+
+		// Arrays.
+		"ARRAY_LENGTH": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if err := checkArgs(name, args, 1, 1, isRepeated); err != nil {
+				return nil, err
+			}
+			return nullSafe(intType, args, func(vs []interface{}) (interface{}, error) {
+				return int64(len(vs[0].([]interface{}))), nil
+			}), nil
+		},
+		"ARRAY_REVERSE": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if err := checkArgs(name, args, 1, 1, isRepeated); err != nil {
+				return nil, err
+			}
+			return nullSafe(args[0].typ, args, func(vs []interface{}) (interface{}, error) {
+				a := vs[0].([]interface{})
+				r := make([]interface{}, len(a))
+				for i := range a {
+					r[i] = a[len(a)-1-i]
+				}
+				return r, nil
+			}), nil
+		},
+		"ARRAY_CONCAT": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if err := checkArgs(name, args, 1, -1, isRepeated); err != nil {
+				return nil, err
+			}
+			typ, err := supertype(name, args)
+			if err != nil {
+				return nil, err
+			}
+			return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+				r := []interface{}{}
+				for _, v := range vs {
+					for _, el := range v.([]interface{}) {
+						r = append(r, coerce(el, elemType(typ)))
+					}
+				}
+				return r, nil
+			}), nil
+		},
+		"ARRAY_TO_STRING": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if len(args) < 2 || len(args) > 3 || !isRepeated(args[0].typ) {
+				return nil, noSignature(name, args)
+			}
+			typ := elemType(args[0].typ)
+			if typ.Type != "STRING" && typ.Type != "BYTES" {
+				return nil, noSignature(name, args)
+			}
+			for _, a := range args[1:] {
+				if a.typ.Type != typ.Type && a.typ.Type != typeNull {
+					return nil, noSignature(name, args)
+				}
+			}
+			return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+				var parts []string
+				for _, el := range vs[0].([]interface{}) {
+					switch {
+					case el != nil:
+						parts = append(parts, toText(el))
+					case len(vs) > 2:
+						parts = append(parts, toText(vs[2]))
+					}
+				}
+				return fromText(typ, strings.Join(parts, toText(vs[1]))), nil
+			}), nil
+		},
+		"GENERATE_ARRAY": func(c *compiler, name string, args []*compiled) (*compiled, error) {
+			if err := checkArgs(name, args, 2, 3, isIntType); err != nil {
+				return nil, err
+			}
+			return nullSafe(arrayType(intType), args, func(vs []interface{}) (interface{}, error) {
+				start, end, step := vs[0].(int64), vs[1].(int64), int64(1)
+				if len(vs) > 2 {
+					step = vs[2].(int64)
+				}
+				if step == 0 {
+					return nil, evalError("Sequence step cannot be 0.")
+				}
+				r := []interface{}{}
+				for i := start; (step > 0 && i <= end) || (step < 0 && i >= end); i += step {
+					if len(r) >= 1000000 {
+						return nil, evalError("GENERATE_ARRAY(%d, %d, %d) produced too many elements", start, end, step)
+					}
+					r = append(r, i)
+				}
+				return r, nil
+			}), nil
+		},
+	}
+}
+
+var (
+	intType    = scalarType("INTEGER")
+	floatType  = scalarType("FLOAT")
+	boolType   = scalarType("BOOLEAN")
+	stringType = scalarType("STRING")
+)
+
+func noSignature(name string, args []*compiled) error {
+	return invalidQuery("No matching signature for function %s for argument types: %s", name, typeNames(args))
+}
+
+// checkArgs checks the number of arguments of a function and that each
+// argument, unless it is NULL, satisfies ok.
+func checkArgs(name string, args []*compiled, min, max int, ok func(*bq.TableFieldSchema) bool) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return noSignature(name, args)
+	}
+	for _, a := range args {
+		if a.typ.Type != typeNull && !ok(a.typ) {
+			return noSignature(name, args)
+		}
+	}
+	return nil
+}
+
+func isType(t string) func(*bq.TableFieldSchema) bool {
+	return func(f *bq.TableFieldSchema) bool { return f.Type == t && !isRepeated(f) }
+}
+
+func isIntType(f *bq.TableFieldSchema) bool {
+	return f.Type == "INTEGER" && !isRepeated(f)
+}
+
+func isNumberType(f *bq.TableFieldSchema) bool {
+	return isNumericType(f.Type) && !isRepeated(f)
+}
+
+// nullSafe returns a compiled function call that returns NULL if any of its
+// arguments is NULL, and otherwise calls f with their values.
+func nullSafe(typ *bq.TableFieldSchema, args []*compiled, f func([]interface{}) (interface{}, error)) *compiled {
+	return &compiled{typ: typ, eval: func(e *env) (interface{}, error) {
+		vs, err := evalArgs(e, args...)
+		if vs == nil || err != nil {
+			return nil, err
+		}
+		return f(vs)
+	}}
+}
+
+// textArgs checks that the arguments of a function are all STRING or all
+// BYTES, and returns their type.
+func textArgs(name string, args []*compiled, min, max int) (*bq.TableFieldSchema, error) {
+	if err := checkArgs(name, args, min, max, func(f *bq.TableFieldSchema) bool {
+		return (f.Type == "STRING" || f.Type == "BYTES") && !isRepeated(f)
+	}); err != nil {
+		return nil, err
+	}
+	typ := stringType
+	for _, a := range args {
+		if a.typ.Type == typeNull {
+			continue
+		}
+		if typ != stringType && a.typ.Type != typ.Type {
+			return nil, noSignature(name, args)
+		}
+		typ = scalarType(a.typ.Type)
+	}
+	return typ, nil
+}
+
+// fromText returns s as a value of the STRING or BYTES type typ.
+func fromText(typ *bq.TableFieldSchema, s string) interface{} {
+	if typ.Type == "BYTES" {
+		return []byte(s)
+	}
+	return s
+}
+
+func textLength(s string, b []byte) int64 {
+	if b != nil {
+		return int64(len(b))
+	}
+	return int64(utf8.RuneCountInString(s))
+}
+
+// Result types of functions of one argument.
+var (
+	sameType = func(arg *bq.TableFieldSchema) *bq.TableFieldSchema {
+		if arg.Type == typeNull {
+			return intType
+		}
+		return arg
+	}
+	// floatResultType is the type of ROUND, FLOOR and so on, which return
+	// FLOAT64 for integers.
+	floatResultType = func(arg *bq.TableFieldSchema) *bq.TableFieldSchema {
+		if arg.Type == "INTEGER" || arg.Type == typeNull {
+			return floatType
+		}
+		return arg
+	}
+)
+
+// textFunc returns a function of one STRING or BYTES argument. f is called
+// with the value as s, or as b if it is BYTES.
+func textFunc(typ interface{}, f func(s string, b []byte) interface{}) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		argType, err := textArgs(name, args, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		return nullSafe(resultType(typ, argType), args, func(vs []interface{}) (interface{}, error) {
+			if b, ok := vs[0].([]byte); ok {
+				if b == nil {
+					b = []byte{}
+				}
+				return f("", b), nil
+			}
+			return f(vs[0].(string), nil), nil
+		}), nil
+	}
+}
+
+// stringFunc returns a function of one STRING argument.
+func stringFunc(typ interface{}, f func(s string) interface{}) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if err := checkArgs(name, args, 1, 1, isType("STRING")); err != nil {
+			return nil, err
+		}
+		return nullSafe(resultType(typ, stringType), args, func(vs []interface{}) (interface{}, error) {
+			return f(vs[0].(string)), nil
+		}), nil
+	}
+}
+
+// resultType returns the result type of a function, given either as a type
+// or as a function of the argument type.
+func resultType(typ interface{}, arg *bq.TableFieldSchema) *bq.TableFieldSchema {
+	if f, ok := typ.(func(*bq.TableFieldSchema) *bq.TableFieldSchema); ok {
+		return f(arg)
+	}
+	return typ.(*bq.TableFieldSchema)
+}
+
+// textPredicate returns a function of two STRING or BYTES arguments that
+// returns a BOOL.
+func textPredicate(f func(s, t string) bool) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if _, err := textArgs(name, args, 2, 2); err != nil {
+			return nil, err
+		}
+		return nullSafe(boolType, args, func(vs []interface{}) (interface{}, error) {
+			return f(toText(vs[0]), toText(vs[1])), nil
+		}), nil
+	}
+}
+
+// textIntFunc compiles a function of a STRING or BYTES value and a
+// non-negative INT64 that operates on characters, or bytes.
+func textIntFunc(name string, args []*compiled, f func(rs []rune, n int64) []rune) (*compiled, error) {
+	if len(args) != 2 || !isIntType(args[1].typ) && args[1].typ.Type != typeNull {
+		return nil, noSignature(name, args)
+	}
+	typ, err := textArgs(name, args[:1], 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+		n := vs[1].(int64)
+		if n < 0 {
+			return nil, evalError("%s requires a non-negative length", name)
+		}
+		if b, ok := vs[0].([]byte); ok {
+			// Treat each byte as a character.
+			rs := make([]rune, len(b))
+			for i, c := range b {
+				rs[i] = rune(c)
+			}
+			out := f(rs, n)
+			ob := make([]byte, len(out))
+			for i, r := range out {
+				ob[i] = byte(r)
+			}
+			return ob, nil
+		}
+		return string(f([]rune(vs[0].(string)), n)), nil
+	}), nil
+}
+
+// substr implements SUBSTR(value, position[, length]). Positions start at 1,
+// and negative positions count from the end.
+func substr(c *compiler, name string, args []*compiled) (*compiled, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, noSignature(name, args)
+	}
+	for _, a := range args[1:] {
+		if !isIntType(a.typ) && a.typ.Type != typeNull {
+			return nil, noSignature(name, args)
+		}
+	}
+	typ, err := textArgs(name, args[:1], 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+		var rs []rune
+		b, isBytes := vs[0].([]byte)
+		if isBytes {
+			for _, c := range b {
+				rs = append(rs, rune(c))
+			}
+		} else {
+			rs = []rune(vs[0].(string))
+		}
+		n := int64(len(rs))
+		pos := vs[1].(int64)
+		switch {
+		case pos > 0:
+			pos--
+		case pos < 0:
+			pos += n
+			if pos < 0 {
+				pos = 0
+			}
+		}
+		if pos > n {
+			pos = n
+		}
+		end := n
+		if len(vs) > 2 {
+			l := vs[2].(int64)
+			if l < 0 {
+				return nil, evalError("Third argument in SUBSTR() cannot be negative")
+			}
+			if l < n-pos {
+				end = pos + l
+			}
+		}
+		out := rs[pos:end]
+		if isBytes {
+			ob := make([]byte, len(out))
+			for i, r := range out {
+				ob[i] = byte(r)
+			}
+			return ob, nil
+		}
+		return string(out), nil
+	}), nil
+}
+
+func trimFunc(trim func(s, cutset string) string) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		typ, err := textArgs(name, args, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+			cutset := " \t\n\r\v\f"
+			if len(vs) > 1 {
+				cutset = toText(vs[1])
+			}
+			return fromText(typ, trim(toText(vs[0]), cutset)), nil
+		}), nil
+	}
+}
+
+func padFunc(left bool) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if len(args) < 2 || len(args) > 3 || !isIntType(args[1].typ) && args[1].typ.Type != typeNull {
+			return nil, noSignature(name, args)
+		}
+		textArgsList := []*compiled{args[0]}
+		if len(args) == 3 {
+			textArgsList = append(textArgsList, args[2])
+		}
+		typ, err := textArgs(name, textArgsList, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+			s, n, pad := []rune(toText(vs[0])), vs[1].(int64), []rune(" ")
+			if len(vs) > 2 {
+				pad = []rune(toText(vs[2]))
+			}
+			if n < 0 {
+				return nil, evalError("%s requires a non-negative length", name)
+			}
+			if int64(len(s)) >= n {
+				return fromText(typ, string(s[:n])), nil
+			}
+			if len(pad) == 0 {
+				return nil, evalError("%s requires a non-empty pattern", name)
+			}
+			var fill []rune
+			for int64(len(fill)+len(s)) < n {
+				fill = append(fill, pad[len(fill)%len(pad)])
+			}
+			if left {
+				return fromText(typ, string(fill)+string(s)), nil
+			}
+			return fromText(typ, string(s)+string(fill)), nil
+		}), nil
+	}
+}
+
+// regexpCache compiles regular expressions used by a function call.
+type regexpCache map[string]*regexp.Regexp
+
+func newRegexpCache() regexpCache {
+	return regexpCache{}
+}
+
+func (c regexpCache) get(pat string) (*regexp.Regexp, error) {
+	if re := c[pat]; re != nil {
+		return re, nil
+	}
+	re, err := regexp.Compile(pat)
+	if err != nil {
+		return nil, evalError("Cannot parse regular expression: %v", err)
+	}
+	c[pat] = re
+	return re, nil
+}
+
+// numberFunc returns a function of one numeric argument.
+func numberFunc(typ func(*bq.TableFieldSchema) *bq.TableFieldSchema, f func(interface{}) (interface{}, error)) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if err := checkArgs(name, args, 1, 1, isNumberType); err != nil {
+			return nil, err
+		}
+		rt := typ(args[0].typ)
+		return nullSafe(rt, args, func(vs []interface{}) (interface{}, error) {
+			return f(coerce(vs[0], rt))
+		}), nil
+	}
+}
+
+// floatFunc returns a function of n numeric arguments that returns FLOAT64.
+func floatFunc(n int, f func([]float64) (float64, error)) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if err := checkArgs(name, args, n, n, isNumberType); err != nil {
+			return nil, err
+		}
+		return nullSafe(floatType, args, func(vs []interface{}) (interface{}, error) {
+			xs := make([]float64, len(vs))
+			for i, v := range vs {
+				xs[i] = toFloat(v)
+			}
+			return f(xs)
+		}), nil
+	}
+}
+
+func roundHalfAway(x float64) float64 {
+	return math.Round(x)
+}
+
+// roundFunc implements ROUND and TRUNC, with an optional number of digits.
+func roundFunc(round func(float64) float64) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if len(args) < 1 || len(args) > 2 || !isNumberType(args[0].typ) && args[0].typ.Type != typeNull {
+			return nil, noSignature(name, args)
+		}
+		if len(args) == 2 && !isIntType(args[1].typ) && args[1].typ.Type != typeNull {
+			return nil, noSignature(name, args)
+		}
+		rt := floatResultType(args[0].typ)
+		return nullSafe(rt, args, func(vs []interface{}) (interface{}, error) {
+			var digits int64
+			if len(vs) > 1 {
+				digits = vs[1].(int64)
+			}
+			return roundValue(coerce(vs[0], rt), digits, round)
+		}), nil
+	}
+}
+
+// roundValue rounds a FLOAT64, NUMERIC or BIGNUMERIC value to the given
+// number of decimal digits using round, which rounds to an integer.
+func roundValue(v interface{}, digits int64, round func(float64) float64) (interface{}, error) {
+	if digits > 40 {
+		digits = 40
+	}
+	if digits < -40 {
+		digits = -40
+	}
+	switch v := v.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return v, nil
+		}
+		p := math.Pow(10, float64(digits))
+		r := round(v*p) / p
+		if math.IsInf(r, 0) || math.IsNaN(r) {
+			return v, nil
+		}
+		return r, nil
+	case int64:
+		return v, nil
+	}
+	r := v.(*big.Rat)
+	scale := new(big.Rat).SetFrac(new(big.Int).Exp(big.NewInt(10), big.NewInt(abs(digits)), nil), big.NewInt(1))
+	if digits < 0 {
+		scale.Inv(scale)
+	}
+	scaled := new(big.Rat).Mul(r, scale)
+	f, _ := scaled.Float64()
+	var n *big.Int
+	q, m := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	switch rf := round(f); {
+	case m.Sign() == 0:
+		n = q
+	case rf > f:
+		n = q.Add(q, big.NewInt(int64(bool2int(scaled.Sign() > 0))))
+	case rf < f:
+		n = q.Sub(q, big.NewInt(int64(bool2int(scaled.Sign() < 0))))
+	default:
+		n = q
+	}
+	return new(big.Rat).Quo(new(big.Rat).SetInt(n), scale), nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func bool2int(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// divideFunc implements SAFE_DIVIDE, which returns NULL for division by zero,
+// and IEEE_DIVIDE, which returns infinity or NaN.
+func divideFunc(safe bool) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if err := checkArgs(name, args, 2, 2, isNumberType); err != nil {
+			return nil, err
+		}
+		typ := numericResultType(args[0].typ.Type, args[1].typ.Type)
+		if typ == "INTEGER" || !safe {
+			typ = "FLOAT"
+		}
+		return nullSafe(scalarType(typ), args, func(vs []interface{}) (interface{}, error) {
+			if typ == "FLOAT" {
+				x, y := toFloat(vs[0]), toFloat(vs[1])
+				if y == 0 && safe {
+					return nil, nil
+				}
+				return x / y, nil
+			}
+			if toRat(vs[1]).Sign() == 0 {
+				return nil, nil
+			}
+			return arith("/", typ, vs[0], vs[1])
+		}), nil
+	}
+}
+
+// extremeFunc implements GREATEST (sign 1) and LEAST (sign -1).
+func extremeFunc(sign int) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if len(args) == 0 {
+			return nil, noSignature(name, args)
+		}
+		typ, err := supertype(name, args)
+		if err != nil {
+			return nil, err
+		}
+		if !isOrderable(typ) {
+			return nil, noSignature(name, args)
+		}
+		return nullSafe(typ, args, func(vs []interface{}) (interface{}, error) {
+			best := coerce(vs[0], typ)
+			for _, v := range vs[1:] {
+				v = coerce(v, typ)
+				if compareValues(v, best)*sign > 0 {
+					best = v
+				}
+			}
+			return best, nil
+		}), nil
+	}
+}
+
+// coalesce compiles COALESCE and IFNULL.
+func coalesce(name string, args []*compiled) (*compiled, error) {
+	typ, err := supertype(name, args)
+	if err != nil {
+		return nil, err
+	}
+	return &compiled{typ: typ, eval: func(e *env) (interface{}, error) {
+		for _, a := range args {
+			v, err := a.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				return coerce(v, typ), nil
+			}
+		}
+		return nil, nil
+	}}, nil
+}
+
+// nowFunc returns a function of the current time, as of the start of the
+// query, in UTC.
+func nowFunc(typ string) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if len(args) != 0 {
+			return nil, invalidQuery("bqtest: time zone arguments are not supported")
+		}
+		v, err := castValue(truncateTimestamp(c.q.now), "TIMESTAMP", typ)
+		if err != nil {
+			return nil, err
+		}
+		return constant(scalarType(typ), v), nil
+	}
+}
+
+// dateFunc implements DATE(timestamp), DATE(datetime), DATE(string) and
+// DATE(year, month, day).
+func dateFunc(c *compiler, name string, args []*compiled) (*compiled, error) {
+	switch len(args) {
+	case 1:
+		switch args[0].typ.Type {
+		case "TIMESTAMP", "DATETIME", "DATE", "STRING", typeNull:
+			return castTo(args[0], "DATE", false)
+		}
+	case 3:
+		if err := checkArgs(name, args, 3, 3, isIntType); err != nil {
+			return nil, err
+		}
+		return nullSafe(scalarType("DATE"), args, func(vs []interface{}) (interface{}, error) {
+			d := civil.Date{Year: int(vs[0].(int64)), Month: time.Month(vs[1].(int64)), Day: int(vs[2].(int64))}
+			if !d.IsValid() {
+				return nil, evalError("Input calculates to invalid date: %d-%d-%d", vs[0], vs[1], vs[2])
+			}
+			return d, nil
+		}), nil
+	}
+	return nil, noSignature(name, args)
+}
+
+// datetimeFunc implements DATETIME(timestamp), DATETIME(date),
+// DATETIME(string), DATETIME(date, time) and DATETIME(year, month, day,
+// hour, minute, second).
+func datetimeFunc(c *compiler, name string, args []*compiled) (*compiled, error) {
+	switch len(args) {
+	case 1:
+		switch args[0].typ.Type {
+		case "TIMESTAMP", "DATETIME", "DATE", "STRING", typeNull:
+			return castTo(args[0], "DATETIME", false)
+		}
+	case 2:
+		if (args[0].typ.Type == "DATE" || args[0].typ.Type == typeNull) && (args[1].typ.Type == "TIME" || args[1].typ.Type == typeNull) {
+			return nullSafe(scalarType("DATETIME"), args, func(vs []interface{}) (interface{}, error) {
+				return civil.DateTime{Date: vs[0].(civil.Date), Time: vs[1].(civil.Time)}, nil
+			}), nil
+		}
+	case 6:
+		if err := checkArgs(name, args, 6, 6, isIntType); err != nil {
+			return nil, err
+		}
+		return nullSafe(scalarType("DATETIME"), args, func(vs []interface{}) (interface{}, error) {
+			var n [6]int
+			for i, v := range vs {
+				n[i] = int(v.(int64))
+			}
+			dt := civil.DateTime{
+				Date: civil.Date{Year: n[0], Month: time.Month(n[1]), Day: n[2]},
+				Time: civil.Time{Hour: n[3], Minute: n[4], Second: n[5]},
+			}
+			if !dt.IsValid() {
+				return nil, evalError("Input calculates to invalid datetime")
+			}
+			return dt, nil
+		}), nil
+	}
+	return nil, noSignature(name, args)
+}
+
+func timestampToInt(unitMicros int64) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if err := checkArgs(name, args, 1, 1, isType("TIMESTAMP")); err != nil {
+			return nil, err
+		}
+		return nullSafe(intType, args, func(vs []interface{}) (interface{}, error) {
+			return floorDiv(timestampMicros(vs[0].(time.Time)), unitMicros), nil
+		}), nil
+	}
+}
+
+func intToTimestamp(unitMicros int64) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if err := checkArgs(name, args, 1, 1, isIntType); err != nil {
+			return nil, err
+		}
+		return nullSafe(scalarType("TIMESTAMP"), args, func(vs []interface{}) (interface{}, error) {
+			n := vs[0].(int64)
+			if n > math.MaxInt64/unitMicros || n < math.MinInt64/unitMicros {
+				return nil, evalError("Timestamp value is out of range")
+			}
+			return microsTimestamp(n * unitMicros), nil
+		}), nil
+	}
+}
+
+func microsTimestamp(us int64) time.Time {
+	return time.Unix(floorDiv(us, 1e6), (us-floorDiv(us, 1e6)*1e6)*1e3).UTC()
+}
+
+// floorDiv divides, rounding toward negative infinity.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// datePartArg returns the name of a date part written as an identifier.
+func datePartArg(e expr) (string, bool) {
+	ref, ok := e.(*columnRef)
+	if !ok || len(ref.parts) != 1 {
+		return "", false
+	}
+	return strings.ToUpper(ref.parts[0]), true
+}
+
+// toTime converts a DATE, DATETIME or TIMESTAMP value to a time.Time in
+// UTC.
+func toTime(v interface{}) time.Time {
+	switch v := v.(type) {
+	case civil.Date:
+		return v.In(time.UTC)
+	case civil.DateTime:
+		return v.In(time.UTC)
+	case civil.Time:
+		return time.Date(1970, 1, 1, v.Hour, v.Minute, v.Second, v.Nanosecond, time.UTC)
+	}
+	return v.(time.Time)
+}
+
+// fromTime converts a time.Time in UTC to a value of the given type.
+func fromTime(t time.Time, typ string) interface{} {
+	switch typ {
+	case "DATE":
+		return civil.DateOf(t)
+	case "DATETIME":
+		return civil.DateTimeOf(t)
+	case "TIME":
+		return civil.TimeOf(t)
+	}
+	return t
+}
+
+var partMicros = map[string]int64{
+	"MICROSECOND": 1, "MILLISECOND": 1e3, "SECOND": 1e6, "MINUTE": 60e6, "HOUR": 3600e6, "DAY": 86400e6,
+}
+
+// validPart reports whether a date part may be used with values of type typ
+// in DATE_DIFF, DATE_TRUNC and similar functions.
+func validPart(typ, part string) bool {
+	switch part {
+	case "WEEK", "MONTH", "QUARTER", "YEAR", "DAY":
+		return true
+	case "MICROSECOND", "MILLISECOND", "SECOND", "MINUTE", "HOUR":
+		return typ != "DATE"
+	}
+	return false
+}
+
+// diffFunc implements DATE_DIFF, DATETIME_DIFF and TIMESTAMP_DIFF, which
+// count the part boundaries between two values.
+func diffFunc(typ string) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if len(args) != 3 {
+			return nil, noSignature(name, args)
+		}
+		part := args[2].constantString()
+		if err := checkArgs(name, args[:2], 2, 2, isType(typ)); err != nil || !validPart(typ, part) {
+			return nil, invalidQuery("No matching signature for function %s with date part %s", name, part)
+		}
+		return nullSafe(intType, args[:2], func(vs []interface{}) (interface{}, error) {
+			a, b := toTime(vs[0]), toTime(vs[1])
+			switch part {
+			case "YEAR":
+				return int64(a.Year() - b.Year()), nil
+			case "QUARTER":
+				return int64((a.Year()*4 + (int(a.Month())-1)/3) - (b.Year()*4 + (int(b.Month())-1)/3)), nil
+			case "MONTH":
+				return int64((a.Year()*12 + int(a.Month())) - (b.Year()*12 + int(b.Month()))), nil
+			case "WEEK":
+				return floorDiv(timestampMicros(truncTime(a, "WEEK"))-timestampMicros(truncTime(b, "WEEK")), 7*86400e6), nil
+			case "DAY":
+				if typ != "TIMESTAMP" {
+					return floorDiv(timestampMicros(truncTime(a, "DAY"))-timestampMicros(truncTime(b, "DAY")), 86400e6), nil
+				}
+			}
+			// Other parts count whole units of elapsed time.
+			return (timestampMicros(a) - timestampMicros(b)) / partMicros[part], nil
+		}), nil
+	}
+}
+
+// truncFunc implements DATE_TRUNC, DATETIME_TRUNC and TIMESTAMP_TRUNC.
+func truncFunc(typ string) scalarFunc {
+	return func(c *compiler, name string, args []*compiled) (*compiled, error) {
+		if len(args) != 2 {
+			return nil, noSignature(name, args)
+		}
+		part := args[1].constantString()
+		if err := checkArgs(name, args[:1], 1, 1, isType(typ)); err != nil || !validPart(typ, part) {
+			return nil, invalidQuery("No matching signature for function %s with date part %s", name, part)
+		}
+		return nullSafe(scalarType(typ), args[:1], func(vs []interface{}) (interface{}, error) {
+			return fromTime(truncTime(toTime(vs[0]), part), typ), nil
+		}), nil
+	}
+}
+
+// truncTime truncates a time in UTC to the start of a date part. Weeks start
+// on Sunday.
+func truncTime(t time.Time, part string) time.Time {
+	y, m, d := t.Date()
+	switch part {
+	case "YEAR":
+		return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+	case "QUARTER":
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, time.UTC)
+	case "MONTH":
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	case "WEEK":
+		return time.Date(y, m, d-int(t.Weekday()), 0, 0, 0, 0, time.UTC)
+	case "DAY":
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	us := timestampMicros(t)
+	return microsTimestamp(floorDiv(us, partMicros[part]) * partMicros[part])
+}
+
+// constantString returns the value of a compiled STRING constant, or "".
+func (x *compiled) constantString() string {
+	if !x.constant {
+		return ""
+	}
+	v, _ := x.eval(&env{})
+	s, _ := v.(string)
+	return s
+}
+
+func (c *compiler) compileExtract(ex *extractExpr) (*compiled, error) {
+	x, err := c.compile(ex.x)
+	if err != nil {
+		return nil, err
+	}
+	typ := x.typ.Type
+	resType := intType
+	valid := false
+	switch ex.part {
+	case "YEAR", "QUARTER", "MONTH", "WEEK", "DAY", "DAYOFWEEK", "DAYOFYEAR":
+		valid = typ == "DATE" || typ == "DATETIME" || typ == "TIMESTAMP"
+	case "HOUR", "MINUTE", "SECOND", "MILLISECOND", "MICROSECOND":
+		valid = typ == "TIME" || typ == "DATETIME" || typ == "TIMESTAMP"
+	case "DATE", "TIME", "DATETIME":
+		valid = typ == "DATETIME" || typ == "TIMESTAMP"
+		resType = scalarType(ex.part)
+	}
+	if !valid || isRepeated(x.typ) {
+		return nil, invalidQuery("EXTRACT from %s does not support the %s date part", sqlTypeName(x.typ), ex.part)
+	}
+	return nullSafe(resType, []*compiled{x}, func(vs []interface{}) (interface{}, error) {
+		t := toTime(vs[0])
+		switch ex.part {
+		case "YEAR":
+			return int64(t.Year()), nil
+		case "QUARTER":
+			return int64((t.Month()-1)/3 + 1), nil
+		case "MONTH":
+			return int64(t.Month()), nil
+		case "WEEK":
+			// Weeks start on Sunday; days before the first Sunday are in
+			// week 0.
+			yd := t.YearDay() - 1
+			first := (7 - int(time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC).Weekday())) % 7
+			if yd < first {
+				return int64(0), nil
+			}
+			return int64((yd-first)/7 + 1), nil
+		case "DAY":
+			return int64(t.Day()), nil
+		case "DAYOFWEEK":
+			return int64(t.Weekday()) + 1, nil
+		case "DAYOFYEAR":
+			return int64(t.YearDay()), nil
+		case "HOUR":
+			return int64(t.Hour()), nil
+		case "MINUTE":
+			return int64(t.Minute()), nil
+		case "SECOND":
+			return int64(t.Second()), nil
+		case "MILLISECOND":
+			return int64(t.Nanosecond() / 1e6), nil
+		case "MICROSECOND":
+			return int64(t.Nanosecond() / 1e3), nil
+		}
+		return fromTime(t, ex.part), nil
+	}), nil
+}
+
+// castTargets holds the types to which each type can be cast.
+var castTargets = map[string]string{
+	"INTEGER":    "INTEGER FLOAT NUMERIC BIGNUMERIC BOOLEAN STRING",
+	"FLOAT":      "INTEGER FLOAT NUMERIC BIGNUMERIC STRING",
+	"NUMERIC":    "INTEGER FLOAT NUMERIC BIGNUMERIC STRING",
+	"BIGNUMERIC": "INTEGER FLOAT NUMERIC BIGNUMERIC STRING",
+	"BOOLEAN":    "INTEGER BOOLEAN STRING",
+	"STRING":     "INTEGER FLOAT NUMERIC BIGNUMERIC BOOLEAN STRING BYTES DATE DATETIME TIME TIMESTAMP",
+	"BYTES":      "BYTES STRING",
+	"DATE":       "DATE DATETIME STRING TIMESTAMP",
+	"DATETIME":   "DATE DATETIME STRING TIME TIMESTAMP",
+	"TIME":       "STRING TIME",
+	"TIMESTAMP":  "DATE DATETIME STRING TIME TIMESTAMP",
+	"GEOGRAPHY":  "GEOGRAPHY",
+}
+
+func (c *compiler) compileCast(ce *castExpr) (*compiled, error) {
+	x, err := c.compile(ce.x)
+	if err != nil {
+		return nil, err
+	}
+	return castTo(x, ce.typ, ce.safe)
+}
+
+// castTo compiles a conversion of x to type to. If safe is set, values that
+// cannot be converted become NULL instead of causing an error.
+func castTo(x *compiled, to string, safe bool) (*compiled, error) {
+	from := x.typ.Type
+	if from == typeNull {
+		return constant(scalarType(to), nil), nil
+	}
+	if isRepeated(x.typ) || !containsWord(castTargets[from], to) {
+		return nil, invalidQuery("Invalid cast from %s to %s", sqlTypeName(x.typ), sqlTypeName(scalarType(to)))
+	}
+	if from == to {
+		return x, nil
+	}
+	return &compiled{typ: scalarType(to), eval: func(e *env) (interface{}, error) {
+		v, err := x.eval(e)
+		if v == nil || err != nil {
+			return nil, err
+		}
+		v, err = castValue(v, from, to)
+		if err != nil && safe {
+			return nil, nil
+		}
+		return v, err
+	}}, nil
+}
+
+func containsWord(words, w string) bool {
+	for _, x := range strings.Fields(words) {
+		if x == w {
+			return true
+		}
+	}
+	return false
+}
+
+// castValue converts a non-NULL value between types.
+func castValue(v interface{}, from, to string) (interface{}, error) {
+	bad := func() error {
+		if s, ok := v.(string); ok {
+			return evalError("Bad %s value: %s", sqlTypeName(scalarType(to)), s)
+		}
+		return evalError("Invalid cast of %s to %s", formatValue(from, v), sqlTypeName(scalarType(to)))
+	}
+	switch to {
+	case "STRING":
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case []byte:
+			if !utf8.Valid(v) {
+				return nil, evalError("Invalid cast of BYTES to STRING: not valid UTF-8")
+			}
+			return string(v), nil
+		case time.Time:
+			return v.Format("2006-01-02 15:04:05.999999-07"), nil
+		}
+		return formatValue(from, v), nil
+	case "BYTES":
+		if s, ok := v.(string); ok {
+			return []byte(s), nil
+		}
+		return v, nil
+	case "INTEGER":
+		switch v := v.(type) {
+		case bool:
+			return int64(bool2int(v)), nil
+		case string:
+			s := strings.TrimSpace(v)
+			neg := strings.HasPrefix(s, "-")
+			if lower := strings.ToLower(strings.TrimLeft(s, "+-")); strings.HasPrefix(lower, "0x") {
+				u, err := strconv.ParseUint(lower[2:], 16, 63)
+				if err != nil {
+					return nil, bad()
+				}
+				if neg {
+					return -int64(u), nil
+				}
+				return int64(u), nil
+			}
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, bad()
+			}
+			return n, nil
+		case float64:
+			r := math.Round(v)
+			if math.IsNaN(r) || r < -9.223372036854775808e18 || r >= 9.223372036854775808e18 {
+				return nil, bad()
+			}
+			return int64(r), nil
+		case *big.Rat:
+			rr, err := roundValue(v, 0, math.Round)
+			if err != nil {
+				return nil, err
+			}
+			n := rr.(*big.Rat).Num()
+			if !n.IsInt64() {
+				return nil, bad()
+			}
+			return n.Int64(), nil
+		}
+	case "FLOAT":
+		if s, ok := v.(string); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil && !isRangeError(err) {
+				return nil, bad()
+			}
+			return f, nil
+		}
+		return toFloat(v), nil
+	case "NUMERIC", "BIGNUMERIC":
+		switch v := v.(type) {
+		case string:
+			r, err := parseString(to, v)
+			if err != nil {
+				return nil, bad()
+			}
+			return r, nil
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, bad()
+			}
+		}
+		r, err := roundNumeric(to, toRat(v))
+		if err != nil {
+			return nil, evalError("%v", err)
+		}
+		return r, nil
+	case "BOOLEAN":
+		switch v := v.(type) {
+		case int64:
+			return v != 0, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true":
+				return true, nil
+			case "false":
+				return false, nil
+			}
+			return nil, bad()
+		}
+	case "DATE", "TIME", "DATETIME", "TIMESTAMP":
+		if s, ok := v.(string); ok {
+			if _, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				return nil, bad()
+			}
+			r, err := parseString(to, s)
+			if err != nil {
+				return nil, bad()
+			}
+			return r, nil
+		}
+		if to == "TIME" {
+			switch v := v.(type) {
+			case civil.DateTime:
+				return v.Time, nil
+			case time.Time:
+				return truncateTime(civil.TimeOf(v.UTC())), nil
+			}
+		}
+		return fromTime(toTime(v), to), nil
+	}
+	return nil, bad()
+}
+
+// aggregateFunc describes an aggregate function.
+type aggregateFunc struct {
+	minArgs, maxArgs int
+	distinct         bool // whether DISTINCT is allowed
+	// typ returns the result type for the argument types, or nil if they
+	// are not allowed.
+	typ func(args []*bq.TableFieldSchema) *bq.TableFieldSchema
+	// reduce computes the result from the values of the first argument for
+	// the rows of a group, including NULLs, and the values of the other
+	// arguments, which must be constant.
+	reduce func(vals []interface{}, typ *bq.TableFieldSchema, extra []interface{}) (interface{}, error)
+}
+
+// aggregateFuncs holds the supported aggregate functions, by upper-case name.
+var aggregateFuncs map[string]*aggregateFunc
+
+func init() {
+	orderable := func(args []*bq.TableFieldSchema) *bq.TableFieldSchema {
+		if !isOrderable(args[0]) {
+			return nil
+		}
+		return args[0]
+	}
+	extreme := func(sign int) func([]interface{}, *bq.TableFieldSchema, []interface{}) (interface{}, error) {
+		return func(vals []interface{}, _ *bq.TableFieldSchema, _ []interface{}) (interface{}, error) {
+			var best interface{}
+			for _, v := range vals {
+				if v != nil && (best == nil || compareValues(v, best)*sign > 0) {
+					best = v
+				}
+			}
+			return best, nil
+		}
+	}
+	logical := func(and bool) func([]interface{}, *bq.TableFieldSchema, []interface{}) (interface{}, error) {
+		return func(vals []interface{}, _ *bq.TableFieldSchema, _ []interface{}) (interface{}, error) {
+			var res interface{}
+			for _, v := range vals {
+				if v == nil {
+					continue
+				}
+				if v.(bool) != and {
+					return v, nil
+				}
+				res = and
+			}
+			return res, nil
+		}
+	}
+	boolArg := func(args []*bq.TableFieldSchema) *bq.TableFieldSchema {
+		if args[0].Type != "BOOLEAN" && args[0].Type != typeNull || isRepeated(args[0]) {
+			return nil
+		}
+		return boolType
+	}
+	aggregateFuncs = map[string]*aggregateFunc{
+		"COUNT": {
+			minArgs: 1, maxArgs: 1, distinct: true,
+			typ: func([]*bq.TableFieldSchema) *bq.TableFieldSchema { return intType },
+			reduce: func(vals []interface{}, _ *bq.TableFieldSchema, _ []interface{}) (interface{}, error) {
+				var n int64
+				for _, v := range vals {
+					if v != nil {
+						n++
+					}
+				}
+				return n, nil
+			},
+		},
+		"COUNTIF": {
+			minArgs: 1, maxArgs: 1,
+			typ: func(args []*bq.TableFieldSchema) *bq.TableFieldSchema {
+				if boolArg(args) == nil {
+					return nil
+				}
+				return intType
+			},
+			reduce: func(vals []interface{}, _ *bq.TableFieldSchema, _ []interface{}) (interface{}, error) {
+				var n int64
+				for _, v := range vals {
+					if v == true {
+						n++
+					}
+				}
+				return n, nil
+			},
+		},
+		"SUM": {
+			minArgs: 1, maxArgs: 1, distinct: true,
+			typ: func(args []*bq.TableFieldSchema) *bq.TableFieldSchema {
+				if !isNumberType(args[0]) && args[0].Type != typeNull {
+					return nil
+				}
+				return sameType(args[0])
+			},
+			reduce: func(vals []interface{}, typ *bq.TableFieldSchema, _ []interface{}) (interface{}, error) {
+				var sum interface{}
+				for _, v := range vals {
+					if v == nil {
+						continue
+					}
+					if sum == nil {
+						sum = coerce(v, typ)
+						continue
+					}
+					var err error
+					if sum, err = arith("+", typ.Type, sum, v); err != nil {
+						return nil, err
+					}
+				}
+				return sum, nil
+			},
+		},
+		"AVG": {
+			minArgs: 1, maxArgs: 1, distinct: true,
+			typ: func(args []*bq.TableFieldSchema) *bq.TableFieldSchema {
+				if !isNumberType(args[0]) && args[0].Type != typeNull {
+					return nil
+				}
+				return floatResultType(args[0])
+			},
+			reduce: func(vals []interface{}, typ *bq.TableFieldSchema, _ []interface{}) (interface{}, error) {
+				var n int64
+				fsum := 0.0
+				rsum := new(big.Rat)
+				for _, v := range vals {
+					if v == nil {
+						continue
+					}
+					n++
+					if typ.Type == "FLOAT" {
+						fsum += toFloat(v)
+					} else {
+						rsum.Add(rsum, toRat(v))
+					}
+				}
+				switch {
+				case n == 0:
+					return nil, nil
+				case typ.Type == "FLOAT":
+					return fsum / float64(n), nil
+				}
+				return roundNumeric(typ.Type, rsum.Quo(rsum, new(big.Rat).SetInt64(n)))
+			},
+		},
+		"MIN":         {minArgs: 1, maxArgs: 1, distinct: true, typ: orderable, reduce: extreme(-1)},
+		"MAX":         {minArgs: 1, maxArgs: 1, distinct: true, typ: orderable, reduce: extreme(1)},
+		"LOGICAL_AND": {minArgs: 1, maxArgs: 1, typ: boolArg, reduce: logical(true)},
+		"LOGICAL_OR":  {minArgs: 1, maxArgs: 1, typ: boolArg, reduce: logical(false)},
+		"ANY_VALUE": {
+			minArgs: 1, maxArgs: 1,
+			typ: func(args []*bq.TableFieldSchema) *bq.TableFieldSchema { return sameType(args[0]) },
+			reduce: func(vals []interface{}, _ *bq.TableFieldSchema, _ []interface{}) (interface{}, error) {
+				for _, v := range vals {
+					if v != nil {
+						return v, nil
+					}
+				}
+				return nil, nil
+			},
+		},
+		"ARRAY_AGG": {
+			minArgs: 1, maxArgs: 1, distinct: true,
+			typ: func(args []*bq.TableFieldSchema) *bq.TableFieldSchema {
+				if isRepeated(args[0]) {
+					return nil
+				}
+				return arrayType(sameType(args[0]))
+			},
+			reduce: func(vals []interface{}, _ *bq.TableFieldSchema, _ []interface{}) (interface{}, error) {
+				for _, v := range vals {
+					if v == nil {
+						return nil, evalError("Array cannot have a null element")
+					}
+				}
+				return append([]interface{}{}, vals...), nil
+			},
+		},
+		"STRING_AGG": {
+			minArgs: 1, maxArgs: 2, distinct: true,
+			typ: func(args []*bq.TableFieldSchema) *bq.TableFieldSchema {
+				typ := ""
+				for _, a := range args {
+					if isRepeated(a) || a.Type != "STRING" && a.Type != "BYTES" && a.Type != typeNull {
+						return nil
+					}
+					if a.Type != typeNull {
+						if typ != "" && typ != a.Type {
+							return nil
+						}
+						typ = a.Type
+					}
+				}
+				if typ == "" {
+					typ = "STRING"
+				}
+				return scalarType(typ)
+			},
+			reduce: func(vals []interface{}, typ *bq.TableFieldSchema, extra []interface{}) (interface{}, error) {
+				sep := ","
+				if len(extra) > 0 {
+					if extra[0] == nil {
+						return nil, nil
+					}
+					sep = toText(extra[0])
+				}
+				var parts []string
+				for _, v := range vals {
+					if v != nil {
+						parts = append(parts, toText(v))
+					}
+				}
+				if parts == nil {
+					return nil, nil
+				}
+				return fromText(typ, strings.Join(parts, sep)), nil
+			},
+		},
+	}
+}
+
+func (c *compiler) compileCall(call *callExpr) (*compiled, error) {
+	if call.name == "." {
+		x, err := c.compile(call.args[0])
+		if err != nil {
+			return nil, err
+		}
+		return fieldAccess(x, call.args[1].(*literal).val.(string))
+	}
+	if agg := aggregateFuncs[call.name]; agg != nil {
+		return c.compileAggregate(call, agg)
+	}
+	f := scalarFuncs[call.name]
+	if f == nil {
+		return nil, invalidQuery("Function not found: %s", strings.ToLower(call.name))
+	}
+	if call.star || call.distinct {
+		return nil, invalidQuery("Syntax error: %s does not support %s", call.name, map[bool]string{true: "*", false: "DISTINCT"}[call.star])
+	}
+	argExprs := call.args
+	var partArg *compiled
+	if datePartFuncs[call.name] && len(argExprs) > 0 {
+		if part, ok := datePartArg(argExprs[len(argExprs)-1]); ok {
+			partArg = constant(stringType, part)
+			argExprs = argExprs[:len(argExprs)-1]
+		}
+	}
+	args, err := c.compileAll(argExprs)
+	if err != nil {
+		return nil, err
+	}
+	if partArg != nil {
+		args = append(args, partArg)
+	}
+	return f(c, call.name, args)
+}
+
+func (c *compiler) compileAggregate(call *callExpr, agg *aggregateFunc) (*compiled, error) {
+	if !c.aggs {
+		if c.inAgg {
+			return nil, invalidQuery("Aggregations of aggregations are not allowed")
+		}
+		return nil, invalidQuery("Aggregate function %s not allowed in %s", call.name, c.clause)
+	}
+	if call.star {
+		if call.name != "COUNT" {
+			return nil, invalidQuery("Syntax error: %s does not support *", call.name)
+		}
+		return &compiled{typ: intType, eval: func(e *env) (interface{}, error) {
+			return int64(len(e.group)), nil
+		}}, nil
+	}
+	if call.distinct && !agg.distinct {
+		return nil, invalidQuery("DISTINCT is not allowed for function %s", call.name)
+	}
+	sub := *c
+	sub.aggs = false
+	sub.inAgg = true
+	args, err := sub.compileAll(call.args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < agg.minArgs || len(args) > agg.maxArgs {
+		return nil, noSignature(call.name, args)
+	}
+	types := make([]*bq.TableFieldSchema, len(args))
+	for i, a := range args {
+		types[i] = a.typ
+	}
+	typ := agg.typ(types)
+	if typ == nil {
+		return nil, invalidQuery("No matching signature for aggregate function %s for argument types: %s", call.name, typeNames(args))
+	}
+	if call.distinct && !isOrderable(args[0].typ) {
+		return nil, invalidQuery("Aggregate functions with DISTINCT cannot be used with arguments of type %s", sqlTypeName(args[0].typ))
+	}
+	return &compiled{typ: typ, eval: func(e *env) (interface{}, error) {
+		vals := make([]interface{}, 0, len(e.group))
+		seen := map[string]bool{}
+		for _, r := range e.group {
+			v, err := args[0].eval(&env{row: r, group: []row{r}})
+			if err != nil {
+				return nil, err
+			}
+			if call.distinct {
+				if v == nil {
+					continue
+				}
+				k := valueKey(v)
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+			}
+			vals = append(vals, v)
+		}
+		var extra []interface{}
+		for _, a := range args[1:] {
+			v, err := a.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			extra = append(extra, v)
+		}
+		return agg.reduce(vals, typ, extra)
+	}}, nil
+}
@@ -0,0 +1,492 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// job is a job that has run. Jobs run synchronously when they are inserted,
+// so every job held by the server is done.
+type job struct {
+	meta *bq.Job
+	// result holds the rows of a successful query job.
+	result *queryResult
+	// err is the error of a failed job.
+	err *httpError
+}
+
+// handleJobs dispatches a request for jobs. segs is the request path after
+// "/projects/{projectId}/jobs".
+func (s *Server) handleJobs(r *http.Request, projectID string, segs []string) (interface{}, error) {
+	switch {
+	case len(segs) == 0 && r.Method == http.MethodGet:
+		return s.listJobs(r, projectID)
+	case len(segs) == 0 && r.Method == http.MethodPost:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return s.insertJob(projectID, body, nil, false)
+	case len(segs) == 1 && r.Method == http.MethodGet:
+		return s.getJob(projectID, segs[0])
+	case len(segs) == 2 && segs[1] == "cancel" && r.Method == http.MethodPost:
+		meta, err := s.getJob(projectID, segs[0])
+		if err != nil {
+			return nil, err
+		}
+		// The job is already done, so there is nothing to cancel.
+		return &bq.JobCancelResponse{Kind: "bigquery#jobCancelResponse", Job: meta}, nil
+	case len(segs) == 2 && segs[1] == "delete" && r.Method == http.MethodDelete:
+		return nil, s.deleteJob(projectID, segs[0])
+	case len(segs) <= 2:
+		return nil, errMethod(r)
+	}
+	return nil, errNotFound(r)
+}
+
+// handleQueries dispatches a request for the jobs.query and
+// jobs.getQueryResults methods. segs is the request path after
+// "/projects/{projectId}/queries".
+func (s *Server) handleQueries(r *http.Request, projectID string, segs []string) (interface{}, error) {
+	switch {
+	case len(segs) == 0 && r.Method == http.MethodPost:
+		return s.query(r, projectID)
+	case len(segs) == 1 && r.Method == http.MethodGet:
+		return s.getQueryResults(r, projectID, segs[0])
+	case len(segs) <= 1:
+		return nil, errMethod(r)
+	}
+	return nil, errNotFound(r)
+}
+
+func jobKey(projectID, jobID string) string {
+	return projectID + ":" + jobID
+}
+
+func errJobNotFound(projectID, jobID string) error {
+	return errorf(http.StatusNotFound, "Not found: Job %s:%s", projectID, jobID)
+}
+
+// lookupJob returns the named job. s.mu must be held.
+func (s *Server) lookupJob(projectID, jobID string) (*job, error) {
+	j := s.jobs[jobKey(projectID, jobID)]
+	if j == nil {
+		return nil, errJobNotFound(projectID, jobID)
+	}
+	return j, nil
+}
+
+// insertJob creates and runs a job from the JSON body of a jobs.insert
+// request. data is the media uploaded with the request, if uploaded is set.
+func (s *Server) insertJob(projectID string, body, data []byte, uploaded bool) (*bq.Job, error) {
+	var meta bq.Job
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	// Decode the query parameters again, keeping explicit nulls.
+	var raw struct {
+		Configuration struct {
+			Query struct {
+				QueryParameters []*queryParameter `json:"queryParameters"`
+			} `json:"query"`
+		} `json:"configuration"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	if meta.Configuration == nil {
+		return nil, errorf(http.StatusBadRequest, "Required parameter is missing: configuration")
+	}
+	var params *queryParams
+	if qc := meta.Configuration.Query; qc != nil {
+		var err error
+		if params, err = decodeParams(qc.ParameterMode, raw.Configuration.Query.QueryParameters); err != nil {
+			return nil, err
+		}
+	}
+	if uploaded && meta.Configuration.Load == nil {
+		return nil, errorf(http.StatusBadRequest, "Media upload is only supported for load jobs")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.runJob(projectID, &meta, params, data, uploaded)
+	if err != nil {
+		return nil, err
+	}
+	return j.meta, nil
+}
+
+// runJob runs a job and, unless it is a dry run, stores it. An error is
+// returned if the job cannot be created; the job's own failure is recorded
+// in its status. s.mu must be held.
+func (s *Server) runJob(projectID string, meta *bq.Job, params *queryParams, data []byte, uploaded bool) (*job, error) {
+	ref := &bq.JobReference{ProjectId: projectID, Location: defaultLocation}
+	if meta.JobReference != nil {
+		ref.JobId = meta.JobReference.JobId
+		if meta.JobReference.Location != "" {
+			ref.Location = meta.JobReference.Location
+		}
+	}
+	if ref.JobId == "" {
+		ref.JobId = s.newID("job")
+	}
+	key := jobKey(projectID, ref.JobId)
+	if s.jobs[key] != nil {
+		return nil, errorf(http.StatusConflict, "Already Exists: Job %s.%s", key, ref.Location)
+	}
+
+	cfg := meta.Configuration
+	now := s.now()
+	stats := &bq.JobStatistics{CreationTime: now, StartTime: now}
+	j := &job{}
+	var err error
+	switch {
+	case cfg.Query != nil:
+		cfg.JobType = "QUERY"
+		j.result, stats.Query, err = s.runQuery(projectID, cfg.Query, params, cfg.DryRun)
+		if err != nil && cfg.DryRun {
+			// Dry runs report errors directly.
+			return nil, err
+		}
+	case cfg.Load != nil:
+		cfg.JobType = "LOAD"
+		stats.Load, err = s.runLoad(projectID, cfg.Load, data, uploaded)
+	case cfg.Extract != nil:
+		cfg.JobType = "EXTRACT"
+		stats.Extract, err = s.runExtract(projectID, cfg.Extract)
+	case cfg.Copy != nil:
+		cfg.JobType = "COPY"
+		err = s.runCopy(projectID, cfg.Copy)
+	default:
+		return nil, errorf(http.StatusBadRequest, "bqtest: unsupported job configuration")
+	}
+	stats.EndTime = s.now()
+
+	jm := *meta
+	jm.Kind = "bigquery#job"
+	jm.Id = fmt.Sprintf("%s:%s.%s", projectID, ref.Location, ref.JobId)
+	jm.JobReference = ref
+	jm.Statistics = stats
+	jm.Status = &bq.JobStatus{State: "DONE"}
+	if err != nil {
+		herr, ok := err.(*httpError)
+		if !ok {
+			herr = errorf(http.StatusInternalServerError, "%v", err)
+			herr.reason = "internalError"
+		}
+		ep := &bq.ErrorProto{Reason: herr.reason, Message: herr.msg}
+		jm.Status.ErrorResult = ep
+		jm.Status.Errors = []*bq.ErrorProto{ep}
+		j.err = herr
+		j.result = nil
+	}
+	j.meta = &jm
+	if !cfg.DryRun {
+		s.jobs[key] = j
+		s.jobOrder = append(s.jobOrder, j)
+	}
+	return j, nil
+}
+
+// runQuery runs a query job, writing its result to the destination table if
+// there is one. s.mu must be held.
+func (s *Server) runQuery(projectID string, cfg *bq.JobConfigurationQuery, params *queryParams, dryRun bool) (*queryResult, *bq.JobStatistics2, error) {
+	if cfg.UseLegacySql != nil && *cfg.UseLegacySql {
+		return nil, nil, invalidQuery("bqtest: legacy SQL is not supported")
+	}
+	if len(cfg.TableDefinitions) > 0 {
+		return nil, nil, invalidQuery("bqtest: external tables are not supported")
+	}
+	q := &queryRunner{
+		s:              s,
+		projectID:      projectID,
+		defaultDataset: cfg.DefaultDataset,
+		params:         params,
+		now:            s.timeNowFunc(),
+	}
+	res, err := q.run(cfg.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats := &bq.JobStatistics2{
+		StatementType: "SELECT",
+		Schema:        &bq.TableSchema{Fields: res.schema},
+	}
+	if dst := cfg.DestinationTable; dst != nil && !dryRun {
+		cd, wd := cfg.CreateDisposition, cfg.WriteDisposition
+		if cd == "" {
+			cd = createIfNeeded
+		}
+		if wd == "" {
+			wd = writeEmpty
+		}
+		if err := s.writeTable(tableRefIn(projectID, dst), res.schema, res.rows, cd, wd, len(cfg.SchemaUpdateOptions) > 0); err != nil {
+			return nil, nil, err
+		}
+	}
+	return res, stats, nil
+}
+
+// tableRefIn returns ref with its project defaulting to projectID.
+func tableRefIn(projectID string, ref *bq.TableReference) *bq.TableReference {
+	r := *ref
+	if r.ProjectId == "" {
+		r.ProjectId = projectID
+	}
+	return &r
+}
+
+// runCopy runs a copy job. s.mu must be held.
+func (s *Server) runCopy(projectID string, cfg *bq.JobConfigurationTableCopy) error {
+	switch cfg.OperationType {
+	case "", "COPY":
+	default:
+		return errorf(http.StatusBadRequest, "bqtest: copy operation %s is not supported", cfg.OperationType)
+	}
+	if cfg.DestinationTable == nil {
+		return errorf(http.StatusBadRequest, "Required parameter is missing: destinationTable")
+	}
+	srcs := cfg.SourceTables
+	if len(srcs) == 0 && cfg.SourceTable != nil {
+		srcs = []*bq.TableReference{cfg.SourceTable}
+	}
+	if len(srcs) == 0 {
+		return errorf(http.StatusBadRequest, "Required parameter is missing: sourceTables")
+	}
+	var fields []*bq.TableFieldSchema
+	var rows []row
+	for i, src := range srcs {
+		ref := tableRefIn(projectID, src)
+		t, err := s.lookupTable(ref.ProjectId, ref.DatasetId, ref.TableId)
+		if err != nil {
+			return err
+		}
+		if t.meta.Type != typeTable {
+			return errorf(http.StatusBadRequest, "Cannot copy from a table of type %s.", t.meta.Type)
+		}
+		if i == 0 {
+			fields = t.schemaFields()
+		} else if !sameFields(fields, t.schemaFields()) {
+			return errorf(http.StatusBadRequest, "Source tables have incompatible schemas.")
+		}
+		rows = append(rows, t.rows...)
+	}
+	cd, wd := cfg.CreateDisposition, cfg.WriteDisposition
+	if cd == "" {
+		cd = createIfNeeded
+	}
+	if wd == "" {
+		wd = writeEmpty
+	}
+	return s.writeTable(tableRefIn(projectID, cfg.DestinationTable), fields, rows, cd, wd, false)
+}
+
+func (s *Server) getJob(projectID, jobID string) (*bq.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.lookupJob(projectID, jobID)
+	if err != nil {
+		return nil, err
+	}
+	return j.meta, nil
+}
+
+func (s *Server) deleteJob(projectID, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.lookupJob(projectID, jobID)
+	if err != nil {
+		return err
+	}
+	delete(s.jobs, jobKey(projectID, jobID))
+	for i, oj := range s.jobOrder {
+		if oj == j {
+			s.jobOrder = append(s.jobOrder[:i:i], s.jobOrder[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// listJobs lists the jobs of a project, most recent first.
+func (s *Server) listJobs(r *http.Request, projectID string) (interface{}, error) {
+	params := r.URL.Query()
+	minTime, _, err := int64Param(params, "minCreationTime")
+	if err != nil {
+		return nil, err
+	}
+	maxTime, hasMax, err := int64Param(params, "maxCreationTime")
+	if err != nil {
+		return nil, err
+	}
+	states := params["stateFilter"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []*bq.JobListJobs
+	for i := len(s.jobOrder) - 1; i >= 0; i-- {
+		j := s.jobOrder[i].meta
+		created := j.Statistics.CreationTime
+		if j.JobReference.ProjectId != projectID || created < minTime || (hasMax && created > maxTime) {
+			continue
+		}
+		// Jobs have no children, since scripts are not supported.
+		if params.Get("parentJobId") != "" {
+			continue
+		}
+		if len(states) > 0 && !containsFold(states, j.Status.State) {
+			continue
+		}
+		items = append(items, &bq.JobListJobs{
+			Kind:          j.Kind,
+			Id:            j.Id,
+			JobReference:  j.JobReference,
+			Configuration: j.Configuration,
+			State:         j.Status.State,
+			Status:        j.Status,
+			ErrorResult:   j.Status.ErrorResult,
+			Statistics:    j.Statistics,
+		})
+	}
+	start, end, next, err := pageBounds(params, len(items))
+	if err != nil {
+		return nil, err
+	}
+	return &bq.JobList{
+		Kind:          "bigquery#jobList",
+		Jobs:          items[start:end],
+		NextPageToken: next,
+	}, nil
+}
+
+// query implements jobs.query, which runs a query job and returns the first
+// page of its results.
+func (s *Server) query(r *http.Request, projectID string) (interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var req bq.QueryRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	var raw struct {
+		QueryParameters []*queryParameter `json:"queryParameters"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	params, err := decodeParams(req.ParameterMode, raw.QueryParameters)
+	if err != nil {
+		return nil, err
+	}
+	meta := &bq.Job{
+		JobReference: &bq.JobReference{Location: req.Location},
+		Configuration: &bq.JobConfiguration{
+			DryRun: req.DryRun,
+			Labels: req.Labels,
+			Query: &bq.JobConfigurationQuery{
+				Query:              req.Query,
+				DefaultDataset:     req.DefaultDataset,
+				UseLegacySql:       req.UseLegacySql,
+				UseQueryCache:      req.UseQueryCache,
+				ParameterMode:      req.ParameterMode,
+				QueryParameters:    req.QueryParameters,
+				MaximumBytesBilled: req.MaximumBytesBilled,
+			},
+		},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.runJob(projectID, meta, params, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if j.err != nil {
+		return nil, j.err
+	}
+	resp := &bq.QueryResponse{
+		Kind:         "bigquery#queryResponse",
+		JobReference: j.meta.JobReference,
+		JobComplete:  true,
+		Schema:       &bq.TableSchema{Fields: j.result.schema},
+		TotalRows:    uint64(len(j.result.rows)),
+	}
+	if req.DryRun {
+		return resp, nil
+	}
+	n := len(j.result.rows)
+	end := n
+	if end > maxPageSize {
+		end = maxPageSize
+	}
+	if req.MaxResults > 0 && int64(end) > req.MaxResults {
+		end = int(req.MaxResults)
+	}
+	resp.Rows = resultRows(j.result, 0, end)
+	if end < n {
+		resp.PageToken = strconv.Itoa(end)
+	}
+	return resp, nil
+}
+
+// getQueryResults implements jobs.getQueryResults. The error of a failed
+// query job is returned as the error of the request.
+func (s *Server) getQueryResults(r *http.Request, projectID, jobID string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := s.lookupJob(projectID, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if j.meta.Configuration.Query == nil {
+		return nil, errorf(http.StatusBadRequest, "Job %s is not a query job.", j.meta.Id)
+	}
+	if j.err != nil {
+		return nil, j.err
+	}
+	start, end, next, err := pageBounds(r.URL.Query(), len(j.result.rows))
+	if err != nil {
+		return nil, err
+	}
+	return &bq.GetQueryResultsResponse{
+		Kind:         "bigquery#getQueryResultsResponse",
+		JobReference: j.meta.JobReference,
+		JobComplete:  true,
+		Schema:       &bq.TableSchema{Fields: j.result.schema},
+		Rows:         resultRows(j.result, start, end),
+		TotalRows:    uint64(len(j.result.rows)),
+		PageToken:    next,
+	}, nil
+}
+
+// resultRows returns rows [start, end) of a query result in the JSON form of
+// the REST API.
+func resultRows(res *queryResult, start, end int) []*bq.TableRow {
+	rows := make([]*bq.TableRow, 0, end-start)
+	for _, r := range res.rows[start:end] {
+		rows = append(rows, rowJSON(res.schema, r))
+	}
+	return rows
+}
@@ -0,0 +1,711 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/civil"
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// Data formats of load and extract jobs.
+const (
+	formatCSV  = "CSV"
+	formatJSON = "NEWLINE_DELIMITED_JSON"
+)
+
+// runLoad runs a load job, reading the data uploaded with the request if
+// uploaded is set, and the job's source URIs otherwise. s.mu must be held.
+func (s *Server) runLoad(projectID string, cfg *bq.JobConfigurationLoad, data []byte, uploaded bool) (*bq.JobStatistics3, error) {
+	if cfg.DestinationTable == nil {
+		return nil, errorf(http.StatusBadRequest, "Required parameter is missing: destinationTable")
+	}
+	ref := tableRefIn(projectID, cfg.DestinationTable)
+	format := cfg.SourceFormat
+	if format == "" {
+		format = formatCSV
+	}
+	if format != formatCSV && format != formatJSON {
+		return nil, errorf(http.StatusBadRequest, "bqtest: source format %s is not supported", format)
+	}
+
+	var inputs [][]byte
+	if uploaded {
+		inputs = [][]byte{data}
+	} else {
+		if len(cfg.SourceUris) == 0 {
+			return nil, errorf(http.StatusBadRequest, "Required parameter is missing: sourceUris")
+		}
+		for _, uri := range cfg.SourceUris {
+			objs, err := s.matchObjects(uri)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, objs...)
+		}
+	}
+	stats := &bq.JobStatistics3{InputFiles: int64(len(inputs))}
+	for i, in := range inputs {
+		stats.InputFileBytes += int64(len(in))
+		d, err := decompress(in)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(cfg.Encoding, "ISO-8859-1") {
+			d = latin1ToUTF8(d)
+		}
+		inputs[i] = d
+	}
+
+	// The schema is the job's, the table's, or detected from the data. A
+	// table that is replaced by detection keeps none of its schema.
+	var fields []*bq.TableFieldSchema
+	if cfg.Schema != nil && len(cfg.Schema.Fields) > 0 {
+		fields = cfg.Schema.Fields
+		if err := validateSchema(fields); err != nil {
+			return nil, errorf(http.StatusBadRequest, "%v", err)
+		}
+	} else if t, err := s.lookupTable(ref.ProjectId, ref.DatasetId, ref.TableId); err == nil && !(cfg.Autodetect && cfg.WriteDisposition == writeTruncate) {
+		fields = t.schemaFields()
+	}
+	var rows []row
+	var err error
+	switch format {
+	case formatCSV:
+		rows, fields, err = readCSV(cfg, fields, inputs)
+	case formatJSON:
+		rows, fields, err = readJSON(cfg, fields, inputs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stats.OutputRows = int64(len(rows))
+	stats.OutputBytes = rowsBytes(rows)
+
+	cd, wd := cfg.CreateDisposition, cfg.WriteDisposition
+	if cd == "" {
+		cd = createIfNeeded
+	}
+	if wd == "" {
+		wd = writeAppend
+	}
+	if err := s.writeTable(ref, fields, rows, cd, wd, len(cfg.SchemaUpdateOptions) > 0); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// matchObjects returns the contents of the objects named by a Cloud Storage
+// URI, which may contain a single "*" wildcard. s.mu must be held.
+func (s *Server) matchObjects(uri string) ([][]byte, error) {
+	if !strings.HasPrefix(uri, "gs://") {
+		return nil, errorf(http.StatusBadRequest, "Invalid source URI %q: it must start with gs://", uri)
+	}
+	i := strings.Index(uri, "*")
+	if i < 0 {
+		data, ok := s.objects[uri]
+		if !ok {
+			return nil, errorf(http.StatusNotFound, "Not found: URI %s", uri)
+		}
+		return [][]byte{data}, nil
+	}
+	prefix, suffix := uri[:i], uri[i+1:]
+	if strings.Contains(suffix, "*") {
+		return nil, errorf(http.StatusBadRequest, "Invalid source URI %q: only one wildcard is allowed", uri)
+	}
+	var names []string
+	for name := range s.objects {
+		if len(name) >= len(prefix)+len(suffix) && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errorf(http.StatusNotFound, "Not found: Uris %s", uri)
+	}
+	sort.Strings(names)
+	var objs [][]byte
+	for _, name := range names {
+		objs = append(objs, s.objects[name])
+	}
+	return objs, nil
+}
+
+// decompress returns data decompressed if it is gzipped, and unchanged
+// otherwise.
+func decompress(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "Error while reading data: %v", err)
+	}
+	d, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "Error while reading data: %v", err)
+	}
+	return d, nil
+}
+
+func latin1ToUTF8(data []byte) []byte {
+	var buf bytes.Buffer
+	for _, b := range data {
+		buf.WriteRune(rune(b))
+	}
+	return buf.Bytes()
+}
+
+// badRecords counts the rows of a load job that could not be read, and fails
+// the job once there are more than the job allows.
+type badRecords struct {
+	max, n int64
+}
+
+func (b *badRecords) add(err error, line int) error {
+	b.n++
+	if b.n > b.max {
+		return errorf(http.StatusBadRequest, "Error while reading data, error message: %v; line: %d", err, line)
+	}
+	return nil
+}
+
+// csvDelimiter returns the field delimiter of a CSV load or extract job.
+func csvDelimiter(d string) (rune, error) {
+	switch d {
+	case "":
+		return ',', nil
+	case "\\t", "tab":
+		return '\t', nil
+	}
+	r, n := utf8.DecodeRuneInString(d)
+	if n != len(d) || r == utf8.RuneError || r == '"' || r == '\n' || r == '\r' {
+		return 0, errorf(http.StatusBadRequest, "Invalid field delimiter %q", d)
+	}
+	return r, nil
+}
+
+// readCSV parses the CSV inputs of a load job. If fields is empty, the schema
+// is detected when the job allows it.
+func readCSV(cfg *bq.JobConfigurationLoad, fields []*bq.TableFieldSchema, inputs [][]byte) ([]row, []*bq.TableFieldSchema, error) {
+	delim, err := csvDelimiter(cfg.FieldDelimiter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Quote != nil && *cfg.Quote != `"` {
+		return nil, nil, errorf(http.StatusBadRequest, "bqtest: only the default CSV quote character is supported")
+	}
+	var header []string
+	var records [][]string
+	var lines []int
+	for _, in := range inputs {
+		cr := csv.NewReader(bytes.NewReader(in))
+		cr.Comma = delim
+		cr.FieldsPerRecord = -1
+		for i := 0; ; i++ {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, errorf(http.StatusBadRequest, "Error while reading data, error message: %v", err)
+			}
+			if int64(i) < cfg.SkipLeadingRows {
+				header = rec
+				continue
+			}
+			records = append(records, rec)
+			lines = append(lines, i+1)
+		}
+	}
+	if len(fields) == 0 {
+		if !cfg.Autodetect {
+			return nil, nil, errorf(http.StatusBadRequest, "No schema specified on job or table.")
+		}
+		fields = detectCSVSchema(header, records)
+	}
+	for _, f := range fields {
+		if canonicalType(f.Type) == "RECORD" || isRepeated(f) {
+			return nil, nil, errorf(http.StatusBadRequest, "CSV cannot load nested or repeated field %s", f.Name)
+		}
+	}
+
+	bad := &badRecords{max: cfg.MaxBadRecords}
+	rows := make([]row, 0, len(records))
+	for i, rec := range records {
+		r, err := csvRow(cfg, fields, rec)
+		if err != nil {
+			if err := bad.add(err, lines[i]); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		rows = append(rows, r)
+	}
+	return rows, fields, nil
+}
+
+// csvRow converts a CSV record to a row.
+func csvRow(cfg *bq.JobConfigurationLoad, fields []*bq.TableFieldSchema, rec []string) (row, error) {
+	if len(rec) > len(fields) && !cfg.IgnoreUnknownValues {
+		return nil, fmt.Errorf("Too many values: expected %d column(s) but got %d", len(fields), len(rec))
+	}
+	if len(rec) < len(fields) && !cfg.AllowJaggedRows {
+		return nil, fmt.Errorf("Too few values: expected %d column(s) but got %d", len(fields), len(rec))
+	}
+	r := make(row, len(fields))
+	for i, f := range fields {
+		var s string
+		isNull := i >= len(rec)
+		if !isNull {
+			s = rec[i]
+			if cfg.NullMarker != "" {
+				isNull = s == cfg.NullMarker
+			} else {
+				isNull = s == ""
+			}
+		}
+		if isNull {
+			if f.Mode == modeRequired {
+				return nil, fmt.Errorf("Missing required field: %s", f.Name)
+			}
+			continue
+		}
+		v, err := parseString(canonicalType(f.Type), s)
+		if err != nil {
+			return nil, fmt.Errorf("%v for field %s", err, f.Name)
+		}
+		r[i] = v
+	}
+	return r, nil
+}
+
+var nonIdentChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// detectCSVSchema infers a schema from CSV records. Columns are named from
+// the header, the last skipped row, if there is one.
+func detectCSVSchema(header []string, records [][]string) []*bq.TableFieldSchema {
+	n := len(header)
+	for _, rec := range records {
+		if len(rec) > n {
+			n = len(rec)
+		}
+	}
+	fields := make([]*bq.TableFieldSchema, n)
+	for i := range fields {
+		name := fmt.Sprintf("string_field_%d", i)
+		if i < len(header) && header[i] != "" {
+			name = nonIdentChars.ReplaceAllString(strings.TrimSpace(header[i]), "_")
+		}
+		var vals []string
+		for _, rec := range records {
+			if i < len(rec) && rec[i] != "" {
+				vals = append(vals, rec[i])
+			}
+		}
+		fields[i] = &bq.TableFieldSchema{Name: name, Type: detectStringType(vals), Mode: modeNullable}
+	}
+	return fields
+}
+
+// detectStringType returns the narrowest type that all of vals can be
+// parsed as.
+func detectStringType(vals []string) string {
+	if len(vals) == 0 {
+		return "STRING"
+	}
+	for _, typ := range []string{"INTEGER", "FLOAT", "BOOLEAN", "DATE", "TIMESTAMP"} {
+		ok := true
+		for _, v := range vals {
+			var err error
+			switch typ {
+			case "BOOLEAN":
+				if lv := strings.ToLower(v); lv != "true" && lv != "false" {
+					err = fmt.Errorf("not a bool")
+				}
+			case "DATE":
+				_, err = civil.ParseDate(v)
+			case "TIMESTAMP":
+				if _, err = strconv.ParseFloat(v, 64); err == nil {
+					err = fmt.Errorf("number")
+				} else {
+					_, err = parseTimestamp(v)
+				}
+			default:
+				_, err = parseString(typ, v)
+			}
+			if err != nil {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return typ
+		}
+	}
+	return "STRING"
+}
+
+// readJSON parses the newline-delimited JSON inputs of a load job. If fields
+// is empty, the schema is detected when the job allows it.
+func readJSON(cfg *bq.JobConfigurationLoad, fields []*bq.TableFieldSchema, inputs [][]byte) ([]row, []*bq.TableFieldSchema, error) {
+	type line struct {
+		obj *jsonObject
+		num int
+	}
+	var lines []line
+	bad := &badRecords{max: cfg.MaxBadRecords}
+	for _, in := range inputs {
+		sc := bufio.NewScanner(bytes.NewReader(in))
+		sc.Buffer(nil, 100<<20)
+		for num := 1; sc.Scan(); num++ {
+			text := bytes.TrimSpace(sc.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			dec := json.NewDecoder(bytes.NewReader(text))
+			dec.UseNumber()
+			v, err := decodeOrdered(dec)
+			obj, ok := v.(*jsonObject)
+			if err == nil && !ok {
+				err = fmt.Errorf("JSON value is not an object")
+			}
+			if err == nil && dec.More() {
+				err = fmt.Errorf("trailing data after JSON object")
+			}
+			if err != nil {
+				if err := bad.add(err, num); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			lines = append(lines, line{obj, num})
+		}
+		if err := sc.Err(); err != nil {
+			return nil, nil, errorf(http.StatusBadRequest, "Error while reading data, error message: %v", err)
+		}
+	}
+	if len(fields) == 0 {
+		if !cfg.Autodetect {
+			return nil, nil, errorf(http.StatusBadRequest, "No schema specified on job or table.")
+		}
+		var objs []*jsonObject
+		for _, l := range lines {
+			objs = append(objs, l.obj)
+		}
+		fields = detectJSONFields(objs)
+	}
+	rows := make([]row, 0, len(lines))
+	for _, l := range lines {
+		r, err := parseRow(fields, l.obj.plain(), cfg.IgnoreUnknownValues, "")
+		if err != nil {
+			if err := bad.add(err, l.num); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		rows = append(rows, r)
+	}
+	return rows, fields, nil
+}
+
+// jsonObject is a decoded JSON object that remembers the order of its keys,
+// for schema detection.
+type jsonObject struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+// decodeOrdered decodes the next JSON value from dec. Objects are returned
+// as *jsonObject.
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := &jsonObject{vals: map[string]interface{}{}}
+		for dec.More() {
+			kt, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			k := kt.(string)
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := obj.vals[k]; !ok {
+				obj.keys = append(obj.keys, k)
+			}
+			obj.vals[k] = v
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// plain returns the object as a map, with nested objects also converted.
+func (o *jsonObject) plain() map[string]interface{} {
+	m := make(map[string]interface{}, len(o.vals))
+	for k, v := range o.vals {
+		m[k] = plainJSON(v)
+	}
+	return m
+}
+
+func plainJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *jsonObject:
+		return v.plain()
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = plainJSON(e)
+		}
+		return out
+	}
+	return v
+}
+
+// detectJSONFields infers a schema from JSON objects. Fields are in the order
+// in which they first appear.
+func detectJSONFields(objs []*jsonObject) []*bq.TableFieldSchema {
+	var fields []*bq.TableFieldSchema
+	for _, o := range objs {
+		for _, k := range o.keys {
+			f := detectJSONField(k, o.vals[k])
+			if f == nil {
+				f = &bq.TableFieldSchema{Name: k}
+			}
+			if i := fieldIndex(fields, k); i >= 0 {
+				fields[i] = mergeDetected(fields[i], f)
+			} else {
+				fields = append(fields, f)
+			}
+		}
+	}
+	for _, f := range fields {
+		defaultDetected(f)
+	}
+	return fields
+}
+
+// detectJSONField returns the field for a JSON value, or nil if the value is
+// null. The type of a field is empty until a non-null value is seen.
+func detectJSONField(name string, v interface{}) *bq.TableFieldSchema {
+	f := &bq.TableFieldSchema{Name: name, Mode: modeNullable}
+	switch v := v.(type) {
+	case string:
+		f.Type = detectStringType([]string{v})
+		if f.Type != "DATE" && f.Type != "TIMESTAMP" {
+			f.Type = "STRING"
+		}
+	case json.Number:
+		f.Type = "FLOAT"
+		if _, err := v.Int64(); err == nil {
+			f.Type = "INTEGER"
+		}
+	case bool:
+		f.Type = "BOOLEAN"
+	case *jsonObject:
+		f.Type = "RECORD"
+		f.Fields = detectJSONFields([]*jsonObject{v})
+	case []interface{}:
+		var elem *bq.TableFieldSchema
+		for _, e := range v {
+			if ef := detectJSONField(name, e); ef != nil {
+				if elem == nil {
+					elem = ef
+				} else {
+					elem = mergeDetected(elem, ef)
+				}
+			}
+		}
+		if elem == nil {
+			elem = &bq.TableFieldSchema{Name: name}
+		}
+		elem.Mode = modeRepeated
+		return elem
+	default:
+		return nil
+	}
+	return f
+}
+
+// mergeDetected combines two detected schemas of the same field.
+func mergeDetected(a, b *bq.TableFieldSchema) *bq.TableFieldSchema {
+	m := *a
+	if b.Mode == modeRepeated {
+		m.Mode = modeRepeated
+	}
+	switch {
+	case a.Type == "":
+		m.Type, m.Fields = b.Type, b.Fields
+	case b.Type == "" || a.Type == b.Type && a.Type != "RECORD":
+	case a.Type == "RECORD" && b.Type == "RECORD":
+		m.Fields = append([]*bq.TableFieldSchema(nil), a.Fields...)
+		for _, bf := range b.Fields {
+			if i := fieldIndex(m.Fields, bf.Name); i >= 0 {
+				m.Fields[i] = mergeDetected(m.Fields[i], bf)
+			} else {
+				m.Fields = append(m.Fields, bf)
+			}
+		}
+	case isNumericType(a.Type) && isNumericType(b.Type):
+		m.Type = "FLOAT"
+	default:
+		m.Type, m.Fields = "STRING", nil
+	}
+	return &m
+}
+
+// defaultDetected gives fields that only had null values the type STRING.
+func defaultDetected(f *bq.TableFieldSchema) {
+	if f.Type == "" {
+		f.Type = "STRING"
+	}
+	if f.Mode == "" {
+		f.Mode = modeNullable
+	}
+	for _, sub := range f.Fields {
+		defaultDetected(sub)
+	}
+}
+
+// runExtract runs an extract job, writing the table to the server's objects.
+// All rows are written to the first destination URI. s.mu must be held.
+func (s *Server) runExtract(projectID string, cfg *bq.JobConfigurationExtract) (*bq.JobStatistics4, error) {
+	if cfg.SourceTable == nil {
+		return nil, errorf(http.StatusBadRequest, "bqtest: only tables can be extracted")
+	}
+	ref := tableRefIn(projectID, cfg.SourceTable)
+	t, err := s.lookupTable(ref.ProjectId, ref.DatasetId, ref.TableId)
+	if err != nil {
+		return nil, err
+	}
+	if t.meta.Type != typeTable {
+		return nil, errorf(http.StatusBadRequest, "Cannot extract from a table of type %s.", t.meta.Type)
+	}
+	uris := cfg.DestinationUris
+	if len(uris) == 0 && cfg.DestinationUri != "" {
+		uris = []string{cfg.DestinationUri}
+	}
+	if len(uris) == 0 {
+		return nil, errorf(http.StatusBadRequest, "Required parameter is missing: destinationUris")
+	}
+	for _, uri := range uris {
+		if !strings.HasPrefix(uri, "gs://") || strings.Count(uri, "*") > 1 {
+			return nil, errorf(http.StatusBadRequest, "Invalid extract destination URI %q", uri)
+		}
+	}
+
+	fields := t.schemaFields()
+	var buf bytes.Buffer
+	switch format := cfg.DestinationFormat; format {
+	case "", formatCSV:
+		if err := writeCSV(&buf, cfg, fields, t.rows); err != nil {
+			return nil, err
+		}
+	case formatJSON:
+		for _, r := range t.rows {
+			b, err := json.Marshal(exportJSON(fields, r))
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(b)
+			buf.WriteByte('\n')
+		}
+	default:
+		return nil, errorf(http.StatusBadRequest, "bqtest: destination format %s is not supported", format)
+	}
+	data := buf.Bytes()
+	switch cfg.Compression {
+	case "", "NONE":
+	case "GZIP":
+		var zbuf bytes.Buffer
+		zw := gzip.NewWriter(&zbuf)
+		zw.Write(data)
+		zw.Close()
+		data = zbuf.Bytes()
+	default:
+		return nil, errorf(http.StatusBadRequest, "bqtest: compression %s is not supported", cfg.Compression)
+	}
+
+	stats := &bq.JobStatistics4{DestinationUriFileCounts: make([]int64, len(uris))}
+	name := strings.Replace(uris[0], "*", "000000000000", 1)
+	s.objects[name] = data
+	stats.DestinationUriFileCounts[0] = 1
+	return stats, nil
+}
+
+// writeCSV writes rows in the CSV format of an extract job.
+func writeCSV(w io.Writer, cfg *bq.JobConfigurationExtract, fields []*bq.TableFieldSchema, rows []row) error {
+	delim, err := csvDelimiter(cfg.FieldDelimiter)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if canonicalType(f.Type) == "RECORD" || isRepeated(f) {
+			return errorf(http.StatusBadRequest, "Operation cannot be performed on a nested schema. Field: %s", f.Name)
+		}
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = delim
+	if cfg.PrintHeader == nil || *cfg.PrintHeader {
+		var header []string
+		for _, f := range fields {
+			header = append(header, f.Name)
+		}
+		cw.Write(header)
+	}
+	for _, r := range rows {
+		rec := make([]string, len(fields))
+		for i, f := range fields {
+			if r[i] != nil {
+				rec[i] = formatValue(canonicalType(f.Type), r[i])
+			}
+		}
+		cw.Write(rec)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,236 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// upload is a resumable upload session for a load job.
+type upload struct {
+	projectID string
+	body      []byte // the JSON job resource
+	data      []byte
+	// result is the job created by the upload, once it has completed.
+	result *bq.Job
+}
+
+// handleUpload handles a request to the upload API, which inserts a load job
+// together with its data. segs is the request path after
+// "/upload/bigquery/v2".
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, segs []string) {
+	if len(segs) != 3 || segs[0] != "projects" || segs[2] != "jobs" {
+		writeError(w, errNotFound(r))
+		return
+	}
+	projectID := segs[1]
+	params := r.URL.Query()
+
+	var resp interface{}
+	var err error
+	switch params.Get("uploadType") {
+	case "multipart":
+		if r.Method != http.MethodPost {
+			writeError(w, errMethod(r))
+			return
+		}
+		resp, err = s.multipartUpload(r, projectID)
+	case "resumable":
+		id := params.Get("upload_id")
+		switch {
+		case id == "" && r.Method == http.MethodPost:
+			s.startResumableUpload(w, r, projectID)
+			return
+		case id != "" && (r.Method == http.MethodPost || r.Method == http.MethodPut):
+			s.resumableUploadChunk(w, r, id)
+			return
+		default:
+			err = errMethod(r)
+		}
+	default:
+		err = errorf(http.StatusBadRequest, "unsupported uploadType %q", params.Get("uploadType"))
+	}
+	writeResponse(w, resp, err)
+}
+
+// multipartUpload handles an upload whose body is a multipart/related message
+// with two parts: the JSON job resource and the data to load.
+func (s *Server) multipartUpload(r *http.Request, projectID string) (interface{}, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, errorf(http.StatusBadRequest, "multipart upload has invalid Content-Type %q", r.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "reading metadata part: %v", err)
+	}
+	body, err := ioutil.ReadAll(part)
+	if err != nil {
+		return nil, err
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "reading media part: %v", err)
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		return nil, err
+	}
+	return s.insertJob(projectID, body, data, true)
+}
+
+// startResumableUpload creates a resumable upload session and returns its URI
+// in the Location header. The job is created when the upload completes.
+func (s *Server) startResumableUpload(w http.ResponseWriter, r *http.Request, projectID string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.mu.Lock()
+	id := s.newID("upload")
+	s.uploads[id] = &upload{projectID: projectID, body: body}
+	s.mu.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("%s/upload/bigquery/v2/projects/%s/jobs?uploadType=resumable&upload_id=%s",
+		s.URL(), url.PathEscape(projectID), id))
+	w.WriteHeader(http.StatusOK)
+}
+
+// resumableUploadChunk handles a request that uploads a chunk of a resumable
+// upload. The Content-Range header gives the offset of the chunk and, for the
+// final chunk, the total size of the data.
+func (s *Server) resumableUploadChunk(w http.ResponseWriter, r *http.Request, id string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	first, total, err := parseContentRange(r.Header.Get("Content-Range"), int64(len(data)))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, errorf(http.StatusNotFound, "upload %q not found", id))
+		return
+	}
+	if u.result != nil {
+		s.mu.Unlock()
+		writeResponse(w, u.result, nil)
+		return
+	}
+	if first >= 0 {
+		persisted := int64(len(u.data))
+		if first > persisted {
+			s.mu.Unlock()
+			writeError(w, errorf(http.StatusBadRequest, "chunk offset %d is past the %d bytes persisted", first, persisted))
+			return
+		}
+		// Bytes that have already been persisted are ignored, so that a chunk
+		// can be retried.
+		if skip := persisted - first; skip < int64(len(data)) {
+			u.data = append(u.data, data[skip:]...)
+		}
+	}
+	size := int64(len(u.data))
+	s.mu.Unlock()
+
+	if total < 0 || size < total {
+		// The upload is incomplete. The service reports this with status
+		// 308, or with an override header if the client asked it to avoid
+		// 308.
+		if size > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", size-1))
+		}
+		if r.Header.Get("X-GUploader-No-308") == "yes" {
+			w.Header().Set("X-Http-Status-Code-Override", "308")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+	if size > total {
+		writeError(w, errorf(http.StatusBadRequest, "upload has %d bytes, more than the total size %d", size, total))
+		return
+	}
+	job, err := s.insertJob(u.projectID, u.body, u.data, true)
+	s.mu.Lock()
+	if err != nil {
+		delete(s.uploads, id)
+	} else {
+		u.result = job
+		u.data = nil
+	}
+	s.mu.Unlock()
+	writeResponse(w, job, err)
+}
+
+// parseContentRange parses the Content-Range header of a resumable upload
+// request: "bytes FIRST-LAST/TOTAL", where the range is "*" for a request
+// without data and the total is "*" if it is not yet known. Unspecified values
+// are returned as -1.
+func parseContentRange(cr string, n int64) (first, total int64, err error) {
+	invalid := errorf(http.StatusBadRequest, "invalid Content-Range %q", cr)
+	if cr == "" {
+		// All of the data in a single request.
+		return 0, n, nil
+	}
+	if !strings.HasPrefix(cr, "bytes ") {
+		return 0, 0, invalid
+	}
+	slash := strings.Index(cr, "/")
+	if slash < 0 {
+		return 0, 0, invalid
+	}
+	rng, tot := cr[len("bytes "):slash], cr[slash+1:]
+	first, total = -1, -1
+	if tot != "*" {
+		if total, err = strconv.ParseInt(tot, 10, 64); err != nil || total < 0 {
+			return 0, 0, invalid
+		}
+	}
+	if rng == "*" {
+		if n != 0 {
+			return 0, 0, invalid
+		}
+		return first, total, nil
+	}
+	dash := strings.Index(rng, "-")
+	if dash < 0 {
+		return 0, 0, invalid
+	}
+	first, err1 := strconv.ParseInt(rng[:dash], 10, 64)
+	last, err2 := strconv.ParseInt(rng[dash+1:], 10, 64)
+	if err1 != nil || err2 != nil || first < 0 || last-first+1 != n {
+		return 0, 0, invalid
+	}
+	return first, total, nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// queryParams holds the parameters of a query. At most one of named and
+// positional is non-nil.
+type queryParams struct {
+	named      map[string]*paramValue // keyed by lower-case name
+	positional []*paramValue
+}
+
+// paramValue is the type and value of a query parameter.
+type paramValue struct {
+	typ *bq.TableFieldSchema
+	val interface{}
+}
+
+// queryParameter is the JSON form of a query parameter. It is decoded
+// separately from the generated bq.QueryParameter so that an explicit null
+// value can be told apart from an empty string, which clients omit.
+type queryParameter struct {
+	Name           string                 `json:"name"`
+	ParameterType  *bq.QueryParameterType `json:"parameterType"`
+	ParameterValue *rawParamValue         `json:"parameterValue"`
+}
+
+type rawParamValue struct {
+	Value        json.RawMessage           `json:"value"`
+	ArrayValues  []*rawParamValue          `json:"arrayValues"`
+	StructValues map[string]*rawParamValue `json:"structValues"`
+}
+
+// decodeParams converts the query parameters of a request. mode is the
+// job's parameterMode, which may be empty.
+func decodeParams(mode string, raw []*queryParameter) (*queryParams, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	ps := &queryParams{}
+	for _, p := range raw {
+		if p.ParameterType == nil {
+			return nil, invalidQuery("Query parameter %s has no type", p.Name)
+		}
+		typ, err := paramType(p.ParameterType)
+		if err != nil {
+			return nil, err
+		}
+		val, err := paramValueOf(typ, p.ParameterValue)
+		if err != nil {
+			return nil, invalidQuery("Invalid value for query parameter %s: %v", p.Name, err)
+		}
+		pv := &paramValue{typ: typ, val: val}
+		if p.Name == "" {
+			if strings.EqualFold(mode, "NAMED") || ps.named != nil {
+				return nil, invalidQuery("Query parameters must all be named or all be positional")
+			}
+			ps.positional = append(ps.positional, pv)
+			continue
+		}
+		if strings.EqualFold(mode, "POSITIONAL") || ps.positional != nil {
+			return nil, invalidQuery("Query parameters must all be named or all be positional")
+		}
+		if ps.named == nil {
+			ps.named = map[string]*paramValue{}
+		}
+		key := strings.ToLower(p.Name)
+		if ps.named[key] != nil {
+			return nil, invalidQuery("Duplicate query parameter %s", p.Name)
+		}
+		ps.named[key] = pv
+	}
+	return ps, nil
+}
+
+// paramType converts a parameter type to the schema of a field of that type.
+func paramType(pt *bq.QueryParameterType) (*bq.TableFieldSchema, error) {
+	switch typ := canonicalType(pt.Type); typ {
+	case "ARRAY":
+		if pt.ArrayType == nil {
+			return nil, invalidQuery("Array query parameter has no element type")
+		}
+		elem, err := paramType(pt.ArrayType)
+		if err != nil {
+			return nil, err
+		}
+		if isRepeated(elem) {
+			return nil, invalidQuery("Arrays of arrays are not supported")
+		}
+		return arrayType(elem), nil
+	case "RECORD":
+		f := &bq.TableFieldSchema{Type: typ}
+		for i, st := range pt.StructTypes {
+			ft, err := paramType(st.Type)
+			if err != nil {
+				return nil, err
+			}
+			// Copy, since ft may be shared with an array's element type.
+			sub := *ft
+			sub.Name = st.Name
+			if sub.Name == "" {
+				// Anonymous fields are given positional names, as in
+				// query results.
+				sub.Name = "_field_" + strconv.Itoa(i+1)
+			}
+			f.Fields = append(f.Fields, &sub)
+		}
+		if len(f.Fields) == 0 {
+			return nil, invalidQuery("Struct query parameter has no fields")
+		}
+		return f, nil
+	default:
+		if !validTypes[typ] {
+			return nil, invalidQuery("Unsupported query parameter type %s", pt.Type)
+		}
+		return scalarType(typ), nil
+	}
+}
+
+// paramValueOf converts a parameter value to a value of type typ. A missing
+// scalar value is the empty string for STRING parameters and NULL otherwise.
+func paramValueOf(typ *bq.TableFieldSchema, v *rawParamValue) (interface{}, error) {
+	if isRepeated(typ) {
+		if v == nil {
+			return []interface{}{}, nil
+		}
+		elem := elemType(typ)
+		arr := make([]interface{}, len(v.ArrayValues))
+		for i, av := range v.ArrayValues {
+			ev, err := paramValueOf(elem, av)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = ev
+		}
+		return arr, nil
+	}
+	if typ.Type == "RECORD" {
+		if v == nil || v.StructValues == nil {
+			return nil, nil
+		}
+		rec := make([]interface{}, len(typ.Fields))
+		for i, f := range typ.Fields {
+			fv, err := paramValueOf(f, v.StructValues[f.Name])
+			if err != nil {
+				return nil, err
+			}
+			rec[i] = fv
+		}
+		return rec, nil
+	}
+	if v == nil || v.Value == nil {
+		if typ.Type == "STRING" {
+			return "", nil
+		}
+		return nil, nil
+	}
+	var s *string
+	if err := json.Unmarshal(v.Value, &s); err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, nil
+	}
+	return parseString(typ.Type, *s)
+}
@@ -0,0 +1,1286 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file parses the subset of GoogleSQL supported by the server into a
+// syntax tree. Names are not resolved and types are not checked until the
+// statement is compiled.
+
+type tokenKind int
+
+const (
+	tokEOF        tokenKind = iota
+	tokIdent                // identifier or keyword
+	tokQuoted               // `quoted identifier`
+	tokInt                  // integer literal
+	tokFloat                // floating-point literal
+	tokString               // string literal
+	tokBytes                // bytes literal
+	tokParam                // @name
+	tokPositional           // ?
+	tokOp                   // operator or punctuation
+)
+
+type token struct {
+	kind tokenKind
+	text string // the unquoted text of the token
+	pos  int    // byte offset in the statement
+}
+
+// lex splits a statement into tokens.
+func lex(sql string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(sql) {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || (c == '-' && strings.HasPrefix(sql[i:], "--")):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return nil, syntaxError(sql, i, "unclosed comment")
+			}
+			i += end + 4
+		case isIdentStart(c):
+			start := i
+			for i < len(sql) && isIdentPart(sql[i]) {
+				i++
+			}
+			word := sql[start:i]
+			// String and bytes literals may have b and r prefixes.
+			if i < len(sql) && (sql[i] == '\'' || sql[i] == '"') && isLiteralPrefix(word) {
+				prefix := strings.ToLower(word)
+				s, n, err := lexString(sql, i, strings.Contains(prefix, "r"))
+				if err != nil {
+					return nil, err
+				}
+				kind := tokString
+				if strings.Contains(prefix, "b") {
+					kind = tokBytes
+				}
+				toks = append(toks, token{kind, s, start})
+				i = n
+				continue
+			}
+			toks = append(toks, token{tokIdent, word, start})
+		case c == '`':
+			end := strings.IndexByte(sql[i+1:], '`')
+			if end < 0 {
+				return nil, syntaxError(sql, i, "unclosed quoted identifier")
+			}
+			toks = append(toks, token{tokQuoted, sql[i+1 : i+1+end], i})
+			i += end + 2
+		case c == '\'' || c == '"':
+			s, n, err := lexString(sql, i, false)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{tokString, s, i})
+			i = n
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			start := i
+			kind := tokInt
+			if strings.HasPrefix(strings.ToLower(sql[i:]), "0x") {
+				i += 2
+				for i < len(sql) && strings.IndexByte("0123456789abcdefABCDEF", sql[i]) >= 0 {
+					i++
+				}
+			} else {
+				for i < len(sql) && isDigit(sql[i]) {
+					i++
+				}
+				if i < len(sql) && sql[i] == '.' {
+					kind = tokFloat
+					i++
+					for i < len(sql) && isDigit(sql[i]) {
+						i++
+					}
+				}
+				if i < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+					kind = tokFloat
+					i++
+					if i < len(sql) && (sql[i] == '+' || sql[i] == '-') {
+						i++
+					}
+					for i < len(sql) && isDigit(sql[i]) {
+						i++
+					}
+				}
+			}
+			if i < len(sql) && isIdentStart(sql[i]) {
+				return nil, syntaxError(sql, start, "invalid number")
+			}
+			toks = append(toks, token{kind, sql[start:i], start})
+		case c == '@':
+			start := i
+			i++
+			for i < len(sql) && isIdentPart(sql[i]) {
+				i++
+			}
+			if i == start+1 {
+				return nil, syntaxError(sql, start, "missing parameter name")
+			}
+			toks = append(toks, token{tokParam, sql[start+1 : i], start})
+		case c == '?':
+			toks = append(toks, token{tokPositional, "?", i})
+			i++
+		default:
+			op := ""
+			for _, o := range []string{"<=", ">=", "<>", "!=", "||", "<<", ">>"} {
+				if strings.HasPrefix(sql[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				if strings.IndexByte("(),.;*/+-=<>[]&|^~", c) < 0 {
+					r, _ := utf8.DecodeRuneInString(sql[i:])
+					return nil, syntaxError(sql, i, "unexpected character %q", r)
+				}
+				op = string(c)
+			}
+			toks = append(toks, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tokEOF, "", len(sql)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLiteralPrefix(word string) bool {
+	switch strings.ToLower(word) {
+	case "b", "r", "br", "rb":
+		return true
+	}
+	return false
+}
+
+// lexString reads a quoted string starting at sql[i], returning its value and
+// the offset after its closing quote.
+func lexString(sql string, i int, raw bool) (string, int, error) {
+	start := i
+	quote := sql[i : i+1]
+	if strings.HasPrefix(sql[i:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	i += len(quote)
+	var b strings.Builder
+	for {
+		if i >= len(sql) {
+			return "", 0, syntaxError(sql, start, "unclosed string literal")
+		}
+		if strings.HasPrefix(sql[i:], quote) {
+			return b.String(), i + len(quote), nil
+		}
+		c := sql[i]
+		if c == '\n' && len(quote) == 1 {
+			return "", 0, syntaxError(sql, start, "unclosed string literal")
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		if i+1 >= len(sql) {
+			return "", 0, syntaxError(sql, start, "unclosed string literal")
+		}
+		if raw {
+			b.WriteString(sql[i : i+2])
+			i += 2
+			continue
+		}
+		e := sql[i+1]
+		i += 2
+		switch e {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x', 'u', 'U':
+			n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[e]
+			if i+n > len(sql) {
+				return "", 0, syntaxError(sql, i, "invalid escape sequence")
+			}
+			v, err := strconv.ParseUint(sql[i:i+n], 16, 32)
+			if err != nil {
+				return "", 0, syntaxError(sql, i, "invalid escape sequence")
+			}
+			if e == 'x' {
+				b.WriteByte(byte(v))
+			} else {
+				b.WriteRune(rune(v))
+			}
+			i += n
+		default:
+			b.WriteByte(e)
+		}
+	}
+}
+
+// syntaxError returns an error for a problem at offset pos of sql, reporting
+// the position as a line and column like the service.
+func syntaxError(sql string, pos int, format string, args ...interface{}) error {
+	line, col := 1, 1
+	for _, r := range sql[:pos] {
+		if r == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return invalidQuery("Syntax error: %s at [%d:%d]", fmt.Sprintf(format, args...), line, col)
+}
+
+// Syntax tree.
+
+type selectStmt struct {
+	distinct bool
+	items    []*selectItem
+	from     *fromItem
+	where    expr
+	groupBy  []expr
+	having   expr
+	orderBy  []*orderItem
+	limit    expr
+	offset   expr
+}
+
+type selectItem struct {
+	star      bool     // * or qualifier.*
+	qualifier []string // for qualifier.*
+	except    []string // SELECT * EXCEPT (...)
+	expr      expr
+	alias     string
+}
+
+// fromItem is a table or a subquery.
+type fromItem struct {
+	path     []string    // table name parts, split at dots
+	subquery *selectStmt // or a subquery
+	alias    string
+}
+
+type orderItem struct {
+	expr       expr
+	desc       bool
+	nullsFirst *bool
+}
+
+type expr interface{}
+
+type (
+	literal struct {
+		typ string // canonical type
+		val interface{}
+	}
+	columnRef struct {
+		parts []string
+		pos   int
+	}
+	paramRef struct {
+		name     string // for named parameters
+		position int    // for positional parameters, from 0
+		pos      int
+	}
+	unaryExpr struct {
+		op string // "-", "+", "NOT", "~"
+		x  expr
+	}
+	binaryExpr struct {
+		op   string // "+", "-", "*", "/", "||", "=", "!=", "<", "<=", ">", ">=", "AND", "OR", ...
+		l, r expr
+	}
+	isExpr struct {
+		x   expr
+		not bool
+		val interface{} // nil for IS NULL, or true or false
+	}
+	inExpr struct {
+		x      expr
+		list   []expr // IN (a, b, ...)
+		unnest expr   // IN UNNEST(array)
+		not    bool
+	}
+	betweenExpr struct {
+		x, lo, hi expr
+		not       bool
+	}
+	likeExpr struct {
+		x, pattern expr
+		not        bool
+	}
+	callExpr struct {
+		name     string // upper case
+		args     []expr
+		star     bool // COUNT(*)
+		distinct bool
+		pos      int
+	}
+	castExpr struct {
+		x    expr
+		typ  string // canonical type
+		safe bool
+	}
+	caseExpr struct {
+		operand expr // nil for a searched CASE
+		whens   []*whenClause
+		els     expr
+	}
+	whenClause struct {
+		cond, result expr
+	}
+	arrayExpr struct {
+		elems []expr
+	}
+	extractExpr struct {
+		part string
+		x    expr
+	}
+)
+
+// parser is a recursive descent parser over a token slice.
+type parser struct {
+	sql        string
+	toks       []token
+	i          int
+	positional int // number of positional parameters seen
+}
+
+// parseQuery parses a single SELECT statement, with an optional trailing
+// semicolon.
+func parseQuery(sql string) (*selectStmt, error) {
+	toks, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{sql: sql, toks: toks}
+	if p.peekKeyword("WITH", "INSERT", "UPDATE", "DELETE", "MERGE", "CREATE", "DROP", "ALTER", "DECLARE", "BEGIN") {
+		return nil, invalidQuery("bqtest: only SELECT statements are supported, not %s", strings.ToUpper(p.peek().text))
+	}
+	stmt, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptOp(";")
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "Expected end of input but got %s", describe(t))
+	}
+	return stmt, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return syntaxError(p.sql, t.pos, format, args...)
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokIdent:
+		if reserved[strings.ToUpper(t.text)] {
+			return "keyword " + strings.ToUpper(t.text)
+		}
+		return "identifier " + t.text
+	case tokQuoted:
+		return "identifier `" + t.text + "`"
+	case tokString, tokBytes:
+		return "string literal"
+	case tokInt, tokFloat:
+		return "number " + t.text
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// reserved holds the reserved keywords used by the supported grammar, which
+// cannot be used as unquoted identifiers.
+var reserved = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`ALL AND ANY ARRAY AS ASC BETWEEN BY CASE CAST CROSS DESC DISTINCT ELSE END
+		EXCEPT EXISTS EXTRACT FALSE FROM FULL GROUP HAVING IF IN INNER INTERVAL IS JOIN LEFT LIKE LIMIT
+		NOT NULL NULLS OFFSET ON OR ORDER OUTER RIGHT SELECT STRUCT THEN TRUE UNION UNNEST USING WHEN WHERE
+		WINDOW WITH`) {
+		reserved[k] = true
+	}
+}
+
+func (p *parser) peekKeyword(kws ...string) bool {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return false
+	}
+	for _, kw := range kws {
+		if strings.EqualFold(t.text, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.peekKeyword(kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.errorf(p.peek(), "Expected keyword %s but got %s", kw, describe(p.peek()))
+	}
+	return nil
+}
+
+func (p *parser) peekOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if p.peekOp(op) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.errorf(p.peek(), "Expected %q but got %s", op, describe(p.peek()))
+	}
+	return nil
+}
+
+// parseIdent parses an identifier, quoted or not. Keywords are not allowed.
+func (p *parser) parseIdent() (string, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokQuoted:
+		p.i++
+		return t.text, nil
+	case t.kind == tokIdent && !reserved[strings.ToUpper(t.text)]:
+		p.i++
+		return t.text, nil
+	}
+	return "", p.errorf(t, "Unexpected %s", describe(t))
+}
+
+func (p *parser) parseSelect() (*selectStmt, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	stmt := &selectStmt{}
+	if p.acceptKeyword("DISTINCT") {
+		stmt.distinct = true
+	} else {
+		p.acceptKeyword("ALL")
+	}
+	for {
+		item, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+		stmt.items = append(stmt.items, item)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	var err error
+	if p.acceptKeyword("FROM") {
+		if stmt.from, err = p.parseFromItem(); err != nil {
+			return nil, err
+		}
+		if p.peekOp(",") || p.peekKeyword("JOIN", "INNER", "LEFT", "RIGHT", "FULL", "CROSS") {
+			return nil, invalidQuery("bqtest: joins are not supported")
+		}
+	}
+	if p.acceptKeyword("WHERE") {
+		if stmt.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if stmt.groupBy, err = p.parseExprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("HAVING") {
+		if stmt.having, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peekKeyword("UNION", "INTERSECT", "EXCEPT", "WINDOW", "QUALIFY") {
+		return nil, invalidQuery("bqtest: %s is not supported", strings.ToUpper(p.peek().text))
+	}
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := &orderItem{expr: e}
+			if p.acceptKeyword("DESC") {
+				item.desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			if p.acceptKeyword("NULLS") {
+				first := p.acceptKeyword("FIRST")
+				if !first {
+					if err := p.expectKeyword("LAST"); err != nil {
+						return nil, err
+					}
+				}
+				item.nullsFirst = &first
+			}
+			stmt.orderBy = append(stmt.orderBy, item)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		if stmt.limit, err = p.parsePrimary(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("OFFSET") {
+			if stmt.offset, err = p.parsePrimary(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseSelectItem() (*selectItem, error) {
+	if p.acceptOp("*") {
+		item := &selectItem{star: true}
+		return item, p.parseExcept(item)
+	}
+	// qualifier.*
+	start := p.i
+	var qual []string
+	for {
+		t := p.peek()
+		if t.kind != tokIdent && t.kind != tokQuoted {
+			break
+		}
+		if t.kind == tokIdent && reserved[strings.ToUpper(t.text)] {
+			break
+		}
+		if p.toks[p.i+1].kind != tokOp || p.toks[p.i+1].text != "." {
+			break
+		}
+		qual = append(qual, t.text)
+		p.i += 2
+		if p.acceptOp("*") {
+			item := &selectItem{star: true, qualifier: qual}
+			return item, p.parseExcept(item)
+		}
+	}
+	p.i = start
+
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	item := &selectItem{expr: e}
+	if p.acceptKeyword("AS") {
+		if item.alias, err = p.parseIdent(); err != nil {
+			return nil, err
+		}
+	} else if t := p.peek(); t.kind == tokQuoted || (t.kind == tokIdent && !reserved[strings.ToUpper(t.text)]) {
+		item.alias = t.text
+		p.i++
+	}
+	return item, nil
+}
+
+func (p *parser) parseExcept(item *selectItem) error {
+	if !p.acceptKeyword("EXCEPT") {
+		return nil
+	}
+	if err := p.expectOp("("); err != nil {
+		return err
+	}
+	for {
+		name, err := p.parseIdent()
+		if err != nil {
+			return err
+		}
+		item.except = append(item.except, name)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return p.expectOp(")")
+}
+
+func (p *parser) parseFromItem() (*fromItem, error) {
+	item := &fromItem{}
+	if p.acceptOp("(") {
+		sub, err := p.parseSelect()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		item.subquery = sub
+	} else {
+		if p.peekKeyword("UNNEST") {
+			return nil, invalidQuery("bqtest: UNNEST in FROM is not supported")
+		}
+		path, err := p.parseTablePath()
+		if err != nil {
+			return nil, err
+		}
+		item.path = path
+	}
+	var err error
+	if p.acceptKeyword("AS") {
+		if item.alias, err = p.parseIdent(); err != nil {
+			return nil, err
+		}
+	} else if t := p.peek(); t.kind == tokQuoted || (t.kind == tokIdent && !reserved[strings.ToUpper(t.text)]) {
+		item.alias = t.text
+		p.i++
+	}
+	return item, nil
+}
+
+// parseTablePath parses a table name such as `project.dataset.table`,
+// dataset.table or my-project.dataset.table, returning its parts.
+func (p *parser) parseTablePath() ([]string, error) {
+	var path []string
+	for {
+		t := p.peek()
+		var part string
+		switch {
+		case t.kind == tokQuoted:
+			p.i++
+			path = append(path, strings.Split(t.text, ".")...)
+			if !p.acceptOp(".") {
+				return path, nil
+			}
+			continue
+		case t.kind == tokIdent:
+			p.i++
+			part = t.text
+			// Project IDs may contain dashes without being quoted.
+			for p.peekOp("-") && (p.toks[p.i+1].kind == tokIdent || p.toks[p.i+1].kind == tokInt) && p.toks[p.i+1].pos == p.peek().pos+1 {
+				part += "-" + p.toks[p.i+1].text
+				p.i += 2
+			}
+		default:
+			return nil, p.errorf(t, "Unexpected %s", describe(t))
+		}
+		path = append(path, part)
+		if !p.acceptOp(".") {
+			return path, nil
+		}
+	}
+}
+
+func (p *parser) parseExprList() ([]expr, error) {
+	var es []expr
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+		if !p.acceptOp(",") {
+			return es, nil
+		}
+	}
+}
+
+// parseExpr parses an expression. Operator precedence, from lowest to
+// highest, is OR; AND; NOT; comparisons, IS, IN, BETWEEN and LIKE; |; ^; &;
+// << and >>; + and -; *, / and ||; and unary operators.
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "OR", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "AND", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.acceptKeyword("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "NOT", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	l, err := p.parseBitOr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind == tokOp {
+			switch t.text {
+			case "=", "!=", "<>", "<", "<=", ">", ">=":
+				p.i++
+				r, err := p.parseBitOr()
+				if err != nil {
+					return nil, err
+				}
+				op := t.text
+				if op == "<>" {
+					op = "!="
+				}
+				l = &binaryExpr{op: op, l: l, r: r}
+				continue
+			}
+			return l, nil
+		}
+		if p.acceptKeyword("IS") {
+			e := &isExpr{x: l, not: p.acceptKeyword("NOT")}
+			switch {
+			case p.acceptKeyword("NULL"):
+			case p.acceptKeyword("TRUE"):
+				e.val = true
+			case p.acceptKeyword("FALSE"):
+				e.val = false
+			default:
+				return nil, p.errorf(p.peek(), "Expected NULL, TRUE or FALSE but got %s", describe(p.peek()))
+			}
+			l = e
+			continue
+		}
+		not := false
+		if p.peekKeyword("NOT") && p.toks[p.i+1].kind == tokIdent {
+			switch strings.ToUpper(p.toks[p.i+1].text) {
+			case "IN", "BETWEEN", "LIKE":
+				p.i++
+				not = true
+			}
+		}
+		switch {
+		case p.acceptKeyword("IN"):
+			e := &inExpr{x: l, not: not}
+			if p.acceptKeyword("UNNEST") {
+				if err := p.expectOp("("); err != nil {
+					return nil, err
+				}
+				if e.unnest, err = p.parseExpr(); err != nil {
+					return nil, err
+				}
+			} else {
+				if err := p.expectOp("("); err != nil {
+					return nil, err
+				}
+				if p.peekKeyword("SELECT") {
+					return nil, invalidQuery("bqtest: subqueries are only supported in FROM")
+				}
+				if e.list, err = p.parseExprList(); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			l = e
+		case p.acceptKeyword("BETWEEN"):
+			lo, err := p.parseBitOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("AND"); err != nil {
+				return nil, err
+			}
+			hi, err := p.parseBitOr()
+			if err != nil {
+				return nil, err
+			}
+			l = &betweenExpr{x: l, lo: lo, hi: hi, not: not}
+		case p.acceptKeyword("LIKE"):
+			pat, err := p.parseBitOr()
+			if err != nil {
+				return nil, err
+			}
+			l = &likeExpr{x: l, pattern: pat, not: not}
+		default:
+			return l, nil
+		}
+	}
+}
+
+// parseBinary parses a left-associative sequence of operands separated by
+// any of ops.
+func (p *parser) parseBinary(operand func() (expr, error), ops ...string) (expr, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		matched := false
+		if t.kind == tokOp {
+			for _, op := range ops {
+				if t.text == op {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			return l, nil
+		}
+		p.i++
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: t.text, l: l, r: r}
+	}
+}
+
+func (p *parser) parseBitOr() (expr, error) {
+	return p.parseBinary(p.parseBitXor, "|")
+}
+
+func (p *parser) parseBitXor() (expr, error) {
+	return p.parseBinary(p.parseBitAnd, "^")
+}
+
+func (p *parser) parseBitAnd() (expr, error) {
+	return p.parseBinary(p.parseShift, "&")
+}
+
+func (p *parser) parseShift() (expr, error) {
+	return p.parseBinary(p.parseAdditive, "<<", ">>")
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "||")
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	for _, op := range []string{"-", "+", "~"} {
+		if p.acceptOp(op) {
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			// Fold negative numeric literals so that the smallest INT64 can
+			// be written.
+			if lit, ok := x.(*literal); ok && op == "-" {
+				switch v := lit.val.(type) {
+				case int64:
+					return &literal{typ: lit.typ, val: -v}, nil
+				case float64:
+					return &literal{typ: lit.typ, val: -v}, nil
+				}
+			}
+			return &unaryExpr{op: op, x: x}, nil
+		}
+	}
+	return p.parseField()
+}
+
+// parseField parses a primary expression followed by field accesses, as in
+// (expr).field.
+func (p *parser) parseField() (expr, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp(".") {
+		if _, ok := x.(*columnRef); ok {
+			break // handled by parsePrimary
+		}
+		p.i++
+		name, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		x = &callExpr{name: ".", args: []expr{x, &literal{typ: "STRING", val: name}}}
+	}
+	if p.peekOp("[") {
+		return nil, invalidQuery("bqtest: array subscripts are not supported")
+	}
+	return x, nil
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokInt:
+		p.i++
+		var n int64
+		var err error
+		if strings.HasPrefix(strings.ToLower(t.text), "0x") {
+			var u uint64
+			u, err = strconv.ParseUint(t.text[2:], 16, 64)
+			n = int64(u)
+		} else {
+			n, err = strconv.ParseInt(t.text, 10, 64)
+			// -9223372036854775808 is parsed as the negation of a
+			// literal that overflows; it is folded in parseUnary.
+			if err != nil && t.text == "9223372036854775808" && p.i >= 2 && p.toks[p.i-2].text == "-" {
+				return &literal{typ: "INTEGER", val: int64(-1 << 63)}, nil
+			}
+		}
+		if err != nil {
+			return nil, p.errorf(t, "Invalid integer literal: %s", t.text)
+		}
+		return &literal{typ: "INTEGER", val: n}, nil
+	case tokFloat:
+		p.i++
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "Invalid floating point literal: %s", t.text)
+		}
+		return &literal{typ: "FLOAT", val: f}, nil
+	case tokString:
+		p.i++
+		return &literal{typ: "STRING", val: t.text}, nil
+	case tokBytes:
+		p.i++
+		return &literal{typ: "BYTES", val: []byte(t.text)}, nil
+	case tokParam:
+		p.i++
+		return &paramRef{name: t.text, position: -1, pos: t.pos}, nil
+	case tokPositional:
+		p.i++
+		p.positional++
+		return &paramRef{position: p.positional - 1, pos: t.pos}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			p.i++
+			if p.peekKeyword("SELECT") {
+				return nil, invalidQuery("bqtest: subqueries are only supported in FROM")
+			}
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if p.peekOp(",") {
+				return nil, invalidQuery("bqtest: STRUCT values are not supported")
+			}
+			return e, p.expectOp(")")
+		case "[":
+			p.i++
+			return p.parseArrayElems()
+		}
+	case tokQuoted:
+		return p.parseColumnRef()
+	case tokIdent:
+		return p.parseIdentExpr()
+	}
+	return nil, p.errorf(t, "Unexpected %s", describe(t))
+}
+
+// parseArrayElems parses the elements of an array literal after its opening
+// bracket.
+func (p *parser) parseArrayElems() (expr, error) {
+	a := &arrayExpr{}
+	if p.acceptOp("]") {
+		return a, nil
+	}
+	elems, err := p.parseExprList()
+	if err != nil {
+		return nil, err
+	}
+	a.elems = elems
+	return a, p.expectOp("]")
+}
+
+func (p *parser) parseColumnRef() (expr, error) {
+	ref := &columnRef{pos: p.peek().pos}
+	for {
+		name, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		ref.parts = append(ref.parts, name)
+		if !p.acceptOp(".") {
+			return ref, nil
+		}
+	}
+}
+
+// typedLiteralTypes are the types that can prefix a string literal, as in
+// DATE '2021-01-02'.
+var typedLiteralTypes = map[string]bool{
+	"DATE": true, "TIME": true, "DATETIME": true, "TIMESTAMP": true, "NUMERIC": true, "BIGNUMERIC": true,
+	"DECIMAL": true, "BIGDECIMAL": true, "JSON": true,
+}
+
+func (p *parser) parseIdentExpr() (expr, error) {
+	t := p.peek()
+	kw := strings.ToUpper(t.text)
+	switch kw {
+	case "NULL":
+		p.i++
+		return &literal{}, nil
+	case "TRUE", "FALSE":
+		p.i++
+		return &literal{typ: "BOOLEAN", val: kw == "TRUE"}, nil
+	case "CAST", "SAFE_CAST":
+		p.i++
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AS"); err != nil {
+			return nil, err
+		}
+		typ, err := p.parseTypeName()
+		if err != nil {
+			return nil, err
+		}
+		return &castExpr{x: x, typ: typ, safe: kw == "SAFE_CAST"}, p.expectOp(")")
+	case "CASE":
+		p.i++
+		return p.parseCase()
+	case "EXTRACT":
+		p.i++
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		part := p.next()
+		if part.kind != tokIdent {
+			return nil, p.errorf(part, "Expected a date part but got %s", describe(part))
+		}
+		if err := p.expectKeyword("FROM"); err != nil {
+			return nil, err
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &extractExpr{part: strings.ToUpper(part.text), x: x}, p.expectOp(")")
+	case "ARRAY":
+		p.i++
+		if err := p.expectOp("["); err != nil {
+			return nil, err
+		}
+		return p.parseArrayElems()
+	case "IF":
+		// IF is a reserved keyword but also a function.
+		if p.toks[p.i+1].kind == tokOp && p.toks[p.i+1].text == "(" {
+			return p.parseCall()
+		}
+	case "INTERVAL", "STRUCT", "EXISTS":
+		return nil, invalidQuery("bqtest: %s is not supported", kw)
+	}
+	if typedLiteralTypes[kw] && p.toks[p.i+1].kind == tokString {
+		p.i++
+		s := p.next().text
+		typ := canonicalType(kw)
+		if typ == "JSON" {
+			return nil, invalidQuery("bqtest: JSON is not supported")
+		}
+		v, err := parseString(typ, s)
+		if err != nil {
+			return nil, p.errorf(t, "Invalid %s literal %q", typ, s)
+		}
+		return &literal{typ: typ, val: v}, nil
+	}
+	if reserved[kw] {
+		return nil, p.errorf(t, "Unexpected %s", describe(t))
+	}
+	if p.toks[p.i+1].kind == tokOp && p.toks[p.i+1].text == "(" {
+		return p.parseCall()
+	}
+	return p.parseColumnRef()
+}
+
+func (p *parser) parseCall() (expr, error) {
+	t := p.next()
+	p.next() // (
+	call := &callExpr{name: strings.ToUpper(t.text), pos: t.pos}
+	switch {
+	case p.acceptOp(")"):
+	case p.acceptOp("*"):
+		call.star = true
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	default:
+		call.distinct = p.acceptKeyword("DISTINCT")
+		args, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		call.args = args
+		// STRING_AGG and ARRAY_AGG may have ORDER BY and LIMIT clauses,
+		// which are not supported.
+		if p.peekKeyword("ORDER", "LIMIT", "IGNORE", "RESPECT") {
+			return nil, invalidQuery("bqtest: %s in aggregate function calls is not supported", strings.ToUpper(p.peek().text))
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+	if p.peekKeyword("OVER") {
+		return nil, invalidQuery("bqtest: window functions are not supported")
+	}
+	return call, nil
+}
+
+// parseTypeName parses the name of a scalar type, with optional
+// parameters such as STRING(10) or NUMERIC(10, 2), which are ignored.
+func (p *parser) parseTypeName() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return "", p.errorf(t, "Expected a type name but got %s", describe(t))
+	}
+	typ := canonicalType(t.text)
+	if !validTypes[typ] || typ == "RECORD" {
+		if strings.EqualFold(t.text, "ARRAY") || strings.EqualFold(t.text, "STRUCT") {
+			return "", invalidQuery("bqtest: casts to %s are not supported", strings.ToUpper(t.text))
+		}
+		return "", p.errorf(t, "Type not found: %s", t.text)
+	}
+	if p.acceptOp("(") {
+		for !p.acceptOp(")") {
+			if p.peek().kind == tokEOF {
+				return "", p.errorf(p.peek(), "Unexpected end of input")
+			}
+			p.i++
+		}
+	}
+	return typ, nil
+}
+
+func (p *parser) parseCase() (expr, error) {
+	c := &caseExpr{}
+	var err error
+	if !p.peekKeyword("WHEN") {
+		if c.operand, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.acceptKeyword("WHEN") {
+		w := &whenClause{}
+		if w.cond, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		if w.result, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, w)
+	}
+	if len(c.whens) == 0 {
+		return nil, p.errorf(p.peek(), "Expected keyword WHEN but got %s", describe(p.peek()))
+	}
+	if p.acceptKeyword("ELSE") {
+		if c.els, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expectKeyword("END")
+}
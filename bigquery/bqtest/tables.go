@@ -0,0 +1,589 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// table is a table or view and its rows.
+type table struct {
+	meta *bq.Table
+	rows []row
+	// insertIDs holds the insert IDs of rows added by insertAll, which are
+	// used to drop duplicate rows.
+	insertIDs map[string]bool
+}
+
+// Table types.
+const (
+	typeTable = "TABLE"
+	typeView  = "VIEW"
+)
+
+// Write and create dispositions of jobs.
+const (
+	writeAppend     = "WRITE_APPEND"
+	writeTruncate   = "WRITE_TRUNCATE"
+	writeEmpty      = "WRITE_EMPTY"
+	createIfNeeded  = "CREATE_IF_NEEDED"
+	createNever     = "CREATE_NEVER"
+	tableIDTemplate = "%s:%s.%s"
+)
+
+// schemaFields returns the top-level fields of a table's schema.
+func (t *table) schemaFields() []*bq.TableFieldSchema {
+	if t.meta.Schema == nil {
+		return nil
+	}
+	return t.meta.Schema.Fields
+}
+
+// handleTables dispatches a request for tables and table data. segs is the
+// request path after "/projects/{projectId}/datasets/{datasetId}/tables".
+func (s *Server) handleTables(r *http.Request, projectID, datasetID string, segs []string) (interface{}, error) {
+	switch len(segs) {
+	case 0:
+		switch r.Method {
+		case http.MethodGet:
+			return s.listTables(r, projectID, datasetID)
+		case http.MethodPost:
+			return s.insertTable(r, projectID, datasetID)
+		}
+	case 1:
+		switch r.Method {
+		case http.MethodGet:
+			return s.getTable(projectID, datasetID, segs[0])
+		case http.MethodPatch, http.MethodPut:
+			return s.patchTable(r, projectID, datasetID, segs[0])
+		case http.MethodDelete:
+			return nil, s.deleteTable(projectID, datasetID, segs[0])
+		}
+	case 2:
+		switch {
+		case segs[1] == "insertAll" && r.Method == http.MethodPost:
+			return s.insertAll(r, projectID, datasetID, segs[0])
+		case segs[1] == "data" && r.Method == http.MethodGet:
+			return s.listTableData(r, projectID, datasetID, segs[0])
+		}
+		return nil, errNotFound(r)
+	default:
+		return nil, errNotFound(r)
+	}
+	return nil, errMethod(r)
+}
+
+func errTableNotFound(projectID, datasetID, tableID string) error {
+	return errorf(http.StatusNotFound, "Not found: Table "+tableIDTemplate, projectID, datasetID, tableID)
+}
+
+// lookupTable returns the named table. s.mu must be held.
+func (s *Server) lookupTable(projectID, datasetID, tableID string) (*table, error) {
+	ds, err := s.lookupDataset(projectID, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	t := ds.tables[tableID]
+	if t == nil {
+		return nil, errTableNotFound(projectID, datasetID, tableID)
+	}
+	return t, nil
+}
+
+// tableMeta returns the metadata of t with its current size. s.mu must be
+// held.
+func tableMeta(t *table) *bq.Table {
+	meta := *t.meta
+	if meta.Type == typeTable {
+		meta.NumRows = uint64(len(t.rows))
+		meta.NumBytes = t.numBytes()
+	}
+	return &meta
+}
+
+// numBytes returns an estimate of the logical size of the table's data.
+func (t *table) numBytes() int64 {
+	return rowsBytes(t.rows)
+}
+
+// rowsBytes returns an estimate of the logical size of rows.
+func rowsBytes(rows []row) int64 {
+	var n int64
+	for _, r := range rows {
+		n += int64(len(fmt.Sprint(r)))
+	}
+	return n
+}
+
+func (s *Server) insertTable(r *http.Request, projectID, datasetID string) (interface{}, error) {
+	var meta bq.Table
+	if err := decodeBody(r, &meta); err != nil {
+		return nil, err
+	}
+	if meta.TableReference == nil || !validID(meta.TableReference.TableId) {
+		return nil, errorf(http.StatusBadRequest, "Invalid table ID.")
+	}
+	if meta.ExternalDataConfiguration != nil || meta.MaterializedView != nil {
+		return nil, errorf(http.StatusBadRequest, "bqtest: external tables and materialized views are not supported")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, err := s.lookupDataset(projectID, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	tableID := meta.TableReference.TableId
+	if ds.tables[tableID] != nil {
+		return nil, errorf(http.StatusConflict, "Already Exists: Table "+tableIDTemplate, projectID, datasetID, tableID)
+	}
+	meta.TableReference = &bq.TableReference{ProjectId: projectID, DatasetId: datasetID, TableId: tableID}
+	if meta.View != nil {
+		if meta.Schema != nil && len(meta.Schema.Fields) > 0 {
+			return nil, errorf(http.StatusBadRequest, "Schema field shouldn't be used as input with a view")
+		}
+		if meta.View.UseLegacySql {
+			return nil, errorf(http.StatusBadRequest, "bqtest: legacy SQL is not supported")
+		}
+		// Like the service, check the view's query and record its schema.
+		q := &queryRunner{s: s, projectID: projectID, defaultDataset: &bq.DatasetReference{ProjectId: projectID, DatasetId: datasetID}, now: s.timeNowFunc()}
+		res, err := q.run(meta.View.Query)
+		if err != nil {
+			return nil, err
+		}
+		meta.Schema = &bq.TableSchema{Fields: res.schema}
+		meta.Type = typeView
+	} else {
+		if meta.Schema == nil {
+			meta.Schema = &bq.TableSchema{}
+		}
+		if err := validateSchema(meta.Schema.Fields); err != nil {
+			return nil, errorf(http.StatusBadRequest, "%v", err)
+		}
+		meta.Type = typeTable
+	}
+	s.addTable(ds, &meta)
+	return tableMeta(ds.tables[tableID]), nil
+}
+
+// addTable adds a table with the given metadata, filling in output-only
+// fields, to ds. s.mu must be held.
+func (s *Server) addTable(ds *dataset, meta *bq.Table) *table {
+	ref := meta.TableReference
+	now := s.now()
+	meta.Kind = "bigquery#table"
+	meta.Id = fmt.Sprintf(tableIDTemplate, ref.ProjectId, ref.DatasetId, ref.TableId)
+	if meta.Type == "" {
+		meta.Type = typeTable
+	}
+	meta.Location = ds.meta.Location
+	meta.CreationTime = now
+	meta.LastModifiedTime = uint64(now)
+	meta.Etag = s.newEtag()
+	t := &table{meta: meta, insertIDs: map[string]bool{}}
+	ds.tables[ref.TableId] = t
+	return t
+}
+
+func (s *Server) getTable(projectID, datasetID, tableID string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.lookupTable(projectID, datasetID, tableID)
+	if err != nil {
+		return nil, err
+	}
+	return tableMeta(t), nil
+}
+
+func (s *Server) patchTable(r *http.Request, projectID, datasetID, tableID string) (interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.lookupTable(projectID, datasetID, tableID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEtag(r, t.meta.Etag); err != nil {
+		return nil, err
+	}
+	var meta bq.Table
+	if r.Method == http.MethodPut {
+		err = mergePatch(&bq.Table{}, body, &meta)
+	} else {
+		err = mergePatch(t.meta, body, &meta)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Output-only and immutable fields keep their values.
+	meta.Kind = t.meta.Kind
+	meta.Id = t.meta.Id
+	meta.TableReference = t.meta.TableReference
+	meta.Type = t.meta.Type
+	meta.Location = t.meta.Location
+	meta.CreationTime = t.meta.CreationTime
+	if t.meta.Type == typeView {
+		if meta.View == nil || meta.View.Query == "" {
+			meta.View = t.meta.View
+		}
+		q := &queryRunner{s: s, projectID: projectID, defaultDataset: &bq.DatasetReference{ProjectId: projectID, DatasetId: datasetID}, now: s.timeNowFunc()}
+		res, err := q.run(meta.View.Query)
+		if err != nil {
+			return nil, err
+		}
+		meta.Schema = &bq.TableSchema{Fields: res.schema}
+	} else {
+		if meta.Schema == nil {
+			meta.Schema = t.meta.Schema
+		}
+		if err := checkSchemaUpdate(meta.TableReference, t.schemaFields(), meta.Schema.Fields); err != nil {
+			return nil, err
+		}
+		for i, r := range t.rows {
+			t.rows[i] = convertRow(t.schemaFields(), meta.Schema.Fields, r)
+		}
+	}
+	meta.LastModifiedTime = uint64(s.now())
+	meta.Etag = s.newEtag()
+	t.meta = &meta
+	return tableMeta(t), nil
+}
+
+// checkSchemaUpdate checks that a table's schema may be changed from old to
+// new by a table update. Fields may be added, as long as they are not
+// REQUIRED, and REQUIRED fields may be relaxed to NULLABLE. Fields may not be
+// removed or change type.
+func checkSchemaUpdate(ref *bq.TableReference, old, new []*bq.TableFieldSchema) error {
+	if err := validateSchema(new); err != nil {
+		return errorf(http.StatusBadRequest, "%v", err)
+	}
+	id := fmt.Sprintf(tableIDTemplate, ref.ProjectId, ref.DatasetId, ref.TableId)
+	for _, of := range old {
+		if fieldIndex(new, of.Name) < 0 {
+			return errorf(http.StatusBadRequest, "Provided Schema does not match Table %s. Field %s is missing in new schema", id, of.Name)
+		}
+	}
+	for _, nf := range new {
+		if fieldIndex(old, nf.Name) < 0 && nf.Mode == modeRequired {
+			return errorf(http.StatusBadRequest, "Provided Schema does not match Table %s. Cannot add required fields to an existing schema. (field: %s)", id, nf.Name)
+		}
+	}
+	_, err := appendSchema(id, "", old, new, true)
+	return err
+}
+
+// appendSchema returns the schema of a table with the given fields after
+// rows with the incoming fields are written to it. Incoming fields are
+// matched to the table's by name and must have the same type. Unless
+// allowChanges is set, as it is by the ALLOW_FIELD_ADDITION and
+// ALLOW_FIELD_RELAXATION schema update options, new fields may not be added
+// and REQUIRED fields may not become NULLABLE.
+func appendSchema(id, path string, fields, incoming []*bq.TableFieldSchema, allowChanges bool) ([]*bq.TableFieldSchema, error) {
+	out := append([]*bq.TableFieldSchema(nil), fields...)
+	for _, f := range incoming {
+		name := joinPath(path, f.Name)
+		i := fieldIndex(fields, f.Name)
+		if i < 0 {
+			if !allowChanges {
+				return nil, errorf(http.StatusBadRequest, "Provided Schema does not match Table %s. Cannot add fields (field: %s)", id, name)
+			}
+			nf := *f
+			if nf.Mode == modeRequired {
+				nf.Mode = modeNullable
+			}
+			out = append(out, &nf)
+			continue
+		}
+		tf := fields[i]
+		if canonicalType(tf.Type) != canonicalType(f.Type) {
+			return nil, errorf(http.StatusBadRequest, "Provided Schema does not match Table %s. Field %s has changed type from %s to %s", id, name, canonicalType(tf.Type), canonicalType(f.Type))
+		}
+		merged := *tf
+		switch tm, fm := fieldMode(tf), fieldMode(f); {
+		case tm == fm, tm == modeNullable && fm == modeRequired:
+		case tm == modeRequired && fm == modeNullable && allowChanges:
+			merged.Mode = modeNullable
+		default:
+			return nil, errorf(http.StatusBadRequest, "Provided Schema does not match Table %s. Field %s has changed mode from %s to %s", id, name, tm, fm)
+		}
+		if len(tf.Fields) > 0 {
+			var err error
+			if merged.Fields, err = appendSchema(id, name, tf.Fields, f.Fields, allowChanges); err != nil {
+				return nil, err
+			}
+		}
+		out[i] = &merged
+	}
+	return out, nil
+}
+
+// fieldMode returns the mode of f, which defaults to NULLABLE.
+func fieldMode(f *bq.TableFieldSchema) string {
+	if f.Mode == "" {
+		return modeNullable
+	}
+	return f.Mode
+}
+
+func (s *Server) deleteTable(projectID, datasetID, tableID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.lookupTable(projectID, datasetID, tableID); err != nil {
+		return err
+	}
+	delete(s.datasets[datasetKey(projectID, datasetID)].tables, tableID)
+	return nil
+}
+
+func (s *Server) listTables(r *http.Request, projectID, datasetID string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, err := s.lookupDataset(projectID, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	var items []*bq.TableListTables
+	for _, t := range ds.tables {
+		items = append(items, &bq.TableListTables{
+			Kind:           t.meta.Kind,
+			Id:             t.meta.Id,
+			TableReference: t.meta.TableReference,
+			Type:           t.meta.Type,
+			FriendlyName:   t.meta.FriendlyName,
+			Labels:         t.meta.Labels,
+			CreationTime:   t.meta.CreationTime,
+			ExpirationTime: t.meta.ExpirationTime,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].TableReference.TableId < items[j].TableReference.TableId
+	})
+	start, end, next, err := pageBounds(r.URL.Query(), len(items))
+	if err != nil {
+		return nil, err
+	}
+	return &bq.TableList{
+		Kind:          "bigquery#tableList",
+		Tables:        items[start:end],
+		TotalItems:    int64(len(items)),
+		NextPageToken: next,
+	}, nil
+}
+
+func (s *Server) insertAll(r *http.Request, projectID, datasetID, tableID string) (interface{}, error) {
+	var req bq.TableDataInsertAllRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.lookupTable(projectID, datasetID, tableID)
+	if err != nil {
+		return nil, err
+	}
+	if t.meta.Type != typeTable {
+		return nil, errorf(http.StatusBadRequest, "Cannot add rows to a table of type %s.", t.meta.Type)
+	}
+	if req.TemplateSuffix != "" {
+		// Rows go to a table named by the suffix, created with the schema of
+		// the template table if necessary.
+		ds := s.datasets[datasetKey(projectID, datasetID)]
+		id := tableID + req.TemplateSuffix
+		if ds.tables[id] == nil {
+			s.addTable(ds, &bq.Table{
+				TableReference: &bq.TableReference{ProjectId: projectID, DatasetId: datasetID, TableId: id},
+				Schema:         t.meta.Schema,
+			})
+		}
+		t = ds.tables[id]
+	}
+
+	var rows []row
+	var ids []string
+	var insertErrors []*bq.TableDataInsertAllResponseInsertErrors
+	for i, rr := range req.Rows {
+		m := map[string]interface{}{}
+		for k, v := range rr.Json {
+			m[k] = v
+		}
+		row, err := parseRow(t.schemaFields(), m, req.IgnoreUnknownValues, "")
+		if err != nil {
+			insertErrors = append(insertErrors, &bq.TableDataInsertAllResponseInsertErrors{
+				Index:  int64(i),
+				Errors: []*bq.ErrorProto{{Reason: "invalid", Message: err.Error(), Location: errorLocation(err)}},
+			})
+			continue
+		}
+		rows = append(rows, row)
+		ids = append(ids, rr.InsertId)
+	}
+	if len(insertErrors) > 0 && !req.SkipInvalidRows {
+		// No rows are inserted; the valid ones are reported as stopped.
+		failed := map[int64]bool{}
+		for _, e := range insertErrors {
+			failed[e.Index] = true
+		}
+		for i := range req.Rows {
+			if !failed[int64(i)] {
+				insertErrors = append(insertErrors, &bq.TableDataInsertAllResponseInsertErrors{
+					Index:  int64(i),
+					Errors: []*bq.ErrorProto{{Reason: "stopped"}},
+				})
+			}
+		}
+		sort.Slice(insertErrors, func(i, j int) bool { return insertErrors[i].Index < insertErrors[j].Index })
+		rows = nil
+	}
+	for i, row := range rows {
+		if id := ids[i]; id != "" {
+			if t.insertIDs[id] {
+				continue
+			}
+			t.insertIDs[id] = true
+		}
+		t.rows = append(t.rows, row)
+	}
+	return &bq.TableDataInsertAllResponse{
+		Kind:         "bigquery#tableDataInsertAllResponse",
+		InsertErrors: insertErrors,
+	}, nil
+}
+
+// errorLocation returns the field named by a row parsing error, if any.
+func errorLocation(err error) string {
+	msg := err.Error()
+	if i := strings.LastIndex(msg, ": "); i >= 0 {
+		return msg[i+2:]
+	}
+	return ""
+}
+
+func (s *Server) listTableData(r *http.Request, projectID, datasetID, tableID string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.lookupTable(projectID, datasetID, tableID)
+	if err != nil {
+		return nil, err
+	}
+	if t.meta.Type != typeTable {
+		return nil, errorf(http.StatusBadRequest, "Cannot list a table of type %s.", t.meta.Type)
+	}
+	start, end, next, err := pageBounds(r.URL.Query(), len(t.rows))
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]*bq.TableRow, 0, end-start)
+	for _, row := range t.rows[start:end] {
+		rows = append(rows, rowJSON(t.schemaFields(), row))
+	}
+	return &bq.TableDataList{
+		Kind:      "bigquery#tableDataList",
+		Etag:      t.meta.Etag,
+		Rows:      rows,
+		TotalRows: int64(len(t.rows)),
+		PageToken: next,
+	}, nil
+}
+
+// writeTable writes the rows of a job's result, with the given schema, to the
+// destination table according to the job's dispositions. s.mu must be held.
+func (s *Server) writeTable(ref *bq.TableReference, fields []*bq.TableFieldSchema, rows []row, createDisposition, writeDisposition string, allowFieldChanges bool) error {
+	ds, err := s.lookupDataset(ref.ProjectId, ref.DatasetId)
+	if err != nil {
+		return err
+	}
+	t := ds.tables[ref.TableId]
+	if t == nil {
+		if createDisposition == createNever {
+			return errTableNotFound(ref.ProjectId, ref.DatasetId, ref.TableId)
+		}
+		if err := validateSchema(fields); err != nil {
+			return errorf(http.StatusBadRequest, "%v", err)
+		}
+		t = s.addTable(ds, &bq.Table{
+			TableReference: &bq.TableReference{ProjectId: ref.ProjectId, DatasetId: ref.DatasetId, TableId: ref.TableId},
+			Schema:         &bq.TableSchema{Fields: fields},
+		})
+		t.rows = rows
+		return nil
+	}
+	if t.meta.Type != typeTable {
+		return errorf(http.StatusBadRequest, "Cannot write to a table of type %s.", t.meta.Type)
+	}
+	switch writeDisposition {
+	case writeEmpty:
+		if len(t.rows) > 0 {
+			e := errorf(http.StatusBadRequest, "Already Exists: Table %s", t.meta.Id)
+			e.reason = "duplicate"
+			return e
+		}
+	case writeTruncate:
+		meta := *t.meta
+		meta.Schema = &bq.TableSchema{Fields: fields}
+		meta.LastModifiedTime = uint64(s.now())
+		meta.Etag = s.newEtag()
+		t.meta = &meta
+		t.rows = rows
+		return nil
+	}
+	old := t.schemaFields()
+	if len(fields) == 0 {
+		fields = old
+	}
+	target, err := appendSchema(t.meta.Id, "", old, fields, allowFieldChanges)
+	if err != nil {
+		return err
+	}
+	meta := *t.meta
+	if !sameFields(old, target) {
+		meta.Schema = &bq.TableSchema{Fields: target}
+		meta.Etag = s.newEtag()
+		for i, r := range t.rows {
+			t.rows[i] = convertRow(old, target, r)
+		}
+	}
+	for _, r := range rows {
+		t.rows = append(t.rows, convertRow(fields, target, r))
+	}
+	meta.LastModifiedTime = uint64(s.now())
+	t.meta = &meta
+	return nil
+}
+
+// sameFields reports whether two schemas have the same fields, in the same
+// order, with the same types and modes.
+func sameFields(a, b []*bq.TableFieldSchema) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i].Name, b[i].Name) || canonicalType(a[i].Type) != canonicalType(b[i].Type) ||
+			fieldMode(a[i]) != fieldMode(b[i]) || !sameFields(a[i].Fields, b[i].Fields) {
+			return false
+		}
+	}
+	return true
+}
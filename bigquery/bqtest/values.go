@@ -0,0 +1,768 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bqtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	bq "google.golang.org/api/bigquery/v2"
+)
+
+// Values are stored and computed with the following Go types:
+//
+//   INTEGER              int64
+//   FLOAT                float64
+//   BOOLEAN              bool
+//   STRING, GEOGRAPHY    string
+//   BYTES                []byte
+//   TIMESTAMP            time.Time, in UTC with microsecond precision
+//   DATE                 civil.Date
+//   TIME                 civil.Time
+//   DATETIME             civil.DateTime
+//   NUMERIC, BIGNUMERIC  *big.Rat
+//   RECORD               []interface{}, one element per field
+//
+// NULL is nil. The value of a REPEATED field is a []interface{} of its
+// elements, which is never nil; an empty array stands for NULL.
+
+// A row holds the values of a table's top-level fields, in schema order.
+type row = []interface{}
+
+// Modes of schema fields.
+const (
+	modeNullable = "NULLABLE"
+	modeRequired = "REQUIRED"
+	modeRepeated = "REPEATED"
+)
+
+// canonicalType returns the legacy name of a type, as used in table schemas,
+// for any of its names.
+func canonicalType(t string) string {
+	switch t = strings.ToUpper(t); t {
+	case "INT64":
+		return "INTEGER"
+	case "FLOAT64":
+		return "FLOAT"
+	case "BOOL":
+		return "BOOLEAN"
+	case "STRUCT":
+		return "RECORD"
+	case "DECIMAL":
+		return "NUMERIC"
+	case "BIGDECIMAL":
+		return "BIGNUMERIC"
+	}
+	return t
+}
+
+var validTypes = map[string]bool{
+	"INTEGER": true, "FLOAT": true, "BOOLEAN": true, "STRING": true, "BYTES": true,
+	"TIMESTAMP": true, "DATE": true, "TIME": true, "DATETIME": true,
+	"NUMERIC": true, "BIGNUMERIC": true, "GEOGRAPHY": true, "RECORD": true,
+}
+
+func isRepeated(f *bq.TableFieldSchema) bool {
+	return f.Mode == modeRepeated
+}
+
+func isNumericType(t string) bool {
+	switch t {
+	case "INTEGER", "FLOAT", "NUMERIC", "BIGNUMERIC":
+		return true
+	}
+	return false
+}
+
+// validateSchema checks that fields form a valid table schema: names are
+// non-empty and unique, ignoring case, types and modes are known and records
+// have fields.
+func validateSchema(fields []*bq.TableFieldSchema) error {
+	seen := map[string]bool{}
+	for _, f := range fields {
+		if f == nil || f.Name == "" {
+			return fmt.Errorf("Empty field name")
+		}
+		lname := strings.ToLower(f.Name)
+		if seen[lname] {
+			return fmt.Errorf("Field %s already exists in schema", f.Name)
+		}
+		seen[lname] = true
+		typ := canonicalType(f.Type)
+		if !validTypes[typ] {
+			return fmt.Errorf("Invalid field type %q for field %s", f.Type, f.Name)
+		}
+		switch f.Mode {
+		case "", modeNullable, modeRequired, modeRepeated:
+		default:
+			return fmt.Errorf("Invalid field mode %q for field %s", f.Mode, f.Name)
+		}
+		if typ == "RECORD" {
+			if len(f.Fields) == 0 {
+				return fmt.Errorf("Field %s is type RECORD but has no schema", f.Name)
+			}
+			if err := validateSchema(f.Fields); err != nil {
+				return err
+			}
+		} else if len(f.Fields) > 0 {
+			return fmt.Errorf("Field %s is type %s but has subfields", f.Name, f.Type)
+		}
+	}
+	return nil
+}
+
+// fieldIndex returns the index of the field with the given name, ignoring
+// case, or -1.
+func fieldIndex(fields []*bq.TableFieldSchema, name string) int {
+	for i, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// nullValue returns the value of a field that is not set.
+func nullValue(f *bq.TableFieldSchema) interface{} {
+	if isRepeated(f) {
+		return []interface{}{}
+	}
+	return nil
+}
+
+// parseRow converts a JSON object, as sent to insertAll or read from a
+// newline-delimited JSON file, to a row of the given schema. Unknown fields are
+// an error unless ignoreUnknown is set. path is the name of the enclosing
+// record, for error messages.
+func parseRow(fields []*bq.TableFieldSchema, m map[string]interface{}, ignoreUnknown bool, path string) (row, error) {
+	r := make(row, len(fields))
+	set := make([]bool, len(fields))
+	for k, v := range m {
+		i := fieldIndex(fields, k)
+		if i < 0 {
+			if ignoreUnknown {
+				continue
+			}
+			return nil, fmt.Errorf("no such field: %s", joinPath(path, k))
+		}
+		val, err := parseField(fields[i], v, ignoreUnknown, joinPath(path, fields[i].Name))
+		if err != nil {
+			return nil, err
+		}
+		r[i] = val
+		set[i] = true
+	}
+	for i, f := range fields {
+		if !set[i] {
+			r[i] = nullValue(f)
+		}
+		if r[i] == nil && f.Mode == modeRequired {
+			return nil, fmt.Errorf("missing required field: %s", joinPath(path, f.Name))
+		}
+	}
+	return r, nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// parseField converts the JSON value of a field to a value.
+func parseField(f *bq.TableFieldSchema, v interface{}, ignoreUnknown bool, path string) (interface{}, error) {
+	if isRepeated(f) {
+		if v == nil {
+			return []interface{}{}, nil
+		}
+		elems, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("array specified for non-repeated field: %s", path)
+		}
+		vals := make([]interface{}, len(elems))
+		for i, e := range elems {
+			if e == nil {
+				return nil, fmt.Errorf("NULL element in repeated field: %s", path)
+			}
+			val, err := parseElement(f, e, ignoreUnknown, path)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = val
+		}
+		return vals, nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	return parseElement(f, v, ignoreUnknown, path)
+}
+
+func parseElement(f *bq.TableFieldSchema, v interface{}, ignoreUnknown bool, path string) (interface{}, error) {
+	typ := canonicalType(f.Type)
+	if typ == "RECORD" {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("this field is not a record: %s", path)
+		}
+		return parseRow(f.Fields, m, ignoreUnknown, path)
+	}
+	val, err := parseScalar(typ, v)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, path)
+	}
+	return val, nil
+}
+
+// parseScalar converts a JSON scalar to a value of the given type.
+func parseScalar(typ string, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return parseString(typ, v)
+	case json.Number:
+		return parseString(typ, v.String())
+	case float64:
+		return parseString(typ, strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		switch typ {
+		case "BOOLEAN":
+			return v, nil
+		case "STRING":
+			return strconv.FormatBool(v), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert value to %s", typ)
+}
+
+// parseString converts the string form of a value, as used in JSON, CSV and
+// query parameters, to a value of the given type.
+func parseString(typ, s string) (interface{}, error) {
+	bad := func() error {
+		return fmt.Errorf("Invalid %s value: %q", typ, s)
+	}
+	switch typ {
+	case "STRING", "GEOGRAPHY":
+		return s, nil
+	case "INTEGER":
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, bad()
+		}
+		return n, nil
+	case "FLOAT":
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil && !isRangeError(err) {
+			return nil, bad()
+		}
+		return f, nil
+	case "BOOLEAN":
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "true", "t", "1":
+			return true, nil
+		case "false", "f", "0":
+			return false, nil
+		}
+		return nil, bad()
+	case "BYTES":
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, bad()
+		}
+		return b, nil
+	case "TIMESTAMP":
+		t, err := parseTimestamp(s)
+		if err != nil {
+			return nil, bad()
+		}
+		return t, nil
+	case "DATE":
+		d, err := civil.ParseDate(strings.TrimSpace(s))
+		if err != nil {
+			return nil, bad()
+		}
+		return d, nil
+	case "TIME":
+		t, err := civil.ParseTime(strings.TrimSpace(s))
+		if err != nil {
+			return nil, bad()
+		}
+		return truncateTime(t), nil
+	case "DATETIME":
+		dt, err := parseDateTime(s)
+		if err != nil {
+			return nil, bad()
+		}
+		return dt, nil
+	case "NUMERIC", "BIGNUMERIC":
+		r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+		if !ok {
+			return nil, bad()
+		}
+		return roundNumeric(typ, r)
+	}
+	return nil, fmt.Errorf("unsupported type %s", typ)
+}
+
+func isRangeError(err error) bool {
+	ne, ok := err.(*strconv.NumError)
+	return ok && ne.Err == strconv.ErrRange
+}
+
+// parseTimestamp parses a timestamp in any of the canonical forms accepted
+// by BigQuery, or as a number of seconds since the Unix epoch.
+func parseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		secs := math.Floor(f)
+		return truncateTimestamp(time.Unix(int64(secs), int64(math.Round((f-secs)*1e6))*1e3)), nil
+	}
+	s = strings.Replace(s, "T", " ", 1)
+	if strings.HasSuffix(s, "UTC") {
+		s = strings.TrimSpace(strings.TrimSuffix(s, "UTC")) + "Z"
+	}
+	// Allow a space between the time and a numeric zone offset.
+	if i := strings.LastIndexAny(s, "+-"); i > 10 && s[i-1] == ' ' {
+		s = s[:i-1] + s[i:]
+	}
+	for _, layout := range []string{
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999Z07",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02 15:04Z07:00",
+		"2006-01-02 15:04",
+		"2006-01-02",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return truncateTimestamp(t), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
+
+// parseDateTime parses a DATETIME, with either a space or a "T" between the
+// date and the time.
+func parseDateTime(s string) (civil.DateTime, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 10 {
+		d, err := civil.ParseDate(s)
+		return civil.DateTime{Date: d}, err
+	}
+	s = strings.Replace(s, " ", "T", 1)
+	dt, err := civil.ParseDateTime(s)
+	if err != nil {
+		return dt, err
+	}
+	dt.Time = truncateTime(dt.Time)
+	return dt, nil
+}
+
+// truncateTimestamp returns t in UTC, truncated to microseconds.
+func truncateTimestamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// truncateTime truncates t to microseconds.
+func truncateTime(t civil.Time) civil.Time {
+	t.Nanosecond -= t.Nanosecond % 1000
+	return t
+}
+
+// numericScale returns the number of digits after the decimal point of a
+// NUMERIC or BIGNUMERIC value.
+func numericScale(typ string) int {
+	if typ == "BIGNUMERIC" {
+		return 38
+	}
+	return 9
+}
+
+var (
+	maxNumeric    = new(big.Rat).SetFrac(new(big.Int).Sub(new(big.Int).Exp(big.NewInt(10), big.NewInt(38), nil), big.NewInt(1)), new(big.Int).Exp(big.NewInt(10), big.NewInt(9), nil))
+	maxBigNumeric = new(big.Rat).SetFrac(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(1)), new(big.Int).Exp(big.NewInt(10), big.NewInt(38), nil))
+)
+
+// roundNumeric rounds r, half away from zero, to the scale of typ and checks
+// that it is in range.
+func roundNumeric(typ string, r *big.Rat) (*big.Rat, error) {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(numericScale(typ))), nil)
+	n := new(big.Int).Mul(r.Num(), scale)
+	q, m := new(big.Int).QuoRem(n, r.Denom(), new(big.Int))
+	if m.Sign() != 0 {
+		m.Abs(m).Lsh(m, 1)
+		if m.Cmp(r.Denom()) >= 0 {
+			q.Add(q, big.NewInt(int64(r.Sign())))
+		}
+	}
+	res := new(big.Rat).SetFrac(q, scale)
+	max := maxNumeric
+	if typ == "BIGNUMERIC" {
+		max = maxBigNumeric
+	}
+	if new(big.Rat).Abs(res).Cmp(max) > 0 {
+		return nil, fmt.Errorf("%s value %s is out of range", typ, r.FloatString(numericScale(typ)))
+	}
+	return res, nil
+}
+
+// formatValue returns the canonical string form of a non-NULL scalar value,
+// as written to CSV files and used for CAST(... AS STRING). BYTES values are
+// base64-encoded.
+func formatValue(typ string, v interface{}) string {
+	switch typ {
+	case "INTEGER":
+		return strconv.FormatInt(v.(int64), 10)
+	case "FLOAT":
+		return formatFloat(v.(float64))
+	case "BOOLEAN":
+		return strconv.FormatBool(v.(bool))
+	case "BYTES":
+		return base64.StdEncoding.EncodeToString(v.([]byte))
+	case "TIMESTAMP":
+		return v.(time.Time).Format("2006-01-02 15:04:05.999999 UTC")
+	case "DATE":
+		return v.(civil.Date).String()
+	case "TIME":
+		return formatTime(v.(civil.Time))
+	case "DATETIME":
+		dt := v.(civil.DateTime)
+		return dt.Date.String() + "T" + formatTime(dt.Time)
+	case "NUMERIC", "BIGNUMERIC":
+		return formatNumeric(typ, v.(*big.Rat))
+	}
+	return fmt.Sprint(v)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func formatTime(t civil.Time) string {
+	s := fmt.Sprintf("%02d:%02d:%02d", t.Hour, t.Minute, t.Second)
+	if t.Nanosecond != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%06d", t.Nanosecond/1000), "0")
+	}
+	return s
+}
+
+func formatNumeric(typ string, r *big.Rat) string {
+	s := r.FloatString(numericScale(typ))
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// timestampMicros returns the number of microseconds since the Unix epoch.
+func timestampMicros(t time.Time) int64 {
+	return t.Unix()*1e6 + int64(t.Nanosecond()/1e3)
+}
+
+// rowJSON returns the REST representation of a row, as returned by
+// tabledata.list and the query result methods.
+func rowJSON(fields []*bq.TableFieldSchema, r row) *bq.TableRow {
+	tr := &bq.TableRow{F: make([]*bq.TableCell, len(fields))}
+	for i, f := range fields {
+		tr.F[i] = &bq.TableCell{V: cellValue(f, r[i])}
+	}
+	return tr
+}
+
+func cellValue(f *bq.TableFieldSchema, v interface{}) interface{} {
+	if isRepeated(f) {
+		elems, _ := v.([]interface{})
+		cells := make([]*bq.TableCell, len(elems))
+		for i, e := range elems {
+			cells[i] = &bq.TableCell{V: scalarCell(f, e)}
+		}
+		return cells
+	}
+	return scalarCell(f, v)
+}
+
+func scalarCell(f *bq.TableFieldSchema, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch typ := canonicalType(f.Type); typ {
+	case "RECORD":
+		return rowJSON(f.Fields, v.([]interface{}))
+	case "TIMESTAMP":
+		// Timestamps are sent as floating-point seconds since the epoch.
+		us := timestampMicros(v.(time.Time))
+		sign := ""
+		if us < 0 {
+			sign, us = "-", -us
+		}
+		return fmt.Sprintf("%s%d.%06d", sign, us/1e6, us%1e6)
+	default:
+		return formatValue(typ, v)
+	}
+}
+
+// exportJSON returns the JSON representation of a row for newline-delimited
+// JSON exports. NULL fields are omitted.
+func exportJSON(fields []*bq.TableFieldSchema, r row) map[string]interface{} {
+	m := map[string]interface{}{}
+	for i, f := range fields {
+		if r[i] == nil {
+			continue
+		}
+		if isRepeated(f) {
+			elems := r[i].([]interface{})
+			vals := make([]interface{}, len(elems))
+			for j, e := range elems {
+				vals[j] = exportJSONValue(f, e)
+			}
+			m[f.Name] = vals
+			continue
+		}
+		m[f.Name] = exportJSONValue(f, r[i])
+	}
+	return m
+}
+
+func exportJSONValue(f *bq.TableFieldSchema, v interface{}) interface{} {
+	switch typ := canonicalType(f.Type); typ {
+	case "RECORD":
+		return exportJSON(f.Fields, v.([]interface{}))
+	case "BOOLEAN":
+		return v
+	case "FLOAT":
+		if f := v.(float64); math.IsNaN(f) || math.IsInf(f, 0) {
+			return formatValue(typ, v)
+		}
+		return json.Number(formatValue(typ, v))
+	default:
+		return formatValue(typ, v)
+	}
+}
+
+// compareValues orders two non-NULL values of comparable types. Numbers of
+// different types compare by value, and FLOAT NaN is smaller than all other
+// numbers.
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case bool:
+		b := b.(bool)
+		switch {
+		case a == b:
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	case time.Time:
+		b := b.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+		return 0
+	case civil.Date:
+		b := b.(civil.Date)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+		return 0
+	case civil.Time:
+		return compareCivilTime(a, b.(civil.Time))
+	case civil.DateTime:
+		b := b.(civil.DateTime)
+		switch {
+		case a.Date.Before(b.Date):
+			return -1
+		case a.Date.After(b.Date):
+			return 1
+		}
+		return compareCivilTime(a.Time, b.Time)
+	case []interface{}:
+		b := b.([]interface{})
+		for i := 0; i < len(a) && i < len(b); i++ {
+			switch {
+			case a[i] == nil && b[i] == nil:
+				continue
+			case a[i] == nil:
+				return -1
+			case b[i] == nil:
+				return 1
+			}
+			if c := compareValues(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(b)
+	}
+	return compareNumbers(a, b)
+}
+
+func compareCivilTime(a, b civil.Time) int {
+	an := ((int64(a.Hour)*60+int64(a.Minute))*60+int64(a.Second))*1e9 + int64(a.Nanosecond)
+	bn := ((int64(b.Hour)*60+int64(b.Minute))*60+int64(b.Second))*1e9 + int64(b.Nanosecond)
+	switch {
+	case an < bn:
+		return -1
+	case an > bn:
+		return 1
+	}
+	return 0
+}
+
+func compareNumbers(a, b interface{}) int {
+	if ai, ok := a.(int64); ok {
+		if bi, ok := b.(int64); ok {
+			switch {
+			case ai < bi:
+				return -1
+			case ai > bi:
+				return 1
+			}
+			return 0
+		}
+	}
+	_, af := a.(float64)
+	_, bf := b.(float64)
+	if af || bf {
+		x, y := toFloat(a), toFloat(b)
+		switch {
+		case math.IsNaN(x) && math.IsNaN(y):
+			return 0
+		case math.IsNaN(x) || x < y:
+			return -1
+		case math.IsNaN(y) || x > y:
+			return 1
+		}
+		return 0
+	}
+	return toRat(a).Cmp(toRat(b))
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f
+	}
+	panic(fmt.Sprintf("bqtest: %T is not a number", v))
+}
+
+func toRat(v interface{}) *big.Rat {
+	switch v := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(v)
+	case *big.Rat:
+		return v
+	case float64:
+		r, _ := new(big.Rat).SetString(strconv.FormatFloat(v, 'g', -1, 64))
+		return r
+	}
+	panic(fmt.Sprintf("bqtest: %T is not a number", v))
+}
+
+// valueKey returns a string that is equal for equal values, for grouping and
+// DISTINCT.
+func valueKey(v interface{}) string {
+	var b strings.Builder
+	writeValueKey(&b, v)
+	return b.String()
+}
+
+func writeValueKey(b *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("N")
+	case []interface{}:
+		b.WriteString("[")
+		for _, e := range v {
+			writeValueKey(b, e)
+			b.WriteString(",")
+		}
+		b.WriteString("]")
+	case *big.Rat:
+		fmt.Fprintf(b, "R%s", v.RatString())
+	case []byte:
+		fmt.Fprintf(b, "B%q", v)
+	case string:
+		fmt.Fprintf(b, "S%q", v)
+	case time.Time:
+		fmt.Fprintf(b, "T%d", timestampMicros(v))
+	default:
+		fmt.Fprintf(b, "%T:%v", v, v)
+	}
+}
+
+// convertRow converts r, a row of schema from, to a row of schema to, which
+// may have more fields, or fields in a different order. Fields are matched by
+// name and new fields are NULL.
+func convertRow(from, to []*bq.TableFieldSchema, r row) row {
+	out := make(row, len(to))
+	for i, f := range to {
+		j := fieldIndex(from, f.Name)
+		if j < 0 {
+			out[i] = nullValue(f)
+			continue
+		}
+		out[i] = convertValue(from[j], f, r[j])
+	}
+	return out
+}
+
+func convertValue(from, to *bq.TableFieldSchema, v interface{}) interface{} {
+	if v == nil || canonicalType(to.Type) != "RECORD" {
+		return v
+	}
+	if isRepeated(to) {
+		elems := v.([]interface{})
+		out := make([]interface{}, len(elems))
+		for i, e := range elems {
+			if e != nil {
+				out[i] = convertRow(from.Fields, to.Fields, e.([]interface{}))
+			}
+		}
+		return out
+	}
+	return convertRow(from.Fields, to.Fields, v.([]interface{}))
+}
@@ -174,7 +174,7 @@ change names, ignore fields, or mark a field as nullable (non-required). Fields
 declared as one of the Null types (NullInt64, NullFloat64, NullString, NullBool,
 NullTimestamp, NullDate, NullTime, NullDateTime, and NullGeography) are
 automatically inferred as nullable, so the "nullable" tag is only needed for []byte,
-*big.Rat and pointer-to-struct fields.
+json.RawMessage, *big.Rat, *IntervalValue and pointer-to-struct fields.
 
     type student2 struct {
         Name     string `bigquery:"full_name"`
@@ -236,6 +236,62 @@ func ExampleJob_Read() {
 	_ = it // TODO: iterate using Next or iterator.Pager.
 }
 
+func ExampleJob_ScriptStatements() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	q := client.Query(`
+		DECLARE n INT64 DEFAULT 3;
+		CREATE TEMP TABLE t AS SELECT x FROM UNNEST(GENERATE_ARRAY(1, n)) AS x;
+		SELECT SUM(x) FROM t;`)
+	job, err := q.Run(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	if _, err := job.Wait(ctx); err != nil {
+		// TODO: Handle error.
+	}
+	statements, err := job.ScriptStatements(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	for _, s := range statements {
+		fmt.Println(s.StatementType, s.EvaluationKind)
+	}
+}
+
+func ExampleQueryConfig_session() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	// Start a session, and create a temporary table in it.
+	q := client.Query("CREATE TEMP TABLE t AS SELECT 17 AS x")
+	q.CreateSession = true
+	job, err := q.Run(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	sessionID := status.Statistics.SessionInfo.SessionID
+	defer client.AbortSession(ctx, sessionID)
+
+	// Later queries in the session can use the table.
+	q = client.Query("SELECT x FROM t")
+	q.ConnectionProperties = []*bigquery.ConnectionProperty{bigquery.SessionConnectionProperty(sessionID)}
+	it, err := q.Read(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	_ = it // TODO: iterate using Next or iterator.Pager.
+}
+
 func ExampleJob_Wait() {
 	ctx := context.Background()
 	client, err := bigquery.NewClient(ctx, "project-id")
@@ -652,6 +708,46 @@ func ExampleTable_LoaderFrom_reader() {
 	}
 }
 
+func ExampleParquetSchema() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	f, err := os.Open("data.parquet")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		// TODO: Handle error.
+	}
+	opts := &bigquery.ParquetOptions{EnumAsString: true, EnableListInference: true}
+	// Check the schema before loading the file.
+	schema, err := bigquery.ParquetSchema(f, fi.Size(), opts)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	for _, fs := range schema {
+		fmt.Println(fs.Name, fs.Type)
+	}
+	rs := bigquery.NewReaderSource(f)
+	rs.SourceFormat = bigquery.Parquet
+	rs.ParquetOptions = opts
+	job, err := client.Dataset("my_dataset").Table("my_table").LoaderFrom(rs).Run(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	if status.Err() != nil {
+		// TODO: Handle error.
+	}
+}
+
 func ExampleTable_Read() {
 	ctx := context.Background()
 	client, err := bigquery.NewClient(ctx, "project-id")
@@ -703,6 +799,47 @@ func ExampleTable_Update_blindWrite() {
 	fmt.Println(tm)
 }
 
+func ExampleInserter_Batch() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	b := client.Dataset("my_dataset").Table("my_table").Inserter().Batch(func(rows []bigquery.ValueSaver, err error) {
+		// TODO: Handle rows that could not be inserted.
+	})
+	b.BatchSettings.CountThreshold = 1000
+	defer b.Stop()
+	items := []*Item{
+		{Name: "n1", Size: 32.6, Count: 7},
+		{Name: "n2", Size: 4, Count: 2},
+	}
+	if err := b.Put(ctx, items); err != nil {
+		// TODO: Handle error.
+	}
+}
+
+func ExampleTable_UpdateSchema() {
+	ctx := context.Background()
+	client, err := bigquery.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	schema, err := bigquery.InferSchema(Item{})
+	if err != nil {
+		// TODO: Handle error.
+	}
+	t := client.Dataset("my_dataset").Table("my_table")
+	md, diff, err := t.UpdateSchema(ctx, schema)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	for _, c := range diff.Incompatible() {
+		fmt.Println("not applied:", c)
+	}
+	fmt.Println(md.Schema)
+}
+
 func ExampleTableIterator_Next() {
 	ctx := context.Background()
 	client, err := bigquery.NewClient(ctx, "project-id")
@@ -432,7 +432,8 @@ func bqToBigtableColumn(q *bq.BigtableColumn) (*BigtableColumn, error) {
 	return b, nil
 }
 
-// ParquetOptions are additional options for Parquet external data sources.
+// ParquetOptions are additional options for Parquet external data sources
+// and load jobs.
 type ParquetOptions struct {
 	// EnumAsString indicates whether to infer Parquet ENUM logical type as
 	// STRING instead of BYTES by default.
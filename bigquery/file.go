@@ -70,12 +70,15 @@ type FileConfig struct {
 
 	// Schema describes the data. It is required when reading CSV or JSON data,
 	// unless the data is being loaded into a table that already exists.
+	// Parquet and ORC files are self-describing, so their schema is read from
+	// the files. Use ParquetSchema to preview the schema of a Parquet file.
 	Schema Schema
 
-	// Additional options for CSV files.
+	// Additional options for CSV files. They are ignored for other formats.
 	CSVOptions
 
-	// Additional options for Parquet files.
+	// Additional options for Parquet files. ORC files have no additional
+	// options.
 	ParquetOptions *ParquetOptions
 }
 
@@ -113,6 +116,7 @@ func bqPopulateFileConfig(conf *bq.JobConfigurationLoad, fc *FileConfig) {
 	fc.Encoding = Encoding(conf.Encoding)
 	fc.FieldDelimiter = conf.FieldDelimiter
 	fc.CSVOptions.setQuote(conf.Quote)
+	fc.ParquetOptions = bqToParquetOptions(conf.ParquetOptions)
 }
 
 func (fc *FileConfig) populateExternalDataConfig(conf *bq.ExternalDataConfiguration) {
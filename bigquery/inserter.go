@@ -177,52 +177,77 @@ func (u *Inserter) putMulti(ctx context.Context, src []ValueSaver) error {
 	if req == nil {
 		return nil
 	}
+	res, err := u.insertAll(ctx, req)
+	if err != nil {
+		return err
+	}
+	return handleInsertErrors(res.InsertErrors, req.Rows)
+}
+
+// insertAll sends an insertAll request, retrying on temporary errors.
+func (u *Inserter) insertAll(ctx context.Context, req *bq.TableDataInsertAllRequest) (*bq.TableDataInsertAllResponse, error) {
 	call := u.t.c.bqs.Tabledata.InsertAll(u.t.ProjectID, u.t.DatasetID, u.t.TableID, req)
 	call = call.Context(ctx)
 	setClientHeader(call.Header())
 	var res *bq.TableDataInsertAllResponse
-	err = runWithRetry(ctx, func() (err error) {
+	err := runWithRetry(ctx, func() (err error) {
 		res, err = call.Do()
 		return err
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return handleInsertErrors(res.InsertErrors, req.Rows)
+	return res, nil
 }
 
 func (u *Inserter) newInsertRequest(savers []ValueSaver) (*bq.TableDataInsertAllRequest, error) {
 	if savers == nil { // If there are no rows, do nothing.
 		return nil, nil
 	}
-	req := &bq.TableDataInsertAllRequest{
-		TemplateSuffix:      u.TableTemplateSuffix,
-		IgnoreUnknownValues: u.IgnoreUnknownValues,
-		SkipInvalidRows:     u.SkipInvalidRows,
-	}
+	req := u.emptyInsertRequest()
 	for _, saver := range savers {
-		row, insertID, err := saver.Save()
+		row, err := saveInsertRow(saver)
 		if err != nil {
 			return nil, err
 		}
-		if insertID == NoDedupeID {
-			// User wants to opt-out of sending deduplication ID.
-			insertID = ""
-		} else if insertID == "" {
-			insertID = randomIDFn()
-		}
-		m := make(map[string]bq.JsonValue)
-		for k, v := range row {
-			m[k] = bq.JsonValue(v)
-		}
-		req.Rows = append(req.Rows, &bq.TableDataInsertAllRequestRows{
-			InsertId: insertID,
-			Json:     m,
-		})
+		req.Rows = append(req.Rows, row)
 	}
 	return req, nil
 }
 
+// emptyInsertRequest returns an insertAll request with the Inserter's options
+// and no rows.
+func (u *Inserter) emptyInsertRequest() *bq.TableDataInsertAllRequest {
+	return &bq.TableDataInsertAllRequest{
+		TemplateSuffix:      u.TableTemplateSuffix,
+		IgnoreUnknownValues: u.IgnoreUnknownValues,
+		SkipInvalidRows:     u.SkipInvalidRows,
+	}
+}
+
+// saveInsertRow saves a row for an insertAll request, assigning it a random
+// insert ID if the saver does not supply one.
+func saveInsertRow(saver ValueSaver) (*bq.TableDataInsertAllRequestRows, error) {
+	row, insertID, err := saver.Save()
+	if err != nil {
+		return nil, err
+	}
+	if insertID == NoDedupeID {
+		// User wants to opt-out of sending deduplication ID.
+		insertID = ""
+	} else if insertID == "" {
+		insertID = randomIDFn()
+	}
+	m := make(map[string]bq.JsonValue)
+	for k, v := range row {
+		m[k] = bq.JsonValue(v)
+	}
+	return &bq.TableDataInsertAllRequestRows{
+		InsertId: insertID,
+		Json:     m,
+	}, nil
+}
+
 func handleInsertErrors(ierrs []*bq.TableDataInsertAllResponseInsertErrors, rows []*bq.TableDataInsertAllRequestRows) error {
 	if len(ierrs) == 0 {
 		return nil
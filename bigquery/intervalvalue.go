@@ -0,0 +1,150 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IntervalValue is a BigQuery INTERVAL value: a duration made up of
+// months, days and a time, each of which may be negative. A month and
+// a day have no fixed length, so the parts are kept separate.
+//
+// The fields need not be normalized; for example, Months may exceed 11. The
+// value is normalized when it is formatted or passed to Canonicalize.
+//
+// See https://cloud.google.com/bigquery/docs/reference/standard-sql/data-types#interval_type
+// for more on INTERVAL.
+type IntervalValue struct {
+	Years   int32
+	Months  int32
+	Days    int32
+	Hours   int32
+	Minutes int32
+	Seconds int32
+	// SubSecondNanos is the fraction of a second, in nanoseconds. BigQuery
+	// keeps microsecond precision.
+	SubSecondNanos int32
+}
+
+// intervalRE matches the canonical format of an INTERVAL:
+// [sign]Y-M [sign]D [sign]H:M:S[.F]
+var intervalRE = regexp.MustCompile(`^([-+]?)(\d+)-(\d+) ([-+]?\d+) ([-+]?)(\d+):(\d+):(\d+)(?:\.(\d{1,9}))?$`)
+
+// ParseInterval parses an INTERVAL in its canonical format, as returned by
+// BigQuery and by IntervalValue.String. The format is
+//
+//	[sign]Y-M [sign]D [sign]H:M:S[.F]
+//
+// where the first sign applies to both years and months, and the last to
+// every part of the time. For example, "1-2 -3 4:05:06.5" is 1 year,
+// 2 months, minus 3 days, and 4 hours, 5 minutes and 6.5 seconds.
+func ParseInterval(s string) (*IntervalValue, error) {
+	m := intervalRE.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, fmt.Errorf("bigquery: invalid INTERVAL value %q", s)
+	}
+	var nums [7]int32
+	for i, part := range []string{m[2], m[3], m[4], m[6], m[7], m[8]} {
+		n, err := strconv.ParseInt(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bigquery: invalid INTERVAL value %q: %v", s, err)
+		}
+		nums[i] = int32(n)
+	}
+	if m[9] != "" {
+		// Pad the fraction to nanoseconds.
+		n, err := strconv.ParseInt(m[9]+strings.Repeat("0", 9-len(m[9])), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bigquery: invalid INTERVAL value %q: %v", s, err)
+		}
+		nums[6] = int32(n)
+	}
+	iv := &IntervalValue{
+		Years:          nums[0],
+		Months:         nums[1],
+		Days:           nums[2],
+		Hours:          nums[3],
+		Minutes:        nums[4],
+		Seconds:        nums[5],
+		SubSecondNanos: nums[6],
+	}
+	if m[1] == "-" {
+		iv.Years, iv.Months = -iv.Years, -iv.Months
+	}
+	if m[5] == "-" {
+		iv.Hours, iv.Minutes, iv.Seconds, iv.SubSecondNanos = -iv.Hours, -iv.Minutes, -iv.Seconds, -iv.SubSecondNanos
+	}
+	return iv, nil
+}
+
+// Canonicalize returns an equivalent IntervalValue in which months are
+// folded into years, and the parts of the time into each other, so that
+// Years and Months have the same sign, and so do the time fields, and no
+// field but Years, Days and Hours exceeds its natural range. Days are not
+// folded into months, nor hours into days, since their lengths vary.
+func (iv *IntervalValue) Canonicalize() *IntervalValue {
+	months := int64(iv.Years)*12 + int64(iv.Months)
+	// The time may not fit in an int64 of nanoseconds, so keep the seconds
+	// and nanoseconds apart, with the same sign.
+	secs := (int64(iv.Hours)*60+int64(iv.Minutes))*60 + int64(iv.Seconds) + int64(iv.SubSecondNanos/1e9)
+	nanos := int64(iv.SubSecondNanos % 1e9)
+	if secs > 0 && nanos < 0 {
+		secs--
+		nanos += 1e9
+	} else if secs < 0 && nanos > 0 {
+		secs++
+		nanos -= 1e9
+	}
+	return &IntervalValue{
+		Years:          int32(months / 12),
+		Months:         int32(months % 12),
+		Days:           iv.Days,
+		Hours:          int32(secs / 3600),
+		Minutes:        int32(secs / 60 % 60),
+		Seconds:        int32(secs % 60),
+		SubSecondNanos: int32(nanos),
+	}
+}
+
+// String returns the canonical format of the interval, which ParseInterval
+// accepts. The interval is canonicalized first, and the fraction of a second
+// is written without trailing zeros.
+func (iv *IntervalValue) String() string {
+	c := iv.Canonicalize()
+	var b strings.Builder
+	if c.Years < 0 || c.Months < 0 {
+		b.WriteByte('-')
+	}
+	fmt.Fprintf(&b, "%d-%d %d ", abs32(c.Years), abs32(c.Months), c.Days)
+	if c.Hours < 0 || c.Minutes < 0 || c.Seconds < 0 || c.SubSecondNanos < 0 {
+		b.WriteByte('-')
+	}
+	fmt.Fprintf(&b, "%d:%d:%d", abs32(c.Hours), abs32(c.Minutes), abs32(c.Seconds))
+	if c.SubSecondNanos != 0 {
+		b.WriteString(strings.TrimRight(fmt.Sprintf(".%09d", abs32(c.SubSecondNanos)), "0"))
+	}
+	return b.String()
+}
+
+func abs32(n int32) int64 {
+	if n < 0 {
+		return -int64(n)
+	}
+	return int64(n)
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"testing"
+
+	"cloud.google.com/go/internal/testutil"
+)
+
+func TestParseInterval(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want *IntervalValue
+		str  string // canonical form, if different from in
+	}{
+		{"0-0 0 0:0:0", &IntervalValue{}, ""},
+		{"1-2 3 4:5:6", &IntervalValue{Years: 1, Months: 2, Days: 3, Hours: 4, Minutes: 5, Seconds: 6}, ""},
+		{"1-2 3 4:5:6.5", &IntervalValue{Years: 1, Months: 2, Days: 3, Hours: 4, Minutes: 5, Seconds: 6, SubSecondNanos: 500000000}, ""},
+		{"0-0 0 0:0:0.000001", &IntervalValue{SubSecondNanos: 1000}, ""},
+		{"-1-2 -3 -4:5:6.789", &IntervalValue{Years: -1, Months: -2, Days: -3, Hours: -4, Minutes: -5, Seconds: -6, SubSecondNanos: -789000000}, ""},
+		{"+1-2 +3 +04:05:06", &IntervalValue{Years: 1, Months: 2, Days: 3, Hours: 4, Minutes: 5, Seconds: 6}, "1-2 3 4:5:6"},
+		{" 0-11 0 100:59:59 ", &IntervalValue{Months: 11, Hours: 100, Minutes: 59, Seconds: 59}, "0-11 0 100:59:59"},
+		{"10000-0 3660000 87840000:0:0", &IntervalValue{Years: 10000, Days: 3660000, Hours: 87840000}, ""},
+	} {
+		got, err := ParseInterval(test.in)
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+		if diff := testutil.Diff(got, test.want); diff != "" {
+			t.Errorf("%q: got=-, want=+:\n%s", test.in, diff)
+		}
+		wantStr := test.str
+		if wantStr == "" {
+			wantStr = test.in
+		}
+		if s := got.String(); s != wantStr {
+			t.Errorf("%q: String() = %q, want %q", test.in, s, wantStr)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"1-2",
+		"1-2 3",
+		"1-2 3 4:5",
+		"1 2 3:4:5",
+		"1--2 3 4:5:6",
+		"1-2 3 4:5:6.",
+		"1-2 3 4:5:6.1234567891",
+		"1-2 3 4:5:6 7",
+		"P1Y2M3DT4H5M6S",
+		"99999999999-0 0 0:0:0",
+	} {
+		if _, err := ParseInterval(bad); err == nil {
+			t.Errorf("%q: got nil, want error", bad)
+		}
+	}
+}
+
+func TestIntervalCanonicalize(t *testing.T) {
+	for _, test := range []struct {
+		in   *IntervalValue
+		want *IntervalValue
+		str  string
+	}{
+		{
+			&IntervalValue{Months: 14, Minutes: 61, Seconds: 3600, SubSecondNanos: 1500000000},
+			&IntervalValue{Years: 1, Months: 2, Hours: 2, Minutes: 1, Seconds: 1, SubSecondNanos: 500000000},
+			"1-2 0 2:1:1.5",
+		},
+		{
+			&IntervalValue{Years: 1, Months: -13, Days: 40, Hours: 1, Minutes: -61},
+			&IntervalValue{Months: -1, Days: 40, Minutes: -1},
+			"-0-1 40 -0:1:0",
+		},
+		{
+			&IntervalValue{Years: -1, Months: 2, Seconds: 1, SubSecondNanos: -1},
+			&IntervalValue{Months: -10, SubSecondNanos: 999999999},
+			"-0-10 0 0:0:0.999999999",
+		},
+	} {
+		got := test.in.Canonicalize()
+		if diff := testutil.Diff(got, test.want); diff != "" {
+			t.Errorf("%+v: got=-, want=+:\n%s", test.in, diff)
+		}
+		if s := test.in.String(); s != test.str {
+			t.Errorf("%+v: String() = %q, want %q", test.in, s, test.str)
+		}
+		// The canonical form parses to the canonical value.
+		parsed, err := ParseInterval(test.str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := testutil.Diff(parsed, test.want); diff != "" {
+			t.Errorf("%q: got=-, want=+:\n%s", test.str, diff)
+		}
+	}
+}
@@ -47,6 +47,7 @@ type RowIterator struct {
 	pageInfo *iterator.PageInfo
 	nextFunc func() error
 	pf       pageFetcher
+	storage  *storageReader // reads through the Storage Read API, if set
 
 	// StartIndex can be set before the first call to Next. If PageInfo().Token
 	// is also set, StartIndex is ignored.
@@ -97,16 +98,18 @@ type pageFetcher func(ctx context.Context, _ *rowSource, _ Schema, startIndex ui
 //   DATE        civil.Date
 //   TIME        civil.Time
 //   DATETIME    civil.DateTime
+//   JSON        json.RawMessage, string
+//   INTERVAL    *IntervalValue
 //
 // A repeated field corresponds to a slice or array of the element type. A STRUCT
 // type (RECORD or nested schema) corresponds to a nested struct or struct pointer.
 // All calls to Next on the same iterator must use the same struct type.
 //
 // It is an error to attempt to read a BigQuery NULL value into a struct field,
-// unless the field is of type []byte or is one of the special Null types: NullInt64,
-// NullFloat64, NullBool, NullString, NullTimestamp, NullDate, NullTime or
-// NullDateTime. You can also use a *[]Value or *map[string]Value to read from a
-// table with NULLs.
+// unless the field is of type []byte, json.RawMessage or *IntervalValue, or is one of
+// the special Null types: NullInt64, NullFloat64, NullBool, NullString, NullTimestamp,
+// NullDate, NullTime or NullDateTime. You can also use a *[]Value or *map[string]Value
+// to read from a table with NULLs.
 func (it *RowIterator) Next(dst interface{}) error {
 	var vl ValueLoader
 	switch dst := dst.(type) {
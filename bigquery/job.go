@@ -317,7 +317,14 @@ func (j *Job) read(ctx context.Context, waitForQuery func(context.Context, strin
 		jobID:     j.jobID,
 		location:  j.location,
 	}
+	var s *storageReader
+	if j.c.rc != nil && j.c.rc.accepts(totalRows) {
+		s = newStorageReader(j.c.rc, pf)
+		s.totalRows, s.known = totalRows, true
+		pf = s.fetchPage
+	}
 	it := newRowIterator(ctx, &rowSource{j: itJob}, pf)
+	it.storage = s
 	it.Schema = schema
 	it.TotalRows = totalRows
 	return it, nil
@@ -375,6 +382,9 @@ type JobStatistics struct {
 
 	// TransactionInfo indicates the transaction ID associated with the job, if any.
 	TransactionInfo *TransactionInfo
+
+	// SessionInfo contains information about the session if this job is part of one.
+	SessionInfo *SessionInfo
 }
 
 // Statistics is one of ExtractStatistics, LoadStatistics or QueryStatistics.
@@ -884,6 +894,7 @@ func (j *Job) setStatistics(s *bq.JobStatistics, c *Client) {
 		ScriptStatistics:    bqToScriptStatistics(s.ScriptStatistics),
 		ReservationUsage:    bqToReservationUsage(s.ReservationUsage),
 		TransactionInfo:     bqToTransactionInfo(s.TransactionInfo),
+		SessionInfo:         bqToSessionInfo(s.SessionInfo),
 	}
 	switch {
 	case s.Extract != nil:
@@ -1002,3 +1013,20 @@ func bqToTransactionInfo(in *bq.TransactionInfo) *TransactionInfo {
 		TransactionID: in.TransactionId,
 	}
 }
+
+// SessionInfo contains information about the session in which a job ran.
+type SessionInfo struct {
+	// SessionID is the identifier of the session. Pass it to later queries
+	// in a ConnectionProperty with the key "session_id" to run them in the
+	// same session.
+	SessionID string
+}
+
+func bqToSessionInfo(in *bq.SessionInfo) *SessionInfo {
+	if in == nil {
+		return nil
+	}
+	return &SessionInfo{
+		SessionID: in.SessionId,
+	}
+}
@@ -272,6 +272,42 @@ func TestLoad(t *testing.T) {
 				return j
 			}(),
 		},
+		{
+			dst: c.Dataset("dataset-id").Table("table-id"),
+			src: func() *ReaderSource {
+				r := NewReaderSource(strings.NewReader("foo"))
+				r.SourceFormat = Parquet
+				r.ParquetOptions = &ParquetOptions{
+					EnumAsString:        true,
+					EnableListInference: true,
+				}
+				return r
+			}(),
+			want: func() *bq.Job {
+				j := defaultLoadJob()
+				j.Configuration.Load.SourceUris = nil
+				j.Configuration.Load.SourceFormat = "PARQUET"
+				j.Configuration.Load.ParquetOptions = &bq.ParquetOptions{
+					EnumAsString:        true,
+					EnableListInference: true,
+				}
+				return j
+			}(),
+		},
+		{
+			dst: c.Dataset("dataset-id").Table("table-id"),
+			src: func() *ReaderSource {
+				r := NewReaderSource(strings.NewReader("foo"))
+				r.SourceFormat = ORC
+				return r
+			}(),
+			want: func() *bq.Job {
+				j := defaultLoadJob()
+				j.Configuration.Load.SourceUris = nil
+				j.Configuration.Load.SourceFormat = "ORC"
+				return j
+			}(),
+		},
 		{
 			dst: c.Dataset("dataset-id").Table("table-id"),
 			src: func() *ReaderSource {
@@ -16,6 +16,7 @@ package bigquery
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
@@ -77,6 +78,8 @@ var (
 	numericParamType    = &bq.QueryParameterType{Type: "NUMERIC"}
 	bigNumericParamType = &bq.QueryParameterType{Type: "BIGNUMERIC"}
 	geographyParamType  = &bq.QueryParameterType{Type: "GEOGRAPHY"}
+	jsonParamType       = &bq.QueryParameterType{Type: "JSON"}
+	intervalParamType   = &bq.QueryParameterType{Type: "INTERVAL"}
 )
 
 var (
@@ -85,6 +88,9 @@ var (
 	typeOfDateTime = reflect.TypeOf(civil.DateTime{})
 	typeOfGoTime   = reflect.TypeOf(time.Time{})
 	typeOfRat      = reflect.TypeOf(&big.Rat{})
+
+	typeOfJSON          = reflect.TypeOf(json.RawMessage{})
+	typeOfIntervalValue = reflect.TypeOf(&IntervalValue{})
 )
 
 // A QueryParameter is a parameter to a query.
@@ -106,6 +112,8 @@ type QueryParameter struct {
 	// []byte: BYTES
 	// time.Time: TIMESTAMP
 	// *big.Rat: NUMERIC
+	// json.RawMessage: JSON
+	// *IntervalValue: INTERVAL
 	// Arrays and slices of the above.
 	// Structs of the above. Only the exported fields are used.
 	//
@@ -156,6 +164,10 @@ func paramType(t reflect.Type) (*bq.QueryParameterType, error) {
 		return timestampParamType, nil
 	case typeOfRat:
 		return numericParamType, nil
+	case typeOfJSON:
+		return jsonParamType, nil
+	case typeOfIntervalValue:
+		return intervalParamType, nil
 	case typeOfNullBool:
 		return boolParamType, nil
 	case typeOfNullFloat64:
@@ -300,6 +312,22 @@ func paramValue(v reflect.Value) (*bq.QueryParameterValue, error) {
 		// to honor previous behavior and send as Numeric type.
 		res.Value = NumericString(v.Interface().(*big.Rat))
 		return res, nil
+
+	case typeOfJSON:
+		if v.IsNil() {
+			res.NullFields = append(res.NullFields, "Value")
+			return res, nil
+		}
+		res.Value = string(v.Interface().(json.RawMessage))
+		return res, nil
+
+	case typeOfIntervalValue:
+		if v.IsNil() {
+			res.NullFields = append(res.NullFields, "Value")
+			return res, nil
+		}
+		res.Value = v.Interface().(*IntervalValue).String()
+		return res, nil
 	}
 	switch t.Kind() {
 	case reflect.Slice:
@@ -379,6 +407,8 @@ var paramTypeToFieldType = map[string]FieldType{
 	numericParamType.Type:    NumericFieldType,
 	bigNumericParamType.Type: BigNumericFieldType,
 	geographyParamType.Type:  GeographyFieldType,
+	jsonParamType.Type:       JSONFieldType,
+	intervalParamType.Type:   IntervalFieldType,
 }
 
 // Convert a parameter value from the service to a Go value. This is similar to, but
@@ -423,6 +453,10 @@ func convertParamValue(qval *bq.QueryParameterValue, qtype *bq.QueryParameterTyp
 				return NullTime{Valid: false}, nil
 			case "GEOGRAPHY":
 				return NullGeography{Valid: false}, nil
+			case "JSON":
+				return json.RawMessage(nil), nil
+			case "INTERVAL":
+				return (*IntervalValue)(nil), nil
 			}
 
 		}
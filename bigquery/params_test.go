@@ -16,6 +16,7 @@ package bigquery
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"math"
 	"math/big"
@@ -118,6 +119,14 @@ var scalarTests = []struct {
 	{big.NewRat(12345, 1000), false, "12.345000000", numericParamType, big.NewRat(12345, 1000)},
 	{NullGeography{GeographyVal: "POINT(-122.335503 47.625536)", Valid: true}, false, "POINT(-122.335503 47.625536)", geographyParamType, "POINT(-122.335503 47.625536)"},
 	{NullGeography{Valid: false}, true, "", geographyParamType, NullGeography{Valid: false}},
+	{json.RawMessage(`{"a":[1,2]}`), false, `{"a":[1,2]}`, jsonParamType, json.RawMessage(`{"a":[1,2]}`)},
+	{json.RawMessage(nil), true, "", jsonParamType, json.RawMessage(nil)},
+	{&IntervalValue{Years: 1, Months: 2, Days: 3, Hours: 4, Minutes: 5, Seconds: 6, SubSecondNanos: 789000000},
+		false,
+		"1-2 3 4:5:6.789",
+		intervalParamType,
+		&IntervalValue{Years: 1, Months: 2, Days: 3, Hours: 4, Minutes: 5, Seconds: 6, SubSecondNanos: 789000000}},
+	{(*IntervalValue)(nil), true, "", intervalParamType, (*IntervalValue)(nil)},
 }
 
 type (
@@ -0,0 +1,588 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ParquetSchema returns the schema that BigQuery infers when it loads the
+// Parquet file read from r, which holds size bytes. Only the footer of the
+// file is read, so no load job is needed to preview the schema.
+//
+// opts may be nil. Its fields affect the schema as they do for a load job
+// with the same ParquetOptions: EnumAsString maps ENUM columns to STRING
+// instead of BYTES, and EnableListInference maps LIST groups in the standard
+// three-level form to repeated fields of their element type.
+func ParquetSchema(r io.ReaderAt, size int64, opts *ParquetOptions) (Schema, error) {
+	elems, err := readParquetFooter(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) == 0 {
+		return nil, errors.New("bigquery: Parquet file has no schema")
+	}
+	if opts == nil {
+		opts = &ParquetOptions{}
+	}
+	c := &parquetConverter{elems: elems, pos: 1, opts: opts}
+	schema, err := c.fields(int(elems[0].numChildren))
+	if err != nil {
+		return nil, err
+	}
+	if c.pos != len(elems) {
+		return nil, errors.New("bigquery: malformed Parquet schema")
+	}
+	return schema, nil
+}
+
+var parquetMagic = []byte("PAR1")
+
+// readParquetFooter reads the schema elements from the FileMetaData in the
+// footer of a Parquet file.
+func readParquetFooter(r io.ReaderAt, size int64) ([]*parquetElement, error) {
+	// A file begins with the magic number and ends with the footer, its 4-byte
+	// length, and the magic number again.
+	if size < 3*int64(len(parquetMagic)) {
+		return nil, errors.New("bigquery: file is too small to be a Parquet file")
+	}
+	head := make([]byte, len(parquetMagic))
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, err
+	}
+	tail := make([]byte, 8)
+	if _, err := r.ReadAt(tail, size-8); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if string(head) != string(parquetMagic) {
+		return nil, errors.New("bigquery: not a Parquet file")
+	}
+	switch string(tail[4:]) {
+	case string(parquetMagic):
+	case "PARE":
+		return nil, errors.New("bigquery: Parquet files with encrypted footers are not supported")
+	default:
+		return nil, errors.New("bigquery: not a Parquet file")
+	}
+	n := int64(binary.LittleEndian.Uint32(tail))
+	if n > size-12 {
+		return nil, fmt.Errorf("bigquery: Parquet footer length %d exceeds the file size", n)
+	}
+	footer := make([]byte, n)
+	if _, err := r.ReadAt(footer, size-8-n); err != nil && err != io.EOF {
+		return nil, err
+	}
+	elems, err := decodeParquetFileMetaData(&thriftReader{buf: footer})
+	if err != nil {
+		return nil, fmt.Errorf("bigquery: reading Parquet footer: %v", err)
+	}
+	return elems, nil
+}
+
+// Parquet physical types.
+const (
+	parquetBoolean           = 0
+	parquetInt32             = 1
+	parquetInt64             = 2
+	parquetInt96             = 3
+	parquetFloat             = 4
+	parquetDouble            = 5
+	parquetByteArray         = 6
+	parquetFixedLenByteArray = 7
+)
+
+// Parquet field repetitions.
+const (
+	parquetRequired = 0
+	parquetOptional = 1
+	parquetRepeated = 2
+)
+
+// Parquet converted types, which older writers use in place of logical
+// types.
+const (
+	convertedUTF8            = 0
+	convertedList            = 3
+	convertedEnum            = 4
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimeMillis      = 7
+	convertedTimeMicros      = 8
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+	convertedJSON            = 19
+)
+
+// Parquet logical types, identified by their field ID in the LogicalType
+// union.
+const (
+	logicalString    = 1
+	logicalList      = 3
+	logicalEnum      = 4
+	logicalDecimal   = 5
+	logicalDate      = 6
+	logicalTime      = 7
+	logicalTimestamp = 8
+	logicalJSON      = 12
+)
+
+// parquetElement is a SchemaElement of a Parquet file. The elements of a
+// schema are stored depth first; the first is the root of the schema.
+type parquetElement struct {
+	name          string
+	physicalType  int32 // -1 for groups
+	repetition    int32
+	numChildren   int32
+	convertedType int32 // -1 if not set
+	scale         int32
+	precision     int32
+	logical       *parquetLogicalType
+}
+
+type parquetLogicalType struct {
+	kind            int16 // the field ID in the LogicalType union
+	scale           int32
+	precision       int32
+	isAdjustedToUTC bool
+}
+
+func (e *parquetElement) isGroup() bool { return e.physicalType < 0 }
+
+// is reports whether the element is annotated with the given logical type or
+// with the equivalent converted type.
+func (e *parquetElement) is(logical int16, converted int32) bool {
+	if e.logical != nil {
+		return e.logical.kind == logical
+	}
+	return e.convertedType == converted
+}
+
+type parquetConverter struct {
+	elems []*parquetElement
+	pos   int
+	opts  *ParquetOptions
+}
+
+// fields converts the next n elements, and their descendants, to fields.
+func (c *parquetConverter) fields(n int) (Schema, error) {
+	var s Schema
+	for i := 0; i < n; i++ {
+		f, err := c.field()
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, f)
+	}
+	return s, nil
+}
+
+func (c *parquetConverter) field() (*FieldSchema, error) {
+	if c.pos >= len(c.elems) {
+		return nil, errors.New("bigquery: malformed Parquet schema")
+	}
+	e := c.elems[c.pos]
+	c.pos++
+	f := &FieldSchema{
+		Name:     e.name,
+		Required: e.repetition == parquetRequired,
+		Repeated: e.repetition == parquetRepeated,
+	}
+	if !e.isGroup() {
+		t, err := parquetFieldType(e, c.opts)
+		if err != nil {
+			return nil, err
+		}
+		f.Type = t
+		return f, nil
+	}
+	start := c.pos
+	children, err := c.fields(int(e.numChildren))
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.EnableListInference && e.is(logicalList, convertedList) {
+		if elem := c.listElement(start, children); elem != nil {
+			// A repeated field of the element's type replaces the group and
+			// its repeated child.
+			elem.Name = e.name
+			elem.Description = ""
+			elem.Required = false
+			elem.Repeated = true
+			return elem, nil
+		}
+	}
+	f.Type = RecordFieldType
+	f.Schema = children
+	return f, nil
+}
+
+// listElement returns the element field of a LIST group in the standard
+// three-level form, whose only child is a repeated group with one field. It
+// returns nil if the group is in another form, or its elements are repeated.
+func (c *parquetConverter) listElement(start int, children Schema) *FieldSchema {
+	if len(children) != 1 {
+		return nil
+	}
+	list := c.elems[start]
+	if !list.isGroup() || list.repetition != parquetRepeated || len(children[0].Schema) != 1 {
+		return nil
+	}
+	elem := children[0].Schema[0]
+	if elem.Repeated {
+		return nil
+	}
+	return elem
+}
+
+// parquetFieldType returns the BigQuery type of a primitive Parquet column.
+func parquetFieldType(e *parquetElement, opts *ParquetOptions) (FieldType, error) {
+	if e.is(logicalDecimal, convertedDecimal) {
+		return parquetDecimalType(e)
+	}
+	switch e.physicalType {
+	case parquetBoolean:
+		return BooleanFieldType, nil
+	case parquetInt32:
+		switch {
+		case e.is(logicalDate, convertedDate):
+			return DateFieldType, nil
+		case e.is(logicalTime, convertedTimeMillis):
+			return TimeFieldType, nil
+		}
+		return IntegerFieldType, nil
+	case parquetInt64:
+		switch {
+		case e.is(logicalTime, convertedTimeMicros):
+			return TimeFieldType, nil
+		case e.logical != nil && e.logical.kind == logicalTimestamp:
+			if !e.logical.isAdjustedToUTC {
+				return DateTimeFieldType, nil
+			}
+			return TimestampFieldType, nil
+		case e.logical == nil && (e.convertedType == convertedTimestampMillis || e.convertedType == convertedTimestampMicros):
+			return TimestampFieldType, nil
+		}
+		return IntegerFieldType, nil
+	case parquetInt96:
+		return TimestampFieldType, nil
+	case parquetFloat, parquetDouble:
+		return FloatFieldType, nil
+	case parquetByteArray:
+		switch {
+		case e.is(logicalString, convertedUTF8), e.is(logicalJSON, convertedJSON):
+			return StringFieldType, nil
+		case e.is(logicalEnum, convertedEnum):
+			if opts.EnumAsString {
+				return StringFieldType, nil
+			}
+		}
+		return BytesFieldType, nil
+	case parquetFixedLenByteArray:
+		return BytesFieldType, nil
+	}
+	return "", fmt.Errorf("bigquery: Parquet column %q has unknown physical type %d", e.name, e.physicalType)
+}
+
+// parquetDecimalType returns NUMERIC for decimals that fit in it, and
+// BIGNUMERIC for those that fit only in it.
+func parquetDecimalType(e *parquetElement) (FieldType, error) {
+	precision, scale := e.precision, e.scale
+	if e.logical != nil {
+		precision, scale = e.logical.precision, e.logical.scale
+	}
+	switch {
+	case scale <= NumericScaleDigits && precision-scale <= NumericPrecisionDigits-NumericScaleDigits:
+		return NumericFieldType, nil
+	case scale <= BigNumericScaleDigits && precision-scale <= BigNumericPrecisionDigits-BigNumericScaleDigits:
+		return BigNumericFieldType, nil
+	}
+	return "", fmt.Errorf("bigquery: Parquet column %q has DECIMAL(%d, %d), which is too large for BIGNUMERIC", e.name, precision, scale)
+}
+
+// decodeParquetFileMetaData returns the schema of a FileMetaData struct.
+func decodeParquetFileMetaData(r *thriftReader) ([]*parquetElement, error) {
+	var elems []*parquetElement
+	err := r.readStruct(func(id int16, typ byte) error {
+		if id != 2 || typ != thriftList {
+			return r.skip(typ, 0)
+		}
+		et, n, err := r.readListHeader()
+		if err != nil {
+			return err
+		}
+		if et != thriftStruct {
+			return errors.New("schema is not a list of structs")
+		}
+		for i := 0; i < n; i++ {
+			e, err := decodeParquetElement(r)
+			if err != nil {
+				return err
+			}
+			elems = append(elems, e)
+		}
+		return nil
+	})
+	return elems, err
+}
+
+func decodeParquetElement(r *thriftReader) (*parquetElement, error) {
+	e := &parquetElement{physicalType: -1, convertedType: -1}
+	err := r.readStruct(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 1 && typ == thriftI32:
+			e.physicalType, err = r.readI32()
+		case id == 3 && typ == thriftI32:
+			e.repetition, err = r.readI32()
+		case id == 4 && typ == thriftBinary:
+			var b []byte
+			b, err = r.readBinary()
+			e.name = string(b)
+		case id == 5 && typ == thriftI32:
+			e.numChildren, err = r.readI32()
+		case id == 6 && typ == thriftI32:
+			e.convertedType, err = r.readI32()
+		case id == 7 && typ == thriftI32:
+			e.scale, err = r.readI32()
+		case id == 8 && typ == thriftI32:
+			e.precision, err = r.readI32()
+		case id == 10 && typ == thriftStruct:
+			e.logical, err = decodeParquetLogicalType(r)
+		default:
+			err = r.skip(typ, 0)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if e.numChildren < 0 {
+		return nil, fmt.Errorf("element %q has %d children", e.name, e.numChildren)
+	}
+	return e, nil
+}
+
+func decodeParquetLogicalType(r *thriftReader) (*parquetLogicalType, error) {
+	lt := &parquetLogicalType{}
+	err := r.readStruct(func(id int16, typ byte) error {
+		if typ != thriftStruct {
+			return r.skip(typ, 0)
+		}
+		lt.kind = id
+		return r.readStruct(func(fid int16, ftyp byte) error {
+			var err error
+			switch {
+			case id == logicalDecimal && fid == 1 && ftyp == thriftI32:
+				lt.scale, err = r.readI32()
+			case id == logicalDecimal && fid == 2 && ftyp == thriftI32:
+				lt.precision, err = r.readI32()
+			case (id == logicalTime || id == logicalTimestamp) && fid == 1 && (ftyp == thriftTrue || ftyp == thriftFalse):
+				lt.isAdjustedToUTC = ftyp == thriftTrue
+			default:
+				err = r.skip(ftyp, 0)
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lt, nil
+}
+
+// Thrift compact protocol types.
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+// maxThriftDepth limits the nesting of skipped values.
+const maxThriftDepth = 64
+
+var errThriftTruncated = errors.New("unexpected end of data")
+
+// thriftReader decodes values encoded with the Thrift compact protocol, as
+// Parquet metadata is.
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) readByte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errThriftTruncated
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) readUvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, errThriftTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) readVarint() (int64, error) {
+	u, err := r.readUvarint()
+	return int64(u>>1) ^ -int64(u&1), err
+}
+
+func (r *thriftReader) readI32() (int32, error) {
+	v, err := r.readVarint()
+	return int32(v), err
+}
+
+func (r *thriftReader) readBinary() ([]byte, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)-r.pos) {
+		return nil, errThriftTruncated
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// readStruct calls f with the ID and type of each field of a struct. f must
+// read or skip the field's value.
+func (r *thriftReader) readStruct(f func(id int16, typ byte) error) error {
+	var id int16
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		typ := b & 0x0f
+		if typ == 0 {
+			return nil // stop field
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := r.readVarint()
+			if err != nil {
+				return err
+			}
+			id = int16(v)
+		}
+		if err := f(id, typ); err != nil {
+			return err
+		}
+	}
+}
+
+// readListHeader returns the element type and size of a list or set.
+func (r *thriftReader) readListHeader() (byte, int, error) {
+	b, err := r.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	n := uint64(b >> 4)
+	if n == 15 {
+		if n, err = r.readUvarint(); err != nil {
+			return 0, 0, err
+		}
+	}
+	// Every element takes at least a byte.
+	if n > uint64(len(r.buf)-r.pos) {
+		return 0, 0, errThriftTruncated
+	}
+	return b & 0x0f, int(n), nil
+}
+
+// skip skips a value of the given type, at the given depth of nesting.
+func (r *thriftReader) skip(typ byte, depth int) error {
+	if depth > maxThriftDepth {
+		return errors.New("values are nested too deeply")
+	}
+	var err error
+	switch typ {
+	case thriftTrue, thriftFalse:
+		// The value of a bool field is in its type.
+	case thriftByte:
+		_, err = r.readByte()
+	case thriftI16, thriftI32, thriftI64:
+		_, err = r.readUvarint()
+	case thriftDouble:
+		if len(r.buf)-r.pos < 8 {
+			return errThriftTruncated
+		}
+		r.pos += 8
+	case thriftBinary:
+		_, err = r.readBinary()
+	case thriftList, thriftSet:
+		var et byte
+		var n int
+		if et, n, err = r.readListHeader(); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := r.skipElem(et, depth+1); err != nil {
+				return err
+			}
+		}
+	case thriftMap:
+		var n uint64
+		if n, err = r.readUvarint(); err != nil || n == 0 {
+			return err
+		}
+		var kv byte
+		if kv, err = r.readByte(); err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := r.skipElem(kv>>4, depth+1); err != nil {
+				return err
+			}
+			if err := r.skipElem(kv&0x0f, depth+1); err != nil {
+				return err
+			}
+		}
+	case thriftStruct:
+		err = r.readStruct(func(_ int16, t byte) error { return r.skip(t, depth+1) })
+	default:
+		err = fmt.Errorf("unknown Thrift type %d", typ)
+	}
+	return err
+}
+
+// skipElem skips an element of a list, set or map. Unlike bool fields, bool
+// elements take a byte each.
+func (r *thriftReader) skipElem(typ byte, depth int) error {
+	if typ == thriftTrue || typ == thriftFalse {
+		_, err := r.readByte()
+		return err
+	}
+	return r.skip(typ, depth)
+}
@@ -0,0 +1,343 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/internal/testutil"
+)
+
+// compactWriter encodes values with the Thrift compact protocol.
+type compactWriter struct {
+	buf    bytes.Buffer
+	lastID []int16
+}
+
+func (w *compactWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *compactWriter) varint(v int64) { w.uvarint(uint64(v<<1) ^ uint64(v>>63)) }
+
+func (w *compactWriter) beginStruct() { w.lastID = append(w.lastID, 0) }
+
+func (w *compactWriter) endStruct() {
+	w.buf.WriteByte(0)
+	w.lastID = w.lastID[:len(w.lastID)-1]
+}
+
+func (w *compactWriter) field(id int16, typ byte) {
+	last := &w.lastID[len(w.lastID)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		w.buf.WriteByte(byte(d)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(int64(id))
+	}
+	*last = id
+}
+
+func (w *compactWriter) i32Field(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(v))
+}
+
+func (w *compactWriter) binaryField(id int16, b string) {
+	w.field(id, thriftBinary)
+	w.uvarint(uint64(len(b)))
+	w.buf.WriteString(b)
+}
+
+func (w *compactWriter) listHeader(typ byte, n int) {
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | typ)
+	} else {
+		w.buf.WriteByte(0xf0 | typ)
+		w.uvarint(uint64(n))
+	}
+}
+
+// pe describes a Parquet SchemaElement.
+type pe struct {
+	name       string
+	typ        int32 // -1 for a group
+	rep        int32
+	children   int32
+	converted  int32 // -1 for none
+	scale      int32
+	precision  int32
+	logical    int16 // 0 for none
+	utc        bool
+	lscale     int32
+	lprecision int32
+}
+
+func (e pe) write(w *compactWriter) {
+	w.beginStruct()
+	if e.typ >= 0 {
+		w.i32Field(1, e.typ)
+	}
+	w.i32Field(3, e.rep)
+	w.binaryField(4, e.name)
+	if e.typ < 0 {
+		w.i32Field(5, e.children)
+	}
+	if e.converted >= 0 {
+		w.i32Field(6, e.converted)
+		if e.converted == convertedDecimal {
+			w.i32Field(7, e.scale)
+			w.i32Field(8, e.precision)
+		}
+	}
+	if e.logical != 0 {
+		w.field(10, thriftStruct)
+		w.beginStruct()
+		w.field(e.logical, thriftStruct)
+		w.beginStruct()
+		switch e.logical {
+		case logicalDecimal:
+			w.i32Field(1, e.lscale)
+			w.i32Field(2, e.lprecision)
+		case logicalTime, logicalTimestamp:
+			if e.utc {
+				w.field(1, thriftTrue)
+			} else {
+				w.field(1, thriftFalse)
+			}
+			// unit: MICROS
+			w.field(2, thriftStruct)
+			w.beginStruct()
+			w.field(2, thriftStruct)
+			w.beginStruct()
+			w.endStruct()
+			w.endStruct()
+		}
+		w.endStruct()
+		w.endStruct()
+	}
+	w.endStruct()
+}
+
+func col(name string, typ, rep int32) pe {
+	return pe{name: name, typ: typ, rep: rep, converted: -1}
+}
+
+func group(name string, rep, children int32) pe {
+	return pe{name: name, typ: -1, rep: rep, children: children, converted: -1}
+}
+
+// parquetFile returns a Parquet file whose footer holds the given schema,
+// after the root element. Fields that the schema reader does not use are
+// included to check that they are skipped.
+func parquetFile(elems ...pe) []byte {
+	var numChildren int32
+	depth := []int32{}
+	for _, e := range elems {
+		// Count the top-level elements.
+		if len(depth) == 0 {
+			numChildren++
+		} else {
+			depth[len(depth)-1]--
+		}
+		if e.typ < 0 && e.children > 0 {
+			depth = append(depth, e.children)
+		}
+		for len(depth) > 0 && depth[len(depth)-1] == 0 {
+			depth = depth[:len(depth)-1]
+		}
+	}
+	w := &compactWriter{}
+	w.beginStruct()
+	w.i32Field(1, 1) // version
+	w.field(2, thriftList)
+	w.listHeader(thriftStruct, len(elems)+1)
+	root := group("schema", parquetRequired, numChildren)
+	root.write(w)
+	for _, e := range elems {
+		e.write(w)
+	}
+	w.field(3, thriftI64) // num_rows
+	w.varint(12345)
+	w.field(4, thriftList) // row_groups
+	w.listHeader(thriftStruct, 1)
+	w.beginStruct()
+	w.field(2, thriftI64)
+	w.varint(100)
+	w.field(5, thriftList) // a list of bools
+	w.listHeader(thriftTrue, 2)
+	w.buf.Write([]byte{1, 2})
+	w.endStruct()
+	w.binaryField(6, "parquet-go test") // created_by
+	w.endStruct()
+
+	var f bytes.Buffer
+	f.WriteString("PAR1")
+	f.WriteString("column data")
+	f.Write(w.buf.Bytes())
+	binary.Write(&f, binary.LittleEndian, uint32(w.buf.Len()))
+	f.WriteString("PAR1")
+	return f.Bytes()
+}
+
+func TestParquetSchema(t *testing.T) {
+	str := col("s", parquetByteArray, parquetOptional)
+	str.converted = convertedUTF8
+	enum := col("e", parquetByteArray, parquetOptional)
+	enum.converted = convertedEnum
+	date := col("d", parquetInt32, parquetOptional)
+	date.logical = logicalDate
+	tsUTC := col("ts", parquetInt64, parquetOptional)
+	tsUTC.logical = logicalTimestamp
+	tsUTC.utc = true
+	tsLocal := col("dt", parquetInt64, parquetOptional)
+	tsLocal.logical = logicalTimestamp
+	tsMillis := col("tsm", parquetInt64, parquetOptional)
+	tsMillis.converted = convertedTimestampMillis
+	tm := col("t", parquetInt64, parquetOptional)
+	tm.logical = logicalTime
+	num := col("n", parquetFixedLenByteArray, parquetOptional)
+	num.converted = convertedDecimal
+	num.precision, num.scale = 38, 9
+	bignum := col("bn", parquetByteArray, parquetOptional)
+	bignum.logical = logicalDecimal
+	bignum.lprecision, bignum.lscale = 40, 10
+	list := group("l", parquetOptional, 1)
+	list.converted = convertedList
+	recList := group("rl", parquetOptional, 1)
+	recList.logical = logicalList
+
+	file := parquetFile(
+		col("b", parquetBoolean, parquetRequired),
+		col("i32", parquetInt32, parquetOptional),
+		col("i64", parquetInt64, parquetOptional),
+		col("i96", parquetInt96, parquetOptional),
+		col("f", parquetFloat, parquetOptional),
+		col("db", parquetDouble, parquetOptional),
+		col("by", parquetByteArray, parquetRepeated),
+		str, enum, date, tsUTC, tsLocal, tsMillis, tm, num, bignum,
+		list,
+		group("list", parquetRepeated, 1),
+		col("element", parquetInt64, parquetOptional),
+		recList,
+		group("list", parquetRepeated, 1),
+		group("element", parquetOptional, 2),
+		col("x", parquetInt32, parquetRequired),
+		col("y", parquetDouble, parquetOptional),
+	)
+	common := Schema{
+		{Name: "b", Type: BooleanFieldType, Required: true},
+		{Name: "i32", Type: IntegerFieldType},
+		{Name: "i64", Type: IntegerFieldType},
+		{Name: "i96", Type: TimestampFieldType},
+		{Name: "f", Type: FloatFieldType},
+		{Name: "db", Type: FloatFieldType},
+		{Name: "by", Type: BytesFieldType, Repeated: true},
+		{Name: "s", Type: StringFieldType},
+	}
+	rest := Schema{
+		{Name: "d", Type: DateFieldType},
+		{Name: "ts", Type: TimestampFieldType},
+		{Name: "dt", Type: DateTimeFieldType},
+		{Name: "tsm", Type: TimestampFieldType},
+		{Name: "t", Type: TimeFieldType},
+		{Name: "n", Type: NumericFieldType},
+		{Name: "bn", Type: BigNumericFieldType},
+	}
+	xy := Schema{
+		{Name: "x", Type: IntegerFieldType, Required: true},
+		{Name: "y", Type: FloatFieldType},
+	}
+	concat := func(ss ...Schema) Schema {
+		var r Schema
+		for _, s := range ss {
+			r = append(r, s...)
+		}
+		return r
+	}
+
+	for _, test := range []struct {
+		opts *ParquetOptions
+		want Schema
+	}{
+		{
+			opts: nil,
+			want: concat(common, Schema{{Name: "e", Type: BytesFieldType}}, rest, Schema{
+				{Name: "l", Type: RecordFieldType, Schema: Schema{
+					{Name: "list", Type: RecordFieldType, Repeated: true, Schema: Schema{
+						{Name: "element", Type: IntegerFieldType},
+					}},
+				}},
+				{Name: "rl", Type: RecordFieldType, Schema: Schema{
+					{Name: "list", Type: RecordFieldType, Repeated: true, Schema: Schema{
+						{Name: "element", Type: RecordFieldType, Schema: xy},
+					}},
+				}},
+			}),
+		},
+		{
+			opts: &ParquetOptions{EnumAsString: true, EnableListInference: true},
+			want: concat(common, Schema{{Name: "e", Type: StringFieldType}}, rest, Schema{
+				{Name: "l", Type: IntegerFieldType, Repeated: true},
+				{Name: "rl", Type: RecordFieldType, Repeated: true, Schema: xy},
+			}),
+		},
+	} {
+		got, err := ParquetSchema(bytes.NewReader(file), int64(len(file)), test.opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", test.opts, err)
+		}
+		if diff := testutil.Diff(got, test.want); diff != "" {
+			t.Errorf("%+v: got=-, want=+:\n%s", test.opts, diff)
+		}
+	}
+}
+
+func TestParquetSchemaErrors(t *testing.T) {
+	tooBig := col("d", parquetByteArray, parquetOptional)
+	tooBig.logical = logicalDecimal
+	tooBig.lprecision, tooBig.lscale = 77, 38
+	valid := parquetFile(col("a", parquetInt32, parquetOptional))
+	truncated := append([]byte("PAR1"), valid[len(valid)-20:]...)
+	binary.LittleEndian.PutUint32(truncated[len(truncated)-8:], 12)
+
+	for _, test := range []struct {
+		desc    string
+		file    []byte
+		wantErr string
+	}{
+		{"empty", nil, "too small"},
+		{"no header", append([]byte("PAR0"), valid[4:]...), "not a Parquet file"},
+		{"no trailer", append(valid[:len(valid)-4:len(valid)-4], "PAR0"...), "not a Parquet file"},
+		{"encrypted", append(valid[:len(valid)-4:len(valid)-4], "PARE"...), "encrypted"},
+		{"footer too long", func() []byte {
+			f := append([]byte(nil), valid...)
+			binary.LittleEndian.PutUint32(f[len(f)-8:], uint32(len(f)))
+			return f
+		}(), "exceeds"},
+		{"truncated footer", truncated, "reading Parquet footer"},
+		{"decimal too large", parquetFile(tooBig), "too large"},
+		{"missing children", parquetFile(group("g", parquetOptional, 2), col("a", parquetInt32, parquetOptional)), "malformed"},
+	} {
+		_, err := ParquetSchema(bytes.NewReader(test.file), int64(len(test.file)), nil)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: got error %v, want one containing %q", test.desc, err, test.wantErr)
+		}
+	}
+}
@@ -132,6 +132,57 @@ type QueryConfig struct {
 	// Allows the schema of the destination table to be updated as a side effect of
 	// the query job.
 	SchemaUpdateOptions []string
+
+	// CreateSession causes the query to start a new session. The ID of the
+	// session is reported in the SessionInfo of the job's statistics.
+	CreateSession bool
+
+	// ConnectionProperties are properties of the connection in which the
+	// query runs. To run the query in an existing session, pass its ID with
+	// the key "session_id"; see SessionConnectionProperty.
+	ConnectionProperties []*ConnectionProperty
+}
+
+// ConnectionProperty is a property of the connection in which a query runs.
+type ConnectionProperty struct {
+	// Key is the name of the property, such as "session_id" or "time_zone".
+	Key string
+	// Value is the value of the property.
+	Value string
+}
+
+// SessionConnectionProperty returns a connection property that runs a query
+// in the session with the given ID.
+func SessionConnectionProperty(sessionID string) *ConnectionProperty {
+	return &ConnectionProperty{Key: "session_id", Value: sessionID}
+}
+
+func (cp *ConnectionProperty) toBQ() *bq.ConnectionProperty {
+	if cp == nil {
+		return nil
+	}
+	return &bq.ConnectionProperty{
+		Key:   cp.Key,
+		Value: cp.Value,
+	}
+}
+
+func bqToConnectionProperty(in *bq.ConnectionProperty) *ConnectionProperty {
+	if in == nil {
+		return nil
+	}
+	return &ConnectionProperty{
+		Key:   in.Key,
+		Value: in.Value,
+	}
+}
+
+func connectionPropertiesToBQ(cps []*ConnectionProperty) []*bq.ConnectionProperty {
+	var out []*bq.ConnectionProperty
+	for _, cp := range cps {
+		out = append(out, cp.toBQ())
+	}
+	return out
 }
 
 func (qc *QueryConfig) toBQ() (*bq.JobConfiguration, error) {
@@ -147,6 +198,8 @@ func (qc *QueryConfig) toBQ() (*bq.JobConfiguration, error) {
 		Clustering:                         qc.Clustering.toBQ(),
 		DestinationEncryptionConfiguration: qc.DestinationEncryptionConfig.toBQ(),
 		SchemaUpdateOptions:                qc.SchemaUpdateOptions,
+		CreateSession:                      qc.CreateSession,
+		ConnectionProperties:               connectionPropertiesToBQ(qc.ConnectionProperties),
 	}
 	if len(qc.TableDefinitions) > 0 {
 		qconf.TableDefinitions = make(map[string]bq.ExternalDataConfiguration)
@@ -219,8 +272,12 @@ func bqToQueryConfig(q *bq.JobConfiguration, c *Client) (*QueryConfig, error) {
 		Clustering:                  bqToClustering(qq.Clustering),
 		DestinationEncryptionConfig: bqToEncryptionConfig(qq.DestinationEncryptionConfiguration),
 		SchemaUpdateOptions:         qq.SchemaUpdateOptions,
+		CreateSession:               qq.CreateSession,
 	}
 	qc.UseStandardSQL = !qc.UseLegacySQL
+	for _, cp := range qq.ConnectionProperties {
+		qc.ConnectionProperties = append(qc.ConnectionProperties, bqToConnectionProperty(cp))
+	}
 
 	if len(qq.TableDefinitions) > 0 {
 		qc.TableDefinitions = make(map[string]ExternalData)
@@ -353,7 +410,10 @@ func (q *Query) Read(ctx context.Context) (it *RowIterator, err error) {
 		location:  resp.JobReference.Location,
 		projectID: resp.JobReference.ProjectId,
 	}
-	if resp.JobComplete {
+	// Results that were not all returned may be large enough to read through
+	// the Storage Read API.
+	storageRead := q.client.rc != nil && resp.PageToken != "" && q.client.rc.accepts(resp.TotalRows)
+	if resp.JobComplete && !storageRead {
 		rowSource := &rowSource{
 			j: minimalJob,
 			// RowIterator can precache results from the iterator to save a lookup.
@@ -363,7 +423,8 @@ func (q *Query) Read(ctx context.Context) (it *RowIterator, err error) {
 		}
 		return newRowIterator(ctx, rowSource, fetchPage), nil
 	}
-	// We're on the fastPath, but we need to poll because the job is incomplete.
+	// We're on the fastPath, but we need to poll because the job is incomplete
+	// or its results are read through the Storage Read API.
 	// Fallback to job-based Read().
 	//
 	// (Issue 2937) In order to satisfy basic probing of the job in classic path,
@@ -401,12 +462,14 @@ func (q *Query) probeFastPath() (*bq.QueryRequest, error) {
 	}
 	pfalse := false
 	qRequest := &bq.QueryRequest{
-		Query:              q.QueryConfig.Q,
-		Location:           q.Location,
-		UseLegacySql:       &pfalse,
-		MaximumBytesBilled: q.QueryConfig.MaxBytesBilled,
-		RequestId:          uid.NewSpace("request", nil).New(),
-		Labels:             q.Labels,
+		Query:                q.QueryConfig.Q,
+		Location:             q.Location,
+		UseLegacySql:         &pfalse,
+		MaximumBytesBilled:   q.QueryConfig.MaxBytesBilled,
+		RequestId:            uid.NewSpace("request", nil).New(),
+		Labels:               q.Labels,
+		CreateSession:        q.QueryConfig.CreateSession,
+		ConnectionProperties: connectionPropertiesToBQ(q.QueryConfig.ConnectionProperties),
 	}
 	if q.QueryConfig.DisableQueryCache {
 		qRequest.UseQueryCache = &pfalse
@@ -68,6 +68,21 @@ func TestQuery(t *testing.T) {
 			src:  defaultQuery,
 			want: defaultQueryJob(),
 		},
+		{
+			dst: c.Dataset("dataset-id").Table("table-id"),
+			src: &QueryConfig{
+				Q:                    "query string",
+				CreateSession:        true,
+				ConnectionProperties: []*ConnectionProperty{{Key: "time_zone", Value: "UTC"}},
+			},
+			want: func() *bq.Job {
+				j := defaultQueryJob()
+				j.Configuration.Query.DefaultDataset = nil
+				j.Configuration.Query.CreateSession = true
+				j.Configuration.Query.ConnectionProperties = []*bq.ConnectionProperty{{Key: "time_zone", Value: "UTC"}}
+				return j
+			}(),
+		},
 		{
 			dst: c.Dataset("dataset-id").Table("table-id"),
 			src: &QueryConfig{
@@ -422,6 +437,8 @@ func TestProbeFastPath(t *testing.T) {
 				Labels: map[string]string{
 					"key": "val",
 				},
+				CreateSession:        true,
+				ConnectionProperties: []*ConnectionProperty{{Key: "time_zone", Value: "UTC"}},
 			},
 			wantReq: &bq.QueryRequest{
 				Query:          "foo",
@@ -429,6 +446,8 @@ func TestProbeFastPath(t *testing.T) {
 				Labels: map[string]string{
 					"key": "val",
 				},
+				CreateSession:        true,
+				ConnectionProperties: []*bq.ConnectionProperty{{Key: "time_zone", Value: "UTC"}},
 				MaximumBytesBilled: 123,
 				UseLegacySql:       &pfalse,
 				QueryParameters: []*bq.QueryParameter{
@@ -494,6 +513,7 @@ func TestConfiguringQuery(t *testing.T) {
 	}
 	query.DestinationEncryptionConfig = &EncryptionConfig{KMSKeyName: "keyName"}
 	query.SchemaUpdateOptions = []string{"ALLOW_FIELD_ADDITION"}
+	query.ConnectionProperties = []*ConnectionProperty{SessionConnectionProperty("sess")}
 
 	// Note: Other configuration fields are tested in other tests above.
 	// A lot of that can be consolidated once Client.Copy is gone.
@@ -512,6 +532,7 @@ func TestConfiguringQuery(t *testing.T) {
 				Clustering:                         &bq.Clustering{Fields: []string{"cfield1"}},
 				DestinationEncryptionConfiguration: &bq.EncryptionConfiguration{KmsKeyName: "keyName"},
 				SchemaUpdateOptions:                []string{"ALLOW_FIELD_ADDITION"},
+				ConnectionProperties:               []*bq.ConnectionProperty{{Key: "session_id", Value: "sess"}},
 			},
 		},
 		JobReference: &bq.JobReference{
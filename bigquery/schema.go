@@ -226,6 +226,12 @@ const (
 	// BigNumericFieldType is a numeric field type that supports values of larger precision
 	// and scale than the NumericFieldType.
 	BigNumericFieldType FieldType = "BIGNUMERIC"
+	// JSONFieldType is a field type for JSON documents. Values of this type are
+	// represented as json.RawMessage.
+	JSONFieldType FieldType = "JSON"
+	// IntervalFieldType is a field type for durations made up of months, days
+	// and a time. Values of this type are represented as *IntervalValue.
+	IntervalFieldType FieldType = "INTERVAL"
 )
 
 var (
@@ -244,6 +250,8 @@ var (
 		NumericFieldType:    true,
 		GeographyFieldType:  true,
 		BigNumericFieldType: true,
+		JSONFieldType:       true,
+		IntervalFieldType:   true,
 	}
 	// The API will accept alias names for the types based on the Standard SQL type names.
 	fieldAliases = map[FieldType]FieldType{
@@ -275,6 +283,8 @@ var typeOfByteSlice = reflect.TypeOf([]byte{})
 //   TIME        civil.Time
 //   DATETIME    civil.DateTime
 //   NUMERIC     *big.Rat
+//   JSON        json.RawMessage
+//   INTERVAL    *IntervalValue
 //
 // The big.Rat type supports numbers of arbitrary size and precision. Values
 // will be rounded to 9 digits after the decimal point before being transmitted
@@ -301,6 +311,8 @@ var typeOfByteSlice = reflect.TypeOf([]byte{})
 //
 // For a nullable BYTES field, use the type []byte and tag the field "nullable" (see below).
 // For a nullable NUMERIC field, use the type *big.Rat and tag the field "nullable".
+// Nullable JSON and INTERVAL fields are similar, with the types json.RawMessage and
+// *IntervalValue.
 //
 // A struct field that is of struct type is inferred to be a required field of type
 // RECORD with a schema inferred recursively. For backwards compatibility, a field of
@@ -321,8 +333,9 @@ var typeOfByteSlice = reflect.TypeOf([]byte{})
 //     bigquery:"-"
 // omits the field from the inferred schema.
 // The "nullable" option marks the field as nullable (not required). It is only
-// needed for []byte, *big.Rat and pointer-to-struct fields, and cannot appear on other
-// fields. In this example, the Go name of the field is retained:
+// needed for []byte, json.RawMessage, *big.Rat, *IntervalValue and pointer-to-struct
+// fields, and cannot appear on other fields. In this example, the Go name of the field
+// is retained:
 //     bigquery:",nullable"
 func InferSchema(st interface{}) (Schema, error) {
 	return inferSchemaReflectCached(reflect.TypeOf(st))
@@ -377,8 +390,8 @@ func inferStruct(t reflect.Type) (Schema, error) {
 
 // inferFieldSchema infers the FieldSchema for a Go type
 func inferFieldSchema(fieldName string, rt reflect.Type, nullable bool) (*FieldSchema, error) {
-	// Only []byte and struct pointers can be tagged nullable.
-	if nullable && !(rt == typeOfByteSlice || rt.Kind() == reflect.Ptr && rt.Elem().Kind() == reflect.Struct) {
+	// Only []byte, json.RawMessage and struct pointers can be tagged nullable.
+	if nullable && !(rt == typeOfByteSlice || rt == typeOfJSON || rt.Kind() == reflect.Ptr && rt.Elem().Kind() == reflect.Struct) {
 		return nil, badNullableError{fieldName, rt}
 	}
 	switch rt {
@@ -398,6 +411,10 @@ func inferFieldSchema(fieldName string, rt reflect.Type, nullable bool) (*FieldS
 		// larger precision of BIGNUMERIC need to manipulate the inferred
 		// schema.
 		return &FieldSchema{Required: !nullable, Type: NumericFieldType}, nil
+	case typeOfJSON:
+		return &FieldSchema{Required: !nullable, Type: JSONFieldType}, nil
+	case typeOfIntervalValue:
+		return &FieldSchema{Required: !nullable, Type: IntervalFieldType}, nil
 	}
 	if ft := nullableFieldType(rt); ft != "" {
 		return &FieldSchema{Required: false, Type: ft}, nil
@@ -0,0 +1,274 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/internal/trace"
+)
+
+// SchemaChangeKind is the kind of a SchemaChange.
+type SchemaChangeKind int
+
+const (
+	// FieldAdded means a field is in the new schema but not the old one. This
+	// includes fields added to a nested RECORD. Adding a NULLABLE or REPEATED
+	// field is compatible; adding a REQUIRED field is not.
+	FieldAdded SchemaChangeKind = iota
+
+	// FieldRelaxed means a REQUIRED field has become NULLABLE. This change is
+	// compatible.
+	FieldRelaxed
+
+	// FieldRemoved means a field is in the old schema but not the new one.
+	// This change is incompatible.
+	FieldRemoved
+
+	// FieldTypeChanged means a field has a different type in the new schema.
+	// This change is incompatible.
+	FieldTypeChanged
+
+	// FieldModeChanged means a field's mode has changed in a way other than
+	// relaxation: a NULLABLE field has become REQUIRED, or a field has become
+	// or stopped being REPEATED. This change is incompatible.
+	FieldModeChanged
+)
+
+func (k SchemaChangeKind) String() string {
+	switch k {
+	case FieldAdded:
+		return "FieldAdded"
+	case FieldRelaxed:
+		return "FieldRelaxed"
+	case FieldRemoved:
+		return "FieldRemoved"
+	case FieldTypeChanged:
+		return "FieldTypeChanged"
+	case FieldModeChanged:
+		return "FieldModeChanged"
+	}
+	return fmt.Sprintf("SchemaChangeKind(%d)", int(k))
+}
+
+// SchemaChange describes a difference between two schemas.
+type SchemaChange struct {
+	Kind SchemaChangeKind
+
+	// Path is the name of the changed field. The names of nested fields are
+	// qualified by the names of their enclosing RECORD fields, separated by
+	// dots; for example, "address.zip".
+	Path string
+
+	// Old and New are the field in the old and new schemas. Old is nil for
+	// FieldAdded, and New is nil for FieldRemoved.
+	Old, New *FieldSchema
+}
+
+// Compatible reports whether the change can be applied to a table with
+// Table.Update.
+func (c *SchemaChange) Compatible() bool {
+	switch c.Kind {
+	case FieldAdded:
+		return fieldMode(c.New) != "REQUIRED"
+	case FieldRelaxed:
+		return true
+	}
+	return false
+}
+
+func (c *SchemaChange) String() string {
+	switch c.Kind {
+	case FieldAdded:
+		return fmt.Sprintf("%s: added %s %s", c.Path, fieldMode(c.New), c.New.Type)
+	case FieldRelaxed:
+		return fmt.Sprintf("%s: relaxed from REQUIRED to NULLABLE", c.Path)
+	case FieldRemoved:
+		return fmt.Sprintf("%s: removed", c.Path)
+	case FieldTypeChanged:
+		return fmt.Sprintf("%s: type changed from %s to %s", c.Path, c.Old.Type, c.New.Type)
+	case FieldModeChanged:
+		return fmt.Sprintf("%s: mode changed from %s to %s", c.Path, fieldMode(c.Old), fieldMode(c.New))
+	}
+	return fmt.Sprintf("%s: %v", c.Path, c.Kind)
+}
+
+// SchemaDiff is the result of comparing two schemas.
+type SchemaDiff struct {
+	Changes []*SchemaChange
+
+	// merged is the old schema with the compatible changes applied.
+	merged Schema
+}
+
+// DiffSchemas compares an existing schema, such as that of a table, with a
+// desired one, such as one produced by InferSchema. Field names are compared
+// case-insensitively, as BigQuery does. Differences in descriptions, policy
+// tags and other field attributes are not reported.
+func DiffSchemas(old, new Schema) *SchemaDiff {
+	d := &SchemaDiff{}
+	d.merged = d.diff("", old, new)
+	return d
+}
+
+// Compatible reports whether all of the changes are compatible, so that the
+// new schema can be applied to the table in full.
+func (d *SchemaDiff) Compatible() bool {
+	return len(d.Incompatible()) == 0
+}
+
+// Incompatible returns the changes that cannot be applied.
+func (d *SchemaDiff) Incompatible() []*SchemaChange {
+	var cs []*SchemaChange
+	for _, c := range d.Changes {
+		if !c.Compatible() {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// Apply returns the old schema with the compatible changes applied. Added
+// fields follow the existing fields of the same RECORD, in the order of the
+// new schema. Fields that were removed or changed incompatibly keep their old
+// definitions.
+func (d *SchemaDiff) Apply() Schema {
+	return d.merged
+}
+
+// diff appends the differences between old and new to d.Changes and returns
+// the merged schema. prefix is the path of the enclosing RECORD, if any.
+func (d *SchemaDiff) diff(prefix string, old, new Schema) Schema {
+	byName := map[string]*FieldSchema{}
+	for _, f := range new {
+		byName[strings.ToLower(f.Name)] = f
+	}
+	inOld := map[string]bool{}
+	var merged Schema
+	for _, of := range old {
+		key := strings.ToLower(of.Name)
+		inOld[key] = true
+		path := prefix + of.Name
+		mf := copyFieldSchema(of)
+		merged = append(merged, mf)
+		nf := byName[key]
+		if nf == nil {
+			d.add(FieldRemoved, path, of, nil)
+			continue
+		}
+		if canonicalFieldType(of.Type) != canonicalFieldType(nf.Type) {
+			d.add(FieldTypeChanged, path, of, nf)
+			continue
+		}
+		switch om, nm := fieldMode(of), fieldMode(nf); {
+		case om == nm:
+		case om == "REQUIRED" && nm == "NULLABLE":
+			d.add(FieldRelaxed, path, of, nf)
+			mf.Required = false
+		default:
+			d.add(FieldModeChanged, path, of, nf)
+		}
+		if canonicalFieldType(of.Type) == RecordFieldType {
+			mf.Schema = d.diff(path+".", of.Schema, nf.Schema)
+		}
+	}
+	for _, nf := range new {
+		if inOld[strings.ToLower(nf.Name)] {
+			continue
+		}
+		c := d.add(FieldAdded, prefix+nf.Name, nil, nf)
+		if c.Compatible() {
+			merged = append(merged, copyFieldSchema(nf))
+		}
+	}
+	return merged
+}
+
+func (d *SchemaDiff) add(kind SchemaChangeKind, path string, old, new *FieldSchema) *SchemaChange {
+	c := &SchemaChange{Kind: kind, Path: path, Old: old, New: new}
+	d.Changes = append(d.Changes, c)
+	return c
+}
+
+// fieldMode returns the mode of a field as the API reports it.
+func fieldMode(f *FieldSchema) string {
+	switch {
+	case f.Repeated:
+		return "REPEATED"
+	case f.Required:
+		return "REQUIRED"
+	}
+	return "NULLABLE"
+}
+
+// canonicalFieldType resolves the Standard SQL aliases of a field type.
+func canonicalFieldType(t FieldType) FieldType {
+	t = FieldType(strings.ToUpper(string(t)))
+	if a, ok := fieldAliases[t]; ok {
+		return a
+	}
+	return t
+}
+
+// copyFieldSchema returns a deep copy of f.
+func copyFieldSchema(f *FieldSchema) *FieldSchema {
+	c := *f
+	if f.PolicyTags != nil {
+		pt := *f.PolicyTags
+		pt.Names = append([]string(nil), f.PolicyTags.Names...)
+		c.PolicyTags = &pt
+	}
+	c.Schema = nil
+	for _, sf := range f.Schema {
+		c.Schema = append(c.Schema, copyFieldSchema(sf))
+	}
+	return &c
+}
+
+// UpdateSchema compares the table's schema with schema and applies the
+// compatible changes: added NULLABLE and REPEATED fields, including nested
+// ones, and relaxations of REQUIRED fields to NULLABLE. Incompatible changes
+// are not applied; they are reported in the returned SchemaDiff, which the
+// caller should check.
+//
+// The update is made with the ETag of the table metadata that was compared,
+// so it fails with an HTTP 412 (Precondition Failed) error if the table was
+// changed concurrently. Call UpdateSchema again in that case. If there are no
+// compatible changes, the table is not updated and its current metadata is
+// returned.
+func (t *Table) UpdateSchema(ctx context.Context, schema Schema) (md *TableMetadata, diff *SchemaDiff, err error) {
+	ctx = trace.StartSpan(ctx, "cloud.google.com/go/bigquery.Table.UpdateSchema")
+	defer func() { trace.EndSpan(ctx, err) }()
+
+	md, err = t.Metadata(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	diff = DiffSchemas(md.Schema, schema)
+	applied := false
+	for _, c := range diff.Changes {
+		applied = applied || c.Compatible()
+	}
+	if !applied {
+		return md, diff, nil
+	}
+	md, err = t.Update(ctx, TableMetadataToUpdate{Schema: diff.Apply()}, md.ETag)
+	if err != nil {
+		return nil, diff, err
+	}
+	return md, diff, nil
+}
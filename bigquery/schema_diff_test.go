@@ -0,0 +1,113 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"testing"
+
+	"cloud.google.com/go/internal/testutil"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	old := Schema{
+		{Name: "id", Type: IntegerFieldType, Required: true},
+		{Name: "name", Type: StringFieldType, Required: true},
+		{Name: "score", Type: FloatFieldType},
+		{Name: "tags", Type: StringFieldType, Repeated: true},
+		{Name: "gone", Type: BytesFieldType},
+		{Name: "addr", Type: RecordFieldType, Schema: Schema{
+			{Name: "city", Type: StringFieldType, Required: true},
+		}},
+	}
+	new := Schema{
+		{Name: "ID", Type: "INT64", Required: true},
+		{Name: "name", Type: StringFieldType},
+		{Name: "score", Type: NumericFieldType},
+		{Name: "tags", Type: StringFieldType},
+		{Name: "addr", Type: RecordFieldType, Schema: Schema{
+			{Name: "city", Type: StringFieldType},
+			{Name: "zip", Type: StringFieldType},
+		}},
+		{Name: "created", Type: TimestampFieldType},
+		{Name: "must", Type: StringFieldType, Required: true},
+	}
+	d := DiffSchemas(old, new)
+
+	var got []string
+	for _, c := range d.Changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"name: relaxed from REQUIRED to NULLABLE",
+		"score: type changed from FLOAT to NUMERIC",
+		"tags: mode changed from REPEATED to NULLABLE",
+		"gone: removed",
+		"addr.city: relaxed from REQUIRED to NULLABLE",
+		"addr.zip: added NULLABLE STRING",
+		"created: added NULLABLE TIMESTAMP",
+		"must: added REQUIRED STRING",
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("changes: got=-, want=+:\n%s", diff)
+	}
+	if d.Compatible() {
+		t.Error("got compatible, want incompatible")
+	}
+	var incompatible []string
+	for _, c := range d.Incompatible() {
+		incompatible = append(incompatible, c.Path)
+	}
+	if diff := testutil.Diff(incompatible, []string{"score", "tags", "gone", "must"}); diff != "" {
+		t.Errorf("incompatible: got=-, want=+:\n%s", diff)
+	}
+
+	wantApplied := Schema{
+		{Name: "id", Type: IntegerFieldType, Required: true},
+		{Name: "name", Type: StringFieldType},
+		{Name: "score", Type: FloatFieldType},
+		{Name: "tags", Type: StringFieldType, Repeated: true},
+		{Name: "gone", Type: BytesFieldType},
+		{Name: "addr", Type: RecordFieldType, Schema: Schema{
+			{Name: "city", Type: StringFieldType},
+			{Name: "zip", Type: StringFieldType},
+		}},
+		{Name: "created", Type: TimestampFieldType},
+	}
+	if diff := testutil.Diff(d.Apply(), wantApplied); diff != "" {
+		t.Errorf("applied: got=-, want=+:\n%s", diff)
+	}
+	// The old schema is not modified.
+	if !old[1].Required || len(old[5].Schema) != 1 {
+		t.Errorf("old schema was modified: %v", old)
+	}
+}
+
+func TestDiffSchemasIdentical(t *testing.T) {
+	s, err := InferSchema(struct {
+		A int
+		B []string
+		C struct{ D float64 }
+	}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := DiffSchemas(s, s)
+	if len(d.Changes) != 0 || !d.Compatible() {
+		t.Errorf("got changes %v, want none", d.Changes)
+	}
+	if diff := testutil.Diff(d.Apply(), s); diff != "" {
+		t.Errorf("applied: got=-, want=+:\n%s", diff)
+	}
+}
@@ -15,6 +15,7 @@
 package bigquery
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"reflect"
@@ -414,6 +415,14 @@ type allNumeric struct {
 	Numeric *big.Rat
 }
 
+type allJSONAndInterval struct {
+	JSON             json.RawMessage
+	NullableJSON     json.RawMessage `bigquery:",nullable"`
+	Interval         *IntervalValue
+	NullableInterval *IntervalValue `bigquery:",nullable"`
+	Intervals        []*IntervalValue
+}
+
 func reqField(name, typ string) *FieldSchema {
 	return &FieldSchema{
 		Name:     name,
@@ -494,6 +503,16 @@ func TestSimpleInference(t *testing.T) {
 				reqField("ByteSlice", "BYTES"),
 			},
 		},
+		{
+			in: allJSONAndInterval{},
+			want: Schema{
+				reqField("JSON", "JSON"),
+				optField("NullableJSON", "JSON"),
+				reqField("Interval", "INTERVAL"),
+				optField("NullableInterval", "INTERVAL"),
+				{Name: "Intervals", Type: "INTERVAL", Repeated: true},
+			},
+		},
 	}
 	for _, tc := range testCases {
 		got, err := InferSchema(tc.in)
@@ -0,0 +1,87 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery_test
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/bqtest"
+	"cloud.google.com/go/internal/testutil"
+)
+
+func TestUpdateSchema(t *testing.T) {
+	ctx := context.Background()
+	srv := bqtest.NewServer()
+	defer srv.Close()
+	client, err := bigquery.NewClient(ctx, "proj", srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ds := client.Dataset("ds")
+	if err := ds.Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	table := ds.Table("people")
+	err = table.Create(ctx, &bigquery.TableMetadata{Schema: bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "age", Type: bigquery.IntegerFieldType},
+		{Name: "city", Type: bigquery.StringFieldType},
+		{Name: "score", Type: bigquery.FloatFieldType},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "age", Type: bigquery.StringFieldType},
+		{Name: "city", Type: bigquery.StringFieldType},
+		{Name: "score", Type: bigquery.FloatFieldType},
+		{Name: "email", Type: bigquery.StringFieldType},
+	}
+	md, diff, err := table.UpdateSchema(ctx, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var incompatible []string
+	for _, c := range diff.Incompatible() {
+		incompatible = append(incompatible, c.String())
+	}
+	if diff := testutil.Diff(incompatible, []string{"age: type changed from INTEGER to STRING"}); diff != "" {
+		t.Errorf("incompatible: got=-, want=+:\n%s", diff)
+	}
+	want := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "age", Type: bigquery.IntegerFieldType},
+		{Name: "city", Type: bigquery.StringFieldType},
+		{Name: "score", Type: bigquery.FloatFieldType},
+		{Name: "email", Type: bigquery.StringFieldType},
+	}
+	if diff := testutil.Diff(md.Schema, want); diff != "" {
+		t.Errorf("schema: got=-, want=+:\n%s", diff)
+	}
+
+	// Nothing more can be applied, so the table is left alone.
+	md2, _, err := table.UpdateSchema(ctx, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md2.ETag != md.ETag {
+		t.Errorf("got ETag %q, want unchanged %q", md2.ETag, md.ETag)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/internal/trace"
+	"google.golang.org/api/iterator"
+)
+
+// ScriptStatement is a statement or expression evaluated by a script, which
+// ran as a child job of the script's job.
+type ScriptStatement struct {
+	// Job is the child job that evaluated the statement.
+	Job *Job
+
+	// StatementType is the type of the statement, such as "SELECT",
+	// "CREATE_TABLE" or "INSERT". It is empty if the job's statistics do not
+	// report it.
+	StatementType string
+
+	// EvaluationKind is "STATEMENT" for a statement, or "EXPRESSION" for an
+	// expression evaluated as part of a statement, such as the condition of
+	// an IF or the value of a SET.
+	EvaluationKind string
+
+	// StackFrames locate the statement in the script, innermost first.
+	StackFrames []*ScriptStackFrame
+}
+
+// Read returns the result rows of the statement. Every SELECT statement in a
+// script has its own results; reading the script's job returns the results
+// of its final statement.
+func (s *ScriptStatement) Read(ctx context.Context) (*RowIterator, error) {
+	return s.Job.Read(ctx)
+}
+
+// ScriptStatements returns the statements evaluated by a script, in the order
+// in which they ran. j must be the job of a multi-statement query. Call it
+// after the script's job is done to get all of its statements.
+func (j *Job) ScriptStatements(ctx context.Context) (ss []*ScriptStatement, err error) {
+	ctx = trace.StartSpan(ctx, "cloud.google.com/go/bigquery.Job.ScriptStatements")
+	defer func() { trace.EndSpan(ctx, err) }()
+
+	if !j.isQuery() {
+		return nil, errors.New("bigquery: cannot list the statements of a non-query job")
+	}
+	it := j.Children(ctx)
+	it.ProjectID = j.projectID
+	for {
+		child, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s := &ScriptStatement{Job: child}
+		if st := child.LastStatus(); st != nil && st.Statistics != nil {
+			if sst := st.Statistics.ScriptStatistics; sst != nil {
+				s.EvaluationKind = sst.EvaluationKind
+				s.StackFrames = sst.StackFrames
+			}
+			if qs, ok := st.Statistics.Details.(*QueryStatistics); ok {
+				s.StatementType = qs.StatementType
+			}
+		}
+		ss = append(ss, s)
+	}
+	// Jobs are listed newest first. Reverse them, then sort by creation time
+	// in case the list is not strictly ordered.
+	for i, k := 0, len(ss)-1; i < k; i, k = i+1, k-1 {
+		ss[i], ss[k] = ss[k], ss[i]
+	}
+	sort.SliceStable(ss, func(a, b int) bool {
+		return creationTime(ss[a].Job).Before(creationTime(ss[b].Job))
+	})
+	return ss, nil
+}
+
+func creationTime(j *Job) (t time.Time) {
+	if st := j.LastStatus(); st != nil && st.Statistics != nil {
+		t = st.Statistics.CreationTime
+	}
+	return t
+}
+
+// AbortSession terminates the session with the given ID, which was created
+// by a query with CreateSession set. The session's temporary tables and
+// variables are discarded, and later queries in the session fail. Sessions
+// otherwise end after a period of inactivity.
+//
+// The session must be in the client's Location, if it is set.
+func (c *Client) AbortSession(ctx context.Context, sessionID string) (err error) {
+	ctx = trace.StartSpan(ctx, "cloud.google.com/go/bigquery.Client.AbortSession")
+	defer func() { trace.EndSpan(ctx, err) }()
+
+	q := c.Query("CALL BQ.ABORT_SESSION()")
+	q.ConnectionProperties = []*ConnectionProperty{SessionConnectionProperty(sessionID)}
+	job, err := q.Run(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
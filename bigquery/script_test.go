@@ -0,0 +1,142 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/internal/testutil"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+)
+
+func newScriptTestClient(t *testing.T, h http.HandlerFunc) (*Client, func()) {
+	ts := httptest.NewServer(h)
+	c, err := NewClient(context.Background(), "p", option.WithEndpoint(ts.URL+"/bigquery/v2/"), option.WithoutAuthentication())
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		ts.Close()
+	}
+}
+
+func TestScriptStatements(t *testing.T) {
+	var gotParent string
+	c, cleanup := newScriptTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		gotParent = r.URL.Query().Get("parentJobId")
+		// Newest first, as the service lists them.
+		fmt.Fprint(w, `{"jobs": [
+			{"jobReference": {"projectId": "p", "jobId": "script_job_2"}, "state": "DONE", "status": {"state": "DONE"},
+			 "configuration": {"query": {"query": "SELECT x"}},
+			 "statistics": {"creationTime": "3000", "parentJobId": "script",
+			  "scriptStatistics": {"evaluationKind": "STATEMENT", "stackFrames": [{"startLine": 3, "startColumn": 1, "text": "SELECT x"}]},
+			  "query": {"statementType": "SELECT"}}},
+			{"jobReference": {"projectId": "p", "jobId": "script_job_1"}, "state": "DONE", "status": {"state": "DONE"},
+			 "configuration": {"query": {"query": "SET x = 1"}},
+			 "statistics": {"creationTime": "2000", "parentJobId": "script",
+			  "scriptStatistics": {"evaluationKind": "EXPRESSION"}}},
+			{"jobReference": {"projectId": "p", "jobId": "script_job_0"}, "state": "DONE", "status": {"state": "DONE"},
+			 "configuration": {"query": {"query": "CREATE TEMP TABLE t AS SELECT 1 AS x"}},
+			 "statistics": {"creationTime": "1000", "parentJobId": "script",
+			  "scriptStatistics": {"evaluationKind": "STATEMENT"},
+			  "query": {"statementType": "CREATE_TABLE_AS_SELECT"}}}
+		]}`)
+	})
+	defer cleanup()
+
+	j := &Job{c: c, projectID: "p", jobID: "script", config: &bq.JobConfiguration{Query: &bq.JobConfigurationQuery{}}}
+	ss, err := j.ScriptStatements(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotParent != "script" {
+		t.Errorf("got parentJobId %q, want %q", gotParent, "script")
+	}
+	type statement struct {
+		JobID, StatementType, EvaluationKind string
+		Frames                               int
+	}
+	var got []statement
+	for _, s := range ss {
+		got = append(got, statement{s.Job.ID(), s.StatementType, s.EvaluationKind, len(s.StackFrames)})
+	}
+	want := []statement{
+		{"script_job_0", "CREATE_TABLE_AS_SELECT", "STATEMENT", 0},
+		{"script_job_1", "", "EXPRESSION", 0},
+		{"script_job_2", "SELECT", "STATEMENT", 1},
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("statements: got=-, want=+:\n%s", diff)
+	}
+
+	nonQuery := &Job{c: c, projectID: "p", jobID: "load", config: &bq.JobConfiguration{Load: &bq.JobConfigurationLoad{}}}
+	if _, err := nonQuery.ScriptStatements(context.Background()); err == nil {
+		t.Error("got nil, want error for a non-query job")
+	}
+}
+
+func TestAbortSession(t *testing.T) {
+	var mu sync.Mutex
+	var inserted *bq.Job
+	c, cleanup := newScriptTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/jobs"):
+			body, _ := ioutil.ReadAll(r.Body)
+			inserted = &bq.Job{}
+			if err := json.Unmarshal(body, inserted); err != nil {
+				t.Error(err)
+			}
+			inserted.Status = &bq.JobStatus{State: "DONE"}
+			json.NewEncoder(w).Encode(inserted)
+		case strings.Contains(r.URL.Path, "/queries/"):
+			fmt.Fprint(w, `{"jobComplete": true}`)
+		default:
+			json.NewEncoder(w).Encode(inserted)
+		}
+	})
+	defer cleanup()
+
+	if err := c.AbortSession(context.Background(), "sess-1"); err != nil {
+		t.Fatal(err)
+	}
+	q := inserted.Configuration.Query
+	if q.Query != "CALL BQ.ABORT_SESSION()" {
+		t.Errorf("got query %q", q.Query)
+	}
+	if diff := testutil.Diff(q.ConnectionProperties, []*bq.ConnectionProperty{{Key: "session_id", Value: "sess-1"}}); diff != "" {
+		t.Errorf("connection properties: got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestSessionInfoStatistics(t *testing.T) {
+	j := &Job{lastStatus: &JobStatus{}}
+	j.setStatistics(&bq.JobStatistics{SessionInfo: &bq.SessionInfo{SessionId: "sess-1"}}, nil)
+	if got := j.lastStatus.Statistics.SessionInfo; got == nil || got.SessionID != "sess-1" {
+		t.Errorf("got SessionInfo %+v, want session ID sess-1", got)
+	}
+}
@@ -56,6 +56,8 @@ var bqTypeToFieldTypeMap = map[storagepb.TableFieldSchema_Type]descriptorpb.Fiel
 	storagepb.TableFieldSchema_DOUBLE:     descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
 	storagepb.TableFieldSchema_GEOGRAPHY:  descriptorpb.FieldDescriptorProto_TYPE_STRING,
 	storagepb.TableFieldSchema_INT64:      descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	storagepb.TableFieldSchema_INTERVAL:   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	storagepb.TableFieldSchema_JSON:       descriptorpb.FieldDescriptorProto_TYPE_STRING,
 	storagepb.TableFieldSchema_NUMERIC:    descriptorpb.FieldDescriptorProto_TYPE_BYTES,
 	storagepb.TableFieldSchema_STRING:     descriptorpb.FieldDescriptorProto_TYPE_STRING,
 	storagepb.TableFieldSchema_STRUCT:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
@@ -74,6 +76,8 @@ var bqTypeToWrapperMap = map[storagepb.TableFieldSchema_Type]string{
 	storagepb.TableFieldSchema_DOUBLE:     ".google.protobuf.DoubleValue",
 	storagepb.TableFieldSchema_GEOGRAPHY:  ".google.protobuf.StringValue",
 	storagepb.TableFieldSchema_INT64:      ".google.protobuf.Int64Value",
+	storagepb.TableFieldSchema_INTERVAL:   ".google.protobuf.StringValue",
+	storagepb.TableFieldSchema_JSON:       ".google.protobuf.StringValue",
 	storagepb.TableFieldSchema_NUMERIC:    ".google.protobuf.BytesValue",
 	storagepb.TableFieldSchema_STRING:     ".google.protobuf.StringValue",
 	storagepb.TableFieldSchema_TIME:       ".google.protobuf.Int64Value",
@@ -35,6 +35,8 @@ var fieldTypeMap = map[bigquery.FieldType]storagepb.TableFieldSchema_Type{
 	bigquery.NumericFieldType:    storagepb.TableFieldSchema_NUMERIC,
 	bigquery.BigNumericFieldType: storagepb.TableFieldSchema_BIGNUMERIC,
 	bigquery.GeographyFieldType:  storagepb.TableFieldSchema_GEOGRAPHY,
+	bigquery.JSONFieldType:       storagepb.TableFieldSchema_JSON,
+	bigquery.IntervalFieldType:   storagepb.TableFieldSchema_INTERVAL,
 }
 
 func bqFieldToProto(in *bigquery.FieldSchema) (*storagepb.TableFieldSchema, error) {
@@ -157,6 +157,19 @@ func TestSchemaConversion(t *testing.T) {
 				},
 			},
 		},
+		{
+			description: "json and interval",
+			bqSchema: bigquery.Schema{
+				{Name: "doc", Type: bigquery.JSONFieldType},
+				{Name: "durations", Type: bigquery.IntervalFieldType, Repeated: true},
+			},
+			storageSchema: &storagepb.TableSchema{
+				Fields: []*storagepb.TableFieldSchema{
+					{Name: "doc", Type: storagepb.TableFieldSchema_JSON, Mode: storagepb.TableFieldSchema_NULLABLE},
+					{Name: "durations", Type: storagepb.TableFieldSchema_INTERVAL, Mode: storagepb.TableFieldSchema_REPEATED},
+				},
+			},
+		},
 		{
 			description: "nested",
 			bqSchema: bigquery.Schema{
@@ -38,6 +38,9 @@ type AppendResult struct {
 
 	// the stream offset
 	offset int64
+
+	// the updated table schema reported by the append response, if any
+	updatedSchema *storagepb.TableSchema
 }
 
 func newAppendResult(data [][]byte) *AppendResult {
@@ -62,6 +65,24 @@ func (ar *AppendResult) GetResult(ctx context.Context) (int64, error) {
 	}
 }
 
+// UpdatedSchema returns the updated schema of the destination table reported
+// by the response to the append, or nil if the response reported none. The
+// service reports an updated schema when the table schema has changed since
+// the connection was opened, such as after a column was added. It blocks until
+// the result is ready.
+//
+// Rows with the new columns can be appended after the writer descriptor is
+// updated with ManagedStream.UpdateSchemaDescriptor, which
+// WithAutomaticSchemaUpdates does for streams configured with WithJSONRows.
+func (ar *AppendResult) UpdatedSchema(ctx context.Context) (*storagepb.TableSchema, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-ar.Ready():
+		return ar.updatedSchema, nil
+	}
+}
+
 // pendingWrite tracks state for a set of rows that are part of a single
 // append request.
 type pendingWrite struct {
@@ -136,9 +136,28 @@ func (c *Client) buildManagedStream(ctx context.Context, streamFunc streamClient
 					return nil, fmt.Errorf("couldn't create write stream: %v", err)
 				}
 				streamName = resp.GetName()
+				if ms.tableSchema == nil {
+					ms.tableSchema = resp.GetTableSchema()
+				}
 			}
 			ms.streamSettings.streamID = streamName
 		}
+		if ms.jsonRows {
+			if ms.tableSchema == nil {
+				// The default stream reports the schema of its table.
+				info, err := c.getWriteStream(ctx, ms.streamSettings.streamID)
+				if err != nil {
+					return nil, fmt.Errorf("couldn't fetch table schema: %v", err)
+				}
+				ms.tableSchema = info.GetTableSchema()
+			}
+			rc, dp, err := newRowConverter(ms.tableSchema)
+			if err != nil {
+				return nil, err
+			}
+			ms.rowConverter = rc
+			ms.schemaDescriptor = dp
+		}
 	}
 	if ms.streamSettings != nil {
 		if ms.ctx != nil {
@@ -166,6 +185,9 @@ func (c *Client) validateOptions(ctx context.Context, ms *ManagedStream) error {
 		// update type and destination based on stream metadata
 		ms.streamSettings.streamType = StreamType(info.Type.String())
 		ms.destinationTable = TableParentFromStreamName(ms.streamSettings.streamID)
+		if ms.tableSchema == nil {
+			ms.tableSchema = info.GetTableSchema()
+		}
 	}
 	if ms.destinationTable == "" {
 		return fmt.Errorf("no destination table specified")
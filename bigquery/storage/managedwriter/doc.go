@@ -30,6 +30,8 @@ use cases and needs.  See the StreamType documentation for more details about ea
 This API uses serialized protocol buffer messages for appending data to streams.  For users who don't have predefined protocol
 buffer messages for sending data, the cloud.google.com/go/bigquery/storage/managedwriter/adapt subpackage includes functionality
 for defining protocol buffer messages dynamically using table schema information, which enables users to do things like using
-protojson to convert json text into a protocol buffer.
+protojson to convert json text into a protocol buffer.  Streams configured with the WithJSONRows option do this
+conversion themselves: they accept rows as JSON objects, maps or bigquery.ValueSavers, and convert them into messages
+of a descriptor built from the table schema.
 */
 package managedwriter
@@ -16,15 +16,19 @@ package managedwriter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 
+	"cloud.google.com/go/bigquery"
 	"github.com/googleapis/gax-go/v2"
 	"go.opencensus.io/tag"
 	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1beta2"
 	"google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
@@ -78,16 +82,33 @@ type ManagedStream struct {
 	c                *Client
 	fc               *flowController
 
+	// conversion of rows for WithJSONRows
+	jsonRows          bool
+	autoSchemaUpdates bool
+	tableSchema       *storagepb.TableSchema
+	rowConverter      *rowConverter
+	// updatedSchema holds the last updated table schema reported by an append
+	// response. The receive processor stores it without taking the mutex, and
+	// appends apply it.
+	updatedSchema atomic.Value
+	seenSchema    *storagepb.TableSchema // the last updated schema applied
+
 	// aspects of the stream client
 	ctx    context.Context // retained context for the stream
 	cancel context.CancelFunc
 	open   func(streamID string) (storagepb.BigQueryWrite_AppendRowsClient, error) // how we get a new connection
 
+	// connMu is held for reading while appends are sent and enqueued, and
+	// for writing while a connection is replaced.
+	connMu sync.RWMutex
+
 	mu          sync.Mutex
 	arc         *storagepb.BigQueryWrite_AppendRowsClient // current stream connection
 	err         error                                     // terminal error
 	pending     chan *pendingWrite                        // writes awaiting status
+	recvDone    chan struct{}                             // closed when the receive processor of the connection exits
 	streamSetup *sync.Once                                // handles amending the first request in a new stream
+	reconnect   bool                                      // the connection must be replaced to change the writer schema
 }
 
 // enables testing
@@ -187,8 +208,9 @@ func (ms *ManagedStream) getStream(arc *storagepb.BigQueryWrite_AppendRowsClient
 		return nil, nil, ms.err
 	}
 
-	// Always return the retained ARC if the arg differs.
-	if arc != ms.arc {
+	// Always return the retained ARC if the arg differs, unless the connection
+	// was replaced.
+	if arc != ms.arc && ms.arc != nil {
 		return ms.arc, ms.pending, nil
 	}
 
@@ -221,7 +243,12 @@ func (ms *ManagedStream) openWithRetry() (storagepb.BigQueryWrite_AppendRowsClie
 			// The channel relationship with its ARC is 1:1.  If we get a new ARC, create a new chan
 			// and fire up the associated receive processor.
 			ch := make(chan *pendingWrite)
-			go recvProcessor(ms.ctx, arc, ms.fc, ch)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				recvProcessor(ms.ctx, arc, ms.fc, ch, ms.schemaUpdated)
+			}()
+			ms.recvDone = done
 			// Also, replace the sync.Once for setting up a new stream, as we need to do "special" work
 			// for every new connection.
 			ms.streamSetup = new(sync.Once)
@@ -246,16 +273,22 @@ func (ms *ManagedStream) append(pw *pendingWrite, opts ...gax.CallOption) error
 	var err error
 
 	for {
+		ms.replaceConnection()
+		ms.connMu.RLock()
 		arc, ch, err = ms.getStream(arc)
 		if err != nil {
+			ms.connMu.RUnlock()
 			return err
 		}
 		var req *storagepb.AppendRowsRequest
 		ms.streamSetup.Do(func() {
+			ms.mu.Lock()
+			dp := ms.schemaDescriptor
+			ms.mu.Unlock()
 			reqCopy := *pw.request
 			reqCopy.WriteStream = ms.streamSettings.streamID
 			reqCopy.GetProtoRows().WriterSchema = &storagepb.ProtoSchema{
-				ProtoDescriptor: ms.schemaDescriptor,
+				ProtoDescriptor: dp,
 			}
 			if ms.streamSettings.TraceID != "" {
 				reqCopy.TraceId = ms.streamSettings.TraceID
@@ -273,6 +306,10 @@ func (ms *ManagedStream) append(pw *pendingWrite, opts ...gax.CallOption) error
 		recordStat(ms.ctx, AppendRequests, 1)
 		recordStat(ms.ctx, AppendRequestBytes, int64(pw.reqSize))
 		recordStat(ms.ctx, AppendRequestRows, int64(len(pw.request.GetProtoRows().Rows.GetSerializedRows())))
+		if err == nil {
+			ch <- pw
+		}
+		ms.connMu.RUnlock()
 		if err != nil {
 			status := grpcstatus.Convert(err)
 			if status != nil {
@@ -290,15 +327,96 @@ func (ms *ManagedStream) append(pw *pendingWrite, opts ...gax.CallOption) error
 			ms.err = err
 			ms.mu.Unlock()
 		}
-		if err == nil {
-			ch <- pw
-		}
 		return err
 	}
 }
 
+// UpdateSchemaDescriptor replaces the descriptor of the serialized rows of
+// later appends, such as after a column was added to the destination table.
+// If a connection is open, the appends already sent complete on it, and later
+// appends are sent on a new connection that carries the new descriptor, so no
+// appends are lost or duplicated.
+func (ms *ManagedStream) UpdateSchemaDescriptor(dp *descriptorpb.DescriptorProto) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.err != nil {
+		return ms.err
+	}
+	ms.schemaDescriptor = dp
+	ms.reconnect = ms.arc != nil
+	return nil
+}
+
+// schemaUpdated is called by the receive processor when an append response
+// reports an updated table schema. With WithAutomaticSchemaUpdates, it is
+// recorded for applySchemaUpdate.
+func (ms *ManagedStream) schemaUpdated(schema *storagepb.TableSchema) {
+	if ms.autoSchemaUpdates {
+		ms.updatedSchema.Store(schema)
+	}
+}
+
+// applySchemaUpdate rebuilds the row conversion and writer descriptor of a
+// stream configured with WithJSONRows from the last updated table schema, if
+// it has not been applied.
+//
+// The calling code must hold the mutex lock.
+func (ms *ManagedStream) applySchemaUpdate() {
+	schema, _ := ms.updatedSchema.Load().(*storagepb.TableSchema)
+	if schema == nil || schema == ms.seenSchema {
+		return
+	}
+	ms.seenSchema = schema
+	if ms.rowConverter == nil || proto.Equal(schema, ms.tableSchema) {
+		return
+	}
+	rc, dp, err := newRowConverter(schema)
+	if err != nil {
+		// Keep appending with the previous schema.
+		return
+	}
+	ms.tableSchema = schema
+	ms.rowConverter = rc
+	ms.schemaDescriptor = dp
+	ms.reconnect = ms.arc != nil
+}
+
+// replaceConnection closes the connection if the writer schema has changed,
+// once the appends sent on it are acknowledged, so that the next append opens
+// a new connection. Waiting for the acknowledgements preserves the order of
+// appends with offsets.
+func (ms *ManagedStream) replaceConnection() {
+	ms.mu.Lock()
+	ms.applySchemaUpdate()
+	need := ms.reconnect
+	ms.mu.Unlock()
+	if !need {
+		return
+	}
+	ms.connMu.Lock()
+	defer ms.connMu.Unlock()
+	ms.mu.Lock()
+	if !ms.reconnect || ms.arc == nil {
+		ms.reconnect = false
+		ms.mu.Unlock()
+		return
+	}
+	arc, ch, done := *ms.arc, ms.pending, ms.recvDone
+	ms.arc, ms.pending, ms.recvDone = nil, nil, nil
+	ms.reconnect = false
+	ms.mu.Unlock()
+
+	// The receive processor handles the responses to the writes already in
+	// ch, and exits when it is closed. Any error is reported on those writes.
+	arc.CloseSend()
+	close(ch)
+	<-done
+}
+
 // Close closes a managed stream.
 func (ms *ManagedStream) Close() error {
+	ms.connMu.Lock()
+	defer ms.connMu.Unlock()
 
 	var arc *storagepb.BigQueryWrite_AppendRowsClient
 
@@ -344,11 +462,88 @@ func (ms *ManagedStream) AppendRows(ctx context.Context, data [][]byte, offset i
 	return pw.result, nil
 }
 
+// AppendJSONRows converts rows given as JSON objects into messages of the
+// table schema, and appends them like AppendRows. The stream must be
+// configured with WithJSONRows.
+//
+// Values are converted to the types of their columns. Besides JSON strings,
+// numbers and booleans, strings in the formats used by the BigQuery API are
+// accepted for TIMESTAMP, DATE, TIME, DATETIME, NUMERIC and BIGNUMERIC columns,
+// and BYTES are base64-encoded strings. If any row cannot be converted, no
+// rows are appended and the error is a RowConversionErrors.
+func (ms *ManagedStream) AppendJSONRows(ctx context.Context, rows [][]byte, offset int64) (*AppendResult, error) {
+	rc := ms.converter()
+	if rc == nil {
+		return nil, errNoJSONRows
+	}
+	data, err := convertRows(len(rows), func(i int) ([]byte, error) {
+		return rc.convertJSON(rows[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms.AppendRows(ctx, data, offset)
+}
+
+// AppendMapRows converts rows given as maps from column names to values into
+// messages of the table schema, and appends them like AppendRows. The stream
+// must be configured with WithJSONRows.
+//
+// Values may have the types returned by a bigquery.RowIterator for their
+// columns, or any of the types accepted by AppendJSONRows. RECORD values are
+// maps, and REPEATED values are slices. If any row cannot be converted, no rows
+// are appended and the error is a RowConversionErrors.
+func (ms *ManagedStream) AppendMapRows(ctx context.Context, rows []map[string]interface{}, offset int64) (*AppendResult, error) {
+	rc := ms.converter()
+	if rc == nil {
+		return nil, errNoJSONRows
+	}
+	data, err := convertRows(len(rows), func(i int) ([]byte, error) {
+		return rc.convertMap(rows[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms.AppendRows(ctx, data, offset)
+}
+
+// AppendValueSaverRows converts the rows saved by ValueSavers, such as
+// bigquery.StructSavers, into messages of the table schema, and appends them
+// like AppendRows. Insert IDs are ignored. The stream must be configured with
+// WithJSONRows. If any row cannot be converted, no rows are appended and the
+// error is a RowConversionErrors.
+func (ms *ManagedStream) AppendValueSaverRows(ctx context.Context, rows []bigquery.ValueSaver, offset int64) (*AppendResult, error) {
+	rc := ms.converter()
+	if rc == nil {
+		return nil, errNoJSONRows
+	}
+	data, err := convertRows(len(rows), func(i int) ([]byte, error) {
+		return rc.convertValueSaver(rows[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms.AppendRows(ctx, data, offset)
+}
+
+var errNoJSONRows = errors.New("stream was not configured with WithJSONRows")
+
+// converter returns the current row converter of the stream, if any.
+func (ms *ManagedStream) converter() *rowConverter {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.applySchemaUpdate()
+	return ms.rowConverter
+}
+
 // recvProcessor is used to propagate append responses back up with the originating write requests in a goroutine.
 //
 // The receive processor only deals with a single instance of a connection/channel, and thus should never interact
 // with the mutex lock.
-func recvProcessor(ctx context.Context, arc storagepb.BigQueryWrite_AppendRowsClient, fc *flowController, ch <-chan *pendingWrite) {
+//
+// If a response reports an updated table schema, it is recorded on the AppendResult and passed to schemaUpdated,
+// if that is not nil. schemaUpdated must not block, or take the mutex lock.
+func recvProcessor(ctx context.Context, arc storagepb.BigQueryWrite_AppendRowsClient, fc *flowController, ch <-chan *pendingWrite, schemaUpdated func(*storagepb.TableSchema)) {
 	// TODO:  We'd like to re-send requests that are in an ambiguous state due to channel errors.  For now, we simply
 	// ensure that pending writes get acknowledged with a terminal state.
 	for {
@@ -376,6 +571,13 @@ func recvProcessor(ctx context.Context, arc storagepb.BigQueryWrite_AppendRowsCl
 			}
 			recordStat(ctx, AppendResponses, 1)
 
+			if schema := resp.GetUpdatedSchema(); schema != nil {
+				nextWrite.result.updatedSchema = schema
+				if schemaUpdated != nil {
+					schemaUpdated(schema)
+				}
+			}
+
 			if status := resp.GetError(); status != nil {
 				tagCtx, _ := tag.New(ctx, tag.Insert(keyError, codes.Code(status.GetCode()).String()))
 				if err != nil {
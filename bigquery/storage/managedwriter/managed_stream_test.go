@@ -16,7 +16,9 @@ package managedwriter
 
 import (
 	"context"
+	"reflect"
 	"testing"
+	"time"
 
 	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1beta2"
 	"google.golang.org/grpc/codes"
@@ -169,12 +171,215 @@ func TestManagedStream_FirstAppendBehavior(t *testing.T) {
 	}
 }
 
+func TestManagedStream_UpdateSchemaDescriptor(t *testing.T) {
+	ctx := context.Background()
+
+	var testARC *testAppendRowsClient
+	testARC = &testAppendRowsClient{
+		recvF: func() (*storagepb.AppendRowsResponse, error) {
+			return &storagepb.AppendRowsResponse{
+				Response: &storagepb.AppendRowsResponse_AppendResult_{},
+			}, nil
+		},
+		sendF: func(req *storagepb.AppendRowsRequest) error {
+			testARC.requests = append(testARC.requests, req)
+			return nil
+		},
+	}
+	ms := &ManagedStream{
+		ctx: ctx,
+		open: func(s string) (storagepb.BigQueryWrite_AppendRowsClient, error) {
+			testARC.openCount = testARC.openCount + 1
+			return testARC, nil
+		},
+		streamSettings:   defaultStreamSettings(),
+		fc:               newFlowController(0, 0),
+		schemaDescriptor: &descriptorpb.DescriptorProto{Name: proto.String("v1")},
+	}
+	ms.streamSettings.streamID = "FOO"
+
+	// Updating the descriptor before the first append needs no new connection.
+	if err := ms.UpdateSchemaDescriptor(&descriptorpb.DescriptorProto{Name: proto.String("v2")}); err != nil {
+		t.Fatal(err)
+	}
+	var results []*AppendResult
+	appendRows := func() {
+		res, err := ms.AppendRows(ctx, [][]byte{[]byte("foo")}, NoStreamOffset)
+		if err != nil {
+			t.Fatalf("AppendRows: %v", err)
+		}
+		results = append(results, res)
+	}
+	appendRows()
+	appendRows()
+	if err := ms.UpdateSchemaDescriptor(&descriptorpb.DescriptorProto{Name: proto.String("v3")}); err != nil {
+		t.Fatal(err)
+	}
+	appendRows()
+	appendRows()
+
+	if testARC.openCount != 2 {
+		t.Errorf("got %d opens, want 2", testARC.openCount)
+	}
+	if testARC.closeCount != 1 {
+		t.Errorf("got %d closes, want 1", testARC.closeCount)
+	}
+	for _, res := range results {
+		if _, err := res.GetResult(ctx); err != nil {
+			t.Errorf("GetResult: %v", err)
+		}
+	}
+	var got []string
+	for _, req := range testARC.requests {
+		got = append(got, req.GetProtoRows().GetWriterSchema().GetProtoDescriptor().GetName())
+	}
+	if want := []string{"v2", "", "v3", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got writer schemas %q, want %q", got, want)
+	}
+}
+
+func TestManagedStream_AutomaticSchemaUpdates(t *testing.T) {
+	ctx := context.Background()
+	oldSchema := &storagepb.TableSchema{
+		Fields: []*storagepb.TableFieldSchema{
+			{Name: "name", Type: storagepb.TableFieldSchema_STRING, Mode: storagepb.TableFieldSchema_NULLABLE},
+		},
+	}
+	newSchema := &storagepb.TableSchema{
+		Fields: []*storagepb.TableFieldSchema{
+			{Name: "name", Type: storagepb.TableFieldSchema_STRING, Mode: storagepb.TableFieldSchema_NULLABLE},
+			{Name: "added", Type: storagepb.TableFieldSchema_INT64, Mode: storagepb.TableFieldSchema_NULLABLE},
+		},
+	}
+
+	var testARC *testAppendRowsClient
+	testARC = &testAppendRowsClient{
+		recvF: func() (*storagepb.AppendRowsResponse, error) {
+			// The schema is reported updated on the first connection.
+			resp := &storagepb.AppendRowsResponse{
+				Response: &storagepb.AppendRowsResponse_AppendResult_{},
+			}
+			if testARC.openCount == 1 {
+				resp.UpdatedSchema = newSchema
+			}
+			return resp, nil
+		},
+		sendF: func(req *storagepb.AppendRowsRequest) error {
+			testARC.requests = append(testARC.requests, req)
+			return nil
+		},
+	}
+	rc, dp, err := newRowConverter(oldSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := &ManagedStream{
+		ctx: ctx,
+		open: func(s string) (storagepb.BigQueryWrite_AppendRowsClient, error) {
+			testARC.openCount = testARC.openCount + 1
+			return testARC, nil
+		},
+		streamSettings:    defaultStreamSettings(),
+		fc:                newFlowController(0, 0),
+		jsonRows:          true,
+		autoSchemaUpdates: true,
+		tableSchema:       oldSchema,
+		rowConverter:      rc,
+		schemaDescriptor:  dp,
+	}
+	ms.streamSettings.streamID = "FOO"
+
+	newRow := [][]byte{[]byte(`{"name": "b", "added": 1}`)}
+	if _, err := ms.AppendJSONRows(ctx, newRow, NoStreamOffset); err == nil {
+		t.Fatal("AppendJSONRows: got nil error for a row with an unknown column")
+	}
+	res, err := ms.AppendJSONRows(ctx, [][]byte{[]byte(`{"name": "a"}`)}, NoStreamOffset)
+	if err != nil {
+		t.Fatalf("AppendJSONRows: %v", err)
+	}
+	updated, err := res.UpdatedSchema(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(updated, newSchema) {
+		t.Errorf("got updated schema %v, want %v", updated, newSchema)
+	}
+	for i := 0; i < 2; i++ {
+		res, err = ms.AppendJSONRows(ctx, newRow, NoStreamOffset)
+		if err != nil {
+			t.Fatalf("AppendJSONRows after the update: %v", err)
+		}
+		if updated, _ := res.UpdatedSchema(ctx); updated != nil {
+			t.Errorf("got updated schema %v on the new connection", updated)
+		}
+	}
+
+	if testARC.openCount != 2 {
+		t.Errorf("got %d opens, want 2", testARC.openCount)
+	}
+	var got []int
+	for _, req := range testARC.requests {
+		got = append(got, len(req.GetProtoRows().GetWriterSchema().GetProtoDescriptor().GetField()))
+	}
+	if want := []int{1, 2, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got writer schema field counts %v, want %v", got, want)
+	}
+}
+
+func TestManagedStream_SchemaUpdateWithoutLock(t *testing.T) {
+	ctx := context.Background()
+	schema := &storagepb.TableSchema{
+		Fields: []*storagepb.TableFieldSchema{
+			{Name: "name", Type: storagepb.TableFieldSchema_STRING, Mode: storagepb.TableFieldSchema_NULLABLE},
+		},
+	}
+	testARC := &testAppendRowsClient{
+		recvF: func() (*storagepb.AppendRowsResponse, error) {
+			return &storagepb.AppendRowsResponse{
+				Response:      &storagepb.AppendRowsResponse_AppendResult_{},
+				UpdatedSchema: schema,
+			}, nil
+		},
+	}
+	ms := &ManagedStream{
+		ctx:               ctx,
+		fc:                newFlowController(0, 0),
+		jsonRows:          true,
+		autoSchemaUpdates: true,
+	}
+
+	// A schema update is acknowledged while the mutex is held, such as by a
+	// connection being opened.
+	ms.mu.Lock()
+	ch := make(chan *pendingWrite, 1)
+	pw := newPendingWrite([][]byte{[]byte("row")}, NoStreamOffset)
+	ch <- pw
+	close(ch)
+	go recvProcessor(ctx, testARC, ms.fc, ch, ms.schemaUpdated)
+	select {
+	case <-pw.result.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the append was not acknowledged while the mutex was held")
+	}
+	ms.mu.Unlock()
+
+	if got, _ := ms.updatedSchema.Load().(*storagepb.TableSchema); got != schema {
+		t.Errorf("got updated schema %v, want %v", got, schema)
+	}
+}
+
 type testAppendRowsClient struct {
 	storagepb.BigQueryWrite_AppendRowsClient
-	openCount int
-	requests  []*storagepb.AppendRowsRequest
-	sendF     func(*storagepb.AppendRowsRequest) error
-	recvF     func() (*storagepb.AppendRowsResponse, error)
+	openCount  int
+	closeCount int
+	requests   []*storagepb.AppendRowsRequest
+	sendF      func(*storagepb.AppendRowsRequest) error
+	recvF      func() (*storagepb.AppendRowsResponse, error)
+}
+
+func (tarc *testAppendRowsClient) CloseSend() error {
+	tarc.closeCount = tarc.closeCount + 1
+	return nil
 }
 
 func (tarc *testAppendRowsClient) Send(req *storagepb.AppendRowsRequest) error {
@@ -0,0 +1,545 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package managedwriter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"cloud.google.com/go/civil"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1beta2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// RowConversionError reports a row that could not be converted into the
+// message format of a stream.
+type RowConversionError struct {
+	// RowIndex is the index of the row in the appended rows.
+	RowIndex int
+	Err      error
+}
+
+func (e *RowConversionError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.RowIndex, e.Err)
+}
+
+// RowConversionErrors contains an error for each row of an append that could
+// not be converted. When any row of an append fails to convert, none of its
+// rows are appended.
+type RowConversionErrors []*RowConversionError
+
+func (e RowConversionErrors) Error() string {
+	switch len(e) {
+	case 0:
+		return "(0 errors)"
+	case 1:
+		return e[0].Error()
+	case 2:
+		return e[0].Error() + " (and 1 other error)"
+	}
+	return fmt.Sprintf("%s (and %d other errors)", e[0].Error(), len(e)-1)
+}
+
+// rowConverter converts rows into serialized messages of a proto2 descriptor
+// built from a table schema.
+type rowConverter struct {
+	schema *storagepb.TableSchema
+	md     protoreflect.MessageDescriptor
+}
+
+// newRowConverter returns a converter for rows of a table schema, along with
+// the normalized descriptor of its messages.
+func newRowConverter(schema *storagepb.TableSchema) (*rowConverter, *descriptorpb.DescriptorProto, error) {
+	d, err := adapt.StorageSchemaToProto2Descriptor(schema, "root")
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't build descriptor from table schema: %v", err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("descriptor built from table schema is a %T, not a message descriptor", d)
+	}
+	dp, err := adapt.NormalizeDescriptor(md)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't normalize descriptor: %v", err)
+	}
+	return &rowConverter{schema: schema, md: md}, dp, nil
+}
+
+// convertRows converts each row with conv, and reports every row that fails.
+func convertRows(n int, conv func(i int) ([]byte, error)) ([][]byte, error) {
+	data := make([][]byte, n)
+	var errs RowConversionErrors
+	for i := 0; i < n; i++ {
+		b, err := conv(i)
+		if err != nil {
+			errs = append(errs, &RowConversionError{RowIndex: i, Err: err})
+			continue
+		}
+		data[i] = b
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return data, nil
+}
+
+// convertJSON converts a row given as a JSON object.
+func (rc *rowConverter) convertJSON(data []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var row map[string]interface{}
+	if err := d.Decode(&row); err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, fmt.Errorf("row is not a JSON object")
+	}
+	return rc.convertMap(row)
+}
+
+// convertValueSaver converts the row saved by a ValueSaver.
+func (rc *rowConverter) convertValueSaver(vs bigquery.ValueSaver) ([]byte, error) {
+	row, _, err := vs.Save()
+	if err != nil {
+		return nil, err
+	}
+	return rc.convertMap(row)
+}
+
+// convertMap converts a row given as a map from column names to values.
+func (rc *rowConverter) convertMap(row interface{}) ([]byte, error) {
+	m := dynamicpb.NewMessage(rc.md)
+	if err := setFields(m, rc.schema.GetFields(), row, ""); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+// setFields sets the fields of m from the values of row, which is a
+// map[string]interface{} or a map[string]bigquery.Value. Column names are
+// matched without regard to case. Path is the column of m, for errors; it is
+// empty for the row itself.
+func setFields(m protoreflect.Message, fields []*storagepb.TableFieldSchema, row interface{}, path string) error {
+	prefix := ""
+	if path != "" {
+		prefix = path + "."
+	}
+	var vals map[string]interface{}
+	switch r := row.(type) {
+	case map[string]interface{}:
+		vals = r
+	case map[string]bigquery.Value:
+		vals = make(map[string]interface{}, len(r))
+		for k, v := range r {
+			vals[k] = v
+		}
+	default:
+		if path == "" {
+			return fmt.Errorf("row of type %T is not a map", row)
+		}
+		return fmt.Errorf("%s: value of type %T is not a map", path, row)
+	}
+	for name, v := range vals {
+		var f *storagepb.TableFieldSchema
+		for _, sf := range fields {
+			if strings.EqualFold(sf.GetName(), name) {
+				f = sf
+				break
+			}
+		}
+		if f == nil {
+			return fmt.Errorf("%s%s: no such column", prefix, name)
+		}
+		fpath := prefix + f.GetName()
+		v = unwrapNull(v)
+		if v == nil {
+			if f.GetMode() == storagepb.TableFieldSchema_REQUIRED {
+				return fmt.Errorf("%s: NULL value for REQUIRED column", fpath)
+			}
+			continue
+		}
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(strings.ToLower(f.GetName())))
+		if f.GetMode() != storagepb.TableFieldSchema_REPEATED {
+			pv, err := fieldValue(fd, f, v, fpath)
+			if err != nil {
+				return err
+			}
+			m.Set(fd, pv)
+			continue
+		}
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return fmt.Errorf("%s: value of type %T for REPEATED column is not a slice", fpath, v)
+		}
+		list := m.Mutable(fd).List()
+		for i := 0; i < rv.Len(); i++ {
+			ev := unwrapNull(rv.Index(i).Interface())
+			epath := fmt.Sprintf("%s[%d]", fpath, i)
+			if ev == nil {
+				return fmt.Errorf("%s: NULL element in REPEATED column", epath)
+			}
+			pv, err := fieldValue(fd, f, ev, epath)
+			if err != nil {
+				return err
+			}
+			list.Append(pv)
+		}
+	}
+	// Report missing REQUIRED columns here, rather than when marshaling.
+	for _, f := range fields {
+		if f.GetMode() != storagepb.TableFieldSchema_REQUIRED {
+			continue
+		}
+		if !m.Has(m.Descriptor().Fields().ByName(protoreflect.Name(strings.ToLower(f.GetName())))) {
+			return fmt.Errorf("%s%s: missing value for REQUIRED column", prefix, f.GetName())
+		}
+	}
+	return nil
+}
+
+// fieldValue converts a single, non-NULL value of a column.
+func fieldValue(fd protoreflect.FieldDescriptor, f *storagepb.TableFieldSchema, v interface{}, path string) (protoreflect.Value, error) {
+	if f.GetType() == storagepb.TableFieldSchema_STRUCT {
+		sub := dynamicpb.NewMessage(fd.Message())
+		if err := setFields(sub, f.GetFields(), v, path); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfMessage(sub), nil
+	}
+	pv, err := scalarValue(f.GetType(), v)
+	if err != nil {
+		return protoreflect.Value{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return pv, nil
+}
+
+// unwrapNull returns the value of a bigquery Null type, or nil if it is not
+// valid. Other values are returned unchanged.
+func unwrapNull(v interface{}) interface{} {
+	var valid bool
+	var val interface{}
+	switch n := v.(type) {
+	case bigquery.NullInt64:
+		valid, val = n.Valid, n.Int64
+	case bigquery.NullString:
+		valid, val = n.Valid, n.StringVal
+	case bigquery.NullGeography:
+		valid, val = n.Valid, n.GeographyVal
+	case bigquery.NullFloat64:
+		valid, val = n.Valid, n.Float64
+	case bigquery.NullBool:
+		valid, val = n.Valid, n.Bool
+	case bigquery.NullTimestamp:
+		valid, val = n.Valid, n.Timestamp
+	case bigquery.NullDate:
+		valid, val = n.Valid, n.Date
+	case bigquery.NullTime:
+		valid, val = n.Valid, n.Time
+	case bigquery.NullDateTime:
+		valid, val = n.Valid, n.DateTime
+	case *big.Rat:
+		if n == nil {
+			return nil
+		}
+		return v
+	case *bigquery.IntervalValue:
+		if n == nil {
+			return nil
+		}
+		return v
+	case json.RawMessage:
+		if n == nil {
+			return nil
+		}
+		return v
+	default:
+		return v
+	}
+	if !valid {
+		return nil
+	}
+	return val
+}
+
+// scalarValue converts v into the protocol buffer encoding of a column type.
+//
+// Besides the Go type of the column, strings in the formats used by the
+// BigQuery API and JSON numbers are accepted for every type. Integers are
+// accepted as microseconds since the epoch for TIMESTAMP, and as days since
+// the epoch for DATE.
+func scalarValue(typ storagepb.TableFieldSchema_Type, v interface{}) (protoreflect.Value, error) {
+	switch typ {
+	case storagepb.TableFieldSchema_INT64:
+		n, err := toInt64(v)
+		return protoreflect.ValueOfInt64(n), err
+	case storagepb.TableFieldSchema_DOUBLE:
+		f, err := toFloat64(v)
+		return protoreflect.ValueOfFloat64(f), err
+	case storagepb.TableFieldSchema_BOOL:
+		switch b := v.(type) {
+		case bool:
+			return protoreflect.ValueOfBool(b), nil
+		case string:
+			pb, err := strconv.ParseBool(b)
+			return protoreflect.ValueOfBool(pb), err
+		}
+	case storagepb.TableFieldSchema_STRING, storagepb.TableFieldSchema_GEOGRAPHY:
+		if s, ok := v.(string); ok {
+			return protoreflect.ValueOfString(s), nil
+		}
+	case storagepb.TableFieldSchema_JSON:
+		switch j := v.(type) {
+		case string:
+			return protoreflect.ValueOfString(j), nil
+		case json.RawMessage:
+			return protoreflect.ValueOfString(string(j)), nil
+		}
+	case storagepb.TableFieldSchema_INTERVAL:
+		switch iv := v.(type) {
+		case string:
+			return protoreflect.ValueOfString(iv), nil
+		case *bigquery.IntervalValue:
+			return protoreflect.ValueOfString(iv.String()), nil
+		}
+	case storagepb.TableFieldSchema_BYTES:
+		switch b := v.(type) {
+		case []byte:
+			return protoreflect.ValueOfBytes(b), nil
+		case string:
+			// Bytes are base64-encoded in JSON.
+			db, err := base64.StdEncoding.DecodeString(b)
+			return protoreflect.ValueOfBytes(db), err
+		}
+	case storagepb.TableFieldSchema_TIMESTAMP:
+		switch t := v.(type) {
+		case time.Time:
+			return protoreflect.ValueOfInt64(timestampMicros(t)), nil
+		case string:
+			pt, err := parseTimestamp(t)
+			return protoreflect.ValueOfInt64(timestampMicros(pt)), err
+		default:
+			n, err := toInt64(v)
+			return protoreflect.ValueOfInt64(n), err
+		}
+	case storagepb.TableFieldSchema_DATE:
+		var d civil.Date
+		switch t := v.(type) {
+		case civil.Date:
+			d = t
+		case time.Time:
+			d = civil.DateOf(t)
+		case string:
+			var err error
+			if d, err = civil.ParseDate(t); err != nil {
+				return protoreflect.Value{}, err
+			}
+		default:
+			n, err := toInt64(v)
+			if err == nil && (n < math.MinInt32 || n > math.MaxInt32) {
+				err = fmt.Errorf("%d days is out of range", n)
+			}
+			return protoreflect.ValueOfInt32(int32(n)), err
+		}
+		return protoreflect.ValueOfInt32(int32(d.DaysSince(civil.Date{Year: 1970, Month: 1, Day: 1}))), nil
+	case storagepb.TableFieldSchema_TIME:
+		switch t := v.(type) {
+		case civil.Time:
+			return protoreflect.ValueOfInt64(packedTimeMicros(t)), nil
+		case string:
+			pt, err := civil.ParseTime(t)
+			return protoreflect.ValueOfInt64(packedTimeMicros(pt)), err
+		}
+	case storagepb.TableFieldSchema_DATETIME:
+		switch t := v.(type) {
+		case civil.DateTime:
+			return protoreflect.ValueOfInt64(packedDateTimeMicros(t)), nil
+		case time.Time:
+			return protoreflect.ValueOfInt64(packedDateTimeMicros(civil.DateTimeOf(t))), nil
+		case string:
+			// The BigQuery API separates the date and time with a space.
+			pt, err := civil.ParseDateTime(strings.Replace(t, " ", "T", 1))
+			return protoreflect.ValueOfInt64(packedDateTimeMicros(pt)), err
+		}
+	case storagepb.TableFieldSchema_NUMERIC:
+		b, err := numericBytes(v, bigquery.NumericScaleDigits, 16)
+		return protoreflect.ValueOfBytes(b), err
+	case storagepb.TableFieldSchema_BIGNUMERIC:
+		b, err := numericBytes(v, bigquery.BigNumericScaleDigits, 32)
+		return protoreflect.ValueOfBytes(b), err
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported column type %s", typ)
+	}
+	return protoreflect.Value{}, fmt.Errorf("cannot convert value of type %T to %s", v, typ)
+}
+
+// toInt64 converts integers, integral floats and strings (including
+// json.Number) into an int64.
+func toInt64(v interface{}) (int64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("%d overflows INT64", rv.Uint())
+		}
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, fmt.Errorf("%v is not an INT64", f)
+		}
+		return int64(f), nil
+	case reflect.String:
+		return strconv.ParseInt(rv.String(), 10, 64)
+	}
+	return 0, fmt.Errorf("cannot convert value of type %T to INT64", v)
+}
+
+// toFloat64 converts numbers and strings (including json.Number) into a
+// float64.
+func toFloat64(v interface{}) (float64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return strconv.ParseFloat(rv.String(), 64)
+	}
+	return 0, fmt.Errorf("cannot convert value of type %T to DOUBLE", v)
+}
+
+// timestampLayouts are the accepted formats of TIMESTAMP strings. Strings
+// without a zone are in UTC.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 MST",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a TIMESTAMP", s)
+}
+
+func timestampMicros(t time.Time) int64 {
+	return t.Unix()*1e6 + int64(t.Nanosecond()/1e3)
+}
+
+// packedTimeMicros encodes a TIME as hour, minute, second and microsecond
+// bit fields, the format of TIME columns in the Storage Write API.
+func packedTimeMicros(t civil.Time) int64 {
+	secs := int64(t.Hour)<<12 | int64(t.Minute)<<6 | int64(t.Second)
+	return secs<<20 | int64(t.Nanosecond/1e3)
+}
+
+// packedDateTimeMicros encodes a DATETIME as year, month, day, hour, minute,
+// second and microsecond bit fields, the format of DATETIME columns in the
+// Storage Write API.
+func packedDateTimeMicros(dt civil.DateTime) int64 {
+	secs := int64(dt.Date.Year)<<26 | int64(dt.Date.Month)<<22 | int64(dt.Date.Day)<<17 |
+		int64(dt.Time.Hour)<<12 | int64(dt.Time.Minute)<<6 | int64(dt.Time.Second)
+	return secs<<20 | int64(dt.Time.Nanosecond/1e3)
+}
+
+// numericBytes encodes a NUMERIC or BIGNUMERIC value with the given scale as
+// the little-endian two's complement of its unscaled value, in the fewest
+// bytes. The unscaled value must fit in size bytes.
+func numericBytes(v interface{}, scale, size int) ([]byte, error) {
+	var r *big.Rat
+	switch n := v.(type) {
+	case *big.Rat:
+		r = n
+	case big.Rat:
+		r = &n
+	case string:
+		var ok bool
+		if r, ok = new(big.Rat).SetString(n); !ok {
+			return nil, fmt.Errorf("cannot parse %q as a number", n)
+		}
+	case json.Number:
+		var ok bool
+		if r, ok = new(big.Rat).SetString(string(n)); !ok {
+			return nil, fmt.Errorf("cannot parse %q as a number", n)
+		}
+	case float32, float64:
+		f, _ := toFloat64(n)
+		if r = new(big.Rat).SetFloat64(f); r == nil {
+			return nil, fmt.Errorf("%v is not a finite number", f)
+		}
+		// Round to the scale, since binary fractions rarely have exact
+		// decimal values.
+		if r, _ = new(big.Rat).SetString(r.FloatString(scale)); r == nil {
+			return nil, fmt.Errorf("cannot convert %v", f)
+		}
+	default:
+		i, err := toInt64(v)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert value of type %T to a number", v)
+		}
+		r = new(big.Rat).SetInt64(i)
+	}
+	u := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	if !u.IsInt() {
+		return nil, fmt.Errorf("%s has more than %d digits after the decimal point", r.FloatString(scale+1), scale)
+	}
+	n := u.Num()
+	// Big-endian two's complement, with at least one sign bit.
+	var b []byte
+	if n.Sign() >= 0 {
+		b = n.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+	} else {
+		b = new(big.Int).Sub(new(big.Int).Neg(n), big.NewInt(1)).Bytes()
+		for i := range b {
+			b[i] = ^b[i]
+		}
+		if len(b) == 0 || b[0]&0x80 == 0 {
+			b = append([]byte{0xff}, b...)
+		}
+	}
+	if len(b) > size {
+		return nil, fmt.Errorf("%s is out of range", r.FloatString(scale))
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b, nil
+}
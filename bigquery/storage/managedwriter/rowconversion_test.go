@@ -0,0 +1,500 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package managedwriter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	storage "cloud.google.com/go/bigquery/storage/apiv1beta2"
+	"cloud.google.com/go/civil"
+	"google.golang.org/api/option"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1beta2"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestScalarValue(t *testing.T) {
+	ts := time.Date(2021, 9, 1, 12, 30, 0, 123456000, time.UTC)
+	tsMicros := ts.Unix()*1e6 + 123456
+	// 12:30:00.123456 and 2021-09-01 12:30:00.123456, packed.
+	timeMicros := int64(12<<12|30<<6)<<20 | 123456
+	dateTimeMicros := int64(2021<<26|9<<22|1<<17|12<<12|30<<6)<<20 | 123456
+
+	testCases := []struct {
+		typ     storagepb.TableFieldSchema_Type
+		in      interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{typ: storagepb.TableFieldSchema_INT64, in: 7, want: int64(7)},
+		{typ: storagepb.TableFieldSchema_INT64, in: uint8(7), want: int64(7)},
+		{typ: storagepb.TableFieldSchema_INT64, in: float64(7), want: int64(7)},
+		{typ: storagepb.TableFieldSchema_INT64, in: json.Number("-7"), want: int64(-7)},
+		{typ: storagepb.TableFieldSchema_INT64, in: "7", want: int64(7)},
+		{typ: storagepb.TableFieldSchema_INT64, in: 7.5, wantErr: true},
+		{typ: storagepb.TableFieldSchema_INT64, in: uint64(1 << 63), wantErr: true},
+		{typ: storagepb.TableFieldSchema_INT64, in: true, wantErr: true},
+		{typ: storagepb.TableFieldSchema_DOUBLE, in: 1.5, want: 1.5},
+		{typ: storagepb.TableFieldSchema_DOUBLE, in: json.Number("1.5"), want: 1.5},
+		{typ: storagepb.TableFieldSchema_DOUBLE, in: 2, want: 2.0},
+		{typ: storagepb.TableFieldSchema_BOOL, in: true, want: true},
+		{typ: storagepb.TableFieldSchema_BOOL, in: "false", want: false},
+		{typ: storagepb.TableFieldSchema_BOOL, in: 1, wantErr: true},
+		{typ: storagepb.TableFieldSchema_STRING, in: "s", want: "s"},
+		{typ: storagepb.TableFieldSchema_STRING, in: 1, wantErr: true},
+		{typ: storagepb.TableFieldSchema_GEOGRAPHY, in: "POINT(1 2)", want: "POINT(1 2)"},
+		{typ: storagepb.TableFieldSchema_JSON, in: `{"a": 1}`, want: `{"a": 1}`},
+		{typ: storagepb.TableFieldSchema_JSON, in: json.RawMessage(`[1, 2]`), want: `[1, 2]`},
+		{typ: storagepb.TableFieldSchema_JSON, in: 1, wantErr: true},
+		{typ: storagepb.TableFieldSchema_INTERVAL, in: "1-2 3 4:5:6.5", want: "1-2 3 4:5:6.5"},
+		{typ: storagepb.TableFieldSchema_INTERVAL, in: &bigquery.IntervalValue{Years: 1, Months: 2, Days: 3}, want: "1-2 3 0:0:0"},
+		{typ: storagepb.TableFieldSchema_INTERVAL, in: time.Second, wantErr: true},
+		{typ: storagepb.TableFieldSchema_BYTES, in: []byte{1, 2}, want: []byte{1, 2}},
+		{typ: storagepb.TableFieldSchema_BYTES, in: "AQI=", want: []byte{1, 2}},
+		{typ: storagepb.TableFieldSchema_BYTES, in: "!", wantErr: true},
+		{typ: storagepb.TableFieldSchema_TIMESTAMP, in: ts, want: tsMicros},
+		{typ: storagepb.TableFieldSchema_TIMESTAMP, in: "2021-09-01T12:30:00.123456Z", want: tsMicros},
+		{typ: storagepb.TableFieldSchema_TIMESTAMP, in: "2021-09-01 14:30:00.123456+02:00", want: tsMicros},
+		{typ: storagepb.TableFieldSchema_TIMESTAMP, in: "2021-09-01 12:30:00.123456 UTC", want: tsMicros},
+		{typ: storagepb.TableFieldSchema_TIMESTAMP, in: "2021-09-01 12:30:00.123456", want: tsMicros},
+		{typ: storagepb.TableFieldSchema_TIMESTAMP, in: json.Number("1"), want: int64(1)},
+		{typ: storagepb.TableFieldSchema_TIMESTAMP, in: "yesterday", wantErr: true},
+		{typ: storagepb.TableFieldSchema_DATE, in: civil.Date{Year: 1970, Month: 1, Day: 11}, want: int32(10)},
+		{typ: storagepb.TableFieldSchema_DATE, in: "1969-12-31", want: int32(-1)},
+		{typ: storagepb.TableFieldSchema_DATE, in: ts, want: int32(18871)},
+		{typ: storagepb.TableFieldSchema_DATE, in: 3, want: int32(3)},
+		{typ: storagepb.TableFieldSchema_DATE, in: "2021-02-30", wantErr: true},
+		{typ: storagepb.TableFieldSchema_TIME, in: civil.TimeOf(ts), want: timeMicros},
+		{typ: storagepb.TableFieldSchema_TIME, in: "12:30:00.123456", want: timeMicros},
+		{typ: storagepb.TableFieldSchema_TIME, in: 1, wantErr: true},
+		{typ: storagepb.TableFieldSchema_DATETIME, in: civil.DateTimeOf(ts), want: dateTimeMicros},
+		{typ: storagepb.TableFieldSchema_DATETIME, in: ts, want: dateTimeMicros},
+		{typ: storagepb.TableFieldSchema_DATETIME, in: "2021-09-01 12:30:00.123456", want: dateTimeMicros},
+		{typ: storagepb.TableFieldSchema_DATETIME, in: "2021-09-01T12:30:00.123456", want: dateTimeMicros},
+		{typ: storagepb.TableFieldSchema_NUMERIC, in: "1", want: []byte{0x00, 0xca, 0x9a, 0x3b}},
+		{typ: storagepb.TableFieldSchema_BIGNUMERIC, in: 0, want: []byte{0}},
+	}
+	for _, tc := range testCases {
+		got, err := scalarValue(tc.typ, tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s from %T %v: got %v, want error", tc.typ, tc.in, tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s from %T %v: %v", tc.typ, tc.in, tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Interface(), tc.want) {
+			t.Errorf("%s from %T %v: got %v, want %v", tc.typ, tc.in, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNumericBytes(t *testing.T) {
+	testCases := []struct {
+		in      interface{}
+		scale   int
+		size    int
+		want    []byte
+		wantErr bool
+	}{
+		{in: big.NewRat(0, 1), scale: 9, size: 16, want: []byte{0}},
+		{in: *big.NewRat(-1, 1000000000), scale: 9, size: 16, want: []byte{0xff}},
+		{in: "-0.000000128", scale: 9, size: 16, want: []byte{0x80}},
+		{in: "0.000000128", scale: 9, size: 16, want: []byte{0x80, 0x00}},
+		{in: json.Number("-1.5"), scale: 9, size: 16, want: []byte{0x00, 0xd1, 0x97, 0xa6}},
+		{in: 0.1, scale: 9, size: 16, want: []byte{0x00, 0xe1, 0xf5, 0x05}},
+		{in: int64(2), scale: 38, size: 32, want: []byte{0, 0, 0, 0, 0x80, 0x44, 0x14, 0x13, 0xf4, 0x88, 0x0d, 0xb5, 0x50, 0x99, 0x76, 0x96, 0x00}},
+		{in: "0.0000000001", scale: 9, size: 16, wantErr: true},
+		{in: "1e30", scale: 9, size: 16, wantErr: true},
+		{in: "x", scale: 9, size: 16, wantErr: true},
+		{in: true, scale: 9, size: 16, wantErr: true},
+	}
+	for _, tc := range testCases {
+		got, err := numericBytes(tc.in, tc.scale, tc.size)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%v: got % x, want error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tc.in, err)
+			continue
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("%v: got % x, want % x", tc.in, got, tc.want)
+		}
+	}
+}
+
+var testConversionSchema = &storagepb.TableSchema{
+	Fields: []*storagepb.TableFieldSchema{
+		{Name: "Name", Type: storagepb.TableFieldSchema_STRING, Mode: storagepb.TableFieldSchema_REQUIRED},
+		{Name: "value", Type: storagepb.TableFieldSchema_INT64, Mode: storagepb.TableFieldSchema_NULLABLE},
+		{Name: "tags", Type: storagepb.TableFieldSchema_STRING, Mode: storagepb.TableFieldSchema_REPEATED},
+		{Name: "price", Type: storagepb.TableFieldSchema_NUMERIC, Mode: storagepb.TableFieldSchema_NULLABLE},
+		{Name: "day", Type: storagepb.TableFieldSchema_DATE, Mode: storagepb.TableFieldSchema_NULLABLE},
+		{Name: "items", Type: storagepb.TableFieldSchema_STRUCT, Mode: storagepb.TableFieldSchema_REPEATED, Fields: []*storagepb.TableFieldSchema{
+			{Name: "id", Type: storagepb.TableFieldSchema_INT64, Mode: storagepb.TableFieldSchema_REQUIRED},
+			{Name: "at", Type: storagepb.TableFieldSchema_TIMESTAMP, Mode: storagepb.TableFieldSchema_NULLABLE},
+		}},
+	},
+}
+
+func TestRowConverter(t *testing.T) {
+	rc, dp, err := newRowConverter(testConversionSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dp.GetField()) != 6 || len(dp.GetNestedType()) != 1 {
+		t.Errorf("got descriptor %v", dp)
+	}
+
+	// The same row in every accepted form, with its expected message in
+	// protojson form.
+	want := dynamicpb.NewMessage(rc.md)
+	if err := protojson.Unmarshal([]byte(`{"name": "a", "tags": ["x", "y"], "price": "AGXNHQ==",
+		"day": 1, "items": [{"id": 1, "at": "1000000"}, {"id": 2}]}`), want); err != nil {
+		t.Fatal(err)
+	}
+	jsonRow := []byte(`{"NAME": "a", "value": null, "tags": ["x", "y"], "price": "0.5", "day": "1970-01-02",
+		"items": [{"id": 1, "at": "1970-01-01T00:00:01Z"}, {"id": "2"}]}`)
+	mapRow := map[string]interface{}{
+		"name":  "a",
+		"tags":  []string{"x", "y"},
+		"price": big.NewRat(1, 2),
+		"day":   civil.Date{Year: 1970, Month: 1, Day: 2},
+		"items": []interface{}{
+			map[string]interface{}{"id": 1, "at": time.Unix(1, 0)},
+			map[string]bigquery.Value{"id": int64(2), "at": nil},
+		},
+	}
+	type item struct {
+		ID int64
+		At bigquery.NullTimestamp
+	}
+	type row struct {
+		Name  string
+		Value bigquery.NullInt64
+		Tags  []string
+		Price *big.Rat
+		Day   civil.Date
+		Items []item
+	}
+	bqSchema, err := bigquery.InferSchema(row{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saver := &bigquery.StructSaver{
+		Schema: bqSchema,
+		Struct: row{
+			Name:  "a",
+			Tags:  []string{"x", "y"},
+			Price: big.NewRat(1, 2),
+			Day:   civil.Date{Year: 1970, Month: 1, Day: 2},
+			Items: []item{{1, bigquery.NullTimestamp{Timestamp: time.Unix(1, 0), Valid: true}}, {ID: 2}},
+		},
+	}
+
+	for _, tc := range []struct {
+		desc    string
+		convert func() ([]byte, error)
+	}{
+		{"json", func() ([]byte, error) { return rc.convertJSON(jsonRow) }},
+		{"map", func() ([]byte, error) { return rc.convertMap(mapRow) }},
+		{"value saver", func() ([]byte, error) { return rc.convertValueSaver(saver) }},
+	} {
+		b, err := tc.convert()
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		got := dynamicpb.NewMessage(rc.md)
+		if err := proto.Unmarshal(b, got); err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if !proto.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", tc.desc, got, want)
+		}
+	}
+}
+
+func TestRowConverterJSONAndInterval(t *testing.T) {
+	rc, dp, err := newRowConverter(&storagepb.TableSchema{
+		Fields: []*storagepb.TableFieldSchema{
+			{Name: "doc", Type: storagepb.TableFieldSchema_JSON, Mode: storagepb.TableFieldSchema_NULLABLE},
+			{Name: "span", Type: storagepb.TableFieldSchema_INTERVAL, Mode: storagepb.TableFieldSchema_NULLABLE},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range dp.GetField() {
+		if f.GetType() != descriptorpb.FieldDescriptorProto_TYPE_STRING {
+			t.Errorf("field %s: got type %s, want STRING", f.GetName(), f.GetType())
+		}
+	}
+	for _, tc := range []struct {
+		desc string
+		row  map[string]interface{}
+		want string
+	}{
+		{
+			desc: "values",
+			row:  map[string]interface{}{"doc": json.RawMessage(`{"a": 1}`), "span": &bigquery.IntervalValue{Hours: 1}},
+			want: `{"doc": "{\"a\": 1}", "span": "0-0 0 1:0:0"}`,
+		},
+		{
+			desc: "nulls",
+			row:  map[string]interface{}{"doc": json.RawMessage(nil), "span": (*bigquery.IntervalValue)(nil)},
+			want: `{}`,
+		},
+	} {
+		b, err := rc.convertMap(tc.row)
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		got := dynamicpb.NewMessage(rc.md)
+		if err := proto.Unmarshal(b, got); err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		want := dynamicpb.NewMessage(rc.md)
+		if err := protojson.Unmarshal([]byte(tc.want), want); err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", tc.desc, got, want)
+		}
+	}
+}
+
+func TestRowConversionErrors(t *testing.T) {
+	rc, _, err := newRowConverter(testConversionSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []map[string]interface{}{
+		{"name": "ok"},
+		{"value": 1},
+		{"name": "a", "missing": 1},
+		{"name": nil},
+		{"name": "a", "tags": "x"},
+		{"name": "a", "tags": []interface{}{"x", nil}},
+		{"name": "a", "items": []interface{}{map[string]interface{}{"id": "x"}}},
+		{"name": "a", "items": []interface{}{"x"}},
+		{"name": "a", "price": "0.0000000001"},
+	}
+	_, err = convertRows(len(rows), func(i int) ([]byte, error) {
+		return rc.convertMap(rows[i])
+	})
+	errs, ok := err.(RowConversionErrors)
+	if !ok {
+		t.Fatalf("got error %v, want RowConversionErrors", err)
+	}
+	want := []string{
+		"row 1: Name: missing value for REQUIRED column",
+		"row 2: missing: no such column",
+		"row 3: Name: NULL value for REQUIRED column",
+		"row 4: tags: value of type string for REPEATED column is not a slice",
+		"row 5: tags[1]: NULL element in REPEATED column",
+		`row 6: items[0].id: strconv.ParseInt: parsing "x": invalid syntax`,
+		"row 7: items[0]: value of type string is not a map",
+		"row 8: price: 0.0000000001 has more than 9 digits after the decimal point",
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !strings.HasSuffix(err.Error(), "(and 7 other errors)") {
+		t.Errorf("got %q", err)
+	}
+
+	if _, err := rc.convertJSON([]byte(`[1]`)); err == nil {
+		t.Error("got nil error for a JSON array")
+	}
+	if _, err := rc.convertJSON([]byte(`null`)); err == nil {
+		t.Error("got nil error for a JSON null")
+	}
+}
+
+// fakeWriteServer serves write streams of a table, and records the append
+// requests it receives.
+type fakeWriteServer struct {
+	storagepb.UnimplementedBigQueryWriteServer
+
+	mu       sync.Mutex
+	schema   *storagepb.TableSchema
+	requests []*storagepb.AppendRowsRequest
+	lookups  int // calls to GetWriteStream
+}
+
+func (s *fakeWriteServer) CreateWriteStream(ctx context.Context, req *storagepb.CreateWriteStreamRequest) (*storagepb.WriteStream, error) {
+	return &storagepb.WriteStream{
+		Name:        req.GetParent() + "/streams/s1",
+		Type:        req.GetWriteStream().GetType(),
+		TableSchema: s.schema,
+	}, nil
+}
+
+// GetWriteStream serves the default stream of a table, whose schema has only
+// the name column, and any other stream as a committed stream.
+func (s *fakeWriteServer) GetWriteStream(ctx context.Context, req *storagepb.GetWriteStreamRequest) (*storagepb.WriteStream, error) {
+	s.mu.Lock()
+	s.lookups++
+	s.mu.Unlock()
+	if strings.HasSuffix(req.GetName(), "/_default") {
+		return &storagepb.WriteStream{
+			Name: req.GetName(),
+			Type: storagepb.WriteStream_COMMITTED,
+			TableSchema: &storagepb.TableSchema{Fields: []*storagepb.TableFieldSchema{
+				{Name: "name", Type: storagepb.TableFieldSchema_STRING, Mode: storagepb.TableFieldSchema_REQUIRED},
+			}},
+		}, nil
+	}
+	return &storagepb.WriteStream{
+		Name:        req.GetName(),
+		Type:        storagepb.WriteStream_COMMITTED,
+		TableSchema: s.schema,
+	}, nil
+}
+
+func (s *fakeWriteServer) AppendRows(stream storagepb.BigQueryWrite_AppendRowsServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+		if err := stream.Send(&storagepb.AppendRowsResponse{
+			Response: &storagepb.AppendRowsResponse_AppendResult_{AppendResult: &storagepb.AppendRowsResponse_AppendResult{}},
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestJSONRowsStream(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeWriteServer{schema: testConversionSchema}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gsrv := grpc.NewServer()
+	storagepb.RegisterBigQueryWriteServer(gsrv, fake)
+	go gsrv.Serve(lis)
+	defer gsrv.Stop()
+
+	raw, err := storage.NewBigQueryWriteClient(ctx,
+		option.WithEndpoint(lis.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{
+		rawClient: raw,
+		projectID: "p",
+	}
+	defer c.Close()
+
+	for _, tc := range []struct {
+		desc        string
+		streamType  StreamType
+		opts        []WriterOption
+		row         string
+		wantFields  int
+		wantLookups int
+	}{
+		{"committed stream", CommittedStream, nil, `{"name": "a", "items": [{"id": 1}]}`, 6, 0},
+		{"default stream", DefaultStream, nil, `{"name": "a"}`, 1, 1},
+		{"default stream with schema", DefaultStream, []WriterOption{WithTableSchema(testConversionSchema)}, `{"name": "a", "value": 3}`, 6, 0},
+		{"named stream", CommittedStream, []WriterOption{WithStreamName("projects/p/datasets/d/tables/t/streams/s2")}, `{"name": "a", "value": 3}`, 6, 1},
+	} {
+		fake.lookups = 0
+		fake.requests = nil
+		opts := append([]WriterOption{
+			WithType(tc.streamType),
+			WithDestinationTable("projects/p/datasets/d/tables/t"),
+			WithJSONRows(),
+		}, tc.opts...)
+		ms, err := c.NewManagedStream(ctx, opts...)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		if _, err := ms.AppendJSONRows(ctx, [][]byte{[]byte(`{"name": 1}`)}, NoStreamOffset); err == nil {
+			t.Errorf("%s: got nil error for a bad row", tc.desc)
+		}
+		res, err := ms.AppendJSONRows(ctx, [][]byte{[]byte(tc.row)}, NoStreamOffset)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		if _, err := res.GetResult(ctx); err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+		}
+		ms.Close()
+
+		if fake.lookups != tc.wantLookups {
+			t.Errorf("%s: got %d stream lookups, want %d", tc.desc, fake.lookups, tc.wantLookups)
+		}
+		if len(fake.requests) != 1 {
+			t.Fatalf("%s: got %d requests, want 1", tc.desc, len(fake.requests))
+		}
+		rows := fake.requests[0].GetProtoRows()
+		dp := rows.GetWriterSchema().GetProtoDescriptor()
+		if len(dp.GetField()) != tc.wantFields {
+			t.Errorf("%s: got descriptor %v", tc.desc, dp)
+		}
+		if len(rows.GetRows().GetSerializedRows()) != 1 {
+			t.Errorf("%s: got rows %v", tc.desc, rows.GetRows())
+		}
+	}
+
+	ms, err := c.NewManagedStream(ctx, WithType(CommittedStream), WithDestinationTable("projects/p/datasets/d/tables/t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.AppendMapRows(ctx, []map[string]interface{}{{"name": "a"}}, NoStreamOffset); err != errNoJSONRows {
+		t.Errorf("got %v from a stream without WithJSONRows, want %v", err, errNoJSONRows)
+	}
+}
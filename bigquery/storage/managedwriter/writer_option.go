@@ -14,7 +14,10 @@
 
 package managedwriter
 
-import "google.golang.org/protobuf/types/descriptorpb"
+import (
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1beta2"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
 
 // WriterOption are variadic options used to configure a ManagedStream instance.
 type WriterOption func(*ManagedStream)
@@ -78,6 +81,41 @@ func WithSchemaDescriptor(dp *descriptorpb.DescriptorProto) WriterOption {
 	}
 }
 
+// WithJSONRows configures the stream to accept rows as JSON objects, maps or
+// bigquery.ValueSavers through the AppendJSONRows, AppendMapRows and
+// AppendValueSaverRows methods. The rows are converted into messages of a
+// descriptor built from the schema of the destination table, which replaces
+// any descriptor given by WithSchemaDescriptor.
+//
+// The table schema is returned by the service when the client creates or
+// looks up the stream, unless it is given by WithTableSchema.
+func WithJSONRows() WriterOption {
+	return func(ms *ManagedStream) {
+		ms.jsonRows = true
+	}
+}
+
+// WithTableSchema gives the schema of the destination table to a stream
+// configured with WithJSONRows, so that it need not be fetched.
+func WithTableSchema(schema *storagepb.TableSchema) WriterOption {
+	return func(ms *ManagedStream) {
+		ms.tableSchema = schema
+	}
+}
+
+// WithAutomaticSchemaUpdates makes a stream configured with WithJSONRows
+// adopt the updated table schemas reported by append responses. When the
+// schema of the destination table changes, such as when a column is added,
+// the stream rebuilds its descriptor from the new schema, and sends later
+// appends on a new connection as described by
+// ManagedStream.UpdateSchemaDescriptor. Rows with the new columns are accepted
+// once an AppendResult reports the updated schema.
+func WithAutomaticSchemaUpdates() WriterOption {
+	return func(ms *ManagedStream) {
+		ms.autoSchemaUpdates = true
+	}
+}
+
 // WithDataOrigin is used to attach an origin context to the instrumentation metrics
 // emitted by the library.
 func WithDataOrigin(dataOrigin string) WriterOption {
@@ -0,0 +1,101 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+
+	bqStorage "cloud.google.com/go/bigquery/storage/apiv1"
+	"google.golang.org/api/option"
+)
+
+// defaultStorageReadMinRows is the default value of StorageReadConfig.MinRows.
+const defaultStorageReadMinRows = 10000
+
+// StorageReadConfig configures how results are read through the BigQuery
+// Storage Read API.
+type StorageReadConfig struct {
+	// MaxStreams is the largest number of streams that are read in parallel.
+	// If zero, runtime.NumCPU() is used. Results of queries with an ORDER BY
+	// clause are always read from a single stream, to preserve their order.
+	MaxStreams int
+
+	// MinRows is the smallest number of rows that are read through the
+	// Storage Read API. Smaller results are read with the BigQuery API, which
+	// avoids the cost of creating a read session. If zero, 10000 is used. If
+	// negative, results of any size are read through the Storage Read API.
+	MinRows int64
+}
+
+// readClient reads table data through the BigQuery Storage Read API.
+type readClient struct {
+	rawClient *bqStorage.BigQueryReadClient
+	projectID string
+	cfg       StorageReadConfig
+}
+
+// EnableStorageReadClient makes the client read query results and table
+// contents through the BigQuery Storage Read API, which is much faster than
+// the BigQuery API for large results. Rows are returned by the same
+// RowIterator, so they can still be loaded into ValueLoaders or structs.
+//
+// Only results with at least cfg.MinRows rows that are stored in a table are
+// read through the Storage Read API. Small results, views, external tables
+// and the results of scripts and DML statements are read with the BigQuery API
+// as before.
+//
+// Rows read in parallel from several streams are not returned in any
+// particular order, and their RowIterator ignores page sizes. Its page
+// tokens cannot be used to resume iteration, and setting RowIterator.StartIndex
+// or a page token before the first call to Next reads with the BigQuery API.
+// The reads stop when the context passed to Read is done.
+//
+// The options are used to create the Storage Read API client. The client is
+// closed by Client.Close.
+func (c *Client) EnableStorageReadClient(ctx context.Context, cfg StorageReadConfig, opts ...option.ClientOption) error {
+	if c.rc != nil {
+		return errors.New("bigquery: storage read client is already enabled")
+	}
+	raw, err := bqStorage.NewBigQueryReadClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("bigquery: constructing storage read client: %v", err)
+	}
+	c.rc = &readClient{
+		rawClient: raw,
+		projectID: c.projectID,
+		cfg:       cfg,
+	}
+	return nil
+}
+
+// accepts reports whether a result with the given number of rows should be
+// read through the Storage Read API.
+func (rc *readClient) accepts(rows uint64) bool {
+	min := rc.cfg.MinRows
+	if min == 0 {
+		min = defaultStorageReadMinRows
+	}
+	return min < 0 || rows >= uint64(min)
+}
+
+func (rc *readClient) maxStreams() int {
+	if rc.cfg.MaxStreams > 0 {
+		return rc.cfg.MaxStreams
+	}
+	return runtime.NumCPU()
+}
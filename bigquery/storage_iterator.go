@@ -0,0 +1,319 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"time"
+
+	bqStorage "cloud.google.com/go/bigquery/storage/apiv1"
+	gax "github.com/googleapis/gax-go/v2"
+	"golang.org/x/sync/errgroup"
+	bq "google.golang.org/api/bigquery/v2"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// orderByRegexp matches the ORDER BY clause of a query.
+var orderByRegexp = regexp.MustCompile(`(?i)\bORDER\s+BY\b`)
+
+// readStreamRetries is the number of times in a row the read of a stream is
+// retried without receiving a row before it fails.
+const readStreamRetries = 5
+
+// storageReader provides a pageFetcher that reads rows through the BigQuery
+// Storage Read API, and uses another pageFetcher for the results it cannot
+// read.
+type storageReader struct {
+	rc       *readClient
+	fallback pageFetcher
+
+	// totalRows is the number of rows in the result, if known is set.
+	totalRows uint64
+	known     bool
+
+	started     bool
+	useFallback bool
+	session     string
+	schema      Schema
+	arrowSchema []byte // the serialized schema of an Arrow session
+
+	*storageStreams // set once the session is created
+}
+
+// storageStreams reads the streams of a read session. It is kept apart from
+// the storageReader, which the goroutines reading the streams do not refer
+// to: once the iterator reading the storageReader is garbage collected, its
+// finalizer cancels the reads.
+type storageStreams struct {
+	client *bqStorage.BigQueryReadClient
+	schema Schema
+	cancel context.CancelFunc // cancels the reads of all streams
+
+	// Streams are read into pages or, for an Arrow session, into batches.
+	// The channel is closed when all streams have been read.
+	pages   chan [][]Value
+	batches chan *ArrowRecordBatch
+	err     error // set before the channel is closed
+}
+
+func newStorageReader(rc *readClient, fallback pageFetcher) *storageReader {
+	return &storageReader{rc: rc, fallback: fallback}
+}
+
+func (s *storageReader) fetchPage(ctx context.Context, src *rowSource, schema Schema, startIndex uint64, pageSize int64, pageToken string) (*fetchPageResult, error) {
+	if !s.started {
+		s.started = true
+		s.useFallback = true
+		if pageToken == "" && startIndex == 0 {
+			ok, err := s.start(ctx, src, schema, storagepb.DataFormat_AVRO)
+			if err != nil {
+				return nil, err
+			}
+			s.useFallback = !ok
+		}
+	}
+	if s.useFallback {
+		if schema == nil {
+			schema = s.schema
+		}
+		return s.fallback(ctx, src, schema, startIndex, pageSize, pageToken)
+	}
+	if s.pages == nil {
+		return nil, errors.New("bigquery: rows are being read by an ArrowIterator")
+	}
+	res := &fetchPageResult{
+		totalRows: s.totalRows,
+		schema:    s.schema,
+	}
+	rows, ok := <-s.pages
+	if !ok {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return res, nil
+	}
+	res.rows = rows
+	// The token only needs to be non-empty to continue the iteration.
+	res.pageToken = s.session
+	return res, nil
+}
+
+// start creates a read session for the rows of src in the given format and
+// starts reading its streams. It reports false if src should be read with the
+// BigQuery API instead.
+func (s *storageReader) start(ctx context.Context, src *rowSource, schema Schema, format storagepb.DataFormat) (bool, error) {
+	t := src.t
+	ordered := false
+	if src.j != nil {
+		var err error
+		t, ordered, err = queryDestination(ctx, src.j)
+		if err != nil || t == nil {
+			return false, err
+		}
+	}
+	if schema == nil || !s.known {
+		var bqt *bq.Table
+		err := runWithRetry(ctx, func() (err error) {
+			call := t.c.bqs.Tables.Get(t.ProjectID, t.DatasetID, t.TableID).
+				Fields("schema", "numRows", "type").
+				Context(ctx)
+			setClientHeader(call.Header())
+			bqt, err = call.Do()
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		if schema == nil {
+			schema = bqToSchema(bqt.Schema)
+		}
+		// The schema is also used when reading with the BigQuery API.
+		s.schema = schema
+		if bqt.Type != "TABLE" {
+			return false, nil
+		}
+		if !s.known {
+			s.totalRows = bqt.NumRows
+			s.known = true
+		}
+	}
+	if !s.rc.accepts(s.totalRows) {
+		return false, nil
+	}
+
+	streams := s.rc.maxStreams()
+	if ordered {
+		streams = 1
+	}
+	session, err := s.rc.rawClient.CreateReadSession(ctx, &storagepb.CreateReadSessionRequest{
+		Parent: fmt.Sprintf("projects/%s", s.rc.projectID),
+		ReadSession: &storagepb.ReadSession{
+			Table:      fmt.Sprintf("projects/%s/datasets/%s/tables/%s", t.ProjectID, t.DatasetID, t.TableID),
+			DataFormat: format,
+		},
+		MaxStreamCount: int32(streams),
+	})
+	if err != nil {
+		return false, err
+	}
+	s.session = session.GetName()
+	s.schema = schema
+	ss := &storageStreams{client: s.rc.rawClient, schema: schema}
+	var read func(context.Context, *storagepb.ReadRowsResponse) (int64, error)
+	done := func() { close(ss.pages) }
+	if format == storagepb.DataFormat_ARROW {
+		s.arrowSchema = session.GetArrowSchema().GetSerializedSchema()
+		ss.batches = make(chan *ArrowRecordBatch, len(session.GetStreams()))
+		read = ss.sendBatch
+		done = func() { close(ss.batches) }
+	} else {
+		avro, err := parseAvroSchema(session.GetAvroSchema().GetSchema())
+		if err != nil {
+			return false, err
+		}
+		ss.pages = make(chan [][]Value, len(session.GetStreams()))
+		read = func(ctx context.Context, res *storagepb.ReadRowsResponse) (int64, error) {
+			return ss.sendRows(ctx, res, avro)
+		}
+	}
+	// The streams are read with a context of their own, so that they are not
+	// left open when the iterator is abandoned before it is done.
+	ctx, ss.cancel = context.WithCancel(ctx)
+	s.storageStreams = ss
+	runtime.SetFinalizer(s, func(s *storageReader) { s.cancel() })
+	g, gctx := errgroup.WithContext(ctx)
+	for _, stream := range session.GetStreams() {
+		name := stream.GetName()
+		g.Go(func() error { return ss.readStream(gctx, name, read) })
+	}
+	go func() {
+		ss.err = g.Wait()
+		ss.cancel()
+		done()
+	}()
+	return true, nil
+}
+
+// sendRows decodes the Avro rows of a response and sends them to s.pages.
+func (s *storageStreams) sendRows(ctx context.Context, res *storagepb.ReadRowsResponse, t *avroType) (int64, error) {
+	d := &avroDecoder{buf: res.GetAvroRows().GetSerializedBinaryRows()}
+	rows, err := d.rows(t, s.schema)
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case s.pages <- rows:
+		return int64(len(rows)), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// sendBatch sends the Arrow record batch of a response to s.batches.
+func (s *storageStreams) sendBatch(ctx context.Context, res *storagepb.ReadRowsResponse) (int64, error) {
+	b := &ArrowRecordBatch{
+		Data:    res.GetArrowRecordBatch().GetSerializedRecordBatch(),
+		NumRows: res.GetArrowRecordBatch().GetRowCount(),
+	}
+	select {
+	case s.batches <- b:
+		return b.NumRows, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// readStream passes each response of a stream to read, which returns the
+// number of rows in it. A broken connection is resumed from the last row
+// received, unless it broke readStreamRetries times in a row before any row
+// was received.
+func (s *storageStreams) readStream(ctx context.Context, stream string, read func(context.Context, *storagepb.ReadRowsResponse) (int64, error)) error {
+	var offset int64
+	backoff := gax.Backoff{Max: 10 * time.Second}
+	retries := 0
+	for {
+		start := offset
+		rs, err := s.client.ReadRows(ctx, &storagepb.ReadRowsRequest{
+			ReadStream: stream,
+			Offset:     offset,
+		})
+		if err != nil {
+			return err
+		}
+		for {
+			var res *storagepb.ReadRowsResponse
+			res, err = rs.Recv()
+			if err != nil {
+				break
+			}
+			n, rerr := read(ctx, res)
+			if rerr != nil {
+				return rerr
+			}
+			offset += n
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if offset > start {
+			backoff = gax.Backoff{Max: 10 * time.Second}
+			retries = 0
+		}
+		if status.Code(err) != codes.Unavailable || retries == readStreamRetries {
+			return err
+		}
+		retries++
+		if err := gax.Sleep(ctx, backoff.Pause()); err != nil {
+			return err
+		}
+	}
+}
+
+// queryDestination returns the table holding the results of a query job, or
+// nil if they cannot be read through the Storage Read API. It also reports
+// whether the results are ordered.
+func queryDestination(ctx context.Context, j *Job) (t *Table, ordered bool, err error) {
+	var job *bq.Job
+	err = runWithRetry(ctx, func() (err error) {
+		call := j.c.bqs.Jobs.Get(j.projectID, j.jobID).
+			Location(j.location).
+			Fields("configuration.query.destinationTable", "configuration.query.query", "statistics.query.statementType").
+			Context(ctx)
+		setClientHeader(call.Header())
+		job, err = call.Do()
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if job.Configuration == nil || job.Configuration.Query == nil || job.Configuration.Query.DestinationTable == nil {
+		return nil, false, nil
+	}
+	// Scripts and DML statements have no results to read.
+	if job.Statistics != nil && job.Statistics.Query != nil {
+		if st := job.Statistics.Query.StatementType; st != "" && st != "SELECT" {
+			return nil, false, nil
+		}
+	}
+	q := job.Configuration.Query
+	return bqToTable(q.DestinationTable, j.c), orderByRegexp.MatchString(q.Query), nil
+}
@@ -0,0 +1,441 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testReadAvroSchema = `{"type": "record", "name": "__root__", "fields": [
+	{"name": "id", "type": "long"},
+	{"name": "name", "type": ["null", "string"]}
+]}`
+
+const testReadTableSchema = `{"fields": [
+	{"name": "id", "type": "INTEGER", "mode": "REQUIRED"},
+	{"name": "name", "type": "STRING"}
+]}`
+
+// fakeReadServer serves read sessions whose streams have one row per
+// response. The rows of an Arrow session are served as record batches.
+type fakeReadServer struct {
+	storagepb.UnimplementedBigQueryReadServer
+
+	mu       sync.Mutex
+	streams  map[string][][]byte
+	sessions []*storagepb.CreateReadSessionRequest
+	reads    []string
+	arrow    bool
+	// failStream, if set, is broken once after its first response.
+	failStream string
+	// held, if set, holds streams open once their rows are sent, and is
+	// closed when a held stream is canceled.
+	held chan struct{}
+}
+
+func (s *fakeReadServer) CreateReadSession(ctx context.Context, req *storagepb.CreateReadSessionRequest) (*storagepb.ReadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = append(s.sessions, req)
+	rs := &storagepb.ReadSession{
+		Name:   "session",
+		Schema: &storagepb.ReadSession_AvroSchema{AvroSchema: &storagepb.AvroSchema{Schema: testReadAvroSchema}},
+	}
+	s.arrow = req.GetReadSession().GetDataFormat() == storagepb.DataFormat_ARROW
+	if s.arrow {
+		rs.Schema = &storagepb.ReadSession_ArrowSchema{ArrowSchema: &storagepb.ArrowSchema{SerializedSchema: []byte("arrow schema")}}
+	}
+	var names []string
+	for name := range s.streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rs.Streams = append(rs.Streams, &storagepb.ReadStream{Name: name})
+	}
+	return rs, nil
+}
+
+func (s *fakeReadServer) ReadRows(req *storagepb.ReadRowsRequest, stream storagepb.BigQueryRead_ReadRowsServer) error {
+	s.mu.Lock()
+	s.reads = append(s.reads, fmt.Sprintf("%s@%d", req.GetReadStream(), req.GetOffset()))
+	rows := s.streams[req.GetReadStream()]
+	fail := s.failStream == req.GetReadStream()
+	if fail {
+		s.failStream = ""
+	}
+	arrow := s.arrow
+	held := s.held
+	s.mu.Unlock()
+	for i := req.GetOffset(); i < int64(len(rows)); i++ {
+		if fail && i > req.GetOffset() {
+			return status.Error(codes.Unavailable, "injected failure")
+		}
+		res := &storagepb.ReadRowsResponse{
+			Rows:     &storagepb.ReadRowsResponse_AvroRows{AvroRows: &storagepb.AvroRows{SerializedBinaryRows: rows[i], RowCount: 1}},
+			RowCount: 1,
+		}
+		if arrow {
+			res.Rows = &storagepb.ReadRowsResponse_ArrowRecordBatch{ArrowRecordBatch: &storagepb.ArrowRecordBatch{SerializedRecordBatch: rows[i], RowCount: 1}}
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+	}
+	if held != nil {
+		<-stream.Context().Done()
+		close(held)
+	}
+	return nil
+}
+
+func testAvroRow(id int64, name string) []byte {
+	var e avroEncoder
+	e.long(id)
+	e.long(1)
+	e.bytes([]byte(name))
+	return e.Bytes()
+}
+
+// newStorageReadTestClient returns a client whose BigQuery API requests are
+// served by h, and whose Storage Read API requests are served by a fake.
+func newStorageReadTestClient(t *testing.T, cfg StorageReadConfig, h http.HandlerFunc) (*Client, *fakeReadServer, func()) {
+	ctx := context.Background()
+	ts := httptest.NewServer(h)
+	fake := &fakeReadServer{streams: map[string][][]byte{}}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gsrv := grpc.NewServer()
+	storagepb.RegisterBigQueryReadServer(gsrv, fake)
+	go gsrv.Serve(lis)
+	cleanup := func() {
+		gsrv.Stop()
+		ts.Close()
+	}
+	c, err := NewClient(ctx, "client-project", option.WithEndpoint(ts.URL+"/bigquery/v2/"), option.WithoutAuthentication())
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	err = c.EnableStorageReadClient(ctx, cfg,
+		option.WithEndpoint(lis.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()))
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return c, fake, func() {
+		c.Close()
+		cleanup()
+	}
+}
+
+// tableHandler serves metadata and tabledata.list requests for a table, and
+// records the paths requested.
+func tableHandler(tableType string, numRows int, paths *[]string) http.HandlerFunc {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*paths = append(*paths, strings.TrimPrefix(r.URL.Path, "/bigquery/v2"))
+		mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/data"):
+			fmt.Fprint(w, `{"totalRows": "1", "rows": [{"f": [{"v": "1"}, {"v": "a"}]}]}`)
+		case strings.Contains(r.URL.Path, "/queries/"):
+			fmt.Fprintf(w, `{"jobComplete": true, "totalRows": "1", "schema": %s, "rows": [{"f": [{"v": "1"}, {"v": "a"}]}]}`, testReadTableSchema)
+		case strings.Contains(r.URL.Path, "/jobs/"):
+			st := "SELECT"
+			if strings.HasSuffix(r.URL.Path, "dml") {
+				st = "UPDATE"
+			}
+			fmt.Fprintf(w, `{"configuration": {"query": {"query": "SELECT * FROM t ORDER BY id", "destinationTable": {"projectId": "p", "datasetId": "d", "tableId": "t"}}},
+				"statistics": {"query": {"statementType": %q}}}`, st)
+		default:
+			fmt.Fprintf(w, `{"type": %q, "numRows": "%d", "schema": %s}`, tableType, numRows, testReadTableSchema)
+		}
+	}
+}
+
+func TestStorageReadTable(t *testing.T) {
+	var paths []string
+	c, fake, cleanup := newStorageReadTestClient(t, StorageReadConfig{MaxStreams: 2}, tableHandler("TABLE", 20000, &paths))
+	defer cleanup()
+	fake.streams["s1"] = [][]byte{testAvroRow(1, "a"), testAvroRow(2, "b")}
+	fake.streams["s2"] = [][]byte{testAvroRow(3, "c")}
+	fake.failStream = "s1"
+
+	it := c.DatasetInProject("p", "d").Table("t").Read(context.Background())
+	type row struct {
+		ID   int64
+		Name string
+	}
+	var got []row
+	for {
+		var r row
+		err := it.Next(&r)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].ID < got[j].ID })
+	if diff := testutil.Diff(got, []row{{1, "a"}, {2, "b"}, {3, "c"}}); diff != "" {
+		t.Errorf("rows: got=-, want=+:\n%s", diff)
+	}
+	if it.TotalRows != 20000 {
+		t.Errorf("got TotalRows %d, want 20000", it.TotalRows)
+	}
+	if len(it.Schema) != 2 || it.Schema[0].Name != "id" {
+		t.Errorf("got schema %+v", it.Schema)
+	}
+	if len(fake.sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(fake.sessions))
+	}
+	req := fake.sessions[0]
+	if req.GetParent() != "projects/client-project" || req.GetReadSession().GetTable() != "projects/p/datasets/d/tables/t" ||
+		req.GetMaxStreamCount() != 2 || req.GetReadSession().GetDataFormat() != storagepb.DataFormat_AVRO {
+		t.Errorf("got session request %v", req)
+	}
+	sort.Strings(fake.reads)
+	if diff := testutil.Diff(fake.reads, []string{"s1@0", "s1@1", "s2@0"}); diff != "" {
+		t.Errorf("reads: got=-, want=+:\n%s", diff)
+	}
+	if diff := testutil.Diff(paths, []string{"/projects/p/datasets/d/tables/t"}); diff != "" {
+		t.Errorf("paths: got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestStorageReadArrow(t *testing.T) {
+	var paths []string
+	c, fake, cleanup := newStorageReadTestClient(t, StorageReadConfig{MaxStreams: 2}, tableHandler("TABLE", 20000, &paths))
+	defer cleanup()
+	fake.streams["s1"] = [][]byte{[]byte("b1"), []byte("b2")}
+	fake.streams["s2"] = [][]byte{[]byte("b3")}
+
+	it := c.DatasetInProject("p", "d").Table("t").Read(context.Background())
+	ai, err := it.ArrowIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(ai.SerializedArrowSchema()); got != "arrow schema" {
+		t.Errorf("got serialized schema %q", got)
+	}
+	if len(ai.Schema()) != 2 || it.TotalRows != 20000 {
+		t.Errorf("got schema %+v and %d total rows", ai.Schema(), it.TotalRows)
+	}
+	var got []string
+	for {
+		b, err := ai.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.NumRows != 1 {
+			t.Errorf("got %d rows in batch %q", b.NumRows, b.Data)
+		}
+		got = append(got, string(b.Data))
+	}
+	sort.Strings(got)
+	if diff := testutil.Diff(got, []string{"b1", "b2", "b3"}); diff != "" {
+		t.Errorf("batches: got=-, want=+:\n%s", diff)
+	}
+	if len(fake.sessions) != 1 || fake.sessions[0].GetReadSession().GetDataFormat() != storagepb.DataFormat_ARROW {
+		t.Errorf("got session requests %v", fake.sessions)
+	}
+	if err := it.Next(&[]Value{}); err == nil {
+		t.Error("got nil error from Next on a RowIterator read by an ArrowIterator")
+	}
+}
+
+func TestStorageReadFallback(t *testing.T) {
+	for _, test := range []struct {
+		desc       string
+		tableType  string
+		numRows    int
+		startIndex uint64
+		wantPaths  []string
+	}{
+		{
+			desc:      "small table",
+			tableType: "TABLE",
+			numRows:   10,
+			// The schema is fetched only once.
+			wantPaths: []string{"/projects/p/datasets/d/tables/t", "/projects/p/datasets/d/tables/t/data"},
+		},
+		{
+			desc:      "view",
+			tableType: "VIEW",
+			numRows:   20000,
+			wantPaths: []string{"/projects/p/datasets/d/tables/t", "/projects/p/datasets/d/tables/t/data"},
+		},
+		{
+			desc:       "start index",
+			tableType:  "TABLE",
+			numRows:    20000,
+			startIndex: 5,
+			// The schema is fetched alongside the rows.
+			wantPaths: []string{"/projects/p/datasets/d/tables/t", "/projects/p/datasets/d/tables/t/data"},
+		},
+	} {
+		var paths []string
+		c, fake, cleanup := newStorageReadTestClient(t, StorageReadConfig{}, tableHandler(test.tableType, test.numRows, &paths))
+		it := c.DatasetInProject("p", "d").Table("t").Read(context.Background())
+		it.StartIndex = test.startIndex
+		var got []Value
+		if err := it.Next(&got); err != nil {
+			t.Errorf("%s: %v", test.desc, err)
+		} else if diff := testutil.Diff(got, []Value{int64(1), "a"}); diff != "" {
+			t.Errorf("%s: got=-, want=+:\n%s", test.desc, diff)
+		}
+		sort.Strings(paths)
+		if diff := testutil.Diff(paths, test.wantPaths); diff != "" {
+			t.Errorf("%s: paths: got=-, want=+:\n%s", test.desc, diff)
+		}
+		if len(fake.sessions) != 0 {
+			t.Errorf("%s: got %d read sessions, want none", test.desc, len(fake.sessions))
+		}
+		cleanup()
+	}
+}
+
+func TestStorageReadQuery(t *testing.T) {
+	var paths []string
+	c, fake, cleanup := newStorageReadTestClient(t, StorageReadConfig{MinRows: -1}, tableHandler("TABLE", 3, &paths))
+	defer cleanup()
+	fake.streams["s1"] = [][]byte{testAvroRow(1, "a"), testAvroRow(2, "b")}
+	ctx := context.Background()
+	schema := Schema{{Name: "id", Type: IntegerFieldType, Required: true}, {Name: "name", Type: StringFieldType}}
+	waitForQuery := func(context.Context, string) (Schema, uint64, error) { return schema, 2, nil }
+
+	// An ordered query is read from a single stream.
+	j := &Job{c: c, projectID: "p", jobID: "select", config: &bq.JobConfiguration{Query: &bq.JobConfigurationQuery{}}}
+	it, err := j.read(ctx, waitForQuery, fetchPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]Value
+	for {
+		var row []Value
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if diff := testutil.Diff(rows, [][]Value{{int64(1), "a"}, {int64(2), "b"}}); diff != "" {
+		t.Errorf("rows: got=-, want=+:\n%s", diff)
+	}
+	if len(fake.sessions) != 1 || fake.sessions[0].GetMaxStreamCount() != 1 {
+		t.Errorf("got sessions %v, want one with a single stream", fake.sessions)
+	}
+	if diff := testutil.Diff(paths, []string{"/projects/p/jobs/select"}); diff != "" {
+		t.Errorf("paths: got=-, want=+:\n%s", diff)
+	}
+
+	// The results of a DML statement are read with the BigQuery API.
+	paths = nil
+	j = &Job{c: c, projectID: "p", jobID: "dml", config: &bq.JobConfiguration{Query: &bq.JobConfigurationQuery{}}}
+	it, err = j.read(ctx, waitForQuery, fetchPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row []Value
+	if err := it.Next(&row); err != nil {
+		t.Fatal(err)
+	}
+	if diff := testutil.Diff(paths, []string{"/projects/p/jobs/dml", "/projects/p/queries/dml"}); diff != "" {
+		t.Errorf("paths: got=-, want=+:\n%s", diff)
+	}
+	if len(fake.sessions) != 1 {
+		t.Errorf("got %d sessions, want 1", len(fake.sessions))
+	}
+}
+
+func TestStorageReadAbandoned(t *testing.T) {
+	var paths []string
+	c, fake, cleanup := newStorageReadTestClient(t, StorageReadConfig{}, tableHandler("TABLE", 20000, &paths))
+	defer cleanup()
+	fake.streams["s1"] = [][]byte{testAvroRow(1, "a"), testAvroRow(2, "b"), testAvroRow(3, "c")}
+	fake.held = make(chan struct{})
+
+	// Read one row, then abandon the iterator.
+	func() {
+		it := c.DatasetInProject("p", "d").Table("t").Read(context.Background())
+		var row []Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for i := 0; ; i++ {
+		runtime.GC()
+		select {
+		case <-fake.held:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+		if i == 50 {
+			t.Fatal("the stream of an abandoned iterator was not canceled")
+		}
+	}
+}
+
+func TestQueryOrdered(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT * FROM t", false},
+		{"SELECT * FROM t ORDER BY id", true},
+		{"select * from t order by id", true},
+		{"SELECT * FROM t\nORDER\n  BY id", true},
+		{"SELECT * FROM t ORDER\tBY id", true},
+		{"SELECT border, bye FROM t", false},
+		{"SELECT * FROM t ORDERBY id", false},
+	} {
+		if got := orderByRegexp.MatchString(test.query); got != test.want {
+			t.Errorf("%q: got %t, want %t", test.query, got, test.want)
+		}
+	}
+}
@@ -670,7 +670,14 @@ func (t *Table) Read(ctx context.Context) *RowIterator {
 }
 
 func (t *Table) read(ctx context.Context, pf pageFetcher) *RowIterator {
-	return newRowIterator(ctx, &rowSource{t: t}, pf)
+	var s *storageReader
+	if t.c.rc != nil {
+		s = newStorageReader(t.c.rc, pf)
+		pf = s.fetchPage
+	}
+	it := newRowIterator(ctx, &rowSource{t: t}, pf)
+	it.storage = s
+	return it
 }
 
 // NeverExpire is a sentinel value used to remove a table'e expiration time.
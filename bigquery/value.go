@@ -16,6 +16,7 @@ package bigquery
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -187,6 +188,23 @@ func setBytes(v reflect.Value, x interface{}) error {
 	return nil
 }
 
+func setJSON(v reflect.Value, x interface{}) error {
+	if x == nil {
+		v.SetBytes(nil)
+	} else {
+		v.SetBytes(x.(json.RawMessage))
+	}
+	return nil
+}
+
+func setJSONString(v reflect.Value, x interface{}) error {
+	if x == nil {
+		return errNoNulls
+	}
+	v.SetString(string(x.(json.RawMessage)))
+	return nil
+}
+
 func setNull(v reflect.Value, x interface{}, build func() interface{}) error {
 	if x == nil {
 		v.Set(reflect.Zero(v.Type()))
@@ -414,6 +432,21 @@ func determineSetFunc(ftype reflect.Type, stype FieldType) setFunc {
 				return setNull(v, x, func() interface{} { return x.(*big.Rat) })
 			}
 		}
+
+	case JSONFieldType:
+		if ftype == typeOfJSON {
+			return setJSON
+		}
+		if ftype.Kind() == reflect.String {
+			return setJSONString
+		}
+
+	case IntervalFieldType:
+		if ftype == typeOfIntervalValue {
+			return func(v reflect.Value, x interface{}) error {
+				return setNull(v, x, func() interface{} { return x.(*IntervalValue) })
+			}
+		}
 	}
 	return nil
 }
@@ -699,7 +732,8 @@ func structFieldToUploadValue(vfield reflect.Value, schemaField *FieldSchema) (i
 }
 
 func toUploadValue(val interface{}, fs *FieldSchema) interface{} {
-	if fs.Type == TimeFieldType || fs.Type == DateTimeFieldType || fs.Type == NumericFieldType || fs.Type == BigNumericFieldType {
+	switch fs.Type {
+	case TimeFieldType, DateTimeFieldType, NumericFieldType, BigNumericFieldType, JSONFieldType, IntervalFieldType:
 		return toUploadValueReflect(reflect.ValueOf(val), fs)
 	}
 	return val
@@ -735,6 +769,27 @@ func toUploadValueReflect(v reflect.Value, fs *FieldSchema) interface{} {
 		return formatUploadValue(v, fs, func(v reflect.Value) string {
 			return BigNumericString(v.Interface().(*big.Rat))
 		})
+	case JSONFieldType:
+		// JSON values are uploaded as strings holding the JSON text.
+		if r, ok := v.Interface().(json.RawMessage); ok && r == nil {
+			return nil
+		}
+		return formatUploadValue(v, fs, func(v reflect.Value) string {
+			if r, ok := v.Interface().(json.RawMessage); ok {
+				return string(r)
+			}
+			return fmt.Sprint(v.Interface())
+		})
+	case IntervalFieldType:
+		if iv, ok := v.Interface().(*IntervalValue); ok && iv == nil {
+			return nil
+		}
+		return formatUploadValue(v, fs, func(v reflect.Value) string {
+			if iv, ok := v.Interface().(*IntervalValue); ok {
+				return iv.String()
+			}
+			return fmt.Sprint(v.Interface())
+		})
 	default:
 		if !fs.Repeated || v.Len() > 0 {
 			return v.Interface()
@@ -947,6 +1002,14 @@ func convertBasicType(val string, typ FieldType) (Value, error) {
 		return Value(r), nil
 	case GeographyFieldType:
 		return val, nil
+	case JSONFieldType:
+		return json.RawMessage(val), nil
+	case IntervalFieldType:
+		iv, err := ParseInterval(val)
+		if err != nil {
+			return nil, err
+		}
+		return Value(iv), nil
 	default:
 		return nil, fmt.Errorf("unrecognized type: %s", typ)
 	}
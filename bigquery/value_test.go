@@ -16,6 +16,7 @@ package bigquery
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"math"
 	"math/big"
@@ -38,6 +39,8 @@ func TestConvertBasicValues(t *testing.T) {
 		{Type: NumericFieldType},
 		{Type: BigNumericFieldType},
 		{Type: GeographyFieldType},
+		{Type: JSONFieldType},
+		{Type: IntervalFieldType},
 	}
 	row := &bq.TableRow{
 		F: []*bq.TableCell{
@@ -49,6 +52,8 @@ func TestConvertBasicValues(t *testing.T) {
 			{V: "123.123456789"},
 			{V: "99999999999999999999999999999999999999.99999999999999999999999999999999999999"},
 			{V: testGeography},
+			{V: `{"a": [1, "b"]}`},
+			{V: "-1-2 3 -4:5:6.5"},
 		},
 	}
 	got, err := convertRow(row, schema)
@@ -58,7 +63,9 @@ func TestConvertBasicValues(t *testing.T) {
 
 	bigRatVal := new(big.Rat)
 	bigRatVal.SetString("99999999999999999999999999999999999999.99999999999999999999999999999999999999")
-	want := []Value{"a", int64(1), 1.2, true, []byte("foo"), big.NewRat(123123456789, 1e9), bigRatVal, testGeography}
+	want := []Value{"a", int64(1), 1.2, true, []byte("foo"), big.NewRat(123123456789, 1e9), bigRatVal, testGeography,
+		json.RawMessage(`{"a": [1, "b"]}`),
+		&IntervalValue{Years: -1, Months: -2, Days: 3, Hours: -4, Minutes: -5, Seconds: -6, SubSecondNanos: -500000000}}
 	if !testutil.Equal(got, want) {
 		t.Errorf("converting basic values: got:\n%v\nwant:\n%v", got, want)
 	}
@@ -716,6 +723,61 @@ func TestStructSaver(t *testing.T) {
 		})
 }
 
+func TestSaveJSONAndInterval(t *testing.T) {
+	schema := Schema{
+		{Name: "j", Type: JSONFieldType},
+		{Name: "jr", Type: JSONFieldType, Repeated: true},
+		{Name: "i", Type: IntervalFieldType},
+		{Name: "ir", Type: IntervalFieldType, Repeated: true},
+	}
+	type T struct {
+		J  json.RawMessage
+		JR []json.RawMessage
+		I  *IntervalValue
+		IR []*IntervalValue
+	}
+	iv := &IntervalValue{Months: 14, Hours: 25}
+	want := map[string]Value{
+		"j":  `{"a":1}`,
+		"jr": []string{`[1]`, `"x"`},
+		"i":  "1-2 0 25:0:0",
+		"ir": []string{"1-2 0 25:0:0"},
+	}
+	ss := &StructSaver{Schema: schema, Struct: T{
+		J:  json.RawMessage(`{"a":1}`),
+		JR: []json.RawMessage{json.RawMessage(`[1]`), json.RawMessage(`"x"`)},
+		I:  iv,
+		IR: []*IntervalValue{iv},
+	}}
+	got, _, err := ss.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("StructSaver: got=-, want=+:\n%s", diff)
+	}
+
+	// Nil values are omitted.
+	ss.Struct = T{}
+	got, _, err = ss.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := testutil.Diff(got, map[string]Value{}); diff != "" {
+		t.Errorf("StructSaver, nil values: got=-, want=+:\n%s", diff)
+	}
+
+	// A ValuesSaver may also supply JSON as a string.
+	vs := &ValuesSaver{Schema: schema, Row: []Value{`{"a":1}`, []Value{json.RawMessage(`[1]`), `"x"`}, iv, []Value{iv}}}
+	got, _, err = vs.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("ValuesSaver: got=-, want=+:\n%s", diff)
+	}
+}
+
 func TestStructSaverErrors(t *testing.T) {
 	type (
 		badField struct {
@@ -1147,6 +1209,38 @@ func TestStructLoaderNullable(t *testing.T) {
 	}
 }
 
+func TestStructLoaderJSONAndInterval(t *testing.T) {
+	schema := Schema{
+		{Name: "j", Type: JSONFieldType},
+		{Name: "js", Type: JSONFieldType},
+		{Name: "i", Type: IntervalFieldType},
+		{Name: "ir", Type: IntervalFieldType, Repeated: true},
+	}
+	type T struct {
+		J  json.RawMessage
+		JS string
+		I  *IntervalValue
+		IR []*IntervalValue
+	}
+	iv := &IntervalValue{Years: 1, Days: 2}
+	var got T
+	mustLoad(t, &got, schema, []Value{json.RawMessage(`{"a":1}`), json.RawMessage(`[2]`), iv, []Value{iv}})
+	want := T{J: json.RawMessage(`{"a":1}`), JS: `[2]`, I: iv, IR: []*IntervalValue{iv}}
+	if diff := testutil.Diff(got, want); diff != "" {
+		t.Errorf("got=-, want=+:\n%s", diff)
+	}
+
+	// JSON and INTERVAL fields are nullable, but a string is not.
+	mustLoad(t, &got, schema[:1], []Value{nil})
+	mustLoad(t, &got, schema[2:3], []Value{nil})
+	if got.J != nil || got.I != nil {
+		t.Errorf("got %+v, want nil J and I", got)
+	}
+	if err := load(&got, schema[1:2], []Value{nil}); err != errNoNulls {
+		t.Errorf("loading NULL into a string: got %v, want errNoNulls", err)
+	}
+}
+
 func TestStructLoaderOverflow(t *testing.T) {
 	type S struct {
 		I int16
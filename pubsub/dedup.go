@@ -0,0 +1,310 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultDeduplicationTTL is the default period for which the key of a
+// processed message is remembered.
+const DefaultDeduplicationTTL = time.Hour
+
+// DefaultDeduplicationTimeout is the default time limit for a single call to
+// a DedupStore.
+const DefaultDeduplicationTimeout = 5 * time.Second
+
+// DedupStore records the keys of messages that have been successfully
+// processed, so that redeliveries of those messages can be skipped.
+//
+// Implementations must be safe for concurrent use. A DedupStore may be shared
+// by several subscribers (for example, one backed by a database) in order to
+// deduplicate across processes.
+type DedupStore interface {
+	// Contains reports whether key was added to the store and has not yet
+	// expired.
+	Contains(ctx context.Context, key string) (bool, error)
+
+	// Add records key in the store. The key should be forgotten once ttl has
+	// elapsed.
+	Add(ctx context.Context, key string, ttl time.Duration) error
+}
+
+// DeduplicationSettings configures the deduplication of redelivered messages
+// in Subscription.Receive.
+//
+// Receive looks up the key of every message in Store before invoking the
+// handler, in the goroutine that invokes it, so a slow Store delays only the
+// messages being looked up. If the key is present, the message is acked without being passed
+// to the handler and the DuplicateCount measure is incremented. The key of a
+// message is added to Store when the handler acks it; messages that are nacked
+// (or whose ack deadline expires) are not recorded, so they are processed again
+// when redelivered.
+//
+// A message that is redelivered while an earlier delivery of it is still
+// being handled by this Receive call is dropped without being acked or nacked,
+// so that it is redelivered once its ack deadline expires. Nacking it would
+// have it redelivered at once, again and again while the earlier delivery is
+// handled.
+//
+// Calls to Store are bounded by Timeout. Recording a key happens in the
+// background, so acking a message does not wait for Store. Keys of messages
+// acked after Receive has returned are not recorded.
+//
+// Errors returned by Store are not fatal: a message whose key cannot be looked
+// up is passed to the handler, preserving at-least-once delivery, and a key
+// that cannot be recorded is simply not deduplicated. Each such error
+// increments the DedupStoreErrorCount measure.
+type DeduplicationSettings struct {
+	// Store holds the keys of processed messages. It must be non-nil.
+	Store DedupStore
+
+	// Attribute, if non-empty, is the name of the message attribute whose
+	// value is used as the deduplication key. Messages that do not have the
+	// attribute are never treated as duplicates. If Attribute is empty,
+	// Message.ID is used as the key.
+	Attribute string
+
+	// TTL is the period for which the key of a processed message is
+	// remembered. If zero, DefaultDeduplicationTTL is used.
+	TTL time.Duration
+
+	// Timeout bounds each call to Store. If zero, DefaultDeduplicationTimeout
+	// is used.
+	Timeout time.Duration
+}
+
+// deduplicator applies DeduplicationSettings to the messages of a single
+// Receive call.
+type deduplicator struct {
+	store   DedupStore
+	attr    string
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]bool // keys of messages currently being handled or recorded
+	closed   bool            // set by wait; no more keys are recorded
+
+	adds sync.WaitGroup // pending calls to store.Add
+}
+
+func newDeduplicator(ds *DeduplicationSettings) *deduplicator {
+	ttl := ds.TTL
+	if ttl <= 0 {
+		ttl = DefaultDeduplicationTTL
+	}
+	timeout := ds.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDeduplicationTimeout
+	}
+	return &deduplicator{
+		store:    ds.Store,
+		attr:     ds.Attribute,
+		ttl:      ttl,
+		timeout:  timeout,
+		inFlight: make(map[string]bool),
+	}
+}
+
+// key returns the deduplication key of msg, and false if msg should not be
+// deduplicated.
+func (d *deduplicator) key(msg *Message) (string, bool) {
+	if d.attr == "" {
+		return msg.ID, msg.ID != ""
+	}
+	k, ok := msg.Attributes[d.attr]
+	return k, ok && k != ""
+}
+
+// filter decides what to do with msg before it is handed to the user's
+// handler. It reports whether msg should be handled; if not, msg has already
+// been acked, or dropped with drop. If msg is to be handled, filter arranges
+// for its key to be recorded when msg is acked.
+func (d *deduplicator) filter(ctx context.Context, msg *Message, drop func()) bool {
+	key, ok := d.key(msg)
+	if !ok {
+		return true
+	}
+	d.mu.Lock()
+	busy := d.inFlight[key]
+	if !busy {
+		d.inFlight[key] = true
+	}
+	d.mu.Unlock()
+	if busy {
+		recordStat(ctx, DuplicateCount, 1)
+		drop()
+		return false
+	}
+	if d.contains(ctx, key) {
+		d.release(key)
+		recordStat(ctx, DuplicateCount, 1)
+		msg.Ack()
+		return false
+	}
+	ackh, ok := msgAckHandler(msg)
+	if !ok {
+		d.release(key)
+		return true
+	}
+	old := ackh.doneFunc
+	ackh.doneFunc = func(ackID string, ack bool, receiveTime time.Time) {
+		if ack {
+			// Keep the key in flight until it is recorded, so that a
+			// redelivery in the meantime is not handled again.
+			d.add(ctx, key)
+		} else {
+			d.release(key)
+		}
+		old(ackID, ack, receiveTime)
+	}
+	return true
+}
+
+// contains reports whether key is in the store. Errors are treated as the key
+// being absent.
+func (d *deduplicator) contains(ctx context.Context, key string) bool {
+	cctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	seen, err := d.store.Contains(cctx, key)
+	if err != nil {
+		recordStat(ctx, DedupStoreErrorCount, 1)
+		return false
+	}
+	return seen
+}
+
+// add records key in the store in the background, and then releases it. Once
+// wait has been called, key is released without being recorded.
+func (d *deduplicator) add(ctx context.Context, key string) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		d.release(key)
+		return
+	}
+	d.adds.Add(1)
+	d.mu.Unlock()
+	go func() {
+		defer d.adds.Done()
+		defer d.release(key)
+		// The context passed to Receive may already be done by the time the
+		// message is acked, so don't use it here.
+		actx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		defer cancel()
+		if err := d.store.Add(actx, key, d.ttl); err != nil {
+			recordStat(ctx, DedupStoreErrorCount, 1)
+		}
+	}()
+}
+
+// wait stops the recording of keys, and waits for keys that are being
+// recorded.
+func (d *deduplicator) wait() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.adds.Wait()
+}
+
+func (d *deduplicator) release(key string) {
+	d.mu.Lock()
+	delete(d.inFlight, key)
+	d.mu.Unlock()
+}
+
+// NewMemoryDedupStore returns a DedupStore that holds at most capacity keys in
+// memory. When the store is full, the least recently used key is evicted. If
+// capacity is not positive, the number of keys is unbounded and keys are only
+// removed when they expire.
+//
+// A memory store only deduplicates messages received by the current process.
+func NewMemoryDedupStore(capacity int) DedupStore {
+	return &memoryDedupStore{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+type memoryDedupStore struct {
+	capacity int
+	now      func() time.Time // for testing
+
+	mu    sync.Mutex
+	ll    *list.List // of *dedupEntry, most recently used at the front
+	items map[string]*list.Element
+}
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+func (s *memoryDedupStore) Contains(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return false, nil
+	}
+	if !s.now().Before(e.Value.(*dedupEntry).expires) {
+		s.remove(e)
+		return false, nil
+	}
+	s.ll.MoveToFront(e)
+	return true, nil
+}
+
+func (s *memoryDedupStore) Add(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires := s.now().Add(ttl)
+	if e, ok := s.items[key]; ok {
+		e.Value.(*dedupEntry).expires = expires
+		s.ll.MoveToFront(e)
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(&dedupEntry{key: key, expires: expires})
+	s.evict()
+	return nil
+}
+
+// evict removes expired entries from the back of the list, and then the least
+// recently used entries until the store is within capacity.
+// s.mu must be held.
+func (s *memoryDedupStore) evict() {
+	now := s.now()
+	for e := s.ll.Back(); e != nil; {
+		prev := e.Prev()
+		overCapacity := s.capacity > 0 && s.ll.Len() > s.capacity
+		if !overCapacity && now.Before(e.Value.(*dedupEntry).expires) {
+			break
+		}
+		s.remove(e)
+		e = prev
+	}
+}
+
+// s.mu must be held.
+func (s *memoryDedupStore) remove(e *list.Element) {
+	s.ll.Remove(e)
+	delete(s.items, e.Value.(*dedupEntry).key)
+}
@@ -0,0 +1,281 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	ipubsub "cloud.google.com/go/internal/pubsub"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryDedupStore(2).(*memoryDedupStore)
+	s.now = func() time.Time { return now }
+
+	contains := func(key string) bool {
+		t.Helper()
+		ok, err := s.Contains(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if contains("a") {
+		t.Fatal("empty store contains a")
+	}
+	s.Add(ctx, "a", time.Minute)
+	s.Add(ctx, "b", time.Minute)
+	if !contains("a") || !contains("b") {
+		t.Fatal("want a and b in store")
+	}
+	// "a" was used more recently than "b", so "b" is evicted.
+	contains("a")
+	s.Add(ctx, "c", time.Minute)
+	if contains("b") {
+		t.Error("b was not evicted")
+	}
+	if !contains("a") || !contains("c") {
+		t.Error("want a and c in store")
+	}
+
+	now = now.Add(time.Minute)
+	if contains("a") || contains("c") {
+		t.Error("expired keys still in store")
+	}
+	if got := s.ll.Len(); got != 0 {
+		t.Errorf("got %d entries after expiry, want 0", got)
+	}
+}
+
+func TestMemoryDedupStoreUnbounded(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryDedupStore(0)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		s.Add(ctx, k, time.Hour)
+	}
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if ok, _ := s.Contains(ctx, k); !ok {
+			t.Errorf("%s: not in store", k)
+		}
+	}
+}
+
+func TestReceiveDeduplication(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, srv := newFake(t)
+	defer client.Close()
+	defer srv.Close()
+
+	topic := mustCreateTopic(t, client, "t")
+	sub, err := client.CreateSubscription(ctx, "s", SubscriptionConfig{Topic: topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryDedupStore(100)
+	store.Add(ctx, "c", time.Hour)
+	sub.ReceiveSettings.Deduplication = &DeduplicationSettings{
+		Store:     store,
+		Attribute: "key",
+	}
+
+	var mu sync.Mutex
+	handled := map[string]int{}
+	// receive publishes messages with the given keys, and receives until the
+	// server has seen an ack for every message published so far.
+	published := 0
+	receive := func(keys ...string) {
+		for _, k := range keys {
+			srv.Publish(topic.name, []byte(k), map[string]string{"key": k})
+		}
+		published += len(keys)
+		rctx, rcancel := context.WithCancel(ctx)
+		go func() {
+			defer rcancel()
+			for rctx.Err() == nil {
+				acked := 0
+				for _, m := range srv.Messages() {
+					if m.Acks > 0 {
+						acked++
+					}
+				}
+				if acked == published {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+		err := sub.Receive(rctx, func(_ context.Context, m *Message) {
+			mu.Lock()
+			handled[string(m.Data)]++
+			mu.Unlock()
+			m.Ack()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive("a", "b", "c")
+	// Redeliveries of processed messages are acked without being handled.
+	receive("a")
+	if ctx.Err() != nil {
+		t.Fatal("timed out waiting for acks")
+	}
+
+	want := map[string]int{"a": 1, "b": 1}
+	for k, n := range want {
+		if handled[k] != n {
+			t.Errorf("%s: handled %d times, want %d", k, handled[k], n)
+		}
+	}
+	if handled["c"] != 0 {
+		t.Errorf("c: handled %d times, want 0", handled["c"])
+	}
+	for _, k := range []string{"a", "b"} {
+		if ok, _ := store.Contains(ctx, k); !ok {
+			t.Errorf("%s: not recorded in store after ack", k)
+		}
+	}
+}
+
+func TestDeduplicatorInFlight(t *testing.T) {
+	d := newDeduplicator(&DeduplicationSettings{Store: NewMemoryDedupStore(0)})
+	done := make(chan bool, 2)
+	newMsg := func() *Message {
+		msg := ipubsub.NewMessage(&psAckHandler{doneFunc: func(_ string, ack bool, _ time.Time) {
+			done <- ack
+		}})
+		msg.ID = "m"
+		return msg
+	}
+	noDrop := func() { t.Error("message dropped, want it handled") }
+
+	first := newMsg()
+	if !d.filter(context.Background(), first, noDrop) {
+		t.Fatal("filter: got false for the first delivery, want true")
+	}
+	// A redelivery while the first delivery is handled is dropped, without
+	// being acked or nacked.
+	dropped := false
+	if d.filter(context.Background(), newMsg(), func() { dropped = true }) {
+		t.Fatal("filter: got true for a redelivery in flight, want false")
+	}
+	if !dropped {
+		t.Error("redelivery in flight was not dropped")
+	}
+	select {
+	case ack := <-done:
+		t.Errorf("redelivery in flight was acked or nacked (ack %t)", ack)
+	default:
+	}
+
+	first.Ack()
+	<-done
+	d.wait()
+	// Once the key is recorded, a redelivery is acked.
+	if d.filter(context.Background(), newMsg(), noDrop) {
+		t.Fatal("filter: got true for a processed message, want false")
+	}
+	if ack := <-done; !ack {
+		t.Error("got nack for a processed message, want ack")
+	}
+}
+
+func TestDeduplicatorAddAfterWait(t *testing.T) {
+	store := NewMemoryDedupStore(0)
+	d := newDeduplicator(&DeduplicationSettings{Store: store})
+	msg := ipubsub.NewMessage(&psAckHandler{doneFunc: func(string, bool, time.Time) {}})
+	msg.ID = "m"
+	if !d.filter(context.Background(), msg, func() {}) {
+		t.Fatal("filter: got false, want true")
+	}
+	// Receive has returned, so the key is not recorded.
+	d.wait()
+	msg.Ack()
+	if ok, _ := store.Contains(context.Background(), "m"); ok {
+		t.Error("key recorded after wait")
+	}
+	d.mu.Lock()
+	busy := d.inFlight["m"]
+	d.mu.Unlock()
+	if busy {
+		t.Error("key still in flight")
+	}
+}
+
+// blockingDedupStore is a DedupStore whose calls block until their context is
+// done.
+type blockingDedupStore struct{}
+
+func (blockingDedupStore) Contains(ctx context.Context, _ string) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func (blockingDedupStore) Add(ctx context.Context, _ string, _ time.Duration) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestDeduplicatorStoreTimeout(t *testing.T) {
+	d := newDeduplicator(&DeduplicationSettings{
+		Store:   blockingDedupStore{},
+		Timeout: 50 * time.Millisecond,
+	})
+	acked := make(chan bool, 1)
+	msg := ipubsub.NewMessage(&psAckHandler{doneFunc: func(_ string, ack bool, _ time.Time) {
+		acked <- ack
+	}})
+	msg.ID = "m"
+
+	// A lookup that times out lets the message through.
+	start := time.Now()
+	if !d.filter(context.Background(), msg, func() {}) {
+		t.Fatal("filter: got false, want true")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("filter took %s, want about the timeout", elapsed)
+	}
+
+	// Acking does not wait for the store.
+	start = time.Now()
+	msg.Ack()
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Errorf("Ack took %s, want it not to wait for DedupStore.Add", elapsed)
+	}
+	if ack := <-acked; !ack {
+		t.Fatal("got nack, want ack")
+	}
+	// The key stays in flight until Add gives up.
+	d.mu.Lock()
+	busy := d.inFlight["m"]
+	d.mu.Unlock()
+	if !busy {
+		t.Error("key released before it was recorded")
+	}
+	d.wait()
+	d.mu.Lock()
+	busy = d.inFlight["m"]
+	d.mu.Unlock()
+	if busy {
+		t.Error("key still in flight after Add returned")
+	}
+}
@@ -161,6 +161,15 @@ func (it *messageIterator) done(ackID string, ack bool, receiveTime time.Time) {
 	it.checkDrained()
 }
 
+// drop stops extending the ack deadline of a message, without acking or
+// nacking it, so that it is redelivered once the deadline expires.
+func (it *messageIterator) drop(ackID string) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	delete(it.keepAliveDeadlines, ackID)
+	it.checkDrained()
+}
+
 // fail is called when a stream method returns a permanent error.
 // fail returns it.err. This may be err, or it may be the error
 // set by an earlier call to fail.
@@ -536,6 +536,13 @@ type ReceiveSettings struct {
 	// processed, rather than in memory. NumGoroutines is ignored.
 	// The default is false.
 	Synchronous bool
+
+	// Deduplication, if non-nil, enables skipping messages that have already
+	// been processed, as identified by Message.ID or a message attribute.
+	// See DeduplicationSettings for details.
+	// The default is nil, meaning that every delivery of a message is passed
+	// to the handler.
+	Deduplication *DeduplicationSettings
 }
 
 // For synchronous receive, the time to wait if we are already processing
@@ -865,6 +872,16 @@ func (s *Subscription) Receive(ctx context.Context, f func(context.Context, *Mes
 
 	sched := scheduler.NewReceiveScheduler(maxCount)
 
+	var dedup *deduplicator
+	var dedupCtx context.Context
+	if ds := s.ReceiveSettings.Deduplication; ds != nil {
+		if ds.Store == nil {
+			return errors.New("pubsub: ReceiveSettings.Deduplication.Store must be set")
+		}
+		dedup = newDeduplicator(ds)
+		dedupCtx = withSubscriptionKey(ctx, s.name)
+	}
+
 	// Wait for all goroutines started by Receive to return, so instead of an
 	// obscure goroutine leak we have an obvious blocked call to Receive.
 	group, gctx := errgroup.WithContext(ctx)
@@ -960,6 +977,15 @@ func (s *Subscription) Receive(ctx context.Context, f func(context.Context, *Mes
 					// constructor level?
 					if err := sched.Add(key, msg, func(msg interface{}) {
 						defer wg.Done()
+						if dedup != nil && !dedup.filter(dedupCtx, msg.(*Message), func() {
+							// Stop extending the ack deadline of a duplicate,
+							// without acking or nacking it.
+							ackh.calledDone = true
+							iter.drop(ackh.ackID)
+							fc.release(ctx, msgLen)
+						}) {
+							return
+						}
 						f(ctx2, msg.(*Message))
 					}); err != nil {
 						wg.Done()
@@ -985,7 +1011,12 @@ func (s *Subscription) Receive(ctx context.Context, f func(context.Context, *Mes
 		sched.Shutdown()
 	}()
 
-	return group.Wait()
+	err := group.Wait()
+	if dedup != nil {
+		// Wait for the keys of acked messages to be recorded.
+		dedup.wait()
+	}
+	return err
 }
 
 // checkOrdering calls Config to check theEnableMessageOrdering field.
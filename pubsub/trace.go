@@ -92,6 +92,16 @@ var (
 	// OutstandingBytes is a measure of the number of bytes all outstanding messages held by the client take up.
 	// It is EXPERIMENTAL and subject to change or removal without notice.
 	OutstandingBytes = stats.Int64(statsPrefix+"outstanding_bytes", "Number of outstanding bytes", stats.UnitDimensionless)
+
+	// DuplicateCount is a measure of the number of messages skipped as duplicates
+	// when ReceiveSettings.Deduplication is set.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	DuplicateCount = stats.Int64(statsPrefix+"duplicate_count", "Number of duplicate PubSub messages skipped", stats.UnitDimensionless)
+
+	// DedupStoreErrorCount is a measure of the number of failed or timed out
+	// calls to ReceiveSettings.Deduplication.Store.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	DedupStoreErrorCount = stats.Int64(statsPrefix+"dedup_store_error_count", "Number of failed deduplication store calls", stats.UnitDimensionless)
 )
 
 var (
@@ -146,6 +156,14 @@ var (
 	// OutstandingBytesView is the last value of OutstandingBytes
 	// It is EXPERIMENTAL and subject to change or removal without notice.
 	OutstandingBytesView *view.View
+
+	// DuplicateCountView is a cumulative sum of DuplicateCount.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	DuplicateCountView *view.View
+
+	// DedupStoreErrorCountView is a cumulative sum of DedupStoreErrorCount.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	DedupStoreErrorCountView *view.View
 )
 
 func init() {
@@ -162,6 +180,8 @@ func init() {
 	StreamResponseCountView = createCountView(StreamResponseCount, keySubscription)
 	OutstandingMessagesView = createLastValueView(OutstandingMessages, keySubscription)
 	OutstandingBytesView = createLastValueView(OutstandingBytes, keySubscription)
+	DuplicateCountView = createCountView(DuplicateCount, keySubscription)
+	DedupStoreErrorCountView = createCountView(DedupStoreErrorCount, keySubscription)
 
 	DefaultPublishViews = []*view.View{
 		PublishedMessagesView,
@@ -180,6 +200,8 @@ func init() {
 		StreamResponseCountView,
 		OutstandingMessagesView,
 		OutstandingBytesView,
+		DuplicateCountView,
+		DedupStoreErrorCountView,
 	}
 }
 
@@ -27,6 +27,7 @@ import (
 var (
 	errNoTopicFieldsUpdated        = errors.New("pubsublite: no fields updated for topic")
 	errNoSubscriptionFieldsUpdated = errors.New("pubsublite: no fields updated for subscription")
+	errNoReservationFieldsUpdated  = errors.New("pubsublite: no fields updated for reservation")
 )
 
 // AdminClient provides admin operations for Pub/Sub Lite resources within a
@@ -37,6 +38,9 @@ var (
 // An AdminClient may be shared by multiple goroutines.
 type AdminClient struct {
 	admin *vkit.AdminClient
+	// The topic stats and cursor clients share the admin client's connection.
+	stats  *vkit.TopicStatsClient
+	cursor *vkit.CursorClient
 }
 
 // NewAdminClient creates a new Pub/Sub Lite client to perform admin operations
@@ -49,7 +53,18 @@ func NewAdminClient(ctx context.Context, region string, opts ...option.ClientOpt
 	if err != nil {
 		return nil, err
 	}
-	return &AdminClient{admin: admin}, nil
+	conn := option.WithGRPCConn(admin.Connection())
+	stats, err := vkit.NewTopicStatsClient(ctx, conn)
+	if err != nil {
+		admin.Close()
+		return nil, err
+	}
+	cursor, err := vkit.NewCursorClient(ctx, conn)
+	if err != nil {
+		admin.Close()
+		return nil, err
+	}
+	return &AdminClient{admin: admin, stats: stats, cursor: cursor}, nil
 }
 
 // CreateTopic creates a new topic from the given config. If the topic already
@@ -273,6 +288,90 @@ func (ac *AdminClient) Subscriptions(ctx context.Context, parent string) *Subscr
 	}
 }
 
+// CreateReservation creates a new reservation from the given config. If the
+// reservation already exists an error will be returned.
+func (ac *AdminClient) CreateReservation(ctx context.Context, config ReservationConfig) (*ReservationConfig, error) {
+	reservationPath, err := wire.ParseReservationPath(config.Name)
+	if err != nil {
+		return nil, err
+	}
+	req := &pb.CreateReservationRequest{
+		Parent:        reservationPath.Location().String(),
+		Reservation:   config.toProto(),
+		ReservationId: reservationPath.ReservationID,
+	}
+	reservationpb, err := ac.admin.CreateReservation(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return protoToReservationConfig(reservationpb), nil
+}
+
+// UpdateReservation updates an existing reservation from the given config and
+// returns the new reservation config. UpdateReservation returns an error if no
+// fields were modified.
+func (ac *AdminClient) UpdateReservation(ctx context.Context, config ReservationConfigToUpdate) (*ReservationConfig, error) {
+	if _, err := wire.ParseReservationPath(config.Name); err != nil {
+		return nil, err
+	}
+	req := config.toUpdateRequest()
+	if len(req.GetUpdateMask().GetPaths()) == 0 {
+		return nil, errNoReservationFieldsUpdated
+	}
+	reservationpb, err := ac.admin.UpdateReservation(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return protoToReservationConfig(reservationpb), nil
+}
+
+// DeleteReservation deletes a reservation. A valid reservation path has the
+// format: "projects/PROJECT_ID/locations/REGION/reservations/RESERVATION_ID".
+func (ac *AdminClient) DeleteReservation(ctx context.Context, reservation string) error {
+	if _, err := wire.ParseReservationPath(reservation); err != nil {
+		return err
+	}
+	return ac.admin.DeleteReservation(ctx, &pb.DeleteReservationRequest{Name: reservation})
+}
+
+// Reservation retrieves the configuration of a reservation. A valid
+// reservation path has the format:
+// "projects/PROJECT_ID/locations/REGION/reservations/RESERVATION_ID".
+func (ac *AdminClient) Reservation(ctx context.Context, reservation string) (*ReservationConfig, error) {
+	if _, err := wire.ParseReservationPath(reservation); err != nil {
+		return nil, err
+	}
+	reservationpb, err := ac.admin.GetReservation(ctx, &pb.GetReservationRequest{Name: reservation})
+	if err != nil {
+		return nil, err
+	}
+	return protoToReservationConfig(reservationpb), nil
+}
+
+// Reservations retrieves the list of reservation configs for a given project
+// and region. A valid parent path has the format:
+// "projects/PROJECT_ID/locations/REGION".
+func (ac *AdminClient) Reservations(ctx context.Context, parent string) *ReservationIterator {
+	if _, err := wire.ParseLocationPath(parent); err != nil {
+		return &ReservationIterator{err: err}
+	}
+	return &ReservationIterator{
+		it: ac.admin.ListReservations(ctx, &pb.ListReservationsRequest{Parent: parent}),
+	}
+}
+
+// ReservationTopics retrieves the list of topic paths for a reservation.
+// A valid reservation path has the format:
+// "projects/PROJECT_ID/locations/REGION/reservations/RESERVATION_ID".
+func (ac *AdminClient) ReservationTopics(ctx context.Context, reservation string) *TopicPathIterator {
+	if _, err := wire.ParseReservationPath(reservation); err != nil {
+		return &TopicPathIterator{err: err}
+	}
+	return &TopicPathIterator{
+		it: ac.admin.ListReservationTopics(ctx, &pb.ListReservationTopicsRequest{Name: reservation}),
+	}
+}
+
 // Close releases any resources held by the client when it is no longer
 // required. If the client is available for the lifetime of the program, then
 // Close need not be called at exit.
@@ -340,3 +439,43 @@ func (sp *SubscriptionPathIterator) Next() (string, error) {
 	}
 	return subsPath, nil
 }
+
+// ReservationIterator is an iterator that returns a list of reservation
+// configs.
+type ReservationIterator struct {
+	it  *vkit.ReservationIterator
+	err error
+}
+
+// Next returns the next reservation config. The second return value will be
+// iterator.Done if there are no more reservation configs.
+func (r *ReservationIterator) Next() (*ReservationConfig, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	reservationpb, err := r.it.Next()
+	if err != nil {
+		return nil, err
+	}
+	return protoToReservationConfig(reservationpb), nil
+}
+
+// TopicPathIterator is an iterator that returns a list of topic paths.
+type TopicPathIterator struct {
+	it  *vkit.StringIterator
+	err error
+}
+
+// Next returns the next topic path, which has format:
+// "projects/PROJECT_ID/locations/ZONE/topics/TOPIC_ID". The second return
+// value will be iterator.Done if there are no more topic paths.
+func (tp *TopicPathIterator) Next() (string, error) {
+	if tp.err != nil {
+		return "", tp.err
+	}
+	topicPath, err := tp.it.Next()
+	if err != nil {
+		return "", err
+	}
+	return topicPath, nil
+}
@@ -20,6 +20,8 @@ import (
 
 	"cloud.google.com/go/internal/testutil"
 	"cloud.google.com/go/pubsublite/internal/test"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -453,6 +455,20 @@ func TestAdminValidateResourcePaths(t *testing.T) {
 	if _, err := subsIt.Next(); err == nil {
 		t.Errorf("SubscriptionIterator.Next() should fail")
 	}
+	if _, err := admin.Reservation(ctx, "INVALID"); err == nil {
+		t.Errorf("Reservation() should fail")
+	}
+	if err := admin.DeleteReservation(ctx, "INVALID"); err == nil {
+		t.Errorf("DeleteReservation() should fail")
+	}
+	reservationIt := admin.Reservations(ctx, "INVALID")
+	if _, err := reservationIt.Next(); err == nil {
+		t.Errorf("ReservationIterator.Next() should fail")
+	}
+	topicPathIt := admin.ReservationTopics(ctx, "INVALID")
+	if _, err := topicPathIt.Next(); err == nil {
+		t.Errorf("TopicPathIterator.Next() should fail")
+	}
 }
 
 func TestAdminSeekSubscription(t *testing.T) {
@@ -657,3 +673,357 @@ func TestAdminSeekSubscription(t *testing.T) {
 		})
 	}
 }
+
+func TestAdminReservationCRUD(t *testing.T) {
+	ctx := context.Background()
+
+	// Inputs
+	const reservationPath = "projects/my-proj/locations/us-central1/reservations/my-reservation"
+	reservationConfig := ReservationConfig{
+		Name:               reservationPath,
+		ThroughputCapacity: 10,
+	}
+	updateConfig := ReservationConfigToUpdate{
+		Name:               reservationPath,
+		ThroughputCapacity: 20,
+	}
+	emptyUpdateConfig := ReservationConfigToUpdate{
+		Name: reservationPath,
+	}
+
+	// Expected requests and fake responses
+	wantCreateReq := &pb.CreateReservationRequest{
+		Parent:        "projects/my-proj/locations/us-central1",
+		ReservationId: "my-reservation",
+		Reservation:   reservationConfig.toProto(),
+	}
+	wantUpdateReq := updateConfig.toUpdateRequest()
+	wantGetReq := &pb.GetReservationRequest{
+		Name: "projects/my-proj/locations/us-central1/reservations/my-reservation",
+	}
+	wantDeleteReq := &pb.DeleteReservationRequest{
+		Name: "projects/my-proj/locations/us-central1/reservations/my-reservation",
+	}
+
+	verifiers := test.NewVerifiers(t)
+	verifiers.GlobalVerifier.Push(wantCreateReq, reservationConfig.toProto(), nil)
+	verifiers.GlobalVerifier.Push(wantUpdateReq, reservationConfig.toProto(), nil)
+	verifiers.GlobalVerifier.Push(wantGetReq, reservationConfig.toProto(), nil)
+	verifiers.GlobalVerifier.Push(wantDeleteReq, &emptypb.Empty{}, nil)
+	mockServer.OnTestStart(verifiers)
+	defer mockServer.OnTestEnd()
+
+	admin := newTestAdminClient(t)
+	defer admin.Close()
+
+	if gotConfig, err := admin.CreateReservation(ctx, reservationConfig); err != nil {
+		t.Errorf("CreateReservation() got err: %v", err)
+	} else if !testutil.Equal(gotConfig, &reservationConfig) {
+		t.Errorf("CreateReservation() got: %v\nwant: %v", gotConfig, reservationConfig)
+	}
+
+	if gotConfig, err := admin.UpdateReservation(ctx, updateConfig); err != nil {
+		t.Errorf("UpdateReservation() got err: %v", err)
+	} else if !testutil.Equal(gotConfig, &reservationConfig) {
+		t.Errorf("UpdateReservation() got: %v\nwant: %v", gotConfig, reservationConfig)
+	}
+
+	if _, err := admin.UpdateReservation(ctx, emptyUpdateConfig); !test.ErrorEqual(err, errNoReservationFieldsUpdated) {
+		t.Errorf("UpdateReservation() got err: (%v), want err: (%v)", err, errNoReservationFieldsUpdated)
+	}
+
+	if gotConfig, err := admin.Reservation(ctx, reservationPath); err != nil {
+		t.Errorf("Reservation() got err: %v", err)
+	} else if !testutil.Equal(gotConfig, &reservationConfig) {
+		t.Errorf("Reservation() got: %v\nwant: %v", gotConfig, reservationConfig)
+	}
+
+	if err := admin.DeleteReservation(ctx, reservationPath); err != nil {
+		t.Errorf("DeleteReservation() got err: %v", err)
+	}
+}
+
+func TestAdminListReservations(t *testing.T) {
+	ctx := context.Background()
+
+	// Inputs
+	const locationPath = "projects/my-proj/locations/us-central1"
+	reservationConfig1 := ReservationConfig{
+		Name:               "projects/my-proj/locations/us-central1/reservations/reservation1",
+		ThroughputCapacity: 10,
+	}
+	reservationConfig2 := ReservationConfig{
+		Name:               "projects/my-proj/locations/us-central1/reservations/reservation2",
+		ThroughputCapacity: 20,
+	}
+	reservationConfig3 := ReservationConfig{
+		Name:               "projects/my-proj/locations/us-central1/reservations/reservation3",
+		ThroughputCapacity: 30,
+	}
+
+	// Expected requests and fake responses
+	wantListReq1 := &pb.ListReservationsRequest{
+		Parent: "projects/my-proj/locations/us-central1",
+	}
+	listResp1 := &pb.ListReservationsResponse{
+		Reservations:  []*pb.Reservation{reservationConfig1.toProto(), reservationConfig2.toProto()},
+		NextPageToken: "next_token",
+	}
+	wantListReq2 := &pb.ListReservationsRequest{
+		Parent:    "projects/my-proj/locations/us-central1",
+		PageToken: "next_token",
+	}
+	listResp2 := &pb.ListReservationsResponse{
+		Reservations: []*pb.Reservation{reservationConfig3.toProto()},
+	}
+
+	verifiers := test.NewVerifiers(t)
+	verifiers.GlobalVerifier.Push(wantListReq1, listResp1, nil)
+	verifiers.GlobalVerifier.Push(wantListReq2, listResp2, nil)
+	mockServer.OnTestStart(verifiers)
+	defer mockServer.OnTestEnd()
+
+	admin := newTestAdminClient(t)
+	defer admin.Close()
+
+	var gotReservationConfigs []*ReservationConfig
+	reservationIt := admin.Reservations(ctx, locationPath)
+	for {
+		reservation, err := reservationIt.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Errorf("ReservationIterator.Next() got err: %v", err)
+		} else {
+			gotReservationConfigs = append(gotReservationConfigs, reservation)
+		}
+	}
+
+	wantReservationConfigs := []*ReservationConfig{&reservationConfig1, &reservationConfig2, &reservationConfig3}
+	if diff := testutil.Diff(gotReservationConfigs, wantReservationConfigs); diff != "" {
+		t.Errorf("Reservations() got: -, want: +\n%s", diff)
+	}
+}
+
+func TestAdminListReservationTopics(t *testing.T) {
+	ctx := context.Background()
+
+	// Inputs
+	const (
+		reservationPath = "projects/my-proj/locations/us-central1/reservations/my-reservation"
+		topic1          = "projects/my-proj/locations/us-central1-a/topics/topic1"
+		topic2          = "projects/my-proj/locations/us-central1-b/topics/topic2"
+		topic3          = "projects/my-proj/locations/us-central1-c/topics/topic3"
+	)
+
+	// Expected requests and fake responses
+	wantListReq1 := &pb.ListReservationTopicsRequest{
+		Name: "projects/my-proj/locations/us-central1/reservations/my-reservation",
+	}
+	listResp1 := &pb.ListReservationTopicsResponse{
+		Topics:        []string{topic1, topic2},
+		NextPageToken: "next_token",
+	}
+	wantListReq2 := &pb.ListReservationTopicsRequest{
+		Name:      "projects/my-proj/locations/us-central1/reservations/my-reservation",
+		PageToken: "next_token",
+	}
+	listResp2 := &pb.ListReservationTopicsResponse{
+		Topics: []string{topic3},
+	}
+
+	verifiers := test.NewVerifiers(t)
+	verifiers.GlobalVerifier.Push(wantListReq1, listResp1, nil)
+	verifiers.GlobalVerifier.Push(wantListReq2, listResp2, nil)
+	mockServer.OnTestStart(verifiers)
+	defer mockServer.OnTestEnd()
+
+	admin := newTestAdminClient(t)
+	defer admin.Close()
+
+	var gotTopics []string
+	topicPathIt := admin.ReservationTopics(ctx, reservationPath)
+	for {
+		topicPath, err := topicPathIt.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Errorf("TopicPathIterator.Next() got err: %v", err)
+		} else {
+			gotTopics = append(gotTopics, topicPath)
+		}
+	}
+
+	wantTopics := []string{topic1, topic2, topic3}
+	if !testutil.Equal(gotTopics, wantTopics) {
+		t.Errorf("ReservationTopics() got: %v\nwant: %v", gotTopics, wantTopics)
+	}
+}
+
+func TestAdminSubscriptionBacklog(t *testing.T) {
+	ctx := context.Background()
+
+	// Inputs
+	const (
+		subscriptionPath = "projects/my-proj/locations/us-central1-a/subscriptions/my-subs"
+		topicPath        = "projects/my-proj/locations/us-central1-a/topics/my-topic"
+	)
+	oldestPublishTime := time.Unix(1600000000, 0).UTC()
+
+	// Expected requests and fake responses
+	wantGetSubsReq := &pb.GetSubscriptionRequest{Name: subscriptionPath}
+	subspb := &pb.Subscription{Name: subscriptionPath, Topic: topicPath}
+	wantPartitionsReq := &pb.GetTopicPartitionsRequest{Name: topicPath}
+	wantCursorsReq := &pb.ListPartitionCursorsRequest{Parent: subscriptionPath}
+	cursorsResp := &pb.ListPartitionCursorsResponse{
+		PartitionCursors: []*pb.PartitionCursor{
+			{Partition: 0, Cursor: &pb.Cursor{Offset: 5}},
+			{Partition: 1, Cursor: &pb.Cursor{Offset: 7}},
+		},
+	}
+	wantHeadReq0 := &pb.ComputeHeadCursorRequest{Topic: topicPath, Partition: 0}
+	wantStatsReq0 := &pb.ComputeMessageStatsRequest{
+		Topic:       topicPath,
+		Partition:   0,
+		StartCursor: &pb.Cursor{Offset: 5},
+		EndCursor:   &pb.Cursor{Offset: 10},
+	}
+	statsResp0 := &pb.ComputeMessageStatsResponse{
+		MessageCount:       5,
+		MessageBytes:       500,
+		MinimumPublishTime: &tspb.Timestamp{Seconds: 1600000000},
+	}
+	wantHeadReq1 := &pb.ComputeHeadCursorRequest{Topic: topicPath, Partition: 1}
+	wantHeadReq2 := &pb.ComputeHeadCursorRequest{Topic: topicPath, Partition: 2}
+	wantStatsReq2 := &pb.ComputeMessageStatsRequest{
+		Topic:       topicPath,
+		Partition:   2,
+		StartCursor: &pb.Cursor{Offset: 0},
+		EndCursor:   &pb.Cursor{Offset: 3},
+	}
+	statsResp2 := &pb.ComputeMessageStatsResponse{
+		MessageCount:       3,
+		MessageBytes:       300,
+		MinimumPublishTime: &tspb.Timestamp{Seconds: 1600000000},
+	}
+
+	verifiers := test.NewVerifiers(t)
+	verifiers.GlobalVerifier.Push(wantGetSubsReq, subspb, nil)
+	verifiers.GlobalVerifier.Push(wantPartitionsReq, &pb.TopicPartitions{PartitionCount: 3}, nil)
+	verifiers.GlobalVerifier.Push(wantCursorsReq, cursorsResp, nil)
+	verifiers.GlobalVerifier.Push(wantHeadReq0, &pb.ComputeHeadCursorResponse{HeadCursor: &pb.Cursor{Offset: 10}}, nil)
+	verifiers.GlobalVerifier.Push(wantStatsReq0, statsResp0, nil)
+	// No message stats are requested for partition 1, which has no backlog.
+	verifiers.GlobalVerifier.Push(wantHeadReq1, &pb.ComputeHeadCursorResponse{HeadCursor: &pb.Cursor{Offset: 7}}, nil)
+	// Partition 2 has no committed cursor.
+	verifiers.GlobalVerifier.Push(wantHeadReq2, &pb.ComputeHeadCursorResponse{HeadCursor: &pb.Cursor{Offset: 3}}, nil)
+	verifiers.GlobalVerifier.Push(wantStatsReq2, statsResp2, nil)
+	mockServer.OnTestStart(verifiers)
+	defer mockServer.OnTestEnd()
+
+	admin := newTestAdminClient(t)
+	defer admin.Close()
+
+	gotBacklogs, err := admin.SubscriptionBacklog(ctx, subscriptionPath)
+	if err != nil {
+		t.Fatalf("SubscriptionBacklog() got err: %v", err)
+	}
+	wantBacklogs := []*PartitionBacklog{
+		{
+			Partition:                0,
+			HeadOffset:               10,
+			CommittedOffset:          5,
+			MessageCount:             5,
+			Bytes:                    500,
+			OldestUnackedPublishTime: oldestPublishTime,
+		},
+		{
+			Partition:       1,
+			HeadOffset:      7,
+			CommittedOffset: 7,
+		},
+		{
+			Partition:                2,
+			HeadOffset:               3,
+			MessageCount:             3,
+			Bytes:                    300,
+			OldestUnackedPublishTime: oldestPublishTime,
+		},
+	}
+	if diff := testutil.Diff(gotBacklogs, wantBacklogs); diff != "" {
+		t.Errorf("SubscriptionBacklog() got: -, want: +\n%s", diff)
+	}
+}
+
+func TestAdminExportBacklogMetricsInvalidArgs(t *testing.T) {
+	ctx := context.Background()
+	admin := newTestAdminClient(t)
+	defer admin.Close()
+
+	if err := admin.ExportBacklogMetrics(ctx, 0); !test.ErrorEqual(err, errInvalidExportInterval) {
+		t.Errorf("ExportBacklogMetrics() got err: (%v), want err: (%v)", err, errInvalidExportInterval)
+	}
+	if err := admin.ExportBacklogMetrics(ctx, time.Minute, "INVALID"); err == nil {
+		t.Errorf("ExportBacklogMetrics() should fail")
+	}
+}
+
+func TestAdminExportBacklogMetricsSkipsErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const (
+		badSubscription  = "projects/my-proj/locations/us-central1-a/subscriptions/bad-subs"
+		goodSubscription = "projects/my-proj/locations/us-central1-a/subscriptions/good-subs"
+		topicPath        = "projects/my-proj/locations/us-central1-a/topics/my-topic"
+	)
+
+	verifiers := test.NewVerifiers(t)
+	verifiers.GlobalVerifier.Push(&pb.GetSubscriptionRequest{Name: badSubscription}, nil, status.Error(codes.NotFound, "not found"))
+	verifiers.GlobalVerifier.Push(&pb.GetSubscriptionRequest{Name: goodSubscription}, &pb.Subscription{Name: goodSubscription, Topic: topicPath}, nil)
+	verifiers.GlobalVerifier.Push(&pb.GetTopicPartitionsRequest{Name: topicPath}, &pb.TopicPartitions{PartitionCount: 1}, nil)
+	verifiers.GlobalVerifier.Push(&pb.ListPartitionCursorsRequest{Parent: goodSubscription}, &pb.ListPartitionCursorsResponse{}, nil)
+	verifiers.GlobalVerifier.Push(&pb.ComputeHeadCursorRequest{Topic: topicPath, Partition: 0}, &pb.ComputeHeadCursorResponse{HeadCursor: &pb.Cursor{Offset: 0}}, nil)
+	mockServer.OnTestStart(verifiers)
+	defer mockServer.OnTestEnd()
+
+	if err := view.Register(BacklogMessageCountView, BacklogErrorCountView); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(BacklogMessageCountView, BacklogErrorCountView)
+
+	admin := newTestAdminClient(t)
+	defer admin.Close()
+
+	// Stop exporting once the backlog of the good subscription is recorded.
+	go func() {
+		defer cancel()
+		for ctx.Err() == nil {
+			if rows, _ := view.RetrieveData(BacklogMessageCountView.Name); len(rows) > 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	if err := admin.ExportBacklogMetrics(ctx, time.Hour, badSubscription, goodSubscription); err != nil {
+		t.Fatalf("ExportBacklogMetrics() got err: %v", err)
+	}
+
+	rows, err := view.RetrieveData(BacklogErrorCountView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTag := tag.Tag{Key: KeySubscription, Value: badSubscription}
+	if len(rows) != 1 || len(rows[0].Tags) != 1 || rows[0].Tags[0] != wantTag || rows[0].Data.(*view.CountData).Value != 1 {
+		t.Errorf("BacklogErrorCountView got rows: %v, want one count for %s", rows, badSubscription)
+	}
+	rows, err = view.RetrieveData(BacklogMessageCountView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Errorf("BacklogMessageCountView got %d rows, want 1", len(rows))
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsublite
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/pubsublite/internal/wire"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"google.golang.org/api/iterator"
+
+	pb "google.golang.org/genproto/googleapis/cloud/pubsublite/v1"
+)
+
+var errInvalidExportInterval = errors.New("pubsublite: backlog metrics export interval must be > 0")
+
+// PartitionBacklog holds statistics about the messages in a topic partition
+// that have not yet been acknowledged by a subscription.
+type PartitionBacklog struct {
+	// The topic partition.
+	Partition int
+
+	// The offset of the next message to be published to the partition.
+	HeadOffset int64
+
+	// The offset committed by subscribers for the partition, which is the offset
+	// of the next message to be delivered. Zero if subscribers have not
+	// committed an offset for the partition.
+	CommittedOffset int64
+
+	// The number of messages between the committed offset and the head offset.
+	MessageCount int64
+
+	// The total size of messages between the committed offset and the head
+	// offset, in bytes.
+	Bytes int64
+
+	// The minimum publish time of messages between the committed offset and the
+	// head offset. Zero if there is no backlog.
+	OldestUnackedPublishTime time.Time
+}
+
+// SubscriptionBacklog computes the backlog of each partition of a
+// subscription's topic, i.e. the messages that have been published but not yet
+// acknowledged by subscribers. The result is ordered by partition. A valid
+// subscription path has the format:
+// "projects/PROJECT_ID/locations/ZONE/subscriptions/SUBSCRIPTION_ID".
+//
+// Backlog statistics are computed by the server and may lag behind the most
+// recently published messages and committed offsets.
+func (ac *AdminClient) SubscriptionBacklog(ctx context.Context, subscription string) ([]*PartitionBacklog, error) {
+	subs, err := ac.Subscription(ctx, subscription)
+	if err != nil {
+		return nil, err
+	}
+	partitionCount, err := ac.TopicPartitionCount(ctx, subs.Topic)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := ac.committedOffsets(ctx, subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	var backlogs []*PartitionBacklog
+	for p := 0; p < partitionCount; p++ {
+		head, err := ac.stats.ComputeHeadCursor(ctx, &pb.ComputeHeadCursorRequest{
+			Topic:     subs.Topic,
+			Partition: int64(p),
+		})
+		if err != nil {
+			return nil, err
+		}
+		backlog := &PartitionBacklog{
+			Partition:       p,
+			HeadOffset:      head.GetHeadCursor().GetOffset(),
+			CommittedOffset: committed[int64(p)],
+		}
+		if backlog.CommittedOffset < backlog.HeadOffset {
+			msgStats, err := ac.stats.ComputeMessageStats(ctx, &pb.ComputeMessageStatsRequest{
+				Topic:       subs.Topic,
+				Partition:   int64(p),
+				StartCursor: &pb.Cursor{Offset: backlog.CommittedOffset},
+				EndCursor:   &pb.Cursor{Offset: backlog.HeadOffset},
+			})
+			if err != nil {
+				return nil, err
+			}
+			backlog.MessageCount = msgStats.GetMessageCount()
+			backlog.Bytes = msgStats.GetMessageBytes()
+			if msgStats.GetMinimumPublishTime() != nil {
+				backlog.OldestUnackedPublishTime = msgStats.GetMinimumPublishTime().AsTime()
+			}
+		}
+		backlogs = append(backlogs, backlog)
+	}
+	return backlogs, nil
+}
+
+// committedOffsets returns the committed offsets of a subscription, keyed by
+// partition.
+func (ac *AdminClient) committedOffsets(ctx context.Context, subscription string) (map[int64]int64, error) {
+	offsets := make(map[int64]int64)
+	it := ac.cursor.ListPartitionCursors(ctx, &pb.ListPartitionCursorsRequest{Parent: subscription})
+	for {
+		cursor, err := it.Next()
+		if err == iterator.Done {
+			return offsets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		offsets[cursor.GetPartition()] = cursor.GetCursor().GetOffset()
+	}
+}
+
+// ExportBacklogMetrics computes the backlog of the given subscriptions every
+// interval and records it to the BacklogMessageCount, BacklogBytes and
+// OldestUnackedMessageAge measures, tagged with the subscription path and
+// partition. Register the views in DefaultBacklogViews (or custom views) with
+// OpenCensus to export the metrics.
+//
+// If computing the backlog of a subscription fails, the subscription is
+// skipped until the next interval and the BacklogErrorCount measure is
+// incremented. ExportBacklogMetrics blocks until ctx is done, and then returns
+// nil.
+func (ac *AdminClient) ExportBacklogMetrics(ctx context.Context, interval time.Duration, subscriptions ...string) error {
+	if interval <= 0 {
+		return errInvalidExportInterval
+	}
+	for _, subscription := range subscriptions {
+		if _, err := wire.ParseSubscriptionPath(subscription); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, subscription := range subscriptions {
+			backlogs, err := ac.SubscriptionBacklog(ctx, subscription)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				recordBacklogError(ctx, subscription)
+				continue
+			}
+			recordBacklog(ctx, subscription, backlogs, time.Now())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func recordBacklog(ctx context.Context, subscription string, backlogs []*PartitionBacklog, now time.Time) {
+	for _, backlog := range backlogs {
+		ctx, err := tag.New(ctx,
+			tag.Upsert(KeySubscription, subscription),
+			tag.Upsert(KeyPartition, strconv.Itoa(backlog.Partition)))
+		if err != nil {
+			continue
+		}
+		var ageMillis int64
+		if !backlog.OldestUnackedPublishTime.IsZero() {
+			ageMillis = now.Sub(backlog.OldestUnackedPublishTime).Milliseconds()
+		}
+		stats.Record(ctx,
+			BacklogMessageCount.M(backlog.MessageCount),
+			BacklogBytes.M(backlog.Bytes),
+			OldestUnackedMessageAge.M(ageMillis))
+	}
+}
+
+func recordBacklogError(ctx context.Context, subscription string) {
+	ctx, err := tag.New(ctx, tag.Upsert(KeySubscription, subscription))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, BacklogErrorCount.M(1))
+}
+
+const statsPrefix = "cloud.google.com/go/pubsublite/"
+
+var (
+	// KeySubscription tags backlog metrics with the subscription path.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	KeySubscription = tag.MustNewKey("subscription")
+
+	// KeyPartition tags backlog metrics with the topic partition.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	KeyPartition = tag.MustNewKey("partition")
+)
+
+var (
+	// BacklogMessageCount is a measure of the number of unacknowledged messages
+	// in a partition for a subscription.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	BacklogMessageCount = stats.Int64(statsPrefix+"backlog_message_count", "Number of unacknowledged Pub/Sub Lite messages", stats.UnitDimensionless)
+
+	// BacklogBytes is a measure of the total size of unacknowledged messages in
+	// a partition for a subscription.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	BacklogBytes = stats.Int64(statsPrefix+"backlog_bytes", "Size of unacknowledged Pub/Sub Lite messages", stats.UnitBytes)
+
+	// OldestUnackedMessageAge is a measure of the age in milliseconds of the
+	// oldest unacknowledged message in a partition for a subscription.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	OldestUnackedMessageAge = stats.Int64(statsPrefix+"oldest_unacked_message_age", "Age of the oldest unacknowledged Pub/Sub Lite message in milliseconds", stats.UnitMilliseconds)
+
+	// BacklogErrorCount is a measure of the number of times the backlog of a
+	// subscription could not be computed by ExportBacklogMetrics.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	BacklogErrorCount = stats.Int64(statsPrefix+"backlog_error_count", "Number of failures to compute a Pub/Sub Lite subscription backlog", stats.UnitDimensionless)
+)
+
+var (
+	// BacklogMessageCountView is the last value of BacklogMessageCount.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	BacklogMessageCountView = backlogView(BacklogMessageCount)
+
+	// BacklogBytesView is the last value of BacklogBytes.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	BacklogBytesView = backlogView(BacklogBytes)
+
+	// OldestUnackedMessageAgeView is the last value of OldestUnackedMessageAge.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	OldestUnackedMessageAgeView = backlogView(OldestUnackedMessageAge)
+
+	// BacklogErrorCountView is a cumulative sum of BacklogErrorCount.
+	// It is EXPERIMENTAL and subject to change or removal without notice.
+	BacklogErrorCountView = &view.View{
+		Name:        BacklogErrorCount.Name(),
+		Description: BacklogErrorCount.Description(),
+		TagKeys:     []tag.Key{KeySubscription},
+		Measure:     BacklogErrorCount,
+		Aggregation: view.Count(),
+	}
+)
+
+// DefaultBacklogViews are the views recorded by ExportBacklogMetrics.
+// It is EXPERIMENTAL and subject to change or removal without notice.
+var DefaultBacklogViews = []*view.View{
+	BacklogMessageCountView,
+	BacklogBytesView,
+	OldestUnackedMessageAgeView,
+	BacklogErrorCountView,
+}
+
+func backlogView(m stats.Measure) *view.View {
+	return &view.View{
+		Name:        m.Name(),
+		Description: m.Description(),
+		TagKeys:     []tag.Key{KeySubscription, KeyPartition},
+		Measure:     m,
+		Aggregation: view.LastValue(),
+	}
+}
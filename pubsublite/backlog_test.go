@@ -0,0 +1,71 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsublite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+)
+
+func TestRecordBacklog(t *testing.T) {
+	if err := view.Register(DefaultBacklogViews...); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(DefaultBacklogViews...)
+
+	const subscription = "projects/my-proj/locations/us-central1-a/subscriptions/my-subs"
+	now := time.Unix(1600000000, 0)
+	recordBacklog(context.Background(), subscription, []*PartitionBacklog{
+		{Partition: 0, MessageCount: 5, Bytes: 500, OldestUnackedPublishTime: now.Add(-2 * time.Second)},
+		{Partition: 1},
+	}, now)
+
+	for _, tc := range []struct {
+		view *view.View
+		want map[string]float64 // Keyed by partition.
+	}{
+		{view: BacklogMessageCountView, want: map[string]float64{"0": 5, "1": 0}},
+		{view: BacklogBytesView, want: map[string]float64{"0": 500, "1": 0}},
+		{view: OldestUnackedMessageAgeView, want: map[string]float64{"0": 2000, "1": 0}},
+	} {
+		rows, err := view.RetrieveData(tc.view.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]float64)
+		for _, row := range rows {
+			var partition string
+			for _, tg := range row.Tags {
+				switch tg.Key {
+				case KeyPartition:
+					partition = tg.Value
+				case KeySubscription:
+					if tg.Value != subscription {
+						t.Errorf("%s: got subscription tag %q, want %q", tc.view.Name, tg.Value, subscription)
+					}
+				}
+			}
+			got[partition] = row.Data.(*view.LastValueData).Value
+		}
+		for partition, want := range tc.want {
+			if got[partition] != want {
+				t.Errorf("%s: partition %s got %v, want %v", tc.view.Name, partition, got[partition], want)
+			}
+		}
+	}
+}
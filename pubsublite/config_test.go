@@ -87,6 +87,36 @@ func TestTopicConfigToProtoConversion(t *testing.T) {
 				RetentionDuration:          InfiniteRetention,
 			},
 		},
+		{
+			desc: "with throughput reservation",
+			topicpb: &pb.Topic{
+				Name: "projects/my-proj/locations/us-central1-c/topics/my-topic",
+				PartitionConfig: &pb.Topic_PartitionConfig{
+					Count: 1,
+					Dimension: &pb.Topic_PartitionConfig_Capacity_{
+						Capacity: &pb.Topic_PartitionConfig_Capacity{
+							PublishMibPerSec:   4,
+							SubscribeMibPerSec: 4,
+						},
+					},
+				},
+				RetentionConfig: &pb.Topic_RetentionConfig{
+					PerPartitionBytes: 4294967296,
+				},
+				ReservationConfig: &pb.Topic_ReservationConfig{
+					ThroughputReservation: "projects/my-proj/locations/us-central1/reservations/my-reservation",
+				},
+			},
+			wantConfig: &TopicConfig{
+				Name:                       "projects/my-proj/locations/us-central1-c/topics/my-topic",
+				PartitionCount:             1,
+				PublishCapacityMiBPerSec:   4,
+				SubscribeCapacityMiBPerSec: 4,
+				PerPartitionBytes:          4294967296,
+				RetentionDuration:          InfiniteRetention,
+				ThroughputReservation:      "projects/my-proj/locations/us-central1/reservations/my-reservation",
+			},
+		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
 			gotConfig, gotErr := protoToTopicConfig(tc.topicpb)
@@ -169,6 +199,30 @@ func TestTopicUpdateRequest(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "clear throughput reservation",
+			config: &TopicConfigToUpdate{
+				Name:                  "projects/my-proj/locations/us-central1-c/topics/my-topic",
+				ThroughputReservation: "",
+			},
+			want: &pb.UpdateTopicRequest{
+				Topic: &pb.Topic{
+					Name: "projects/my-proj/locations/us-central1-c/topics/my-topic",
+					PartitionConfig: &pb.Topic_PartitionConfig{
+						Dimension: &pb.Topic_PartitionConfig_Capacity_{
+							Capacity: &pb.Topic_PartitionConfig_Capacity{},
+						},
+					},
+					RetentionConfig:   &pb.Topic_RetentionConfig{},
+					ReservationConfig: &pb.Topic_ReservationConfig{},
+				},
+				UpdateMask: &fmpb.FieldMask{
+					Paths: []string{
+						"reservation_config.throughput_reservation",
+					},
+				},
+			},
+		},
 		{
 			desc: "no fields set",
 			config: &TopicConfigToUpdate{
@@ -291,3 +345,63 @@ func TestSubscriptionUpdateRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestReservationConfigToProtoConversion(t *testing.T) {
+	reservationpb := &pb.Reservation{
+		Name:               "projects/my-proj/locations/us-central1/reservations/my-reservation",
+		ThroughputCapacity: 10,
+	}
+	wantConfig := &ReservationConfig{
+		Name:               "projects/my-proj/locations/us-central1/reservations/my-reservation",
+		ThroughputCapacity: 10,
+	}
+	if gotConfig := protoToReservationConfig(reservationpb); !testutil.Equal(gotConfig, wantConfig) {
+		t.Errorf("protoToReservationConfig(%v)\ngot: %v\nwant: %v", reservationpb, gotConfig, wantConfig)
+	}
+	if gotProto := wantConfig.toProto(); !proto.Equal(gotProto, reservationpb) {
+		t.Errorf("ReservationConfig: %v toProto():\ngot: %v\nwant: %v", wantConfig, gotProto, reservationpb)
+	}
+}
+
+func TestReservationUpdateRequest(t *testing.T) {
+	for _, tc := range []struct {
+		desc   string
+		config *ReservationConfigToUpdate
+		want   *pb.UpdateReservationRequest
+	}{
+		{
+			desc: "all fields set",
+			config: &ReservationConfigToUpdate{
+				Name:               "projects/my-proj/locations/us-central1/reservations/my-reservation",
+				ThroughputCapacity: 20,
+			},
+			want: &pb.UpdateReservationRequest{
+				Reservation: &pb.Reservation{
+					Name:               "projects/my-proj/locations/us-central1/reservations/my-reservation",
+					ThroughputCapacity: 20,
+				},
+				UpdateMask: &fmpb.FieldMask{
+					Paths: []string{"throughput_capacity"},
+				},
+			},
+		},
+		{
+			desc: "no fields set",
+			config: &ReservationConfigToUpdate{
+				Name: "projects/my-proj/locations/us-central1/reservations/my-reservation",
+			},
+			want: &pb.UpdateReservationRequest{
+				Reservation: &pb.Reservation{
+					Name: "projects/my-proj/locations/us-central1/reservations/my-reservation",
+				},
+				UpdateMask: &fmpb.FieldMask{},
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := tc.config.toUpdateRequest(); !proto.Equal(got, tc.want) {
+				t.Errorf("ReservationConfigToUpdate(%v).toUpdateRequest():\ngot: %v\nwant: %v", tc.config, got, tc.want)
+			}
+		})
+	}
+}
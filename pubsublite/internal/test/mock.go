@@ -63,6 +63,7 @@ func NewServer() (*Server, error) {
 	pb.RegisterSubscriberServiceServer(srv.Gsrv, liteServer)
 	pb.RegisterCursorServiceServer(srv.Gsrv, liteServer)
 	pb.RegisterPartitionAssignmentServiceServer(srv.Gsrv, liteServer)
+	pb.RegisterTopicStatsServiceServer(srv.Gsrv, liteServer)
 	lrpb.RegisterOperationsServer(srv.Gsrv, liteServer)
 	srv.Start()
 	return &Server{LiteServer: liteServer, gRPCServer: srv}, nil
@@ -89,6 +90,7 @@ type mockLiteServer struct {
 	pb.SubscriberServiceServer
 	pb.CursorServiceServer
 	pb.PartitionAssignmentServiceServer
+	pb.TopicStatsServiceServer
 	lrpb.OperationsServer
 
 	mu sync.Mutex
@@ -457,6 +459,42 @@ func (s *mockLiteServer) ListReservationTopics(ctx context.Context, req *pb.List
 	return resp, nil
 }
 
+func (s *mockLiteServer) ListPartitionCursors(ctx context.Context, req *pb.ListPartitionCursorsRequest) (*pb.ListPartitionCursorsResponse, error) {
+	retResponse, retErr := s.popGlobalVerifiers(req)
+	if retErr != nil {
+		return nil, retErr
+	}
+	resp, ok := retResponse.(*pb.ListPartitionCursorsResponse)
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "mockserver: invalid response type %T", retResponse)
+	}
+	return resp, nil
+}
+
+func (s *mockLiteServer) ComputeMessageStats(ctx context.Context, req *pb.ComputeMessageStatsRequest) (*pb.ComputeMessageStatsResponse, error) {
+	retResponse, retErr := s.popGlobalVerifiers(req)
+	if retErr != nil {
+		return nil, retErr
+	}
+	resp, ok := retResponse.(*pb.ComputeMessageStatsResponse)
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "mockserver: invalid response type %T", retResponse)
+	}
+	return resp, nil
+}
+
+func (s *mockLiteServer) ComputeHeadCursor(ctx context.Context, req *pb.ComputeHeadCursorRequest) (*pb.ComputeHeadCursorResponse, error) {
+	retResponse, retErr := s.popGlobalVerifiers(req)
+	if retErr != nil {
+		return nil, retErr
+	}
+	resp, ok := retResponse.(*pb.ComputeHeadCursorResponse)
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "mockserver: invalid response type %T", retResponse)
+	}
+	return resp, nil
+}
+
 func (s *mockLiteServer) GetOperation(ctx context.Context, req *lrpb.GetOperationRequest) (*lrpb.Operation, error) {
 	return s.doOperationResponse(ctx, req)
 }
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
@@ -45,6 +46,13 @@ func (ps partitionSet) Ints() (partitions []int) {
 	return
 }
 
+// SortedInts returns the partitions in ascending order.
+func (ps partitionSet) SortedInts() []int {
+	partitions := ps.Ints()
+	sort.Ints(partitions)
+	return partitions
+}
+
 func (ps partitionSet) Contains(partition int) bool {
 	_, exists := ps[partition]
 	return exists
@@ -114,6 +114,11 @@ func (c *committer) Terminate() {
 	defer c.mu.Unlock()
 
 	c.acks.Release()
+	if c.status == serviceTerminating {
+		// Acks may have been received since the committer started stopping, so
+		// ensure the final commit offset is sent.
+		c.unsafeCommitOffsetToStream()
+	}
 	c.unsafeInitiateShutdown(serviceTerminating, nil)
 }
 
@@ -138,10 +138,27 @@ type ReceiveSettings struct {
 	// determine which partitions it should connect to.
 	Partitions []int
 
+	// Optional receiver of partition assignment changes made by the partition
+	// assignment service. Not used if Partitions is set.
+	AssignmentReceiver PartitionAssignmentReceiver
+
 	// The user-facing API type.
 	Framework FrameworkType
 }
 
+// PartitionAssignmentReceiver is notified when the partition assignment
+// service assigns partitions to, or revokes partitions from, a subscriber.
+// Calls are not concurrent.
+type PartitionAssignmentReceiver interface {
+	// OnPartitionAssigned is called before messages from the partition are
+	// delivered.
+	OnPartitionAssigned(partition int)
+	// OnPartitionRevoked is called after all calls to the message receiver for
+	// the partition have returned. Messages acked before it returns are
+	// committed.
+	OnPartitionRevoked(partition int)
+}
+
 // DefaultReceiveSettings holds the default values for ReceiveSettings.
 var DefaultReceiveSettings = ReceiveSettings{
 	MaxOutstandingMessages: 1000,
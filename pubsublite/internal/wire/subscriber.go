@@ -17,6 +17,7 @@ import (
 	"context"
 	"errors"
 	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -203,6 +204,12 @@ func (s *subscribeStream) Stop() {
 	s.unsafeInitiateShutdown(serviceTerminating, nil)
 }
 
+// WaitDeliveryStopped waits until all calls to the message receiver have
+// returned. It must only be called after Stop.
+func (s *subscribeStream) WaitDeliveryStopped() {
+	s.messageQueue.Wait()
+}
+
 func (s *subscribeStream) newStream(ctx context.Context) (grpc.ClientStream, error) {
 	return s.subClient.Subscribe(s.metadata.AddToContext(ctx))
 }
@@ -478,17 +485,44 @@ func newAssigningSubscriber(allClients apiClients, assignmentClient *vkit.Partit
 }
 
 func (as *assigningSubscriber) handleAssignment(partitions partitionSet) error {
-	removedSubscribers, err := as.doHandleAssignment(partitions)
-	if err != nil {
-		return err
+	receiver := as.subFactory.settings.AssignmentReceiver
+	if receiver == nil {
+		removedSubscribers, err := as.doHandleAssignment(partitions)
+		if err != nil {
+			return err
+		}
+
+		// Wait for removed subscribers to completely stop (which waits for commit
+		// acknowledgments from the server) before acking the assignment. This
+		// avoids commits racing with the new assigned client.
+		for _, subscriber := range removedSubscribers {
+			subscriber.WaitStopped()
+		}
+		return nil
 	}
 
-	// Wait for removed subscribers to completely stop (which waits for commit
-	// acknowledgments from the server) before acking the assignment. This avoids
-	// commits racing with the new assigned client.
-	for _, subscriber := range removedSubscribers {
+	// Revoke partitions before assigning new partitions. Subscribers for revoked
+	// partitions stop delivering messages, but continue to track acks until the
+	// receiver has been notified.
+	revokedPartitions, revokedSubscribers := as.removeSubscribers(partitions)
+	for i, subscriber := range revokedSubscribers {
+		subscriber.subscriber.WaitDeliveryStopped()
+		receiver.OnPartitionRevoked(revokedPartitions[i])
+		subscriber.Terminate()
+	}
+	for _, subscriber := range revokedSubscribers {
 		subscriber.WaitStopped()
 	}
+
+	for _, partition := range partitions.SortedInts() {
+		if as.hasSubscriber(partition) {
+			continue
+		}
+		receiver.OnPartitionAssigned(partition)
+		if err := as.addSubscriber(partition); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -526,6 +560,53 @@ func (as *assigningSubscriber) doHandleAssignment(partitions partitionSet) ([]*s
 	return removedSubscribers, nil
 }
 
+// removeSubscribers stops the subscribers for partitions not in the new
+// assignment, without discarding outstanding acks. Returns the removed
+// partitions in ascending order along with their subscribers.
+func (as *assigningSubscriber) removeSubscribers(partitions partitionSet) ([]int, []*singlePartitionSubscriber) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	var removedPartitions []int
+	for partition := range as.subscribers {
+		if !partitions.Contains(partition) {
+			removedPartitions = append(removedPartitions, partition)
+		}
+	}
+	sort.Ints(removedPartitions)
+
+	var removedSubscribers []*singlePartitionSubscriber
+	for _, partition := range removedPartitions {
+		subscriber := as.subscribers[partition]
+		removedSubscribers = append(removedSubscribers, subscriber)
+		// Stop() terminates the subscribe stream, but allows outstanding acks to
+		// be committed.
+		as.unsafeRemoveService(subscriber)
+		delete(as.subscribers, partition)
+	}
+	return removedPartitions, removedSubscribers
+}
+
+func (as *assigningSubscriber) hasSubscriber(partition int) bool {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	_, exists := as.subscribers[partition]
+	return exists
+}
+
+func (as *assigningSubscriber) addSubscriber(partition int) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	subscriber := as.subFactory.New(partition)
+	if err := as.unsafeAddServices(subscriber); err != nil {
+		// Occurs when the assigningSubscriber is stopping/stopped.
+		return err
+	}
+	as.subscribers[partition] = subscriber
+	return nil
+}
+
 // Terminate shuts down all singlePartitionSubscribers without waiting for
 // outstanding acks. Alternatively, Stop() will wait for outstanding acks.
 func (as *assigningSubscriber) Terminate() {
@@ -15,6 +15,7 @@ package wire
 
 import (
 	"context"
+	"fmt"
 	"sort"
 	"sync"
 	"testing"
@@ -1089,6 +1090,10 @@ func (as *assigningSubscriber) FlushCommits() {
 }
 
 func newTestAssigningSubscriber(t *testing.T, receiverFunc MessageReceiverFunc, subscriptionPath string) *assigningSubscriber {
+	return newTestAssigningSubscriberWithSettings(t, receiverFunc, subscriptionPath, testSubscriberSettings())
+}
+
+func newTestAssigningSubscriberWithSettings(t *testing.T, receiverFunc MessageReceiverFunc, subscriptionPath string, settings ReceiveSettings) *assigningSubscriber {
 	ctx := context.Background()
 	subClient, err := newSubscriberClient(ctx, "ignored", testServer.ClientConn())
 	if err != nil {
@@ -1108,7 +1113,7 @@ func newTestAssigningSubscriber(t *testing.T, receiverFunc MessageReceiverFunc,
 		ctx:              ctx,
 		subClient:        subClient,
 		cursorClient:     cursorClient,
-		settings:         testSubscriberSettings(),
+		settings:         settings,
 		subscriptionPath: subscriptionPath,
 		receiver:         receiverFunc,
 		disableTasks:     true, // Background tasks disabled to control event order
@@ -1330,6 +1335,109 @@ func TestAssigningSubscriberIgnoreOutstandingAcks(t *testing.T) {
 	}
 }
 
+// testAssignmentReceiver records partition assignment changes.
+type testAssignmentReceiver struct {
+	mu       sync.Mutex
+	events   []string
+	onRevoke func(partition int)
+}
+
+func (r *testAssignmentReceiver) OnPartitionAssigned(partition int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf("assigned %d", partition))
+}
+
+func (r *testAssignmentReceiver) OnPartitionRevoked(partition int) {
+	r.mu.Lock()
+	r.events = append(r.events, fmt.Sprintf("revoked %d", partition))
+	r.mu.Unlock()
+	if r.onRevoke != nil {
+		r.onRevoke(partition)
+	}
+}
+
+func (r *testAssignmentReceiver) Events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events
+}
+
+func TestAssigningSubscriberAssignmentReceiver(t *testing.T) {
+	const subscription = "projects/123456/locations/us-central1-b/subscriptions/my-sub"
+	receiver := newTestMessageReceiver(t)
+	msg1 := seqMsgWithOffsetAndSize(11, 100)
+	msg2 := seqMsgWithOffsetAndSize(22, 200)
+	msg3 := seqMsgWithOffsetAndSize(33, 100)
+
+	verifiers := test.NewVerifiers(t)
+
+	// Assignment stream
+	asnStream := test.NewRPCVerifier(t)
+	asnStream.Push(initAssignmentReq(subscription, fakeUUID[:]), assignmentResp([]int64{1}), nil)
+	assignmentBarrier1 := asnStream.PushWithBarrier(assignmentAckReq(), assignmentResp([]int64{2}), nil)
+	assignmentBarrier2 := asnStream.PushWithBarrier(assignmentAckReq(), nil, nil)
+	verifiers.AddAssignmentStream(subscription, asnStream)
+
+	// Partition 1
+	subStream1 := test.NewRPCVerifier(t)
+	subStream1.Push(initSubReqCommit(subscriptionPartition{Path: subscription, Partition: 1}), initSubResp(), nil)
+	subStream1.Push(initFlowControlReq(), msgSubResp(msg1, msg2), nil)
+	verifiers.AddSubscribeStream(subscription, 1, subStream1)
+
+	cmtStream1 := test.NewRPCVerifier(t)
+	cmtStream1.Push(initCommitReq(subscriptionPartition{Path: subscription, Partition: 1}), initCommitResp(), nil)
+	// Revoking the partition commits msg1. The final commit includes msg2, which
+	// is acked during revocation.
+	cmtStream1.Push(commitReq(12), commitResp(1), nil)
+	cmtStream1.Push(commitReq(23), commitResp(1), nil)
+	verifiers.AddCommitStream(subscription, 1, cmtStream1)
+
+	// Partition 2
+	subStream2 := test.NewRPCVerifier(t)
+	subStream2.Push(initSubReqCommit(subscriptionPartition{Path: subscription, Partition: 2}), initSubResp(), nil)
+	subStream2.Push(initFlowControlReq(), msgSubResp(msg3), nil)
+	verifiers.AddSubscribeStream(subscription, 2, subStream2)
+
+	cmtStream2 := test.NewRPCVerifier(t)
+	cmtStream2.Push(initCommitReq(subscriptionPartition{Path: subscription, Partition: 2}), initCommitResp(), nil)
+	cmtStream2.Push(commitReq(34), commitResp(1), nil)
+	verifiers.AddCommitStream(subscription, 2, cmtStream2)
+
+	mockServer.OnTestStart(verifiers)
+	defer mockServer.OnTestEnd()
+
+	assignments := new(testAssignmentReceiver)
+	settings := testSubscriberSettings()
+	settings.AssignmentReceiver = assignments
+	sub := newTestAssigningSubscriberWithSettings(t, receiver.onMessage, subscription, settings)
+	if gotErr := sub.WaitStarted(); gotErr != nil {
+		t.Errorf("Start() got err: (%v)", gotErr)
+	}
+
+	// Partition assignments are initially {1}.
+	receiver.ValidateMsg(msg1).Ack()
+	ack2 := receiver.ValidateMsg(msg2)
+	assignments.onRevoke = func(int) {
+		// Acks are still committed before the revocation completes.
+		ack2.Ack()
+	}
+
+	// Partition assignments will now be {2}.
+	assignmentBarrier1.Release()
+	receiver.ValidateMsg(msg3).Ack()
+	assignmentBarrier2.Release()
+
+	if got, want := assignments.Events(), []string{"assigned 1", "revoked 1", "assigned 2"}; !testutil.Equal(got, want) {
+		t.Errorf("partition assignment events: got %q, want %q", got, want)
+	}
+
+	sub.Stop()
+	if gotErr := sub.WaitStopped(); gotErr != nil {
+		t.Errorf("Stop() got err: (%v)", gotErr)
+	}
+}
+
 func TestNewSubscriberValidatesSettings(t *testing.T) {
 	const subscription = "projects/123456/locations/us-central1-b/subscriptions/my-sub"
 	const region = "us-central1"
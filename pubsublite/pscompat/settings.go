@@ -164,6 +164,10 @@ type NackHandler func(*pubsub.Message) error
 // error and terminate.
 type ReceiveMessageTransformerFunc func(*pb.SequencedMessage, *pubsub.Message) error
 
+// PartitionHandler is invoked when a partition is assigned to or revoked from
+// a SubscriberClient.
+type PartitionHandler func(partition int)
+
 // ReceiveSettings configure the SubscriberClient. Flow control settings
 // (MaxOutstandingMessages, MaxOutstandingBytes) apply per partition.
 //
@@ -210,6 +214,27 @@ type ReceiveSettings struct {
 	// Optional custom function that transforms a SequencedMessage API proto to a
 	// pubsub.Message.
 	MessageTransformer ReceiveMessageTransformerFunc
+
+	// If true, the SubscriberClient waits for each message to be acked or nacked
+	// before delivering the next message from the same partition. Messages from
+	// each partition are then processed strictly sequentially in offset order,
+	// even if the receiver func hands them off to another goroutine.
+	OrderedPartitionDelivery bool
+
+	// Optional function invoked when a partition is assigned to the
+	// SubscriberClient, before any messages from the partition are delivered. If
+	// Partitions is set, it is invoked for each of them when Receive starts.
+	OnPartitionAssigned PartitionHandler
+
+	// Optional function invoked when a partition is revoked from the
+	// SubscriberClient, after all calls to the receiver func for messages from
+	// the partition have returned. Messages from the partition that are acked
+	// before OnPartitionRevoked returns are committed, so it can be used to flush
+	// per-partition state before the partition is reassigned to another client.
+	// When Receive terminates, it is invoked for all partitions still assigned.
+	//
+	// OnPartitionAssigned and OnPartitionRevoked are never called concurrently.
+	OnPartitionRevoked PartitionHandler
 }
 
 // DefaultReceiveSettings holds the default values for ReceiveSettings.
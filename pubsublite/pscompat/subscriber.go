@@ -16,6 +16,7 @@ package pscompat
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
 
 	"cloud.google.com/go/pubsub"
@@ -42,6 +43,9 @@ type pslAckHandler struct {
 	msg         *pubsub.Message
 	nackh       NackHandler
 	subInstance *subscriberInstance
+	// Closed when the message is acked or nacked. Only set if
+	// ReceiveSettings.OrderedPartitionDelivery is true.
+	done chan struct{}
 }
 
 func (ah *pslAckHandler) OnAck() {
@@ -51,6 +55,7 @@ func (ah *pslAckHandler) OnAck() {
 
 	ah.ackh.Ack()
 	ah.subInstance = nil
+	ah.notifyDone()
 }
 
 func (ah *pslAckHandler) OnNack() {
@@ -67,12 +72,19 @@ func (ah *pslAckHandler) OnNack() {
 		ah.ackh.Ack()
 	}
 	ah.subInstance = nil
+	ah.notifyDone()
+}
+
+func (ah *pslAckHandler) notifyDone() {
+	if ah.done != nil {
+		close(ah.done)
+	}
 }
 
 // wireSubscriberFactory is a factory for creating wire subscribers, which can
 // be overridden with a mock in unit tests.
 type wireSubscriberFactory interface {
-	New(context.Context, wire.MessageReceiverFunc) (wire.Subscriber, error)
+	New(context.Context, wire.MessageReceiverFunc, wire.PartitionAssignmentReceiver) (wire.Subscriber, error)
 }
 
 type wireSubscriberFactoryImpl struct {
@@ -82,8 +94,10 @@ type wireSubscriberFactoryImpl struct {
 	options      []option.ClientOption
 }
 
-func (f *wireSubscriberFactoryImpl) New(ctx context.Context, receiver wire.MessageReceiverFunc) (wire.Subscriber, error) {
-	return wire.NewSubscriber(ctx, f.settings, receiver, f.region, f.subscription.String(), f.options...)
+func (f *wireSubscriberFactoryImpl) New(ctx context.Context, receiver wire.MessageReceiverFunc, assignments wire.PartitionAssignmentReceiver) (wire.Subscriber, error) {
+	settings := f.settings
+	settings.AssignmentReceiver = assignments
+	return wire.NewSubscriber(ctx, settings, receiver, f.region, f.subscription.String(), f.options...)
 }
 
 type messageReceiverFunc = func(context.Context, *pubsub.Message)
@@ -98,6 +112,11 @@ type subscriberInstance struct {
 	wireSub         wire.Subscriber
 	activeReceivers sync.WaitGroup
 
+	// Partitions currently assigned, which must be guarded with assignMu. The
+	// mutex is also held while invoking partition handlers to serialize them.
+	assignMu sync.Mutex
+	assigned map[int]bool
+
 	// Fields below must be guarded with mu.
 	mu  sync.Mutex
 	err error
@@ -110,13 +129,20 @@ func newSubscriberInstance(recvCtx, clientCtx context.Context, factory wireSubsc
 		recvCtx:    recvCtx,
 		recvCancel: recvCancel,
 		receiver:   receiver,
+		assigned:   make(map[int]bool),
+	}
+
+	// Only receive partition assignment changes if handlers are set.
+	var assignments wire.PartitionAssignmentReceiver
+	if settings.OnPartitionAssigned != nil || settings.OnPartitionRevoked != nil {
+		assignments = subInstance
 	}
 
 	// Note: The context from Receive (recvCtx) should not be used, as when it is
 	// cancelled, the gRPC streams will be disconnected and the subscriber will
 	// not be able to process acks and commit the final cursor offset. Use the
 	// context from NewSubscriberClient (clientCtx) instead.
-	wireSub, err := factory.New(clientCtx, subInstance.onMessage)
+	wireSub, err := factory.New(clientCtx, subInstance.onMessage, assignments)
 	if err != nil {
 		return nil, err
 	}
@@ -149,6 +175,9 @@ func (si *subscriberInstance) onMessage(msg *wire.ReceivedMessage) {
 		nackh:       si.settings.NackHandler,
 		subInstance: si,
 	}
+	if si.settings.OrderedPartitionDelivery {
+		pslAckh.done = make(chan struct{})
+	}
 	psMsg := ipubsub.NewMessage(pslAckh)
 	pslAckh.msg = psMsg
 	if err := si.transformMessage(msg, psMsg); err != nil {
@@ -158,9 +187,58 @@ func (si *subscriberInstance) onMessage(msg *wire.ReceivedMessage) {
 
 	si.activeReceivers.Add(1)
 	si.receiver(si.recvCtx, psMsg)
+	if pslAckh.done != nil {
+		// Block delivery of the next message from the partition until this
+		// message has been processed.
+		select {
+		case <-pslAckh.done:
+		case <-si.recvCtx.Done():
+		}
+	}
 	si.activeReceivers.Done()
 }
 
+// OnPartitionAssigned implements wire.PartitionAssignmentReceiver.
+func (si *subscriberInstance) OnPartitionAssigned(partition int) {
+	si.assignMu.Lock()
+	defer si.assignMu.Unlock()
+
+	si.assigned[partition] = true
+	if si.settings.OnPartitionAssigned != nil {
+		si.settings.OnPartitionAssigned(partition)
+	}
+}
+
+// OnPartitionRevoked implements wire.PartitionAssignmentReceiver.
+func (si *subscriberInstance) OnPartitionRevoked(partition int) {
+	si.assignMu.Lock()
+	defer si.assignMu.Unlock()
+
+	if !si.assigned[partition] {
+		return
+	}
+	delete(si.assigned, partition)
+	if si.settings.OnPartitionRevoked != nil {
+		si.settings.OnPartitionRevoked(partition)
+	}
+}
+
+// revokeAll revokes all partitions still assigned when the subscriber has
+// terminated.
+func (si *subscriberInstance) revokeAll() {
+	si.assignMu.Lock()
+	var partitions []int
+	for p := range si.assigned {
+		partitions = append(partitions, p)
+	}
+	si.assignMu.Unlock()
+
+	sort.Ints(partitions)
+	for _, p := range partitions {
+		si.OnPartitionRevoked(p)
+	}
+}
+
 // shutdown starts shutting down the subscriber client. The wire subscriber can
 // optionally wait for all outstanding messages to be acked/nacked.
 func (si *subscriberInstance) shutdown(waitForAcks bool, err error) {
@@ -192,6 +270,15 @@ func (si *subscriberInstance) Terminate(err error) {
 // Wait for the subscriber to stop, or the context is done, whichever occurs
 // first.
 func (si *subscriberInstance) Wait(ctx context.Context) error {
+	if si.settings.OnPartitionAssigned != nil || si.settings.OnPartitionRevoked != nil {
+		// The wire subscriber only notifies assignment changes from the partition
+		// assignment service, so notify statically assigned partitions here.
+		for _, p := range si.settings.Partitions {
+			si.OnPartitionAssigned(p)
+		}
+		defer si.revokeAll()
+	}
+
 	si.wireSub.Start()
 	if err := si.wireSub.WaitStarted(); err != nil {
 		return err
@@ -293,6 +380,10 @@ func NewSubscriberClientWithSettings(ctx context.Context, subscription string, s
 // is connected to multiple partitions. Only one call from any connected
 // partition will be outstanding at a time, and blocking in the receiver
 // callback f will block the delivery of subsequent messages for the partition.
+// Set ReceiveSettings.OrderedPartitionDelivery to also wait for each message to
+// be acked before delivering the next message from the partition, and
+// ReceiveSettings.OnPartitionAssigned and OnPartitionRevoked to be notified
+// when partitions are assigned to and revoked from the SubscriberClient.
 //
 // All messages received by f must be ACKed or NACKed. Failure to do so can
 // prevent Receive from returning.
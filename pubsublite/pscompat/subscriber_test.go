@@ -16,6 +16,8 @@ package pscompat
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -43,12 +45,13 @@ func (ac *mockAckConsumer) Ack() {
 
 // mockWireSubscriber is a mock implementation of the wire.Subscriber interface.
 type mockWireSubscriber struct {
-	receiver   wire.MessageReceiverFunc
-	msgsC      chan *wire.ReceivedMessage
-	stopC      chan struct{}
-	err        error
-	Stopped    bool
-	Terminated bool
+	receiver    wire.MessageReceiverFunc
+	assignments wire.PartitionAssignmentReceiver
+	msgsC       chan *wire.ReceivedMessage
+	stopC       chan struct{}
+	err         error
+	Stopped     bool
+	Terminated  bool
 }
 
 // DeliverMessages should be called from the test to simulate a message
@@ -113,11 +116,12 @@ func (ms *mockWireSubscriber) WaitStopped() error {
 
 type mockWireSubscriberFactory struct{}
 
-func (f *mockWireSubscriberFactory) New(ctx context.Context, receiver wire.MessageReceiverFunc) (wire.Subscriber, error) {
+func (f *mockWireSubscriberFactory) New(ctx context.Context, receiver wire.MessageReceiverFunc, assignments wire.PartitionAssignmentReceiver) (wire.Subscriber, error) {
 	return &mockWireSubscriber{
-		receiver: receiver,
-		msgsC:    make(chan *wire.ReceivedMessage, 10),
-		stopC:    make(chan struct{}),
+		receiver:    receiver,
+		assignments: assignments,
+		msgsC:       make(chan *wire.ReceivedMessage, 10),
+		stopC:       make(chan struct{}),
 	}, nil
 }
 
@@ -363,6 +367,103 @@ func TestSubscriberInstanceNack(t *testing.T) {
 	}
 }
 
+func TestSubscriberInstanceOrderedPartitionDelivery(t *testing.T) {
+	ctx := context.Background()
+	msg1 := &wire.ReceivedMessage{
+		Msg: &pb.SequencedMessage{Cursor: &pb.Cursor{Offset: 1}, Message: &pb.PubSubMessage{}},
+		Ack: &mockAckConsumer{},
+	}
+	msg2 := &wire.ReceivedMessage{
+		Msg: &pb.SequencedMessage{Cursor: &pb.Cursor{Offset: 2}, Message: &pb.PubSubMessage{}},
+		Ack: &mockAckConsumer{},
+	}
+
+	settings := DefaultReceiveSettings
+	settings.OrderedPartitionDelivery = true
+
+	cctx, stopSubscriber := context.WithTimeout(ctx, defaultSubscriberTestTimeout)
+	defer stopSubscriber()
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	messageReceiver := func(ctx context.Context, got *pubsub.Message) {
+		record("received " + got.ID)
+		// Ack asynchronously. The next message must not be delivered until the
+		// ack.
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			record("acked " + got.ID)
+			got.Ack()
+			if got.ID == "0:2" {
+				stopSubscriber()
+			}
+		}()
+	}
+	subInstance := newTestSubscriberInstance(cctx, settings, messageReceiver)
+	subInstance.wireSub.(*mockWireSubscriber).DeliverMessages(msg1, msg2)
+
+	if gotErr := subInstance.Wait(cctx); gotErr != nil {
+		t.Errorf("subscriberInstance.Wait() got err: (%v)", gotErr)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"received 0:1", "acked 0:1", "received 0:2", "acked 0:2"}; !testutil.Equal(events, want) {
+		t.Errorf("events: got %q, want %q", events, want)
+	}
+}
+
+func TestSubscriberInstancePartitionHandlers(t *testing.T) {
+	ctx := context.Background()
+
+	var events []string
+	settings := DefaultReceiveSettings
+	settings.Partitions = []int{2, 1}
+	settings.OnPartitionAssigned = func(partition int) {
+		events = append(events, fmt.Sprintf("assigned %d", partition))
+	}
+	settings.OnPartitionRevoked = func(partition int) {
+		events = append(events, fmt.Sprintf("revoked %d", partition))
+	}
+
+	cctx, stopSubscriber := context.WithCancel(ctx)
+	subInstance := newTestSubscriberInstance(cctx, settings, func(context.Context, *pubsub.Message) {})
+	assignments := subInstance.wireSub.(*mockWireSubscriber).assignments
+	if assignments == nil {
+		t.Fatal("wire subscriber not created with a partition assignment receiver")
+	}
+
+	result := make(chan error)
+	go func() {
+		result <- subInstance.Wait(cctx)
+	}()
+	// Wait for the static partitions to be assigned, then simulate the wire
+	// subscriber reassigning partition 2.
+	for {
+		subInstance.assignMu.Lock()
+		assigned := len(subInstance.assigned)
+		subInstance.assignMu.Unlock()
+		if assigned == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assignments.OnPartitionRevoked(2)
+	assignments.OnPartitionAssigned(3)
+	stopSubscriber()
+	if gotErr := <-result; gotErr != nil {
+		t.Errorf("subscriberInstance.Wait() got err: (%v)", gotErr)
+	}
+
+	want := []string{"assigned 2", "assigned 1", "revoked 2", "assigned 3", "revoked 1", "revoked 3"}
+	if !testutil.Equal(events, want) {
+		t.Errorf("events: got %q, want %q", events, want)
+	}
+}
+
 func TestSubscriberInstanceWireSubscriberFails(t *testing.T) {
 	fatalErr := errors.New("server error")
 
@@ -0,0 +1,490 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package psltest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/internal/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "google.golang.org/genproto/googleapis/cloud/pubsublite/v1"
+	lrpb "google.golang.org/genproto/googleapis/longrunning"
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	fmpb "google.golang.org/protobuf/types/known/fieldmaskpb"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Topics
+
+func (s *liteServer) CreateTopic(_ context.Context, req *pb.CreateTopicRequest) (*pb.Topic, error) {
+	if req.GetParent() == "" || req.GetTopicId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing parent or topic ID")
+	}
+	if req.GetTopic().GetPartitionConfig().GetCount() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "topic partition count must be positive")
+	}
+	name := fmt.Sprintf("%s/topics/%s", req.GetParent(), req.GetTopicId())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.topics[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "topic %q already exists", name)
+	}
+	if err := s.checkReservation(req.GetTopic()); err != nil {
+		return nil, err
+	}
+	t := proto.Clone(req.GetTopic()).(*pb.Topic)
+	t.Name = name
+	s.topics[name] = newTopic(t)
+	return proto.Clone(t).(*pb.Topic), nil
+}
+
+func (s *liteServer) GetTopic(_ context.Context, req *pb.GetTopicRequest) (*pb.Topic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.findTopic(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(t.proto).(*pb.Topic), nil
+}
+
+func (s *liteServer) GetTopicPartitions(_ context.Context, req *pb.GetTopicPartitionsRequest) (*pb.TopicPartitions, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.findTopic(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.TopicPartitions{PartitionCount: int64(len(t.partitions))}, nil
+}
+
+func (s *liteServer) ListTopics(_ context.Context, req *pb.ListTopicsRequest) (*pb.ListTopicsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.topics {
+		if strings.HasPrefix(name, req.GetParent()+"/topics/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	from, to, nextToken, err := testutil.PageBounds(int(req.GetPageSize()), req.GetPageToken(), len(names))
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.ListTopicsResponse{NextPageToken: nextToken}
+	for _, name := range names[from:to] {
+		res.Topics = append(res.Topics, proto.Clone(s.topics[name].proto).(*pb.Topic))
+	}
+	return res, nil
+}
+
+func (s *liteServer) UpdateTopic(_ context.Context, req *pb.UpdateTopicRequest) (*pb.Topic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.findTopic(req.GetTopic().GetName())
+	if err != nil {
+		return nil, err
+	}
+	updated := proto.Clone(t.proto).(*pb.Topic)
+	if err := applyFieldMask(updated, req.GetTopic(), req.GetUpdateMask()); err != nil {
+		return nil, err
+	}
+	count := updated.GetPartitionConfig().GetCount()
+	if count < int64(len(t.partitions)) {
+		return nil, status.Error(codes.InvalidArgument, "topic partition count cannot be decreased")
+	}
+	if err := s.checkReservation(updated); err != nil {
+		return nil, err
+	}
+	t.proto = updated
+	t.resize(count)
+	for _, sub := range s.subs {
+		if sub.proto.GetTopic() == t.proto.GetName() {
+			s.rebalance(sub)
+		}
+	}
+	return proto.Clone(updated).(*pb.Topic), nil
+}
+
+func (s *liteServer) DeleteTopic(_ context.Context, req *pb.DeleteTopicRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.findTopic(req.GetName()); err != nil {
+		return nil, err
+	}
+	delete(s.topics, req.GetName())
+	return &emptypb.Empty{}, nil
+}
+
+func (s *liteServer) ListTopicSubscriptions(_ context.Context, req *pb.ListTopicSubscriptionsRequest) (*pb.ListTopicSubscriptionsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.findTopic(req.GetName()); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, sub := range s.subs {
+		if sub.proto.GetTopic() == req.GetName() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	from, to, nextToken, err := testutil.PageBounds(int(req.GetPageSize()), req.GetPageToken(), len(names))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListTopicSubscriptionsResponse{Subscriptions: names[from:to], NextPageToken: nextToken}, nil
+}
+
+// Subscriptions
+
+func (s *liteServer) CreateSubscription(_ context.Context, req *pb.CreateSubscriptionRequest) (*pb.Subscription, error) {
+	if req.GetParent() == "" || req.GetSubscriptionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing parent or subscription ID")
+	}
+	name := fmt.Sprintf("%s/subscriptions/%s", req.GetParent(), req.GetSubscriptionId())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "subscription %q already exists", name)
+	}
+	t, err := s.findTopic(req.GetSubscription().GetTopic())
+	if err != nil {
+		return nil, err
+	}
+	p := cloneSubscription(req.GetSubscription())
+	p.Name = name
+	sub := newSubscription(p)
+	if req.GetSkipBacklog() {
+		for i, log := range t.partitions {
+			sub.cursors[int64(i)] = log.head()
+		}
+	}
+	s.subs[name] = sub
+	return cloneSubscription(p), nil
+}
+
+func (s *liteServer) GetSubscription(_ context.Context, req *pb.GetSubscriptionRequest) (*pb.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, err := s.findSubscription(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return cloneSubscription(sub.proto), nil
+}
+
+func (s *liteServer) ListSubscriptions(_ context.Context, req *pb.ListSubscriptionsRequest) (*pb.ListSubscriptionsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.subs {
+		if strings.HasPrefix(name, req.GetParent()+"/subscriptions/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	from, to, nextToken, err := testutil.PageBounds(int(req.GetPageSize()), req.GetPageToken(), len(names))
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.ListSubscriptionsResponse{NextPageToken: nextToken}
+	for _, name := range names[from:to] {
+		res.Subscriptions = append(res.Subscriptions, cloneSubscription(s.subs[name].proto))
+	}
+	return res, nil
+}
+
+func (s *liteServer) UpdateSubscription(_ context.Context, req *pb.UpdateSubscriptionRequest) (*pb.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, err := s.findSubscription(req.GetSubscription().GetName())
+	if err != nil {
+		return nil, err
+	}
+	updated := cloneSubscription(sub.proto)
+	if err := applyFieldMask(updated, req.GetSubscription(), req.GetUpdateMask()); err != nil {
+		return nil, err
+	}
+	if updated.GetTopic() != sub.proto.GetTopic() {
+		return nil, status.Error(codes.InvalidArgument, "the topic of a subscription cannot be updated")
+	}
+	sub.proto = updated
+	return cloneSubscription(updated), nil
+}
+
+func (s *liteServer) DeleteSubscription(_ context.Context, req *pb.DeleteSubscriptionRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, err := s.findSubscription(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	delete(s.subs, req.GetName())
+	sub.terminateClients(status.Errorf(codes.NotFound, "subscription %q deleted", req.GetName()))
+	return &emptypb.Empty{}, nil
+}
+
+// SeekSubscription updates the committed cursors of all partitions and resets
+// connected subscribers. The returned operation is already complete.
+func (s *liteServer) SeekSubscription(_ context.Context, req *pb.SeekSubscriptionRequest) (*lrpb.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, err := s.findSubscription(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.findTopic(sub.proto.GetTopic())
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range t.partitions {
+		var offset int64
+		switch {
+		case req.GetNamedTarget() == pb.SeekSubscriptionRequest_HEAD:
+			offset = p.head()
+		case req.GetNamedTarget() == pb.SeekSubscriptionRequest_TAIL:
+			offset = 0
+		case req.GetTimeTarget().GetPublishTime() != nil:
+			offset = p.offsetForTime(req.GetTimeTarget().GetPublishTime().AsTime(), false)
+		case req.GetTimeTarget().GetEventTime() != nil:
+			offset = p.offsetForTime(req.GetTimeTarget().GetEventTime().AsTime(), true)
+		default:
+			return nil, status.Error(codes.InvalidArgument, "missing seek target")
+		}
+		sub.cursors[int64(i)] = offset
+	}
+	sub.resetSubscribers()
+
+	now := tspb.New(s.timeNow())
+	metadata, err := anypb.New(&pb.OperationMetadata{
+		CreateTime: now,
+		EndTime:    now,
+		Target:     req.GetName(),
+		Verb:       "seek",
+	})
+	if err != nil {
+		return nil, err
+	}
+	response, err := anypb.New(&pb.SeekSubscriptionResponse{})
+	if err != nil {
+		return nil, err
+	}
+	s.nextOpID++
+	parent := req.GetName()[:strings.Index(req.GetName(), "/subscriptions/")]
+	op := &lrpb.Operation{
+		Name:     fmt.Sprintf("%s/operations/op-%d", parent, s.nextOpID),
+		Metadata: metadata,
+		Done:     true,
+		Result:   &lrpb.Operation_Response{Response: response},
+	}
+	s.operations[op.Name] = op
+	return proto.Clone(op).(*lrpb.Operation), nil
+}
+
+// Reservations
+
+func (s *liteServer) CreateReservation(_ context.Context, req *pb.CreateReservationRequest) (*pb.Reservation, error) {
+	if req.GetParent() == "" || req.GetReservationId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing parent or reservation ID")
+	}
+	name := fmt.Sprintf("%s/reservations/%s", req.GetParent(), req.GetReservationId())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.reservations[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "reservation %q already exists", name)
+	}
+	r := proto.Clone(req.GetReservation()).(*pb.Reservation)
+	r.Name = name
+	s.reservations[name] = r
+	return proto.Clone(r).(*pb.Reservation), nil
+}
+
+func (s *liteServer) GetReservation(_ context.Context, req *pb.GetReservationRequest) (*pb.Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.findReservation(req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(r).(*pb.Reservation), nil
+}
+
+func (s *liteServer) ListReservations(_ context.Context, req *pb.ListReservationsRequest) (*pb.ListReservationsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.reservations {
+		if strings.HasPrefix(name, req.GetParent()+"/reservations/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	from, to, nextToken, err := testutil.PageBounds(int(req.GetPageSize()), req.GetPageToken(), len(names))
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.ListReservationsResponse{NextPageToken: nextToken}
+	for _, name := range names[from:to] {
+		res.Reservations = append(res.Reservations, proto.Clone(s.reservations[name]).(*pb.Reservation))
+	}
+	return res, nil
+}
+
+func (s *liteServer) UpdateReservation(_ context.Context, req *pb.UpdateReservationRequest) (*pb.Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.findReservation(req.GetReservation().GetName())
+	if err != nil {
+		return nil, err
+	}
+	updated := proto.Clone(r).(*pb.Reservation)
+	if err := applyFieldMask(updated, req.GetReservation(), req.GetUpdateMask()); err != nil {
+		return nil, err
+	}
+	s.reservations[updated.GetName()] = updated
+	return proto.Clone(updated).(*pb.Reservation), nil
+}
+
+func (s *liteServer) DeleteReservation(_ context.Context, req *pb.DeleteReservationRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.findReservation(req.GetName()); err != nil {
+		return nil, err
+	}
+	for _, t := range s.topics {
+		if t.proto.GetReservationConfig().GetThroughputReservation() == req.GetName() {
+			return nil, status.Errorf(codes.FailedPrecondition, "reservation %q is used by topic %q", req.GetName(), t.proto.GetName())
+		}
+	}
+	delete(s.reservations, req.GetName())
+	return &emptypb.Empty{}, nil
+}
+
+func (s *liteServer) ListReservationTopics(_ context.Context, req *pb.ListReservationTopicsRequest) (*pb.ListReservationTopicsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.findReservation(req.GetName()); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, t := range s.topics {
+		if t.proto.GetReservationConfig().GetThroughputReservation() == req.GetName() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	from, to, nextToken, err := testutil.PageBounds(int(req.GetPageSize()), req.GetPageToken(), len(names))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListReservationTopicsResponse{Topics: names[from:to], NextPageToken: nextToken}, nil
+}
+
+// Operations
+
+func (s *liteServer) GetOperation(_ context.Context, req *lrpb.GetOperationRequest) (*lrpb.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.operations[req.GetName()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "operation %q not found", req.GetName())
+	}
+	return proto.Clone(op).(*lrpb.Operation), nil
+}
+
+// Helpers. The functions below must be called with s.mu held.
+
+func (s *liteServer) findTopic(name string) (*topic, error) {
+	t, ok := s.topics[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "topic %q not found", name)
+	}
+	return t, nil
+}
+
+func (s *liteServer) findSubscription(name string) (*subscription, error) {
+	sub, ok := s.subs[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "subscription %q not found", name)
+	}
+	return sub, nil
+}
+
+func (s *liteServer) findReservation(name string) (*pb.Reservation, error) {
+	r, ok := s.reservations[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "reservation %q not found", name)
+	}
+	return r, nil
+}
+
+func (s *liteServer) checkReservation(t *pb.Topic) error {
+	name := t.GetReservationConfig().GetThroughputReservation()
+	if name == "" {
+		return nil
+	}
+	_, err := s.findReservation(name)
+	return err
+}
+
+func cloneSubscription(sub *pb.Subscription) *pb.Subscription {
+	return proto.Clone(sub).(*pb.Subscription)
+}
+
+// applyFieldMask copies the fields listed in mask from src to dst. Fields that
+// are listed in the mask but unset in src are cleared in dst.
+func applyFieldMask(dst, src proto.Message, mask *fmpb.FieldMask) error {
+	if len(mask.GetPaths()) == 0 {
+		return status.Error(codes.InvalidArgument, "empty update mask")
+	}
+	for _, path := range mask.GetPaths() {
+		d := dst.ProtoReflect()
+		s := src.ProtoReflect()
+		parts := strings.Split(path, ".")
+		for i, part := range parts {
+			fd := d.Descriptor().Fields().ByName(protoreflect.Name(part))
+			if fd == nil {
+				return status.Errorf(codes.InvalidArgument, "invalid update mask path %q", path)
+			}
+			if i == len(parts)-1 {
+				if s.Has(fd) {
+					d.Set(fd, s.Get(fd))
+				} else {
+					d.Clear(fd)
+				}
+				break
+			}
+			if fd.Message() == nil {
+				return status.Errorf(codes.InvalidArgument, "invalid update mask path %q", path)
+			}
+			d = d.Mutable(fd).Message()
+			s = s.Get(fd).Message()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package psltest provides a fake Pub/Sub Lite service for testing. It
+// implements a simplified form of the Admin, Publisher, Subscriber, Cursor,
+// PartitionAssignment and TopicStats services, suitable for unit tests of
+// applications that use the pubsublite and pscompat packages.
+//
+// The fake keeps all state in memory. It does not enforce throughput limits,
+// retention or quotas, and it may behave differently from the actual service
+// in ways in which the service is non-deterministic or unspecified: timing,
+// batching of messages, partition assignment, etc.
+//
+// This package is EXPERIMENTAL and is subject to change without notice.
+//
+// Example:
+//
+//   srv := psltest.NewServer()
+//   defer srv.Close()
+//   admin, err := pubsublite.NewAdminClient(ctx, "us-central1", srv.ClientOptions()...)
+//   ...
+//   publisher, err := pscompat.NewPublisherClient(ctx, topicPath, srv.ClientOptions()...)
+package psltest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/internal/testutil"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	pb "google.golang.org/genproto/googleapis/cloud/pubsublite/v1"
+	lrpb "google.golang.org/genproto/googleapis/longrunning"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server is a fake Pub/Sub Lite server.
+type Server struct {
+	srv  *testutil.Server
+	Addr string // The address that the server is listening on.
+
+	lite *liteServer
+}
+
+// NewServer creates a new fake server running in the current process.
+//
+// NewServer panics if the server cannot be started, which is appropriate for
+// testing.
+func NewServer() *Server {
+	srv, err := testutil.NewServer()
+	if err != nil {
+		panic(fmt.Sprintf("psltest.NewServer: %v", err))
+	}
+	lite := newLiteServer()
+	pb.RegisterAdminServiceServer(srv.Gsrv, lite)
+	pb.RegisterPublisherServiceServer(srv.Gsrv, lite)
+	pb.RegisterSubscriberServiceServer(srv.Gsrv, lite)
+	pb.RegisterCursorServiceServer(srv.Gsrv, lite)
+	pb.RegisterPartitionAssignmentServiceServer(srv.Gsrv, lite)
+	pb.RegisterTopicStatsServiceServer(srv.Gsrv, lite)
+	lrpb.RegisterOperationsServer(srv.Gsrv, lite)
+	srv.Start()
+	return &Server{srv: srv, Addr: srv.Addr, lite: lite}
+}
+
+// ClientOptions returns the options required for clients to connect to the
+// fake server.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()),
+	}
+}
+
+// SetTimeNowFunc registers f as a function to be used instead of time.Now for
+// message publish times and operation timestamps.
+func (s *Server) SetTimeNowFunc(f func() time.Time) {
+	s.lite.mu.Lock()
+	defer s.lite.mu.Unlock()
+	s.lite.timeNow = f
+}
+
+// Close shuts down the server and releases all resources.
+func (s *Server) Close() error {
+	s.lite.close()
+	s.srv.Close()
+	return nil
+}
+
+// Message is a message stored by the fake server.
+type Message struct {
+	Offset      int64
+	Key         []byte
+	Data        []byte
+	Attributes  map[string][][]byte
+	EventTime   time.Time // Zero if not set by the publisher.
+	PublishTime time.Time
+	SizeBytes   int64
+}
+
+// Publish behaves as if a message with the given key, data and attributes was
+// published to a partition of the topic. It returns the offset of the message.
+//
+// Publish panics if the topic or partition does not exist, which is
+// appropriate for testing.
+func (s *Server) Publish(topic string, partition int, key, data []byte, attrs map[string]string) int64 {
+	msg := &pb.PubSubMessage{Key: key, Data: data}
+	if len(attrs) > 0 {
+		msg.Attributes = make(map[string]*pb.AttributeValues)
+		for k, v := range attrs {
+			msg.Attributes[k] = &pb.AttributeValues{Values: [][]byte{[]byte(v)}}
+		}
+	}
+	offset, err := s.lite.publish(topic, int64(partition), []*pb.PubSubMessage{msg})
+	if err != nil {
+		panic(fmt.Sprintf("psltest.Publish: %v", err))
+	}
+	return offset
+}
+
+// Messages returns all messages published to a partition of the topic, in
+// offset order. It returns nil if the topic or partition does not exist.
+func (s *Server) Messages(topic string, partition int) []*Message {
+	s.lite.mu.Lock()
+	defer s.lite.mu.Unlock()
+	t, ok := s.lite.topics[topic]
+	if !ok || partition < 0 || partition >= len(t.partitions) {
+		return nil
+	}
+	var msgs []*Message
+	for _, m := range t.partitions[partition].msgs {
+		msg := &Message{
+			Offset:      m.GetCursor().GetOffset(),
+			Key:         m.GetMessage().GetKey(),
+			Data:        m.GetMessage().GetData(),
+			PublishTime: m.GetPublishTime().AsTime(),
+			SizeBytes:   m.GetSizeBytes(),
+		}
+		if m.GetMessage().GetEventTime() != nil {
+			msg.EventTime = m.GetMessage().GetEventTime().AsTime()
+		}
+		if attrs := m.GetMessage().GetAttributes(); len(attrs) > 0 {
+			msg.Attributes = make(map[string][][]byte)
+			for k, v := range attrs {
+				msg.Attributes[k] = v.GetValues()
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// CommittedOffset returns the offset committed by subscribers for a partition
+// of the subscription, and false if no offset has been committed.
+func (s *Server) CommittedOffset(subscription string, partition int) (int64, bool) {
+	s.lite.mu.Lock()
+	defer s.lite.mu.Unlock()
+	sub, ok := s.lite.subs[subscription]
+	if !ok {
+		return 0, false
+	}
+	offset, ok := sub.cursors[int64(partition)]
+	return offset, ok
+}
+
+// liteServer implements the Pub/Sub Lite gRPC services.
+type liteServer struct {
+	pb.UnimplementedAdminServiceServer
+	pb.UnimplementedPublisherServiceServer
+	pb.UnimplementedSubscriberServiceServer
+	pb.UnimplementedCursorServiceServer
+	pb.UnimplementedPartitionAssignmentServiceServer
+	pb.UnimplementedTopicStatsServiceServer
+	lrpb.UnimplementedOperationsServer
+
+	mu           sync.Mutex
+	timeNow      func() time.Time
+	topics       map[string]*topic
+	subs         map[string]*subscription
+	reservations map[string]*pb.Reservation
+	operations   map[string]*lrpb.Operation
+	nextOpID     int
+	done         chan struct{} // closed when the server is shut down
+}
+
+func newLiteServer() *liteServer {
+	return &liteServer{
+		timeNow:      time.Now,
+		topics:       make(map[string]*topic),
+		subs:         make(map[string]*subscription),
+		reservations: make(map[string]*pb.Reservation),
+		operations:   make(map[string]*lrpb.Operation),
+		done:         make(chan struct{}),
+	}
+}
+
+func (s *liteServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+// topic holds the configuration and messages of a topic.
+type topic struct {
+	proto      *pb.Topic
+	partitions []*partitionLog
+}
+
+func newTopic(proto *pb.Topic) *topic {
+	t := &topic{proto: proto}
+	t.resize(proto.GetPartitionConfig().GetCount())
+	return t
+}
+
+// resize grows the number of partitions of the topic. The partition count of
+// a topic cannot decrease.
+func (t *topic) resize(count int64) {
+	for int64(len(t.partitions)) < count {
+		t.partitions = append(t.partitions, newPartitionLog())
+	}
+}
+
+// partitionLog is the ordered list of messages in a topic partition.
+type partitionLog struct {
+	msgs []*pb.SequencedMessage
+	// notify is closed and replaced when messages are appended to the log.
+	notify chan struct{}
+}
+
+func newPartitionLog() *partitionLog {
+	return &partitionLog{notify: make(chan struct{})}
+}
+
+// head returns the offset of the next message to be published.
+func (p *partitionLog) head() int64 {
+	return int64(len(p.msgs))
+}
+
+func (p *partitionLog) append(msgs []*pb.PubSubMessage, publishTime time.Time) (startOffset int64) {
+	startOffset = p.head()
+	for _, msg := range msgs {
+		p.msgs = append(p.msgs, &pb.SequencedMessage{
+			Cursor:      &pb.Cursor{Offset: p.head()},
+			PublishTime: tspb.New(publishTime),
+			Message:     msg,
+			SizeBytes:   int64(proto.Size(msg)),
+		})
+	}
+	close(p.notify)
+	p.notify = make(chan struct{})
+	return startOffset
+}
+
+// offsetForTime returns the offset of the first message with a publish time
+// (or event time if eventTime is true) greater than or equal to t. Messages
+// without an event time are treated as having an event time equal to their
+// publish time. Returns the head offset if there is no such message.
+func (p *partitionLog) offsetForTime(t time.Time, eventTime bool) int64 {
+	for _, m := range p.msgs {
+		ts := m.GetPublishTime()
+		if eventTime && m.GetMessage().GetEventTime() != nil {
+			ts = m.GetMessage().GetEventTime()
+		}
+		if !ts.AsTime().Before(t) {
+			return m.GetCursor().GetOffset()
+		}
+	}
+	return p.head()
+}
+
+// subscription holds the configuration, committed cursors and connected
+// clients of a subscription.
+type subscription struct {
+	proto *pb.Subscription
+	// Committed offsets, keyed by partition.
+	cursors map[int64]int64
+	// Connected subscribe streams, which are reset on out-of-band seeks.
+	streams map[*subscribeStream]bool
+	// Connected partition assignment streams.
+	assignees map[*assignmentStream]bool
+}
+
+func newSubscription(proto *pb.Subscription) *subscription {
+	return &subscription{
+		proto:     proto,
+		cursors:   make(map[int64]int64),
+		streams:   make(map[*subscribeStream]bool),
+		assignees: make(map[*assignmentStream]bool),
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package psltest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsublite"
+	"cloud.google.com/go/pubsublite/pscompat"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	region    = "us-central1"
+	parent    = "projects/P/locations/us-central1-a"
+	topicPath = parent + "/topics/t"
+	subPath   = parent + "/subscriptions/s"
+)
+
+func newAdmin(t *testing.T, srv *Server) *pubsublite.AdminClient {
+	t.Helper()
+	admin, err := pubsublite.NewAdminClient(context.Background(), region, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admin
+}
+
+func createTopicAndSubscription(t *testing.T, admin *pubsublite.AdminClient, partitions int) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := admin.CreateTopic(ctx, pubsublite.TopicConfig{
+		Name:                       topicPath,
+		PartitionCount:             partitions,
+		PublishCapacityMiBPerSec:   4,
+		SubscribeCapacityMiBPerSec: 8,
+		PerPartitionBytes:          30 * 1024 * 1024 * 1024,
+		RetentionDuration:          pubsublite.InfiniteRetention,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.CreateSubscription(ctx, pubsublite.SubscriptionConfig{
+		Name:                subPath,
+		Topic:               topicPath,
+		DeliveryRequirement: pubsublite.DeliverImmediately,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer()
+	defer srv.Close()
+	admin := newAdmin(t, srv)
+	defer admin.Close()
+	createTopicAndSubscription(t, admin, 2)
+
+	if _, err := admin.CreateTopic(ctx, pubsublite.TopicConfig{Name: topicPath, PartitionCount: 1}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateTopic: got err %v, want AlreadyExists", err)
+	}
+
+	updated, err := admin.UpdateTopic(ctx, pubsublite.TopicConfigToUpdate{
+		Name:           topicPath,
+		PartitionCount: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.PartitionCount != 3 || updated.PublishCapacityMiBPerSec != 4 {
+		t.Errorf("UpdateTopic: got %+v", updated)
+	}
+	count, err := admin.TopicPartitionCount(ctx, topicPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("TopicPartitionCount: got %d, want 3", count)
+	}
+
+	it := admin.TopicSubscriptions(ctx, topicPath)
+	var subs []string
+	for {
+		sub, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) != 1 || subs[0] != subPath {
+		t.Errorf("TopicSubscriptions: got %v, want [%s]", subs, subPath)
+	}
+
+	if err := admin.DeleteSubscription(ctx, subPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Subscription(ctx, subPath); status.Code(err) != codes.NotFound {
+		t.Errorf("Subscription: got err %v, want NotFound", err)
+	}
+	if err := admin.DeleteTopic(ctx, topicPath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishReceive(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer()
+	defer srv.Close()
+	admin := newAdmin(t, srv)
+	defer admin.Close()
+	createTopicAndSubscription(t, admin, 2)
+
+	publisher, err := pscompat.NewPublisherClient(ctx, topicPath, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numMsgs = 20
+	var results []*pubsub.PublishResult
+	for i := 0; i < numMsgs; i++ {
+		results = append(results, publisher.Publish(ctx, &pubsub.Message{
+			Data:        []byte(fmt.Sprintf("msg-%d", i)),
+			OrderingKey: fmt.Sprintf("key-%d", i%4),
+		}))
+	}
+	for _, r := range results {
+		if _, err := r.Get(ctx); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	publisher.Stop()
+	if got := len(srv.Messages(topicPath, 0)) + len(srv.Messages(topicPath, 1)); got != numMsgs {
+		t.Fatalf("got %d stored messages, want %d", got, numMsgs)
+	}
+
+	settings := pscompat.DefaultReceiveSettings
+	settings.MaxOutstandingMessages = 5
+	subscriber, err := pscompat.NewSubscriberClientWithSettings(ctx, subPath, settings, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	var mu sync.Mutex
+	received := make(map[string]bool)
+	err = subscriber.Receive(cctx, func(_ context.Context, msg *pubsub.Message) {
+		msg.Ack()
+		mu.Lock()
+		defer mu.Unlock()
+		received[string(msg.Data)] = true
+		if len(received) == numMsgs {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if len(received) != numMsgs {
+		t.Fatalf("received %d messages, want %d", len(received), numMsgs)
+	}
+	for p := 0; p < 2; p++ {
+		want := int64(len(srv.Messages(topicPath, p)))
+		if got, _ := srv.CommittedOffset(subPath, p); got != want {
+			t.Errorf("partition %d: committed offset %d, want %d", p, got, want)
+		}
+	}
+}
+
+func TestSeekSubscription(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer()
+	defer srv.Close()
+	admin := newAdmin(t, srv)
+	defer admin.Close()
+	createTopicAndSubscription(t, admin, 1)
+
+	for i := 0; i < 3; i++ {
+		srv.Publish(topicPath, 0, nil, []byte("old"), nil)
+	}
+	op, err := admin.SeekSubscription(ctx, subPath, pubsublite.End)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := op.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := srv.CommittedOffset(subPath, 0); got != 3 {
+		t.Errorf("committed offset after seek: got %d, want 3", got)
+	}
+	srv.Publish(topicPath, 0, nil, []byte("new"), map[string]string{"k": "v"})
+
+	subscriber, err := pscompat.NewSubscriberClient(ctx, subPath, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	var got *pubsub.Message
+	err = subscriber.Receive(cctx, func(_ context.Context, msg *pubsub.Message) {
+		msg.Ack()
+		got = msg
+		cancel()
+	})
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if got == nil || string(got.Data) != "new" || got.Attributes["k"] != "v" {
+		t.Errorf("received %v, want message published after seek", got)
+	}
+}
+
+func TestSubscriptionBacklog(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer()
+	defer srv.Close()
+	admin := newAdmin(t, srv)
+	defer admin.Close()
+	createTopicAndSubscription(t, admin, 2)
+
+	publishTime := time.Unix(1600000000, 0)
+	srv.SetTimeNowFunc(func() time.Time { return publishTime })
+	var wantBytes int64
+	for i := 0; i < 3; i++ {
+		srv.Publish(topicPath, 0, nil, []byte("msg"), nil)
+	}
+	for _, m := range srv.Messages(topicPath, 0)[1:] {
+		wantBytes += m.SizeBytes
+	}
+	srv.lite.mu.Lock()
+	srv.lite.subs[subPath].cursors[0] = 1
+	srv.lite.mu.Unlock()
+
+	got, err := admin.SubscriptionBacklog(ctx, subPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("SubscriptionBacklog: got %d partitions, want 2", len(got))
+	}
+	if b := got[0]; b.HeadOffset != 3 || b.CommittedOffset != 1 || b.MessageCount != 2 || b.Bytes != wantBytes || !b.OldestUnackedPublishTime.Equal(publishTime) {
+		t.Errorf("partition 0: got %+v", b)
+	}
+	if b := got[1]; b.HeadOffset != 0 || b.MessageCount != 0 || !b.OldestUnackedPublishTime.IsZero() {
+		t.Errorf("partition 1: got %+v", b)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package psltest
+
+import (
+	"context"
+
+	pb "google.golang.org/genproto/googleapis/cloud/pubsublite/v1"
+)
+
+func (s *liteServer) ComputeMessageStats(_ context.Context, req *pb.ComputeMessageStatsRequest) (*pb.ComputeMessageStatsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log, err := s.findPartition(req.GetTopic(), req.GetPartition())
+	if err != nil {
+		return nil, err
+	}
+	// The start cursor is inclusive and the end cursor is exclusive. An unset
+	// end cursor denotes the head of the partition.
+	start := req.GetStartCursor().GetOffset()
+	if start < 0 {
+		start = 0
+	}
+	end := log.head()
+	if req.GetEndCursor() != nil && req.GetEndCursor().GetOffset() < end {
+		end = req.GetEndCursor().GetOffset()
+	}
+	res := &pb.ComputeMessageStatsResponse{}
+	for offset := start; offset < end; offset++ {
+		m := log.msgs[offset]
+		res.MessageCount++
+		res.MessageBytes += m.GetSizeBytes()
+		// Messages without an event time use their publish time.
+		eventTime := m.GetPublishTime()
+		if m.GetMessage().GetEventTime() != nil {
+			eventTime = m.GetMessage().GetEventTime()
+		}
+		if res.MinimumPublishTime == nil || m.GetPublishTime().AsTime().Before(res.MinimumPublishTime.AsTime()) {
+			res.MinimumPublishTime = m.GetPublishTime()
+		}
+		if res.MinimumEventTime == nil || eventTime.AsTime().Before(res.MinimumEventTime.AsTime()) {
+			res.MinimumEventTime = eventTime
+		}
+	}
+	return res, nil
+}
+
+func (s *liteServer) ComputeHeadCursor(_ context.Context, req *pb.ComputeHeadCursorRequest) (*pb.ComputeHeadCursorResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log, err := s.findPartition(req.GetTopic(), req.GetPartition())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ComputeHeadCursorResponse{HeadCursor: &pb.Cursor{Offset: log.head()}}, nil
+}
+
+func (s *liteServer) ComputeTimeCursor(_ context.Context, req *pb.ComputeTimeCursorRequest) (*pb.ComputeTimeCursorResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log, err := s.findPartition(req.GetTopic(), req.GetPartition())
+	if err != nil {
+		return nil, err
+	}
+	var offset int64
+	switch {
+	case req.GetTarget().GetPublishTime() != nil:
+		offset = log.offsetForTime(req.GetTarget().GetPublishTime().AsTime(), false)
+	case req.GetTarget().GetEventTime() != nil:
+		offset = log.offsetForTime(req.GetTarget().GetEventTime().AsTime(), true)
+	}
+	// The cursor is unset if no message meets the target.
+	res := &pb.ComputeTimeCursorResponse{}
+	if offset < log.head() {
+		res.Cursor = &pb.Cursor{Offset: offset}
+	}
+	return res, nil
+}
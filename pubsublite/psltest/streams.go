@@ -0,0 +1,568 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package psltest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"cloud.google.com/go/internal/testutil"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "google.golang.org/genproto/googleapis/cloud/pubsublite/v1"
+)
+
+// The maximum number of messages sent in a single subscribe response.
+const maxMessagesPerResponse = 1000
+
+var errServerClosed = status.Error(codes.Unavailable, "psltest: server closed")
+
+// resetError returns the error that instructs subscribers to reset their state
+// after an out-of-band seek.
+func resetError() error {
+	st, err := status.New(codes.Aborted, "psltest: subscriber reset").WithDetails(&errdetails.ErrorInfo{
+		Reason: "RESET",
+		Domain: "pubsublite.googleapis.com",
+	})
+	if err != nil {
+		panic(err)
+	}
+	return st.Err()
+}
+
+// streamTerminator allows the server to terminate a stream from another
+// goroutine.
+type streamTerminator struct {
+	term chan error
+}
+
+func newStreamTerminator() streamTerminator {
+	return streamTerminator{term: make(chan error, 1)}
+}
+
+// terminate causes the stream to end with err. Only the first error is used.
+func (st streamTerminator) terminate(err error) {
+	select {
+	case st.term <- err:
+	default:
+	}
+}
+
+// receiveRequests reads requests from a server stream in a background
+// goroutine. The returned channel is closed once recv returns an error, which
+// is then stored in *errp.
+func receiveRequests(ctx context.Context, recv func() (interface{}, error), errp *error) <-chan interface{} {
+	reqs := make(chan interface{})
+	go func() {
+		defer close(reqs)
+		for {
+			req, err := recv()
+			if err != nil {
+				*errp = err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				*errp = ctx.Err()
+				return
+			}
+		}
+	}()
+	return reqs
+}
+
+// Publisher
+
+func (s *liteServer) publish(topicPath string, partition int64, msgs []*pb.PubSubMessage) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log, err := s.findPartition(topicPath, partition)
+	if err != nil {
+		return 0, err
+	}
+	return log.append(msgs, s.timeNow()), nil
+}
+
+func (s *liteServer) Publish(stream pb.PublisherService_PublishServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	initReq := req.GetInitialRequest()
+	if initReq == nil {
+		return status.Error(codes.InvalidArgument, "first publish request must be an initial request")
+	}
+	s.mu.Lock()
+	_, err = s.findPartition(initReq.GetTopic(), initReq.GetPartition())
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&pb.PublishResponse{
+		ResponseType: &pb.PublishResponse_InitialResponse{InitialResponse: &pb.InitialPublishResponse{}},
+	}); err != nil {
+		return err
+	}
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		msgReq := req.GetMessagePublishRequest()
+		if msgReq == nil {
+			return status.Error(codes.InvalidArgument, "expected a message publish request")
+		}
+		offset, err := s.publish(initReq.GetTopic(), initReq.GetPartition(), msgReq.GetMessages())
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&pb.PublishResponse{
+			ResponseType: &pb.PublishResponse_MessageResponse{
+				MessageResponse: &pb.MessagePublishResponse{StartCursor: &pb.Cursor{Offset: offset}},
+			},
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// Subscriber
+
+// subscribeStream is a connected subscribe stream for a subscription
+// partition.
+type subscribeStream struct {
+	streamTerminator
+}
+
+func (s *liteServer) Subscribe(stream pb.SubscriberService_SubscribeServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	initReq := req.GetInitial()
+	if initReq == nil {
+		return status.Error(codes.InvalidArgument, "first subscribe request must be an initial request")
+	}
+	partition := initReq.GetPartition()
+
+	s.mu.Lock()
+	sub, log, err := s.findSubscriptionPartition(initReq.GetSubscription(), partition)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	offset, err := s.seekOffset(sub, log, partition, initReq.GetInitialLocation())
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	ss := &subscribeStream{newStreamTerminator()}
+	sub.streams[ss] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(sub.streams, ss)
+		s.mu.Unlock()
+	}()
+
+	if err := stream.Send(&pb.SubscribeResponse{
+		Response: &pb.SubscribeResponse_Initial{
+			Initial: &pb.InitialSubscribeResponse{Cursor: &pb.Cursor{Offset: offset}},
+		},
+	}); err != nil {
+		return err
+	}
+
+	var recvErr error
+	reqs := receiveRequests(stream.Context(), func() (interface{}, error) { return stream.Recv() }, &recvErr)
+	var allowedMessages, allowedBytes int64
+	for {
+		// Deliver as many messages as the flow control tokens allow.
+		s.mu.Lock()
+		var msgs []*pb.SequencedMessage
+		for offset < log.head() && allowedMessages > 0 && len(msgs) < maxMessagesPerResponse {
+			msg := log.msgs[offset]
+			if msg.GetSizeBytes() > allowedBytes {
+				break
+			}
+			msgs = append(msgs, msg)
+			allowedMessages--
+			allowedBytes -= msg.GetSizeBytes()
+			offset++
+		}
+		notify := log.notify
+		s.mu.Unlock()
+
+		if len(msgs) > 0 {
+			if err := stream.Send(&pb.SubscribeResponse{
+				Response: &pb.SubscribeResponse_Messages{Messages: &pb.MessageResponse{Messages: msgs}},
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		select {
+		case <-notify:
+		case r, ok := <-reqs:
+			if !ok {
+				if recvErr == io.EOF {
+					return nil
+				}
+				return recvErr
+			}
+			req := r.(*pb.SubscribeRequest)
+			switch {
+			case req.GetFlowControl() != nil:
+				allowedMessages += req.GetFlowControl().GetAllowedMessages()
+				allowedBytes += req.GetFlowControl().GetAllowedBytes()
+			case req.GetSeek() != nil:
+				s.mu.Lock()
+				offset, err = s.seekOffset(sub, log, partition, req.GetSeek())
+				s.mu.Unlock()
+				if err != nil {
+					return err
+				}
+				if err := stream.Send(&pb.SubscribeResponse{
+					Response: &pb.SubscribeResponse_Seek{Seek: &pb.SeekResponse{Cursor: &pb.Cursor{Offset: offset}}},
+				}); err != nil {
+					return err
+				}
+			default:
+				return status.Error(codes.InvalidArgument, "unexpected subscribe request")
+			}
+		case err := <-ss.term:
+			return err
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.done:
+			return errServerClosed
+		}
+	}
+}
+
+// seekOffset returns the offset that a subscribe stream should seek to.
+// s.mu must be held.
+func (s *liteServer) seekOffset(sub *subscription, log *partitionLog, partition int64, req *pb.SeekRequest) (int64, error) {
+	if req.GetCursor() != nil {
+		offset := req.GetCursor().GetOffset()
+		if offset < 0 {
+			return 0, status.Errorf(codes.InvalidArgument, "invalid seek offset %d", offset)
+		}
+		if offset > log.head() {
+			offset = log.head()
+		}
+		return offset, nil
+	}
+	switch req.GetNamedTarget() {
+	case pb.SeekRequest_HEAD:
+		return log.head(), nil
+	case pb.SeekRequest_COMMITTED_CURSOR, pb.SeekRequest_NAMED_TARGET_UNSPECIFIED:
+		return sub.cursors[partition], nil
+	default:
+		return 0, status.Errorf(codes.InvalidArgument, "invalid seek target %v", req.GetNamedTarget())
+	}
+}
+
+// resetSubscribers instructs all connected subscribers to reset their state.
+// s.mu must be held.
+func (sub *subscription) resetSubscribers() {
+	for ss := range sub.streams {
+		ss.terminate(resetError())
+	}
+}
+
+// terminateClients terminates all streams connected to the subscription.
+// s.mu must be held.
+func (sub *subscription) terminateClients(err error) {
+	for ss := range sub.streams {
+		ss.terminate(err)
+	}
+	for as := range sub.assignees {
+		as.terminate(err)
+	}
+}
+
+// Cursor
+
+func (s *liteServer) commitCursor(subscription string, partition, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, _, err := s.findSubscriptionPartition(subscription, partition)
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid commit offset %d", offset)
+	}
+	sub.cursors[partition] = offset
+	return nil
+}
+
+func (s *liteServer) StreamingCommitCursor(stream pb.CursorService_StreamingCommitCursorServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	initReq := req.GetInitial()
+	if initReq == nil {
+		return status.Error(codes.InvalidArgument, "first commit request must be an initial request")
+	}
+	s.mu.Lock()
+	_, _, err = s.findSubscriptionPartition(initReq.GetSubscription(), initReq.GetPartition())
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&pb.StreamingCommitCursorResponse{
+		Request: &pb.StreamingCommitCursorResponse_Initial{Initial: &pb.InitialCommitCursorResponse{}},
+	}); err != nil {
+		return err
+	}
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		commit := req.GetCommit()
+		if commit == nil {
+			return status.Error(codes.InvalidArgument, "expected a commit request")
+		}
+		if err := s.commitCursor(initReq.GetSubscription(), initReq.GetPartition(), commit.GetCursor().GetOffset()); err != nil {
+			return err
+		}
+		if err := stream.Send(&pb.StreamingCommitCursorResponse{
+			Request: &pb.StreamingCommitCursorResponse_Commit{
+				Commit: &pb.SequencedCommitCursorResponse{AcknowledgedCommits: 1},
+			},
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *liteServer) CommitCursor(_ context.Context, req *pb.CommitCursorRequest) (*pb.CommitCursorResponse, error) {
+	if err := s.commitCursor(req.GetSubscription(), req.GetPartition(), req.GetCursor().GetOffset()); err != nil {
+		return nil, err
+	}
+	return &pb.CommitCursorResponse{}, nil
+}
+
+func (s *liteServer) ListPartitionCursors(_ context.Context, req *pb.ListPartitionCursorsRequest) (*pb.ListPartitionCursorsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, err := s.findSubscription(req.GetParent())
+	if err != nil {
+		return nil, err
+	}
+	var partitions []int64
+	for p := range sub.cursors {
+		partitions = append(partitions, p)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	from, to, nextToken, err := testutil.PageBounds(int(req.GetPageSize()), req.GetPageToken(), len(partitions))
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.ListPartitionCursorsResponse{NextPageToken: nextToken}
+	for _, p := range partitions[from:to] {
+		res.PartitionCursors = append(res.PartitionCursors, &pb.PartitionCursor{
+			Partition: p,
+			Cursor:    &pb.Cursor{Offset: sub.cursors[p]},
+		})
+	}
+	return res, nil
+}
+
+// Partition assignment
+
+// assignmentStream is a connected partition assignment stream for a
+// subscription.
+type assignmentStream struct {
+	streamTerminator
+	clientID string
+
+	mu      sync.Mutex
+	pending []int64 // the latest assignment not yet sent to the client
+	hasNew  bool
+	signal  chan struct{}
+}
+
+// assign queues an assignment to be sent to the client, replacing any queued
+// assignment that has not been sent.
+func (as *assignmentStream) assign(partitions []int64) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.pending = partitions
+	as.hasNew = true
+	select {
+	case as.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (as *assignmentStream) takePending() ([]int64, bool) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	p, ok := as.pending, as.hasNew
+	as.pending, as.hasNew = nil, false
+	return p, ok
+}
+
+func (s *liteServer) AssignPartitions(stream pb.PartitionAssignmentService_AssignPartitionsServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	initReq := req.GetInitial()
+	if initReq == nil {
+		return status.Error(codes.InvalidArgument, "first assignment request must be an initial request")
+	}
+	if len(initReq.GetClientId()) == 0 {
+		return status.Error(codes.InvalidArgument, "missing client ID")
+	}
+
+	as := &assignmentStream{
+		streamTerminator: newStreamTerminator(),
+		clientID:         fmt.Sprintf("%x", initReq.GetClientId()),
+		signal:           make(chan struct{}, 1),
+	}
+	s.mu.Lock()
+	sub, err := s.findSubscription(initReq.GetSubscription())
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	for other := range sub.assignees {
+		if other.clientID == as.clientID {
+			s.mu.Unlock()
+			return status.Error(codes.AlreadyExists, "client ID already connected")
+		}
+	}
+	sub.assignees[as] = true
+	s.rebalance(sub)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(sub.assignees, as)
+		s.rebalance(sub)
+		s.mu.Unlock()
+	}()
+
+	var recvErr error
+	reqs := receiveRequests(stream.Context(), func() (interface{}, error) { return stream.Recv() }, &recvErr)
+	awaitingAck := false
+	for {
+		if !awaitingAck {
+			if partitions, ok := as.takePending(); ok {
+				if err := stream.Send(&pb.PartitionAssignment{Partitions: partitions}); err != nil {
+					return err
+				}
+				awaitingAck = true
+			}
+		}
+
+		select {
+		case <-as.signal:
+		case r, ok := <-reqs:
+			if !ok {
+				if recvErr == io.EOF {
+					return nil
+				}
+				return recvErr
+			}
+			if r.(*pb.PartitionAssignmentRequest).GetAck() == nil {
+				return status.Error(codes.InvalidArgument, "expected an assignment ack")
+			}
+			if !awaitingAck {
+				return status.Error(codes.FailedPrecondition, "unexpected assignment ack")
+			}
+			awaitingAck = false
+		case err := <-as.term:
+			return err
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.done:
+			return errServerClosed
+		}
+	}
+}
+
+// rebalance distributes the partitions of the subscription's topic evenly
+// across connected assignment clients, ordered by client ID.
+// s.mu must be held.
+func (s *liteServer) rebalance(sub *subscription) {
+	if len(sub.assignees) == 0 {
+		return
+	}
+	var partitionCount int
+	if t, ok := s.topics[sub.proto.GetTopic()]; ok {
+		partitionCount = len(t.partitions)
+	}
+	var clients []*assignmentStream
+	for as := range sub.assignees {
+		clients = append(clients, as)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].clientID < clients[j].clientID })
+	assignments := make([][]int64, len(clients))
+	for p := 0; p < partitionCount; p++ {
+		i := p % len(clients)
+		assignments[i] = append(assignments[i], int64(p))
+	}
+	for i, as := range clients {
+		as.assign(assignments[i])
+	}
+}
+
+// Helpers. The functions below must be called with s.mu held.
+
+func (s *liteServer) findPartition(topicPath string, partition int64) (*partitionLog, error) {
+	t, err := s.findTopic(topicPath)
+	if err != nil {
+		return nil, err
+	}
+	if partition < 0 || partition >= int64(len(t.partitions)) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid partition %d for topic %q", partition, topicPath)
+	}
+	return t.partitions[partition], nil
+}
+
+func (s *liteServer) findSubscriptionPartition(subscription string, partition int64) (*subscription, *partitionLog, error) {
+	sub, err := s.findSubscription(subscription)
+	if err != nil {
+		return nil, nil, err
+	}
+	log, err := s.findPartition(sub.proto.GetTopic(), partition)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sub, log, nil
+}
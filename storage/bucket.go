@@ -37,6 +37,7 @@ type BucketHandle struct {
 	defaultObjectACL ACLHandle
 	conds            *BucketConditions
 	userProject      string // project for Requester Pays buckets
+	retry            *retryConfig
 }
 
 // Bucket returns a BucketHandle, which provides operations on the named bucket.
@@ -47,18 +48,22 @@ type BucketHandle struct {
 // found at:
 //   https://cloud.google.com/storage/docs/bucket-naming
 func (c *Client) Bucket(name string) *BucketHandle {
+	retry := c.retry.clone()
 	return &BucketHandle{
 		c:    c,
 		name: name,
 		acl: ACLHandle{
 			c:      c,
 			bucket: name,
+			retry:  retry,
 		},
 		defaultObjectACL: ACLHandle{
 			c:         c,
 			bucket:    name,
 			isDefault: true,
+			retry:     retry,
 		},
+		retry: retry,
 	}
 }
 
@@ -88,7 +93,7 @@ func (b *BucketHandle) Create(ctx context.Context, projectID string, attrs *Buck
 	if attrs != nil && attrs.PredefinedDefaultObjectACL != "" {
 		req.PredefinedDefaultObjectAcl(attrs.PredefinedDefaultObjectACL)
 	}
-	return runWithRetry(ctx, func() error { _, err := req.Context(ctx).Do(); return err })
+	return runWithRetry(ctx, func() error { _, err := req.Context(ctx).Do(); return err }, b.retry, true)
 }
 
 // Delete deletes the Bucket.
@@ -100,7 +105,7 @@ func (b *BucketHandle) Delete(ctx context.Context) (err error) {
 	if err != nil {
 		return err
 	}
-	return runWithRetry(ctx, func() error { return req.Context(ctx).Do() })
+	return runWithRetry(ctx, func() error { return req.Context(ctx).Do() }, b.retry, true)
 }
 
 func (b *BucketHandle) newDeleteCall() (*raw.BucketsDeleteCall, error) {
@@ -137,6 +142,7 @@ func (b *BucketHandle) DefaultObjectACL() *ACLHandle {
 // for valid object names can be found at:
 //   https://cloud.google.com/storage/docs/naming-objects
 func (b *BucketHandle) Object(name string) *ObjectHandle {
+	retry := b.retry.clone()
 	return &ObjectHandle{
 		c:      b.c,
 		bucket: b.name,
@@ -146,9 +152,11 @@ func (b *BucketHandle) Object(name string) *ObjectHandle {
 			bucket:      b.name,
 			object:      name,
 			userProject: b.userProject,
+			retry:       retry,
 		},
 		gen:         -1,
 		userProject: b.userProject,
+		retry:       retry,
 	}
 }
 
@@ -165,7 +173,7 @@ func (b *BucketHandle) Attrs(ctx context.Context) (attrs *BucketAttrs, err error
 	err = runWithRetry(ctx, func() error {
 		resp, err = req.Context(ctx).Do()
 		return err
-	})
+	}, b.retry, true)
 	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
 		return nil, ErrBucketNotExist
 	}
@@ -202,8 +210,9 @@ func (b *BucketHandle) Update(ctx context.Context, uattrs BucketAttrsToUpdate) (
 	if uattrs.PredefinedDefaultObjectACL != "" {
 		req.PredefinedDefaultObjectAcl(uattrs.PredefinedDefaultObjectACL)
 	}
-	// TODO(jba): retry iff metagen is set?
-	rb, err := req.Context(ctx).Do()
+	isIdempotent := b.conds != nil && b.conds.MetagenerationMatch != 0
+	var rb *raw.Bucket
+	err = runWithRetry(ctx, func() error { rb, err = req.Context(ctx).Do(); return err }, b.retry, isIdempotent)
 	if err != nil {
 		return nil, err
 	}
@@ -935,6 +944,34 @@ func (b *BucketHandle) UserProject(projectID string) *BucketHandle {
 	return &b2
 }
 
+// Retryer returns a bucket handle that is configured with custom retry
+// behavior as specified by the options that are passed to it. All operations
+// on the new handle will use the customized retry configuration.
+// Retry options set on a object handle will take precedence over options set on
+// the bucket handle.
+// These retry options will merge with the client's retry configuration (if set)
+// for the returned handle. Options passed into this method will take precedence
+// over retry options on the client. Note that you must explicitly pass in each
+// option you want to override.
+func (b *BucketHandle) Retryer(opts ...RetryOption) *BucketHandle {
+	b2 := *b
+	var retry *retryConfig
+	if b.retry != nil {
+		// Merge the options with a copy of the existing retry, which may be
+		// shared with the client.
+		retry = b.retry.clone()
+	} else {
+		retry = &retryConfig{}
+	}
+	for _, opt := range opts {
+		opt.apply(retry)
+	}
+	b2.retry = retry
+	b2.acl.retry = retry
+	b2.defaultObjectACL.retry = retry
+	return &b2
+}
+
 // LockRetentionPolicy locks a bucket's retention policy until a previously-configured
 // RetentionPeriod past the EffectiveTime. Note that if RetentionPeriod is set to less
 // than a day, the retention policy is treated as a development configuration and locking
@@ -953,7 +990,7 @@ func (b *BucketHandle) LockRetentionPolicy(ctx context.Context) error {
 	return runWithRetry(ctx, func() error {
 		_, err := req.Context(ctx).Do()
 		return err
-	})
+	}, b.retry, true)
 }
 
 // applyBucketConds modifies the provided call using the conditions in conds.
@@ -1246,6 +1283,7 @@ type ObjectIterator struct {
 	pageInfo *iterator.PageInfo
 	nextFunc func() error
 	items    []*ObjectAttrs
+	matcher  *objectMatcher // for queries with MatchGlob or MatchRegexp
 }
 
 // PageInfo supports pagination. See the google.golang.org/api/iterator package for details.
@@ -1279,6 +1317,25 @@ func (it *ObjectIterator) Next() (*ObjectAttrs, error) {
 }
 
 func (it *ObjectIterator) fetch(pageSize int, pageToken string) (string, error) {
+	if it.query.MatchGlob != "" || it.query.MatchRegexp != "" {
+		return it.fetchMatching(pageSize, pageToken)
+	}
+	resp, err := it.list(it.query.Prefix, it.query.Delimiter, pageSize, pageToken)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range resp.Items {
+		it.items = append(it.items, newObject(item))
+	}
+	for _, prefix := range resp.Prefixes {
+		it.items = append(it.items, &ObjectAttrs{Prefix: prefix})
+	}
+	return resp.NextPageToken, nil
+}
+
+// list fetches a page of objects matching the iterator's query, with the
+// given prefix and delimiter.
+func (it *ObjectIterator) list(prefix, delimiter string, pageSize int, pageToken string) (*raw.Objects, error) {
 	req := it.bucket.c.raw.Objects.List(it.bucket.name)
 	setClientHeader(req.Header())
 	projection := it.query.Projection
@@ -1286,13 +1343,13 @@ func (it *ObjectIterator) fetch(pageSize int, pageToken string) (string, error)
 		projection = ProjectionFull
 	}
 	req.Projection(projection.String())
-	req.Delimiter(it.query.Delimiter)
-	req.Prefix(it.query.Prefix)
+	req.Delimiter(delimiter)
+	req.Prefix(prefix)
 	req.StartOffset(it.query.StartOffset)
 	req.EndOffset(it.query.EndOffset)
 	req.Versions(it.query.Versions)
-	if len(it.query.fieldSelection) > 0 {
-		req.Fields("nextPageToken", googleapi.Field(it.query.fieldSelection))
+	if fields := it.query.fieldSelection(); len(fields) > 0 {
+		req.Fields("nextPageToken", googleapi.Field(fields))
 	}
 	req.PageToken(pageToken)
 	if it.bucket.userProject != "" {
@@ -1306,20 +1363,14 @@ func (it *ObjectIterator) fetch(pageSize int, pageToken string) (string, error)
 	err = runWithRetry(it.ctx, func() error {
 		resp, err = req.Context(it.ctx).Do()
 		return err
-	})
+	}, it.bucket.retry, true)
 	if err != nil {
 		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
 			err = ErrBucketNotExist
 		}
-		return "", err
-	}
-	for _, item := range resp.Items {
-		it.items = append(it.items, newObject(item))
-	}
-	for _, prefix := range resp.Prefixes {
-		it.items = append(it.items, &ObjectAttrs{Prefix: prefix})
+		return nil, err
 	}
-	return resp.NextPageToken, nil
+	return resp, nil
 }
 
 // Buckets returns an iterator over the buckets in the project. You may
@@ -1389,7 +1440,7 @@ func (it *BucketIterator) fetch(pageSize int, pageToken string) (token string, e
 	err = runWithRetry(it.ctx, func() error {
 		resp, err = req.Context(it.ctx).Do()
 		return err
-	})
+	}, it.client.retry, true)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,384 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.16
+// +build go1.16
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+var (
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// BucketFS provides read-only access to the objects of a bucket through the
+// io/fs interfaces. It implements fs.FS, fs.ReadDirFS and fs.StatFS, so it can
+// be used with functions such as fs.WalkDir, http.FS and template.ParseFS.
+//
+// Object names are treated as slash-separated paths. A directory is a prefix
+// ending in "/" that is shared by at least one object, as reported by a listing
+// with Query.Delimiter set to "/". Zero-length objects whose names end in "/",
+// which some tools create as directory markers, are not listed as files.
+// Objects whose names are not valid fs paths, such as names containing "//",
+// cannot be opened and are skipped in directory listings. If an object has the
+// same name as a directory, the object takes precedence.
+//
+// Files opened from a BucketFS read the generation of the object that was
+// current when they were opened, and implement io.Seeker and io.ReaderAt. The
+// fs.FileInfo of a file returns its *ObjectAttrs from Sys.
+type BucketFS struct {
+	ctx      context.Context
+	b        *BucketHandle
+	snapshot time.Time
+}
+
+// FS returns a BucketFS for the bucket. ctx is used for all operations of the
+// returned BucketFS and the files opened from it.
+func (b *BucketHandle) FS(ctx context.Context) *BucketFS {
+	return &BucketFS{ctx: ctx, b: b}
+}
+
+// AtTime returns a BucketFS that presents the bucket as it was at time t.
+// Each object is read at the generation that was live at t, so the bucket
+// must have object versioning enabled for the noncurrent generations to be
+// retained.
+//
+// Since prefix listings do not report when the objects under a prefix were
+// created or deleted, a BucketFS returned by AtTime lists every generation of
+// every object under a directory to read the directory.
+func (fsys *BucketFS) AtTime(t time.Time) *BucketFS {
+	return &BucketFS{ctx: fsys.ctx, b: fsys.b, snapshot: t}
+}
+
+// Open opens the named file or directory.
+func (fsys *BucketFS) Open(name string) (fs.File, error) {
+	info, err := fsys.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &bucketDir{fsys: fsys, path: name, info: info}, nil
+	}
+	return &bucketFile{
+		path: name,
+		info: info,
+		r:    newSeekableReader(fsys.ctx, fsys.b.Object(name), info.attrs),
+	}, nil
+}
+
+// Stat returns a FileInfo describing the named file or directory.
+func (fsys *BucketFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fsys.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by
+// filename.
+func (fsys *BucketFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := fsys.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	entries, err := fsys.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// stat returns the fileInfo of the named file or directory, or a
+// *fs.PathError with the given op.
+func (fsys *BucketFS) stat(op, name string) (*fileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &fileInfo{name: "."}, nil
+	}
+	attrs, err := fsys.objectAttrs(name)
+	if err == nil {
+		return &fileInfo{name: path.Base(name), attrs: attrs}, nil
+	}
+	if err != ErrObjectNotExist {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	ok, err := fsys.isDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return &fileInfo{name: path.Base(name)}, nil
+}
+
+// objectAttrs returns the attributes of the named object, or
+// ErrObjectNotExist.
+func (fsys *BucketFS) objectAttrs(name string) (*ObjectAttrs, error) {
+	if fsys.snapshot.IsZero() {
+		return fsys.b.Object(name).Attrs(fsys.ctx)
+	}
+	// List the generations of the object alone.
+	q := &Query{Versions: true, StartOffset: name, EndOffset: name + "\x00"}
+	var live *ObjectAttrs
+	err := fsys.list(q, func(attrs *ObjectAttrs) bool {
+		if attrs.Name == name {
+			live = attrs
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if live == nil {
+		return nil, ErrObjectNotExist
+	}
+	return live, nil
+}
+
+// isDir reports whether at least one object name starts with name + "/".
+func (fsys *BucketFS) isDir(name string) (bool, error) {
+	q := &Query{Prefix: name + "/", Delimiter: "/"}
+	if !fsys.snapshot.IsZero() {
+		q = &Query{Prefix: name + "/", Versions: true}
+	}
+	found := false
+	err := fsys.list(q, func(*ObjectAttrs) bool {
+		found = true
+		return false
+	})
+	return found, err
+}
+
+// readDir returns the entries of the named directory, sorted by name.
+func (fsys *BucketFS) readDir(name string) ([]fs.DirEntry, error) {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+	files := map[string]*fileInfo{}
+	dirs := map[string]bool{}
+	add := func(attrs *ObjectAttrs) bool {
+		rest := strings.TrimPrefix(attrs.Name, prefix)
+		if attrs.Prefix != "" {
+			rest = strings.TrimPrefix(attrs.Prefix, prefix)
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			if base := rest[:i]; validBase(base) {
+				dirs[base] = true
+			}
+		} else if validBase(rest) {
+			files[rest] = &fileInfo{name: rest, attrs: attrs}
+		}
+		return true
+	}
+	q := &Query{Prefix: prefix, Delimiter: "/"}
+	if !fsys.snapshot.IsZero() {
+		q = &Query{Prefix: prefix, Versions: true}
+	}
+	if err := fsys.list(q, add); err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, 0, len(files)+len(dirs))
+	for _, info := range files {
+		entries = append(entries, info)
+	}
+	for base := range dirs {
+		if files[base] == nil {
+			entries = append(entries, &fileInfo{name: base})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// validBase reports whether base can be the name of an entry of a directory.
+func validBase(base string) bool {
+	return base != "." && fs.ValidPath(base)
+}
+
+// list calls f for each object and prefix matching q until f returns false.
+// In a snapshot, only the generations that were live at the snapshot time are
+// passed to f.
+func (fsys *BucketFS) list(q *Query, f func(*ObjectAttrs) bool) error {
+	it := fsys.b.Objects(fsys.ctx, q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fsys.snapshot.IsZero() && !liveAt(attrs, fsys.snapshot) {
+			continue
+		}
+		if !f(attrs) {
+			return nil
+		}
+	}
+}
+
+// liveAt reports whether the object generation described by attrs was live at
+// time t.
+func liveAt(attrs *ObjectAttrs, t time.Time) bool {
+	return !attrs.Created.After(t) && (attrs.Deleted.IsZero() || attrs.Deleted.After(t))
+}
+
+// fileInfo describes a file or directory of a BucketFS. It implements both
+// fs.FileInfo and fs.DirEntry.
+type fileInfo struct {
+	name  string
+	attrs *ObjectAttrs // nil for directories
+}
+
+func (fi *fileInfo) Name() string { return fi.name }
+
+func (fi *fileInfo) Size() int64 {
+	if fi.attrs == nil {
+		return 0
+	}
+	return fi.attrs.Size
+}
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.attrs == nil {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	if fi.attrs == nil {
+		return time.Time{}
+	}
+	return fi.attrs.Updated
+}
+
+func (fi *fileInfo) IsDir() bool { return fi.attrs == nil }
+
+// Sys returns the *ObjectAttrs of a file, or nil for a directory.
+func (fi *fileInfo) Sys() interface{} {
+	if fi.attrs == nil {
+		return nil
+	}
+	return fi.attrs
+}
+
+func (fi *fileInfo) Type() fs.FileMode { return fi.Mode().Type() }
+
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// bucketFile is a file opened from a BucketFS.
+type bucketFile struct {
+	path string
+	info *fileInfo
+	r    *SeekableReader
+}
+
+func (f *bucketFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *bucketFile) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	return n, f.wrapErr("read", err)
+}
+
+func (f *bucketFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.r.ReadAt(p, off)
+	return n, f.wrapErr("read", err)
+}
+
+func (f *bucketFile) Seek(offset int64, whence int) (int64, error) {
+	n, err := f.r.Seek(offset, whence)
+	return n, f.wrapErr("seek", err)
+}
+
+func (f *bucketFile) Close() error {
+	return f.wrapErr("close", f.r.Close())
+}
+
+// wrapErr wraps errors other than io.EOF in a *fs.PathError.
+func (f *bucketFile) wrapErr(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if err == errSeekableReaderClosed {
+		err = fs.ErrClosed
+	}
+	return &fs.PathError{Op: op, Path: f.path, Err: err}
+}
+
+// bucketDir is a directory opened from a BucketFS.
+type bucketDir struct {
+	fsys *BucketFS
+	path string
+	info *fileInfo
+
+	entries []fs.DirEntry // nil until the first call to ReadDir
+	off     int           // index of the next entry to return
+}
+
+func (d *bucketDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *bucketDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errIsDir}
+}
+
+func (d *bucketDir) Close() error { return nil }
+
+// ReadDir reads the entries of the directory, as described by fs.ReadDirFile.
+// The directory is listed in full on the first call.
+func (d *bucketDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		entries, err := d.fsys.readDir(d.path)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.path, Err: err}
+		}
+		d.entries = entries
+	}
+	rest := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.off += n
+	return rest[:n], nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.16
+// +build go1.16
+
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"cloud.google.com/go/storage/storagetest"
+)
+
+func newFSTestBucket(ctx context.Context, t *testing.T, attrs *storage.BucketAttrs) (*storage.BucketHandle, *storagetest.Server, func()) {
+	t.Helper()
+	client, srv, cleanup := storage.NewFakeClient(ctx, t, attrs, nil)
+	return client.Bucket("bucket"), srv, cleanup
+}
+
+func writeObject(ctx context.Context, t *testing.T, b *storage.BucketHandle, name, contents string) {
+	t.Helper()
+	w := b.Object(name).NewWriter(ctx)
+	if _, err := w.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBucketFS(t *testing.T) {
+	ctx := context.Background()
+	b, _, cleanup := newFSTestBucket(ctx, t, nil)
+	defer cleanup()
+
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/sub/c.txt", "marker/", "x//y"} {
+		writeObject(ctx, t, b, name, "contents of "+name)
+	}
+	fsys := b.FS(ctx)
+	if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/sub/c.txt", "marker"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(fsys, "dir/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, ok := info.Sys().(*storage.ObjectAttrs)
+	if !ok || attrs.Name != "dir/b.txt" || attrs.Size != info.Size() {
+		t.Errorf("got Sys() %#v, want the attributes of dir/b.txt", info.Sys())
+	}
+	if _, err := fsys.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v for a missing file, want fs.ErrNotExist", err)
+	}
+	if _, err := fsys.ReadDir("a.txt"); err == nil {
+		t.Error("got nil error reading a file as a directory")
+	}
+}
+
+func TestBucketFSAtTime(t *testing.T) {
+	ctx := context.Background()
+	b, srv, cleanup := newFSTestBucket(ctx, t, &storage.BucketAttrs{VersioningEnabled: true})
+	defer cleanup()
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv.SetTimeNowFunc(func() time.Time { return now })
+	writeObject(ctx, t, b, "kept.txt", "old")
+	writeObject(ctx, t, b, "dir/deleted.txt", "deleted")
+	snapshot := now.Add(time.Minute)
+
+	now = now.Add(2 * time.Minute)
+	writeObject(ctx, t, b, "kept.txt", "new")
+	writeObject(ctx, t, b, "created.txt", "created")
+	if err := b.Object("dir/deleted.txt").Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	old := b.FS(ctx).AtTime(snapshot)
+	if err := fstest.TestFS(old, "kept.txt", "dir/deleted.txt"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(old, "kept.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "old" {
+		t.Errorf("got %q at the snapshot time, want %q", got, "old")
+	}
+	if _, err := fs.Stat(old, "created.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v for an object created after the snapshot, want fs.ErrNotExist", err)
+	}
+
+	cur := b.FS(ctx)
+	if err := fstest.TestFS(cur, "kept.txt", "created.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(cur, "dir"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v for a directory of deleted objects, want fs.ErrNotExist", err)
+	}
+}
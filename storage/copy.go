@@ -138,7 +138,8 @@ func (c *Copier) callRewrite(ctx context.Context, rawObj *raw.Object) (*raw.Rewr
 	var res *raw.RewriteResponse
 	var err error
 	setClientHeader(call.Header())
-	err = runWithRetry(ctx, func() error { res, err = call.Do(); return err })
+	isIdempotent := c.dst.conds != nil && (c.dst.conds.GenerationMatch != 0 || c.dst.conds.DoesNotExist)
+	err = runWithRetry(ctx, func() error { res, err = call.Do(); return err }, c.dst.retry, isIdempotent)
 	if err != nil {
 		return nil, err
 	}
@@ -230,7 +231,8 @@ func (c *Composer) Run(ctx context.Context) (attrs *ObjectAttrs, err error) {
 	}
 	var obj *raw.Object
 	setClientHeader(call.Header())
-	err = runWithRetry(ctx, func() error { obj, err = call.Do(); return err })
+	isIdempotent := c.dst.conds != nil && (c.dst.conds.GenerationMatch != 0 || c.dst.conds.DoesNotExist)
+	err = runWithRetry(ctx, func() error { obj, err = call.Do(); return err }, c.dst.retry, isIdempotent)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,58 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cloud.google.com/go/storage/storagetest"
+	"google.golang.org/api/option"
+)
+
+// newFakeClient returns a Client of a fake server from package storagetest
+// that holds a bucket named "bucket", created with attrs, and a function that
+// closes both. If wrap is not nil, the Client's requests reach the server
+// through the handler returned by wrap, so that tests can record or fail
+// them. Requests for resumable upload sessions go to the server directly.
+func newFakeClient(ctx context.Context, t *testing.T, attrs *BucketAttrs, wrap func(http.Handler) http.Handler) (*Client, *storagetest.Server, func()) {
+	t.Helper()
+	srv := storagetest.NewServer()
+	opts := srv.ClientOptions()
+	closeProxy := func() {}
+	if wrap != nil {
+		ts := httptest.NewServer(wrap(srv))
+		opts = []option.ClientOption{option.WithEndpoint(ts.URL + "/storage/v1/"), option.WithoutAuthentication()}
+		closeProxy = ts.Close
+	}
+	c, err := NewClient(ctx, opts...)
+	if err != nil {
+		closeProxy()
+		srv.Close()
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		c.Close()
+		closeProxy()
+		srv.Close()
+	}
+	if err := c.Bucket("bucket").Create(ctx, "project", attrs); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return c, srv, cleanup
+}
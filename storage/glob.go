@@ -0,0 +1,366 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"google.golang.org/api/iterator"
+)
+
+// glob is a parsed MatchGlob pattern.
+type glob struct {
+	expr      string // the pattern in regexp syntax, unanchored
+	literal   string // the literal prefix of every match
+	wild      bool   // the pattern contains wildcards
+	recursive bool   // the pattern contains "**"
+}
+
+// parseGlob parses a glob pattern as described in Query.MatchGlob.
+func parseGlob(pattern string) (*glob, error) {
+	g := &glob{}
+	var expr, literal strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			g.wild = true
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				g.recursive = true
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			g.wild = true
+			expr.WriteString("[^/]")
+		case '[':
+			g.wild = true
+			end, class, err := globClass(pattern, i)
+			if err != nil {
+				return nil, err
+			}
+			expr.WriteString(class)
+			i = end
+		default:
+			if c == '\\' {
+				if i+1 == len(pattern) {
+					return nil, fmt.Errorf("storage: glob %q ends with a backslash", pattern)
+				}
+				i++
+				c = pattern[i]
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			if !g.wild {
+				literal.WriteByte(c)
+			}
+		}
+	}
+	g.expr = expr.String()
+	g.literal = literal.String()
+	return g, nil
+}
+
+// globClass converts the character class starting at pattern[i] to regexp
+// syntax, and returns the index of its closing bracket.
+func globClass(pattern string, i int) (end int, class string, err error) {
+	var b strings.Builder
+	b.WriteByte('[')
+	j := i + 1
+	negated := j < len(pattern) && (pattern[j] == '!' || pattern[j] == '^')
+	if negated {
+		b.WriteByte('^')
+		j++
+	}
+	start := j
+	for ; j < len(pattern); j++ {
+		c := pattern[j]
+		switch {
+		case c == ']' && j > start:
+			if negated {
+				// Like "?", a negated class never matches "/".
+				b.WriteByte('/')
+			}
+			b.WriteByte(']')
+			if _, err := regexp.Compile(b.String()); err != nil {
+				return 0, "", fmt.Errorf("storage: invalid character class in glob %q: %v", pattern, err)
+			}
+			return j, b.String(), nil
+		case c == '/':
+			return 0, "", fmt.Errorf("storage: character class in glob %q contains /", pattern)
+		case c == '-' && j > start && j+1 < len(pattern) && pattern[j+1] != ']':
+			b.WriteByte('-')
+		case c == '\\' && j+1 < len(pattern):
+			j++
+			b.WriteString(classLiteral(pattern[j]))
+		default:
+			b.WriteString(classLiteral(c))
+		}
+	}
+	return 0, "", fmt.Errorf("storage: unterminated character class in glob %q", pattern)
+}
+
+// classLiteral escapes c for use in a regexp character class.
+func classLiteral(c byte) string {
+	if c < 0x80 && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+		return `\` + string(c)
+	}
+	return string([]byte{c})
+}
+
+// splitGlob splits a glob pattern at each unescaped "/".
+func splitGlob(pattern string) []string {
+	var segs []string
+	start := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '/':
+			segs = append(segs, pattern[start:i])
+			start = i + 1
+		}
+	}
+	return append(segs, pattern[start:])
+}
+
+// regexpLiteralPrefix returns a literal prefix of every string that expr
+// matches in full.
+func regexpLiteralPrefix(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = re.Simplify()
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	var prefix []rune
+	for _, sub := range subs {
+		switch {
+		case sub.Op == syntax.OpBeginText:
+		case sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0:
+			prefix = append(prefix, sub.Rune...)
+		default:
+			return string(prefix)
+		}
+	}
+	return string(prefix)
+}
+
+// globSegment is the part of a MatchGlob pattern between two slashes.
+type globSegment struct {
+	*glob
+	re *regexp.Regexp // matches the segment in full
+}
+
+// listFrame is a pending listing of an objectMatcher.
+type listFrame struct {
+	prefix string // the listing prefix
+	dir    string // the directory being listed, when listing by directory
+	seg    int    // the segment matched by names in dir, or -1 for a flat listing
+	token  string // the next page token
+}
+
+// objectMatcher lists the objects of a query with MatchGlob or
+// MatchRegexp. A flat listing is filtered by the full pattern. When a glob
+// is used on its own, it is instead matched a segment at a time by listing
+// each matching directory with a "/" delimiter, and only segments containing
+// "**" are listed flat.
+type objectMatcher struct {
+	res      []*regexp.Regexp // every name must match all of these
+	segments []*globSegment   // the glob's segments, when listing by directory
+	frames   []*listFrame     // a stack of listings; the top is listed next
+}
+
+func newObjectMatcher(q *Query) (*objectMatcher, error) {
+	if q.Delimiter != "" {
+		return nil, errors.New("storage: Query.Delimiter cannot be used with MatchGlob or MatchRegexp")
+	}
+	m := &objectMatcher{}
+	var prefixes []string
+	if q.MatchGlob != "" {
+		g, err := parseGlob(q.MatchGlob)
+		if err != nil {
+			return nil, err
+		}
+		m.res = append(m.res, regexp.MustCompile(`^`+g.expr+`$`))
+		prefixes = append(prefixes, g.literal)
+	}
+	if q.MatchRegexp != "" {
+		re, err := regexp.Compile(`^(?:` + q.MatchRegexp + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("storage: invalid MatchRegexp: %v", err)
+		}
+		m.res = append(m.res, re)
+		prefixes = append(prefixes, regexpLiteralPrefix(q.MatchRegexp))
+	}
+	// Every match begins with all of the prefixes, so list with the longest
+	// one. If they disagree, nothing can match.
+	listPrefix := q.Prefix
+	for _, p := range prefixes {
+		switch {
+		case strings.HasPrefix(p, listPrefix):
+			listPrefix = p
+		case !strings.HasPrefix(listPrefix, p):
+			return m, nil
+		}
+	}
+
+	if q.MatchRegexp != "" || q.Prefix != "" || q.StartOffset != "" || q.EndOffset != "" {
+		m.frames = []*listFrame{{prefix: listPrefix, seg: -1}}
+		return m, nil
+	}
+	for _, s := range splitGlob(q.MatchGlob) {
+		g, err := parseGlob(s)
+		if err != nil {
+			return nil, err
+		}
+		m.segments = append(m.segments, &globSegment{glob: g, re: regexp.MustCompile(`^` + g.expr + `$`)})
+	}
+	m.frames = []*listFrame{m.frameAt("", 0)}
+	return m, nil
+}
+
+// frameAt returns the listing of the names in dir that match segment seg.
+func (m *objectMatcher) frameAt(dir string, seg int) *listFrame {
+	// Literal directories need no listing.
+	for seg < len(m.segments)-1 && !m.segments[seg].wild {
+		dir += m.segments[seg].literal + "/"
+		seg++
+	}
+	s := m.segments[seg]
+	if s.recursive {
+		return &listFrame{prefix: dir + s.literal, seg: -1}
+	}
+	return &listFrame{prefix: dir + s.literal, dir: dir, seg: seg}
+}
+
+func (m *objectMatcher) match(name string) bool {
+	for _, re := range m.res {
+		if !re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchMatching is the fetch function of an ObjectIterator whose query has
+// MatchGlob or MatchRegexp.
+func (it *ObjectIterator) fetchMatching(pageSize int, pageToken string) (string, error) {
+	if it.matcher == nil {
+		m, err := newObjectMatcher(&it.query)
+		if err != nil {
+			return "", err
+		}
+		if m.segments == nil && len(m.frames) == 1 {
+			// A flat listing can be resumed from a page token.
+			m.frames[0].token = pageToken
+		}
+		it.matcher = m
+	}
+	m := it.matcher
+	if len(m.frames) == 0 {
+		return "", nil
+	}
+	f := m.frames[len(m.frames)-1]
+	delimiter := ""
+	if f.seg >= 0 {
+		delimiter = "/"
+	}
+	resp, err := it.list(f.prefix, delimiter, pageSize, f.token)
+	if err != nil {
+		return "", err
+	}
+	f.token = resp.NextPageToken
+	if f.token == "" {
+		m.frames = m.frames[:len(m.frames)-1]
+	}
+	if f.seg < 0 || f.seg == len(m.segments)-1 {
+		for _, item := range resp.Items {
+			if m.match(item.Name) {
+				it.items = append(it.items, newObject(item))
+			}
+		}
+	} else {
+		// Directories are listed before the rest of f, so that objects are
+		// returned in order.
+		var children []*listFrame
+		for _, p := range resp.Prefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(p, f.dir), "/")
+			if m.segments[f.seg].re.MatchString(name) {
+				children = append(children, m.frameAt(p, f.seg+1))
+			}
+		}
+		for i := len(children) - 1; i >= 0; i-- {
+			m.frames = append(m.frames, children[i])
+		}
+	}
+	if m.segments == nil || len(m.frames) == 0 {
+		return f.token, nil
+	}
+	// The token only needs to be non-empty to continue the iteration.
+	next := m.frames[len(m.frames)-1]
+	return next.prefix + next.token, nil
+}
+
+// ExpandURL returns handles for the objects named by a URL of the form
+// "gs://bucket/pattern", where pattern is a glob as described in
+// Query.MatchGlob. If the pattern has no wildcards, the handle of the object
+// it names is returned without checking that the object exists. Wildcards
+// are not supported in bucket names.
+func (c *Client) ExpandURL(ctx context.Context, url string) ([]*ObjectHandle, error) {
+	if !strings.HasPrefix(url, "gs://") {
+		return nil, fmt.Errorf("storage: %q is not a gs:// URL", url)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(url, "gs://"), "/", 2)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("storage: URL %q does not name an object", url)
+	}
+	if strings.ContainsAny(parts[0], "*?[") {
+		return nil, fmt.Errorf("storage: wildcards are not supported in bucket names: %q", url)
+	}
+	b := c.Bucket(parts[0])
+	g, err := parseGlob(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if !g.wild {
+		return []*ObjectHandle{b.Object(g.literal)}, nil
+	}
+	q := &Query{MatchGlob: parts[1]}
+	if err := q.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, err
+	}
+	var objs []*ObjectHandle
+	it := b.Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, b.Object(attrs.Name))
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+)
+
+// listRecorder records the prefix and delimiter of each list request made
+// to a fake server.
+type listRecorder struct {
+	next  http.Handler
+	mu    sync.Mutex
+	lists []string
+}
+
+func (l *listRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/o") {
+		q := r.URL.Query()
+		l.mu.Lock()
+		l.lists = append(l.lists, q.Get("prefix")+"|"+q.Get("delimiter"))
+		l.mu.Unlock()
+	}
+	l.next.ServeHTTP(w, r)
+}
+
+func (l *listRecorder) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lists := l.lists
+	l.lists = nil
+	return lists
+}
+
+func newGlobTestBucket(ctx context.Context, t *testing.T) (*storage.Client, *listRecorder, func()) {
+	t.Helper()
+	rec := &listRecorder{}
+	client, _, cleanup := storage.NewFakeClient(ctx, t, nil, func(h http.Handler) http.Handler {
+		rec.next = h
+		return rec
+	})
+	b := client.Bucket("bucket")
+	for _, name := range []string{
+		"logs/2021-01/a/part-1.parquet",
+		"logs/2021-01/a/b/part-2.parquet",
+		"logs/2021-01/a/part-3.csv",
+		"logs/2021-02/part-4.parquet",
+		"logs/2020-12/a/part-5.parquet",
+		"logs/readme",
+		"data/x/1.txt",
+		"data/x/2.txt",
+		"data/y/3.txt",
+		"data/y/z/4.txt",
+		"data/z.txt",
+	} {
+		w := b.Object(name).NewWriter(ctx)
+		if err := w.Close(); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	rec.take()
+	return client, rec, cleanup
+}
+
+func TestObjectsMatchGlob(t *testing.T) {
+	ctx := context.Background()
+	client, rec, cleanup := newGlobTestBucket(ctx, t)
+	defer cleanup()
+	b := client.Bucket("bucket")
+
+	for _, test := range []struct {
+		q         *storage.Query
+		pageSize  int
+		want      []string
+		wantLists []string
+	}{
+		{
+			q:         &storage.Query{MatchGlob: "logs/2021-*/**/part-*.parquet"},
+			want:      []string{"logs/2021-01/a/b/part-2.parquet", "logs/2021-01/a/part-1.parquet"},
+			wantLists: []string{"logs/2021-|/", "logs/2021-01/|", "logs/2021-02/|"},
+		},
+		{
+			q:         &storage.Query{MatchGlob: "data/*/?.txt"},
+			want:      []string{"data/x/1.txt", "data/x/2.txt", "data/y/3.txt"},
+			wantLists: []string{"data/|/", "data/x/|/", "data/y/|/"},
+		},
+		{
+			// Pages of every listing are followed, in order.
+			q:         &storage.Query{MatchGlob: "data/*/?.txt"},
+			pageSize:  1,
+			want:      []string{"data/x/1.txt", "data/x/2.txt", "data/y/3.txt"},
+			wantLists: []string{"data/|/", "data/x/|/", "data/x/|/", "data/|/", "data/y/|/", "data/y/|/", "data/|/"},
+		},
+		{
+			q:         &storage.Query{MatchGlob: "data/[xy]/z/*"},
+			want:      []string{"data/y/z/4.txt"},
+			wantLists: []string{"data/|/", "data/x/z/|/", "data/y/z/|/"},
+		},
+		{
+			q:         &storage.Query{MatchGlob: "logs/readme"},
+			want:      []string{"logs/readme"},
+			wantLists: []string{"logs/readme|/"},
+		},
+		{
+			q:         &storage.Query{MatchGlob: "*/z.txt"},
+			want:      []string{"data/z.txt"},
+			wantLists: []string{"|/", "data/z.txt|/", "logs/z.txt|/"},
+		},
+		{
+			q:         &storage.Query{MatchGlob: "**.csv"},
+			want:      []string{"logs/2021-01/a/part-3.csv"},
+			wantLists: []string{"|"},
+		},
+		{
+			q:         &storage.Query{MatchGlob: "*/x/*", Prefix: "data/"},
+			want:      []string{"data/x/1.txt", "data/x/2.txt"},
+			wantLists: []string{"data/|"},
+		},
+		{
+			q:         &storage.Query{MatchGlob: "data/**", Prefix: "data/y"},
+			want:      []string{"data/y/3.txt", "data/y/z/4.txt"},
+			wantLists: []string{"data/y|"},
+		},
+		{
+			// The prefixes disagree, so nothing is listed.
+			q:    &storage.Query{MatchGlob: "data/**", Prefix: "logs/"},
+			want: nil,
+		},
+		{
+			q:         &storage.Query{MatchRegexp: `logs/2021-\d+/[^/]+/part-\d\.parquet`},
+			want:      []string{"logs/2021-01/a/part-1.parquet"},
+			wantLists: []string{"logs/2021-|"},
+		},
+		{
+			q:         &storage.Query{MatchGlob: "data/**", MatchRegexp: `.*/[13]\.txt`},
+			want:      []string{"data/x/1.txt", "data/y/3.txt"},
+			wantLists: []string{"data/|"},
+		},
+	} {
+		it := b.Objects(ctx, test.q)
+		it.PageInfo().MaxSize = test.pageSize
+		var got []string
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%+v: %v", test.q, err)
+			}
+			got = append(got, attrs.Name)
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%+v: got=-, want=+:\n%s", test.q, diff)
+		}
+		if diff := cmp.Diff(rec.take(), test.wantLists); diff != "" {
+			t.Errorf("%+v: lists: got=-, want=+:\n%s", test.q, diff)
+		}
+	}
+}
+
+func TestObjectsMatchErrors(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newGlobTestBucket(ctx, t)
+	defer cleanup()
+	b := client.Bucket("bucket")
+	for _, q := range []*storage.Query{
+		{MatchGlob: "a/[b"},
+		{MatchRegexp: "a(b"},
+		{MatchGlob: "a/*", Delimiter: "/"},
+	} {
+		if _, err := b.Objects(ctx, q).Next(); err == nil || err == iterator.Done {
+			t.Errorf("%+v: got %v, want an error", q, err)
+		}
+	}
+}
+
+func TestExpandURL(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newGlobTestBucket(ctx, t)
+	defer cleanup()
+
+	for _, test := range []struct {
+		url  string
+		want []string
+	}{
+		{"gs://bucket/data/*/*.txt", []string{"data/x/1.txt", "data/x/2.txt", "data/y/3.txt"}},
+		{"gs://bucket/logs/**.csv", []string{"logs/2021-01/a/part-3.csv"}},
+		{"gs://bucket/nothing*", nil},
+		{"gs://bucket/missing", []string{"missing"}},
+		{`gs://bucket/literal\*`, []string{"literal*"}},
+	} {
+		objs, err := client.ExpandURL(ctx, test.url)
+		if err != nil {
+			t.Errorf("%s: %v", test.url, err)
+			continue
+		}
+		var got []string
+		for _, o := range objs {
+			if o.BucketName() != "bucket" {
+				t.Errorf("%s: got bucket %q", test.url, o.BucketName())
+			}
+			got = append(got, o.ObjectName())
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%s: got=-, want=+:\n%s", test.url, diff)
+		}
+	}
+
+	for _, url := range []string{"bucket/obj", "gs://bucket", "gs://bucket/", "gs:///obj", "gs://b*/obj", "gs://bucket/[a"} {
+		if _, err := client.ExpandURL(ctx, url); err == nil {
+			t.Errorf("%s: got nil error", url)
+		}
+	}
+}
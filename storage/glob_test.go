@@ -0,0 +1,150 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseGlob(t *testing.T) {
+	for _, test := range []struct {
+		pattern   string
+		literal   string
+		recursive bool
+		match     []string
+		noMatch   []string
+	}{
+		{
+			pattern: "logs/a.txt",
+			literal: "logs/a.txt",
+			match:   []string{"logs/a.txt"},
+			noMatch: []string{"logs/aXtxt", "logs/a.txt2"},
+		},
+		{
+			pattern: "logs/*.txt",
+			literal: "logs/",
+			match:   []string{"logs/a.txt", "logs/.txt"},
+			noMatch: []string{"logs/a/b.txt", "logs/a.txt.gz"},
+		},
+		{
+			pattern:   "logs/2021-*/**/part-*.parquet",
+			literal:   "logs/2021-",
+			recursive: true,
+			match:     []string{"logs/2021-01/x/part-1.parquet", "logs/2021-01/x/y/part-.parquet"},
+			noMatch:   []string{"logs/2021-01/part-1.parquet", "logs/2021/x/part-1.parquet", "logs/2021-01/x/y/part/1.parquet"},
+		},
+		{
+			pattern: "a?c",
+			literal: "a",
+			match:   []string{"abc", "a.c"},
+			noMatch: []string{"a/c", "ac", "abbc"},
+		},
+		{
+			pattern: "[a-c]x[!0-9]",
+			literal: "",
+			match:   []string{"axa", "cx-"},
+			noMatch: []string{"dxa", "ax1", "ax/"},
+		},
+		{
+			pattern: "[^]]z[]]",
+			match:   []string{"az]"},
+			noMatch: []string{"]z]"},
+		},
+		{
+			pattern: "[-.]",
+			match:   []string{"-", "."},
+			noMatch: []string{","},
+		},
+		{
+			pattern: `a\*b\[c`,
+			literal: "a*b[c",
+			match:   []string{"a*b[c"},
+			noMatch: []string{"axb[c"},
+		},
+		{
+			pattern: "[語a]x",
+			match:   []string{"語x", "ax"},
+			noMatch: []string{"本x"},
+		},
+		{
+			pattern: "日本/?",
+			literal: "日本/",
+			match:   []string{"日本/語"},
+			noMatch: []string{"日本/語語"},
+		},
+	} {
+		g, err := parseGlob(test.pattern)
+		if err != nil {
+			t.Errorf("%q: %v", test.pattern, err)
+			continue
+		}
+		if g.literal != test.literal || g.recursive != test.recursive {
+			t.Errorf("%q: got literal %q, recursive %t; want %q, %t", test.pattern, g.literal, g.recursive, test.literal, test.recursive)
+		}
+		re := regexp.MustCompile("^" + g.expr + "$")
+		for _, name := range test.match {
+			if !re.MatchString(name) {
+				t.Errorf("%q does not match %q", test.pattern, name)
+			}
+		}
+		for _, name := range test.noMatch {
+			if re.MatchString(name) {
+				t.Errorf("%q matches %q", test.pattern, name)
+			}
+		}
+	}
+
+	for _, pattern := range []string{"a[", "a[b", "[z-a]", "[a/b]", `a\`} {
+		if _, err := parseGlob(pattern); err == nil {
+			t.Errorf("%q: got nil error", pattern)
+		}
+	}
+}
+
+func TestSplitGlob(t *testing.T) {
+	for pattern, want := range map[string][]string{
+		"a":          {"a"},
+		"a/*/b":      {"a", "*", "b"},
+		`a\/b/c`:     {`a\/b`, "c"},
+		"dir/":       {"dir", ""},
+		"**/x/[a-c]": {"**", "x", "[a-c]"},
+	} {
+		if diff := cmp.Diff(splitGlob(pattern), want); diff != "" {
+			t.Errorf("%q: got=-, want=+:\n%s", pattern, diff)
+		}
+	}
+}
+
+func TestRegexpLiteralPrefix(t *testing.T) {
+	for expr, want := range map[string]string{
+		`logs/.*`:         "logs/",
+		`^logs/2021-\d+`:  "logs/2021-",
+		`abc`:             "abc",
+		`a\.b[0-9]`:       "a.b",
+		`(?i)logs/.*`:     "",
+		`abc|abd`:         "ab",
+		`logs|data`:       "",
+		`.*\.txt`:         "",
+		`x(a|b)`:          "x",
+		`not a (valid re`: "",
+	} {
+		if got := regexpLiteralPrefix(expr); got != want {
+			t.Errorf("%q: got %q, want %q", expr, got, want)
+		}
+	}
+}
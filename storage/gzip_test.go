@@ -0,0 +1,100 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestWriterGzipContent(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := storage.NewFakeClient(ctx, t, nil, nil)
+	defer cleanup()
+	b := client.Bucket("bucket")
+	obj := b.Object("obj")
+	data := []byte(strings.Repeat("<html><body>hello, world</body></html>\n", 100))
+
+	w := obj.NewWriter(ctx)
+	w.GzipContent = true
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	attrs := w.Attrs()
+	if attrs.ContentEncoding != "gzip" {
+		t.Errorf("got ContentEncoding %q, want gzip", attrs.ContentEncoding)
+	}
+	if want := "text/html; charset=utf-8"; attrs.ContentType != want {
+		t.Errorf("got ContentType %q, want %q", attrs.ContentType, want)
+	}
+	if attrs.Size >= int64(len(data)) {
+		t.Errorf("got size %d, want less than %d", attrs.Size, len(data))
+	}
+
+	readAll := func(r *storage.Reader, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(readAll(obj.ReadCompressed(true).NewReader(ctx))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("stored object does not decompress to the data written: %v", err)
+	}
+	if got := readAll(obj.NewReader(ctx)); !bytes.Equal(got, data) {
+		t.Error("transcoded read differs from the data written")
+	}
+	if got := readAll(obj.DecompressClientSide(true).NewReader(ctx)); !bytes.Equal(got, data) {
+		t.Error("decompressed read differs from the data written")
+	}
+	if got := readAll(obj.DecompressClientSide(true).NewRangeReader(ctx, 100, 50)); !bytes.Equal(got, data[100:150]) {
+		t.Errorf("decompressed range read: got %q, want %q", got, data[100:150])
+	}
+
+	for _, test := range []struct {
+		desc  string
+		setup func(*storage.Writer)
+	}{
+		{"SendCRC32C", func(w *storage.Writer) { w.SendCRC32C = true }},
+		{"MD5", func(w *storage.Writer) { w.MD5 = []byte("0123456789abcdef") }},
+		{"ContentEncoding", func(w *storage.Writer) { w.ContentEncoding = "br" }},
+	} {
+		w := b.Object("bad").NewWriter(ctx)
+		w.GzipContent = true
+		test.setup(w)
+		w.Write(data)
+		if err := w.Close(); err == nil {
+			t.Errorf("%s: got nil error, want one", test.desc)
+		}
+	}
+}
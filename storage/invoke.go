@@ -27,21 +27,55 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+var defaultRetry = &retryConfig{}
+
 // runWithRetry calls the function until it returns nil or a non-retryable error, or
 // the context is done.
-func runWithRetry(ctx context.Context, call func() error) error {
-	return internal.Retry(ctx, gax.Backoff{}, func() (stop bool, err error) {
+// isIdempotent reports whether the operation is idempotent. If retry is nil,
+// the default retry configuration is used, which only retries idempotent
+// operations.
+func runWithRetry(ctx context.Context, call func() error, retry *retryConfig, isIdempotent bool) error {
+	if retry == nil {
+		retry = defaultRetry
+	}
+	if (retry.policy == RetryIdempotent && !isIdempotent) || retry.policy == RetryNever {
+		return call()
+	}
+	bo := gax.Backoff{}
+	if retry.backoff != nil {
+		bo.Multiplier = retry.backoff.Multiplier
+		bo.Initial = retry.backoff.Initial
+		bo.Max = retry.backoff.Max
+	}
+	var errorFunc func(err error) bool = shouldRetry
+	if retry.shouldRetry != nil {
+		errorFunc = retry.shouldRetry
+	}
+	return internal.Retry(ctx, bo, func() (stop bool, err error) {
 		err = call()
 		if err == nil {
 			return true, nil
 		}
-		if shouldRetry(err) {
+		if errorFunc(err) {
 			return false, nil
 		}
 		return true, err
 	})
 }
 
+// ShouldRetry returns true if an error is retryable, based on best practice
+// guidance from GCS. See
+// https://cloud.google.com/storage/docs/retry-strategy#go for more information
+// on what errors are considered retryable.
+//
+// If you would like to customize retryable errors, use the WithErrorFunc to
+// supply a RetryOption to your library calls. For example, to retry additional
+// errors, you can write a custom func that wraps ShouldRetry and also specifies
+// additional errors that should return true.
+func ShouldRetry(err error) bool {
+	return shouldRetry(err)
+}
+
 func shouldRetry(err error) bool {
 	if err == io.ErrUnexpectedEOF {
 		return true
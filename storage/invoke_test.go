@@ -20,7 +20,9 @@ import (
 	"io"
 	"net/url"
 	"testing"
+	"time"
 
+	gax "github.com/googleapis/gax-go/v2"
 	"golang.org/x/xerrors"
 
 	"google.golang.org/api/googleapi"
@@ -56,9 +58,85 @@ func TestInvoke(t *testing.T) {
 			}
 			return test.finalErr
 		}
-		got := runWithRetry(ctx, call)
+		got := runWithRetry(ctx, call, nil, true)
 		if got != test.finalErr {
 			t.Errorf("%+v: got %v, want %v", test, got, test.finalErr)
 		}
 	}
 }
+
+func TestInvokeRetryConfig(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	retryableErr := &googleapi.Error{Code: 503}
+	nonRetryableErr := &googleapi.Error{Code: 400}
+	fastBackoff := WithBackoff(gax.Backoff{Initial: time.Millisecond, Max: time.Millisecond})
+
+	for _, test := range []struct {
+		desc         string
+		opts         []RetryOption
+		isIdempotent bool
+		err          error // Error to return on the first call.
+		wantCalls    int
+	}{
+		{
+			desc:         "default retries idempotent",
+			isIdempotent: true,
+			err:          retryableErr,
+			wantCalls:    2,
+		},
+		{
+			desc:         "default does not retry non-idempotent",
+			isIdempotent: false,
+			err:          retryableErr,
+			wantCalls:    1,
+		},
+		{
+			desc:         "RetryAlways retries non-idempotent",
+			opts:         []RetryOption{WithPolicy(RetryAlways)},
+			isIdempotent: false,
+			err:          retryableErr,
+			wantCalls:    2,
+		},
+		{
+			desc:         "RetryNever does not retry idempotent",
+			opts:         []RetryOption{WithPolicy(RetryNever)},
+			isIdempotent: true,
+			err:          retryableErr,
+			wantCalls:    1,
+		},
+		{
+			desc:         "custom error func retries otherwise permanent error",
+			opts:         []RetryOption{WithErrorFunc(func(err error) bool { return err == nonRetryableErr })},
+			isIdempotent: true,
+			err:          nonRetryableErr,
+			wantCalls:    2,
+		},
+		{
+			desc:         "custom error func does not retry default retryable error",
+			opts:         []RetryOption{WithErrorFunc(func(err error) bool { return false })},
+			isIdempotent: true,
+			err:          retryableErr,
+			wantCalls:    1,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			retry := &retryConfig{}
+			for _, opt := range append([]RetryOption{fastBackoff}, test.opts...) {
+				opt.apply(retry)
+			}
+			calls := 0
+			call := func() error {
+				calls++
+				if calls == 1 {
+					return test.err
+				}
+				return nil
+			}
+			runWithRetry(ctx, call, retry, test.isIdempotent)
+			if calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, test.wantCalls)
+			}
+		})
+	}
+}
@@ -137,7 +137,11 @@ func (b *BucketHandle) AddNotification(ctx context.Context, n *Notification) (re
 	if b.userProject != "" {
 		call.UserProject(b.userProject)
 	}
-	rn, err := call.Context(ctx).Do()
+	var rn *raw.Notification
+	err = runWithRetry(ctx, func() error {
+		rn, err = call.Context(ctx).Do()
+		return err
+	}, b.retry, false)
 	if err != nil {
 		return nil, err
 	}
@@ -159,7 +163,7 @@ func (b *BucketHandle) Notifications(ctx context.Context) (n map[string]*Notific
 	err = runWithRetry(ctx, func() error {
 		res, err = call.Context(ctx).Do()
 		return err
-	})
+	}, b.retry, true)
 	if err != nil {
 		return nil, err
 	}
@@ -186,5 +190,5 @@ func (b *BucketHandle) DeleteNotification(ctx context.Context, id string) (err e
 	}
 	return runWithRetry(ctx, func() error {
 		return call.Context(ctx).Do()
-	})
+	}, b.retry, true)
 }
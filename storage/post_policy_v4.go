@@ -261,12 +261,12 @@ func GenerateSignedPostPolicyV4(bucket, object string, opts *PostPolicyV4Options
 		conditionStatusCodeOnSuccess(descFields.StatusCodeOnSuccess),
 	)
 
-	YYYYMMDD := now.Format(yearMonthDay)
+	credential := opts.GoogleAccessID + "/" + v4CredentialScope(now)
 	policyFields := map[string]string{
 		"key":                     object,
 		"x-goog-date":             now.Format(iso8601),
-		"x-goog-credential":       opts.GoogleAccessID + "/" + YYYYMMDD + "/auto/storage/goog4_request",
-		"x-goog-algorithm":        "GOOG4-RSA-SHA256",
+		"x-goog-credential":       credential,
+		"x-goog-algorithm":        v4RSAAlgorithm,
 		"acl":                     descFields.ACL,
 		"cache-control":           descFields.CacheControl,
 		"content-disposition":     descFields.ContentDisposition,
@@ -285,11 +285,8 @@ func GenerateSignedPostPolicyV4(bucket, object string, opts *PostPolicyV4Options
 		&singleValueCondition{"bucket", bucket},
 		&singleValueCondition{"key", object},
 		&singleValueCondition{"x-goog-date", now.Format(iso8601)},
-		&singleValueCondition{
-			name:  "x-goog-credential",
-			value: opts.GoogleAccessID + "/" + YYYYMMDD + "/auto/storage/goog4_request",
-		},
-		&singleValueCondition{"x-goog-algorithm", "GOOG4-RSA-SHA256"},
+		&singleValueCondition{"x-goog-credential", credential},
+		&singleValueCondition{"x-goog-algorithm", v4RSAAlgorithm},
 	)
 
 	nonEmptyConds := make([]PostPolicyV4Condition, 0, len(opts.Conditions))
@@ -15,6 +15,7 @@
 package storage
 
 import (
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -96,6 +97,9 @@ func (o *ObjectHandle) NewRangeReader(ctx context.Context, offset, length int64)
 	ctx = trace.StartSpan(ctx, "cloud.google.com/go/storage.Object.NewRangeReader")
 	defer func() { trace.EndSpan(ctx, err) }()
 
+	if o.decompress {
+		return o.newDecompressingReader(ctx, offset, length)
+	}
 	if o.c.gc != nil {
 		return o.newRangeReaderWithGRPC(ctx, offset, length)
 	}
@@ -211,7 +215,7 @@ func (o *ObjectHandle) NewRangeReader(ctx context.Context, offset, length int64)
 				gen = gen64
 			}
 			return nil
-		})
+		}, o.retry, true)
 		if err != nil {
 			return nil, err
 		}
@@ -297,16 +301,143 @@ func (o *ObjectHandle) NewRangeReader(ctx context.Context, offset, length int64)
 		Metageneration:  metaGen,
 	}
 	return &Reader{
-		Attrs:    attrs,
-		body:     body,
-		size:     size,
-		remain:   remain,
-		wantCRC:  crc,
-		checkCRC: checkCRC,
-		reopen:   reopen,
+		Attrs:      attrs,
+		body:       body,
+		size:       size,
+		remain:     remain,
+		wantCRC:    crc,
+		checkCRC:   checkCRC,
+		reopen:     reopen,
+		transcoded: uncompressedByServer(res),
+	}, nil
+}
+
+// newDecompressingReader implements NewRangeReader for handles with
+// DecompressClientSide set. Objects that are not gzip-encoded are read as
+// usual. Otherwise the whole object is read compressed, so that the Reader
+// checks its CRC32C, and the range is selected from the decompressed data.
+func (o *ObjectHandle) newDecompressingReader(ctx context.Context, offset, length int64) (*Reader, error) {
+	raw := o.ReadCompressed(true)
+	raw.decompress = false
+	r, err := raw.NewRangeReader(ctx, offset, length)
+	var rangeErr error
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+		// The offset may be beyond the end of the compressed data, but not
+		// of the decompressed data.
+		rangeErr = err
+		r, err = raw.NewRangeReader(ctx, 0, -1)
+	}
+	if err != nil || length == 0 {
+		return r, err
+	}
+	// The server may still have decompressed the object, in which case the
+	// data is read as is.
+	gzipped := r.Attrs.ContentEncoding == "gzip"
+	if !gzipped && !r.transcoded {
+		if rangeErr != nil {
+			r.Close()
+			return nil, rangeErr
+		}
+		return r, nil
+	}
+	if offset < 0 {
+		r.Close()
+		return nil, fmt.Errorf("storage: cannot read the last %d bytes of gzip-encoded object %q with DecompressClientSide", -offset, o.object)
+	}
+	// A range of a gzip-encoded object is either ignored or applied to the
+	// compressed data. In the latter case, read the whole object instead.
+	if whole := r.Attrs.StartOffset == 0 && r.remain == r.Attrs.Size; !whole {
+		r.Close()
+		if gen := r.Attrs.Generation; gen > 0 {
+			raw = raw.Generation(gen)
+		}
+		if r, err = raw.NewRangeReader(ctx, 0, -1); err != nil {
+			return nil, err
+		}
+		gzipped = r.Attrs.ContentEncoding == "gzip"
+	}
+
+	var body io.Reader = r
+	if gzipped {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("storage: decompressing object %q: %v", o.object, err)
+		}
+		body = zr
+	}
+	if offset > 0 {
+		if _, err := io.CopyN(ioutil.Discard, body, offset); err != nil {
+			r.Close()
+			if err == io.EOF {
+				return nil, fmt.Errorf("storage: offset %d is beyond the decompressed content of object %q", offset, o.object)
+			}
+			return nil, err
+		}
+	}
+	db := &decompressedBody{body: body, r: r}
+	remain := int64(-1)
+	if length > 0 {
+		db.limited = &io.LimitedReader{R: body, N: length}
+		db.body = db.limited
+		remain = length
+	}
+	attrs := r.Attrs
+	attrs.StartOffset = offset
+	return &Reader{
+		Attrs:  attrs,
+		body:   db,
+		size:   r.size,
+		remain: remain,
 	}, nil
 }
 
+// decompressedBody is the body of a Reader returned by
+// newDecompressingReader. Reading it reads and checks the Reader of the
+// compressed data, which is closed by Close.
+//
+// The CRC32C of the compressed data is only checked once it has all been
+// read. So when a range has been read, the rest of the compressed data is
+// read as well, at EOF or, if the range was read without reaching EOF, at
+// Close, and a mismatch is reported there.
+type decompressedBody struct {
+	body    io.Reader
+	r       *Reader           // compressed data
+	limited *io.LimitedReader // body, if a range is read
+	drained bool
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if err == io.EOF && b.limited != nil {
+		if derr := b.drain(); derr != nil {
+			return n, derr
+		}
+	}
+	return n, err
+}
+
+// drain reads the rest of the compressed data, so that its CRC32C is checked.
+func (b *decompressedBody) drain() error {
+	if b.drained {
+		return nil
+	}
+	b.drained = true
+	_, err := io.Copy(ioutil.Discard, b.r)
+	return err
+}
+
+func (b *decompressedBody) Close() error {
+	var err error
+	if b.limited != nil && b.limited.N == 0 {
+		err = b.drain()
+	}
+	if cerr := b.r.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 // decompressiveTranscoding returns true if the request was served decompressed
 // and different than its original storage form. This happens when the "Content-Encoding"
 // header is "gzip".
@@ -373,6 +504,7 @@ type Reader struct {
 	wantCRC            uint32 // the CRC32c value the server sent in the header
 	gotCRC             uint32 // running crc
 	reopen             func(seen int64) (*http.Response, error)
+	transcoded         bool // the server decompressed gzip-encoded data
 
 	// The following fields are only for use in the gRPC hybrid client.
 	stream         storagepb.Storage_ReadObjectClient
@@ -398,8 +530,12 @@ func (r *Reader) Close() error {
 
 func (r *Reader) Read(p []byte) (int, error) {
 	read := r.readWithRetry
-	if r.reopenWithGRPC != nil {
+	switch {
+	case r.reopenWithGRPC != nil:
 		read = r.readWithGRPC
+	case r.reopen == nil:
+		// The body retries by itself, as in newDecompressingReader.
+		read = r.body.Read
 	}
 
 	n, err := read(p)
@@ -490,7 +626,7 @@ func (o *ObjectHandle) newRangeReaderWithGRPC(ctx context.Context, offset, lengt
 			msg, err = stream.Recv()
 
 			return err
-		})
+		}, o.retry, true)
 		if err != nil {
 			// Close the stream context we just created to ensure we don't leak
 			// resources.
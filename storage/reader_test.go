@@ -15,12 +15,16 @@
 package storage
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
@@ -347,3 +351,194 @@ func TestFakeReadCloser(t *testing.T) {
 		}
 	}
 }
+
+func TestDecompressClientSide(t *testing.T) {
+	data := strings.Repeat("decompressive transcoding ", 20)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(data))
+	zw.Close()
+	compressed := buf.Bytes()
+	crc := crc32.Checksum(compressed, crc32cTable)
+
+	for _, test := range []struct {
+		desc           string
+		transcode      bool // the server decompresses despite Accept-Encoding
+		crc            uint32
+		offset, length int64
+		want           string
+		wantRequests   []string
+		wantErr        string
+	}{
+		{
+			desc:         "whole object",
+			crc:          crc,
+			offset:       0,
+			length:       -1,
+			want:         data,
+			wantRequests: []string{" "},
+		},
+		{
+			desc:         "range of compressed object",
+			crc:          crc,
+			offset:       3,
+			length:       5,
+			want:         data[3:8],
+			wantRequests: []string{"bytes=3-7 ", " generation=5"},
+		},
+		{
+			desc:   "range to the end",
+			crc:    crc,
+			offset: int64(len(data) - 4),
+			length: -1,
+			want:   data[len(data)-4:],
+			// The offset is beyond the end of the compressed data.
+			wantRequests: []string{fmt.Sprintf("bytes=%d- ", len(data)-4), " "},
+		},
+		{
+			desc:         "range of transcoded object",
+			transcode:    true,
+			offset:       3,
+			length:       5,
+			want:         data[3:8],
+			wantRequests: []string{"bytes=3-7 "},
+		},
+		{
+			desc:    "bad CRC",
+			crc:     crc + 1,
+			offset:  0,
+			length:  -1,
+			wantErr: "bad CRC",
+		},
+		{
+			desc:    "negative offset",
+			crc:     crc,
+			offset:  -3,
+			length:  -1,
+			wantErr: "cannot read the last 3 bytes",
+		},
+		{
+			desc:    "offset beyond the end",
+			crc:     crc,
+			offset:  int64(len(data) + 1),
+			length:  -1,
+			wantErr: "beyond the decompressed content",
+		},
+	} {
+		var requests []string
+		hc, close := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Header.Get("Range")+" "+r.URL.RawQuery)
+			w.Header().Set("X-Goog-Generation", "5")
+			w.Header().Set("X-Goog-Stored-Content-Encoding", "gzip")
+			w.Header().Set("X-Goog-Hash", "crc32c="+encodeUint32(test.crc))
+			if test.transcode || r.Header.Get("Accept-Encoding") != "gzip" {
+				// Transcoded responses ignore the range.
+				w.Write([]byte(data))
+				return
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			var start, end int
+			if n, _ := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); n > 0 {
+				if n == 1 || end >= len(compressed) {
+					end = len(compressed) - 1
+				}
+				if start < 0 {
+					start += len(compressed)
+				}
+				if start >= len(compressed) {
+					w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+					return
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(compressed)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(compressed[start : end+1])
+				return
+			}
+			w.Write(compressed)
+		})
+		ctx := context.Background()
+		c, err := NewClient(ctx, option.WithHTTPClient(hc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := c.Bucket("b").Object("o").DecompressClientSide(true)
+		var got []byte
+		r, err := obj.NewRangeReader(ctx, test.offset, test.length)
+		if err == nil {
+			got, err = ioutil.ReadAll(r)
+			r.Close()
+		}
+		close()
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: got error %v, want it to contain %q", test.desc, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.desc, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got %q, want %q", test.desc, got, test.want)
+		}
+		if !reflect.DeepEqual(requests, test.wantRequests) {
+			t.Errorf("%s: got requests %q, want %q", test.desc, requests, test.wantRequests)
+		}
+	}
+}
+
+func TestDecompressClientSideRangeCRC(t *testing.T) {
+	data := strings.Repeat("decompressive transcoding ", 20)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(data))
+	zw.Close()
+	crc := crc32.Checksum(buf.Bytes(), crc32cTable)
+	// Corrupt the stored gzip trailer, which a range read does not need to
+	// decompress.
+	stored := append([]byte(nil), buf.Bytes()...)
+	stored[len(stored)-1] ^= 0xff
+
+	hc, close := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Goog-Generation", "5")
+		w.Header().Set("X-Goog-Stored-Content-Encoding", "gzip")
+		w.Header().Set("X-Goog-Hash", "crc32c="+encodeUint32(crc))
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(stored)
+	})
+	defer close()
+	ctx := context.Background()
+	c, err := NewClient(ctx, option.WithHTTPClient(hc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := c.Bucket("b").Object("o").DecompressClientSide(true)
+
+	// The mismatch is reported at EOF.
+	r, err := obj.NewRangeReader(ctx, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err == nil || !strings.Contains(err.Error(), "bad CRC") {
+		t.Errorf("ReadAll: got %q, %v, want a bad CRC error", got, err)
+	}
+
+	// Or at Close, if the range is read without reaching EOF.
+	r, err = obj.NewRangeReader(ctx, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 5)
+	if _, err := io.ReadFull(r, p); err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != data[3:8] {
+		t.Errorf("got %q, want %q", p, data[3:8])
+	}
+	if err := r.Close(); err == nil || !strings.Contains(err.Error(), "bad CRC") {
+		t.Errorf("Close: got %v, want a bad CRC error", err)
+	}
+}
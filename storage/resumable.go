@@ -0,0 +1,541 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	raw "google.golang.org/api/storage/v1"
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// uploadSession is a resumable upload session of the JSON API or of gRPC.
+type uploadSession interface {
+	// id returns the identifier of the session that is passed to
+	// Writer.SessionFunc and ObjectHandle.ResumeWriter.
+	id() string
+
+	// query returns the number of bytes persisted by the service, or the
+	// created object if the upload has completed.
+	query(ctx context.Context) (int64, *ObjectAttrs, error)
+
+	// write sends data at offset off of the object, and completes the upload
+	// if final is true. It returns the number of bytes persisted by the
+	// service, or the created object if the upload has completed.
+	write(ctx context.Context, data []byte, off int64, final bool) (int64, *ObjectAttrs, error)
+}
+
+// ResumeWriter returns a Writer that continues the resumable upload identified
+// by session, which was passed to the SessionFunc of an earlier Writer for the
+// same object, possibly in another process. Sessions started by a Client that
+// uses gRPC can only be resumed by a Client that uses gRPC, and the same holds
+// for the JSON API.
+//
+// ResumeWriter asks the service how many bytes of the upload have been
+// persisted, and returns that number as offset. The data written to the
+// returned Writer must continue the object's contents from that offset. The
+// object attributes, preconditions and checksums were fixed when the session
+// was started, so the ObjectAttrs, SendCRC32C and SessionFunc of the returned
+// Writer are ignored. Its ChunkSize and ProgressFunc are used as usual; the
+// byte counts passed to ProgressFunc include offset.
+//
+// If the upload has already completed, ResumeWriter returns the size of the
+// object as offset, and the returned Writer reports the created object from
+// Attrs once it is closed. Writing data to such a Writer is an error.
+func (o *ObjectHandle) ResumeWriter(ctx context.Context, session string) (w *Writer, offset int64, err error) {
+	if err := o.validate(); err != nil {
+		return nil, 0, err
+	}
+	var s uploadSession
+	if o.c.gc != nil {
+		s = &grpcUploadSession{o: o, uploadID: session}
+	} else {
+		u, err := url.Parse(session)
+		if err != nil || !u.IsAbs() {
+			return nil, 0, fmt.Errorf("storage: invalid upload session URI %q", session)
+		}
+		s = &jsonUploadSession{o: o, uri: session}
+	}
+	var obj *ObjectAttrs
+	err = runWithRetry(ctx, func() error {
+		offset, obj, err = s.query(ctx)
+		return err
+	}, o.retry, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	w = o.NewWriter(ctx)
+	w.session = s
+	w.offset = offset
+	if obj != nil {
+		w.completed = obj
+		offset = obj.Size
+	}
+	return w, offset, nil
+}
+
+// startSession starts a resumable upload of the object with the attributes
+// and checksums of w.
+func (o *ObjectHandle) startSession(ctx context.Context, w *Writer) (uploadSession, error) {
+	if o.c.gc != nil {
+		return o.startGRPCSession(ctx, w)
+	}
+	return o.startJSONSession(ctx, w)
+}
+
+// uploadResumable copies the data read from r to the resumable upload session
+// of w, starting a new session if w does not resume one, and returns the
+// created object.
+func (w *Writer) uploadResumable(r io.Reader) (*ObjectAttrs, error) {
+	if w.completed != nil {
+		if n, _ := io.Copy(ioutil.Discard, r); n > 0 {
+			return nil, errors.New("storage: cannot write to an upload session that has completed")
+		}
+		return w.completed, nil
+	}
+	s := w.session
+	if s == nil {
+		var err error
+		// Starting a session writes no data, so it is idempotent.
+		err = runWithRetry(w.ctx, func() error {
+			s, err = w.o.startSession(w.ctx, w)
+			return err
+		}, w.o.retry, true)
+		if err != nil {
+			return nil, err
+		}
+		if w.SessionFunc != nil {
+			w.SessionFunc(s.id())
+		}
+	}
+
+	chunkSize := w.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = googleapi.DefaultUploadChunkSize
+	}
+	if rem := chunkSize % googleapi.MinUploadChunkSize; rem != 0 {
+		chunkSize += googleapi.MinUploadChunkSize - rem
+	}
+	buf := make([]byte, chunkSize)
+	off := w.offset
+	for {
+		n, err := io.ReadFull(r, buf)
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return nil, err
+		}
+		obj, err := w.sendChunk(s, buf[:n], off, final)
+		if err != nil {
+			return nil, err
+		}
+		off += int64(n)
+		if w.ProgressFunc != nil {
+			w.ProgressFunc(off)
+		}
+		if final {
+			return obj, nil
+		}
+	}
+}
+
+// sendChunk writes data at offset off to the session, and completes the upload
+// if final is true. After a failed request, the session is queried for the
+// number of bytes persisted, and the rest of the data is sent again. As the
+// requests write to a single session at known offsets, they cannot duplicate a
+// write, and are retried under any retry policy but RetryNever.
+func (w *Writer) sendChunk(s uploadSession, data []byte, off int64, final bool) (*ObjectAttrs, error) {
+	var (
+		obj      *ObjectAttrs
+		sent     int64 // bytes of data persisted
+		retrying bool
+	)
+	// persisted records the response to a query or write.
+	persisted := func(committed int64, o *ObjectAttrs) error {
+		if o != nil {
+			obj = o
+			sent = int64(len(data))
+			return nil
+		}
+		if committed < off+sent || committed > off+int64(len(data)) {
+			return fmt.Errorf("storage: upload session %s persisted %d bytes, want between %d and %d", s.id(), committed, off+sent, off+int64(len(data)))
+		}
+		sent = committed - off
+		return nil
+	}
+	err := runWithRetry(w.ctx, func() error {
+		if retrying {
+			committed, o, err := s.query(w.ctx)
+			if err != nil {
+				return err
+			}
+			if err := persisted(committed, o); err != nil {
+				return err
+			}
+		}
+		retrying = true
+		for sent < int64(len(data)) || (final && obj == nil) {
+			before := sent
+			committed, o, err := s.write(w.ctx, data[sent:], off+sent, final)
+			if err != nil {
+				return err
+			}
+			if err := persisted(committed, o); err != nil {
+				return err
+			}
+			if obj == nil && sent == before {
+				return fmt.Errorf("storage: upload session %s persisted no data at offset %d", s.id(), off+sent)
+			}
+		}
+		return nil
+	}, w.o.retry, true)
+	if err != nil {
+		return nil, err
+	}
+	if final && obj == nil {
+		return nil, fmt.Errorf("storage: upload session %s did not complete", s.id())
+	}
+	return obj, nil
+}
+
+// jsonUploadSession is a resumable upload session of the JSON API, identified
+// by its session URI.
+type jsonUploadSession struct {
+	o   *ObjectHandle
+	uri string
+}
+
+// uploadParams holds the query parameters of a request that starts a
+// resumable upload. It has the precondition methods that applyConds searches
+// for by name.
+type uploadParams url.Values
+
+func (p uploadParams) IfGenerationMatch(gen int64) {
+	url.Values(p).Set("ifGenerationMatch", strconv.FormatInt(gen, 10))
+}
+
+func (p uploadParams) IfGenerationNotMatch(gen int64) {
+	url.Values(p).Set("ifGenerationNotMatch", strconv.FormatInt(gen, 10))
+}
+
+func (p uploadParams) IfMetagenerationMatch(gen int64) {
+	url.Values(p).Set("ifMetagenerationMatch", strconv.FormatInt(gen, 10))
+}
+
+func (p uploadParams) IfMetagenerationNotMatch(gen int64) {
+	url.Values(p).Set("ifMetagenerationNotMatch", strconv.FormatInt(gen, 10))
+}
+
+func (o *ObjectHandle) startJSONSession(ctx context.Context, w *Writer) (*jsonUploadSession, error) {
+	attrs := w.ObjectAttrs
+	rawObj := attrs.toRawObject(o.bucket)
+	if w.SendCRC32C {
+		rawObj.Crc32c = encodeUint32(attrs.CRC32C)
+	}
+	if w.MD5 != nil {
+		rawObj.Md5Hash = base64.StdEncoding.EncodeToString(w.MD5)
+	}
+	body, err := json.Marshal(rawObj)
+	if err != nil {
+		return nil, err
+	}
+
+	params := uploadParams{
+		"alt":        {"json"},
+		"name":       {o.object},
+		"projection": {"full"},
+		"uploadType": {"resumable"},
+	}
+	if attrs.KMSKeyName != "" {
+		params["kmsKeyName"] = []string{attrs.KMSKeyName}
+	}
+	if attrs.PredefinedACL != "" {
+		params["predefinedAcl"] = []string{attrs.PredefinedACL}
+	}
+	if o.userProject != "" {
+		params["userProject"] = []string{o.userProject}
+	}
+	if err := applyConds("NewWriter", o.gen, o.conds, params); err != nil {
+		return nil, err
+	}
+	u := googleapi.ResolveRelative(o.c.raw.BasePath, "/upload/storage/v1/b/{bucket}/o")
+	u = strings.Replace(u, "{bucket}", url.PathEscape(o.bucket), 1)
+	req, err := http.NewRequest("POST", u+"?"+url.Values(params).Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if attrs.ContentType != "" {
+		req.Header.Set("X-Upload-Content-Type", attrs.ContentType)
+	}
+	if err := setEncryptionHeaders(req.Header, o.encryptionKey, false); err != nil {
+		return nil, err
+	}
+	setClientHeader(req.Header)
+	resp, err := o.c.hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil, errors.New("storage: resumable upload response has no session URI")
+	}
+	return &jsonUploadSession{o: o, uri: loc}, nil
+}
+
+func (s *jsonUploadSession) id() string { return s.uri }
+
+func (s *jsonUploadSession) query(ctx context.Context) (int64, *ObjectAttrs, error) {
+	return s.put(ctx, nil, "bytes */*")
+}
+
+func (s *jsonUploadSession) write(ctx context.Context, data []byte, off int64, final bool) (int64, *ObjectAttrs, error) {
+	rng, total := "*", "*"
+	if len(data) > 0 {
+		rng = fmt.Sprintf("%d-%d", off, off+int64(len(data))-1)
+	}
+	if final {
+		total = strconv.FormatInt(off+int64(len(data)), 10)
+	}
+	return s.put(ctx, data, fmt.Sprintf("bytes %s/%s", rng, total))
+}
+
+// put sends data to the session URI with the given Content-Range header.
+func (s *jsonUploadSession) put(ctx context.Context, data []byte, contentRange string) (int64, *ObjectAttrs, error) {
+	req, err := http.NewRequest("PUT", s.uri, bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Range", contentRange)
+	if err := setEncryptionHeaders(req.Header, s.o.encryptionKey, false); err != nil {
+		return 0, nil, err
+	}
+	setClientHeader(req.Header)
+	resp, err := s.o.c.hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPermanentRedirect {
+		// The upload is incomplete. The Range header, if any, gives the bytes
+		// persisted as "bytes=0-LAST".
+		rng := resp.Header.Get("Range")
+		if rng == "" {
+			return 0, nil, nil
+		}
+		last, err := strconv.ParseInt(strings.TrimPrefix(rng, "bytes=0-"), 10, 64)
+		if err != nil {
+			return 0, nil, fmt.Errorf("storage: invalid Range header %q in resumable upload response", rng)
+		}
+		return last + 1, nil, nil
+	}
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return 0, nil, err
+	}
+	var obj raw.Object
+	if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return 0, nil, err
+	}
+	return 0, newObject(&obj), nil
+}
+
+// grpcUploadSession is a resumable upload session of gRPC, identified by its
+// upload ID.
+type grpcUploadSession struct {
+	o        *ObjectHandle
+	uploadID string
+}
+
+func (o *ObjectHandle) startGRPCSession(ctx context.Context, w *Writer) (*grpcUploadSession, error) {
+	if o.gen >= 0 {
+		return nil, errors.New("storage: NewWriter: generation not supported")
+	}
+	if w.KMSKeyName != "" {
+		return nil, errors.New("storage: KMSKeyName is not supported with gRPC")
+	}
+	if o.conds != nil {
+		if err := o.conds.validate("NewWriter"); err != nil {
+			return nil, err
+		}
+	}
+	attrs := w.ObjectAttrs
+	if !w.SendCRC32C {
+		attrs.CRC32C = 0
+	}
+	spec := &storagepb.WriteObjectSpec{Resource: attrs.toProtoObject(o.bucket)}
+	if w.SendCRC32C {
+		spec.Resource.Checksums.Crc32C = proto.Uint32(w.CRC32C)
+	}
+	if attrs.PredefinedACL != "" {
+		acl, ok := storagepb.PredefinedObjectAcl_value["OBJECT_ACL_"+toProtoEnumName(attrs.PredefinedACL)]
+		if !ok {
+			return nil, fmt.Errorf("storage: unknown predefined ACL %q", attrs.PredefinedACL)
+		}
+		spec.PredefinedAcl = storagepb.PredefinedObjectAcl(acl)
+	}
+	setWriteSpecConditions(spec, o.conds)
+	resp, err := o.c.gc.StartResumableWrite(ctx, &storagepb.StartResumableWriteRequest{
+		WriteObjectSpec:           spec,
+		CommonObjectRequestParams: o.commonObjectRequestParams(),
+		CommonRequestParams:       o.commonRequestParams(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &grpcUploadSession{o: o, uploadID: resp.GetUploadId()}, nil
+}
+
+// toProtoEnumName converts a JSON API enum value such as "authenticatedRead"
+// to the form used in proto enum names, "AUTHENTICATED_READ".
+func toProtoEnumName(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if r >= 'A' && r <= 'Z' && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
+
+// setWriteSpecConditions applies the given Conditions to a gRPC write spec.
+func setWriteSpecConditions(spec *storagepb.WriteObjectSpec, conds *Conditions) {
+	if conds == nil {
+		return
+	}
+	if conds.MetagenerationMatch != 0 {
+		spec.IfMetagenerationMatch = proto.Int64(conds.MetagenerationMatch)
+	} else if conds.MetagenerationNotMatch != 0 {
+		spec.IfMetagenerationNotMatch = proto.Int64(conds.MetagenerationNotMatch)
+	}
+	switch {
+	case conds.GenerationNotMatch != 0:
+		spec.IfGenerationNotMatch = proto.Int64(conds.GenerationNotMatch)
+	case conds.GenerationMatch != 0:
+		spec.IfGenerationMatch = proto.Int64(conds.GenerationMatch)
+	case conds.DoesNotExist:
+		spec.IfGenerationMatch = proto.Int64(0)
+	}
+}
+
+// commonObjectRequestParams returns the customer-supplied encryption key of o
+// for a gRPC request, or nil.
+func (o *ObjectHandle) commonObjectRequestParams() *storagepb.CommonObjectRequestParams {
+	if o.encryptionKey == nil {
+		return nil
+	}
+	hash := sha256.Sum256(o.encryptionKey)
+	return &storagepb.CommonObjectRequestParams{
+		EncryptionAlgorithm:      "AES256",
+		EncryptionKeyBytes:       o.encryptionKey,
+		EncryptionKeySha256Bytes: hash[:],
+	}
+}
+
+// commonRequestParams returns the user project of o for a gRPC request, or
+// nil.
+func (o *ObjectHandle) commonRequestParams() *storagepb.CommonRequestParams {
+	if o.userProject == "" {
+		return nil
+	}
+	return &storagepb.CommonRequestParams{UserProject: o.userProject}
+}
+
+func (s *grpcUploadSession) id() string { return s.uploadID }
+
+func (s *grpcUploadSession) query(ctx context.Context) (int64, *ObjectAttrs, error) {
+	resp, err := s.o.c.gc.QueryWriteStatus(ctx, &storagepb.QueryWriteStatusRequest{
+		UploadId:                  s.uploadID,
+		CommonObjectRequestParams: s.o.commonObjectRequestParams(),
+		CommonRequestParams:       s.o.commonRequestParams(),
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if obj := resp.GetResource(); obj != nil {
+		return 0, newObjectFromProto(&storagepb.WriteObjectResponse{
+			WriteStatus: &storagepb.WriteObjectResponse_Resource{Resource: obj},
+		}), nil
+	}
+	return resp.GetCommittedSize(), nil, nil
+}
+
+func (s *grpcUploadSession) write(ctx context.Context, data []byte, off int64, final bool) (int64, *ObjectAttrs, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := s.o.c.gc.WriteObject(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	// Messages carry at most MAX_WRITE_CHUNK_BYTES of data. A final request
+	// without data still needs a message to finish the write.
+	const maxData = int(storagepb.ServiceConstants_MAX_WRITE_CHUNK_BYTES)
+	for i := 0; i == 0 || i < len(data); i += maxData {
+		end := i + maxData
+		if end > len(data) {
+			end = len(data)
+		}
+		req := &storagepb.WriteObjectRequest{
+			WriteOffset: off + int64(i),
+			FinishWrite: final && end == len(data),
+		}
+		if i == 0 {
+			req.FirstMessage = &storagepb.WriteObjectRequest_UploadId{UploadId: s.uploadID}
+			req.CommonObjectRequestParams = s.o.commonObjectRequestParams()
+			req.CommonRequestParams = s.o.commonRequestParams()
+		}
+		if end > i {
+			req.Data = &storagepb.WriteObjectRequest_ChecksummedData{
+				ChecksummedData: &storagepb.ChecksummedData{
+					Content: data[i:end],
+					Crc32C:  proto.Uint32(crc32.Checksum(data[i:end], crc32cTable)),
+				},
+			}
+		}
+		if err := stream.Send(req); err != nil {
+			if err == io.EOF {
+				// The server closed the stream; CloseAndRecv reports why.
+				break
+			}
+			return 0, nil, err
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.GetResource() != nil {
+		return 0, newObjectFromProto(resp), nil
+	}
+	return resp.GetCommittedSize(), nil, nil
+}
@@ -0,0 +1,271 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeWriteServer implements the resumable write methods of the gRPC API.
+type fakeWriteServer struct {
+	storagepb.UnimplementedStorageServer
+
+	mu      sync.Mutex
+	specs   map[string]*storagepb.WriteObjectSpec
+	data    map[string][]byte
+	objects map[string]*storagepb.Object
+	// failAfter, if positive, makes WriteObject fail once after receiving
+	// that many bytes.
+	failAfter int
+}
+
+func (s *fakeWriteServer) StartResumableWrite(ctx context.Context, req *storagepb.StartResumableWriteRequest) (*storagepb.StartResumableWriteResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := strconv.Itoa(len(s.specs) + 1)
+	s.specs[id] = req.GetWriteObjectSpec()
+	return &storagepb.StartResumableWriteResponse{UploadId: id}, nil
+}
+
+func (s *fakeWriteServer) QueryWriteStatus(ctx context.Context, req *storagepb.QueryWriteStatusRequest) (*storagepb.QueryWriteStatusResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj := s.objects[req.GetUploadId()]; obj != nil {
+		return &storagepb.QueryWriteStatusResponse{WriteStatus: &storagepb.QueryWriteStatusResponse_Resource{Resource: obj}}, nil
+	}
+	if s.specs[req.GetUploadId()] == nil {
+		return nil, status.Errorf(codes.NotFound, "upload %q not found", req.GetUploadId())
+	}
+	return &storagepb.QueryWriteStatusResponse{WriteStatus: &storagepb.QueryWriteStatusResponse_CommittedSize{CommittedSize: int64(len(s.data[req.GetUploadId()]))}}, nil
+}
+
+func (s *fakeWriteServer) WriteObject(stream storagepb.Storage_WriteObjectServer) error {
+	var id string
+	received := 0
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if id == "" {
+			id = req.GetUploadId()
+			if s.specs[id] == nil {
+				s.mu.Unlock()
+				return status.Errorf(codes.NotFound, "upload %q not found", id)
+			}
+		}
+		data := s.data[id]
+		if req.GetWriteOffset() != int64(len(data)) {
+			s.mu.Unlock()
+			return status.Errorf(codes.InvalidArgument, "got offset %d, want %d", req.GetWriteOffset(), len(data))
+		}
+		content := req.GetChecksummedData().GetContent()
+		if crc := crc32.Checksum(content, crc32cTable); req.GetChecksummedData() != nil && crc != req.GetChecksummedData().GetCrc32C() {
+			s.mu.Unlock()
+			return status.Errorf(codes.InvalidArgument, "bad checksum")
+		}
+		s.data[id] = append(data, content...)
+		received += len(content)
+		if s.failAfter > 0 && received >= s.failAfter {
+			s.failAfter = 0
+			s.mu.Unlock()
+			return status.Errorf(codes.Unavailable, "injected failure")
+		}
+		if req.GetFinishWrite() {
+			spec := s.specs[id]
+			obj := &storagepb.Object{
+				Bucket:      spec.GetResource().GetBucket(),
+				Name:        spec.GetResource().GetName(),
+				ContentType: spec.GetResource().GetContentType(),
+				Size:        int64(len(s.data[id])),
+				Generation:  1,
+				Checksums:   &storagepb.ObjectChecksums{Crc32C: spec.GetResource().GetChecksums().Crc32C},
+			}
+			s.objects[id] = obj
+			s.mu.Unlock()
+			return stream.SendAndClose(&storagepb.WriteObjectResponse{WriteStatus: &storagepb.WriteObjectResponse_Resource{Resource: obj}})
+		}
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return stream.SendAndClose(&storagepb.WriteObjectResponse{WriteStatus: &storagepb.WriteObjectResponse_CommittedSize{CommittedSize: int64(len(s.data[id]))}})
+}
+
+func newFakeWriteClient(ctx context.Context, t *testing.T) (*Client, *fakeWriteServer, func()) {
+	t.Helper()
+	fake := &fakeWriteServer{
+		specs:   map[string]*storagepb.WriteObjectSpec{},
+		data:    map[string][]byte{},
+		objects: map[string]*storagepb.Object{},
+	}
+	c, cleanup := newFakeGRPCClient(ctx, t, fake)
+	return c, fake, cleanup
+}
+
+// newFakeGRPCClient returns a Client that sends gRPC requests to fake, and
+// JSON API requests with the given options.
+func newFakeGRPCClient(ctx context.Context, t *testing.T, fake storagepb.StorageServer, httpOpts ...option.ClientOption) (*Client, func()) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gsrv := grpc.NewServer()
+	storagepb.RegisterStorageServer(gsrv, fake)
+	go gsrv.Serve(lis)
+	c, err := newHybridClient(ctx, &hybridClientOptions{
+		HTTPOpts: append([]option.ClientOption{option.WithoutAuthentication()}, httpOpts...),
+		GRPCOpts: []option.ClientOption{
+			option.WithEndpoint(lis.Addr().String()),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithInsecure()),
+		},
+	})
+	if err != nil {
+		gsrv.Stop()
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		gsrv.Stop()
+	}
+}
+
+func TestResumeWriterGRPC(t *testing.T) {
+	ctx := context.Background()
+	c, fake, cleanup := newFakeWriteClient(ctx, t)
+	defer cleanup()
+	obj := c.Bucket("bucket").Object("obj")
+
+	const chunkSize = googleapi.MinUploadChunkSize
+	data := make([]byte, 2*chunkSize+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	crc := crc32.Checksum(data, crc32cTable)
+
+	// A failed chunk is retried from the persisted offset.
+	fake.failAfter = chunkSize / 2
+	cctx, cancel := context.WithCancel(ctx)
+	w := obj.NewWriter(cctx)
+	w.ChunkSize = chunkSize
+	w.CRC32C = crc
+	w.SendCRC32C = true
+	var session string
+	w.SessionFunc = func(s string) { session = s }
+	w.ProgressFunc = func(n int64) {
+		if n >= chunkSize {
+			cancel()
+		}
+	}
+	w.Write(data)
+	if err := w.Close(); err == nil {
+		t.Fatal("got nil error from a cancelled upload")
+	}
+
+	w, offset, err := obj.ResumeWriter(ctx, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != chunkSize {
+		t.Fatalf("got offset %d, want %d", offset, chunkSize)
+	}
+	w.ChunkSize = chunkSize
+	if _, err := w.Write(data[offset:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Attrs(); got.Size != int64(len(data)) || got.CRC32C != crc {
+		t.Errorf("got attrs %+v", got)
+	}
+	if !bytes.Equal(fake.data[session], data) {
+		t.Error("uploaded contents differ")
+	}
+
+	if _, _, err := obj.ResumeWriter(ctx, "missing"); status.Code(err) != codes.NotFound {
+		t.Errorf("got %v for a missing session, want NotFound", err)
+	}
+}
+
+func TestWriterChunkRetriesGRPC(t *testing.T) {
+	ctx := context.Background()
+	c, fake, cleanup := newFakeWriteClient(ctx, t)
+	defer cleanup()
+	data := make([]byte, googleapi.MinUploadChunkSize)
+	backoff := WithBackoff(gax.Backoff{Initial: time.Millisecond})
+
+	for _, test := range []struct {
+		desc    string
+		opts    []RetryOption
+		wantErr bool
+	}{
+		{"backoff", []RetryOption{backoff}, false},
+		{"idempotent", []RetryOption{backoff, WithPolicy(RetryIdempotent)}, false},
+		{"never", []RetryOption{backoff, WithPolicy(RetryNever)}, true},
+	} {
+		// A chunk fails once, without a precondition on the object.
+		fake.failAfter = len(data) / 2
+		w := c.Bucket("bucket").Object(test.desc).Retryer(test.opts...).NewWriter(ctx)
+		var session string
+		w.SessionFunc = func(s string) { session = s }
+		w.Write(data)
+		err := w.Close()
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("%s: got error %v, want error %t", test.desc, err, test.wantErr)
+		}
+		if !test.wantErr && !bytes.Equal(fake.data[session], data) {
+			t.Errorf("%s: uploaded contents differ", test.desc)
+		}
+	}
+}
+
+func TestToProtoEnumName(t *testing.T) {
+	for in, want := range map[string]string{
+		"private":                "PRIVATE",
+		"authenticatedRead":      "AUTHENTICATED_READ",
+		"bucketOwnerFullControl": "BUCKET_OWNER_FULL_CONTROL",
+	} {
+		if got := toProtoEnumName(in); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+	if _, ok := storagepb.PredefinedObjectAcl_value[fmt.Sprintf("OBJECT_ACL_%s", toProtoEnumName("publicRead"))]; !ok {
+		t.Error("publicRead does not map to a predefined object ACL")
+	}
+}
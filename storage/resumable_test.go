@@ -0,0 +1,191 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/google/go-cmp/cmp"
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestResumeWriter(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := storage.NewFakeClient(ctx, t, nil, nil)
+	defer cleanup()
+	b := client.Bucket("bucket")
+	obj := b.Object("obj")
+
+	const chunkSize = googleapi.MinUploadChunkSize
+	data := make([]byte, 3*chunkSize+1000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	// Start an upload that fails after its second chunk.
+	cctx, cancel := context.WithCancel(ctx)
+	w := obj.If(storage.Conditions{DoesNotExist: true}).NewWriter(cctx)
+	w.ChunkSize = chunkSize
+	w.ContentType = "application/x-test"
+	var session string
+	w.SessionFunc = func(s string) { session = s }
+	var progress []int64
+	w.ProgressFunc = func(n int64) {
+		progress = append(progress, n)
+		if n >= 2*chunkSize {
+			cancel()
+		}
+	}
+	w.Write(data)
+	if err := w.Close(); err == nil {
+		t.Fatal("got nil error from a cancelled upload")
+	}
+	if session == "" {
+		t.Fatal("SessionFunc was not called")
+	}
+	if diff := cmp.Diff(progress, []int64{chunkSize, 2 * chunkSize}); diff != "" {
+		t.Errorf("progress: got=-, want=+:\n%s", diff)
+	}
+
+	// Resume the upload from the persisted offset.
+	w, offset, err := obj.ResumeWriter(ctx, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 2*chunkSize {
+		t.Fatalf("got offset %d, want %d", offset, 2*chunkSize)
+	}
+	progress = nil
+	w.ChunkSize = chunkSize
+	w.ProgressFunc = func(n int64) { progress = append(progress, n) }
+	if _, err := w.Write(data[offset:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(progress, []int64{3 * chunkSize, int64(len(data))}); diff != "" {
+		t.Errorf("progress after resuming: got=-, want=+:\n%s", diff)
+	}
+	attrs := w.Attrs()
+	if attrs.Size != int64(len(data)) || attrs.ContentType != "application/x-test" {
+		t.Errorf("got attrs %+v", attrs)
+	}
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("uploaded contents differ")
+	}
+
+	// Resuming a completed session reports the object.
+	w, offset, err = obj.ResumeWriter(ctx, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != int64(len(data)) {
+		t.Errorf("got offset %d for a completed upload, want %d", offset, len(data))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Attrs().Generation != attrs.Generation {
+		t.Errorf("got generation %d, want %d", w.Attrs().Generation, attrs.Generation)
+	}
+}
+
+func TestWriterSessionFuncPreconditions(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := storage.NewFakeClient(ctx, t, nil, nil)
+	defer cleanup()
+	b := client.Bucket("bucket")
+	for i, want := range []bool{true, false} {
+		w := b.Object("obj").If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+		w.SessionFunc = func(string) {}
+		w.Write([]byte("small"))
+		err := w.Close()
+		if ok := err == nil; ok != want {
+			t.Errorf("write %d: got error %v, want success %t", i, err, want)
+		}
+	}
+}
+
+func TestWriterSessionRetries(t *testing.T) {
+	ctx := context.Background()
+	// Fail the first request that starts an upload session with a retryable
+	// error.
+	var mu sync.Mutex
+	starts := 0
+	client, _, cleanup := storage.NewFakeClient(ctx, t, nil, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/upload/") {
+				mu.Lock()
+				starts++
+				n := starts
+				mu.Unlock()
+				if n == 1 {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	defer cleanup()
+	b := client.Bucket("bucket")
+	backoff := storage.WithBackoff(gax.Backoff{Initial: time.Millisecond})
+
+	for _, test := range []struct {
+		desc       string
+		opts       []storage.RetryOption
+		wantStarts int
+		wantErr    bool
+	}{
+		{"backoff", []storage.RetryOption{backoff}, 2, false},
+		{"idempotent", []storage.RetryOption{backoff, storage.WithPolicy(storage.RetryIdempotent)}, 2, false},
+		{"always", []storage.RetryOption{backoff, storage.WithPolicy(storage.RetryAlways)}, 2, false},
+		{"never", []storage.RetryOption{backoff, storage.WithPolicy(storage.RetryNever)}, 1, true},
+	} {
+		mu.Lock()
+		starts = 0
+		mu.Unlock()
+		w := b.Object(test.desc).Retryer(test.opts...).NewWriter(ctx)
+		w.SessionFunc = func(string) {}
+		w.Write([]byte("data"))
+		err := w.Close()
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("%s: got error %v, want error %t", test.desc, err, test.wantErr)
+		}
+		mu.Lock()
+		if starts != test.wantStarts {
+			t.Errorf("%s: upload started %d times, want %d", test.desc, starts, test.wantStarts)
+		}
+		mu.Unlock()
+	}
+}
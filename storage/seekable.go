@@ -0,0 +1,303 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// DefaultReadAhead is the default value of SeekableReader.ReadAhead.
+const DefaultReadAhead = 1 << 20
+
+var errSeekableReaderClosed = errors.New("storage: SeekableReader is closed")
+
+// SeekableReader reads a Cloud Storage object with random access. It
+// implements io.ReadSeeker, io.ReaderAt and io.Closer, so it can be used
+// with packages such as archive/zip and with http.ServeContent.
+//
+// A SeekableReader reads a single generation of the object: the generation
+// that was current when it was created. Objects are read as stored, without
+// decompressive transcoding.
+//
+// Reads are served from a buffer of ReadAhead bytes, which is filled from a
+// range stream that is opened on the first read and kept open while reads are
+// sequential. Reads of at least ReadAhead bytes through ReadAt bypass the
+// buffer and use their own range stream, so they can run in parallel.
+//
+// The methods of SeekableReader are safe for concurrent use, but concurrent
+// calls to Read and Seek have no defined order.
+type SeekableReader struct {
+	// Attrs are the attributes of the object generation being read.
+	Attrs ReaderObjectAttrs
+
+	// ReadAhead is the number of bytes read and buffered when a read is not
+	// satisfied by the buffer. It must be set before the first read. If zero
+	// or negative, reads are not buffered and every ReadAt opens a range
+	// stream.
+	ReadAhead int
+
+	ctx context.Context
+	o   *ObjectHandle // pinned to the generation being read
+
+	mu        sync.Mutex
+	closed    bool
+	pos       int64   // offset of the next Read
+	buf       []byte  // buffered data
+	bufOff    int64   // offset of buf in the object
+	stream    *Reader // open range stream, or nil
+	streamOff int64   // offset of the next byte of stream
+}
+
+// NewSeekableReader creates a new SeekableReader for the object. It fetches the
+// object's attributes to determine its size and pins the generation, but does
+// not start reading the object until the first read.
+//
+// The caller must call Close on the returned SeekableReader when done reading.
+// ctx is used for all reads of the returned SeekableReader.
+func (o *ObjectHandle) NewSeekableReader(ctx context.Context) (*SeekableReader, error) {
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newSeekableReader(ctx, o, attrs), nil
+}
+
+// newSeekableReader returns a SeekableReader for the generation of o described
+// by attrs.
+func newSeekableReader(ctx context.Context, o *ObjectHandle, attrs *ObjectAttrs) *SeekableReader {
+	return &SeekableReader{
+		Attrs: ReaderObjectAttrs{
+			Size:            attrs.Size,
+			ContentType:     attrs.ContentType,
+			ContentEncoding: attrs.ContentEncoding,
+			CacheControl:    attrs.CacheControl,
+			LastModified:    attrs.Updated,
+			Generation:      attrs.Generation,
+			Metageneration:  attrs.Metageneration,
+		},
+		ReadAhead: DefaultReadAhead,
+		ctx:       ctx,
+		o:         o.Generation(attrs.Generation).ReadCompressed(true).DecompressClientSide(false),
+	}
+}
+
+// Size returns the size of the object in bytes.
+func (r *SeekableReader) Size() int64 {
+	return r.Attrs.Size
+}
+
+// Read reads up to len(p) bytes at the current offset and advances the offset
+// by the number of bytes read.
+func (r *SeekableReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errSeekableReaderClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.pos >= r.Attrs.Size {
+		return 0, io.EOF
+	}
+	var n int
+	var err error
+	if r.ReadAhead > 0 {
+		n, err = r.readBuffered(p, r.pos)
+	} else {
+		n, err = r.readStream(p, r.pos)
+	}
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read, as described by io.Seeker. Seeking
+// does not read from the object. Seeking past the end of the object is
+// allowed; a subsequent Read returns io.EOF.
+func (r *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errSeekableReaderClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.Attrs.Size
+	default:
+		return 0, fmt.Errorf("storage: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("storage: negative offset %d", offset)
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// ReadAt reads len(p) bytes at offset off, as described by io.ReaderAt. It does
+// not use or change the offset used by Read.
+func (r *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("storage: negative offset %d", off)
+	}
+	var n int
+	for n < len(p) {
+		if off+int64(n) >= r.Attrs.Size {
+			return n, io.EOF
+		}
+		rest := p[n:]
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return n, errSeekableReaderClosed
+		}
+		if len(rest) >= r.ReadAhead {
+			// Large reads do not hold the lock, so they can run in parallel.
+			r.mu.Unlock()
+			m, err := r.readRange(rest, off+int64(n))
+			n += m
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+		m, err := r.readBuffered(rest, off+int64(n))
+		r.mu.Unlock()
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close closes the SeekableReader and its open range stream, if any.
+func (r *SeekableReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.buf = nil
+	return r.closeStream()
+}
+
+// readRange reads len(p) bytes at off, or up to the end of the object, through a
+// new range stream.
+func (r *SeekableReader) readRange(p []byte, off int64) (int, error) {
+	if rem := r.Attrs.Size - off; int64(len(p)) > rem {
+		p = p[:rem]
+	}
+	rr, err := r.o.NewRangeReader(r.ctx, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer rr.Close()
+	n, err := io.ReadFull(rr, p)
+	if err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("storage: object %s/%s returned %d bytes at offset %d, want %d", r.o.bucket, r.o.object, n, off, len(p))
+	}
+	return n, err
+}
+
+// readBuffered copies data at off to p from the buffer, filling the buffer
+// first if it does not contain off. r.mu must be held.
+func (r *SeekableReader) readBuffered(p []byte, off int64) (int, error) {
+	if off < r.bufOff || off >= r.bufOff+int64(len(r.buf)) {
+		if err := r.fill(off); err != nil {
+			return 0, err
+		}
+	}
+	return copy(p, r.buf[off-r.bufOff:]), nil
+}
+
+// fill reads up to ReadAhead bytes at off into the buffer. r.mu must be held.
+func (r *SeekableReader) fill(off int64) error {
+	n := int64(r.ReadAhead)
+	if rem := r.Attrs.Size - off; n > rem {
+		n = rem
+	}
+	if int64(cap(r.buf)) < n {
+		r.buf = make([]byte, r.ReadAhead)
+	}
+	r.buf = r.buf[:0]
+	m, err := r.readStream(r.buf[:n], off)
+	if err == io.EOF && int64(m) == n {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	r.buf = r.buf[:m]
+	r.bufOff = off
+	return nil
+}
+
+// readStream reads len(p) bytes at off from the shared range stream, which is
+// reused if it is positioned at or shortly before off and reopened otherwise.
+// r.mu must be held.
+func (r *SeekableReader) readStream(p []byte, off int64) (int, error) {
+	if r.stream != nil && off > r.streamOff && off-r.streamOff <= int64(r.ReadAhead) {
+		// Skipping a short gap is cheaper than opening a new stream.
+		skipped, err := io.CopyN(ioutil.Discard, r.stream, off-r.streamOff)
+		r.streamOff += skipped
+		if err != nil {
+			r.closeStream()
+		}
+	}
+	if r.stream != nil && r.streamOff != off {
+		r.closeStream()
+	}
+	if r.stream == nil {
+		s, err := r.o.NewRangeReader(r.ctx, off, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.stream = s
+		r.streamOff = off
+	}
+	n, err := io.ReadFull(r.stream, p)
+	r.streamOff += int64(n)
+	switch {
+	case err == io.ErrUnexpectedEOF || (err == io.EOF && len(p) > 0):
+		r.closeStream()
+		if off+int64(n) < r.Attrs.Size {
+			return n, fmt.Errorf("storage: object %s/%s returned %d bytes at offset %d, want %d", r.o.bucket, r.o.object, n, off, len(p))
+		}
+		return n, io.EOF
+	case err != nil:
+		r.closeStream()
+		return n, err
+	}
+	return n, nil
+}
+
+// closeStream closes the shared range stream, if it is open. r.mu must be held.
+func (r *SeekableReader) closeStream() error {
+	if r.stream == nil {
+		return nil
+	}
+	err := r.stream.Close()
+	r.stream = nil
+	return err
+}
@@ -0,0 +1,366 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// rangeRecorder records the Range header of each media download made to a
+// fake server, and rejects downloads that do not pin a generation.
+type rangeRecorder struct {
+	next http.Handler
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *rangeRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" && !strings.HasPrefix(r.URL.Path, "/storage/v1/") {
+		if r.URL.Query().Get("generation") == "" {
+			http.Error(w, "generation not set", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+	}
+	s.next.ServeHTTP(w, r)
+}
+
+func (s *rangeRecorder) reads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.ranges
+	s.ranges = nil
+	return r
+}
+
+// writeSeekableTestObject writes data to the object "o" of the bucket of a
+// fake client.
+func writeSeekableTestObject(ctx context.Context, t *testing.T, c *Client, data []byte) *ObjectAttrs {
+	t.Helper()
+	w := c.Bucket("bucket").Object("o").NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w.Attrs()
+}
+
+func newSeekableTestReader(t *testing.T, data []byte) (*SeekableReader, *rangeRecorder, func()) {
+	t.Helper()
+	ctx := context.Background()
+	rec := &rangeRecorder{}
+	c, _, cleanup := newFakeClient(ctx, t, nil, func(h http.Handler) http.Handler {
+		rec.next = h
+		return rec
+	})
+	writeSeekableTestObject(ctx, t, c, data)
+	r, err := c.Bucket("bucket").Object("o").NewSeekableReader(ctx)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	rec.reads()
+	return r, rec, func() {
+		r.Close()
+		cleanup()
+	}
+}
+
+func TestSeekableReaderRead(t *testing.T) {
+	data := []byte(strings.Repeat(readData, 10))
+	r, s, cleanup := newSeekableTestReader(t, data)
+	defer cleanup()
+	r.ReadAhead = 16
+
+	if got, want := r.Size(), int64(len(data)); got != want {
+		t.Errorf("Size: got %d, want %d", got, want)
+	}
+	if got := s.reads(); len(got) != 0 {
+		t.Errorf("got reads %q before the first Read, want none", got)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+	// A sequential read uses a single stream of the whole object.
+	if got := s.reads(); len(got) != 1 || got[0] != "" {
+		t.Errorf("got reads %q, want one read from offset 0", got)
+	}
+
+	for _, test := range []struct {
+		offset int64
+		whence int
+		want   string
+	}{
+		{5, io.SeekStart, "56789"},
+		{-3, io.SeekEnd, "789"},
+		{-10, io.SeekCurrent, "0123456789"},
+		{int64(len(data)) + 5, io.SeekStart, ""},
+	} {
+		if _, err := r.Seek(test.offset, test.whence); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(test.want))
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !(test.want == "" && err == io.EOF) {
+			t.Errorf("Seek(%d, %d): %v", test.offset, test.whence, err)
+			continue
+		}
+		if got := string(buf[:n]); got != test.want {
+			t.Errorf("Seek(%d, %d): got %q, want %q", test.offset, test.whence, got, test.want)
+		}
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("got nil error for a negative offset")
+	}
+}
+
+func TestSeekableReaderReadAt(t *testing.T) {
+	data := []byte(strings.Repeat(readData, 10))
+	r, s, cleanup := newSeekableTestReader(t, data)
+	defer cleanup()
+	r.ReadAhead = 16
+
+	for _, test := range []struct {
+		off       int64
+		n         int
+		want      string
+		wantErr   error
+		wantReads []string
+	}{
+		// Opens a stream and fills the buffer.
+		{off: 2, n: 4, want: "2345", wantReads: []string{"bytes=2-"}},
+		// Served from the buffer.
+		{off: 10, n: 5, want: "01234"},
+		// Continues the stream.
+		{off: 18, n: 2, want: "89"},
+		// Skips a short gap in the stream.
+		{off: 40, n: 3, want: "012"},
+		// Reopens the stream backwards.
+		{off: 0, n: 1, want: "0", wantReads: []string{""}},
+		// Large reads use their own range.
+		{off: 50, n: 20, want: string(data[50:70]), wantReads: []string{"bytes=50-69"}},
+		// Reads past the end are truncated.
+		{off: 95, n: 10, want: "56789", wantErr: io.EOF, wantReads: []string{"bytes=95-"}},
+		{off: 100, n: 1, want: "", wantErr: io.EOF},
+	} {
+		buf := make([]byte, test.n)
+		n, err := r.ReadAt(buf, test.off)
+		if err != test.wantErr {
+			t.Errorf("ReadAt(%d, %d): got error %v, want %v", test.off, test.n, err, test.wantErr)
+		}
+		if got := string(buf[:n]); got != test.want {
+			t.Errorf("ReadAt(%d, %d): got %q, want %q", test.off, test.n, got, test.want)
+		}
+		if got := s.reads(); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", test.wantReads) {
+			t.Errorf("ReadAt(%d, %d): got reads %q, want %q", test.off, test.n, got, test.wantReads)
+		}
+	}
+
+	r.Close()
+	// Both small and large reads fail after Close.
+	for _, n := range []int{1, 20} {
+		if _, err := r.ReadAt(make([]byte, n), 0); err != errSeekableReaderClosed {
+			t.Errorf("ReadAt of %d bytes: got %v after Close, want %v", n, err, errSeekableReaderClosed)
+		}
+	}
+	if got := s.reads(); len(got) != 0 {
+		t.Errorf("got reads %q after Close, want none", got)
+	}
+}
+
+func TestSeekableReaderConcurrentReadAt(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	r, _, cleanup := newSeekableTestReader(t, data)
+	defer cleanup()
+	r.ReadAhead = 64
+
+	var wg sync.WaitGroup
+	errc := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(off int64, n int) {
+			defer wg.Done()
+			buf := make([]byte, n)
+			if _, err := r.ReadAt(buf, off); err != nil {
+				errc <- err
+				return
+			}
+			if !bytes.Equal(buf, data[off:off+int64(n)]) {
+				errc <- fmt.Errorf("ReadAt(%d, %d): wrong contents", off, n)
+			}
+		}(int64(i*97), 10+i*10)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+}
+
+// fakeReadServer implements ReadObject of the gRPC API for a generation of
+// an object, sending its contents in small messages, and records the ranges
+// read.
+type fakeReadServer struct {
+	storagepb.UnimplementedStorageServer
+	data []byte
+	gen  int64
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *fakeReadServer) ReadObject(req *storagepb.ReadObjectRequest, stream storagepb.Storage_ReadObjectServer) error {
+	if req.GetGeneration() != s.gen {
+		return status.Errorf(codes.InvalidArgument, "got generation %d, want %d", req.GetGeneration(), s.gen)
+	}
+	s.mu.Lock()
+	s.ranges = append(s.ranges, fmt.Sprintf("%d+%d", req.GetReadOffset(), req.GetReadLimit()))
+	s.mu.Unlock()
+	start := req.GetReadOffset()
+	if start > int64(len(s.data)) {
+		return status.Errorf(codes.OutOfRange, "offset %d is past the end of the object", start)
+	}
+	end := int64(len(s.data))
+	if limit := req.GetReadLimit(); limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	const msgSize = 7
+	for off := start; off == start || off < end; off += msgSize {
+		msgEnd := off + msgSize
+		if msgEnd > end {
+			msgEnd = end
+		}
+		resp := &storagepb.ReadObjectResponse{
+			ChecksummedData: &storagepb.ChecksummedData{Content: s.data[off:msgEnd]},
+		}
+		if off == start {
+			resp.Metadata = &storagepb.Object{Bucket: "bucket", Name: "o", Generation: s.gen, Size: int64(len(s.data))}
+			resp.ObjectChecksums = &storagepb.ObjectChecksums{Crc32C: proto.Uint32(crc32.Checksum(s.data, crc32cTable))}
+			resp.ContentRange = &storagepb.ContentRange{Start: start, End: end - 1, CompleteLength: int64(len(s.data))}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeReadServer) reads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.ranges
+	s.ranges = nil
+	return r
+}
+
+func TestSeekableReaderGRPC(t *testing.T) {
+	ctx := context.Background()
+	data := []byte(strings.Repeat(readData, 10))
+	// Attributes come from the JSON API, and contents from gRPC.
+	c, srv, cleanup := newFakeClient(ctx, t, nil, nil)
+	defer cleanup()
+	attrs := writeSeekableTestObject(ctx, t, c, data)
+	fake := &fakeReadServer{data: data, gen: attrs.Generation}
+	gc, closeGRPC := newFakeGRPCClient(ctx, t, fake, srv.ClientOptions()...)
+	defer closeGRPC()
+
+	r, err := gc.Bucket("bucket").Object("o").NewSeekableReader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.ReadAhead = 16
+
+	for _, test := range []struct {
+		off       int64
+		n         int
+		want      string
+		wantErr   error
+		wantReads []string
+	}{
+		// Opens a stream and fills the buffer.
+		{off: 2, n: 4, want: "2345", wantReads: []string{"2+0"}},
+		// Served from the buffer.
+		{off: 10, n: 5, want: "01234"},
+		// Continues the stream.
+		{off: 18, n: 2, want: "89"},
+		// Reopens the stream backwards.
+		{off: 0, n: 1, want: "0", wantReads: []string{"0+0"}},
+		// Large reads use their own range.
+		{off: 50, n: 20, want: string(data[50:70]), wantReads: []string{"50+20"}},
+		// Reads past the end are truncated.
+		{off: 95, n: 10, want: "56789", wantErr: io.EOF, wantReads: []string{"95+0"}},
+	} {
+		buf := make([]byte, test.n)
+		n, err := r.ReadAt(buf, test.off)
+		if err != test.wantErr {
+			t.Errorf("ReadAt(%d, %d): got error %v, want %v", test.off, test.n, err, test.wantErr)
+		}
+		if got := string(buf[:n]); got != test.want {
+			t.Errorf("ReadAt(%d, %d): got %q, want %q", test.off, test.n, got, test.want)
+		}
+		if got := fake.reads(); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", test.wantReads) {
+			t.Errorf("ReadAt(%d, %d): got reads %q, want %q", test.off, test.n, got, test.wantReads)
+		}
+	}
+
+	for _, test := range []struct {
+		offset int64
+		whence int
+		want   string
+	}{
+		{5, io.SeekStart, "56789"},
+		{-3, io.SeekEnd, "789"},
+		{-10, io.SeekCurrent, "0123456789"},
+	} {
+		if _, err := r.Seek(test.offset, test.whence); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(test.want))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Errorf("Seek(%d, %d): %v", test.offset, test.whence, err)
+			continue
+		}
+		if got := string(buf); got != test.want {
+			t.Errorf("Seek(%d, %d): got %q, want %q", test.offset, test.whence, got, test.want)
+		}
+	}
+}
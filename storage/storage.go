@@ -41,6 +41,7 @@ import (
 	"cloud.google.com/go/internal/trace"
 	"cloud.google.com/go/internal/version"
 	gapic "cloud.google.com/go/storage/internal/apiv2"
+	gax "github.com/googleapis/gax-go/v2"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/api/option/internaloption"
@@ -101,6 +102,10 @@ type Client struct {
 	//
 	// This is an experimental field and not intended for public use.
 	gc *gapic.Client
+
+	// retry is the retry configuration used by handles created from the
+	// Client. Set with SetRetry.
+	retry *retryConfig
 }
 
 // NewClient creates a new Google Cloud Storage client.
@@ -607,17 +612,9 @@ func pathEncodeV4(path string) string {
 
 // signedURLV4 creates a signed URL using the sigV4 algorithm.
 func signedURLV4(bucket, name string, opts *SignedURLOptions, now time.Time) (string, error) {
-	buf := &bytes.Buffer{}
-	fmt.Fprintf(buf, "%s\n", opts.Method)
-
 	u := &url.URL{Path: opts.Style.path(bucket, name)}
 	u.RawPath = pathEncodeV4(u.Path)
 
-	// Note: we have to add a / here because GCS does so auto-magically, despite
-	// our encoding not doing so (and we have to exactly match their
-	// canonical query).
-	fmt.Fprintf(buf, "/%s\n", u.RawPath)
-
 	headerNames := append(extractHeaderNames(opts.Headers), "host")
 	if opts.ContentType != "" {
 		headerNames = append(headerNames, "content-type")
@@ -628,9 +625,9 @@ func signedURLV4(bucket, name string, opts *SignedURLOptions, now time.Time) (st
 	sort.Strings(headerNames)
 	signedHeaders := strings.Join(headerNames, ";")
 	timestamp := now.Format(iso8601)
-	credentialScope := fmt.Sprintf("%s/auto/storage/goog4_request", now.Format(yearMonthDay))
+	credentialScope := v4CredentialScope(now)
 	canonicalQueryString := url.Values{
-		"X-Goog-Algorithm":     {"GOOG4-RSA-SHA256"},
+		"X-Goog-Algorithm":     {v4RSAAlgorithm},
 		"X-Goog-Credential":    {fmt.Sprintf("%s/%s", opts.GoogleAccessID, credentialScope)},
 		"X-Goog-Date":          {timestamp},
 		"X-Goog-Expires":       {fmt.Sprintf("%d", int(opts.Expires.Sub(now).Seconds()))},
@@ -641,10 +638,6 @@ func signedURLV4(bucket, name string, opts *SignedURLOptions, now time.Time) (st
 	for k, v := range opts.QueryParameters {
 		canonicalQueryString[k] = append(canonicalQueryString[k], v...)
 	}
-	// url.Values.Encode escaping is correct, except that a space must be replaced
-	// by `%20` rather than `+`.
-	escapedQuery := strings.Replace(canonicalQueryString.Encode(), "+", "%20", -1)
-	fmt.Fprintf(buf, "%s\n", escapedQuery)
 
 	// Fill in the hostname based on the desired URL style.
 	u.Host = opts.Style.host(bucket)
@@ -665,9 +658,54 @@ func signedURLV4(bucket, name string, opts *SignedURLOptions, now time.Time) (st
 	if opts.MD5 != "" {
 		headersWithValue = append(headersWithValue, "content-md5:"+opts.MD5)
 	}
+	// Note: we have to add a / here because GCS does so auto-magically, despite
+	// our encoding not doing so (and we have to exactly match their
+	// canonical query).
+	canonicalRequest := v4CanonicalRequest(opts.Method, "/"+u.RawPath, canonicalQueryString, headersWithValue, signedHeaders)
+	stringToSign := v4StringToSign(v4RSAAlgorithm, timestamp, credentialScope, canonicalRequest)
+
+	signBytes := opts.SignBytes
+	if opts.PrivateKey != nil {
+		key, err := parseKey(opts.PrivateKey)
+		if err != nil {
+			return "", err
+		}
+		signBytes = func(b []byte) ([]byte, error) {
+			sum := sha256.Sum256(b)
+			return rsa.SignPKCS1v15(
+				rand.Reader,
+				key,
+				crypto.SHA256,
+				sum[:],
+			)
+		}
+	}
+	b, err := signBytes(stringToSign)
+	if err != nil {
+		return "", err
+	}
+	signature := hex.EncodeToString(b)
+	canonicalQueryString.Set("X-Goog-Signature", string(signature))
+	u.RawQuery = canonicalQueryString.Encode()
+	return u.String(), nil
+}
+
+// v4CanonicalRequest returns the canonical request of a V4 signature. The
+// path must already be escaped, the query must not include X-Goog-Signature,
+// and headers holds the signed headers as "name:value" strings.
+func v4CanonicalRequest(method, escapedPath string, query url.Values, headers []string, signedHeaders string) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s\n", method)
+	fmt.Fprintf(buf, "%s\n", escapedPath)
+
+	// url.Values.Encode escaping is correct, except that a space must be replaced
+	// by `%20` rather than `+`.
+	escapedQuery := strings.Replace(query.Encode(), "+", "%20", -1)
+	fmt.Fprintf(buf, "%s\n", escapedQuery)
+
 	// Trim extra whitespace from headers and replace with a single space.
 	var trimmedHeaders []string
-	for _, h := range headersWithValue {
+	for _, h := range headers {
 		trimmedHeaders = append(trimmedHeaders, strings.Join(strings.Fields(h), " "))
 	}
 	canonicalHeaders := strings.Join(sortHeadersByKey(trimmedHeaders), "\n")
@@ -687,39 +725,24 @@ func signedURLV4(bucket, name string, opts *SignedURLOptions, now time.Time) (st
 	if !sha256Header {
 		fmt.Fprint(buf, "UNSIGNED-PAYLOAD")
 	}
+	return buf.Bytes()
+}
 
-	sum := sha256.Sum256(buf.Bytes())
+// v4StringToSign returns the string to sign for a V4 canonical request.
+func v4StringToSign(algorithm, timestamp, credentialScope string, canonicalRequest []byte) []byte {
+	sum := sha256.Sum256(canonicalRequest)
 	hexDigest := hex.EncodeToString(sum[:])
 	signBuf := &bytes.Buffer{}
-	fmt.Fprint(signBuf, "GOOG4-RSA-SHA256\n")
+	fmt.Fprintf(signBuf, "%s\n", algorithm)
 	fmt.Fprintf(signBuf, "%s\n", timestamp)
 	fmt.Fprintf(signBuf, "%s\n", credentialScope)
 	fmt.Fprintf(signBuf, "%s", hexDigest)
+	return signBuf.Bytes()
+}
 
-	signBytes := opts.SignBytes
-	if opts.PrivateKey != nil {
-		key, err := parseKey(opts.PrivateKey)
-		if err != nil {
-			return "", err
-		}
-		signBytes = func(b []byte) ([]byte, error) {
-			sum := sha256.Sum256(b)
-			return rsa.SignPKCS1v15(
-				rand.Reader,
-				key,
-				crypto.SHA256,
-				sum[:],
-			)
-		}
-	}
-	b, err := signBytes(signBuf.Bytes())
-	if err != nil {
-		return "", err
-	}
-	signature := hex.EncodeToString(b)
-	canonicalQueryString.Set("X-Goog-Signature", string(signature))
-	u.RawQuery = canonicalQueryString.Encode()
-	return u.String(), nil
+// v4CredentialScope returns the credential scope of a V4 signature made at t.
+func v4CredentialScope(t time.Time) string {
+	return fmt.Sprintf("%s/auto/storage/goog4_request", t.Format(yearMonthDay))
 }
 
 // takes a list of headerKey:headervalue1,headervalue2,etc and sorts by header
@@ -765,17 +788,7 @@ func signedURLV2(bucket, name string, opts *SignedURLOptions) (string, error) {
 		Path: fmt.Sprintf("/%s/%s", bucket, name),
 	}
 
-	buf := &bytes.Buffer{}
-	fmt.Fprintf(buf, "%s\n", opts.Method)
-	fmt.Fprintf(buf, "%s\n", opts.MD5)
-	fmt.Fprintf(buf, "%s\n", opts.ContentType)
-	fmt.Fprintf(buf, "%d\n", opts.Expires.Unix())
-	if len(opts.Headers) > 0 {
-		fmt.Fprintf(buf, "%s\n", strings.Join(opts.Headers, "\n"))
-	}
-	fmt.Fprintf(buf, "%s", u.String())
-
-	b, err := signBytes(buf.Bytes())
+	b, err := signBytes(v2StringToSign(opts.Method, opts.MD5, opts.ContentType, opts.Expires.Unix(), opts.Headers, u.String()))
 	if err != nil {
 		return "", err
 	}
@@ -790,6 +803,22 @@ func signedURLV2(bucket, name string, opts *SignedURLOptions) (string, error) {
 	return u.String(), nil
 }
 
+// v2StringToSign returns the string to sign for a V2 signed URL. The headers
+// must already be sanitized, and resource is the escaped "/bucket/object"
+// path.
+func v2StringToSign(method, md5, contentType string, expires int64, headers []string, resource string) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s\n", method)
+	fmt.Fprintf(buf, "%s\n", md5)
+	fmt.Fprintf(buf, "%s\n", contentType)
+	fmt.Fprintf(buf, "%d\n", expires)
+	if len(headers) > 0 {
+		fmt.Fprintf(buf, "%s\n", strings.Join(headers, "\n"))
+	}
+	fmt.Fprintf(buf, "%s", resource)
+	return buf.Bytes()
+}
+
 // ObjectHandle provides operations on an object in a Google Cloud Storage bucket.
 // Use BucketHandle.Object to get a handle.
 type ObjectHandle struct {
@@ -802,6 +831,8 @@ type ObjectHandle struct {
 	encryptionKey  []byte // AES-256 key
 	userProject    string // for requester-pays buckets
 	readCompressed bool   // Accept-Encoding: gzip
+	decompress     bool   // decompress gzip-encoded objects in the client
+	retry          *retryConfig
 }
 
 // ACL provides access to the object's access control list.
@@ -865,7 +896,7 @@ func (o *ObjectHandle) Attrs(ctx context.Context) (attrs *ObjectAttrs, err error
 	}
 	var obj *raw.Object
 	setClientHeader(call.Header())
-	err = runWithRetry(ctx, func() error { obj, err = call.Do(); return err })
+	err = runWithRetry(ctx, func() error { obj, err = call.Do(); return err }, o.retry, true)
 	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
 		return nil, ErrObjectNotExist
 	}
@@ -965,7 +996,8 @@ func (o *ObjectHandle) Update(ctx context.Context, uattrs ObjectAttrsToUpdate) (
 	}
 	var obj *raw.Object
 	setClientHeader(call.Header())
-	err = runWithRetry(ctx, func() error { obj, err = call.Do(); return err })
+	isIdempotent := o.conds != nil && o.conds.MetagenerationMatch != 0
+	err = runWithRetry(ctx, func() error { obj, err = call.Do(); return err }, o.retry, isIdempotent)
 	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
 		return nil, ErrObjectNotExist
 	}
@@ -1028,7 +1060,10 @@ func (o *ObjectHandle) Delete(ctx context.Context) error {
 	}
 	// Encryption doesn't apply to Delete.
 	setClientHeader(call.Header())
-	err := runWithRetry(ctx, func() error { return call.Do() })
+	// Delete is idempotent if GenerationMatch or Generation have been passed in.
+	// The default generation is negative to get the latest version of the object.
+	isIdempotent := (o.conds != nil && o.conds.GenerationMatch != 0) || o.gen >= 0
+	err := runWithRetry(ctx, func() error { return call.Do() }, o.retry, isIdempotent)
 	switch e := err.(type) {
 	case nil:
 		return nil
@@ -1047,6 +1082,22 @@ func (o *ObjectHandle) ReadCompressed(compressed bool) *ObjectHandle {
 	return &o2
 }
 
+// DecompressClientSide when true causes objects stored with gzip content
+// encoding to be downloaded compressed and decompressed by the client,
+// instead of relying on decompressive transcoding by the server. This lets
+// the CRC32C of the stored bytes be checked, and range reads select bytes of
+// the decompressed content. Since compressed data cannot be read from the
+// middle, a range read of a gzip-encoded object downloads the object from
+// its start, and a negative offset is not supported.
+//
+// Other objects are read as usual. DecompressClientSide takes precedence
+// over ReadCompressed.
+func (o *ObjectHandle) DecompressClientSide(decompress bool) *ObjectHandle {
+	o2 := *o
+	o2.decompress = decompress
+	return &o2
+}
+
 // NewWriter returns a storage Writer that writes to the GCS object
 // associated with this ObjectHandle.
 //
@@ -1485,10 +1536,10 @@ type Query struct {
 	// object will be included in the results.
 	Versions bool
 
-	// fieldSelection is used to select only specific fields to be returned by
-	// the query. It's used internally and is populated for the user by
-	// calling Query.SetAttrSelection
-	fieldSelection string
+	// attrSelection holds the API names of the only object fields to be
+	// returned by the query. It's used internally and is populated for the
+	// user by calling Query.SetAttrSelection
+	attrSelection map[string]bool
 
 	// StartOffset is used to filter results to objects whose names are
 	// lexicographically equal to or after startOffset. If endOffset is also set,
@@ -1505,6 +1556,29 @@ type Query struct {
 	// which returns all properties. Passing ProjectionNoACL will omit Owner and ACL,
 	// which may improve performance when listing many objects.
 	Projection Projection
+
+	// MatchGlob filters results to objects whose names match a glob pattern.
+	// In the pattern, "*" matches any sequence of characters other than "/",
+	// "**" matches any sequence of characters, "?" matches any single
+	// character other than "/", "[abc]" and "[a-z]" match any character in
+	// the class, "[!abc]" and "[^abc]" match any character not in the class
+	// other than "/", and a backslash escapes the next character.
+	//
+	// Matching is done by the client. Objects are listed from the longest
+	// literal prefix of the pattern, and unless the query also sets Prefix,
+	// StartOffset, EndOffset or MatchRegexp, directories that cannot match
+	// are skipped by listing with a "/" delimiter. In that case page tokens
+	// cannot be used to resume iteration. MatchGlob cannot be combined with
+	// Delimiter.
+	// Optional.
+	MatchGlob string
+
+	// MatchRegexp filters results to objects whose whole names match a
+	// regular expression in the syntax of the regexp package. Matching is
+	// done by the client; objects are listed from the literal prefix of the
+	// expression, if any. MatchRegexp cannot be combined with Delimiter.
+	// Optional.
+	MatchRegexp string
 }
 
 // attrToFieldMap maps the field names of ObjectAttrs to the underlying field
@@ -1547,6 +1621,8 @@ var attrToFieldMap = map[string]string{
 // ObjectAttr will remain at their default values. This is a performance
 // optimization; for more information, see
 // https://cloud.google.com/storage/docs/json_api/v1/how-tos/performance
+//
+// Name is always fetched for queries with MatchGlob or MatchRegexp.
 func (q *Query) SetAttrSelection(attrs []string) error {
 	fieldSet := make(map[string]bool)
 
@@ -1559,22 +1635,30 @@ func (q *Query) SetAttrSelection(attrs []string) error {
 	}
 
 	if len(fieldSet) > 0 {
-		var b bytes.Buffer
-		b.WriteString("prefixes,items(")
-		first := true
-		for field := range fieldSet {
-			if !first {
-				b.WriteString(",")
-			}
-			first = false
-			b.WriteString(field)
-		}
-		b.WriteString(")")
-		q.fieldSelection = b.String()
+		q.attrSelection = fieldSet
 	}
 	return nil
 }
 
+// fieldSelection returns the partial response selector for the attributes
+// selected with SetAttrSelection, or "" if there is no selection. Names are
+// always selected for queries with MatchGlob or MatchRegexp, since they are
+// needed to match objects.
+func (q *Query) fieldSelection() string {
+	if len(q.attrSelection) == 0 {
+		return ""
+	}
+	fields := make([]string, 0, len(q.attrSelection)+1)
+	for field := range q.attrSelection {
+		fields = append(fields, field)
+	}
+	if (q.MatchGlob != "" || q.MatchRegexp != "") && !q.attrSelection["name"] {
+		fields = append(fields, "name")
+	}
+	sort.Strings(fields)
+	return "prefixes,items(" + strings.Join(fields, ",") + ")"
+}
+
 // Conditions constrain methods to act on specific generations of
 // objects.
 //
@@ -1774,13 +1858,177 @@ func (c *Client) ServiceAccount(ctx context.Context, projectID string) (string,
 	err = runWithRetry(ctx, func() error {
 		res, err = r.Context(ctx).Do()
 		return err
-	})
+	}, c.retry, true)
 	if err != nil {
 		return "", err
 	}
 	return res.EmailAddress, nil
 }
 
+// Retryer returns an object handle that is configured with custom retry
+// behavior as specified by the options that are passed to it. All operations
+// on the new handle will use the customized retry configuration.
+// These retry options will merge with the bucket's retryer (if set) for the
+// returned handle. Options passed into this method will take precedence over
+// retry options on the bucket and client. Note that you must explicitly pass in
+// each option you want to override.
+func (o *ObjectHandle) Retryer(opts ...RetryOption) *ObjectHandle {
+	o2 := *o
+	var retry *retryConfig
+	if o.retry != nil {
+		// Merge the options with a copy of the existing retry, which may be
+		// shared with the bucket and client.
+		retry = o.retry.clone()
+	} else {
+		retry = &retryConfig{}
+	}
+	for _, opt := range opts {
+		opt.apply(retry)
+	}
+	o2.retry = retry
+	o2.acl.retry = retry
+	return &o2
+}
+
+// SetRetry configures the client with custom retry behavior as specified by the
+// options that are passed to it. All operations using this client will use the
+// customized retry configuration.
+// This should be called once before using the client for network operations, as
+// there could be indeterminate behaviour with operations in progress.
+// Retry options set on a bucket or object handle will take precedence over
+// these options.
+func (c *Client) SetRetry(opts ...RetryOption) {
+	var retry *retryConfig
+	if c.retry != nil {
+		// merge the options with the existing retry
+		retry = c.retry
+	} else {
+		retry = &retryConfig{}
+	}
+	for _, opt := range opts {
+		opt.apply(retry)
+	}
+	c.retry = retry
+}
+
+// RetryOption allows users to configure non-default retry behavior for API
+// calls made to GCS.
+type RetryOption interface {
+	apply(config *retryConfig)
+}
+
+// WithBackoff allows configuration of the backoff timing used for retries.
+// Available configuration options (Initial, Max and Multiplier) are described
+// at https://pkg.go.dev/github.com/googleapis/gax-go/v2#Backoff. If any fields
+// are not supplied by the user, gax default values will be used.
+func WithBackoff(backoff gax.Backoff) RetryOption {
+	return &withBackoff{
+		backoff: backoff,
+	}
+}
+
+type withBackoff struct {
+	backoff gax.Backoff
+}
+
+func (wb *withBackoff) apply(config *retryConfig) {
+	config.backoff = &wb.backoff
+}
+
+// RetryPolicy describes the available policies for which operations should be
+// retried. The default is RetryIdempotent.
+type RetryPolicy int
+
+const (
+	// RetryIdempotent causes only idempotent operations to be retried when the
+	// service returns a transient error. Using this policy, fully idempotent
+	// operations (such as `ObjectHandle.Attrs()`) will always be retried.
+	// Conditionally idempotent operations (for example `ObjectHandle.Update()`)
+	// will be retried only if the necessary conditions have been supplied (in
+	// the case of `ObjectHandle.Update()` this would mean supplying a
+	// `Conditions.MetagenerationMatch` condition is required).
+	RetryIdempotent RetryPolicy = iota
+
+	// RetryAlways causes all operations to be retried when the service returns a
+	// transient error, regardless of idempotency considerations.
+	RetryAlways
+
+	// RetryNever causes the client to not perform retries on failed operations.
+	RetryNever
+)
+
+// WithPolicy allows the configuration of which operations should be performed
+// with retries for transient errors.
+func WithPolicy(policy RetryPolicy) RetryOption {
+	return &withPolicy{
+		policy: policy,
+	}
+}
+
+type withPolicy struct {
+	policy RetryPolicy
+}
+
+func (ws *withPolicy) apply(config *retryConfig) {
+	config.policy = ws.policy
+}
+
+// WithErrorFunc allows users to pass a custom function to the retryer. Errors
+// will be retried if and only if `shouldRetry(err)` returns true.
+// By default, the following errors are retried (see ShouldRetry):
+//
+// - HTTP responses with codes 429 and 5xx, and gRPC Unavailable errors.
+//
+// - Transient network errors such as connection reset and io.ErrUnexpectedEOF.
+//
+// - Errors which are considered transient using the Temporary() interface.
+//
+// - Wrapped versions of these errors.
+//
+// This option can be used to retry on a different set of errors than the
+// default. Users can use the default ShouldRetry function inside their custom
+// function if they only want to make minor modifications to default behavior.
+func WithErrorFunc(shouldRetry func(err error) bool) RetryOption {
+	return &withErrorFunc{
+		shouldRetry: shouldRetry,
+	}
+}
+
+type withErrorFunc struct {
+	shouldRetry func(err error) bool
+}
+
+func (wef *withErrorFunc) apply(config *retryConfig) {
+	config.shouldRetry = wef.shouldRetry
+}
+
+type retryConfig struct {
+	backoff     *gax.Backoff
+	policy      RetryPolicy
+	shouldRetry func(err error) bool
+}
+
+func (r *retryConfig) clone() *retryConfig {
+	if r == nil {
+		return nil
+	}
+
+	var bo *gax.Backoff
+	if r.backoff != nil {
+		bo = &gax.Backoff{
+			Initial:    r.backoff.Initial,
+			Max:        r.backoff.Max,
+			Multiplier: r.backoff.Multiplier,
+		}
+	}
+
+	return &retryConfig{
+		backoff:     bo,
+		policy:      r.policy,
+		shouldRetry: r.shouldRetry,
+	}
+}
+
 // bucketResourceName formats the given project ID and bucketResourceName ID
 // into a Bucket resource name. This is the format necessary for the gRPC API as
 // it conforms to the Resource-oriented design practices in https://google.aip.dev/121.
@@ -36,6 +36,7 @@ import (
 
 	"cloud.google.com/go/iam"
 	"cloud.google.com/go/internal/testutil"
+	gax "github.com/googleapis/gax-go/v2"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	raw "google.golang.org/api/storage/v1"
@@ -1118,6 +1119,57 @@ func TestUserProject(t *testing.T) {
 	check("storage.notifications.list", func() { b.Notifications(ctx) })
 }
 
+func TestRetryer(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewClient(ctx, option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	bo := gax.Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 3}
+	client.SetRetry(WithBackoff(bo), WithPolicy(RetryAlways))
+
+	b := client.Bucket("b").Retryer(WithPolicy(RetryNever))
+	o := b.Object("o").Retryer(WithErrorFunc(func(error) bool { return false }))
+
+	if got, want := client.retry.policy, RetryAlways; got != want {
+		t.Errorf("client policy: got %v, want %v", got, want)
+	}
+	if got, want := b.retry.policy, RetryNever; got != want {
+		t.Errorf("bucket policy: got %v, want %v", got, want)
+	}
+	if b.retry.shouldRetry != nil {
+		t.Error("bucket error func: got non-nil, want nil")
+	}
+	if got, want := o.retry.policy, RetryNever; got != want {
+		t.Errorf("object policy: got %v, want %v", got, want)
+	}
+	if o.retry.shouldRetry == nil {
+		t.Error("object error func: got nil, want non-nil")
+	}
+	for _, r := range []*retryConfig{client.retry, b.retry, o.retry} {
+		if r.backoff == nil || r.backoff.Initial != bo.Initial || r.backoff.Max != bo.Max || r.backoff.Multiplier != bo.Multiplier {
+			t.Errorf("backoff: got %+v, want %+v", r.backoff, bo)
+		}
+	}
+	if b.ACL().retry != b.retry || b.DefaultObjectACL().retry != b.retry {
+		t.Error("bucket ACL handles do not share the bucket retry config")
+	}
+	if o.ACL().retry != o.retry {
+		t.Error("object ACL handle does not share the object retry config")
+	}
+
+	// Handles created before a change to the client keep their configuration.
+	client.SetRetry(WithPolicy(RetryIdempotent))
+	if got, want := b.retry.policy, RetryNever; got != want {
+		t.Errorf("bucket policy after client change: got %v, want %v", got, want)
+	}
+	if got, want := client.Bucket("b").retry.policy, RetryIdempotent; got != want {
+		t.Errorf("new bucket policy: got %v, want %v", got, want)
+	}
+}
+
 func newTestServer(handler func(w http.ResponseWriter, r *http.Request)) (*http.Client, func()) {
 	ts := httptest.NewTLSServer(http.HandlerFunc(handler))
 	tlsConf := &tls.Config{InsecureSkipVerify: true}
@@ -1341,6 +1393,31 @@ func TestAttrToFieldMapCoverage(t *testing.T) {
 	}
 }
 
+func TestQueryFieldSelection(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		q     Query
+		attrs []string
+		want  string
+	}{
+		{Query{}, nil, ""},
+		{Query{MatchGlob: "*.txt"}, nil, ""},
+		{Query{}, []string{"Size", "Name", "Size"}, "prefixes,items(name,size)"},
+		// Names are needed to match objects.
+		{Query{MatchGlob: "*.txt"}, []string{"Size"}, "prefixes,items(name,size)"},
+		{Query{MatchRegexp: "a+"}, []string{"Name", "Updated"}, "prefixes,items(name,updated)"},
+	} {
+		q := test.q
+		if err := q.SetAttrSelection(test.attrs); err != nil {
+			t.Fatal(err)
+		}
+		if got := q.fieldSelection(); got != test.want {
+			t.Errorf("%+v with %q: got %q, want %q", test.q, test.attrs, got, test.want)
+		}
+	}
+}
+
 // Create a client using a combination of custom endpoint and
 // STORAGE_EMULATOR_HOST env variable and verify that raw.BasePath (used
 // for writes) and readHost and scheme (used for reads) are all set correctly.
@@ -0,0 +1,314 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	raw "google.golang.org/api/storage/v1"
+)
+
+type bucket struct {
+	// attrs holds the bucket resource, without its ACLs.
+	attrs            *raw.Bucket
+	project          string
+	acl              []aclRule
+	defaultObjectACL []aclRule
+	// objects holds the generations of each object, oldest first. Only the
+	// last generation may be live; the others are noncurrent.
+	objects map[string][]*object
+}
+
+func (b *bucket) versioningEnabled() bool {
+	return b.attrs.Versioning != nil && b.attrs.Versioning.Enabled
+}
+
+// isEmpty reports whether the bucket contains no live or noncurrent objects.
+func (b *bucket) isEmpty() bool {
+	for _, gens := range b.objects {
+		if len(gens) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// rawBucket returns a copy of the bucket resource including its ACLs.
+func (b *bucket) rawBucket() *raw.Bucket {
+	rb := *b.attrs
+	rb.Acl = bucketACLItems(rb.Name, b.acl)
+	rb.DefaultObjectAcl = objectACLItems(rb.Name, "", 0, b.defaultObjectACL)
+	return &rb
+}
+
+// touch records a change to the bucket's metadata. s.mu must be held.
+func (s *Server) touchBucket(b *bucket) {
+	b.attrs.Metageneration++
+	b.attrs.Updated = s.now()
+	b.attrs.Etag = etag(b.attrs.Metageneration)
+}
+
+func (s *Server) insertBucket(r *http.Request) (interface{}, error) {
+	project := r.URL.Query().Get("project")
+	if project == "" {
+		return nil, errorf(http.StatusBadRequest, "project is required")
+	}
+	var rb raw.Bucket
+	if err := decodeBody(r, &rb); err != nil {
+		return nil, err
+	}
+	if rb.Name == "" {
+		return nil, errorf(http.StatusBadRequest, "bucket name is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[rb.Name]; ok {
+		return nil, errorf(http.StatusConflict, "You already own this bucket. Please select another name.")
+	}
+	b := &bucket{
+		attrs:            &rb,
+		project:          project,
+		acl:              bucketACLRules(rb.Acl),
+		defaultObjectACL: objectACLRules(rb.DefaultObjectAcl),
+		objects:          map[string][]*object{},
+	}
+	rb.Acl = nil
+	rb.DefaultObjectAcl = nil
+	rb.Kind = "storage#bucket"
+	rb.Id = rb.Name
+	rb.SelfLink = s.Endpoint() + "b/" + url.PathEscape(rb.Name)
+	rb.Metageneration = 1
+	rb.Etag = etag(rb.Metageneration)
+	rb.TimeCreated = s.now()
+	rb.Updated = rb.TimeCreated
+	if rb.Location == "" {
+		rb.Location = "US"
+	}
+	rb.Location = strings.ToUpper(rb.Location)
+	if rb.LocationType == "" {
+		rb.LocationType = "multi-region"
+	}
+	if rb.StorageClass == "" {
+		rb.StorageClass = "STANDARD"
+	}
+	if rb.RetentionPolicy != nil {
+		rb.RetentionPolicy.EffectiveTime = rb.TimeCreated
+		rb.RetentionPolicy.IsLocked = false
+	}
+	s.buckets[rb.Name] = b
+	return b.rawBucket(), nil
+}
+
+// lookupBucket returns the named bucket after checking the bucket
+// metageneration preconditions of the request. s.mu must be held.
+func (s *Server) lookupBucket(r *http.Request, name string) (*bucket, error) {
+	b, ok := s.buckets[name]
+	if !ok {
+		return nil, errBucketNotFound(name)
+	}
+	params := r.URL.Query()
+	if mg, ok, err := int64Param(params, "ifMetagenerationMatch"); err != nil {
+		return nil, err
+	} else if ok && mg != b.attrs.Metageneration {
+		return nil, errorf(http.StatusPreconditionFailed, "bucket metageneration %d does not match %d", b.attrs.Metageneration, mg)
+	}
+	if mg, ok, err := int64Param(params, "ifMetagenerationNotMatch"); err != nil {
+		return nil, err
+	} else if ok && mg == b.attrs.Metageneration {
+		if r.Method == http.MethodGet {
+			return nil, errorf(http.StatusNotModified, "bucket metageneration is %d", mg)
+		}
+		return nil, errorf(http.StatusPreconditionFailed, "bucket metageneration is %d", mg)
+	}
+	return b, nil
+}
+
+func (s *Server) getBucket(r *http.Request, name string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.lookupBucket(r, name)
+	if err != nil {
+		return nil, err
+	}
+	return b.rawBucket(), nil
+}
+
+func (s *Server) patchBucket(r *http.Request, name string) (interface{}, error) {
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.lookupBucket(r, name)
+	if err != nil {
+		return nil, err
+	}
+	var rb raw.Bucket
+	if err := mergePatch(b.rawBucket(), patch, &rb); err != nil {
+		return nil, err
+	}
+	old := b.attrs
+	if old.RetentionPolicy != nil && old.RetentionPolicy.IsLocked {
+		if rb.RetentionPolicy == nil || rb.RetentionPolicy.RetentionPeriod < old.RetentionPolicy.RetentionPeriod {
+			return nil, errorf(http.StatusForbidden, "cannot remove or reduce a locked retention policy")
+		}
+	}
+	if rb.RetentionPolicy != nil {
+		rb.RetentionPolicy.IsLocked = old.RetentionPolicy != nil && old.RetentionPolicy.IsLocked
+		if old.RetentionPolicy == nil || old.RetentionPolicy.RetentionPeriod != rb.RetentionPolicy.RetentionPeriod {
+			rb.RetentionPolicy.EffectiveTime = s.now()
+		}
+	}
+	b.acl = bucketACLRules(rb.Acl)
+	b.defaultObjectACL = objectACLRules(rb.DefaultObjectAcl)
+	rb.Acl = nil
+	rb.DefaultObjectAcl = nil
+	// Restore the fields that cannot be changed.
+	rb.Kind = old.Kind
+	rb.Id = old.Id
+	rb.Name = old.Name
+	rb.SelfLink = old.SelfLink
+	rb.Location = old.Location
+	rb.LocationType = old.LocationType
+	rb.TimeCreated = old.TimeCreated
+	rb.Metageneration = old.Metageneration
+	b.attrs = &rb
+	s.touchBucket(b)
+	return b.rawBucket(), nil
+}
+
+func (s *Server) deleteBucket(r *http.Request, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.lookupBucket(r, name)
+	if err != nil {
+		return err
+	}
+	if !b.isEmpty() {
+		return errorf(http.StatusConflict, "The bucket you tried to delete is not empty.")
+	}
+	delete(s.buckets, name)
+	return nil
+}
+
+func (s *Server) listBuckets(r *http.Request) (interface{}, error) {
+	params := r.URL.Query()
+	project := params.Get("project")
+	if project == "" {
+		return nil, errorf(http.StatusBadRequest, "project is required")
+	}
+	prefix := params.Get("prefix")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name, b := range s.buckets {
+		if b.project == project && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	start, end, next, err := pageBounds(params, len(names))
+	if err != nil {
+		return nil, err
+	}
+	resp := &raw.Buckets{
+		Kind:          "storage#buckets",
+		Items:         []*raw.Bucket{},
+		NextPageToken: next,
+	}
+	for _, name := range names[start:end] {
+		resp.Items = append(resp.Items, s.buckets[name].rawBucket())
+	}
+	return resp, nil
+}
+
+func (s *Server) lockRetentionPolicy(r *http.Request, name string) (interface{}, error) {
+	if _, ok := r.URL.Query()["ifMetagenerationMatch"]; !ok {
+		return nil, errorf(http.StatusBadRequest, "ifMetagenerationMatch is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.lookupBucket(r, name)
+	if err != nil {
+		return nil, err
+	}
+	if b.attrs.RetentionPolicy == nil {
+		return nil, errorf(http.StatusBadRequest, "bucket %q has no retention policy", name)
+	}
+	rp := *b.attrs.RetentionPolicy
+	rp.IsLocked = true
+	b.attrs.RetentionPolicy = &rp
+	s.touchBucket(b)
+	return b.rawBucket(), nil
+}
+
+func (s *Server) handleBucketACL(r *http.Request, name string, entitySegs []string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[name]
+	if !ok {
+		return nil, errBucketNotFound(name)
+	}
+	return s.handleACL(r, aclList{
+		rules: &b.acl,
+		item: func(rule aclRule) interface{} {
+			return bucketACLItems(name, []aclRule{rule})[0]
+		},
+		items: func(rules []aclRule) interface{} {
+			return &raw.BucketAccessControls{
+				Kind:  "storage#bucketAccessControls",
+				Items: bucketACLItems(name, rules),
+			}
+		},
+		modified: func() { s.touchBucket(b) },
+	}, entitySegs)
+}
+
+func (s *Server) handleDefaultObjectACL(r *http.Request, name string, entitySegs []string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[name]
+	if !ok {
+		return nil, errBucketNotFound(name)
+	}
+	return s.handleACL(r, aclList{
+		rules: &b.defaultObjectACL,
+		item: func(rule aclRule) interface{} {
+			return objectACLItems(name, "", 0, []aclRule{rule})[0]
+		},
+		items: func(rules []aclRule) interface{} {
+			return &raw.ObjectAccessControls{
+				Kind:  "storage#objectAccessControls",
+				Items: objectACLItems(name, "", 0, rules),
+			}
+		},
+		modified: func() { s.touchBucket(b) },
+	}, entitySegs)
+}
+
+// etag returns an entity tag for a resource with the given metageneration.
+func etag(metageneration int64) string {
+	return "CA" + strconv.FormatInt(metageneration, 10) + "="
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest_test
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+	"cloud.google.com/go/storage/storagetest"
+)
+
+func ExampleNewServer() {
+	ctx := context.Background()
+	// Start a fake server running locally.
+	srv := storagetest.NewServer()
+	defer srv.Close()
+	// Use the server's endpoint when creating a storage client.
+	client, err := storage.NewClient(ctx, srv.ClientOptions()...)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer client.Close()
+	// Buckets must be created before objects can be written to them.
+	if err := client.Bucket("my-bucket").Create(ctx, "my-project", nil); err != nil {
+		// TODO: Handle error.
+	}
+	_ = client // TODO: Use the client.
+}
@@ -0,0 +1,556 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storagetest provides a fake Cloud Storage JSON API server for
+// testing. It implements a simplified form of the service that keeps all
+// buckets and objects in memory, suitable for unit tests of code that uses
+// cloud.google.com/go/storage. It may behave differently from the actual
+// service in ways in which the service is unspecified, and it does not
+// implement IAM, notifications, HMAC keys, encryption or access control
+// enforcement.
+//
+// The server supports bucket create, get, update, list and delete; simple,
+// multipart and resumable object uploads; full and range reads; object
+// generations, versioning and preconditions; compose and rewrite; object
+// listing with prefix, delimiter and versions; and ACL and metadata updates.
+//
+// This package is EXPERIMENTAL and is subject to change without notice.
+//
+// See the example for usage.
+package storagetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// Server is a fake Cloud Storage server.
+type Server struct {
+	srv *httptest.Server
+
+	mu           sync.Mutex
+	buckets      map[string]*bucket
+	uploads      map[string]*upload
+	lastGen      int64
+	nextUploadID int
+	timeNowFunc  func() time.Time
+}
+
+// NewServer creates a new fake server running in the current process. It
+// listens on a local address chosen by the system.
+func NewServer() *Server {
+	s := &Server{
+		buckets:     map[string]*bucket{},
+		uploads:     map[string]*upload{},
+		timeNowFunc: time.Now,
+	}
+	s.srv = httptest.NewServer(s)
+	return s
+}
+
+// URL returns the base URL of the server, e.g. "http://127.0.0.1:1234".
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Endpoint returns the JSON API endpoint of the server. It can be passed to
+// storage.NewClient with option.WithEndpoint.
+func (s *Server) Endpoint() string {
+	return s.srv.URL + "/storage/v1/"
+}
+
+// ClientOptions returns the options needed for storage.NewClient to connect to
+// the server.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.Endpoint()),
+		option.WithoutAuthentication(),
+	}
+}
+
+// SetTimeNowFunc registers f as a function to be used instead of time.Now
+// for this server.
+func (s *Server) SetTimeNowFunc(f func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeNowFunc = f
+}
+
+// Close shuts down the server and blocks until all outstanding requests on
+// the server have completed.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// ServeHTTP implements http.Handler. Requests are routed by path to the JSON
+// API (/storage/v1/...), the upload API (/upload/storage/v1/...) or object
+// media downloads (/download/storage/v1/... and /BUCKET/OBJECT).
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segs, err := splitPath(r.URL.EscapedPath())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	switch {
+	case hasPrefix(segs, "upload", "storage", "v1"):
+		s.handleUpload(w, r, segs[3:])
+	case hasPrefix(segs, "download", "storage", "v1"):
+		s.handleJSONDownload(w, r, segs[3:])
+	case hasPrefix(segs, "storage", "v1"):
+		if r.URL.Query().Get("alt") == "media" {
+			s.handleJSONDownload(w, r, segs[2:])
+			return
+		}
+		resp, err := s.handleJSON(r, segs[2:])
+		writeResponse(w, resp, err)
+	default:
+		s.handleXMLDownload(w, r)
+	}
+}
+
+// handleJSON dispatches a JSON API request. segs is the request path after
+// "/storage/v1".
+func (s *Server) handleJSON(r *http.Request, segs []string) (interface{}, error) {
+	if len(segs) == 0 || segs[0] != "b" {
+		return nil, errNotFound(r)
+	}
+	if len(segs) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			return s.listBuckets(r)
+		case http.MethodPost:
+			return s.insertBucket(r)
+		}
+		return nil, errMethod(r)
+	}
+	bucketName := segs[1]
+	switch {
+	case len(segs) == 2:
+		switch r.Method {
+		case http.MethodGet:
+			return s.getBucket(r, bucketName)
+		case http.MethodPatch:
+			return s.patchBucket(r, bucketName)
+		case http.MethodDelete:
+			return nil, s.deleteBucket(r, bucketName)
+		}
+	case len(segs) == 3 && segs[2] == "lockRetentionPolicy" && r.Method == http.MethodPost:
+		return s.lockRetentionPolicy(r, bucketName)
+	case segs[2] == "acl" && len(segs) <= 4:
+		return s.handleBucketACL(r, bucketName, segs[3:])
+	case segs[2] == "defaultObjectAcl" && len(segs) <= 4:
+		return s.handleDefaultObjectACL(r, bucketName, segs[3:])
+	case segs[2] == "o" && len(segs) == 3:
+		if r.Method == http.MethodGet {
+			return s.listObjects(r, bucketName)
+		}
+	case segs[2] == "o" && len(segs) == 4:
+		objectName := segs[3]
+		switch r.Method {
+		case http.MethodGet:
+			return s.getObject(r, bucketName, objectName)
+		case http.MethodPatch:
+			return s.patchObject(r, bucketName, objectName)
+		case http.MethodDelete:
+			return nil, s.deleteObject(r, bucketName, objectName)
+		}
+	case segs[2] == "o" && len(segs) == 5 && segs[4] == "compose" && r.Method == http.MethodPost:
+		return s.composeObject(r, bucketName, segs[3])
+	case segs[2] == "o" && len(segs) == 9 && segs[4] == "rewriteTo" && segs[5] == "b" && segs[7] == "o" && r.Method == http.MethodPost:
+		return s.rewriteObject(r, bucketName, segs[3], segs[6], segs[8])
+	case segs[2] == "o" && len(segs) >= 5 && len(segs) <= 6 && segs[4] == "acl":
+		return s.handleObjectACL(r, bucketName, segs[3], segs[5:])
+	default:
+		return nil, errNotFound(r)
+	}
+	return nil, errMethod(r)
+}
+
+// nextGeneration returns a new object generation. Like the real service,
+// generations are derived from the current time in microseconds. They are
+// strictly increasing. s.mu must be held.
+func (s *Server) nextGeneration() int64 {
+	gen := s.timeNowFunc().UnixNano() / 1e3
+	if gen <= s.lastGen {
+		gen = s.lastGen + 1
+	}
+	s.lastGen = gen
+	return gen
+}
+
+// now returns the current time formatted for a JSON API resource. s.mu must
+// be held.
+func (s *Server) now() string {
+	return s.timeNowFunc().UTC().Format(time.RFC3339Nano)
+}
+
+// httpError is an error with an HTTP status code, reported to clients in the
+// JSON API error format.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("storagetest: %d %s", e.code, e.msg)
+}
+
+func errorf(code int, format string, args ...interface{}) *httpError {
+	return &httpError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+func errNotFound(r *http.Request) error {
+	return errorf(http.StatusNotFound, "Not Found: %s", r.URL.Path)
+}
+
+func errMethod(r *http.Request) error {
+	return errorf(http.StatusMethodNotAllowed, "method %s not supported for %s", r.Method, r.URL.Path)
+}
+
+func errBucketNotFound(name string) error {
+	return errorf(http.StatusNotFound, "The specified bucket %q does not exist.", name)
+}
+
+func errObjectNotFound(bucket, name string) error {
+	return errorf(http.StatusNotFound, "No such object: %s/%s", bucket, name)
+}
+
+func writeResponse(w http.ResponseWriter, resp interface{}, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, resp interface{}) {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, errorf(http.StatusInternalServerError, "marshaling response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	herr, ok := err.(*httpError)
+	if !ok {
+		herr = errorf(http.StatusInternalServerError, "%v", err)
+	}
+	type errorItem struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason,omitempty"`
+	}
+	var body struct {
+		Error struct {
+			Code    int         `json:"code"`
+			Message string      `json:"message"`
+			Errors  []errorItem `json:"errors"`
+		} `json:"error"`
+	}
+	body.Error.Code = herr.code
+	body.Error.Message = herr.msg
+	body.Error.Errors = []errorItem{{Message: herr.msg, Reason: errorReason(herr.code)}}
+	b, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(herr.code)
+	w.Write(b)
+}
+
+func errorReason(code int) string {
+	switch code {
+	case http.StatusBadRequest:
+		return "invalid"
+	case http.StatusNotFound:
+		return "notFound"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusPreconditionFailed:
+		return "conditionNotMet"
+	case http.StatusNotModified:
+		return "notModified"
+	}
+	return ""
+}
+
+// splitPath splits an escaped URL path into unescaped segments. Object names
+// in JSON API paths are escaped, so they are a single segment even if they
+// contain slashes.
+func splitPath(p string) ([]string, error) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil, nil
+	}
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		u, err := url.PathUnescape(seg)
+		if err != nil {
+			return nil, errorf(http.StatusBadRequest, "invalid path: %v", err)
+		}
+		segs[i] = u
+	}
+	return segs, nil
+}
+
+func hasPrefix(segs []string, prefix ...string) bool {
+	if len(segs) < len(prefix) {
+		return false
+	}
+	for i, p := range prefix {
+		if segs[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeBody decodes the JSON request body into v.
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// int64Param returns the value of the named int64 query parameter, and
+// whether it was present.
+func int64Param(params url.Values, name string) (int64, bool, error) {
+	v := params.Get(name)
+	if v == "" {
+		return 0, false, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false, errorf(http.StatusBadRequest, "invalid value %q for parameter %s", v, name)
+	}
+	return n, true, nil
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to the JSON encoding of
+// target and decodes the result into out. This matches the semantics of the
+// JSON API's patch methods: fields missing from the patch are unchanged, null
+// fields are cleared and nested objects such as metadata are merged.
+func mergePatch(target interface{}, patch []byte, out interface{}) error {
+	tb, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(tb, &doc); err != nil {
+		return err
+	}
+	p := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	mergeMaps(doc, p)
+	mb, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(mb, out); err != nil {
+		return errorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		if sm, ok := v.(map[string]interface{}); ok {
+			dm, ok := dst[k].(map[string]interface{})
+			if !ok {
+				dm = map[string]interface{}{}
+				dst[k] = dm
+			}
+			mergeMaps(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// pageBounds returns the slice bounds of the page of n results starting at
+// pageToken, and the token of the next page.
+func pageBounds(params url.Values, n int) (start, end int, nextToken string, err error) {
+	if tok := params.Get("pageToken"); tok != "" {
+		start, err = strconv.Atoi(tok)
+		if err != nil || start < 0 || start > n {
+			return 0, 0, "", errorf(http.StatusBadRequest, "invalid page token %q", tok)
+		}
+	}
+	pageSize := 1000
+	if ms, ok, err := int64Param(params, "maxResults"); err != nil {
+		return 0, 0, "", err
+	} else if ok && ms > 0 && ms < int64(pageSize) {
+		pageSize = int(ms)
+	}
+	end = start + pageSize
+	if end >= n {
+		return start, n, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
+
+// aclRule is an entry of a bucket, default object or object ACL.
+type aclRule struct {
+	Entity string `json:"entity"`
+	Role   string `json:"role"`
+}
+
+// aclList describes an ACL that can be manipulated by handleACL.
+type aclList struct {
+	rules *[]aclRule
+	// item converts a rule to its JSON API resource.
+	item func(aclRule) interface{}
+	// items converts all rules to the JSON API list response.
+	items func([]aclRule) interface{}
+	// modified is called after the ACL has been changed.
+	modified func()
+}
+
+// handleACL handles a request on an ACL. entitySegs holds the entity of the
+// request, if any. s.mu must be held.
+func (s *Server) handleACL(r *http.Request, a aclList, entitySegs []string) (interface{}, error) {
+	if len(entitySegs) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			return a.items(*a.rules), nil
+		case http.MethodPost:
+			var rule aclRule
+			if err := decodeBody(r, &rule); err != nil {
+				return nil, err
+			}
+			if rule.Entity == "" {
+				return nil, errorf(http.StatusBadRequest, "ACL entity is required")
+			}
+			setACLRule(a.rules, rule)
+			a.modified()
+			return a.item(rule), nil
+		}
+		return nil, errMethod(r)
+	}
+	entity := entitySegs[0]
+	i := findACLRule(*a.rules, entity)
+	switch r.Method {
+	case http.MethodGet:
+		if i < 0 {
+			return nil, errorf(http.StatusNotFound, "ACL entity %q not found", entity)
+		}
+		return a.item((*a.rules)[i]), nil
+	case http.MethodPut, http.MethodPatch:
+		var rule aclRule
+		if err := decodeBody(r, &rule); err != nil {
+			return nil, err
+		}
+		rule.Entity = entity
+		if rule.Role == "" && i >= 0 {
+			rule.Role = (*a.rules)[i].Role
+		}
+		setACLRule(a.rules, rule)
+		a.modified()
+		return a.item(rule), nil
+	case http.MethodDelete:
+		if i < 0 {
+			return nil, errorf(http.StatusNotFound, "ACL entity %q not found", entity)
+		}
+		*a.rules = append((*a.rules)[:i:i], (*a.rules)[i+1:]...)
+		a.modified()
+		return nil, nil
+	}
+	return nil, errMethod(r)
+}
+
+func findACLRule(rules []aclRule, entity string) int {
+	for i, r := range rules {
+		if r.Entity == entity {
+			return i
+		}
+	}
+	return -1
+}
+
+func setACLRule(rules *[]aclRule, rule aclRule) {
+	if i := findACLRule(*rules, rule.Entity); i >= 0 {
+		// Copy before modifying, as the slice may be shared with an older
+		// resource.
+		*rules = append([]aclRule(nil), *rules...)
+		(*rules)[i] = rule
+		return
+	}
+	*rules = append((*rules)[:len(*rules):len(*rules)], rule)
+}
+
+func bucketACLItems(bucket string, rules []aclRule) []*raw.BucketAccessControl {
+	items := []*raw.BucketAccessControl{}
+	for _, r := range rules {
+		items = append(items, &raw.BucketAccessControl{
+			Kind:   "storage#bucketAccessControl",
+			Bucket: bucket,
+			Entity: r.Entity,
+			Role:   r.Role,
+		})
+	}
+	return items
+}
+
+func objectACLItems(bucket, object string, generation int64, rules []aclRule) []*raw.ObjectAccessControl {
+	items := []*raw.ObjectAccessControl{}
+	for _, r := range rules {
+		items = append(items, &raw.ObjectAccessControl{
+			Kind:       "storage#objectAccessControl",
+			Bucket:     bucket,
+			Object:     object,
+			Generation: generation,
+			Entity:     r.Entity,
+			Role:       r.Role,
+		})
+	}
+	return items
+}
+
+func bucketACLRules(items []*raw.BucketAccessControl) []aclRule {
+	var rules []aclRule
+	for _, it := range items {
+		rules = append(rules, aclRule{Entity: it.Entity, Role: it.Role})
+	}
+	return rules
+}
+
+func objectACLRules(items []*raw.ObjectAccessControl) []aclRule {
+	var rules []aclRule
+	for _, it := range items {
+		rules = append(rules, aclRule{Entity: it.Entity, Role: it.Role})
+	}
+	return rules
+}
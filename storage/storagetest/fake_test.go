@@ -0,0 +1,666 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/xerrors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+const testProject = "proj"
+
+func newTestClient(ctx context.Context, t *testing.T) (*storage.Client, *Server, func()) {
+	t.Helper()
+	srv := NewServer()
+	client, err := storage.NewClient(ctx, srv.ClientOptions()...)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return client, srv, func() {
+		client.Close()
+		srv.Close()
+	}
+}
+
+func createBucket(ctx context.Context, t *testing.T, client *storage.Client, name string, attrs *storage.BucketAttrs) *storage.BucketHandle {
+	t.Helper()
+	b := client.Bucket(name)
+	if err := b.Create(ctx, testProject, attrs); err != nil {
+		t.Fatalf("creating bucket %q: %v", name, err)
+	}
+	return b
+}
+
+func writeObject(ctx context.Context, t *testing.T, o *storage.ObjectHandle, data []byte, chunkSize int) *storage.ObjectAttrs {
+	t.Helper()
+	w := o.NewWriter(ctx)
+	w.ChunkSize = chunkSize
+	w.ContentType = "text/plain"
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("writing %q: %v", o.ObjectName(), err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing writer for %q: %v", o.ObjectName(), err)
+	}
+	return w.Attrs()
+}
+
+func readObject(ctx context.Context, o *storage.ObjectHandle, offset, length int64) ([]byte, error) {
+	r, err := o.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func errCode(err error) int {
+	var e *googleapi.Error
+	if xerrors.As(err, &e) {
+		return e.Code
+	}
+	return 0
+}
+
+func TestBuckets(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+
+	b := createBucket(ctx, t, client, "b1", &storage.BucketAttrs{Labels: map[string]string{"a": "1"}})
+	createBucket(ctx, t, client, "b2", nil)
+	if err := client.Bucket("b1").Create(ctx, testProject, nil); errCode(err) != http.StatusConflict {
+		t.Errorf("creating existing bucket: got %v, want 409", err)
+	}
+
+	attrs, err := b.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Name != "b1" || attrs.MetaGeneration != 1 || attrs.Labels["a"] != "1" || attrs.Location != "US" {
+		t.Errorf("got attrs %+v", attrs)
+	}
+
+	var uattrs storage.BucketAttrsToUpdate
+	uattrs.VersioningEnabled = true
+	uattrs.SetLabel("b", "2")
+	uattrs.DeleteLabel("a")
+	attrs, err = b.If(storage.BucketConditions{MetagenerationMatch: 1}).Update(ctx, uattrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !attrs.VersioningEnabled || attrs.MetaGeneration != 2 {
+		t.Errorf("got VersioningEnabled %t, MetaGeneration %d; want true, 2", attrs.VersioningEnabled, attrs.MetaGeneration)
+	}
+	if diff := cmp.Diff(attrs.Labels, map[string]string{"b": "2"}); diff != "" {
+		t.Errorf("labels: got=-, want=+:\n%s", diff)
+	}
+	if _, err := b.If(storage.BucketConditions{MetagenerationMatch: 1}).Update(ctx, uattrs); errCode(err) != http.StatusPreconditionFailed {
+		t.Errorf("update with stale metageneration: got %v, want 412", err)
+	}
+
+	var names []string
+	it := client.Buckets(ctx, testProject)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, attrs.Name)
+	}
+	if diff := cmp.Diff(names, []string{"b1", "b2"}); diff != "" {
+		t.Errorf("bucket names: got=-, want=+:\n%s", diff)
+	}
+
+	writeObject(ctx, t, b.Object("o"), []byte("x"), 0)
+	if err := b.Delete(ctx); errCode(err) != http.StatusConflict {
+		t.Errorf("deleting non-empty bucket: got %v, want 409", err)
+	}
+	if err := client.Bucket("b2").Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Bucket("b2").Attrs(ctx); err != storage.ErrBucketNotExist {
+		t.Errorf("got %v, want ErrBucketNotExist", err)
+	}
+}
+
+func TestUploadAndRead(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	b := createBucket(ctx, t, client, "bucket", nil)
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+
+	for _, test := range []struct {
+		desc      string
+		chunkSize int
+	}{
+		{"multipart", 0},
+		{"resumable in one chunk", len(data) * 2},
+		{"resumable in several chunks", 256 * 1024},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			o := b.Object("dir/" + test.desc)
+			attrs := writeObject(ctx, t, o, data, test.chunkSize)
+			if attrs.Size != int64(len(data)) || attrs.Generation == 0 || attrs.ContentType != "text/plain" {
+				t.Errorf("got attrs %+v", attrs)
+			}
+
+			got, err := readObject(ctx, o, 0, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("read %d bytes, want %d bytes written", len(got), len(data))
+			}
+		})
+	}
+
+	o := b.Object("dir/multipart")
+	for _, test := range []struct {
+		offset, length int64
+		want           []byte
+	}{
+		{0, 5, data[:5]},
+		{10, 10, data[10:20]},
+		{int64(len(data)) - 3, -1, data[len(data)-3:]},
+		{-4, -1, data[len(data)-4:]},
+		{int64(len(data)) - 2, 100, data[len(data)-2:]},
+		{0, 0, []byte{}},
+	} {
+		got, err := readObject(ctx, o, test.offset, test.length)
+		if err != nil {
+			t.Errorf("offset %d, length %d: %v", test.offset, test.length, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("offset %d, length %d: got %q, want %q", test.offset, test.length, got, test.want)
+		}
+	}
+
+	if _, err := readObject(ctx, b.Object("missing"), 0, -1); err != storage.ErrObjectNotExist {
+		t.Errorf("reading missing object: got %v, want ErrObjectNotExist", err)
+	}
+	if _, err := b.Object("missing").Attrs(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("missing object attrs: got %v, want ErrObjectNotExist", err)
+	}
+}
+
+func TestUploadChecksums(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	b := createBucket(ctx, t, client, "bucket", nil)
+
+	w := b.Object("o").NewWriter(ctx)
+	w.CRC32C = 1234
+	w.SendCRC32C = true
+	w.Write([]byte("data"))
+	if err := w.Close(); errCode(err) != http.StatusBadRequest {
+		t.Errorf("upload with wrong CRC32C: got %v, want 400", err)
+	}
+}
+
+func TestSimpleUpload(t *testing.T) {
+	ctx := context.Background()
+	client, srv, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	b := createBucket(ctx, t, client, "bucket", nil)
+
+	u := srv.URL() + "/upload/storage/v1/b/bucket/o?uploadType=media&name=a%2Fb"
+	res, err := http.Post(u, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", res.StatusCode)
+	}
+	got, err := readObject(ctx, b.Object("a/b"), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+}
+
+func TestResumableUploadRetriesAndStatus(t *testing.T) {
+	ctx := context.Background()
+	client, srv, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	b := createBucket(ctx, t, client, "bucket", nil)
+
+	do := func(method, u, contentRange, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, u, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentRange != "" {
+			req.Header.Set("Content-Range", contentRange)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	res := do("POST", srv.URL()+"/upload/storage/v1/b/bucket/o?uploadType=resumable&name=o", "", `{"contentType":"text/plain"}`)
+	session := res.Header.Get("Location")
+	if res.StatusCode != http.StatusOK || session == "" {
+		t.Fatalf("got status %d, Location %q", res.StatusCode, session)
+	}
+	for _, test := range []struct {
+		contentRange, body string
+		wantStatus         int
+		wantRange          string
+	}{
+		{"bytes 0-2/*", "abc", http.StatusPermanentRedirect, "bytes=0-2"},
+		// A retried chunk is ignored.
+		{"bytes 0-2/*", "abc", http.StatusPermanentRedirect, "bytes=0-2"},
+		// An overlapping chunk appends the new bytes.
+		{"bytes 2-4/*", "cde", http.StatusPermanentRedirect, "bytes=0-4"},
+		{"bytes */*", "", http.StatusPermanentRedirect, "bytes=0-4"},
+		{"bytes 5-5/6", "f", http.StatusOK, ""},
+		// Further requests return the object.
+		{"bytes */6", "", http.StatusOK, ""},
+	} {
+		res := do("PUT", session, test.contentRange, test.body)
+		if res.StatusCode != test.wantStatus || res.Header.Get("Range") != test.wantRange {
+			t.Errorf("%s: got status %d, Range %q; want %d, %q", test.contentRange,
+				res.StatusCode, res.Header.Get("Range"), test.wantStatus, test.wantRange)
+		}
+	}
+	got, err := readObject(ctx, b.Object("o"), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abcdef" {
+		t.Errorf("got %q, want %q", got, "abcdef")
+	}
+
+	res = do("POST", srv.URL()+"/upload/storage/v1/b/bucket/o?uploadType=resumable&name=cancelled", "", "")
+	if res := do("DELETE", res.Header.Get("Location"), "", ""); res.StatusCode != statusClientClosedRequest {
+		t.Errorf("cancel: got status %d, want %d", res.StatusCode, statusClientClosedRequest)
+	}
+}
+
+func TestGenerationsAndPreconditions(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	b := createBucket(ctx, t, client, "bucket", &storage.BucketAttrs{VersioningEnabled: true})
+	o := b.Object("o")
+
+	attrs1 := writeObject(ctx, t, o.If(storage.Conditions{DoesNotExist: true}), []byte("v1"), 0)
+	w := o.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	w.Write([]byte("v2"))
+	if err := w.Close(); errCode(err) != http.StatusPreconditionFailed {
+		t.Errorf("DoesNotExist on existing object: got %v, want 412", err)
+	}
+	attrs2 := writeObject(ctx, t, o.If(storage.Conditions{GenerationMatch: attrs1.Generation}), []byte("v2"), 0)
+	if attrs2.Generation <= attrs1.Generation {
+		t.Errorf("got generation %d, want > %d", attrs2.Generation, attrs1.Generation)
+	}
+
+	for _, test := range []struct {
+		o    *storage.ObjectHandle
+		want string
+	}{
+		{o, "v2"},
+		{o.Generation(attrs1.Generation), "v1"},
+		{o.Generation(attrs2.Generation), "v2"},
+	} {
+		got, err := readObject(ctx, test.o, 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+	if _, err := readObject(ctx, o.If(storage.Conditions{GenerationMatch: attrs1.Generation}), 0, -1); errCode(err) != http.StatusPreconditionFailed {
+		t.Errorf("read with stale generation: got %v, want 412", err)
+	}
+
+	cond := o.If(storage.Conditions{MetagenerationMatch: 1})
+	if _, err := cond.Update(ctx, storage.ObjectAttrsToUpdate{ContentType: "text/html"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cond.Update(ctx, storage.ObjectAttrsToUpdate{ContentType: "text/csv"}); errCode(err) != http.StatusPreconditionFailed {
+		t.Errorf("update with stale metageneration: got %v, want 412", err)
+	}
+
+	// Deleting the live version of a versioned object makes it noncurrent.
+	if err := o.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Attrs(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("got %v, want ErrObjectNotExist", err)
+	}
+	var gens []int64
+	it := b.Objects(ctx, &storage.Query{Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Deleted.IsZero() {
+			t.Errorf("generation %d is live, want noncurrent", attrs.Generation)
+		}
+		gens = append(gens, attrs.Generation)
+	}
+	if diff := cmp.Diff(gens, []int64{attrs1.Generation, attrs2.Generation}); diff != "" {
+		t.Errorf("generations: got=-, want=+:\n%s", diff)
+	}
+	if err := o.Generation(attrs1.Generation).Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Generation(attrs1.Generation).Attrs(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("got %v, want ErrObjectNotExist", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	b := createBucket(ctx, t, client, "bucket", &storage.BucketAttrs{VersioningEnabled: true})
+	for _, name := range []string{"a", "b/1", "b/2", "b/c/3", "c", "d/4", "e/5"} {
+		writeObject(ctx, t, b.Object(name), []byte(name), 0)
+	}
+	// e/5 is noncurrent, so e/ is only a prefix when versions are listed.
+	if err := b.Object("e/5").Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(q *storage.Query) []string {
+		var got []string
+		it := b.Objects(ctx, q)
+		it.PageInfo().MaxSize = 2
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.Prefix != "" {
+				got = append(got, "prefix:"+attrs.Prefix)
+			} else {
+				got = append(got, attrs.Name)
+			}
+		}
+		return got
+	}
+	for _, test := range []struct {
+		q    *storage.Query
+		want []string
+	}{
+		{nil, []string{"a", "b/1", "b/2", "b/c/3", "c", "d/4"}},
+		{&storage.Query{Prefix: "b/"}, []string{"b/1", "b/2", "b/c/3"}},
+		{&storage.Query{Delimiter: "/"}, []string{"a", "prefix:b/", "c", "prefix:d/"}},
+		{&storage.Query{Prefix: "b/", Delimiter: "/"}, []string{"b/1", "b/2", "prefix:b/c/"}},
+		{&storage.Query{StartOffset: "b/2", EndOffset: "d"}, []string{"b/2", "b/c/3", "c"}},
+		{&storage.Query{Delimiter: "/", Versions: true}, []string{"a", "prefix:b/", "c", "prefix:d/", "prefix:e/"}},
+	} {
+		if diff := cmp.Diff(list(test.q), test.want); diff != "" {
+			t.Errorf("%+v: got=-, want=+:\n%s", test.q, diff)
+		}
+	}
+}
+
+func TestComposeAndCopy(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	b := createBucket(ctx, t, client, "bucket", nil)
+	b2 := createBucket(ctx, t, client, "bucket2", nil)
+
+	var srcs []*storage.ObjectHandle
+	var want string
+	for i := 0; i < 3; i++ {
+		o := b.Object(fmt.Sprintf("part%d", i))
+		writeObject(ctx, t, o, []byte(fmt.Sprint(i)), 0)
+		srcs = append(srcs, o)
+		want += fmt.Sprint(i)
+	}
+	c := b.Object("composed").ComposerFrom(srcs...)
+	c.ContentType = "text/csv"
+	attrs, err := c.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/csv" || attrs.Size != 3 {
+		t.Errorf("got attrs %+v", attrs)
+	}
+	got, err := readObject(ctx, b.Object("composed"), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	tooMany := make([]*storage.ObjectHandle, maxComposeComponents+1)
+	for i := range tooMany {
+		tooMany[i] = srcs[0]
+	}
+	if _, err := b.Object("x").ComposerFrom(tooMany...).Run(ctx); errCode(err) != http.StatusBadRequest {
+		t.Errorf("compose with %d sources: got %v, want 400", len(tooMany), err)
+	}
+
+	copier := b2.Object("copy").CopierFrom(b.Object("composed"))
+	copier.Metadata = map[string]string{"k": "v"}
+	attrs, err = copier.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Bucket != "bucket2" || attrs.ContentType != "text/csv" || attrs.Metadata["k"] != "v" {
+		t.Errorf("got attrs %+v", attrs)
+	}
+	got, err = readObject(ctx, b2.Object("copy"), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRewriteInSeveralCalls(t *testing.T) {
+	ctx := context.Background()
+	client, srv, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	b := createBucket(ctx, t, client, "bucket", nil)
+	writeObject(ctx, t, b.Object("src"), []byte("0123456789"), 0)
+
+	u := srv.Endpoint() + "b/bucket/o/src/rewriteTo/b/bucket/o/dst?maxBytesRewrittenPerCall=4"
+	var calls int
+	for tok := ""; ; calls++ {
+		res, err := http.Post(u+"&rewriteToken="+tok, "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rr struct {
+			Done         bool
+			RewriteToken string
+		}
+		err = decodeJSON(res, &rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rr.Done {
+			break
+		}
+		tok = rr.RewriteToken
+	}
+	if calls != 2 {
+		t.Errorf("got %d incomplete calls, want 2", calls)
+	}
+	got, err := readObject(ctx, b.Object("dst"), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "0123456789" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestACL(t *testing.T) {
+	ctx := context.Background()
+	client, _, cleanup := newTestClient(ctx, t)
+	defer cleanup()
+	b := createBucket(ctx, t, client, "bucket", nil)
+
+	if err := b.DefaultObjectACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
+		t.Fatal(err)
+	}
+	o := b.Object("o")
+	writeObject(ctx, t, o, []byte("x"), 0)
+	if err := o.ACL().Set(ctx, "user-a@example.com", storage.RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := o.ACL().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []storage.ACLRule{
+		{Entity: storage.AllUsers, Role: storage.RoleReader},
+		{Entity: "user-a@example.com", Role: storage.RoleOwner},
+	}
+	sortRules := func(rules []storage.ACLRule) {
+		sort.Slice(rules, func(i, j int) bool { return rules[i].Entity < rules[j].Entity })
+	}
+	sortRules(rules)
+	if diff := cmp.Diff(rules, want); diff != "" {
+		t.Errorf("object ACL: got=-, want=+:\n%s", diff)
+	}
+
+	if err := o.ACL().Delete(ctx, storage.AllUsers); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(attrs.ACL, want[1:]); diff != "" {
+		t.Errorf("object ACL after delete: got=-, want=+:\n%s", diff)
+	}
+	if err := o.ACL().Delete(ctx, storage.AllUsers); errCode(err) != http.StatusNotFound {
+		t.Errorf("deleting missing entity: got %v, want 404", err)
+	}
+
+	if err := b.ACL().Set(ctx, storage.AllAuthenticatedUsers, storage.RoleWriter); err != nil {
+		t.Fatal(err)
+	}
+	brules, err := b.ACL().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(brules, []storage.ACLRule{{Entity: storage.AllAuthenticatedUsers, Role: storage.RoleWriter}}); diff != "" {
+		t.Errorf("bucket ACL: got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for _, test := range []struct {
+		rng                  string
+		size                 int64
+		wantStart, wantEnd   int64
+		wantPartial, wantErr bool
+	}{
+		{"", 10, 0, 10, false, false},
+		{"bytes=0-", 10, 0, 10, true, false},
+		{"bytes=2-5", 10, 2, 6, true, false},
+		{"bytes=2-50", 10, 2, 10, true, false},
+		{"bytes=-3", 10, 7, 10, true, false},
+		{"bytes=-30", 10, 0, 10, true, false},
+		{"bytes=10-", 10, 0, 0, false, true},
+		{"bytes=5-2", 10, 0, 0, false, true},
+		{"bytes=0-1,3-4", 10, 0, 0, false, true},
+		{"items=0-1", 10, 0, 0, false, true},
+	} {
+		start, end, partial, err := parseRange(test.rng, test.size)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("%q: got error %v, want error %t", test.rng, err, test.wantErr)
+			continue
+		}
+		if start != test.wantStart || end != test.wantEnd || partial != test.wantPartial {
+			t.Errorf("%q: got (%d, %d, %t), want (%d, %d, %t)", test.rng, start, end, partial,
+				test.wantStart, test.wantEnd, test.wantPartial)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, test := range []struct {
+		cr                   string
+		n                    int64
+		wantFirst, wantTotal int64
+		wantErr              bool
+	}{
+		{"", 5, 0, 5, false},
+		{"bytes 0-4/*", 5, 0, -1, false},
+		{"bytes 5-9/10", 5, 5, 10, false},
+		{"bytes */10", 0, -1, 10, false},
+		{"bytes */*", 0, -1, -1, false},
+		{"bytes 0-4/*", 4, 0, 0, true},
+		{"bytes */10", 1, 0, 0, true},
+		{"0-4/5", 5, 0, 0, true},
+	} {
+		first, total, err := parseContentRange(test.cr, test.n)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("%q: got error %v, want error %t", test.cr, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && (first != test.wantFirst || total != test.wantTotal) {
+			t.Errorf("%q: got (%d, %d), want (%d, %d)", test.cr, first, total, test.wantFirst, test.wantTotal)
+		}
+	}
+}
+
+func decodeJSON(res *http.Response, v interface{}) error {
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("status %d: %s", res.StatusCode, body)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,496 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	raw "google.golang.org/api/storage/v1"
+)
+
+// statusClientClosedRequest is returned when a resumable upload is cancelled.
+const statusClientClosedRequest = 499
+
+// upload is a resumable upload session.
+type upload struct {
+	bucket string
+	attrs  *raw.Object
+	conds  *conditions
+	data   []byte
+	// result is the object created by the upload, once it has completed.
+	result *raw.Object
+}
+
+// handleUpload handles a request to the upload API. segs is the request path
+// after "/upload/storage/v1".
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, segs []string) {
+	if len(segs) != 3 || segs[0] != "b" || segs[2] != "o" {
+		writeError(w, errNotFound(r))
+		return
+	}
+	bucketName := segs[1]
+	params := r.URL.Query()
+	conds, err := parseConditions(params, false)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var resp interface{}
+	switch params.Get("uploadType") {
+	case "media":
+		if r.Method != http.MethodPost {
+			writeError(w, errMethod(r))
+			return
+		}
+		resp, err = s.simpleUpload(r, bucketName, conds)
+	case "multipart":
+		if r.Method != http.MethodPost {
+			writeError(w, errMethod(r))
+			return
+		}
+		resp, err = s.multipartUpload(r, bucketName, conds)
+	case "resumable":
+		id := params.Get("upload_id")
+		switch {
+		case id == "" && r.Method == http.MethodPost:
+			s.startResumableUpload(w, r, bucketName, conds)
+			return
+		case id != "" && (r.Method == http.MethodPost || r.Method == http.MethodPut):
+			s.resumableUploadChunk(w, r, id)
+			return
+		case id != "" && r.Method == http.MethodDelete:
+			err = s.cancelResumableUpload(id)
+		default:
+			err = errMethod(r)
+		}
+	default:
+		err = errorf(http.StatusBadRequest, "unsupported uploadType %q", params.Get("uploadType"))
+	}
+	writeResponse(w, resp, err)
+}
+
+func (s *Server) simpleUpload(r *http.Request, bucketName string, conds *conditions) (interface{}, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	attrs := &raw.Object{
+		Name:        r.URL.Query().Get("name"),
+		ContentType: r.Header.Get("Content-Type"),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.insertObject(bucketName, attrs, data, conds)
+	if err != nil {
+		return nil, err
+	}
+	return o.rawObject(), nil
+}
+
+// multipartUpload handles an upload whose body is a multipart/related message
+// with two parts: the JSON object metadata and the object contents.
+func (s *Server) multipartUpload(r *http.Request, bucketName string, conds *conditions) (interface{}, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, errorf(http.StatusBadRequest, "multipart upload has invalid Content-Type %q", r.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "reading metadata part: %v", err)
+	}
+	attrs := &raw.Object{}
+	if err := json.NewDecoder(part).Decode(attrs); err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid object metadata: %v", err)
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "reading media part: %v", err)
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.Name == "" {
+		attrs.Name = r.URL.Query().Get("name")
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = part.Header.Get("Content-Type")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.insertObject(bucketName, attrs, data, conds)
+	if err != nil {
+		return nil, err
+	}
+	return o.rawObject(), nil
+}
+
+// startResumableUpload creates a resumable upload session and returns its URI
+// in the Location header. The preconditions of the request are checked when
+// the upload completes.
+func (s *Server) startResumableUpload(w http.ResponseWriter, r *http.Request, bucketName string, conds *conditions) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	attrs := &raw.Object{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, attrs); err != nil {
+			writeError(w, errorf(http.StatusBadRequest, "invalid object metadata: %v", err))
+			return
+		}
+	}
+	if attrs.Name == "" {
+		attrs.Name = r.URL.Query().Get("name")
+	}
+	if attrs.Name == "" {
+		writeError(w, errorf(http.StatusBadRequest, "object name is required"))
+		return
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = r.Header.Get("X-Upload-Content-Type")
+	}
+
+	s.mu.Lock()
+	if _, ok := s.buckets[bucketName]; !ok {
+		s.mu.Unlock()
+		writeError(w, errBucketNotFound(bucketName))
+		return
+	}
+	s.nextUploadID++
+	id := strconv.Itoa(s.nextUploadID)
+	s.uploads[id] = &upload{bucket: bucketName, attrs: attrs, conds: conds}
+	s.mu.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable&upload_id=%s",
+		s.URL(), url.PathEscape(bucketName), id))
+	w.WriteHeader(http.StatusOK)
+}
+
+// resumableUploadChunk handles a request that uploads a chunk of a resumable
+// upload, or queries its progress. The Content-Range header gives the offset
+// of the chunk and, for the final chunk, the total size of the object.
+func (s *Server) resumableUploadChunk(w http.ResponseWriter, r *http.Request, id string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	first, total, err := parseContentRange(r.Header.Get("Content-Range"), int64(len(data)))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	resp, persisted, err := s.writeUploadChunk(id, first, total, data)
+	s.mu.Unlock()
+	if err != nil || resp != nil {
+		writeResponse(w, resp, err)
+		return
+	}
+
+	// The upload is incomplete. The service reports this with status 308,
+	// or with an override header if the client asked it to avoid 308.
+	if persisted > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", persisted-1))
+	}
+	if r.Header.Get("X-GUploader-No-308") == "yes" {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+// writeUploadChunk appends a chunk at offset first to an upload, completing
+// the upload if its total size is known and has been reached. A negative
+// first or total means that it is unspecified. It returns the created object
+// if the upload has completed, and the number of bytes persisted otherwise.
+// s.mu must be held.
+func (s *Server) writeUploadChunk(id string, first, total int64, data []byte) (*raw.Object, int64, error) {
+	u, ok := s.uploads[id]
+	if !ok {
+		return nil, 0, errorf(http.StatusNotFound, "upload %q not found", id)
+	}
+	if u.result != nil {
+		return u.result, 0, nil
+	}
+	if first >= 0 {
+		persisted := int64(len(u.data))
+		if first > persisted {
+			return nil, 0, errorf(http.StatusBadRequest, "chunk offset %d is past the %d bytes persisted", first, persisted)
+		}
+		// Bytes that have already been persisted are ignored, so that a chunk
+		// can be retried.
+		if skip := persisted - first; skip < int64(len(data)) {
+			u.data = append(u.data, data[skip:]...)
+		}
+	}
+	size := int64(len(u.data))
+	if total < 0 || size < total {
+		return nil, size, nil
+	}
+	if size > total {
+		return nil, 0, errorf(http.StatusBadRequest, "upload has %d bytes, more than the total size %d", size, total)
+	}
+	o, err := s.insertObject(u.bucket, u.attrs, u.data, u.conds)
+	if err != nil {
+		delete(s.uploads, id)
+		return nil, 0, err
+	}
+	u.result = o.rawObject()
+	u.data = nil
+	return u.result, 0, nil
+}
+
+func (s *Server) cancelResumableUpload(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.uploads[id]; !ok {
+		return errorf(http.StatusNotFound, "upload %q not found", id)
+	}
+	delete(s.uploads, id)
+	return errorf(statusClientClosedRequest, "upload %q cancelled", id)
+}
+
+// parseContentRange parses the Content-Range header of a resumable upload
+// request: "bytes FIRST-LAST/TOTAL", where the range is "*" for a request
+// without data and the total is "*" if it is not yet known. Unspecified values
+// are returned as -1.
+func parseContentRange(cr string, n int64) (first, total int64, err error) {
+	invalid := errorf(http.StatusBadRequest, "invalid Content-Range %q", cr)
+	if cr == "" {
+		// The whole object in a single request.
+		return 0, n, nil
+	}
+	if !strings.HasPrefix(cr, "bytes ") {
+		return 0, 0, invalid
+	}
+	slash := strings.Index(cr, "/")
+	if slash < 0 {
+		return 0, 0, invalid
+	}
+	rng, tot := cr[len("bytes "):slash], cr[slash+1:]
+	first, total = -1, -1
+	if tot != "*" {
+		if total, err = strconv.ParseInt(tot, 10, 64); err != nil || total < 0 {
+			return 0, 0, invalid
+		}
+	}
+	if rng == "*" {
+		if n != 0 {
+			return 0, 0, invalid
+		}
+		return first, total, nil
+	}
+	dash := strings.Index(rng, "-")
+	if dash < 0 {
+		return 0, 0, invalid
+	}
+	first, err1 := strconv.ParseInt(rng[:dash], 10, 64)
+	last, err2 := strconv.ParseInt(rng[dash+1:], 10, 64)
+	if err1 != nil || err2 != nil || first < 0 || last-first+1 != n {
+		return 0, 0, invalid
+	}
+	return first, total, nil
+}
+
+// handleJSONDownload serves the contents of an object requested through the
+// JSON API with alt=media. segs is the request path after "/storage/v1" or
+// "/download/storage/v1".
+func (s *Server) handleJSONDownload(w http.ResponseWriter, r *http.Request, segs []string) {
+	if len(segs) != 4 || segs[0] != "b" || segs[2] != "o" {
+		writeError(w, errNotFound(r))
+		return
+	}
+	conds, err := parseConditions(r.URL.Query(), false)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.serveMedia(w, r, segs[1], segs[3], conds)
+}
+
+// handleXMLDownload serves the contents of an object requested through the XML
+// API, as storage.Reader does. The path is "/BUCKET/OBJECT" and preconditions
+// are given in headers.
+func (s *Server) handleXMLDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, errMethod(r))
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeError(w, errNotFound(r))
+		return
+	}
+	conds, err := headerConditions(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.serveMedia(w, r, parts[0], parts[1], conds)
+}
+
+// serveMedia writes the contents of an object, or the range of it given by the
+// Range header. Objects stored with gzip content encoding are served whole:
+// compressed if the client accepts gzip, and decompressed otherwise.
+func (s *Server) serveMedia(w http.ResponseWriter, r *http.Request, bucketName, name string, conds *conditions) {
+	s.mu.Lock()
+	o, err := s.lookupObject(bucketName, name, conds, true)
+	if err != nil {
+		s.mu.Unlock()
+		writeError(w, err)
+		return
+	}
+	attrs := *o.attrs
+	data := o.data
+	s.mu.Unlock()
+
+	h := w.Header()
+	h.Set("Content-Type", attrs.ContentType)
+	h.Set("Etag", attrs.Etag)
+	h.Set("X-Goog-Generation", strconv.FormatInt(attrs.Generation, 10))
+	h.Set("X-Goog-Metageneration", strconv.FormatInt(attrs.Metageneration, 10))
+	h.Set("X-Goog-Stored-Content-Length", strconv.Itoa(len(data)))
+	h.Add("X-Goog-Hash", "crc32c="+attrs.Crc32c)
+	if attrs.Md5Hash != "" {
+		h.Add("X-Goog-Hash", "md5="+attrs.Md5Hash)
+	}
+	if t, err := time.Parse(time.RFC3339, attrs.Updated); err == nil {
+		h.Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+	for header, v := range map[string]string{
+		"Cache-Control":       attrs.CacheControl,
+		"Content-Disposition": attrs.ContentDisposition,
+		"Content-Language":    attrs.ContentLanguage,
+	} {
+		if v != "" {
+			h.Set(header, v)
+		}
+	}
+	storedEncoding := attrs.ContentEncoding
+	if storedEncoding == "" {
+		storedEncoding = "identity"
+	}
+	h.Set("X-Goog-Stored-Content-Encoding", storedEncoding)
+
+	status := http.StatusOK
+	if attrs.ContentEncoding == "gzip" {
+		if acceptsGzip(r) {
+			h.Set("Content-Encoding", "gzip")
+		} else {
+			// Decompressive transcoding.
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err == nil {
+				data, err = ioutil.ReadAll(zr)
+			}
+			if err != nil {
+				writeError(w, errorf(http.StatusInternalServerError, "decompressing object: %v", err))
+				return
+			}
+		}
+	} else {
+		start, end, partial, err := parseRange(r.Header.Get("Range"), int64(len(data)))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if partial {
+			h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(data)))
+			status = http.StatusPartialContent
+		}
+		data = data[start:end]
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses a Range header of the form "bytes=FIRST-", "bytes=FIRST-LAST"
+// or "bytes=-SUFFIX" for an object of the given size. It returns the bounds of
+// the range as a slice expression and whether the header requested a range.
+func parseRange(rng string, size int64) (start, end int64, partial bool, err error) {
+	if rng == "" {
+		return 0, size, false, nil
+	}
+	unsatisfiable := errorf(http.StatusRequestedRangeNotSatisfiable, "The requested range %q cannot be satisfied for an object of size %d.", rng, size)
+	if !strings.HasPrefix(rng, "bytes=") {
+		return 0, 0, false, errorf(http.StatusBadRequest, "invalid Range %q", rng)
+	}
+	spec := strings.TrimPrefix(rng, "bytes=")
+	dash := strings.Index(spec, "-")
+	if dash < 0 || strings.Contains(spec, ",") {
+		return 0, 0, false, errorf(http.StatusBadRequest, "invalid Range %q", rng)
+	}
+	if dash == 0 {
+		suffix, err := strconv.ParseInt(spec[1:], 10, 64)
+		if err != nil {
+			return 0, 0, false, errorf(http.StatusBadRequest, "invalid Range %q", rng)
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, false, unsatisfiable
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size, true, nil
+	}
+	start, err = strconv.ParseInt(spec[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, false, errorf(http.StatusBadRequest, "invalid Range %q", rng)
+	}
+	end = size
+	if last := spec[dash+1:]; last != "" {
+		l, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || l < start {
+			return 0, 0, false, errorf(http.StatusBadRequest, "invalid Range %q", rng)
+		}
+		if l+1 < end {
+			end = l + 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, unsatisfiable
+	}
+	return start, end, true, nil
+}
@@ -0,0 +1,655 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	raw "google.golang.org/api/storage/v1"
+)
+
+// maxComposeComponents is the maximum number of source objects in a single
+// compose request.
+const maxComposeComponents = 32
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+type object struct {
+	// attrs holds the object resource, without its ACL. TimeDeleted is set
+	// if the generation is noncurrent.
+	attrs *raw.Object
+	acl   []aclRule
+	// data holds the object contents. It is never modified after the object
+	// is created.
+	data []byte
+}
+
+func (o *object) live() bool {
+	return o.attrs.TimeDeleted == ""
+}
+
+// rawObject returns a copy of the object resource including its ACL.
+func (o *object) rawObject() *raw.Object {
+	ro := *o.attrs
+	ro.Acl = objectACLItems(ro.Bucket, ro.Name, ro.Generation, o.acl)
+	return &ro
+}
+
+// touch records a change to the object's metadata. s.mu must be held.
+func (s *Server) touchObject(o *object) {
+	o.attrs.Metageneration++
+	o.attrs.Updated = s.now()
+	o.attrs.Etag = objectEtag(o.attrs.Generation, o.attrs.Metageneration)
+}
+
+// liveObject returns the live generation of the named object, or nil.
+func (b *bucket) liveObject(name string) *object {
+	gens := b.objects[name]
+	if len(gens) == 0 || !gens[len(gens)-1].live() {
+		return nil
+	}
+	return gens[len(gens)-1]
+}
+
+// objectGeneration returns the given generation of the named object, live or
+// noncurrent, or nil.
+func (b *bucket) objectGeneration(name string, gen int64) *object {
+	for _, o := range b.objects[name] {
+		if o.attrs.Generation == gen {
+			return o
+		}
+	}
+	return nil
+}
+
+// removeGeneration permanently deletes a generation of an object.
+func (b *bucket) removeGeneration(o *object) {
+	gens := b.objects[o.attrs.Name]
+	for i, g := range gens {
+		if g == o {
+			gens = append(gens[:i:i], gens[i+1:]...)
+			break
+		}
+	}
+	if len(gens) == 0 {
+		delete(b.objects, o.attrs.Name)
+	} else {
+		b.objects[o.attrs.Name] = gens
+	}
+}
+
+// conditions holds the generation and metageneration preconditions of a
+// request. Match conditions are pointers so that a zero generation, meaning
+// that the object must not exist, can be distinguished from no condition.
+type conditions struct {
+	generation             *int64
+	generationMatch        *int64
+	generationNotMatch     *int64
+	metagenerationMatch    *int64
+	metagenerationNotMatch *int64
+}
+
+// parseConditions reads the preconditions from the query parameters named
+// "generation", "ifGenerationMatch" and so on. If source is true the
+// parameters for the source of a rewrite, such as "sourceGeneration" and
+// "ifSourceGenerationMatch", are read instead.
+func parseConditions(params url.Values, source bool) (*conditions, error) {
+	name := func(cond string) string {
+		if !source {
+			return cond
+		}
+		if cond == "generation" {
+			return "sourceGeneration"
+		}
+		return "ifSource" + strings.TrimPrefix(cond, "if")
+	}
+	c := &conditions{}
+	for _, f := range []struct {
+		param string
+		dst   **int64
+	}{
+		{"generation", &c.generation},
+		{"ifGenerationMatch", &c.generationMatch},
+		{"ifGenerationNotMatch", &c.generationNotMatch},
+		{"ifMetagenerationMatch", &c.metagenerationMatch},
+		{"ifMetagenerationNotMatch", &c.metagenerationNotMatch},
+	} {
+		n, ok, err := int64Param(params, name(f.param))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			v := n
+			*f.dst = &v
+		}
+	}
+	return c, nil
+}
+
+// headerConditions reads the preconditions from the headers of an XML API
+// download request.
+func headerConditions(r *http.Request) (*conditions, error) {
+	params := url.Values{}
+	if gen := r.URL.Query().Get("generation"); gen != "" {
+		params.Set("generation", gen)
+	}
+	for header, param := range map[string]string{
+		"X-Goog-If-Generation-Match":     "ifGenerationMatch",
+		"X-Goog-If-Metageneration-Match": "ifMetagenerationMatch",
+	} {
+		if v := r.Header.Get(header); v != "" {
+			params.Set(param, v)
+		}
+	}
+	return parseConditions(params, false)
+}
+
+// check verifies the match conditions against o, which is nil if the object
+// does not exist. Unsatisfied not-match conditions of a read are reported as
+// 304 Not Modified, as in the real service.
+func (c *conditions) check(o *object, read bool) error {
+	failed := func(format string, args ...interface{}) error {
+		return errorf(http.StatusPreconditionFailed, "Precondition Failed: "+format, args...)
+	}
+	if c.generationMatch != nil {
+		switch {
+		case o == nil && *c.generationMatch != 0:
+			return failed("object does not exist")
+		case o != nil && o.attrs.Generation != *c.generationMatch:
+			return failed("generation is %d, not %d", o.attrs.Generation, *c.generationMatch)
+		}
+	}
+	if o == nil {
+		if c.metagenerationMatch != nil {
+			return failed("object does not exist")
+		}
+		return nil
+	}
+	if c.metagenerationMatch != nil && o.attrs.Metageneration != *c.metagenerationMatch {
+		return failed("metageneration is %d, not %d", o.attrs.Metageneration, *c.metagenerationMatch)
+	}
+	notModified := failed
+	if read {
+		notModified = func(format string, args ...interface{}) error {
+			return errorf(http.StatusNotModified, format, args...)
+		}
+	}
+	if c.generationNotMatch != nil && o.attrs.Generation == *c.generationNotMatch {
+		return notModified("generation is %d", o.attrs.Generation)
+	}
+	if c.metagenerationNotMatch != nil && o.attrs.Metageneration == *c.metagenerationNotMatch {
+		return notModified("metageneration is %d", o.attrs.Metageneration)
+	}
+	return nil
+}
+
+// lookupObject returns the object addressed by a request after checking its
+// preconditions. If the request has a generation parameter that generation is
+// returned, otherwise the live generation. s.mu must be held.
+func (s *Server) lookupObject(bucketName, name string, conds *conditions, read bool) (*object, error) {
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, errBucketNotFound(bucketName)
+	}
+	var o *object
+	if conds.generation != nil {
+		o = b.objectGeneration(name, *conds.generation)
+	} else {
+		o = b.liveObject(name)
+	}
+	if o == nil {
+		return nil, errObjectNotFound(bucketName, name)
+	}
+	if err := conds.check(o, read); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (s *Server) getObject(r *http.Request, bucketName, name string) (interface{}, error) {
+	conds, err := parseConditions(r.URL.Query(), false)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.lookupObject(bucketName, name, conds, true)
+	if err != nil {
+		return nil, err
+	}
+	return o.rawObject(), nil
+}
+
+func (s *Server) patchObject(r *http.Request, bucketName, name string) (interface{}, error) {
+	conds, err := parseConditions(r.URL.Query(), false)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.lookupObject(bucketName, name, conds, false)
+	if err != nil {
+		return nil, err
+	}
+	var ro raw.Object
+	if err := mergePatch(o.rawObject(), patch, &ro); err != nil {
+		return nil, err
+	}
+	o.acl = objectACLRules(ro.Acl)
+	ro.Acl = nil
+	restoreObjectFields(&ro, o.attrs)
+	o.attrs = &ro
+	s.touchObject(o)
+	return o.rawObject(), nil
+}
+
+// restoreObjectFields copies the fields of old that cannot be changed by a
+// metadata update to ro.
+func restoreObjectFields(ro, old *raw.Object) {
+	ro.Kind = old.Kind
+	ro.Id = old.Id
+	ro.Bucket = old.Bucket
+	ro.Name = old.Name
+	ro.SelfLink = old.SelfLink
+	ro.MediaLink = old.MediaLink
+	ro.Generation = old.Generation
+	ro.Metageneration = old.Metageneration
+	ro.Size = old.Size
+	ro.Md5Hash = old.Md5Hash
+	ro.Crc32c = old.Crc32c
+	ro.ComponentCount = old.ComponentCount
+	ro.StorageClass = old.StorageClass
+	ro.TimeCreated = old.TimeCreated
+	ro.TimeDeleted = old.TimeDeleted
+	ro.TimeStorageClassUpdated = old.TimeStorageClassUpdated
+}
+
+func (s *Server) deleteObject(r *http.Request, bucketName, name string) error {
+	conds, err := parseConditions(r.URL.Query(), false)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.lookupObject(bucketName, name, conds, false)
+	if err != nil {
+		return err
+	}
+	b := s.buckets[bucketName]
+	if conds.generation == nil && b.versioningEnabled() {
+		// Deleting the live generation of a versioned object makes it
+		// noncurrent.
+		o.attrs.TimeDeleted = s.now()
+		return nil
+	}
+	b.removeGeneration(o)
+	return nil
+}
+
+func (s *Server) listObjects(r *http.Request, bucketName string) (interface{}, error) {
+	params := r.URL.Query()
+	prefix := params.Get("prefix")
+	delim := params.Get("delimiter")
+	startOffset := params.Get("startOffset")
+	endOffset := params.Get("endOffset")
+	versions, _ := strconv.ParseBool(params.Get("versions"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, errBucketNotFound(bucketName)
+	}
+	// Results are objects and prefixes, ordered by name and then generation.
+	type result struct {
+		name   string
+		obj    *object
+		prefix bool
+	}
+	var results []result
+	seenPrefixes := map[string]bool{}
+	for name, gens := range b.objects {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if (startOffset != "" && name < startOffset) || (endOffset != "" && name >= endOffset) {
+			continue
+		}
+		var listed []*object
+		for _, o := range gens {
+			if versions || o.live() {
+				listed = append(listed, o)
+			}
+		}
+		if len(listed) == 0 {
+			continue
+		}
+		if delim != "" {
+			if i := strings.Index(name[len(prefix):], delim); i >= 0 {
+				p := name[:len(prefix)+i+len(delim)]
+				if !seenPrefixes[p] {
+					seenPrefixes[p] = true
+					results = append(results, result{name: p, prefix: true})
+				}
+				continue
+			}
+		}
+		for _, o := range listed {
+			results = append(results, result{name: name, obj: o})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		ri, rj := results[i], results[j]
+		if ri.name != rj.name {
+			return ri.name < rj.name
+		}
+		if ri.prefix != rj.prefix {
+			return ri.prefix
+		}
+		return !ri.prefix && ri.obj.attrs.Generation < rj.obj.attrs.Generation
+	})
+
+	start, end, next, err := pageBounds(params, len(results))
+	if err != nil {
+		return nil, err
+	}
+	resp := &raw.Objects{
+		Kind:          "storage#objects",
+		Items:         []*raw.Object{},
+		NextPageToken: next,
+	}
+	for _, res := range results[start:end] {
+		if res.prefix {
+			resp.Prefixes = append(resp.Prefixes, res.name)
+		} else {
+			resp.Items = append(resp.Items, res.obj.rawObject())
+		}
+	}
+	return resp, nil
+}
+
+// insertObject creates a new generation of an object with the given metadata
+// and contents, after checking the preconditions. If the metadata includes a
+// CRC32C or MD5 hash, it must match the contents. s.mu must be held.
+func (s *Server) insertObject(bucketName string, attrs *raw.Object, data []byte, conds *conditions) (*object, error) {
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, errBucketNotFound(bucketName)
+	}
+	if attrs.Name == "" {
+		return nil, errorf(http.StatusBadRequest, "object name is required")
+	}
+	if !utf8.ValidString(attrs.Name) {
+		return nil, errorf(http.StatusBadRequest, "object name %q is not valid UTF-8", attrs.Name)
+	}
+	live := b.liveObject(attrs.Name)
+	if err := conds.check(live, false); err != nil {
+		return nil, err
+	}
+	crc := encodeCRC32C(crc32.Checksum(data, crc32cTable))
+	if attrs.Crc32c != "" && attrs.Crc32c != crc {
+		return nil, errorf(http.StatusBadRequest, "Provided CRC32C %q doesn't match calculated CRC32C %q.", attrs.Crc32c, crc)
+	}
+	sum := md5.Sum(data)
+	md5Hash := base64.StdEncoding.EncodeToString(sum[:])
+	if attrs.Md5Hash != "" && attrs.Md5Hash != md5Hash {
+		return nil, errorf(http.StatusBadRequest, "Provided MD5 hash %q doesn't match calculated MD5 hash %q.", attrs.Md5Hash, md5Hash)
+	}
+
+	ro := *attrs
+	acl := objectACLRules(ro.Acl)
+	if ro.Acl == nil {
+		acl = append([]aclRule(nil), b.defaultObjectACL...)
+	}
+	ro.Acl = nil
+	ro.Kind = "storage#object"
+	ro.Bucket = bucketName
+	ro.Generation = s.nextGeneration()
+	ro.Metageneration = 1
+	ro.Etag = objectEtag(ro.Generation, ro.Metageneration)
+	ro.Id = fmt.Sprintf("%s/%s/%d", bucketName, ro.Name, ro.Generation)
+	ro.SelfLink = s.Endpoint() + "b/" + url.PathEscape(bucketName) + "/o/" + url.PathEscape(ro.Name)
+	ro.MediaLink = s.URL() + "/download/storage/v1/b/" + url.PathEscape(bucketName) + "/o/" + url.PathEscape(ro.Name) +
+		"?generation=" + strconv.FormatInt(ro.Generation, 10) + "&alt=media"
+	ro.Size = uint64(len(data))
+	ro.Crc32c = crc
+	if ro.ComponentCount == 0 {
+		ro.Md5Hash = md5Hash
+	} else {
+		// Composite objects have no MD5 hash.
+		ro.Md5Hash = ""
+	}
+	if ro.ContentType == "" {
+		ro.ContentType = "application/octet-stream"
+	}
+	if ro.StorageClass == "" {
+		ro.StorageClass = b.attrs.StorageClass
+	}
+	ro.TimeCreated = s.now()
+	ro.Updated = ro.TimeCreated
+	ro.TimeStorageClassUpdated = ro.TimeCreated
+	ro.TimeDeleted = ""
+
+	if live != nil {
+		if b.versioningEnabled() {
+			live.attrs.TimeDeleted = ro.TimeCreated
+		} else {
+			b.removeGeneration(live)
+		}
+	}
+	o := &object{attrs: &ro, acl: acl, data: data}
+	b.objects[ro.Name] = append(b.objects[ro.Name], o)
+	return o, nil
+}
+
+func (s *Server) composeObject(r *http.Request, bucketName, name string) (interface{}, error) {
+	conds, err := parseConditions(r.URL.Query(), false)
+	if err != nil {
+		return nil, err
+	}
+	var req raw.ComposeRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	if len(req.SourceObjects) == 0 {
+		return nil, errorf(http.StatusBadRequest, "at least one source object is required")
+	}
+	if len(req.SourceObjects) > maxComposeComponents {
+		return nil, errorf(http.StatusBadRequest, "The number of source components provided (%d) exceeds the maximum (%d)",
+			len(req.SourceObjects), maxComposeComponents)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var buf bytes.Buffer
+	var componentCount int64
+	for _, src := range req.SourceObjects {
+		srcConds := &conditions{}
+		if src.Generation != 0 {
+			gen := src.Generation
+			srcConds.generation = &gen
+		}
+		if src.ObjectPreconditions != nil && src.ObjectPreconditions.IfGenerationMatch != 0 {
+			gen := src.ObjectPreconditions.IfGenerationMatch
+			srcConds.generationMatch = &gen
+		}
+		o, err := s.lookupObject(bucketName, src.Name, srcConds, false)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(o.data)
+		if o.attrs.ComponentCount > 0 {
+			componentCount += o.attrs.ComponentCount
+		} else {
+			componentCount++
+		}
+	}
+	dst := req.Destination
+	if dst == nil {
+		dst = &raw.Object{}
+	}
+	dst.Name = name
+	dst.ComponentCount = componentCount
+	// Hashes of the destination are computed by the service.
+	dst.Crc32c = ""
+	dst.Md5Hash = ""
+	o, err := s.insertObject(bucketName, dst, buf.Bytes(), conds)
+	if err != nil {
+		return nil, err
+	}
+	return o.rawObject(), nil
+}
+
+// rewriteObject copies an object. If the request sets maxBytesRewrittenPerCall
+// and the object is larger, the rewrite takes several calls, each returning a
+// token that records the progress so far.
+func (s *Server) rewriteObject(r *http.Request, srcBucket, srcName, dstBucket, dstName string) (interface{}, error) {
+	params := r.URL.Query()
+	srcConds, err := parseConditions(params, true)
+	if err != nil {
+		return nil, err
+	}
+	dstConds, err := parseConditions(params, false)
+	if err != nil {
+		return nil, err
+	}
+	maxBytes, _, err := int64Param(params, "maxBytesRewrittenPerCall")
+	if err != nil {
+		return nil, err
+	}
+	var rewritten int64
+	if tok := params.Get("rewriteToken"); tok != "" {
+		rewritten, err = strconv.ParseInt(tok, 10, 64)
+		if err != nil || rewritten < 0 {
+			return nil, errorf(http.StatusBadRequest, "invalid rewrite token %q", tok)
+		}
+	}
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, err := s.lookupObject(srcBucket, srcName, srcConds, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.buckets[dstBucket]; !ok {
+		return nil, errBucketNotFound(dstBucket)
+	}
+	size := int64(len(src.data))
+	if rewritten > size {
+		return nil, errorf(http.StatusBadRequest, "invalid rewrite token %q", params.Get("rewriteToken"))
+	}
+	if maxBytes > 0 && size-rewritten > maxBytes {
+		rewritten += maxBytes
+		return &raw.RewriteResponse{
+			Kind:                "storage#rewriteResponse",
+			ObjectSize:          size,
+			TotalBytesRewritten: rewritten,
+			RewriteToken:        strconv.FormatInt(rewritten, 10),
+		}, nil
+	}
+
+	// The destination has the metadata of the source, overridden by any
+	// metadata in the request.
+	srcAttrs := src.rawObject()
+	srcAttrs.Acl = nil
+	var dst raw.Object
+	if len(bytes.TrimSpace(patch)) == 0 {
+		patch = []byte("{}")
+	}
+	if err := mergePatch(srcAttrs, patch, &dst); err != nil {
+		return nil, err
+	}
+	dst.Name = dstName
+	if dst.Crc32c == src.attrs.Crc32c {
+		dst.Crc32c = ""
+	}
+	if dst.Md5Hash == src.attrs.Md5Hash {
+		dst.Md5Hash = ""
+	}
+	dst.ComponentCount = src.attrs.ComponentCount
+	o, err := s.insertObject(dstBucket, &dst, src.data, dstConds)
+	if err != nil {
+		return nil, err
+	}
+	return &raw.RewriteResponse{
+		Kind:                "storage#rewriteResponse",
+		Done:                true,
+		ObjectSize:          size,
+		TotalBytesRewritten: size,
+		Resource:            o.rawObject(),
+	}, nil
+}
+
+func (s *Server) handleObjectACL(r *http.Request, bucketName, name string, entitySegs []string) (interface{}, error) {
+	conds, err := parseConditions(r.URL.Query(), false)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.lookupObject(bucketName, name, conds, false)
+	if err != nil {
+		return nil, err
+	}
+	gen := o.attrs.Generation
+	return s.handleACL(r, aclList{
+		rules: &o.acl,
+		item: func(rule aclRule) interface{} {
+			return objectACLItems(bucketName, name, gen, []aclRule{rule})[0]
+		},
+		items: func(rules []aclRule) interface{} {
+			return &raw.ObjectAccessControls{
+				Kind:  "storage#objectAccessControls",
+				Items: objectACLItems(bucketName, name, gen, rules),
+			}
+		},
+		modified: func() { s.touchObject(o) },
+	}, entitySegs)
+}
+
+// encodeCRC32C returns the base64 encoding of a big-endian CRC32C checksum, as
+// used by the JSON API.
+func encodeCRC32C(crc uint32) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, crc)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// objectEtag returns an entity tag for an object generation and
+// metageneration.
+func objectEtag(generation, metageneration int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%d/%d", generation, metageneration)))
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"cloud.google.com/go/storage"
+)
+
+// readBufferSize is the size of the buffer each part is read into before it is
+// written to the destination.
+const readBufferSize = 1 << 20
+
+// DownloadOptions configures Download and DownloadFile.
+type DownloadOptions struct {
+	// PartSize is the size in bytes of the ranges the object is downloaded in.
+	// If zero, DefaultPartSize is used.
+	PartSize int64
+
+	// Concurrency is the maximum number of ranges downloaded at the same time.
+	// If zero, DefaultConcurrency is used.
+	Concurrency int
+
+	// DisableChecksum disables verification of the CRC32C checksum of the
+	// downloaded object.
+	DisableChecksum bool
+}
+
+func (opts *DownloadOptions) partSize() int64 {
+	if opts == nil || opts.PartSize <= 0 {
+		return DefaultPartSize
+	}
+	return opts.PartSize
+}
+
+func (opts *DownloadOptions) concurrency() int {
+	if opts == nil || opts.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+	return opts.Concurrency
+}
+
+// Download downloads the object o into w, reading concurrent ranges of the
+// object and writing each at its offset in w. It returns the attributes of the
+// downloaded object.
+//
+// The generation of the object is read first and all ranges are read from that
+// generation, so a concurrent overwrite of the object does not corrupt the
+// download. Objects are downloaded as stored, without decompressive
+// transcoding. Unless opts.DisableChecksum is set, Download verifies the
+// CRC32C checksum of the whole object once all ranges have been written.
+//
+// If Download returns an error, some ranges may have been written to w.
+func Download(ctx context.Context, o *storage.ObjectHandle, w io.WriterAt, opts *DownloadOptions) (*storage.ObjectAttrs, error) {
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o = o.Generation(attrs.Generation).ReadCompressed(true)
+
+	offsets, lengths := partBounds(attrs.Size, opts.partSize())
+	crcs := make([]uint32, len(offsets))
+	err = runParallel(ctx, len(offsets), opts.concurrency(), func(ctx context.Context, i int) error {
+		crc, err := downloadRange(ctx, o, w, offsets[i], lengths[i])
+		if err != nil {
+			return err
+		}
+		crcs[i] = crc
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil || !opts.DisableChecksum {
+		var crc uint32
+		for i := range crcs {
+			crc = crc32cCombine(crc, crcs[i], lengths[i])
+		}
+		if crc != attrs.CRC32C {
+			return nil, fmt.Errorf("transfermanager: downloaded %s/%s has CRC32C %d, want %d",
+				attrs.Bucket, attrs.Name, crc, attrs.CRC32C)
+		}
+	}
+	return attrs, nil
+}
+
+// downloadRange copies the given range of o to the same offset in w, and
+// returns the CRC32C checksum of the range.
+func downloadRange(ctx context.Context, o *storage.ObjectHandle, w io.WriterAt, offset, length int64) (uint32, error) {
+	r, err := o.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	var crc uint32
+	buf := make([]byte, readBufferSize)
+	var read int64
+	for read < length {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if read+int64(n) > length {
+				return 0, fmt.Errorf("transfermanager: range at offset %d returned more than %d bytes", offset, length)
+			}
+			if _, werr := w.WriteAt(buf[:n], offset+read); werr != nil {
+				return 0, werr
+			}
+			crc = crc32.Update(crc, crc32cTable, buf[:n])
+			read += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if read != length {
+		return 0, fmt.Errorf("transfermanager: range at offset %d returned %d bytes, want %d", offset, read, length)
+	}
+	return crc, nil
+}
+
+// DownloadFile downloads the object o into the named file, which is created or
+// truncated. See Download for details. If DownloadFile returns an error, the
+// file is removed.
+func DownloadFile(ctx context.Context, o *storage.ObjectHandle, name string, opts *DownloadOptions) (attrs *storage.ObjectAttrs, err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			attrs = nil
+			os.Remove(name)
+		}
+	}()
+	return Download(ctx, o, f, opts)
+}
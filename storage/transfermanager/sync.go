@@ -0,0 +1,530 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// MtimeMetadataKey is the key of the object metadata entry in which the
+// modification time of a synced file is stored, in seconds since the Unix
+// epoch. It is the key used by gsutil rsync.
+const MtimeMetadataKey = "goog-reserved-file-mtime"
+
+// SyncAction is the action taken to sync a file.
+type SyncAction int
+
+const (
+	// SyncSkip means that the file was already up to date.
+	SyncSkip SyncAction = iota
+
+	// SyncUpload means that the local file was uploaded to the bucket.
+	SyncUpload
+
+	// SyncDownload means that the object was downloaded to the local file.
+	SyncDownload
+
+	// SyncDelete means that the file was deleted from the destination because
+	// it does not exist in the source.
+	SyncDelete
+)
+
+func (a SyncAction) String() string {
+	switch a {
+	case SyncSkip:
+		return "skip"
+	case SyncUpload:
+		return "upload"
+	case SyncDownload:
+		return "download"
+	case SyncDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("SyncAction(%d)", int(a))
+	}
+}
+
+// SyncResult reports how a single file was synced.
+type SyncResult struct {
+	// Path is the slash-separated path of the file relative to the local
+	// directory and the bucket prefix.
+	Path string
+
+	// Action is the action taken, or that would have been taken in a dry run.
+	Action SyncAction
+
+	// Err is the error that occurred while comparing or transferring the
+	// file, if any. If Err is not nil, the action may not have completed.
+	Err error
+}
+
+// SyncOptions configures SyncToBucket and SyncFromBucket.
+type SyncOptions struct {
+	// DryRun reports the actions that would be taken without taking them.
+	DryRun bool
+
+	// Delete deletes files at the destination that do not exist in the
+	// source, so that the destination converges to a copy of the source.
+	Delete bool
+
+	// Exclude holds patterns, in the syntax of path.Match, of the files to
+	// ignore in both the source and the destination. A pattern is matched
+	// against the slash-separated path of each file relative to the directory
+	// or prefix, and against the paths of its parent directories, so that
+	// matching a directory excludes all of its contents.
+	Exclude []string
+
+	// CompareMtime skips files whose size and modification time are the same
+	// in the source and the destination without comparing their checksums.
+	// The modification time of an object is read from the MtimeMetadataKey
+	// entry of its metadata. Files whose modification times differ, or that
+	// have no recorded modification time, are compared by checksum.
+	CompareMtime bool
+
+	// Concurrency is the maximum number of files compared or transferred at
+	// the same time. If zero, DefaultConcurrency is used.
+	Concurrency int
+}
+
+func (opts *SyncOptions) concurrency() int {
+	if opts == nil || opts.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+	return opts.Concurrency
+}
+
+// excluded reports whether rel or one of its parent directories matches an
+// exclude pattern.
+func (opts *SyncOptions) excluded(rel string) bool {
+	if opts == nil {
+		return false
+	}
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		for _, pattern := range opts.Exclude {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (opts *SyncOptions) validate() error {
+	if opts == nil {
+		return nil
+	}
+	for _, pattern := range opts.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("transfermanager: bad exclude pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// localFile describes a regular file in the local directory.
+type localFile struct {
+	name  string // path in the local file system
+	size  int64
+	mtime time.Time
+}
+
+// syncState holds the source and destination files of a sync, keyed by
+// relative path.
+type syncState struct {
+	dir     string
+	bucket  *storage.BucketHandle
+	prefix  string
+	opts    *SyncOptions
+	local   map[string]*localFile
+	objects map[string]*storage.ObjectAttrs
+}
+
+// SyncToBucket makes the objects under prefix in bucket b a copy of the files
+// in the local directory dir, like gsutil rsync. Each regular file in the tree
+// rooted at dir is uploaded to the object named prefix followed by its
+// slash-separated relative path, unless the object has the same size and
+// checksums (CRC32C, and MD5 if the object has one). If opts.Delete is set,
+// objects under prefix with no corresponding file are deleted. Symbolic links
+// and other non-regular files are not synced.
+//
+// The modification time of each uploaded file is stored in the object's
+// metadata under MtimeMetadataKey. Uploads are conditional on the generation
+// of the object that was compared, so an object changed concurrently is not
+// overwritten.
+//
+// SyncToBucket returns a result for each file that was not excluded and each
+// object that was deleted, sorted by path. The returned error is not nil if
+// the directory or bucket could not be listed, or if any file could not be
+// synced.
+func SyncToBucket(ctx context.Context, dir string, b *storage.BucketHandle, prefix string, opts *SyncOptions) ([]SyncResult, error) {
+	s, err := newSyncState(ctx, dir, b, prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []func(context.Context) SyncResult
+	for rel, f := range s.local {
+		rel, f := rel, f
+		tasks = append(tasks, func(ctx context.Context) SyncResult {
+			return s.upload(ctx, rel, f, s.objects[rel])
+		})
+	}
+	if opts != nil && opts.Delete {
+		for rel, attrs := range s.objects {
+			if s.local[rel] == nil {
+				rel, attrs := rel, attrs
+				tasks = append(tasks, func(ctx context.Context) SyncResult {
+					return s.deleteObject(ctx, rel, attrs)
+				})
+			}
+		}
+	}
+	return runSync(ctx, tasks, opts)
+}
+
+// SyncFromBucket makes the local directory dir a copy of the objects under
+// prefix in bucket b, like gsutil rsync. It is the reverse of SyncToBucket:
+// objects are downloaded unless the local file has the same size and
+// checksums, and if opts.Delete is set, local files with no corresponding
+// object are deleted. Objects whose names relative to prefix are not valid
+// slash-separated paths, such as names containing "//" or "..", are skipped.
+//
+// Objects are downloaded to a temporary file in the destination directory,
+// which is renamed once the download has been verified. If the object's
+// metadata records a modification time under MtimeMetadataKey, it is set on
+// the local file.
+//
+// SyncFromBucket returns a result for each object that was not excluded and
+// each file that was deleted, sorted by path. The returned error is not nil if
+// the directory or bucket could not be listed, or if any file could not be
+// synced.
+func SyncFromBucket(ctx context.Context, b *storage.BucketHandle, prefix, dir string, opts *SyncOptions) ([]SyncResult, error) {
+	s, err := newSyncState(ctx, dir, b, prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []func(context.Context) SyncResult
+	for rel, attrs := range s.objects {
+		rel, attrs := rel, attrs
+		tasks = append(tasks, func(ctx context.Context) SyncResult {
+			return s.download(ctx, rel, attrs, s.local[rel])
+		})
+	}
+	if opts != nil && opts.Delete {
+		for rel, f := range s.local {
+			if s.objects[rel] == nil {
+				rel, f := rel, f
+				tasks = append(tasks, func(context.Context) SyncResult {
+					return s.deleteFile(rel, f)
+				})
+			}
+		}
+	}
+	return runSync(ctx, tasks, opts)
+}
+
+// runSync runs the tasks with the concurrency of opts and returns their
+// results sorted by path, and an error if any task failed.
+func runSync(ctx context.Context, tasks []func(context.Context) SyncResult, opts *SyncOptions) ([]SyncResult, error) {
+	results := make([]SyncResult, len(tasks))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.concurrency())
+	for i, task := range tasks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, task func(context.Context) SyncResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = task(ctx)
+		}(i, task)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+
+	var failed int
+	var firstErr error
+	for _, r := range results {
+		if r.Err != nil {
+			if firstErr == nil {
+				firstErr = r.Err
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("transfermanager: %d of %d files failed to sync, first error: %v", failed, len(results), firstErr)
+	}
+	return results, nil
+}
+
+func newSyncState(ctx context.Context, dir string, b *storage.BucketHandle, prefix string, opts *SyncOptions) (*syncState, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	s := &syncState{
+		dir:     dir,
+		bucket:  b,
+		prefix:  prefix,
+		opts:    opts,
+		local:   map[string]*localFile{},
+		objects: map[string]*storage.ObjectAttrs{},
+	}
+	if err := s.listLocal(); err != nil {
+		return nil, err
+	}
+	if err := s.listObjects(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// listLocal records the regular files under s.dir. A missing directory has no
+// files.
+func (s *syncState) listLocal() error {
+	err := filepath.Walk(s.dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if name == s.dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && s.opts.excluded(rel) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() {
+			s.local[rel] = &localFile{name: name, size: fi.Size(), mtime: fi.ModTime()}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("transfermanager: listing %s: %v", s.dir, err)
+	}
+	return nil
+}
+
+// listObjects records the live objects under s.prefix.
+func (s *syncState) listObjects(ctx context.Context) error {
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: s.prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(attrs.Name, s.prefix)
+		if !validRelPath(rel) || s.opts.excluded(rel) {
+			continue
+		}
+		s.objects[rel] = attrs
+	}
+}
+
+// validRelPath reports whether rel is a slash-separated relative path of a
+// file, with no empty, "." or ".." elements.
+func validRelPath(rel string) bool {
+	if rel == "" {
+		return false
+	}
+	for _, elem := range strings.Split(rel, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// upToDate reports whether the local file f and the object described by attrs
+// have the same contents.
+func (s *syncState) upToDate(f *localFile, attrs *storage.ObjectAttrs) (bool, error) {
+	if f == nil || attrs == nil || f.size != attrs.Size {
+		return false, nil
+	}
+	if s.opts != nil && s.opts.CompareMtime {
+		if mtime, ok := objectMtime(attrs); ok && mtime.Equal(f.mtime.Truncate(time.Second)) {
+			return true, nil
+		}
+	}
+	file, err := os.Open(f.name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	crc := crc32.New(crc32cTable)
+	md := md5.New()
+	if _, err := io.Copy(io.MultiWriter(crc, md), file); err != nil {
+		return false, err
+	}
+	if crc.Sum32() != attrs.CRC32C {
+		return false, nil
+	}
+	return len(attrs.MD5) == 0 || bytes.Equal(md.Sum(nil), attrs.MD5), nil
+}
+
+// objectMtime returns the modification time recorded in the metadata of an
+// object, if any.
+func objectMtime(attrs *storage.ObjectAttrs) (time.Time, bool) {
+	v, ok := attrs.Metadata[MtimeMetadataKey]
+	if !ok {
+		return time.Time{}, false
+	}
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
+
+// upload uploads the local file f to the object for rel, unless the object
+// described by attrs, which is nil if there is none, is up to date.
+func (s *syncState) upload(ctx context.Context, rel string, f *localFile, attrs *storage.ObjectAttrs) SyncResult {
+	res := SyncResult{Path: rel, Action: SyncSkip}
+	ok, err := s.upToDate(f, attrs)
+	if err != nil || ok {
+		res.Err = err
+		return res
+	}
+	res.Action = SyncUpload
+	if s.opts != nil && s.opts.DryRun {
+		return res
+	}
+
+	file, err := os.Open(f.name)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer file.Close()
+	o := s.bucket.Object(s.prefix + rel)
+	if attrs != nil {
+		o = o.If(storage.Conditions{GenerationMatch: attrs.Generation})
+	} else {
+		o = o.If(storage.Conditions{DoesNotExist: true})
+	}
+	// Keep the content type and metadata of the object being replaced.
+	uopts := &UploadOptions{}
+	uopts.ObjectAttrs.Metadata = map[string]string{}
+	if attrs != nil {
+		uopts.ObjectAttrs.ContentType = attrs.ContentType
+		for k, v := range attrs.Metadata {
+			uopts.ObjectAttrs.Metadata[k] = v
+		}
+	}
+	uopts.ObjectAttrs.Metadata[MtimeMetadataKey] = strconv.FormatInt(f.mtime.Unix(), 10)
+	_, _, res.Err = uploadPart(ctx, o, file, uopts)
+	return res
+}
+
+// download downloads the object described by attrs to the local file for rel,
+// unless the local file f, which is nil if there is none, is up to date.
+func (s *syncState) download(ctx context.Context, rel string, attrs *storage.ObjectAttrs, f *localFile) SyncResult {
+	res := SyncResult{Path: rel, Action: SyncSkip}
+	ok, err := s.upToDate(f, attrs)
+	if err != nil || ok {
+		res.Err = err
+		return res
+	}
+	res.Action = SyncDownload
+	if s.opts != nil && s.opts.DryRun {
+		return res
+	}
+	res.Err = s.downloadFile(ctx, attrs, filepath.Join(s.dir, filepath.FromSlash(rel)))
+	return res
+}
+
+// downloadFile downloads the object described by attrs to a temporary file
+// and renames it to name.
+func (s *syncState) downloadFile(ctx context.Context, attrs *storage.ObjectAttrs, name string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), name)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	o := s.bucket.Object(attrs.Name).Generation(attrs.Generation)
+	if _, err := Download(ctx, o, tmp, &DownloadOptions{Concurrency: 1}); err != nil {
+		return err
+	}
+	if mtime, ok := objectMtime(attrs); ok {
+		return os.Chtimes(tmp.Name(), mtime, mtime)
+	}
+	return nil
+}
+
+// deleteObject deletes the generation of the object for rel described by
+// attrs.
+func (s *syncState) deleteObject(ctx context.Context, rel string, attrs *storage.ObjectAttrs) SyncResult {
+	res := SyncResult{Path: rel, Action: SyncDelete}
+	if s.opts.DryRun {
+		return res
+	}
+	err := s.bucket.Object(attrs.Name).If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+	if err != nil && err != storage.ErrObjectNotExist {
+		res.Err = err
+	}
+	return res
+}
+
+// deleteFile deletes the local file for rel.
+func (s *syncState) deleteFile(rel string, f *localFile) SyncResult {
+	res := SyncResult{Path: rel, Action: SyncDelete}
+	if s.opts.DryRun {
+		return res
+	}
+	if err := os.Remove(f.name); err != nil && !os.IsNotExist(err) {
+		res.Err = err
+	}
+	return res
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, contents := range files {
+		name := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeTestObject(ctx context.Context, t *testing.T, b *storage.BucketHandle, name, contents string) {
+	t.Helper()
+	w := b.Object(name).NewWriter(ctx)
+	w.Write([]byte(contents))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// actions returns the action of each result by path, and fails the test if
+// any result has an error.
+func actions(t *testing.T, results []SyncResult) map[string]SyncAction {
+	t.Helper()
+	got := map[string]SyncAction{}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Path, r.Err)
+		}
+		got[r.Path] = r.Action
+	}
+	return got
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	b, cleanup := newTestBucket(ctx, t)
+	defer cleanup()
+
+	src, err := ioutil.TempDir("", "transfermanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	writeFiles(t, src, map[string]string{
+		"a.txt":        "same",
+		"sub/b.txt":    "new!",
+		"sub/c.txt":    "created",
+		"skip.log":     "excluded",
+		"excluded/d":   "excluded",
+		"sub/excluded": "not excluded by a pattern for a top-level directory",
+	})
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "sub", "b.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	writeTestObject(ctx, t, b, "pre/a.txt", "same")
+	writeTestObject(ctx, t, b, "pre/sub/b.txt", "old!")
+	writeTestObject(ctx, t, b, "pre/stale", "deleted")
+	writeTestObject(ctx, t, b, "pre/skip.log", "kept")
+	writeTestObject(ctx, t, b, "other", "outside the prefix")
+
+	opts := &SyncOptions{
+		Delete:  true,
+		DryRun:  true,
+		Exclude: []string{"*.log", "excluded"},
+	}
+	want := map[string]SyncAction{
+		"a.txt":        SyncSkip,
+		"sub/b.txt":    SyncUpload,
+		"sub/c.txt":    SyncUpload,
+		"sub/excluded": SyncUpload,
+		"stale":        SyncDelete,
+	}
+	results, err := SyncToBucket(ctx, src, b, "pre", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(actions(t, results), want); diff != "" {
+		t.Errorf("dry run: got=-, want=+:\n%s", diff)
+	}
+	if diff := cmp.Diff(objectNames(ctx, t, b), []string{"other", "pre/a.txt", "pre/skip.log", "pre/stale", "pre/sub/b.txt"}); diff != "" {
+		t.Errorf("dry run changed objects: got=-, want=+:\n%s", diff)
+	}
+
+	opts.DryRun = false
+	results, err = SyncToBucket(ctx, src, b, "pre", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(actions(t, results), want); diff != "" {
+		t.Errorf("sync: got=-, want=+:\n%s", diff)
+	}
+	if diff := cmp.Diff(objectNames(ctx, t, b), []string{"other", "pre/a.txt", "pre/skip.log", "pre/sub/b.txt", "pre/sub/c.txt", "pre/sub/excluded"}); diff != "" {
+		t.Errorf("objects: got=-, want=+:\n%s", diff)
+	}
+	attrs, err := b.Object("pre/sub/b.txt").Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := attrs.Metadata[MtimeMetadataKey], "1614834367"; got != want {
+		t.Errorf("got mtime metadata %q, want %q", got, want)
+	}
+
+	// Everything is up to date, whether or not modification times are used.
+	for _, compareMtime := range []bool{false, true} {
+		opts.CompareMtime = compareMtime
+		results, err = SyncToBucket(ctx, src, b, "pre/", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path, action := range actions(t, results) {
+			if action != SyncSkip {
+				t.Errorf("CompareMtime=%t: %s: got %v, want skip", compareMtime, path, action)
+			}
+		}
+	}
+
+	dst, err := ioutil.TempDir("", "transfermanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	writeFiles(t, dst, map[string]string{"a.txt": "same", "extra": "deleted"})
+	results, err = SyncFromBucket(ctx, b, "pre", dst, &SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]SyncAction{
+		"a.txt":        SyncSkip,
+		"skip.log":     SyncDownload,
+		"sub/b.txt":    SyncDownload,
+		"sub/c.txt":    SyncDownload,
+		"sub/excluded": SyncDownload,
+		"extra":        SyncDelete,
+	}
+	if diff := cmp.Diff(actions(t, results), want); diff != "" {
+		t.Errorf("sync from bucket: got=-, want=+:\n%s", diff)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dst, "sub", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new!" {
+		t.Errorf("got %q, want %q", got, "new!")
+	}
+	fi, err := os.Stat(filepath.Join(dst, "sub", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("got mtime %v, want %v", fi.ModTime(), mtime)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "extra")); !os.IsNotExist(err) {
+		t.Errorf("extra file was not deleted: %v", err)
+	}
+}
+
+func TestSyncBadExclude(t *testing.T) {
+	ctx := context.Background()
+	b, cleanup := newTestBucket(ctx, t)
+	defer cleanup()
+	if _, err := SyncToBucket(ctx, os.TempDir(), b, "", &SyncOptions{Exclude: []string{"["}}); err == nil {
+		t.Error("got nil error for a bad exclude pattern")
+	}
+}
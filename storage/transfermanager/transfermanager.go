@@ -0,0 +1,166 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transfermanager speeds up transfers of large Cloud Storage objects by
+// splitting them into parts that are transferred concurrently.
+//
+// Download reads an object as concurrent byte ranges, and verifies the CRC32C
+// checksum of the whole object once all ranges have been written. Upload
+// writes parts of its input to temporary objects concurrently, composes them
+// into the destination object and then deletes the temporary objects. This is
+// known as a parallel composite upload.
+//
+// SyncToBucket and SyncFromBucket make a bucket prefix a copy of a local
+// directory, or the reverse, transferring only the files whose contents
+// differ, like gsutil rsync.
+//
+// Objects created by Upload are composite objects, which have no MD5 hash.
+// See https://cloud.google.com/storage/docs/composite-objects for the other
+// implications of composite objects, such as early deletion charges for the
+// temporary objects in some storage classes.
+//
+// This package is EXPERIMENTAL and subject to change or removal without notice.
+package transfermanager
+
+import (
+	"context"
+	"hash/crc32"
+	"sync"
+)
+
+const (
+	// DefaultPartSize is the default size of the parts an object is split into
+	// for transfer.
+	DefaultPartSize = 32 << 20
+
+	// DefaultConcurrency is the default maximum number of parts transferred
+	// at the same time.
+	DefaultConcurrency = 16
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// partBounds splits size bytes into parts of partSize bytes, the last of which
+// may be shorter. It returns the offset and length of each part.
+func partBounds(size, partSize int64) (offsets, lengths []int64) {
+	for off := int64(0); off < size; off += partSize {
+		n := partSize
+		if off+n > size {
+			n = size - off
+		}
+		offsets = append(offsets, off)
+		lengths = append(lengths, n)
+	}
+	return offsets, lengths
+}
+
+// runParallel calls f for each index in [0, n), with at most concurrency calls
+// running at the same time. It stops starting calls once a call fails, cancels
+// the context of the calls in progress, and returns the first error.
+func runParallel(ctx context.Context, n, concurrency int, f func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := f(ctx, i); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// crc32cCombine returns the CRC32C checksum of the concatenation of two byte
+// sequences, given the checksum of each and the length of the second. It is
+// the algorithm of zlib's crc32_combine, which appends len2 zero bits to crc1
+// using a matrix that represents one zero bit in GF(2).
+func crc32cCombine(crc1, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+	var even, odd [32]uint32
+	// The operator for one zero bit.
+	odd[0] = crc32.Castagnoli
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+	gf2MatrixSquare(&even, &odd) // two zero bits
+	gf2MatrixSquare(&odd, &even) // four zero bits
+
+	// Apply len2 zero bytes to crc1. The first square puts the operator for
+	// one zero byte, eight zero bits, in even.
+	for {
+		gf2MatrixSquare(&even, &odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+		gf2MatrixSquare(&odd, &even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat *[32]uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat *[32]uint32) {
+	for n := 0; n < 32; n++ {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"cloud.google.com/go/storage/storagetest"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+)
+
+func TestCRC32CCombine(t *testing.T) {
+	data := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(data)
+	want := crc32.Checksum(data, crc32cTable)
+	for _, split := range []int{0, 1, 7, 4096, 9999, 10000} {
+		crc1 := crc32.Checksum(data[:split], crc32cTable)
+		crc2 := crc32.Checksum(data[split:], crc32cTable)
+		if got := crc32cCombine(crc1, crc2, int64(len(data)-split)); got != want {
+			t.Errorf("split at %d: got %d, want %d", split, got, want)
+		}
+	}
+}
+
+func TestPartBounds(t *testing.T) {
+	offsets, lengths := partBounds(25, 10)
+	if diff := cmp.Diff(offsets, []int64{0, 10, 20}); diff != "" {
+		t.Errorf("offsets: got=-, want=+:\n%s", diff)
+	}
+	if diff := cmp.Diff(lengths, []int64{10, 10, 5}); diff != "" {
+		t.Errorf("lengths: got=-, want=+:\n%s", diff)
+	}
+	if offsets, _ := partBounds(0, 10); len(offsets) != 0 {
+		t.Errorf("got %d parts for an empty object, want 0", len(offsets))
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	var calls, running, maxRunning int32
+	wantErr := errors.New("failed")
+	err := runParallel(context.Background(), 100, 3, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&calls, 1)
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		if i == 10 {
+			return wantErr
+		}
+		return nil
+	})
+	if err != wantErr {
+		t.Errorf("got %v, want %v", err, wantErr)
+	}
+	if calls >= 100 {
+		t.Errorf("got %d calls, want calls to stop after the error", calls)
+	}
+	if maxRunning > 3 {
+		t.Errorf("got %d concurrent calls, want at most 3", maxRunning)
+	}
+}
+
+func newTestBucket(ctx context.Context, t *testing.T) (*storage.BucketHandle, func()) {
+	t.Helper()
+	srv := storagetest.NewServer()
+	client, err := storage.NewClient(ctx, srv.ClientOptions()...)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	b := client.Bucket("bucket")
+	if err := b.Create(ctx, "project", nil); err != nil {
+		t.Fatal(err)
+	}
+	return b, func() {
+		client.Close()
+		srv.Close()
+	}
+}
+
+func objectNames(ctx context.Context, t *testing.T, b *storage.BucketHandle) []string {
+	t.Helper()
+	var names []string
+	it := b.Objects(ctx, nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, attrs.Name)
+	}
+}
+
+// writerAt is an io.WriterAt that writes to a byte slice.
+type writerAt []byte
+
+func (w writerAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(w[off:], p), nil
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	ctx := context.Background()
+	b, cleanup := newTestBucket(ctx, t)
+	defer cleanup()
+
+	data := make([]byte, 41*1000+17)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, test := range []struct {
+		desc     string
+		size     int
+		partSize int64
+	}{
+		{"single part", 1000, 1000},
+		{"one compose", 20 * 1000, 1000},
+		// 42 parts are composed into two intermediate objects first.
+		{"recursive compose", len(data), 1000},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			want := data[:test.size]
+			opts := &UploadOptions{
+				PartSize:    test.partSize,
+				Concurrency: 4,
+				ObjectAttrs: storage.ObjectAttrs{ContentType: "application/x-test"},
+			}
+			attrs, err := Upload(ctx, b, "obj", bytes.NewReader(want), int64(len(want)), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.Name != "obj" || attrs.Size != int64(len(want)) || attrs.ContentType != "application/x-test" {
+				t.Errorf("got attrs %+v", attrs)
+			}
+			if diff := cmp.Diff(objectNames(ctx, t, b), []string{"obj"}); diff != "" {
+				t.Errorf("temporary objects remain: got=-, want=+:\n%s", diff)
+			}
+
+			got := make(writerAt, len(want))
+			attrs, err = Download(ctx, b.Object("obj"), got, &DownloadOptions{PartSize: 999, Concurrency: 4})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.Size != int64(len(want)) {
+				t.Errorf("got size %d, want %d", attrs.Size, len(want))
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("downloaded contents differ from uploaded contents")
+			}
+		})
+	}
+}
+
+func TestUploadPreconditionFailure(t *testing.T) {
+	ctx := context.Background()
+	b, cleanup := newTestBucket(ctx, t)
+	defer cleanup()
+
+	data := bytes.Repeat([]byte("x"), 5000)
+	opts := &UploadOptions{PartSize: 1000, Conditions: &storage.Conditions{DoesNotExist: true}}
+	if _, err := Upload(ctx, b, "obj", bytes.NewReader(data), int64(len(data)), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Upload(ctx, b, "obj", bytes.NewReader(data), int64(len(data)), opts); err == nil {
+		t.Error("got nil error for an existing object, want precondition failure")
+	}
+	if diff := cmp.Diff(objectNames(ctx, t, b), []string{"obj"}); diff != "" {
+		t.Errorf("temporary objects remain: got=-, want=+:\n%s", diff)
+	}
+}
+
+func TestUploadFileAndDownloadFile(t *testing.T) {
+	ctx := context.Background()
+	b, cleanup := newTestBucket(ctx, t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "transfermanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := make([]byte, 12345)
+	rand.New(rand.NewSource(2)).Read(data)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UploadFile(ctx, b, "obj", src, &UploadOptions{PartSize: 1024}); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "dst")
+	if _, err := DownloadFile(ctx, b.Object("obj"), dst, &DownloadOptions{PartSize: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from uploaded file")
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if _, err := DownloadFile(ctx, b.Object("missing"), missing, nil); err != storage.ErrObjectNotExist {
+		t.Errorf("got %v, want ErrObjectNotExist", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("file of failed download exists: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transfermanager
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// maxComposeComponents is the maximum number of source objects in a single
+// compose request.
+const maxComposeComponents = 32
+
+// cleanupTimeout bounds the deletion of temporary objects when the context of
+// the upload is already done.
+const cleanupTimeout = time.Minute
+
+// DefaultTempPrefix is the default prefix of the names of temporary objects
+// created by Upload.
+const DefaultTempPrefix = "transfermanager-tmp/"
+
+// UploadOptions configures Upload and UploadFile.
+type UploadOptions struct {
+	// PartSize is the size in bytes of the parts that are uploaded as
+	// temporary objects. Inputs no larger than PartSize are uploaded directly
+	// to the destination object. If zero, DefaultPartSize is used.
+	PartSize int64
+
+	// Concurrency is the maximum number of parts uploaded, composed or deleted
+	// at the same time. If zero, DefaultConcurrency is used.
+	Concurrency int
+
+	// TempPrefix is the prefix of the names of the temporary objects, which are
+	// created in the bucket of the destination object. A random component is
+	// added to it for each upload. If empty, DefaultTempPrefix is used.
+	TempPrefix string
+
+	// ObjectAttrs are optional attributes to set on the destination object,
+	// as for storage.Writer. Name, Bucket and checksum attributes are ignored.
+	ObjectAttrs storage.ObjectAttrs
+
+	// Conditions are optional preconditions for creating the destination
+	// object, such as DoesNotExist.
+	Conditions *storage.Conditions
+}
+
+func (opts *UploadOptions) partSize() int64 {
+	if opts == nil || opts.PartSize <= 0 {
+		return DefaultPartSize
+	}
+	return opts.PartSize
+}
+
+func (opts *UploadOptions) concurrency() int {
+	if opts == nil || opts.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+	return opts.Concurrency
+}
+
+func (opts *UploadOptions) tempPrefix() string {
+	if opts == nil || opts.TempPrefix == "" {
+		return DefaultTempPrefix
+	}
+	return opts.TempPrefix
+}
+
+// Upload uploads size bytes read from r to the named object in bucket b.
+//
+// Inputs larger than opts.PartSize are split into parts that are uploaded
+// concurrently as temporary objects in b. The parts are then composed into the
+// destination object. Since a compose request takes at most 32 source objects,
+// larger numbers of parts are composed into intermediate temporary objects
+// first. The CRC32C checksum of each part and of the destination object is
+// verified. Temporary objects are deleted before Upload returns, whether or
+// not the upload succeeded.
+//
+// If the destination object was created but a temporary object could not be
+// deleted, Upload returns the attributes of the destination object and an
+// error.
+func Upload(ctx context.Context, b *storage.BucketHandle, object string, r io.ReaderAt, size int64, opts *UploadOptions) (*storage.ObjectAttrs, error) {
+	dst := b.Object(object)
+	if opts != nil && opts.Conditions != nil {
+		dst = dst.If(*opts.Conditions)
+	}
+	if size <= opts.partSize() {
+		attrs, _, err := uploadPart(ctx, dst, io.NewSectionReader(r, 0, size), opts)
+		return attrs, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	u := &uploader{
+		bucket:      b,
+		dst:         dst,
+		opts:        opts,
+		concurrency: opts.concurrency(),
+		tempPrefix:  opts.tempPrefix() + hex.EncodeToString(id) + "/",
+	}
+	attrs, err := u.upload(ctx, r, size)
+	if cerr := u.cleanup(ctx); cerr != nil && err == nil {
+		err = cerr
+	}
+	return attrs, err
+}
+
+// UploadFile uploads the named file to the named object in bucket b. See Upload
+// for details.
+func UploadFile(ctx context.Context, b *storage.BucketHandle, object, name string, opts *UploadOptions) (*storage.ObjectAttrs, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return Upload(ctx, b, object, f, fi.Size(), opts)
+}
+
+// uploader holds the state of a parallel composite upload.
+type uploader struct {
+	bucket      *storage.BucketHandle
+	dst         *storage.ObjectHandle
+	opts        *UploadOptions
+	concurrency int
+	tempPrefix  string
+
+	mu    sync.Mutex
+	temps []string // names of the temporary objects created
+}
+
+func (u *uploader) addTemp(name string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.temps = append(u.temps, name)
+}
+
+func (u *uploader) upload(ctx context.Context, r io.ReaderAt, size int64) (*storage.ObjectAttrs, error) {
+	offsets, lengths := partBounds(size, u.opts.partSize())
+	parts := make([]*storage.ObjectHandle, len(offsets))
+	crcs := make([]uint32, len(offsets))
+	err := runParallel(ctx, len(offsets), u.concurrency, func(ctx context.Context, i int) error {
+		name := fmt.Sprintf("%spart-%06d", u.tempPrefix, i)
+		attrs, crc, err := uploadPart(ctx, u.bucket.Object(name), io.NewSectionReader(r, offsets[i], lengths[i]), nil)
+		if attrs != nil {
+			u.addTemp(name)
+		}
+		if err != nil {
+			return err
+		}
+		parts[i] = u.bucket.Object(name).Generation(attrs.Generation)
+		crcs[i] = crc
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var crc uint32
+	for i := range crcs {
+		crc = crc32cCombine(crc, crcs[i], lengths[i])
+	}
+	attrs, err := u.compose(ctx, parts)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.CRC32C != crc {
+		return attrs, fmt.Errorf("transfermanager: composed %s/%s has CRC32C %d, want %d", attrs.Bucket, attrs.Name, attrs.CRC32C, crc)
+	}
+	return attrs, nil
+}
+
+// compose composes the parts into the destination object. While there are
+// more than maxComposeComponents parts, groups of parts are composed into
+// intermediate temporary objects, which become the parts of the next round.
+func (u *uploader) compose(ctx context.Context, parts []*storage.ObjectHandle) (*storage.ObjectAttrs, error) {
+	for round := 0; len(parts) > maxComposeComponents; round++ {
+		groups := (len(parts) + maxComposeComponents - 1) / maxComposeComponents
+		next := make([]*storage.ObjectHandle, groups)
+		err := runParallel(ctx, groups, u.concurrency, func(ctx context.Context, i int) error {
+			start := i * maxComposeComponents
+			end := start + maxComposeComponents
+			if end > len(parts) {
+				end = len(parts)
+			}
+			if end-start == 1 {
+				next[i] = parts[start]
+				return nil
+			}
+			name := fmt.Sprintf("%scompose-%d-%06d", u.tempPrefix, round, i)
+			attrs, err := u.bucket.Object(name).ComposerFrom(parts[start:end]...).Run(ctx)
+			if err != nil {
+				return err
+			}
+			u.addTemp(name)
+			next[i] = u.bucket.Object(name).Generation(attrs.Generation)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		parts = next
+	}
+	c := u.dst.ComposerFrom(parts...)
+	if u.opts != nil {
+		c.ObjectAttrs = destinationAttrs(u.opts.ObjectAttrs)
+	}
+	return c.Run(ctx)
+}
+
+// cleanup deletes the temporary objects of the upload. It tries to delete all
+// of them, and returns an error naming those that could not be deleted.
+func (u *uploader) cleanup(ctx context.Context) error {
+	if ctx.Err() != nil {
+		// Temporary objects must be deleted even if the upload was cancelled.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+	}
+	u.mu.Lock()
+	temps := u.temps
+	u.mu.Unlock()
+
+	var (
+		mu     sync.Mutex
+		failed []string
+		errs   []error
+	)
+	runParallel(ctx, len(temps), u.concurrency, func(ctx context.Context, i int) error {
+		err := u.bucket.Object(temps[i]).Delete(ctx)
+		if err != nil && err != storage.ErrObjectNotExist {
+			mu.Lock()
+			failed = append(failed, temps[i])
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+		// Keep deleting the other objects.
+		return nil
+	})
+	if len(failed) > 0 {
+		return fmt.Errorf("transfermanager: deleting temporary objects %s: %v", strings.Join(failed, ", "), errs[0])
+	}
+	return nil
+}
+
+// uploadPart writes the contents of r to o, and returns the attributes of the
+// created object and the CRC32C checksum of the contents. If the object was
+// created, its attributes are returned even if the checksum does not match.
+func uploadPart(ctx context.Context, o *storage.ObjectHandle, r io.Reader, opts *UploadOptions) (*storage.ObjectAttrs, uint32, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := o.NewWriter(ctx)
+	if opts != nil {
+		w.ObjectAttrs = destinationAttrs(opts.ObjectAttrs)
+	}
+	w.ObjectAttrs.Name = o.ObjectName()
+	w.ObjectAttrs.Bucket = o.BucketName()
+	crc := crc32.New(crc32cTable)
+	if _, err := io.Copy(w, io.TeeReader(r, crc)); err != nil {
+		// Cancelling the context aborts the upload.
+		cancel()
+		w.Close()
+		return nil, 0, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, 0, err
+	}
+	attrs := w.Attrs()
+	if attrs.CRC32C != crc.Sum32() {
+		return attrs, 0, fmt.Errorf("transfermanager: uploaded %s/%s has CRC32C %d, want %d",
+			attrs.Bucket, attrs.Name, attrs.CRC32C, crc.Sum32())
+	}
+	return attrs, crc.Sum32(), nil
+}
+
+// destinationAttrs returns a copy of attrs without the attributes that
+// identify an object or describe its contents.
+func destinationAttrs(attrs storage.ObjectAttrs) storage.ObjectAttrs {
+	attrs.Name = ""
+	attrs.Bucket = ""
+	attrs.CRC32C = 0
+	attrs.MD5 = nil
+	attrs.Size = 0
+	return attrs
+}
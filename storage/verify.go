@@ -0,0 +1,446 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSignatureMismatch is returned by VerifySignedURL and
+	// VerifySignedPostPolicyV4 when a signature does not match the signed
+	// request.
+	ErrSignatureMismatch = errors.New("storage: signature does not match")
+	// ErrSignatureExpired is returned by VerifySignedURL and
+	// VerifySignedPostPolicyV4 when a signature has expired.
+	ErrSignatureExpired = errors.New("storage: signature has expired")
+)
+
+const (
+	v4RSAAlgorithm  = "GOOG4-RSA-SHA256"
+	v4HMACAlgorithm = "GOOG4-HMAC-SHA256"
+
+	// maxV4Expiry is the longest lifetime of a V4 signature.
+	maxV4Expiry = 7 * 24 * time.Hour
+)
+
+// VerifySignatureOptions provides the keys used to verify signed URLs and
+// POST policies.
+type VerifySignatureOptions struct {
+	// PublicKey returns the PEM-encoded key of the service account with the
+	// given ID. It may be a certificate, a public key or a private key. It
+	// is required to verify GOOG4-RSA-SHA256 and V2 signatures.
+	PublicKey func(googleAccessID string) ([]byte, error)
+
+	// HMACSecret returns the secret of the HMAC key with the given access
+	// ID. It is required to verify GOOG4-HMAC-SHA256 signatures.
+	HMACSecret func(accessID string) (string, error)
+}
+
+// VerifiedSignature describes a valid signed URL or POST policy.
+type VerifiedSignature struct {
+	// Scheme is the signing scheme of the signature.
+	Scheme SigningScheme
+
+	// GoogleAccessID is the ID of the key that made the signature.
+	GoogleAccessID string
+
+	// Expires is the time at which the signature expires.
+	Expires time.Time
+
+	// SignedHeaders lists the lower-case names of the request headers covered
+	// by the signature of a signed URL.
+	SignedHeaders []string
+}
+
+// VerifySignedURL checks that r is a valid request for a signed URL, as
+// created by SignedURL. It verifies the V2 or V4 signature, that the
+// signature has not expired and that the request carries the signed headers.
+//
+// The host of a V4 signed URL is taken from r.Host, or from r.URL.Host if
+// r.Host is empty. V2 signatures can only be verified with the RSA key of
+// a service account.
+func VerifySignedURL(r *http.Request, opts *VerifySignatureOptions) (*VerifiedSignature, error) {
+	q := r.URL.Query()
+	switch {
+	case q.Get("X-Goog-Signature") != "":
+		return verifySignedURLV4(r, q, opts, utcNow())
+	case q.Get("Signature") != "":
+		return verifySignedURLV2(r, q, opts, utcNow())
+	default:
+		return nil, errors.New("storage: request is not signed")
+	}
+}
+
+func verifySignedURLV4(r *http.Request, q url.Values, opts *VerifySignatureOptions, now time.Time) (*VerifiedSignature, error) {
+	algorithm := q.Get("X-Goog-Algorithm")
+	timestamp := q.Get("X-Goog-Date")
+	accessID, scope, err := parseV4Credential(q.Get("X-Goog-Credential"), timestamp)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := hex.DecodeString(q.Get("X-Goog-Signature"))
+	if err != nil {
+		return nil, fmt.Errorf("storage: malformed X-Goog-Signature: %v", err)
+	}
+	signedAt, err := time.Parse(iso8601, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("storage: malformed X-Goog-Date %q", timestamp)
+	}
+	seconds, err := strconv.ParseInt(q.Get("X-Goog-Expires"), 10, 64)
+	if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxV4Expiry {
+		return nil, fmt.Errorf("storage: invalid X-Goog-Expires %q", q.Get("X-Goog-Expires"))
+	}
+	expires := signedAt.Add(time.Duration(seconds) * time.Second)
+	if !now.Before(expires) {
+		return nil, ErrSignatureExpired
+	}
+
+	signedHeaders := q.Get("X-Goog-SignedHeaders")
+	headerNames := strings.Split(signedHeaders, ";")
+	var headers []string
+	hasHost := false
+	for _, name := range headerNames {
+		var values []string
+		if name == "host" {
+			hasHost = true
+			host := r.Host
+			if host == "" {
+				host = r.URL.Host
+			}
+			values = []string{host}
+		} else {
+			values = r.Header[textproto.CanonicalMIMEHeaderKey(name)]
+		}
+		if name != strings.ToLower(name) {
+			return nil, fmt.Errorf("storage: signed header %q is not lower case", name)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("storage: signed header %q is missing from the request", name)
+		}
+		headers = append(headers, name+":"+strings.Join(values, ","))
+	}
+	if !hasHost {
+		return nil, errors.New("storage: X-Goog-SignedHeaders must include host")
+	}
+
+	query := url.Values{}
+	for k, v := range q {
+		if k != "X-Goog-Signature" {
+			query[k] = v
+		}
+	}
+	canonicalRequest := v4CanonicalRequest(r.Method, pathEncodeV4(r.URL.Path), query, headers, signedHeaders)
+	stringToSign := v4StringToSign(algorithm, timestamp, scope, canonicalRequest)
+	if err := opts.verifyV4(algorithm, accessID, scope, stringToSign, signature); err != nil {
+		return nil, err
+	}
+	return &VerifiedSignature{
+		Scheme:         SigningSchemeV4,
+		GoogleAccessID: accessID,
+		Expires:        expires,
+		SignedHeaders:  headerNames,
+	}, nil
+}
+
+func verifySignedURLV2(r *http.Request, q url.Values, opts *VerifySignatureOptions, now time.Time) (*VerifiedSignature, error) {
+	accessID := q.Get("GoogleAccessId")
+	if accessID == "" {
+		return nil, errors.New("storage: missing GoogleAccessId")
+	}
+	expiresUnix, err := strconv.ParseInt(q.Get("Expires"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("storage: malformed Expires %q", q.Get("Expires"))
+	}
+	signature, err := base64.StdEncoding.DecodeString(q.Get("Signature"))
+	if err != nil {
+		return nil, fmt.Errorf("storage: malformed Signature: %v", err)
+	}
+	expires := time.Unix(expiresUnix, 0)
+	if !now.Before(expires) {
+		return nil, ErrSignatureExpired
+	}
+
+	var hdrs []string
+	for k, v := range r.Header {
+		hdrs = append(hdrs, k+":"+strings.Join(v, ","))
+	}
+	headers := v2SanitizeHeaders(hdrs)
+	resource := (&url.URL{Path: r.URL.Path}).String()
+	stringToSign := v2StringToSign(r.Method, r.Header.Get("Content-MD5"), r.Header.Get("Content-Type"), expiresUnix, headers, resource)
+	if err := opts.verifyRSA(accessID, stringToSign, signature); err != nil {
+		return nil, err
+	}
+	return &VerifiedSignature{
+		Scheme:         SigningSchemeV2,
+		GoogleAccessID: accessID,
+		Expires:        expires,
+		SignedHeaders:  extractHeaderNames(headers),
+	}, nil
+}
+
+// VerifySignedPostPolicyV4 checks that the form fields of a POST object
+// request to bucket satisfy a policy created by GenerateSignedPostPolicyV4.
+// It verifies the signature, that the policy has not expired, that every
+// policy condition holds and that every form field is covered by a
+// condition. Field names are matched case-insensitively.
+//
+// The fields are those that precede the file in the multipart form, and
+// must not include the file itself. size is the length of the uploaded
+// file; content-length-range conditions are not checked if it is negative.
+func VerifySignedPostPolicyV4(bucket string, fields map[string]string, size int64, opts *VerifySignatureOptions) (*VerifiedSignature, error) {
+	form := map[string]string{}
+	for k, v := range fields {
+		form[strings.ToLower(k)] = v
+	}
+	algorithm := form["x-goog-algorithm"]
+	accessID, scope, err := parseV4Credential(form["x-goog-credential"], form["x-goog-date"])
+	if err != nil {
+		return nil, err
+	}
+	signature, err := hex.DecodeString(form["x-goog-signature"])
+	if err != nil {
+		return nil, fmt.Errorf("storage: malformed x-goog-signature: %v", err)
+	}
+	b64Policy := form["policy"]
+	if err := opts.verifyV4(algorithm, accessID, scope, []byte(b64Policy), signature); err != nil {
+		return nil, err
+	}
+
+	policyJSON, err := base64.StdEncoding.DecodeString(b64Policy)
+	if err != nil {
+		return nil, fmt.Errorf("storage: malformed policy: %v", err)
+	}
+	var policy struct {
+		Conditions []json.RawMessage `json:"conditions"`
+		Expiration string            `json:"expiration"`
+	}
+	if err := json.Unmarshal(policyJSON, &policy); err != nil {
+		return nil, fmt.Errorf("storage: malformed policy: %v", err)
+	}
+	expires, err := time.Parse(time.RFC3339, policy.Expiration)
+	if err != nil {
+		return nil, fmt.Errorf("storage: malformed policy expiration %q", policy.Expiration)
+	}
+	if !utcNow().Before(expires) {
+		return nil, ErrSignatureExpired
+	}
+
+	form["bucket"] = bucket
+	covered := map[string]bool{"bucket": true}
+	for _, cond := range policy.Conditions {
+		if err := checkPolicyCondition(cond, form, size, covered); err != nil {
+			return nil, err
+		}
+	}
+	for name := range form {
+		switch {
+		case covered[name], name == "policy", name == "x-goog-signature", name == "file", strings.HasPrefix(name, "x-ignore-"):
+		default:
+			return nil, fmt.Errorf("storage: form field %q is not covered by the policy", name)
+		}
+	}
+	return &VerifiedSignature{
+		Scheme:         SigningSchemeV4,
+		GoogleAccessID: accessID,
+		Expires:        expires,
+	}, nil
+}
+
+// checkPolicyCondition checks that the form satisfies a single POST policy
+// condition, and records the fields it covers.
+func checkPolicyCondition(cond json.RawMessage, form map[string]string, size int64, covered map[string]bool) error {
+	var exact map[string]string
+	if err := json.Unmarshal(cond, &exact); err == nil {
+		for name, want := range exact {
+			name = policyFieldName(name)
+			if got := form[name]; got != want {
+				return fmt.Errorf("storage: form field %q is %q, policy requires %q", name, got, want)
+			}
+			covered[name] = true
+		}
+		return nil
+	}
+
+	var args []interface{}
+	dec := json.NewDecoder(bytes.NewReader(cond))
+	dec.UseNumber()
+	if err := dec.Decode(&args); err != nil || len(args) != 3 {
+		return fmt.Errorf("storage: malformed policy condition %s", cond)
+	}
+	op, _ := args[0].(string)
+	op = strings.ToLower(op)
+	switch op {
+	case "eq", "starts-with":
+		name, ok1 := args[1].(string)
+		value, ok2 := args[2].(string)
+		if !ok1 || !ok2 {
+			return fmt.Errorf("storage: malformed policy condition %s", cond)
+		}
+		name = policyFieldName(name)
+		got := form[name]
+		if op == "eq" && got != value {
+			return fmt.Errorf("storage: form field %q is %q, policy requires %q", name, got, value)
+		}
+		if op != "eq" && !strings.HasPrefix(got, value) {
+			return fmt.Errorf("storage: form field %q is %q, policy requires prefix %q", name, got, value)
+		}
+		covered[name] = true
+	case "content-length-range":
+		lo, ok1 := args[1].(json.Number)
+		hi, ok2 := args[2].(json.Number)
+		if !ok1 || !ok2 {
+			return fmt.Errorf("storage: malformed policy condition %s", cond)
+		}
+		min, err1 := lo.Int64()
+		max, err2 := hi.Int64()
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("storage: malformed policy condition %s", cond)
+		}
+		if size >= 0 && (size < min || size > max) {
+			return fmt.Errorf("storage: content length %d is outside the policy range [%d, %d]", size, min, max)
+		}
+	default:
+		return fmt.Errorf("storage: unsupported policy condition %s", cond)
+	}
+	return nil
+}
+
+// policyFieldName returns the form field name of a policy condition
+// variable such as "$key".
+func policyFieldName(name string) string {
+	return strings.ToLower(strings.TrimPrefix(name, "$"))
+}
+
+// parseV4Credential splits a V4 credential of the form
+// "ACCESS_ID/DATE/LOCATION/storage/goog4_request" into the access ID and
+// the credential scope, and checks that the scope date matches timestamp.
+func parseV4Credential(credential, timestamp string) (accessID, scope string, err error) {
+	parts := strings.Split(credential, "/")
+	n := len(parts)
+	if n < 5 || parts[n-2] != "storage" || parts[n-1] != "goog4_request" {
+		return "", "", fmt.Errorf("storage: malformed credential %q", credential)
+	}
+	if !strings.HasPrefix(timestamp, parts[n-4]) || len(parts[n-4]) != len(yearMonthDay) {
+		return "", "", fmt.Errorf("storage: credential date %q does not match %q", parts[n-4], timestamp)
+	}
+	return strings.Join(parts[:n-4], "/"), strings.Join(parts[n-4:], "/"), nil
+}
+
+// verifyV4 checks a V4 signature of stringToSign made with algorithm.
+func (opts *VerifySignatureOptions) verifyV4(algorithm, accessID, scope string, stringToSign, signature []byte) error {
+	switch algorithm {
+	case v4RSAAlgorithm:
+		return opts.verifyRSA(accessID, stringToSign, signature)
+	case v4HMACAlgorithm:
+		if opts.HMACSecret == nil {
+			return errors.New("storage: VerifySignatureOptions.HMACSecret is required to verify HMAC signatures")
+		}
+		secret, err := opts.HMACSecret(accessID)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal(v4HMACSignature(secret, scope, stringToSign), signature) {
+			return ErrSignatureMismatch
+		}
+		return nil
+	default:
+		return fmt.Errorf("storage: unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// verifyRSA checks an RSA-SHA256 signature of b made by the service account
+// with the given ID.
+func (opts *VerifySignatureOptions) verifyRSA(accessID string, b, signature []byte) error {
+	if opts.PublicKey == nil {
+		return errors.New("storage: VerifySignatureOptions.PublicKey is required to verify RSA signatures")
+	}
+	pemKey, err := opts.PublicKey(accessID)
+	if err != nil {
+		return err
+	}
+	key, err := parsePublicKey(pemKey)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature); err != nil {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+// v4HMACSignature returns the GOOG4-HMAC-SHA256 signature of stringToSign,
+// deriving the signing key from secret and the credential scope.
+func v4HMACSignature(secret, scope string, stringToSign []byte) []byte {
+	key := []byte("GOOG4" + secret)
+	// The scope is DATE/LOCATION/storage/goog4_request, each part of which
+	// is mixed into the key in turn.
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, []byte(part))
+	}
+	return hmacSHA256(key, stringToSign)
+}
+
+func hmacSHA256(key, b []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
+
+// parsePublicKey parses a PEM or DER encoded certificate, public key or
+// private key, and returns its RSA public key.
+func parsePublicKey(key []byte) (*rsa.PublicKey, error) {
+	if block, _ := pem.Decode(key); block != nil {
+		key = block.Bytes
+	}
+	if cert, err := x509.ParseCertificate(key); err == nil {
+		if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return pub, nil
+		}
+		return nil, errors.New("storage: certificate does not hold an RSA public key")
+	}
+	if pub, err := x509.ParsePKIXPublicKey(key); err == nil {
+		if pub, ok := pub.(*rsa.PublicKey); ok {
+			return pub, nil
+		}
+		return nil, errors.New("storage: public key is not an RSA key")
+	}
+	if pub, err := x509.ParsePKCS1PublicKey(key); err == nil {
+		return pub, nil
+	}
+	priv, err := parseKey(key)
+	if err != nil {
+		return nil, errors.New("storage: public key is invalid")
+	}
+	return &priv.PublicKey, nil
+}
@@ -0,0 +1,418 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testHMACSecret = "bGoa+V7g/yqDXvKRqq+JTFn4uQZbPiQJo4pf9RzJ"
+
+// setUTCNow makes utcNow return now, and returns a function that restores
+// it.
+func setUTCNow(now time.Time) func() {
+	old := utcNow
+	utcNow = func() time.Time { return now }
+	return func() { utcNow = old }
+}
+
+func testVerifyOptions() *VerifySignatureOptions {
+	return &VerifySignatureOptions{
+		PublicKey: func(id string) ([]byte, error) {
+			if id != "xxx@clientid" {
+				return nil, errors.New("unknown service account")
+			}
+			return dummyKey("rsa"), nil
+		},
+		HMACSecret: func(id string) (string, error) {
+			if id != "GOOG1EXAMPLE" {
+				return "", errors.New("unknown HMAC key")
+			}
+			return testHMACSecret, nil
+		},
+	}
+}
+
+// hmacSignedURL returns a GOOG4-HMAC-SHA256 signed URL for a GET request.
+func hmacSignedURL(host, path, secret string, now time.Time) string {
+	scope := v4CredentialScope(now)
+	q := url.Values{
+		"X-Goog-Algorithm":     {v4HMACAlgorithm},
+		"X-Goog-Credential":    {"GOOG1EXAMPLE/" + scope},
+		"X-Goog-Date":          {now.Format(iso8601)},
+		"X-Goog-Expires":       {"3600"},
+		"X-Goog-SignedHeaders": {"host"},
+	}
+	canonicalRequest := v4CanonicalRequest("GET", pathEncodeV4(path), q, []string{"host:" + host}, "host")
+	sig := v4HMACSignature(secret, scope, v4StringToSign(v4HMACAlgorithm, now.Format(iso8601), scope, canonicalRequest))
+	q.Set("X-Goog-Signature", hex.EncodeToString(sig))
+	return (&url.URL{Scheme: "https", Host: host, Path: path, RawQuery: q.Encode()}).String()
+}
+
+func TestVerifySignedURL(t *testing.T) {
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	defer setUTCNow(now)()
+
+	signedURL := func(scheme SigningScheme) string {
+		u, err := SignedURL("bucket-name", "dir/object name", &SignedURLOptions{
+			GoogleAccessID:  "xxx@clientid",
+			PrivateKey:      dummyKey("rsa"),
+			Method:          "PUT",
+			Expires:         now.Add(time.Hour),
+			Scheme:          scheme,
+			ContentType:     "text/plain",
+			Headers:         []string{"x-goog-meta-color:  red ", "x-goog-acl:private"},
+			QueryParameters: url.Values{"userProject": {"p"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	request := func(method, u string, headers map[string]string) *http.Request {
+		r := httptest.NewRequest(method, u, nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+	headers := map[string]string{
+		"Content-Type":      "text/plain",
+		"X-Goog-Meta-Color": "red",
+		"X-Goog-Acl":        "private",
+	}
+	v4 := signedURL(SigningSchemeV4)
+	v2 := signedURL(SigningSchemeV2)
+	hmacURL := hmacSignedURL("storage.googleapis.com", "/bucket-name/obj", testHMACSecret, now)
+
+	for _, test := range []struct {
+		desc    string
+		r       *http.Request
+		now     time.Time
+		want    *VerifiedSignature
+		wantErr error
+	}{
+		{
+			desc: "V4",
+			r:    request("PUT", v4, headers),
+			want: &VerifiedSignature{
+				Scheme:         SigningSchemeV4,
+				GoogleAccessID: "xxx@clientid",
+				Expires:        now.Add(time.Hour),
+				SignedHeaders:  []string{"content-type", "host", "x-goog-acl", "x-goog-meta-color"},
+			},
+		},
+		{
+			desc: "V4 with unsigned headers",
+			r: request("PUT", v4, map[string]string{
+				"Content-Type":      "text/plain",
+				"X-Goog-Meta-Color": "red",
+				"X-Goog-Acl":        "private",
+				"X-Goog-Meta-Other": "unsigned",
+			}),
+			want: &VerifiedSignature{
+				Scheme:         SigningSchemeV4,
+				GoogleAccessID: "xxx@clientid",
+				Expires:        now.Add(time.Hour),
+				SignedHeaders:  []string{"content-type", "host", "x-goog-acl", "x-goog-meta-color"},
+			},
+		},
+		{
+			desc: "V4 HMAC",
+			r:    request("GET", hmacURL, nil),
+			want: &VerifiedSignature{
+				Scheme:         SigningSchemeV4,
+				GoogleAccessID: "GOOG1EXAMPLE",
+				Expires:        now.Add(time.Hour),
+				SignedHeaders:  []string{"host"},
+			},
+		},
+		{
+			desc: "V2",
+			r:    request("PUT", v2, headers),
+			want: &VerifiedSignature{
+				Scheme:         SigningSchemeV2,
+				GoogleAccessID: "xxx@clientid",
+				Expires:        now.Add(time.Hour),
+				SignedHeaders:  []string{"x-goog-acl", "x-goog-meta-color"},
+			},
+		},
+		{
+			desc:    "V4 wrong method",
+			r:       request("GET", v4, headers),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			desc: "V4 wrong header value",
+			r: request("PUT", v4, map[string]string{
+				"Content-Type":      "text/plain",
+				"X-Goog-Meta-Color": "blue",
+				"X-Goog-Acl":        "private",
+			}),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			desc:    "V4 wrong object",
+			r:       request("PUT", strings.Replace(v4, "object%20name", "other", 1), headers),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			desc:    "V4 changed query parameter",
+			r:       request("PUT", strings.Replace(v4, "userProject=p", "userProject=q", 1), headers),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			desc:    "V4 expired",
+			r:       request("PUT", v4, headers),
+			now:     now.Add(time.Hour),
+			wantErr: ErrSignatureExpired,
+		},
+		{
+			desc:    "V4 HMAC wrong secret",
+			r:       request("GET", hmacSignedURL("storage.googleapis.com", "/bucket-name/obj", "wrong", now), nil),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			desc:    "V4 HMAC wrong host",
+			r:       request("GET", strings.Replace(hmacURL, "storage.googleapis.com", "example.com", 1), nil),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			desc:    "V2 wrong content type",
+			r:       request("PUT", v2, map[string]string{"Content-Type": "text/html", "X-Goog-Meta-Color": "red", "X-Goog-Acl": "private"}),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			desc:    "V2 extra header",
+			r:       request("PUT", v2, map[string]string{"Content-Type": "text/plain", "X-Goog-Meta-Color": "red", "X-Goog-Acl": "private", "X-Goog-Meta-Other": "x"}),
+			wantErr: ErrSignatureMismatch,
+		},
+		{
+			desc:    "V2 expired",
+			r:       request("PUT", v2, headers),
+			now:     now.Add(2 * time.Hour),
+			wantErr: ErrSignatureExpired,
+		},
+	} {
+		if !test.now.IsZero() {
+			utcNow = func() time.Time { return test.now }
+		}
+		got, err := VerifySignedURL(test.r, testVerifyOptions())
+		utcNow = func() time.Time { return now }
+		if err != test.wantErr {
+			t.Errorf("%s: got error %v, want %v", test.desc, err, test.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%s: got=-, want=+:\n%s", test.desc, diff)
+		}
+	}
+}
+
+func TestVerifySignedURLErrors(t *testing.T) {
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	defer setUTCNow(now)()
+	u, err := SignedURL("bucket-name", "object", &SignedURLOptions{
+		GoogleAccessID: "xxx@clientid",
+		PrivateKey:     dummyKey("rsa"),
+		Method:         "GET",
+		Expires:        now.Add(time.Hour),
+		Scheme:         SigningSchemeV4,
+		Headers:        []string{"x-goog-meta-color:red"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		desc, url string
+		opts      *VerifySignatureOptions
+		wantErr   string
+	}{
+		{"unsigned", "https://storage.googleapis.com/bucket-name/object", testVerifyOptions(), "not signed"},
+		{"missing header", u, testVerifyOptions(), `signed header "x-goog-meta-color" is missing`},
+		{"no public key", u, &VerifySignatureOptions{}, "PublicKey is required"},
+		{"unknown account", strings.Replace(u, "xxx%40clientid", "yyy%40clientid", 1), testVerifyOptions(), "unknown service account"},
+		{"bad credential", strings.Replace(u, "%2Fgoog4_request", "", 1), testVerifyOptions(), "malformed credential"},
+		{"long expiry", strings.Replace(u, "X-Goog-Expires=3600", "X-Goog-Expires=604801", 1), testVerifyOptions(), "invalid X-Goog-Expires"},
+		{"unknown algorithm", strings.Replace(u, "GOOG4-RSA-SHA256", "GOOG4-RSA-SHA512", 1), testVerifyOptions(), "unsupported signing algorithm"},
+	} {
+		r := httptest.NewRequest("GET", test.url, nil)
+		if test.desc != "missing header" {
+			r.Header.Set("X-Goog-Meta-Color", "red")
+		}
+		_, err := VerifySignedURL(r, test.opts)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: got error %v, want it to contain %q", test.desc, err, test.wantErr)
+		}
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	priv, err := parseKey(dummyKey("pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: priv.N, NotAfter: time.Now().Add(time.Hour)}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		desc string
+		key  []byte
+	}{
+		{"private key", dummyKey("pem")},
+		{"PKIX", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix})},
+		{"PKCS1", pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&priv.PublicKey)})},
+		{"certificate", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})},
+		{"DER certificate", cert},
+	} {
+		got, err := parsePublicKey(test.key)
+		if err != nil {
+			t.Errorf("%s: %v", test.desc, err)
+			continue
+		}
+		if got.N.Cmp(priv.N) != 0 || got.E != priv.E {
+			t.Errorf("%s: got a different public key", test.desc)
+		}
+	}
+	if _, err := parsePublicKey([]byte("garbage")); err == nil {
+		t.Error("got nil error for an invalid key")
+	}
+}
+
+func TestVerifySignedPostPolicyV4(t *testing.T) {
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	defer setUTCNow(now)()
+	pp, err := GenerateSignedPostPolicyV4("bucket-name", "uploads/photo.jpg", &PostPolicyV4Options{
+		GoogleAccessID: "xxx@clientid",
+		PrivateKey:     dummyKey("rsa"),
+		Expires:        now.Add(time.Hour),
+		Fields: &PolicyV4Fields{
+			ContentType:         "image/jpeg",
+			StatusCodeOnSuccess: 201,
+			Metadata:            map[string]string{"x-goog-meta-owner": "me"},
+		},
+		Conditions: []PostPolicyV4Condition{
+			ConditionStartsWith("$key", "uploads/"),
+			ConditionContentLengthRange(1, 100),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := func(changes map[string]string) map[string]string {
+		m := map[string]string{}
+		for k, v := range pp.Fields {
+			m[k] = v
+		}
+		for k, v := range changes {
+			if v == "" {
+				delete(m, k)
+			} else {
+				m[k] = v
+			}
+		}
+		return m
+	}
+	want := &VerifiedSignature{Scheme: SigningSchemeV4, GoogleAccessID: "xxx@clientid", Expires: now.Add(time.Hour)}
+
+	for _, test := range []struct {
+		desc    string
+		bucket  string
+		fields  map[string]string
+		size    int64
+		now     time.Time
+		wantErr string
+	}{
+		{desc: "valid", fields: fields(nil), size: 10},
+		{desc: "unknown size", fields: fields(nil), size: -1},
+		{desc: "case-insensitive names", fields: fields(map[string]string{"content-type": "", "Content-Type": "image/jpeg"}), size: 10},
+		{desc: "ignored field", fields: fields(map[string]string{"x-ignore-tracking": "1"}), size: 10},
+		{desc: "wrong bucket", bucket: "other", fields: fields(nil), size: 10, wantErr: `form field "bucket" is "other"`},
+		{desc: "wrong content type", fields: fields(map[string]string{"content-type": "text/html"}), size: 10, wantErr: `form field "content-type" is "text/html"`},
+		{desc: "missing metadata", fields: fields(map[string]string{"x-goog-meta-owner": ""}), size: 10, wantErr: `form field "x-goog-meta-owner" is ""`},
+		{desc: "too large", fields: fields(nil), size: 101, wantErr: "outside the policy range"},
+		{desc: "empty", fields: fields(nil), size: 0, wantErr: "outside the policy range"},
+		{desc: "uncovered field", fields: fields(map[string]string{"acl": "public-read"}), size: 10, wantErr: `form field "acl" is not covered`},
+		{desc: "tampered policy", fields: fields(map[string]string{"policy": pp.Fields["policy"] + "AAAA"}), size: 10, wantErr: ErrSignatureMismatch.Error()},
+		{desc: "expired", fields: fields(nil), size: 10, now: now.Add(time.Hour), wantErr: ErrSignatureExpired.Error()},
+	} {
+		if !test.now.IsZero() {
+			utcNow = func() time.Time { return test.now }
+		}
+		bucket := test.bucket
+		if bucket == "" {
+			bucket = "bucket-name"
+		}
+		got, err := VerifySignedPostPolicyV4(bucket, test.fields, test.size, testVerifyOptions())
+		utcNow = func() time.Time { return now }
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: got error %v, want it to contain %q", test.desc, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.desc, err)
+			continue
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("%s: got=-, want=+:\n%s", test.desc, diff)
+		}
+	}
+}
+
+func TestCheckPolicyCondition(t *testing.T) {
+	form := map[string]string{"key": "uploads/a", "content-type": "image/png"}
+	for _, test := range []struct {
+		cond    string
+		size    int64
+		wantErr bool
+	}{
+		{`{"key": "uploads/a"}`, 1, false},
+		{`{"key": "uploads/b"}`, 1, true},
+		{`["eq", "$Content-Type", "image/png"]`, 1, false},
+		{`["eq", "$content-type", "image/jpeg"]`, 1, true},
+		{`["starts-with", "$key", ""]`, 1, false},
+		{`["starts-with", "$key", "downloads/"]`, 1, true},
+		{`["content-length-range", 0, 5]`, 5, false},
+		{`["content-length-range", 0, 5]`, 6, true},
+		{`["content-length-range", "0", 5]`, 1, true},
+		{`["between", "$key", "a"]`, 1, true},
+		{`["eq", "$key"]`, 1, true},
+	} {
+		err := checkPolicyCondition([]byte(test.cond), form, test.size, map[string]bool{})
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s with size %d: got error %v, want error %t", test.cond, test.size, err, test.wantErr)
+		}
+	}
+}
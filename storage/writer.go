@@ -15,11 +15,14 @@
 package storage
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"net/http"
 	"sync"
 	"unicode/utf8"
 
@@ -60,6 +63,14 @@ type Writer struct {
 	// from retrying in case of a transient error from the server, since a buffer
 	// is required in order to retry the failed request.
 	//
+	// Uploads are retried by the underlying transport and do not use the retry
+	// configuration set with Client.SetRetry, BucketHandle.Retryer or
+	// ObjectHandle.Retryer, even if it only changes the backoff, except for
+	// uploads with a SessionFunc and uploads resumed with
+	// ObjectHandle.ResumeWriter. Those always use a resumable upload, in chunks
+	// of ChunkSize or of the default size if ChunkSize is zero, whose requests
+	// are retried unless the retry policy is RetryNever.
+	//
 	// ChunkSize must be set before the first Write call.
 	ChunkSize int
 
@@ -73,12 +84,51 @@ type Writer struct {
 	// ProgressFunc should return quickly without blocking.
 	ProgressFunc func(int64)
 
+	// SessionFunc, if not nil, makes the Writer start a resumable upload
+	// session, and is called with the identifier of the session before any
+	// data is sent. The identifier is the session URI for the JSON API, and
+	// the upload ID for gRPC. It can be saved and passed to
+	// ObjectHandle.ResumeWriter to continue the upload if the Writer fails,
+	// even in another process.
+	//
+	// SessionFunc must be set before the first Write call.
+	SessionFunc func(session string)
+
+	// GzipContent, if true, makes the Writer compress the data written to it
+	// with gzip and set ContentEncoding to "gzip", so that the object is
+	// stored compressed and served with decompressive transcoding.
+	// ContentType should describe the uncompressed data; if it is empty, it
+	// is detected from the data passed to the first Write call. ProgressFunc
+	// reports the number of compressed bytes sent.
+	//
+	// SendCRC32C and MD5 cannot be used with GzipContent, since checksums
+	// apply to the stored, compressed data. Instead, the CRC32C of the
+	// compressed data is computed as it is written and checked against the
+	// object's CRC32C when the upload completes. GzipContent cannot be used
+	// with a Writer returned by ObjectHandle.ResumeWriter.
+	//
+	// GzipContent must be set before the first Write call.
+	GzipContent bool
+
 	ctx context.Context
 	o   *ObjectHandle
 
 	opened bool
 	pw     *io.PipeWriter
 
+	// gz compresses data written to pw when GzipContent is set, and crc is
+	// the CRC32C of the compressed data.
+	gz  *gzip.Writer
+	crc *crcWriter
+
+	// session is the resumable upload session continued by the Writer, and
+	// offset is the number of bytes it had persisted. completed is the object
+	// created by the session if it had already completed. They are set by
+	// ObjectHandle.ResumeWriter.
+	session   uploadSession
+	offset    int64
+	completed *ObjectAttrs
+
 	donec chan struct{} // closed after err and obj are set.
 	obj   *ObjectAttrs
 
@@ -87,6 +137,18 @@ type Writer struct {
 }
 
 func (w *Writer) open() error {
+	if w.GzipContent {
+		if w.session != nil {
+			return errors.New("storage: GzipContent cannot be used to resume an upload")
+		}
+		if w.SendCRC32C || w.MD5 != nil {
+			return errors.New("storage: SendCRC32C and MD5 cannot be used with GzipContent")
+		}
+		if e := w.ContentEncoding; e != "" && e != "gzip" {
+			return fmt.Errorf("storage: GzipContent cannot be used with ContentEncoding %q", e)
+		}
+		w.ContentEncoding = "gzip"
+	}
 	attrs := w.ObjectAttrs
 	// Check the developer didn't change the object Name (this is unfortunate, but
 	// we don't want to store an object under the wrong name).
@@ -105,6 +167,10 @@ func (w *Writer) open() error {
 	pr, pw := io.Pipe()
 	w.pw = pw
 	w.opened = true
+	if w.GzipContent {
+		w.crc = &crcWriter{w: pw}
+		w.gz = gzip.NewWriter(w.crc)
+	}
 
 	go w.monitorCancel()
 
@@ -115,6 +181,22 @@ func (w *Writer) open() error {
 		mediaOpts = append(mediaOpts, googleapi.ContentType(c))
 	}
 
+	if w.SessionFunc != nil || w.session != nil {
+		go func() {
+			defer close(w.donec)
+			obj, err := w.uploadResumable(pr)
+			if err != nil {
+				w.mu.Lock()
+				w.err = err
+				w.mu.Unlock()
+				pr.CloseWithError(err)
+				return
+			}
+			w.obj = obj
+		}()
+		return nil
+	}
+
 	go func() {
 		defer close(w.donec)
 
@@ -190,11 +272,18 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 		return 0, werr
 	}
 	if !w.opened {
+		if w.GzipContent && w.ContentType == "" {
+			w.ContentType = http.DetectContentType(p)
+		}
 		if err := w.open(); err != nil {
 			return 0, err
 		}
 	}
-	n, err = w.pw.Write(p)
+	if w.gz != nil {
+		n, err = w.gz.Write(p)
+	} else {
+		n, err = w.pw.Write(p)
+	}
 	if err != nil {
 		w.mu.Lock()
 		werr := w.err
@@ -219,6 +308,12 @@ func (w *Writer) Close() error {
 		}
 	}
 
+	if w.gz != nil {
+		// Flush the compressed data. If this fails, the upload has failed
+		// and its error is returned below.
+		w.gz.Close()
+	}
+
 	// Closing either the read or write causes the entire pipe to close.
 	if err := w.pw.Close(); err != nil {
 		return err
@@ -227,9 +322,24 @@ func (w *Writer) Close() error {
 	<-w.donec
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	if w.err == nil && w.crc != nil && w.obj.CRC32C != w.crc.crc {
+		w.err = fmt.Errorf("storage: object %q has CRC32C %d, but the compressed data written has %d", w.o.object, w.obj.CRC32C, w.crc.crc)
+	}
 	return w.err
 }
 
+// crcWriter computes the CRC32C of the data written through it.
+type crcWriter struct {
+	w   io.Writer
+	crc uint32
+}
+
+func (c *crcWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.crc = crc32.Update(c.crc, crc32cTable, p[:n])
+	return n, err
+}
+
 // monitorCancel is intended to be used as a background goroutine. It monitors the
 // context, and when it observes that the context has been canceled, it manually
 // closes things that do not take a context.